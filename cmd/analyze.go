@@ -0,0 +1,67 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var (
+	analyzeCmdOpts struct {
+		top  int
+		json bool
+	}
+
+	analyzeCmd = &cobra.Command{
+		Use:   "analyze",
+		Short: "Report what uses the storage of the datastore",
+		Long: `
+Report the number of keys, the number of revisions retained and the size of
+their values for each top-level prefix of the keys, such as /registry/pods/,
+along with the keys with the largest values, to track down what bloats the
+datastore. The statistics are read directly from the database through the
+node running on this host.
+
+		k8s-dqlite analyze --storage-dir [dir with the dqlite datastore] --top 20
+
+`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			analysis, err := controlClient().Analyze(cmd.Context(), analyzeCmdOpts.top)
+			if err != nil {
+				logrus.WithError(err).Fatal("Failed to analyze datastore")
+			}
+			if analyzeCmdOpts.json {
+				encoder := json.NewEncoder(os.Stdout)
+				encoder.SetIndent("", "  ")
+				if err := encoder.Encode(analysis); err != nil {
+					logrus.WithError(err).Fatal("Failed to print analysis")
+				}
+				return
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "PREFIX\tKEYS\tREVISIONS\tBYTES")
+			for _, p := range analysis.Prefixes {
+				fmt.Fprintf(w, "%s\t%d\t%d\t%d\n", p.Prefix, p.Keys, p.Rows, p.Bytes)
+			}
+			fmt.Fprintln(w)
+			fmt.Fprintln(w, "KEY\tSIZE\tREVISIONS")
+			for _, key := range analysis.Largest {
+				fmt.Fprintf(w, "%s\t%d\t%d\n", key.Key, key.Size, key.Revisions)
+			}
+			w.Flush()
+		},
+	}
+)
+
+func init() {
+	addControlFlags(analyzeCmd)
+	analyzeCmd.Flags().IntVar(&analyzeCmdOpts.top, "top", 10, "number of keys with the largest values reported")
+	analyzeCmd.Flags().BoolVar(&analyzeCmdOpts.json, "json", false, "print the report as JSON")
+	rootCmd.AddCommand(analyzeCmd)
+}
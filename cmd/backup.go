@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/canonical/k8s-dqlite/pkg/snapshot"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var (
+	backupCmdOpts struct {
+		output string
+	}
+
+	backupCmd = &cobra.Command{
+		Use:   "backup",
+		Short: "Back up the datastore of a running cluster",
+		Long: `
+Write a backup archive of the datastore, taken through the node running on
+this host while the cluster keeps serving requests. The archive is a gzipped
+tar file holding the SQLite database and its metadata: the revision, cluster
+ID, schema version and checksum of the database. It is restored with the
+restore subcommand.
+
+		k8s-dqlite backup --storage-dir [dir with the dqlite datastore] --output backup.tar.gz
+
+`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			if backupCmdOpts.output == "" {
+				logrus.Fatal("--output is required")
+			}
+
+			// The archive is only moved to the output once verified.
+			f, err := os.CreateTemp(filepath.Dir(backupCmdOpts.output), ".backup-*")
+			if err != nil {
+				logrus.WithError(err).Fatal("Failed to create backup file")
+			}
+			fail := func(err error, message string) {
+				f.Close()
+				os.Remove(f.Name())
+				logrus.WithError(err).Fatal(message)
+			}
+			if err := controlClient().Backup(cmd.Context(), f); err != nil {
+				fail(err, "Failed to back up datastore")
+			}
+			if err := f.Close(); err != nil {
+				fail(err, "Failed to write backup file")
+			}
+			metadata, err := snapshot.VerifyArchive(f.Name())
+			if err != nil {
+				fail(err, "Failed to verify backup")
+			}
+			if err := os.Rename(f.Name(), backupCmdOpts.output); err != nil {
+				fail(err, "Failed to write backup file")
+			}
+			logrus.WithFields(logrus.Fields{
+				"output":   backupCmdOpts.output,
+				"revision": metadata.Revision,
+				"size":     metadata.Size,
+				"sha256":   metadata.SHA256,
+			}).Print("Backed up datastore")
+		},
+	}
+)
+
+func init() {
+	addControlFlags(backupCmd)
+	backupCmd.Flags().StringVar(&backupCmdOpts.output, "output", "", "path of the backup archive")
+	rootCmd.AddCommand(backupCmd)
+}
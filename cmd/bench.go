@@ -0,0 +1,104 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/canonical/k8s-dqlite/pkg/bench"
+	"github.com/canonical/k8s-dqlite/pkg/migrator"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var (
+	benchCmdOpts struct {
+		etcd     migrator.EtcdConfig
+		workload string
+		config   bench.Config
+		json     bool
+	}
+
+	benchCmd = &cobra.Command{
+		Use:   "bench",
+		Short: "Benchmark a running datastore",
+		Long: `
+Benchmark the kine endpoint of a running datastore, or any etcd endpoint,
+with a mix of puts, gets, lists and watches on keys of its own, and report
+the throughput and latency percentiles of each operation. The puts are
+transactions conditioned on the revision of the key, as the API server
+writes, and the watches measure the time from a put to its event. The keys
+are created before the benchmark and deleted once it completes.
+
+		k8s-dqlite bench --endpoint https://127.0.0.1:12379 --cacert ca.crt --cert client.crt --key client.key --workload put=20,get=60,list=10,watch=10 --concurrency 16 --duration 1m
+
+`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			workload, err := bench.ParseWorkload(benchCmdOpts.workload)
+			if err != nil {
+				logrus.WithError(err).Fatal("Invalid --workload")
+			}
+			config := benchCmdOpts.config
+			config.Workload = workload
+
+			client, err := benchCmdOpts.etcd.Client()
+			if err != nil {
+				logrus.WithError(err).Fatal("Failed to create client")
+			}
+			defer client.Close()
+
+			logrus.WithFields(logrus.Fields{
+				"endpoint":    benchCmdOpts.etcd.Endpoint,
+				"workload":    benchCmdOpts.workload,
+				"concurrency": config.Concurrency,
+			}).Print("Running benchmark")
+			result, err := bench.Run(cmd.Context(), client, config)
+			if err != nil {
+				logrus.WithError(err).Fatal("Benchmark failed")
+			}
+
+			if benchCmdOpts.json {
+				encoder := json.NewEncoder(os.Stdout)
+				encoder.SetIndent("", "  ")
+				if err := encoder.Encode(result); err != nil {
+					logrus.WithError(err).Fatal("Failed to print result")
+				}
+				return
+			}
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "OP\tCOUNT\tERRORS\tOPS/S\tMEAN\tP50\tP90\tP99\tMAX")
+			for _, op := range result.Ops {
+				fmt.Fprintf(w, "%s\t%d\t%d\t%.1f\t%s\t%s\t%s\t%s\t%s\n", op.Op, op.Count, op.Errors, op.Throughput,
+					round(op.Mean), round(op.P50), round(op.P90), round(op.P99), round(op.Max))
+			}
+			fmt.Fprintf(w, "total\t\t\t%.1f\n", result.Throughput)
+			w.Flush()
+		},
+	}
+)
+
+// round rounds the latencies for display.
+func round(d time.Duration) time.Duration {
+	return d.Round(time.Microsecond)
+}
+
+func init() {
+	benchCmd.Flags().StringVar(&benchCmdOpts.etcd.Endpoint, "endpoint", "http://127.0.0.1:12379", "etcd endpoint of the datastore")
+	benchCmd.Flags().StringVar(&benchCmdOpts.etcd.CAFile, "cacert", "", "CA certificate checking the endpoint, if it uses TLS")
+	benchCmd.Flags().StringVar(&benchCmdOpts.etcd.CertFile, "cert", "", "client certificate, if the endpoint uses TLS")
+	benchCmd.Flags().StringVar(&benchCmdOpts.etcd.KeyFile, "key", "", "client key, if the endpoint uses TLS")
+	benchCmd.Flags().StringVar(&benchCmdOpts.workload, "workload", "put=20,get=60,list=10,watch=10", "mix of operations, as op=weight with op one of put, get, list and watch")
+	benchCmd.Flags().IntVar(&benchCmdOpts.config.Concurrency, "concurrency", 8, "number of concurrent clients")
+	benchCmd.Flags().DurationVar(&benchCmdOpts.config.Duration, "duration", 30*time.Second, "duration of the benchmark. If value = 0, it runs until --requests are run.")
+	benchCmd.Flags().Int64Var(&benchCmdOpts.config.Requests, "requests", 0, "number of operations run. If value = 0, the benchmark runs for --duration.")
+	benchCmd.Flags().StringVar(&benchCmdOpts.config.Prefix, "prefix", "/k8s-dqlite-bench/", "prefix of the keys of the benchmark, which are deleted once it completes")
+	benchCmd.Flags().IntVar(&benchCmdOpts.config.Keys, "keys", 1000, "number of keys")
+	benchCmd.Flags().IntVar(&benchCmdOpts.config.KeySize, "key-size", 64, "size of the keys, in bytes")
+	benchCmd.Flags().IntVar(&benchCmdOpts.config.ValueSize, "value-size", 1024, "size of the values, in bytes")
+	benchCmd.Flags().Int64Var(&benchCmdOpts.config.ListLimit, "list-limit", 500, "number of keys returned by the lists. If value = 0, all the keys are listed.")
+	benchCmd.Flags().BoolVar(&benchCmdOpts.json, "json", false, "print the result as JSON")
+	rootCmd.AddCommand(benchCmd)
+}
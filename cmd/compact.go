@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"time"
+
+	"github.com/canonical/k8s-dqlite/pkg/server"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var (
+	compactCmdOpts struct {
+		revision  int64
+		keepHours int
+	}
+
+	compactCmd = &cobra.Command{
+		Use:   "compact",
+		Short: "Compact the history of the datastore",
+		Long: `
+Compact the revisions of the datastore up to a revision, or those older than
+a number of hours, right away rather than waiting for the periodic
+compaction. The compaction runs through the node running on this host, which
+finds the revisions older than --keep-hours from the revisions it sampled
+while running, so only those older than its start can be compacted.
+
+		k8s-dqlite compact --storage-dir [dir with the dqlite datastore] --revision 12345
+		k8s-dqlite compact --storage-dir [dir with the dqlite datastore] --keep-hours 2
+
+`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			if (compactCmdOpts.revision > 0) == (compactCmdOpts.keepHours > 0) {
+				logrus.Fatal("Either --revision or --keep-hours is required")
+			}
+			result, err := controlClient().Compact(cmd.Context(), server.CompactOptions{
+				Revision: compactCmdOpts.revision,
+				Keep:     time.Duration(compactCmdOpts.keepHours) * time.Hour,
+			})
+			if err != nil {
+				logrus.WithError(err).Fatal("Failed to compact datastore")
+			}
+			logrus.WithField("revision", result.Revision).Print("Compacted datastore")
+		},
+	}
+)
+
+func init() {
+	addControlFlags(compactCmd)
+	compactCmd.Flags().Int64Var(&compactCmdOpts.revision, "revision", 0, "revision up to which the history is compacted")
+	compactCmd.Flags().IntVar(&compactCmdOpts.keepHours, "keep-hours", 0, "number of hours of history retained")
+	rootCmd.AddCommand(compactCmd)
+}
@@ -0,0 +1,126 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v2"
+)
+
+// envPrefix is the prefix of the environment variables setting the flags,
+// such as K8S_DQLITE_STORAGE_DIR for --storage-dir.
+const envPrefix = "K8S_DQLITE_"
+
+var (
+	// fixedFlags are the flags set on the command line or in the
+	// environment, which the configuration file does not override.
+	fixedFlags map[string]bool
+	// fileFlags are the flags set from the configuration file.
+	fileFlags = map[string]bool{}
+)
+
+// loadConfig sets the flags that are not set on the command line from the
+// environment variables, and then from the configuration file set by the
+// config flag, if any. The command line takes precedence over the
+// environment, which takes precedence over the configuration file.
+func loadConfig(flags *pflag.FlagSet) error {
+	var err error
+	flags.VisitAll(func(flag *pflag.Flag) {
+		if err != nil || flag.Changed {
+			return
+		}
+		if value, ok := os.LookupEnv(envName(flag.Name)); ok {
+			if setErr := flags.Set(flag.Name, value); setErr != nil {
+				err = fmt.Errorf("invalid value of %s: %w", envName(flag.Name), setErr)
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	fixedFlags = map[string]bool{}
+	flags.Visit(func(flag *pflag.Flag) {
+		fixedFlags[flag.Name] = true
+	})
+	return readConfigFile(flags)
+}
+
+// readConfigFile sets the flags from the configuration file, if any, except
+// the ones set on the command line or in the environment. It can be called
+// again to read the file after it changed, in which case the flags removed
+// from the file go back to their default value.
+func readConfigFile(flags *pflag.FlagSet) error {
+	var path string
+	if flag := flags.Lookup("config"); flag != nil {
+		path = flag.Value.String()
+	}
+	if path == "" {
+		return nil
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read configuration file: %w", err)
+	}
+	var config map[string]interface{}
+	if err := yaml.Unmarshal(b, &config); err != nil {
+		return fmt.Errorf("failed to parse configuration file %s: %w", path, err)
+	}
+
+	set := make(map[string]bool, len(config))
+	for name, value := range config {
+		flag := flags.Lookup(name)
+		if flag == nil || flag.Name == "config" {
+			return fmt.Errorf("unknown option %q in configuration file %s", name, path)
+		}
+		if fixedFlags[flag.Name] {
+			continue
+		}
+		s, err := configValue(value)
+		if err != nil {
+			return fmt.Errorf("invalid value of %q in configuration file %s: %w", name, path, err)
+		}
+		if err := flags.Set(flag.Name, s); err != nil {
+			return fmt.Errorf("invalid value of %q in configuration file %s: %w", name, path, err)
+		}
+		set[flag.Name] = true
+	}
+	for name := range fileFlags {
+		if !set[name] {
+			if err := flags.Set(name, flags.Lookup(name).DefValue); err != nil {
+				return fmt.Errorf("failed to reset %q: %w", name, err)
+			}
+		}
+	}
+	fileFlags = set
+	return nil
+}
+
+// envName returns the environment variable setting a flag.
+func envName(flag string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flag, "-", "_"))
+}
+
+// configValue formats a value of the configuration file as a flag value.
+// Lists are formatted as comma separated values.
+func configValue(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			s, err := configValue(item)
+			if err != nil {
+				return "", err
+			}
+			values = append(values, s)
+		}
+		return strings.Join(values, ","), nil
+	case map[interface{}]interface{}:
+		return "", fmt.Errorf("nested options are not supported")
+	}
+	return fmt.Sprint(value), nil
+}
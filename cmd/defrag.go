@@ -0,0 +1,32 @@
+package cmd
+
+import (
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var defragCmd = &cobra.Command{
+	Use:   "defrag",
+	Short: "Defragment the datastore",
+	Long: `
+Vacuum the database right away, returning its unused pages to the file
+system, as with etcdctl defrag. The vacuum is full or incremental as
+configured with --vacuum-mode on the node running on this host.
+
+		k8s-dqlite defrag --storage-dir [dir with the dqlite datastore]
+
+`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		result, err := controlClient().Defrag(cmd.Context())
+		if err != nil {
+			logrus.WithError(err).Fatal("Failed to defragment datastore")
+		}
+		logrus.WithFields(logrus.Fields{"before": result.Before, "after": result.After}).Print("Defragmented datastore")
+	},
+}
+
+func init() {
+	addControlFlags(defragCmd)
+	rootCmd.AddCommand(defragCmd)
+}
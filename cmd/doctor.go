@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"text/tabwriter"
+
+	"github.com/canonical/k8s-dqlite/pkg/server"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var (
+	doctorCmdOpts struct {
+		certFiles []string
+		joinToken string
+		ntpServer string
+		json      bool
+	}
+
+	doctorCmd = &cobra.Command{
+		Use:   "doctor",
+		Short: "Run preflight checks of the node",
+		Long: `
+Check the host of the node before starting it or joining it to a cluster:
+the permissions of the storage directory, the fsync latency of its storage,
+the open file limit, the expiry of the certificates, the schema version of
+the database, the reachability of the peers and the clock skew. Each problem
+is reported with the action to take, and the command fails if any check
+does. The schema version is only checked if the node is running.
+
+		k8s-dqlite doctor --storage-dir [dir with the dqlite datastore] --join-token [token the node joins with]
+
+`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			config := server.DoctorConfig{
+				Dir:       controlCmdOpts.dir,
+				CertFiles: doctorCmdOpts.certFiles,
+				JoinToken: doctorCmdOpts.joinToken,
+				NTPServer: doctorCmdOpts.ntpServer,
+			}
+			if conn, err := net.Dial("unix", controlSocketPath(controlCmdOpts.dir, controlCmdOpts.controlSocket)); err == nil {
+				conn.Close()
+				config.Control = controlClient()
+			}
+			checks := server.Doctor(cmd.Context(), config)
+
+			if doctorCmdOpts.json {
+				encoder := json.NewEncoder(os.Stdout)
+				encoder.SetIndent("", "  ")
+				if err := encoder.Encode(checks); err != nil {
+					logrus.WithError(err).Fatal("Failed to print checks")
+				}
+			} else {
+				w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+				fmt.Fprintln(w, "CHECK\tSTATUS\tMESSAGE")
+				for _, check := range checks {
+					fmt.Fprintf(w, "%s\t%s\t%s\n", check.Name, check.Status, check.Message)
+				}
+				w.Flush()
+			}
+
+			for _, check := range checks {
+				if check.Status == server.DoctorError {
+					logrus.Fatal("Preflight checks failed")
+				}
+			}
+		},
+	}
+)
+
+func init() {
+	addControlFlags(doctorCmd)
+	doctorCmd.Flags().StringSliceVar(&doctorCmdOpts.certFiles, "cert-file", nil, "certificates checked besides cluster.crt in the storage directory, such as --kine-cert-file")
+	doctorCmd.Flags().StringVar(&doctorCmdOpts.joinToken, "join-token", "", "token the node joins the cluster with, whose join endpoint is checked")
+	doctorCmd.Flags().StringVar(&doctorCmdOpts.ntpServer, "ntp-server", "pool.ntp.org", "NTP server the clock is compared with. If empty, the clock skew is not checked.")
+	doctorCmd.Flags().BoolVar(&doctorCmdOpts.json, "json", false, "print the checks as JSON")
+	rootCmd.AddCommand(doctorCmd)
+}
@@ -0,0 +1,102 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/canonical/k8s-dqlite/pkg/decode"
+	"github.com/canonical/k8s-dqlite/pkg/server"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"sigs.k8s.io/yaml"
+)
+
+var (
+	dumpCmdOpts struct {
+		prefix string
+		decode bool
+		output string
+	}
+
+	dumpCmd = &cobra.Command{
+		Use:   "dump",
+		Short: "List the keys of the datastore",
+		Long: `
+List the keys with a prefix, read directly from the database through the
+node running on this host, to inspect the state of the cluster without an
+API server. With --decode, the values are printed as YAML or JSON. The
+objects stored as protobuf are decoded without their schema: their metadata
+is decoded with the names of its fields, and their other fields are named by
+their number.
+
+		k8s-dqlite dump --storage-dir [dir with the dqlite datastore] --prefix /registry/ --decode
+
+`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			if dumpCmdOpts.output != "yaml" && dumpCmdOpts.output != "json" {
+				logrus.WithField("output", dumpCmdOpts.output).Fatal("Unsupported --output, expected yaml or json")
+			}
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			if !dumpCmdOpts.decode {
+				fmt.Fprintln(w, "KEY\tCREATE REVISION\tMOD REVISION\tLEASE")
+			}
+
+			client := controlClient()
+			opts := server.ListKeysOptions{Prefix: dumpCmdOpts.prefix, Values: dumpCmdOpts.decode}
+			for {
+				list, err := client.ListKeys(cmd.Context(), opts)
+				if err != nil {
+					logrus.WithError(err).Fatal("Failed to list keys")
+				}
+				for _, kv := range list.Keys {
+					if !dumpCmdOpts.decode {
+						fmt.Fprintf(w, "%s\t%d\t%d\t%d\n", kv.Key, kv.CreateRevision, kv.ModRevision, kv.Lease)
+						continue
+					}
+					if err := printDecodedKey(kv); err != nil {
+						logrus.WithError(err).WithField("key", kv.Key).Fatal("Failed to print key")
+					}
+				}
+				if list.Next == "" {
+					break
+				}
+				// The next pages are listed at the revision of the first.
+				opts.Start = list.Next
+				opts.Revision = list.Revision
+			}
+			w.Flush()
+		},
+	}
+)
+
+// printDecodedKey prints a key with its decoded value, as a YAML document
+// or a JSON line.
+func printDecodedKey(kv server.KeyValue) error {
+	if dumpCmdOpts.output == "json" {
+		return json.NewEncoder(os.Stdout).Encode(struct {
+			Key            string `json:"key"`
+			CreateRevision int64  `json:"create_revision"`
+			ModRevision    int64  `json:"mod_revision"`
+			Lease          int64  `json:"lease,omitempty"`
+			Value          any    `json:"value"`
+		}{kv.Key, kv.CreateRevision, kv.ModRevision, kv.Lease, decode.Value(kv.Value)})
+	}
+
+	b, err := yaml.Marshal(decode.Value(kv.Value))
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Printf("---\n# %s (create revision %d, mod revision %d)\n%s", kv.Key, kv.CreateRevision, kv.ModRevision, b)
+	return err
+}
+
+func init() {
+	addControlFlags(dumpCmd)
+	dumpCmd.Flags().StringVar(&dumpCmdOpts.prefix, "prefix", "/registry/", "prefix of the keys")
+	dumpCmd.Flags().BoolVar(&dumpCmdOpts.decode, "decode", false, "print the decoded values of the keys")
+	dumpCmd.Flags().StringVar(&dumpCmdOpts.output, "output", "yaml", "format of the decoded values (yaml|json)")
+	rootCmd.AddCommand(dumpCmd)
+}
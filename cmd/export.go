@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/canonical/k8s-dqlite/pkg/snapshot"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var (
+	exportCmdOpts struct {
+		format       string
+		output       string
+		fromSnapshot string
+	}
+
+	exportCmd = &cobra.Command{
+		Use:   "export",
+		Short: "Export the datastore in a portable format",
+		Long: `
+Export the datastore, with the history kept since the last compaction, as
+an etcd snapshot restorable with "etcdctl snapshot restore", as a SQL dump
+of the kine table, or as the rows of the kine table in JSON lines. The
+datastore is backed up through the node running on this host, unless a
+snapshot or backup archive is given.
+
+		k8s-dqlite export --storage-dir [dir with the dqlite datastore] --format etcd-snapshot|sqldump|jsonl --output [file]
+
+`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			if exportCmdOpts.output == "" {
+				logrus.Fatal("--output is required")
+			}
+			format, err := snapshot.ParseExportFormat(exportCmdOpts.format)
+			if err != nil {
+				logrus.WithError(err).Fatal("Invalid --format")
+			}
+
+			src := exportCmdOpts.fromSnapshot
+			if src == "" {
+				f, err := os.CreateTemp(filepath.Dir(exportCmdOpts.output), ".export-*")
+				if err != nil {
+					logrus.WithError(err).Fatal("Failed to create backup file")
+				}
+				defer os.Remove(f.Name())
+				err = controlClient().Backup(cmd.Context(), f)
+				if closeErr := f.Close(); err == nil {
+					err = closeErr
+				}
+				if err != nil {
+					os.Remove(f.Name())
+					logrus.WithError(err).Fatal("Failed to back up datastore")
+				}
+				src = f.Name()
+			}
+
+			if err := snapshot.Export(cmd.Context(), src, exportCmdOpts.output, format); err != nil {
+				if exportCmdOpts.fromSnapshot == "" {
+					os.Remove(src)
+				}
+				logrus.WithError(err).Fatal("Failed to export datastore")
+			}
+			logrus.WithFields(logrus.Fields{"output": exportCmdOpts.output, "format": format}).Print("Exported datastore")
+		},
+	}
+)
+
+func init() {
+	addControlFlags(exportCmd)
+	exportCmd.Flags().StringVar(&exportCmdOpts.format, "format", string(snapshot.ExportEtcd), "format of the export: etcd-snapshot, sqldump or jsonl")
+	exportCmd.Flags().StringVar(&exportCmdOpts.output, "output", "", "path of the export, which must not exist")
+	exportCmd.Flags().StringVar(&exportCmdOpts.fromSnapshot, "from-snapshot", "", "snapshot or backup archive to export, instead of the running datastore")
+	rootCmd.AddCommand(exportCmd)
+}
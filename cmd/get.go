@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// historyValueLength is the length up to which the values are printed in
+// the history table.
+const historyValueLength = 60
+
+var (
+	getCmdOpts struct {
+		history bool
+		json    bool
+	}
+
+	getCmd = &cobra.Command{
+		Use:   "get <key>",
+		Short: "Print a key as stored in the datastore",
+		Long: `
+Print the latest revision of a key, read directly from the database through
+the node running on this host. With --history, all the revisions of the key
+retained since the last compaction are printed, tombstones included, which
+helps debugging conflicts and stale caches of the API server.
+
+		k8s-dqlite get --storage-dir [dir with the dqlite datastore] --history /registry/pods/default/foo
+
+`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			history, err := controlClient().History(cmd.Context(), args[0])
+			if err != nil {
+				logrus.WithError(err).Fatal("Failed to read key")
+			}
+			revisions := history.Revisions
+			if !getCmdOpts.history && len(revisions) > 0 {
+				revisions = revisions[len(revisions)-1:]
+			}
+			if len(revisions) == 0 || !getCmdOpts.history && revisions[0].Type == "delete" {
+				logrus.WithField("key", args[0]).Fatal("Key not found")
+			}
+
+			if getCmdOpts.json {
+				history.Revisions = revisions
+				encoder := json.NewEncoder(os.Stdout)
+				encoder.SetIndent("", "  ")
+				if err := encoder.Encode(history); err != nil {
+					logrus.WithError(err).Fatal("Failed to print key")
+				}
+				return
+			}
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "REVISION\tTYPE\tCREATE REVISION\tPREV REVISION\tLEASE\tSIZE\tVALUE")
+			for _, revision := range revisions {
+				value := string(revision.Value)
+				if len(value) > historyValueLength {
+					value = value[:historyValueLength] + "..."
+				}
+				fmt.Fprintf(w, "%d\t%s\t%d\t%d\t%d\t%d\t%s\n", revision.Revision, revision.Type, revision.CreateRevision,
+					revision.PrevRevision, revision.Lease, len(revision.Value), strconv.Quote(value))
+			}
+			w.Flush()
+			if getCmdOpts.history && history.CompactRevision > 0 {
+				fmt.Printf("\nRevisions before the compact revision %d may be missing.\n", history.CompactRevision)
+			}
+		},
+	}
+)
+
+func init() {
+	addControlFlags(getCmd)
+	getCmd.Flags().BoolVar(&getCmdOpts.history, "history", false, "print all the revisions of the key retained since the last compaction")
+	getCmd.Flags().BoolVar(&getCmdOpts.json, "json", false, "print the key as JSON, with base64 encoded values")
+	rootCmd.AddCommand(getCmd)
+}
@@ -0,0 +1,29 @@
+package cmd
+
+import (
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var handoverCmd = &cobra.Command{
+	Use:   "handover",
+	Short: "Transfer the dqlite leadership of the node to another voter",
+	Long: `
+Transfer the dqlite leadership of the node running on this host to another
+online voter, if the node is the leader, such as before restarting it. The
+roles of the nodes are unchanged.
+`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		leader, err := controlClient().Handover(cmd.Context())
+		if err != nil {
+			logrus.WithError(err).Fatal("Failed to handover leadership")
+		}
+		logrus.WithField("leader", leader).Print("Leadership handed over")
+	},
+}
+
+func init() {
+	addControlFlags(handoverCmd)
+	rootCmd.AddCommand(handoverCmd)
+}
@@ -0,0 +1,49 @@
+package cmd
+
+import (
+	"time"
+
+	"github.com/canonical/k8s-dqlite/pkg/server"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var (
+	initCertsCmdOpts struct {
+		dir      string
+		sans     []string
+		validity time.Duration
+		force    bool
+	}
+
+	initCertsCmd = &cobra.Command{
+		Use:   "init-certs",
+		Short: "Generate the certificates of a node",
+		Long: `
+Generate a CA (ca.crt), the peer certificate of the dqlite traffic between the
+nodes (cluster.crt) and the certificate of the kine endpoint (server.crt) in the
+storage directory. An existing CA in the storage directory is used to sign the
+certificates instead of generating a new one.
+
+		k8s-dqlite init-certs --storage-dir [dir with the dqlite datastore] --san [DNS name or IP address of the node]
+
+`,
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := server.InitCerts(initCertsCmdOpts.dir, server.InitCertsConfig{
+				SANs:     initCertsCmdOpts.sans,
+				Validity: initCertsCmdOpts.validity,
+				Force:    initCertsCmdOpts.force,
+			}); err != nil {
+				logrus.WithError(err).Fatal("Failed to generate certificates")
+			}
+		},
+	}
+)
+
+func init() {
+	initCertsCmd.Flags().StringVar(&initCertsCmdOpts.dir, "storage-dir", "/var/tmp/k8s-dqlite", "directory with the dqlite datastore")
+	initCertsCmd.Flags().StringSliceVar(&initCertsCmdOpts.sans, "san", nil, "DNS names and IP addresses of the node, added to the certificates. Can be repeated.")
+	initCertsCmd.Flags().DurationVar(&initCertsCmdOpts.validity, "validity", 10*365*24*time.Hour, "validity of the certificates")
+	initCertsCmd.Flags().BoolVar(&initCertsCmdOpts.force, "force", false, "overwrite the existing certificates, except the CA")
+	rootCmd.AddCommand(initCertsCmd)
+}
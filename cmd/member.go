@@ -0,0 +1,140 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/canonical/k8s-dqlite/pkg/server"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var (
+	// controlCmdOpts are the options of the subcommands calling the
+	// control socket.
+	controlCmdOpts struct {
+		dir           string
+		controlSocket string
+	}
+
+	memberAddCmdOpts struct {
+		id      uint64
+		address string
+		role    string
+	}
+
+	memberPromoteCmdOpts struct {
+		role string
+	}
+
+	memberCmd = &cobra.Command{
+		Use:   "member",
+		Short: "Manage the members of the dqlite cluster",
+		Long: `
+Manage the members of the dqlite cluster through the control socket of the
+node running on this host.
+`,
+	}
+
+	memberListCmd = &cobra.Command{
+		Use:   "list",
+		Short: "List the members of the cluster",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			members, err := controlClient().Members(cmd.Context())
+			if err != nil {
+				logrus.WithError(err).Fatal("Failed to list members")
+			}
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "ID\tADDRESS\tROLE")
+			for _, member := range members {
+				fmt.Fprintf(w, "%d\t%s\t%s\n", member.ID, member.Address, member.Role)
+			}
+			w.Flush()
+		},
+	}
+
+	memberAddCmd = &cobra.Command{
+		Use:   "add",
+		Short: "Add a member to the cluster",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			if memberAddCmdOpts.id == 0 || memberAddCmdOpts.address == "" {
+				logrus.Fatal("--id and --address are required")
+			}
+			if err := controlClient().AddMember(cmd.Context(), server.Member{
+				ID:      memberAddCmdOpts.id,
+				Address: memberAddCmdOpts.address,
+				Role:    memberAddCmdOpts.role,
+			}); err != nil {
+				logrus.WithError(err).Fatal("Failed to add member")
+			}
+		},
+	}
+
+	memberRemoveCmd = &cobra.Command{
+		Use:   "remove <id>",
+		Short: "Remove a member from the cluster",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := controlClient().RemoveMember(cmd.Context(), parseMemberID(args[0])); err != nil {
+				logrus.WithError(err).Fatal("Failed to remove member")
+			}
+		},
+	}
+
+	memberPromoteCmd = &cobra.Command{
+		Use:   "promote <id>",
+		Short: "Assign the role of a member of the cluster",
+		Long: `
+Assign the role of a member of the cluster, voter by default. Demoting members
+is done by assigning the standby or spare roles.
+`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := controlClient().AssignRole(cmd.Context(), parseMemberID(args[0]), memberPromoteCmdOpts.role); err != nil {
+				logrus.WithError(err).Fatal("Failed to assign member role")
+			}
+		},
+	}
+)
+
+// controlSocketPath returns the path of the control socket, control.sock
+// in the storage directory unless configured.
+func controlSocketPath(dir, socket string) string {
+	if socket != "" {
+		return socket
+	}
+	return filepath.Join(dir, "control.sock")
+}
+
+func controlClient() *server.ControlClient {
+	return server.NewControlClient(controlSocketPath(controlCmdOpts.dir, controlCmdOpts.controlSocket))
+}
+
+// addControlFlags adds the flags locating the control socket to cmd.
+func addControlFlags(cmd *cobra.Command) {
+	cmd.PersistentFlags().StringVar(&controlCmdOpts.dir, "storage-dir", "/var/tmp/k8s-dqlite", "directory with the dqlite datastore")
+	cmd.PersistentFlags().StringVar(&controlCmdOpts.controlSocket, "control-socket", "", "path of the control socket of the node. If empty, control.sock in the storage directory is used.")
+}
+
+func parseMemberID(arg string) uint64 {
+	id, err := strconv.ParseUint(arg, 10, 64)
+	if err != nil {
+		logrus.WithError(err).Fatal("Invalid member ID")
+	}
+	return id
+}
+
+func init() {
+	addControlFlags(memberCmd)
+	memberAddCmd.Flags().Uint64Var(&memberAddCmdOpts.id, "id", 0, "ID of the dqlite node")
+	memberAddCmd.Flags().StringVar(&memberAddCmdOpts.address, "address", "", "address of the dqlite node, as host:port")
+	memberAddCmd.Flags().StringVar(&memberAddCmdOpts.role, "role", "spare", "role of the member (voter|standby|spare)")
+	memberPromoteCmd.Flags().StringVar(&memberPromoteCmdOpts.role, "role", "voter", "role of the member (voter|standby|spare)")
+	memberCmd.AddCommand(memberListCmd, memberAddCmd, memberRemoveCmd, memberPromoteCmd)
+	rootCmd.AddCommand(memberCmd)
+}
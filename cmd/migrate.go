@@ -0,0 +1,91 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/canonical/k8s-dqlite/pkg/logging"
+	"github.com/canonical/k8s-dqlite/pkg/migrator"
+	"github.com/canonical/k8s-dqlite/pkg/server"
+	"github.com/canonical/k8s-dqlite/pkg/snapshot"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var (
+	migrateCmdOpts struct {
+		dir      string
+		tls      bool
+		etcd     migrator.EtcdConfig
+		fromKine string
+		debug    bool
+	}
+
+	migrateCmd = &cobra.Command{
+		Use:   "migrate",
+		Short: "Migrate the contents of an etcd cluster or kine database to the datastore",
+		Long: `
+Replace the contents of the datastore with a snapshot of a running etcd
+cluster, keeping the history retained by etcd and its revisions where
+possible, or with the contents of the database of another kine, such as the
+one of k3s, keeping its revisions and compact revision. The writes to the
+source must be stopped (e.g. by stopping the Kubernetes API servers) before
+migrating, and the k8s-dqlite service must be stopped on this node while
+migrating.
+
+		k8s-dqlite migrate --storage-dir [dir with the dqlite datastore] --from-etcd https://127.0.0.1:2379 --cacert ca.crt --cert client.crt --key client.key
+		k8s-dqlite migrate --storage-dir [dir with the dqlite datastore] --from-kine [SQLite file, mysql:// or postgres:// URL]
+
+`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			if migrateCmdOpts.debug {
+				logging.SetLevel(logrus.DebugLevel)
+			}
+			switch {
+			case migrateCmdOpts.etcd.Endpoint == "" && migrateCmdOpts.fromKine == "":
+				logrus.Fatal("either --from-etcd or --from-kine is required")
+			case migrateCmdOpts.etcd.Endpoint != "" && migrateCmdOpts.fromKine != "":
+				logrus.Fatal("--from-etcd and --from-kine are mutually exclusive")
+			case migrateCmdOpts.fromKine != "":
+				// The source is not logged, as the URLs hold credentials.
+				logrus.WithField("dir", migrateCmdOpts.dir).Print("Migrating kine database")
+				if err := server.Restore(cmd.Context(), migrateCmdOpts.dir, migrateCmdOpts.tls, migrateCmdOpts.fromKine, snapshot.RestoreOptions{}); err != nil {
+					logrus.WithError(err).Fatal("Failed to migrate kine database")
+				}
+				return
+			}
+
+			// The snapshot is staged in the storage directory, which has
+			// room for the datastore.
+			dir, err := os.MkdirTemp(migrateCmdOpts.dir, "etcd-migration-")
+			if err != nil {
+				logrus.WithError(err).Fatal("Failed to create snapshot directory")
+			}
+			defer os.RemoveAll(dir)
+			path := filepath.Join(dir, "etcd.db")
+
+			if err := migrator.SnapshotEtcd(cmd.Context(), migrateCmdOpts.etcd, path); err != nil {
+				os.RemoveAll(dir)
+				logrus.WithError(err).Fatal("Failed to take etcd snapshot")
+			}
+			logrus.WithFields(logrus.Fields{"dir": migrateCmdOpts.dir, "endpoint": migrateCmdOpts.etcd.Endpoint}).Print("Migrating etcd snapshot")
+			if err := server.Restore(cmd.Context(), migrateCmdOpts.dir, migrateCmdOpts.tls, path, snapshot.RestoreOptions{EtcdRevisions: true}); err != nil {
+				os.RemoveAll(dir)
+				logrus.WithError(err).Fatal("Failed to migrate etcd snapshot")
+			}
+		},
+	}
+)
+
+func init() {
+	migrateCmd.Flags().StringVar(&migrateCmdOpts.dir, "storage-dir", "/var/tmp/k8s-dqlite", "directory with the dqlite datastore")
+	migrateCmd.Flags().BoolVar(&migrateCmdOpts.tls, "enable-tls", true, "enable TLS")
+	migrateCmd.Flags().StringVar(&migrateCmdOpts.etcd.Endpoint, "from-etcd", "", "endpoint of the etcd cluster to migrate")
+	migrateCmd.Flags().StringVar(&migrateCmdOpts.etcd.CAFile, "cacert", "", "CA certificate of the etcd server")
+	migrateCmd.Flags().StringVar(&migrateCmdOpts.etcd.CertFile, "cert", "", "client certificate for etcd")
+	migrateCmd.Flags().StringVar(&migrateCmdOpts.etcd.KeyFile, "key", "", "client key for etcd")
+	migrateCmd.Flags().StringVar(&migrateCmdOpts.fromKine, "from-kine", "", "kine database to migrate: a SQLite file, or a mysql:// or postgres:// URL as in the datastore endpoint of k3s")
+	migrateCmd.Flags().BoolVar(&migrateCmdOpts.debug, "debug", false, "debug logs")
+	rootCmd.AddCommand(migrateCmd)
+}
@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"github.com/canonical/k8s-dqlite/pkg/logging"
 	"github.com/canonical/k8s-dqlite/pkg/migrator"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
@@ -25,7 +26,7 @@ Copy data between etcd and kine (dqlite)
 `,
 		Run: func(cmd *cobra.Command, args []string) {
 			if migratorCmdOpts.debug {
-				logrus.SetLevel(logrus.DebugLevel)
+				logging.SetLevel(logrus.DebugLevel)
 			}
 
 			ctx := cmd.Context()
@@ -0,0 +1,55 @@
+package cmd
+
+import (
+	"net"
+
+	"github.com/canonical/k8s-dqlite/pkg/server"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var (
+	reconfigureCmdOpts struct {
+		fromNodes []string
+	}
+
+	reconfigureCmd = &cobra.Command{
+		Use:   "reconfigure",
+		Short: "Recover the dqlite cluster from the loss of its quorum",
+		Long: `
+Rewrite the membership of the dqlite cluster to the surviving nodes, given by
+address or ID, which all become voters. The k8s-dqlite service must be
+stopped on all the surviving nodes, and the command run on each of them with
+the same nodes, preferably after copying the storage directory of the node
+with the most recent data to the others.
+
+		k8s-dqlite reconfigure --storage-dir [dir with the dqlite datastore] --from-nodes 10.0.0.1:9000,10.0.0.2:9000
+
+`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			if len(reconfigureCmdOpts.fromNodes) == 0 {
+				logrus.Fatal("--from-nodes is required")
+			}
+			// The membership must not change under a running node.
+			if conn, err := net.Dial("unix", controlSocketPath(controlCmdOpts.dir, controlCmdOpts.controlSocket)); err == nil {
+				conn.Close()
+				logrus.Fatal("The node is running, stop the k8s-dqlite service first")
+			}
+
+			membership, err := server.Reconfigure(controlCmdOpts.dir, reconfigureCmdOpts.fromNodes)
+			if err != nil {
+				logrus.WithError(err).Fatal("Failed to reconfigure cluster")
+			}
+			for _, node := range membership {
+				logrus.WithFields(logrus.Fields{"id": node.ID, "address": node.Address, "role": node.Role}).Print("Reconfigured node")
+			}
+		},
+	}
+)
+
+func init() {
+	addControlFlags(reconfigureCmd)
+	reconfigureCmd.Flags().StringSliceVar(&reconfigureCmdOpts.fromNodes, "from-nodes", nil, "addresses or IDs of the surviving nodes, as listed in cluster.yaml")
+	rootCmd.AddCommand(reconfigureCmd)
+}
@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"github.com/canonical/k8s-dqlite/pkg/logging"
+	"github.com/canonical/k8s-dqlite/pkg/server"
+	"github.com/canonical/k8s-dqlite/pkg/snapshot"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var (
+	restoreCmdOpts struct {
+		dir          string
+		tls          bool
+		fromSnapshot string
+		revision     int64
+		debug        bool
+	}
+
+	restoreCmd = &cobra.Command{
+		Use:   "restore",
+		Short: "Restore the datastore from a snapshot",
+		Long: `
+Replace the contents of the datastore with a snapshot, taken either from
+k8s-dqlite or from etcd (e.g. with "etcdctl snapshot save"). The k8s-dqlite
+service must be stopped on this node while restoring. With --revision, the
+datastore is restored as it was at that revision of the snapshot, e.g. right
+before a mass deletion.
+
+		k8s-dqlite restore --storage-dir [dir with the dqlite datastore] --from-snapshot [snapshot file] [--revision N]
+
+`,
+		Run: func(cmd *cobra.Command, args []string) {
+			if restoreCmdOpts.debug {
+				logging.SetLevel(logrus.DebugLevel)
+			}
+			if restoreCmdOpts.fromSnapshot == "" {
+				logrus.Fatal("--from-snapshot is required")
+			}
+
+			logrus.WithFields(logrus.Fields{"dir": restoreCmdOpts.dir, "snapshot": restoreCmdOpts.fromSnapshot, "revision": restoreCmdOpts.revision}).Print("Restoring datastore")
+			if err := server.Restore(cmd.Context(), restoreCmdOpts.dir, restoreCmdOpts.tls, restoreCmdOpts.fromSnapshot, snapshot.RestoreOptions{Revision: restoreCmdOpts.revision}); err != nil {
+				logrus.WithError(err).Fatal("Failed to restore datastore")
+			}
+		},
+	}
+)
+
+func init() {
+	restoreCmd.Flags().StringVar(&restoreCmdOpts.dir, "storage-dir", "/var/tmp/k8s-dqlite", "directory with the dqlite datastore")
+	restoreCmd.Flags().BoolVar(&restoreCmdOpts.tls, "enable-tls", true, "enable TLS")
+	restoreCmd.Flags().StringVar(&restoreCmdOpts.fromSnapshot, "from-snapshot", "", "snapshot file to restore, from k8s-dqlite or etcd")
+	restoreCmd.Flags().Int64Var(&restoreCmdOpts.revision, "revision", 0, "revision of a k8s-dqlite snapshot to restore the datastore at, dropping the later revisions. If value = 0, the whole snapshot is restored")
+	restoreCmd.Flags().BoolVar(&restoreCmdOpts.debug, "debug", false, "debug logs")
+	rootCmd.AddCommand(restoreCmd)
+}
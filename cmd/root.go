@@ -9,29 +9,61 @@ import (
 	"os/signal"
 	"time"
 
+	"github.com/canonical/k8s-dqlite/pkg/kine/audit"
 	"github.com/canonical/k8s-dqlite/pkg/kine/drivers/generic"
+	"github.com/canonical/k8s-dqlite/pkg/kine/endpoint"
+	kine_server "github.com/canonical/k8s-dqlite/pkg/kine/server"
+	"github.com/canonical/k8s-dqlite/pkg/logging"
 	"github.com/canonical/k8s-dqlite/pkg/server"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 	"golang.org/x/sys/unix"
 )
 
 var (
 	rootCmdOpts struct {
+		config                 string
 		dir                    string
 		listen                 string
 		tls                    bool
 		debug                  bool
+		logFormat              string
+		logLevel               string
 		profiling              bool
 		profilingAddress       string
 		diskMode               bool
 		clientSessionCacheSize uint
 		minTLSVersion          string
+		maxTLSVersion          string
+		tlsCipherSuites        []string
+		peerCertFile           string
+		peerKeyFile            string
+		peerCAFile             string
+		kineCertFile           string
+		kineKeyFile            string
+		kineMinTLSVersion      string
+		clientCAFile           string
+		requireClientCert      bool
 		metrics                bool
 		metricsAddress         string
+		metricsAdminTokenFile  string
+		health                 bool
+		healthAddress          string
+		healthWriteProbe       bool
+		healthProbeTimeout     time.Duration
+		joinToken              string
+		advertiseAddress       string
+		joinListen             string
+		controlSocket          string
 		otel                   bool
-		otelAddress            string
+		otelEndpoint           string
+		otelProtocol           string
+		otelInsecure           bool
+		otelSampler            string
+		otelSamplingRate       float64
+		otelRedactKeys         bool
 
 		connectionPoolConfig generic.ConnectionPoolConfig
 
@@ -39,19 +71,88 @@ var (
 		watchAvailableStorageMinBytes uint64
 		lowAvailableStorageAction     string
 
-		etcdMode          bool
-		watchQueryTimeout time.Duration
+		roles         server.RolesConfig
+		raft          server.RaftConfig
+		backup        server.BackupConfig
+		failureDomain uint64
+
+		etcdMode             bool
+		watchQueryTimeout    time.Duration
+		compactInterval      time.Duration
+		pollInterval         time.Duration
+		maxPollInterval      time.Duration
+		writeBatchSize       int
+		watchCacheSize       int
+		watchQueueSize       int
+		readTimeout          time.Duration
+		writeTimeout         time.Duration
+		compactTimeout       time.Duration
+		slowQueryThreshold   time.Duration
+		slowQueryRedactArgs  bool
+		compactBatchSize     int64
+		compactBatchInterval time.Duration
+
+		compactRetentionDuration  time.Duration
+		compactRetentionRevisions int64
+
+		vacuumInterval  time.Duration
+		vacuumMode      string
+		vacuumFreePages int64
+
+		readBarrier bool
+
+		compression          string
+		compressionThreshold int
+
+		chunkSize      int
+		dedupOldValues bool
+
+		events server.EventsConfig
+
+		quotaBackendBytes int64
+		maxRequestBytes   int
+		maxTxnOps         int
+
+		maxInflightRequests int
+		watchCreateRate     float64
+		maxWatchStreams     int
+
+		grpcKeepaliveMinTime     time.Duration
+		grpcKeepaliveInterval    time.Duration
+		grpcKeepaliveTimeout     time.Duration
+		grpcMaxConcurrentStreams uint32
+		grpcMaxRecvMsgSize       int
+		grpcMaxSendMsgSize       int
+
+		watchProgressNotifyInterval time.Duration
+		drainTimeout                time.Duration
+
+		auditLogPath       string
+		auditLogMaxSize    int64
+		auditLogMaxBackups int
+		auditLogRateLimit  float64
+
+		changefeedSink string
+
+		analyzeInterval time.Duration
+		verifyInterval  time.Duration
 	}
 
 	rootCmd = &cobra.Command{
 		Use:   "k8s-dqlite",
 		Short: "Dqlite for Kubernetes",
 		Long:  `Kubernetes datastore based on dqlite`,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			if err := loadConfig(cmd.Flags()); err != nil {
+				return err
+			}
+			return logging.Configure(rootCmdOpts.logFormat, rootCmdOpts.logLevel)
+		},
 		// Uncomment the following line if your bare application
 		// has an action associated with it:
 		Run: func(cmd *cobra.Command, args []string) {
 			if rootCmdOpts.debug {
-				logrus.SetLevel(logrus.TraceLevel)
+				logging.SetLevel(logrus.TraceLevel)
 			}
 
 			if rootCmdOpts.profiling {
@@ -65,17 +166,37 @@ var (
 
 			if rootCmdOpts.otel {
 				var err error
-				logrus.WithField("address", rootCmdOpts.otelAddress).Print("Enable otel endpoint")
-				otelShutdown, err = setupOTelSDK(cmd.Context(), rootCmdOpts.otelAddress)
+				logrus.WithFields(logrus.Fields{
+					"endpoint": rootCmdOpts.otelEndpoint,
+					"protocol": rootCmdOpts.otelProtocol,
+				}).Print("Enable otel exporters")
+				otelShutdown, err = setupOTelSDK(cmd.Context(), otelOptions{
+					endpoint:     rootCmdOpts.otelEndpoint,
+					protocol:     rootCmdOpts.otelProtocol,
+					insecure:     rootCmdOpts.otelInsecure,
+					sampler:      rootCmdOpts.otelSampler,
+					samplingRate: rootCmdOpts.otelSamplingRate,
+					redactKeys:   rootCmdOpts.otelRedactKeys,
+					dir:          rootCmdOpts.dir,
+				})
 				if err != nil {
 					logrus.WithError(err).Warning("Failed to setup OpenTelemetry SDK")
 				}
 			}
 
-			var metricsServer *http.Server
+			var (
+				metricsServer *http.Server
+				adminToken    string
+			)
 
 			if rootCmdOpts.metrics {
-				metricsServer := &http.Server{
+				if rootCmdOpts.metricsAdminTokenFile != "" {
+					var err error
+					if adminToken, err = server.ReadAdminToken(rootCmdOpts.metricsAdminTokenFile); err != nil {
+						logrus.WithError(err).Fatal("Failed to enable admin endpoints")
+					}
+				}
+				metricsServer = &http.Server{
 					Addr:    rootCmdOpts.metricsAddress,
 					Handler: http.NewServeMux(),
 				}
@@ -94,6 +215,16 @@ var (
 				}
 			}
 
+			if rootCmdOpts.joinToken != "" {
+				if err := server.Join(cmd.Context(), rootCmdOpts.dir, rootCmdOpts.joinToken, rootCmdOpts.advertiseAddress); err != nil {
+					logrus.WithError(err).Fatal("Failed to join cluster")
+				}
+			}
+
+			if cmd.Flags().Changed("failure-domain") {
+				rootCmdOpts.roles.FailureDomain = &rootCmdOpts.failureDomain
+			}
+
 			instance, err := server.New(
 				rootCmdOpts.dir,
 				rootCmdOpts.listen,
@@ -101,11 +232,73 @@ var (
 				rootCmdOpts.diskMode,
 				rootCmdOpts.clientSessionCacheSize,
 				rootCmdOpts.minTLSVersion,
+				rootCmdOpts.maxTLSVersion,
+				rootCmdOpts.tlsCipherSuites,
+				rootCmdOpts.peerCertFile,
+				rootCmdOpts.peerKeyFile,
+				rootCmdOpts.peerCAFile,
+				rootCmdOpts.kineCertFile,
+				rootCmdOpts.kineKeyFile,
+				rootCmdOpts.kineMinTLSVersion,
+				rootCmdOpts.clientCAFile,
+				rootCmdOpts.requireClientCert,
 				rootCmdOpts.watchAvailableStorageInterval,
 				rootCmdOpts.watchAvailableStorageMinBytes,
 				rootCmdOpts.lowAvailableStorageAction,
+				rootCmdOpts.roles,
+				rootCmdOpts.raft,
+				rootCmdOpts.backup,
 				rootCmdOpts.connectionPoolConfig,
 				rootCmdOpts.watchQueryTimeout,
+				rootCmdOpts.compactInterval,
+				rootCmdOpts.pollInterval,
+				rootCmdOpts.maxPollInterval,
+				rootCmdOpts.writeBatchSize,
+				rootCmdOpts.watchCacheSize,
+				rootCmdOpts.watchQueueSize,
+				rootCmdOpts.readTimeout,
+				rootCmdOpts.writeTimeout,
+				rootCmdOpts.compactTimeout,
+				rootCmdOpts.slowQueryThreshold,
+				rootCmdOpts.slowQueryRedactArgs,
+				rootCmdOpts.compactBatchSize,
+				rootCmdOpts.compactBatchInterval,
+				rootCmdOpts.compactRetentionDuration,
+				rootCmdOpts.compactRetentionRevisions,
+				rootCmdOpts.vacuumInterval,
+				rootCmdOpts.vacuumMode,
+				rootCmdOpts.vacuumFreePages,
+				rootCmdOpts.readBarrier,
+				rootCmdOpts.compression,
+				rootCmdOpts.compressionThreshold,
+				rootCmdOpts.chunkSize,
+				rootCmdOpts.dedupOldValues,
+				rootCmdOpts.events,
+				rootCmdOpts.quotaBackendBytes,
+				rootCmdOpts.maxRequestBytes,
+				rootCmdOpts.maxTxnOps,
+				rootCmdOpts.maxInflightRequests,
+				rootCmdOpts.watchCreateRate,
+				rootCmdOpts.maxWatchStreams,
+				endpoint.GRPCOptions{
+					KeepaliveMinTime:     rootCmdOpts.grpcKeepaliveMinTime,
+					KeepaliveTime:        rootCmdOpts.grpcKeepaliveInterval,
+					KeepaliveTimeout:     rootCmdOpts.grpcKeepaliveTimeout,
+					MaxConcurrentStreams: rootCmdOpts.grpcMaxConcurrentStreams,
+					MaxRecvMsgSize:       rootCmdOpts.grpcMaxRecvMsgSize,
+					MaxSendMsgSize:       rootCmdOpts.grpcMaxSendMsgSize,
+				},
+				rootCmdOpts.watchProgressNotifyInterval,
+				rootCmdOpts.drainTimeout,
+				audit.Config{
+					Path:       rootCmdOpts.auditLogPath,
+					MaxSize:    rootCmdOpts.auditLogMaxSize * 1024 * 1024,
+					MaxBackups: rootCmdOpts.auditLogMaxBackups,
+					RateLimit:  rootCmdOpts.auditLogRateLimit,
+				},
+				rootCmdOpts.changefeedSink,
+				rootCmdOpts.analyzeInterval,
+				rootCmdOpts.verifyInterval,
 			)
 			if err != nil {
 				logrus.WithError(err).Fatal("Failed to create server")
@@ -116,6 +309,60 @@ var (
 				logrus.WithError(err).Fatal("Server failed to start")
 			}
 
+			if adminToken != "" {
+				if mux, ok := metricsServer.Handler.(*http.ServeMux); ok {
+					logrus.WithField("address", rootCmdOpts.metricsAddress).Print("Enable admin endpoints")
+					instance.HandleAdmin(mux, adminToken)
+				}
+			}
+
+			var healthServer *http.Server
+
+			if rootCmdOpts.health {
+				healthServer = &http.Server{
+					Addr:    rootCmdOpts.healthAddress,
+					Handler: instance.HealthHandler(rootCmdOpts.healthWriteProbe, rootCmdOpts.healthProbeTimeout),
+				}
+				go func() {
+					logrus.WithField("address", rootCmdOpts.healthAddress).Print("Enable health endpoint")
+					if err := healthServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+						logrus.WithError(err).Fatal("Failed to start health endpoint")
+					}
+				}()
+			}
+
+			controlListener, err := server.ListenControl(controlSocketPath(rootCmdOpts.dir, rootCmdOpts.controlSocket))
+			if err != nil {
+				logrus.WithError(err).Fatal("Failed to start control socket")
+			}
+			controlServer := &http.Server{Handler: instance.ControlHandler()}
+			go func() {
+				logrus.WithField("address", controlListener.Addr()).Print("Enable control socket")
+				if err := controlServer.Serve(controlListener); err != nil && err != http.ErrServerClosed {
+					logrus.WithError(err).Fatal("Failed to serve control socket")
+				}
+			}()
+
+			var joinServer *http.Server
+
+			if rootCmdOpts.joinListen != "" {
+				tlsConfig, err := instance.JoinTLSConfig()
+				if err != nil {
+					logrus.WithError(err).Fatal("Failed to start join endpoint")
+				}
+				joinServer = &http.Server{
+					Addr:      rootCmdOpts.joinListen,
+					Handler:   instance.JoinHandler(),
+					TLSConfig: tlsConfig,
+				}
+				go func() {
+					logrus.WithField("address", rootCmdOpts.joinListen).Print("Enable join endpoint")
+					if err := joinServer.ListenAndServeTLS("", ""); err != nil && err != http.ErrServerClosed {
+						logrus.WithError(err).Fatal("Failed to start join endpoint")
+					}
+				}()
+			}
+
 			// Cancel context if we receive an exit signal
 			ch := make(chan os.Signal, 1)
 			signal.Notify(ch, unix.SIGPWR)
@@ -123,19 +370,45 @@ var (
 			signal.Notify(ch, unix.SIGQUIT)
 			signal.Notify(ch, unix.SIGTERM)
 
-			select {
-			case <-ch:
-			case <-instance.MustStop():
+			// Reload the configuration if we receive a hangup signal
+			hup := make(chan os.Signal, 1)
+			signal.Notify(hup, unix.SIGHUP)
+
+		wait:
+			for {
+				select {
+				case <-hup:
+					reload(cmd.Flags(), instance)
+				case <-ch:
+					break wait
+				case <-instance.MustStop():
+					break wait
+				}
 			}
-			cancel()
 
 			// Create a separate context with 30 seconds to cleanup
-			stopCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-			defer cancel()
+			stopCtx, stopCancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer stopCancel()
 
+			if healthServer != nil {
+				if err := healthServer.Shutdown(stopCtx); err != nil {
+					logrus.WithError(err).Warning("Failed to shutdown health endpoint")
+				}
+			}
+			if err := controlServer.Shutdown(stopCtx); err != nil {
+				logrus.WithError(err).Warning("Failed to shutdown control socket")
+			}
+			if joinServer != nil {
+				if err := joinServer.Shutdown(stopCtx); err != nil {
+					logrus.WithError(err).Warning("Failed to shutdown join endpoint")
+				}
+			}
+			// The server hands over its leadership and drains the kine
+			// requests before it stops.
 			if err := instance.Shutdown(stopCtx); err != nil {
 				logrus.WithError(err).Fatal("Failed to shutdown server")
 			}
+			cancel()
 			if rootCmdOpts.otel && otelShutdown != nil {
 				err = errors.Join(err, otelShutdown(stopCtx))
 				if err != nil {
@@ -151,6 +424,31 @@ var (
 	}
 )
 
+// reload reads the configuration file again and applies the settings that
+// can be changed at runtime: the logs, compaction and quota settings, and
+// the cluster certificate. The other settings are applied on restart.
+func reload(flags *pflag.FlagSet, instance *server.Server) {
+	logrus.Print("Reload configuration")
+	if err := readConfigFile(flags); err != nil {
+		logrus.WithError(err).Error("Failed to reload configuration file")
+		return
+	}
+	if err := logging.Configure(rootCmdOpts.logFormat, rootCmdOpts.logLevel); err != nil {
+		logrus.WithError(err).Error("Failed to reload log configuration")
+	}
+	if rootCmdOpts.debug {
+		logging.SetLevel(logrus.TraceLevel)
+	}
+	if err := instance.Reload(
+		rootCmdOpts.compactInterval,
+		rootCmdOpts.compactRetentionDuration,
+		rootCmdOpts.compactRetentionRevisions,
+		rootCmdOpts.quotaBackendBytes,
+	); err != nil {
+		logrus.WithError(err).Error("Failed to reload server")
+	}
+}
+
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the liteCmd.
 func Execute() {
@@ -160,30 +458,140 @@ func Execute() {
 }
 
 func init() {
+	rootCmd.Flags().StringVar(&rootCmdOpts.config, "config", "", "YAML file setting the flags by name, such as storage-dir: /var/lib/k8s-dqlite. Flags are also set by K8S_DQLITE_* environment variables, such as K8S_DQLITE_STORAGE_DIR. The command line takes precedence over the environment, which takes precedence over the file.")
 	rootCmd.Flags().StringVar(&rootCmdOpts.dir, "storage-dir", "/var/tmp/k8s-dqlite", "directory with the dqlite datastore")
 	rootCmd.Flags().StringVar(&rootCmdOpts.listen, "listen", "tcp://127.0.0.1:12379", "endpoint where dqlite should listen to")
 	rootCmd.Flags().BoolVar(&rootCmdOpts.tls, "enable-tls", true, "enable TLS")
 	rootCmd.Flags().BoolVar(&rootCmdOpts.debug, "debug", false, "debug logs")
+	rootCmd.PersistentFlags().StringVar(&rootCmdOpts.logFormat, "log-format", "text", "format of the logs (text|json)")
+	rootCmd.PersistentFlags().StringVar(&rootCmdOpts.logLevel, "log-level", "info", "level of the logs (panic|fatal|error|warn|info|debug|trace). --debug takes precedence.")
 	rootCmd.Flags().BoolVar(&rootCmdOpts.profiling, "profiling", false, "enable debug pprof endpoint")
 	rootCmd.Flags().StringVar(&rootCmdOpts.profilingAddress, "profiling-listen", "127.0.0.1:4000", "listen address for pprof endpoint")
 	rootCmd.Flags().BoolVar(&rootCmdOpts.diskMode, "disk-mode", false, "(experimental) run dqlite store in disk mode")
 	rootCmd.Flags().UintVar(&rootCmdOpts.clientSessionCacheSize, "tls-client-session-cache-size", 0, "ClientCacheSession size for dial TLS config")
 	rootCmd.Flags().StringVar(&rootCmdOpts.minTLSVersion, "min-tls-version", "tls12", "Minimum TLS version for dqlite endpoint (tls10|tls11|tls12|tls13). Default is tls12")
+	rootCmd.Flags().StringVar(&rootCmdOpts.maxTLSVersion, "max-tls-version", "", "Maximum TLS version for the dqlite and kine endpoints (tls10|tls11|tls12|tls13). If empty, the latest version is allowed.")
+	rootCmd.Flags().StringSliceVar(&rootCmdOpts.tlsCipherSuites, "tls-cipher-suites", nil, "comma separated list of the TLS 1.2 cipher suites allowed for the dqlite and kine endpoints, such as TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256. If empty, the Go defaults are used. The TLS 1.3 cipher suites are not configurable.")
+	rootCmd.Flags().StringVar(&rootCmdOpts.peerCertFile, "peer-cert-file", "", "certificate of the dqlite traffic between the nodes. If empty, cluster.crt in the storage directory is used.")
+	rootCmd.Flags().StringVar(&rootCmdOpts.peerKeyFile, "peer-key-file", "", "key of --peer-cert-file. If empty, cluster.key in the storage directory is used.")
+	rootCmd.Flags().StringVar(&rootCmdOpts.peerCAFile, "peer-ca-file", "", "CA verifying the certificates of the other nodes. If empty, the peer certificate is used as the CA.")
+	rootCmd.Flags().StringVar(&rootCmdOpts.kineCertFile, "kine-cert-file", "", "certificate of the kine endpoint. If set, the kine endpoint serves TLS. If empty, the peer certificate is used when the kine endpoint serves TLS. Requires --enable-tls.")
+	rootCmd.Flags().StringVar(&rootCmdOpts.kineKeyFile, "kine-key-file", "", "key of --kine-cert-file")
+	rootCmd.Flags().StringVar(&rootCmdOpts.kineMinTLSVersion, "kine-min-tls-version", "", "Minimum TLS version for the kine endpoint (tls10|tls11|tls12|tls13). If empty, --min-tls-version is used.")
+	rootCmd.Flags().StringVar(&rootCmdOpts.clientCAFile, "client-ca-file", "", "CA file verifying the certificates of the clients of the kine endpoint. If set, the kine endpoint serves TLS. Requires --enable-tls.")
+	rootCmd.Flags().BoolVar(&rootCmdOpts.requireClientCert, "require-client-cert", false, "refuse the clients of the kine endpoint without a certificate signed by --client-ca-file, as etcd's --client-cert-auth")
 	rootCmd.Flags().BoolVar(&rootCmdOpts.metrics, "metrics", false, "enable metrics endpoint")
-	rootCmd.Flags().BoolVar(&rootCmdOpts.otel, "otel", false, "enable traces endpoint")
-	rootCmd.Flags().StringVar(&rootCmdOpts.otelAddress, "otel-listen", "127.0.0.1:4317", "listen address for OpenTelemetry endpoint")
+	rootCmd.Flags().BoolVar(&rootCmdOpts.otel, "otel", false, "enable the export of traces and metrics to an OpenTelemetry collector")
+	rootCmd.Flags().StringVar(&rootCmdOpts.otelEndpoint, "otel-endpoint", "127.0.0.1:4317", "address of the OpenTelemetry collector")
+	rootCmd.Flags().StringVar(&rootCmdOpts.otelProtocol, "otel-protocol", "grpc", "OTLP protocol used to export to the OpenTelemetry collector (grpc|http)")
+	rootCmd.Flags().BoolVar(&rootCmdOpts.otelInsecure, "otel-insecure", true, "export to the OpenTelemetry collector without TLS")
+	rootCmd.Flags().StringVar(&rootCmdOpts.otelSampler, "otel-sampler", "parentbased_traceidratio", "trace sampler (parentbased_traceidratio|traceidratio). parentbased_traceidratio respects the sampling decisions of the callers.")
+	rootCmd.Flags().Float64Var(&rootCmdOpts.otelSamplingRate, "otel-sampling-rate", 1, "fraction of the traces sampled, between 0 and 1")
+	rootCmd.Flags().BoolVar(&rootCmdOpts.otelRedactKeys, "otel-redact-keys", false, "strip the key names from the exported span attributes")
 	rootCmd.Flags().StringVar(&rootCmdOpts.metricsAddress, "metrics-listen", "127.0.0.1:9042", "listen address for metrics endpoint")
+	rootCmd.Flags().StringVar(&rootCmdOpts.metricsAdminTokenFile, "metrics-admin-token-file", "", "file holding the bearer token of the read-only admin endpoints served with the metrics endpoint. If empty, the admin endpoints are disabled.")
+	rootCmd.Flags().BoolVar(&rootCmdOpts.health, "health", false, "enable the /livez, /readyz and /healthz endpoints")
+	rootCmd.Flags().StringVar(&rootCmdOpts.healthAddress, "health-listen", "127.0.0.1:9043", "listen address for the health endpoints")
+	rootCmd.Flags().BoolVar(&rootCmdOpts.healthWriteProbe, "health-write-probe", false, "check that the datastore accepts writes in /readyz and /healthz, by writing the /k8s-dqlite/health key")
+	rootCmd.Flags().DurationVar(&rootCmdOpts.healthProbeTimeout, "health-probe-timeout", 5*time.Second, "timeout of each probe of the health endpoints")
+	rootCmd.Flags().StringVar(&rootCmdOpts.joinToken, "join-token", "", "token created with 'k8s-dqlite token create' on a node of the cluster, to join the cluster on the first start")
+	rootCmd.Flags().StringVar(&rootCmdOpts.advertiseAddress, "advertise-address", "", "dqlite address of the node joining the cluster with --join-token, as host:port")
+	rootCmd.Flags().StringVar(&rootCmdOpts.controlSocket, "control-socket", "", "path of the control socket of the node. If empty, control.sock in the storage directory is used.")
+	rootCmd.Flags().StringVar(&rootCmdOpts.joinListen, "join-listen", "", "listen address of the join endpoint, serving the nodes joining with a token. If empty, the join endpoint is disabled. Requires --enable-tls.")
 	rootCmd.Flags().IntVar(&rootCmdOpts.connectionPoolConfig.MaxIdle, "datastore-max-idle-connections", 5, "Maximum number of idle connections retained by datastore. If value = 0, the system default will be used. If value < 0, idle connections will not be reused.")
 	rootCmd.Flags().IntVar(&rootCmdOpts.connectionPoolConfig.MaxOpen, "datastore-max-open-connections", 5, "Maximum number of open connections used by datastore. If value <= 0, then there is no limit")
 	rootCmd.Flags().DurationVar(&rootCmdOpts.connectionPoolConfig.MaxLifetime, "datastore-connection-max-lifetime", 60*time.Second, "Maximum amount of time a connection may be reused. If value <= 0, then there is no limit.")
 	rootCmd.Flags().DurationVar(&rootCmdOpts.connectionPoolConfig.MaxIdleTime, "datastore-connection-max-idle-time", 0*time.Second, "Maximum amount of time a connection may be idle before being closed. If value <= 0, then there is no limit.")
 	rootCmd.Flags().DurationVar(&rootCmdOpts.watchAvailableStorageInterval, "watch-storage-available-size-interval", 5*time.Second, "Interval to check if the disk is running low on space. Set to 0 to disable the periodic disk size check")
 	rootCmd.Flags().Uint64Var(&rootCmdOpts.watchAvailableStorageMinBytes, "watch-storage-available-size-min-bytes", 10*1024*1024, "Minimum required available disk size (in bytes) to continue operation. If available disk space gets below this threshold, then the --low-available-storage-action is performed")
-	rootCmd.Flags().StringVar(&rootCmdOpts.lowAvailableStorageAction, "low-available-storage-action", "none", "Action to perform in case the available storage is low. One of (none|handover|terminate). none means no action is performed. handover means the dqlite node will handover its leadership role, if any. terminate means this dqlite node will shutdown")
+	rootCmd.Flags().StringVar(&rootCmdOpts.lowAvailableStorageAction, "low-available-storage-action", "none", "Action to perform in case the available storage is low. One of (none|handover|terminate|reclaim|reclaim,handover|reclaim,terminate). none means no action is performed. handover means the dqlite node will handover its leadership role, if any. terminate means this dqlite node will shutdown. reclaim means the datastore is compacted up to the current revision and vacuumed, at most every 10 minutes, before performing the action that follows, if space is still low")
+	rootCmd.Flags().IntVar(&rootCmdOpts.roles.Voters, "voters", 0, "Number of voters of the dqlite cluster, which must be odd and the same on all the nodes. If value = 0, 3 voters are used.")
+	rootCmd.Flags().IntVar(&rootCmdOpts.roles.StandBys, "standbys", 0, "Number of stand-bys of the dqlite cluster, which must be the same on all the nodes. If value = 0, 3 stand-bys are used.")
+	rootCmd.Flags().DurationVar(&rootCmdOpts.roles.AdjustmentFrequency, "roles-adjustment-frequency", 0*time.Second, "Interval between the adjustments of the roles of the nodes by the dqlite leader. If value = 0, the roles are adjusted every 30 seconds.")
+	rootCmd.Flags().Uint64Var(&rootCmdOpts.failureDomain, "failure-domain", 0, "Failure domain of the node, such as its availability zone. The voters are spread across the failure domains. If not set, the failure domain is read from the failure-domain file of the storage directory.")
+	rootCmd.Flags().Uint64Var(&rootCmdOpts.roles.Weight, "weight", 0, "Weight of the node. Within a failure domain, the nodes with a higher weight are promoted last and demoted first.")
+	rootCmd.Flags().DurationVar(&rootCmdOpts.roles.EvictUnreachableAfter, "evict-unreachable-after", 0*time.Second, "Time after which the dqlite leader removes the unreachable nodes from the cluster. If value <= 0, the unreachable nodes are never removed.")
+	rootCmd.Flags().DurationVar(&rootCmdOpts.raft.ElectionTimeout, "raft-election-timeout", 0*time.Second, "Time after which the dqlite followers which did not hear from the leader start an election. The heartbeat interval is a tenth of it. If value = 0, the dqlite default is used. The network-latency setting of tuning.yaml takes precedence.")
+	rootCmd.Flags().DurationVar(&rootCmdOpts.raft.HeartbeatInterval, "raft-heartbeat-interval", 0*time.Second, "Interval between the heartbeats of the dqlite leader. The election timeout is ten times longer. If value = 0, the dqlite default is used. The network-latency setting of tuning.yaml takes precedence.")
+	rootCmd.Flags().Uint64Var(&rootCmdOpts.raft.SnapshotThreshold, "raft-snapshot-threshold", 0, "Number of raft log entries after which dqlite takes a snapshot. If value = 0, 1024 entries are used. The snapshot setting of tuning.yaml takes precedence.")
+	rootCmd.Flags().Uint64Var(&rootCmdOpts.raft.SnapshotTrailing, "raft-snapshot-trailing", 0, "Number of raft log entries kept after a snapshot, from which the lagging nodes catch up. If value = 0, 8192 entries are used. The snapshot setting of tuning.yaml takes precedence.")
+
+	rootCmd.Flags().DurationVar(&rootCmdOpts.backup.Interval, "backup-interval", 0, "Interval between the backups stored by the dqlite leader on the backup target. If value = 0, no backups are scheduled.")
+	rootCmd.Flags().IntVar(&rootCmdOpts.backup.Retention, "backup-retention", 0, "Number of scheduled backups kept on the backup target, the oldest being deleted. If value = 0, all of them are kept.")
+	rootCmd.Flags().StringVar(&rootCmdOpts.backup.Target, "backup-target", "", "Storage of the scheduled backups, as file:///path/to/dir or s3://bucket/prefix. The S3 credentials are read from the AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY environment variables.")
 	rootCmd.Flags().DurationVar(&rootCmdOpts.watchQueryTimeout, "watch-query-timeout", 20*time.Second, "Timeout for querying events in the watch poll loop. If timeout is reached, the poll loop will be re-triggered. The minimum value is 5 seconds.")
+	rootCmd.Flags().DurationVar(&rootCmdOpts.compactInterval, "compact-interval", 5*time.Minute, "Interval between compactions of the datastore. The compact-interval setting of tuning.yaml takes precedence.")
+	rootCmd.Flags().DurationVar(&rootCmdOpts.pollInterval, "poll-interval", 1*time.Second, "Interval between the polls of the watch loop for new events. The kine-poll-interval setting of tuning.yaml takes precedence.")
+	rootCmd.Flags().DurationVar(&rootCmdOpts.maxPollInterval, "max-poll-interval", 5*time.Second, "Interval up to which the watch poll loop backs off while no events are found. If value <= poll-interval, the poll interval is fixed. The kine-max-poll-interval setting of tuning.yaml takes precedence.")
+	rootCmd.Flags().IntVar(&rootCmdOpts.writeBatchSize, "write-batch-size", 0, "Maximum number of concurrent writes committed in a single transaction. If value < 2, writes are not batched.")
+	rootCmd.Flags().IntVar(&rootCmdOpts.watchCacheSize, "watch-cache-size", 1000, "Number of recent events kept in memory for the watches catching up. If value < 0, no events are kept.")
+	rootCmd.Flags().IntVar(&rootCmdOpts.watchQueueSize, "watch-queue-size", 100, "Number of events queued for each watch. The watches falling further behind are canceled, so that clients re-establish them.")
+	rootCmd.Flags().DurationVar(&rootCmdOpts.readTimeout, "query-timeout-read", 0*time.Second, "Timeout of the read queries of the datastore. If value <= 0, reads are not bounded.")
+	rootCmd.Flags().DurationVar(&rootCmdOpts.writeTimeout, "query-timeout-write", 0*time.Second, "Timeout of the writes to the datastore, including their wait for the write queue. If value <= 0, writes are not bounded.")
+	rootCmd.Flags().DurationVar(&rootCmdOpts.compactTimeout, "query-timeout-compact", 0*time.Second, "Timeout of each compaction batch. If value <= 0, compaction batches are not bounded.")
+	rootCmd.Flags().DurationVar(&rootCmdOpts.slowQueryThreshold, "slow-query-threshold", 1*time.Second, "Duration after which the queries to the datastore are logged as slow. If value <= 0, slow queries are not logged.")
+	rootCmd.Flags().BoolVar(&rootCmdOpts.slowQueryRedactArgs, "slow-query-redact-args", false, "Hide the arguments of the slow queries in the logs.")
+	rootCmd.Flags().Int64Var(&rootCmdOpts.compactBatchSize, "compact-batch-size", 1000, "Maximum number of revisions removed in a single compaction transaction. If value <= 0, batches of 1000 revisions are used.")
+	rootCmd.Flags().DurationVar(&rootCmdOpts.compactBatchInterval, "compact-batch-interval", 0*time.Second, "Pause between two consecutive compaction batches. If value <= 0, batches are run back to back.")
+	rootCmd.Flags().DurationVar(&rootCmdOpts.compactRetentionDuration, "compact-retention-duration", 0*time.Second, "Enable periodic compaction, retaining the revisions created in the given time window. If value <= 0, periodic compaction is disabled.")
+	rootCmd.Flags().Int64Var(&rootCmdOpts.compactRetentionRevisions, "compact-retention-revisions", 0, "Enable revision compaction, retaining the given number of most recent revisions. If value <= 0, revision compaction is disabled.")
+	rootCmd.Flags().DurationVar(&rootCmdOpts.vacuumInterval, "vacuum-interval", 0*time.Second, "Interval between scheduled vacuums of the datastore, returning unused pages to the file system, in the mode of --vacuum-mode. If value <= 0, scheduled vacuums are disabled.")
+	rootCmd.Flags().StringVar(&rootCmdOpts.vacuumMode, "vacuum-mode", "full", "Vacuum mode of the scheduled vacuums and the datastore defragmentation (full|incremental). full rebuilds the whole database file. incremental only releases the free pages, but requires a one-off full vacuum to be enabled, which is only done on new dqlite databases.")
+	rootCmd.Flags().Int64Var(&rootCmdOpts.vacuumFreePages, "vacuum-free-pages", 0, "Minimum number of free pages in the datastore for a scheduled vacuum to run.")
+	rootCmd.Flags().BoolVar(&rootCmdOpts.readBarrier, "read-barrier", false, "Commit a read barrier through raft before the linearizable reads, so that a node that lost the leadership never serves stale data.")
+	rootCmd.Flags().StringVar(&rootCmdOpts.compression, "compression", "none", "Compression of the values written to the datastore (none|zstd|snappy). The values already written are read whatever the compression.")
+	rootCmd.Flags().IntVar(&rootCmdOpts.compressionThreshold, "compression-threshold", 1024, "Minimum size (in bytes) of the values compressed.")
+	rootCmd.Flags().IntVar(&rootCmdOpts.chunkSize, "chunk-size", 0, "Maximum size (in bytes) of the values stored whole. The larger values are stored in chunks of this size, each written through raft on its own. If value <= 0, the values are always stored whole.")
+	rootCmd.Flags().BoolVar(&rootCmdOpts.dedupOldValues, "dedup-old-values", false, "Stop storing the previous value of each update and deletion, which is read back from the previous revision instead. The previous values already stored are cleared over the compactions.")
+	rootCmd.Flags().BoolVar(&rootCmdOpts.events.Enabled, "events-table", false, "Store the events of Kubernetes (the keys under /registry/events/) in a table of their own, compacted on the schedule of the events-compact-* flags. The table is in the database of the datastore and shares its revisions. Must be set alike on all the nodes.")
+	rootCmd.Flags().DurationVar(&rootCmdOpts.events.CompactInterval, "events-compact-interval", 1*time.Minute, "Interval between compactions of the events table. If value <= 0, compact-interval is used.")
+	rootCmd.Flags().DurationVar(&rootCmdOpts.events.CompactRetentionDuration, "events-compact-retention-duration", 0*time.Second, "Retain the revisions of the events created in the given time window. If value <= 0, only the most recent revisions of the events are retained.")
+	rootCmd.Flags().Int64Var(&rootCmdOpts.quotaBackendBytes, "quota-backend-bytes", 0, "Size of the datastore (in bytes) after which a NOSPACE alarm is raised and writes are refused until the alarm is disarmed. If value <= 0, no quota is enforced.")
+	rootCmd.Flags().IntVar(&rootCmdOpts.maxRequestBytes, "max-request-bytes", kine_server.DefaultMaxRequestBytes, "Maximum size (in bytes) of the write requests, which are refused with the etcd 'request is too large' error otherwise. If value <= 0, the size is not limited.")
+	rootCmd.Flags().IntVar(&rootCmdOpts.maxTxnOps, "max-txn-ops", kine_server.DefaultMaxTxnOps, "Maximum number of compares, and of operations in each branch, of a transaction, which is refused with the etcd 'too many operations in txn request' error otherwise. If value <= 0, the number is not limited.")
+	rootCmd.Flags().IntVar(&rootCmdOpts.maxInflightRequests, "max-inflight-requests", 0, "Maximum number of unary requests served at once, over which requests are refused with the etcd 'too many requests' error. If value <= 0, the number is not limited.")
+	rootCmd.Flags().Float64Var(&rootCmdOpts.watchCreateRate, "watch-create-rate", 0, "Maximum number of watches created per second, with bursts of as many, over which watches are canceled with the etcd 'too many requests' error. If value <= 0, the rate is not limited.")
+	rootCmd.Flags().IntVar(&rootCmdOpts.maxWatchStreams, "max-watch-streams", 0, "Maximum number of watch streams served at once, over which streams are refused with the etcd 'too many requests' error. If value <= 0, the number is not limited.")
+	rootCmd.Flags().DurationVar(&rootCmdOpts.grpcKeepaliveMinTime, "grpc-keepalive-min-time", 5*time.Second, "Minimum interval between the keepalive pings of the clients, which are disconnected if they ping more often.")
+	rootCmd.Flags().DurationVar(&rootCmdOpts.grpcKeepaliveInterval, "grpc-keepalive-interval", 2*time.Hour, "Interval after which the server pings an idle client connection. Lower it below the idle timeout of the load balancers in front of the server, so that they do not silently drop the watch streams.")
+	rootCmd.Flags().DurationVar(&rootCmdOpts.grpcKeepaliveTimeout, "grpc-keepalive-timeout", 20*time.Second, "Time to wait for the reply to a keepalive ping before closing the client connection.")
+	rootCmd.Flags().Uint32Var(&rootCmdOpts.grpcMaxConcurrentStreams, "grpc-max-concurrent-streams", 0, "Maximum number of concurrent streams, such as watch streams, of each client connection. If value is 0, the number is not limited.")
+	rootCmd.Flags().IntVar(&rootCmdOpts.grpcMaxRecvMsgSize, "grpc-max-recv-msg-size", 0, "Maximum size (in bytes) of the messages received. If value <= 0, it is the value of --max-request-bytes plus 512KiB of gRPC overhead.")
+	rootCmd.Flags().IntVar(&rootCmdOpts.grpcMaxSendMsgSize, "grpc-max-send-msg-size", 0, "Maximum size (in bytes) of the messages sent. If value <= 0, the size is not limited.")
+	rootCmd.Flags().DurationVar(&rootCmdOpts.drainTimeout, "drain-timeout", 10*time.Second, "time to wait on shutdown for the kine requests being served to complete, after handing over the dqlite leadership")
+	rootCmd.Flags().DurationVar(&rootCmdOpts.watchProgressNotifyInterval, "watch-progress-notify-interval", 10*time.Minute, "Interval between the progress notifications sent to the watches that request them. If value <= 0, no periodic progress notifications are sent.")
+	rootCmd.Flags().StringVar(&rootCmdOpts.auditLogPath, "audit-log-path", "", "File recording the writes to the datastore, or syslog to send them to syslog. If empty, writes are not audited.")
+	rootCmd.Flags().Int64Var(&rootCmdOpts.auditLogMaxSize, "audit-log-max-size", 100, "Size in megabytes after which the audit log file is rotated. If value <= 0, the file is never rotated.")
+	rootCmd.Flags().IntVar(&rootCmdOpts.auditLogMaxBackups, "audit-log-max-backups", 5, "Number of rotated audit log files kept.")
+	rootCmd.Flags().Float64Var(&rootCmdOpts.auditLogRateLimit, "audit-log-rate-limit", 0, "Maximum number of audit entries recorded per second. The entries over the limit are dropped and counted in the next entry. If value <= 0, there is no limit.")
+
+	rootCmd.Flags().StringVar(&rootCmdOpts.changefeedSink, "changefeed-sink", "", "File, or http(s) webhook URL, to which the changes of the datastore are exported as JSON lines. If empty, the changes are not exported.")
+
+	rootCmd.Flags().DurationVar(&rootCmdOpts.analyzeInterval, "analyze-interval", 0, "Interval between the updates of the metrics of the number and size of the keys of each top-level prefix, reported by the dqlite leader. If value = 0, the metrics are disabled.")
+	rootCmd.Flags().DurationVar(&rootCmdOpts.verifyInterval, "verify-interval", 0, "Interval between the background verifications of the integrity of the datastore, run by the dqlite leader, which raise the k8s_dqlite_corruption_alarm metric on corruption. If value = 0, the background verifications are disabled.")
+
+	rootCmd.Flags().SetNormalizeFunc(normalizeFlagName)
 
 	rootCmd.AddCommand(&cobra.Command{
 		Use:  "version",
 		RunE: func(cmd *cobra.Command, args []string) error { return printVersions() },
 	})
 }
+
+// flagAliases are the alternative names of the flags: the connection pool
+// flags matching the database/sql connection pool settings, and the former
+// names of renamed flags.
+var flagAliases = map[string]string{
+	"otel-listen":           "otel-endpoint",
+	"db-max-open-conns":     "datastore-max-open-connections",
+	"db-max-idle-conns":     "datastore-max-idle-connections",
+	"db-conn-max-lifetime":  "datastore-connection-max-lifetime",
+	"db-conn-max-idle-time": "datastore-connection-max-idle-time",
+}
+
+func normalizeFlagName(f *pflag.FlagSet, name string) pflag.NormalizedName {
+	if alias, ok := flagAliases[name]; ok {
+		name = alias
+	}
+	return pflag.NormalizedName(name)
+}
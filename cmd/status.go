@@ -0,0 +1,101 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/canonical/k8s-dqlite/pkg/server"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var (
+	statusCmdOpts struct {
+		watches     bool
+		connections bool
+		json        bool
+	}
+
+	statusCmd = &cobra.Command{
+		Use:   "status",
+		Short: "Report the status of the node and its cluster",
+		Long: `
+Report the ID, address and role of the node running on this host, the dqlite
+leader and members, the current revision and size of the datastore, and the
+number of watches and client connections the node serves. With --watches or
+--connections, list the watches or the client connections instead.
+
+		k8s-dqlite status --storage-dir [dir with the dqlite datastore]
+		k8s-dqlite status --storage-dir [dir with the dqlite datastore] --watches
+
+`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			var (
+				result any
+				err    error
+			)
+			c := controlClient()
+			switch {
+			case statusCmdOpts.watches:
+				result, err = c.Watches(cmd.Context())
+			case statusCmdOpts.connections:
+				result, err = c.Connections(cmd.Context())
+			default:
+				result, err = c.Status(cmd.Context())
+			}
+			if err != nil {
+				logrus.WithError(err).Fatal("Failed to get status")
+			}
+			if statusCmdOpts.json {
+				encoder := json.NewEncoder(os.Stdout)
+				encoder.SetIndent("", "  ")
+				if err := encoder.Encode(result); err != nil {
+					logrus.WithError(err).Fatal("Failed to print status")
+				}
+				return
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			switch result := result.(type) {
+			case []server.Watch:
+				fmt.Fprintln(w, "ID\tKEY\tSTART REVISION\tPEER\tAGE")
+				for _, watch := range result {
+					fmt.Fprintf(w, "%d\t%s\t%d\t%s\t%v\n", watch.ID, watch.Key, watch.StartRevision, watch.Peer, time.Since(watch.Started).Round(time.Second))
+				}
+			case []server.Connection:
+				fmt.Fprintln(w, "REMOTE\tREQUESTS\tAGE")
+				for _, connection := range result {
+					fmt.Fprintf(w, "%s\t%d\t%v\n", connection.Remote, connection.Requests, time.Since(connection.Connected).Round(time.Second))
+				}
+			case server.Status:
+				fmt.Fprintf(w, "ID:\t%d\n", result.ID)
+				fmt.Fprintf(w, "Address:\t%s\n", result.Address)
+				fmt.Fprintf(w, "Role:\t%s\n", result.Role)
+				if result.Leader != nil {
+					fmt.Fprintf(w, "Leader:\t%d (%s)\n", result.Leader.ID, result.Leader.Address)
+				} else {
+					fmt.Fprintln(w, "Leader:\tnone")
+				}
+				fmt.Fprintf(w, "Members:\t%d\n", len(result.Members))
+				fmt.Fprintf(w, "Revision:\t%d\n", result.Revision)
+				fmt.Fprintf(w, "Database size:\t%d\n", result.DatabaseSize)
+				fmt.Fprintf(w, "Watches:\t%d\n", result.Watches)
+				fmt.Fprintf(w, "Connections:\t%d\n", result.Connections)
+			}
+			w.Flush()
+		},
+	}
+)
+
+func init() {
+	addControlFlags(statusCmd)
+	statusCmd.Flags().BoolVar(&statusCmdOpts.watches, "watches", false, "list the watches served by the node")
+	statusCmd.Flags().BoolVar(&statusCmdOpts.connections, "connections", false, "list the client connections of the node")
+	statusCmd.Flags().BoolVar(&statusCmdOpts.json, "json", false, "print the status as JSON")
+	statusCmd.MarkFlagsMutuallyExclusive("watches", "connections")
+	rootCmd.AddCommand(statusCmd)
+}
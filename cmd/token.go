@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/canonical/k8s-dqlite/pkg/server"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var (
+	tokenCreateCmdOpts struct {
+		dir          string
+		peerCertFile string
+		joinAddress  string
+		ttl          time.Duration
+	}
+
+	tokenCmd = &cobra.Command{
+		Use:   "token",
+		Short: "Manage the tokens of the nodes joining the cluster",
+	}
+
+	tokenCreateCmd = &cobra.Command{
+		Use:   "create",
+		Short: "Create a token for a node to join the cluster",
+		Long: `
+Create a token with which a node joins the cluster, by starting it with
+--join-token. The token must be created on a node serving the join endpoint
+(--join-listen), and is valid once, until it expires.
+
+		k8s-dqlite token create --storage-dir [dir with the dqlite datastore] --join-address [address of the join endpoint]
+
+`,
+		Run: func(cmd *cobra.Command, args []string) {
+			if tokenCreateCmdOpts.joinAddress == "" {
+				logrus.Fatal("--join-address is required")
+			}
+			token, err := server.CreateJoinToken(tokenCreateCmdOpts.dir, tokenCreateCmdOpts.peerCertFile, tokenCreateCmdOpts.joinAddress, tokenCreateCmdOpts.ttl)
+			if err != nil {
+				logrus.WithError(err).Fatal("Failed to create join token")
+			}
+			fmt.Println(token)
+		},
+	}
+)
+
+func init() {
+	tokenCreateCmd.Flags().StringVar(&tokenCreateCmdOpts.dir, "storage-dir", "/var/tmp/k8s-dqlite", "directory with the dqlite datastore")
+	tokenCreateCmd.Flags().StringVar(&tokenCreateCmdOpts.peerCertFile, "peer-cert-file", "", "certificate of the join endpoint, as --peer-cert-file of the node. If empty, cluster.crt in the storage directory is used.")
+	tokenCreateCmd.Flags().StringVar(&tokenCreateCmdOpts.joinAddress, "join-address", "", "address of the join endpoint of the node, reachable from the joining node")
+	tokenCreateCmd.Flags().DurationVar(&tokenCreateCmdOpts.ttl, "ttl", time.Hour, "validity of the token")
+	tokenCmd.AddCommand(tokenCreateCmd)
+	rootCmd.AddCommand(tokenCmd)
+}
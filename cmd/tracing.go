@@ -4,135 +4,234 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
 
 	"github.com/sirupsen/logrus"
 	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
 	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
-	"go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
 	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
 	"go.opentelemetry.io/otel/sdk/resource"
-	"go.opentelemetry.io/otel/sdk/trace"
 	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
-	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
+	"gopkg.in/yaml.v2"
 )
 
 var (
 	resourceName = "k8s-dqlite"
 )
 
+// otelOptions configure the OTLP exporters of the traces and metrics.
+type otelOptions struct {
+	// endpoint is the address of the OpenTelemetry collector.
+	endpoint string
+	// protocol is the OTLP transport, grpc or http.
+	protocol string
+	// insecure disables TLS towards the collector.
+	insecure bool
+	// sampler is the trace sampler, parentbased_traceidratio or
+	// traceidratio.
+	sampler string
+	// samplingRate is the fraction of the traces that are sampled.
+	samplingRate float64
+	// redactKeys strips the key names from the exported span attributes.
+	redactKeys bool
+	// dir is the storage directory, used to identify the node.
+	dir string
+}
+
 // setupOTelSDK bootstraps the OpenTelemetry pipeline.
 // If it does not return an error, make sure to call shutdown for proper cleanup.
-func setupOTelSDK(ctx context.Context, otelEndpoint string) (shutdown func(context.Context) error, err error) {
-	conn, err := initConn(otelEndpoint)
+func setupOTelSDK(ctx context.Context, opts otelOptions) (shutdown func(context.Context) error, err error) {
+	if opts.protocol != "grpc" && opts.protocol != "http" {
+		return nil, fmt.Errorf("unsupported OTLP protocol %q, must be grpc or http", opts.protocol)
+	}
+	sampler, err := newSampler(opts.sampler, opts.samplingRate)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create gRPC connection to collector: %w", err)
+		return nil, err
 	}
 
 	res, err := resource.New(ctx,
+		resource.WithFromEnv(),
+		resource.WithHost(),
 		resource.WithAttributes(
 			semconv.ServiceNameKey.String(resourceName),
+			semconv.ServiceInstanceIDKey.String(nodeID(opts.dir)),
 		),
 	)
 	if err != nil {
 		logrus.WithError(err).Warning("Otel failed to create resource")
 	}
 
-	traceExporter, err := newTraceExporter(ctx, conn)
+	traceExporter, err := newTraceExporter(ctx, opts)
 	if err != nil {
-		connErr := conn.Close()
-		if connErr != nil {
-			logrus.WithError(connErr).Warning("Failed to shut down otel gRPC connection")
-		}
-		return nil, fmt.Errorf("failed to create trace exporter: %w", err)
+		return nil, err
 	}
+	if opts.redactKeys {
+		traceExporter = &redactingExporter{SpanExporter: traceExporter}
+	}
+	tracerProvider := newTraceProvider(traceExporter, res, sampler)
 
-	tracerProvider := newTraceProvider(traceExporter, res)
-	otel.SetTracerProvider(tracerProvider)
-
-	meterExporter, err := newMeterExporter(ctx, conn)
+	meterExporter, err := newMeterExporter(ctx, opts)
 	if err != nil {
-		var shutdownErrs error
-		shutdownErr := tracerProvider.Shutdown(ctx)
-		if shutdownErr != nil {
-			shutdownErrs = errors.Join(shutdownErrs, shutdownErr)
-		}
-		shutdownErr = conn.Close()
-		if shutdownErr != nil {
-			shutdownErrs = errors.Join(shutdownErrs, shutdownErr)
-		}
-		if shutdownErrs != nil {
-			logrus.WithError(shutdownErrs).Warning("Failed to shutdown OpenTelemetry SDK")
-			return nil, fmt.Errorf("failed to create meter provider: %w", err)
+		if shutdownErr := tracerProvider.Shutdown(ctx); shutdownErr != nil {
+			logrus.WithError(shutdownErr).Warning("Failed to shutdown OpenTelemetry SDK")
 		}
+		return nil, err
 	}
-	meterProvider, err := newMeterProvider(meterExporter, res)
+	meterProvider := newMeterProvider(meterExporter, res)
+
+	otel.SetTracerProvider(tracerProvider)
 	otel.SetMeterProvider(meterProvider)
 
 	shutdown = func(ctx context.Context) error {
-		var shutdownErrs error
-		err = meterProvider.Shutdown(ctx)
-		if err != nil {
-			shutdownErrs = errors.Join(shutdownErrs, err)
-		}
-		err = tracerProvider.Shutdown(ctx)
-		if err != nil {
-			shutdownErrs = errors.Join(shutdownErrs, err)
-		}
-		err = conn.Close()
-		if err != nil {
-			shutdownErrs = errors.Join(shutdownErrs, err)
-		}
-		return shutdownErrs
+		return errors.Join(meterProvider.Shutdown(ctx), tracerProvider.Shutdown(ctx))
 	}
 	return shutdown, nil
 }
 
-func initConn(otelEndpoint string) (*grpc.ClientConn, error) {
-	// It connects the OpenTelemetry Collector through local gRPC connection.
-	conn, err := grpc.NewClient(otelEndpoint,
-		// Note the use of insecure transport here. TLS is recommended in production.
-		grpc.WithTransportCredentials(insecure.NewCredentials()),
-	)
+// nodeID identifies the node in the exported telemetry, by its dqlite node
+// ID if the storage directory holds one, or by its host name otherwise.
+func nodeID(dir string) string {
+	var info struct {
+		ID uint64 `yaml:"ID"`
+	}
+	if b, err := os.ReadFile(filepath.Join(dir, "info.yaml")); err == nil {
+		if err := yaml.Unmarshal(b, &info); err == nil && info.ID != 0 {
+			return strconv.FormatUint(info.ID, 10)
+		}
+	}
+	hostname, err := os.Hostname()
 	if err != nil {
-		return nil, fmt.Errorf("failed to create gRPC connection to collector: %w", err)
+		return "unknown"
 	}
-
-	return conn, nil
+	return hostname
 }
 
-func newTraceExporter(ctx context.Context, conn *grpc.ClientConn) (trace.SpanExporter, error) {
-	exporter, err := otlptracegrpc.New(ctx, otlptracegrpc.WithGRPCConn(conn))
+func newTraceExporter(ctx context.Context, opts otelOptions) (sdktrace.SpanExporter, error) {
+	var (
+		exporter sdktrace.SpanExporter
+		err      error
+	)
+	switch opts.protocol {
+	case "grpc":
+		options := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(opts.endpoint)}
+		if opts.insecure {
+			options = append(options, otlptracegrpc.WithInsecure())
+		}
+		exporter, err = otlptracegrpc.New(ctx, options...)
+	case "http":
+		options := []otlptracehttp.Option{otlptracehttp.WithEndpoint(opts.endpoint)}
+		if opts.insecure {
+			options = append(options, otlptracehttp.WithInsecure())
+		}
+		exporter, err = otlptracehttp.New(ctx, options...)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to create trace exporter: %w", err)
 	}
 	return exporter, nil
 }
 
-func newTraceProvider(traceExporter trace.SpanExporter, res *resource.Resource) *trace.TracerProvider {
+// newSampler returns the sampler of the traces. Sampling a ratio of the
+// traces is parent based by default, so that the traces started by the
+// clients are sampled as they decided.
+func newSampler(name string, samplingRate float64) (sdktrace.Sampler, error) {
+	if samplingRate < 0 || samplingRate > 1 {
+		return nil, fmt.Errorf("invalid sampling rate %v, must be between 0 and 1", samplingRate)
+	}
+	switch name {
+	case "parentbased_traceidratio":
+		return sdktrace.ParentBased(sdktrace.TraceIDRatioBased(samplingRate)), nil
+	case "traceidratio":
+		return sdktrace.TraceIDRatioBased(samplingRate), nil
+	}
+	return nil, fmt.Errorf("unsupported sampler %q, must be parentbased_traceidratio or traceidratio", name)
+}
+
+func newTraceProvider(traceExporter sdktrace.SpanExporter, res *resource.Resource, sampler sdktrace.Sampler) *sdktrace.TracerProvider {
 	bsp := sdktrace.NewBatchSpanProcessor(traceExporter)
 	traceProvider := sdktrace.NewTracerProvider(
-		sdktrace.WithSampler(sdktrace.AlwaysSample()),
+		sdktrace.WithSampler(sampler),
 		sdktrace.WithResource(res),
 		sdktrace.WithSpanProcessor(bsp),
 	)
 	return traceProvider
 }
 
-func newMeterExporter(ctx context.Context, conn *grpc.ClientConn) (*otlpmetricgrpc.Exporter, error) {
-	metricExporter, err := otlpmetricgrpc.New(ctx, otlpmetricgrpc.WithGRPCConn(conn))
+// redactedAttributes are the span attributes holding key names.
+var redactedAttributes = map[attribute.Key]struct{}{
+	"key":      {},
+	"rangeEnd": {},
+	"startKey": {},
+	"start":    {},
+	"end":      {},
+	"prefix":   {},
+}
+
+// redactingExporter strips the key names from the attributes of the spans
+// before exporting them, as they might be sensitive.
+type redactingExporter struct {
+	sdktrace.SpanExporter
+}
+
+func (e *redactingExporter) ExportSpans(ctx context.Context, spans []sdktrace.ReadOnlySpan) error {
+	redacted := make([]sdktrace.ReadOnlySpan, 0, len(spans))
+	for _, span := range spans {
+		redacted = append(redacted, redactedSpan{span})
+	}
+	return e.SpanExporter.ExportSpans(ctx, redacted)
+}
+
+type redactedSpan struct {
+	sdktrace.ReadOnlySpan
+}
+
+func (s redactedSpan) Attributes() []attribute.KeyValue {
+	attrs := s.ReadOnlySpan.Attributes()
+	result := make([]attribute.KeyValue, 0, len(attrs))
+	for _, attr := range attrs {
+		if _, ok := redactedAttributes[attr.Key]; ok {
+			attr = attr.Key.String("<redacted>")
+		}
+		result = append(result, attr)
+	}
+	return result
+}
+
+func newMeterExporter(ctx context.Context, opts otelOptions) (sdkmetric.Exporter, error) {
+	var (
+		exporter sdkmetric.Exporter
+		err      error
+	)
+	switch opts.protocol {
+	case "grpc":
+		options := []otlpmetricgrpc.Option{otlpmetricgrpc.WithEndpoint(opts.endpoint)}
+		if opts.insecure {
+			options = append(options, otlpmetricgrpc.WithInsecure())
+		}
+		exporter, err = otlpmetricgrpc.New(ctx, options...)
+	case "http":
+		options := []otlpmetrichttp.Option{otlpmetrichttp.WithEndpoint(opts.endpoint)}
+		if opts.insecure {
+			options = append(options, otlpmetrichttp.WithInsecure())
+		}
+		exporter, err = otlpmetrichttp.New(ctx, options...)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to create metric exporter: %w", err)
 	}
-	return metricExporter, nil
+	return exporter, nil
 }
 
-func newMeterProvider(metricExporter *otlpmetricgrpc.Exporter, res *resource.Resource) (*metric.MeterProvider, error) {
-	meterProvider := sdkmetric.NewMeterProvider(
+func newMeterProvider(metricExporter sdkmetric.Exporter, res *resource.Resource) *sdkmetric.MeterProvider {
+	return sdkmetric.NewMeterProvider(
 		sdkmetric.WithReader(sdkmetric.NewPeriodicReader(metricExporter)),
 		sdkmetric.WithResource(res),
 	)
-	return meterProvider, nil
 }
@@ -0,0 +1,65 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var (
+	verifyCmdOpts struct {
+		json bool
+	}
+
+	verifyCmd = &cobra.Command{
+		Use:   "verify",
+		Short: "Verify the integrity of the datastore",
+		Long: `
+Run the integrity check of the database, which validates its pages and
+indexes, and check the invariants of the revisions of kine: the revisions
+only reference older revisions of the same key, and the deleted keys are
+created again before being updated. The verification runs on the database
+through the node running on this host, and the command fails if corruptions
+are found.
+
+		k8s-dqlite verify --storage-dir [dir with the dqlite datastore]
+
+`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			verification, err := controlClient().Verify(cmd.Context())
+			if err != nil {
+				logrus.WithError(err).Fatal("Failed to verify datastore")
+			}
+			if verifyCmdOpts.json {
+				encoder := json.NewEncoder(os.Stdout)
+				encoder.SetIndent("", "  ")
+				if err := encoder.Encode(verification); err != nil {
+					logrus.WithError(err).Fatal("Failed to print verification")
+				}
+			} else if len(verification.Violations) > 0 {
+				w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+				fmt.Fprintln(w, "REVISION\tKEY\tPROBLEM")
+				for _, violation := range verification.Violations {
+					fmt.Fprintf(w, "%d\t%s\t%s\n", violation.Revision, violation.Key, violation.Problem)
+				}
+				w.Flush()
+			}
+
+			if len(verification.Violations) > 0 {
+				logrus.WithField("violations", len(verification.Violations)).Fatal("Datastore corruption found")
+			}
+			logrus.WithField("duration", verification.Duration).Print("No corruption found")
+		},
+	}
+)
+
+func init() {
+	addControlFlags(verifyCmd)
+	verifyCmd.Flags().BoolVar(&verifyCmdOpts.json, "json", false, "print the verification as JSON")
+	rootCmd.AddCommand(verifyCmd)
+}
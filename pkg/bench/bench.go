@@ -0,0 +1,401 @@
+// Package bench benchmarks a running etcd endpoint, such as the kine
+// endpoint of k8s-dqlite, with a mix of operations on a key space of its
+// own.
+package bench
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// The operations of the workloads.
+const (
+	OpPut   = "put"
+	OpGet   = "get"
+	OpList  = "list"
+	OpWatch = "watch"
+)
+
+var operations = []string{OpPut, OpGet, OpList, OpWatch}
+
+// Workload is the mix of operations, as the relative weight of each.
+type Workload map[string]int
+
+// ParseWorkload parses a workload as a comma separated list of operations
+// and their weights, such as put=20,get=60,list=10,watch=10.
+func ParseWorkload(s string) (Workload, error) {
+	workload := Workload{}
+	for _, part := range strings.Split(s, ",") {
+		op, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid workload %q, expected op=weight", part)
+		}
+		weight, err := strconv.Atoi(value)
+		if err != nil || weight < 0 {
+			return nil, fmt.Errorf("invalid weight of %s %q", op, value)
+		}
+		if !isOperation(op) {
+			return nil, fmt.Errorf("unsupported operation %q, expected one of %s", op, strings.Join(operations, ", "))
+		}
+		workload[op] = weight
+	}
+	return workload, nil
+}
+
+func isOperation(op string) bool {
+	for _, o := range operations {
+		if o == op {
+			return true
+		}
+	}
+	return false
+}
+
+// Config configures a benchmark.
+type Config struct {
+	Workload Workload
+	// Concurrency is the number of concurrent clients.
+	Concurrency int
+	// Duration bounds the benchmark, and Requests the number of operations
+	// run. Either may be zero, but not both.
+	Duration time.Duration
+	Requests int64
+	// Prefix is the prefix of the keys of the benchmark, which are created
+	// before it starts and deleted once it completes.
+	Prefix string
+	// Keys is the number of keys, of KeySize bytes, with values of
+	// ValueSize bytes.
+	Keys      int
+	KeySize   int
+	ValueSize int
+	// ListLimit is the number of keys listed at once by the list
+	// operations. If zero, all the keys are listed.
+	ListLimit int64
+}
+
+func (c Config) validate() error {
+	total := 0
+	for op, weight := range c.Workload {
+		if !isOperation(op) {
+			return fmt.Errorf("unsupported operation %q", op)
+		}
+		total += weight
+	}
+	switch {
+	case total == 0:
+		return fmt.Errorf("the workload has no operation")
+	case c.Concurrency < 1:
+		return fmt.Errorf("the concurrency must be positive")
+	case c.Duration <= 0 && c.Requests <= 0:
+		return fmt.Errorf("either the duration or the number of requests must be positive")
+	case c.Keys < 1:
+		return fmt.Errorf("the number of keys must be positive")
+	case c.ValueSize < 0 || c.ListLimit < 0:
+		return fmt.Errorf("the value size and list limit must not be negative")
+	case c.Prefix == "":
+		return fmt.Errorf("the prefix is required")
+	}
+	return nil
+}
+
+// key returns the name of the key i, padded to the key size.
+func (c Config) key(i int) string {
+	width := max(c.KeySize-len(c.Prefix), 0)
+	return fmt.Sprintf("%s%0*d", c.Prefix, width, i)
+}
+
+// OpResult reports the latencies of an operation.
+type OpResult struct {
+	Op string `json:"op"`
+	// Count is the number of operations run, and Errors the number of
+	// them which failed.
+	Count  int64 `json:"count"`
+	Errors int64 `json:"errors"`
+	// Throughput is the number of successful operations per second.
+	Throughput float64       `json:"throughput"`
+	Mean       time.Duration `json:"mean"`
+	P50        time.Duration `json:"p50"`
+	P90        time.Duration `json:"p90"`
+	P99        time.Duration `json:"p99"`
+	Max        time.Duration `json:"max"`
+}
+
+// Result reports a benchmark.
+type Result struct {
+	Duration time.Duration `json:"duration"`
+	// Throughput is the number of successful operations per second.
+	Throughput float64    `json:"throughput"`
+	Ops        []OpResult `json:"ops"`
+}
+
+// recorder records the latencies of a client.
+type recorder struct {
+	latencies map[string][]time.Duration
+	errors    map[string]int64
+	lastError error
+}
+
+// Run creates the keys of the benchmark, runs the workload until the
+// duration elapses, the number of requests is reached or ctx is done, and
+// deletes the keys.
+func Run(ctx context.Context, client *clientv3.Client, config Config) (Result, error) {
+	if err := config.validate(); err != nil {
+		return Result{}, err
+	}
+	b := &benchmark{
+		client: client,
+		config: config,
+		value:  strings.Repeat("x", config.ValueSize),
+	}
+
+	// The keys are created with the same concurrency, and deleted even if
+	// the benchmark fails.
+	defer client.Delete(context.WithoutCancel(ctx), config.Prefix, clientv3.WithPrefix())
+	if err := forEach(ctx, config.Concurrency, config.Keys, func(i int) error {
+		_, err := b.put(ctx, config.key(i))
+		return err
+	}); err != nil {
+		return Result{}, fmt.Errorf("failed to create keys: %w", err)
+	}
+
+	if config.Duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, config.Duration)
+		defer cancel()
+	}
+
+	var (
+		wg        sync.WaitGroup
+		requests  atomic.Int64
+		recorders = make([]*recorder, config.Concurrency)
+		start     = time.Now()
+	)
+	for i := range recorders {
+		r := &recorder{latencies: map[string][]time.Duration{}, errors: map[string]int64{}}
+		recorders[i] = r
+		rng := rand.New(rand.NewSource(start.UnixNano() + int64(i)))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				if config.Requests > 0 && requests.Add(1) > config.Requests {
+					return
+				}
+				op := config.Workload.pick(rng)
+				latency, err := b.run(ctx, op, config.key(rng.Intn(config.Keys)))
+				if ctx.Err() != nil {
+					// The operations interrupted by the end of the
+					// benchmark are not recorded.
+					return
+				}
+				if err != nil {
+					r.errors[op]++
+					r.lastError = err
+					continue
+				}
+				r.latencies[op] = append(r.latencies[op], latency)
+			}
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	result := Result{Duration: elapsed}
+	var lastError error
+	for _, op := range operations {
+		if config.Workload[op] == 0 {
+			continue
+		}
+		var (
+			latencies []time.Duration
+			errors    int64
+		)
+		for _, r := range recorders {
+			latencies = append(latencies, r.latencies[op]...)
+			errors += r.errors[op]
+			if r.lastError != nil {
+				lastError = r.lastError
+			}
+		}
+		opResult := summarize(op, latencies, elapsed)
+		opResult.Errors = errors
+		opResult.Count += errors
+		result.Ops = append(result.Ops, opResult)
+		result.Throughput += opResult.Throughput
+	}
+	if lastError != nil && result.Throughput == 0 {
+		return result, fmt.Errorf("all the operations failed: %w", lastError)
+	}
+	return result, nil
+}
+
+// pick picks an operation at random, by weight.
+func (w Workload) pick(rng *rand.Rand) string {
+	total := 0
+	for _, op := range operations {
+		total += w[op]
+	}
+	n := rng.Intn(total)
+	for _, op := range operations {
+		if n < w[op] {
+			return op
+		}
+		n -= w[op]
+	}
+	return OpGet
+}
+
+// benchmark runs the operations of a benchmark.
+type benchmark struct {
+	client *clientv3.Client
+	config Config
+	value  string
+	// revisions holds the last known revision of each key, which the puts
+	// are conditioned on.
+	revisions sync.Map
+}
+
+// run runs an operation on key and returns its latency. The watch
+// operations measure the time from a put to the event of the watch.
+func (b *benchmark) run(ctx context.Context, op, key string) (time.Duration, error) {
+	start := time.Now()
+	switch op {
+	case OpPut:
+		_, err := b.put(ctx, key)
+		return time.Since(start), err
+	case OpGet:
+		_, err := b.client.Get(ctx, key)
+		return time.Since(start), err
+	case OpList:
+		_, err := b.client.Get(ctx, b.config.Prefix, clientv3.WithPrefix(), clientv3.WithLimit(b.config.ListLimit))
+		return time.Since(start), err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	watch := b.client.Watch(ctx, key, clientv3.WithCreatedNotify())
+	if resp, ok := <-watch; !ok || !resp.Created {
+		return 0, fmt.Errorf("failed to create watch: %v", resp.Err())
+	}
+	start = time.Now()
+	rev, err := b.put(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	for resp := range watch {
+		if err := resp.Err(); err != nil {
+			return 0, err
+		}
+		for _, event := range resp.Events {
+			if event.Kv.ModRevision >= rev {
+				return time.Since(start), nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("watch closed before receiving the event")
+}
+
+// put writes key as the API server does, with a transaction conditioned on
+// its last known revision, retried with the current revision on conflicts.
+// It returns the revision of the write.
+func (b *benchmark) put(ctx context.Context, key string) (int64, error) {
+	var rev int64
+	if v, ok := b.revisions.Load(key); ok {
+		rev = v.(int64)
+	}
+	for {
+		resp, err := b.client.Txn(ctx).
+			If(clientv3.Compare(clientv3.ModRevision(key), "=", rev)).
+			Then(clientv3.OpPut(key, b.value)).
+			Else(clientv3.OpGet(key)).
+			Commit()
+		if err != nil {
+			return 0, err
+		}
+		if resp.Succeeded {
+			b.revisions.Store(key, resp.Header.Revision)
+			return resp.Header.Revision, nil
+		}
+		rev = 0
+		if kvs := resp.Responses[0].GetResponseRange().GetKvs(); len(kvs) > 0 {
+			rev = kvs[0].ModRevision
+		}
+	}
+}
+
+// summarize computes the statistics of the latencies of an operation.
+func summarize(op string, latencies []time.Duration, elapsed time.Duration) OpResult {
+	result := OpResult{Op: op, Count: int64(len(latencies))}
+	if len(latencies) == 0 {
+		return result
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	var total time.Duration
+	for _, latency := range latencies {
+		total += latency
+	}
+	result.Throughput = float64(len(latencies)) / elapsed.Seconds()
+	result.Mean = total / time.Duration(len(latencies))
+	result.P50 = percentile(latencies, 0.50)
+	result.P90 = percentile(latencies, 0.90)
+	result.P99 = percentile(latencies, 0.99)
+	result.Max = latencies[len(latencies)-1]
+	return result
+}
+
+// percentile returns the q-th percentile of the sorted latencies, with the
+// nearest-rank method.
+func percentile(sorted []time.Duration, q float64) time.Duration {
+	rank := int(math.Ceil(q * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// forEach runs f for 0 to n-1 with the given concurrency, and returns the
+// first error.
+func forEach(ctx context.Context, concurrency, n int, f func(i int) error) error {
+	var (
+		wg       sync.WaitGroup
+		next     atomic.Int64
+		firstErr error
+		errOnce  sync.Once
+	)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				i := int(next.Add(1) - 1)
+				if i >= n {
+					return
+				}
+				if err := f(i); err != nil {
+					errOnce.Do(func() {
+						firstErr = err
+						cancel()
+					})
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if firstErr == nil {
+		return ctx.Err()
+	}
+	return firstErr
+}
@@ -0,0 +1,55 @@
+package bench
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseWorkload(t *testing.T) {
+	workload, err := ParseWorkload("put=20, get=60,list=10,watch=10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := Workload{OpPut: 20, OpGet: 60, OpList: 10, OpWatch: 10}
+	if !reflect.DeepEqual(workload, expected) {
+		t.Fatalf("expected %v, got %v", expected, workload)
+	}
+	for _, invalid := range []string{"put", "put=-1", "put=a", "delete=1"} {
+		if _, err := ParseWorkload(invalid); err == nil {
+			t.Errorf("expected workload %q to be invalid", invalid)
+		}
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	var latencies []time.Duration
+	for i := 100; i > 0; i-- {
+		latencies = append(latencies, time.Duration(i)*time.Millisecond)
+	}
+	result := summarize(OpGet, latencies, 2*time.Second)
+	expected := OpResult{
+		Op:         OpGet,
+		Count:      100,
+		Throughput: 50,
+		Mean:       50500 * time.Microsecond,
+		P50:        50 * time.Millisecond,
+		P90:        90 * time.Millisecond,
+		P99:        99 * time.Millisecond,
+		Max:        100 * time.Millisecond,
+	}
+	if result != expected {
+		t.Fatalf("expected %+v, got %+v", expected, result)
+	}
+}
+
+func TestKey(t *testing.T) {
+	config := Config{Prefix: "/bench/", KeySize: 12}
+	if key := config.key(42); key != "/bench/00042" {
+		t.Fatalf("unexpected key %q", key)
+	}
+	config.KeySize = 0
+	if key := config.key(42); key != "/bench/42" {
+		t.Fatalf("unexpected key %q", key)
+	}
+}
@@ -0,0 +1,319 @@
+// Package decode decodes the values stored by the Kubernetes API server, so
+// that they can be inspected without an API server.
+package decode
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const (
+	// protobufPrefix starts the objects stored as protobuf, which are
+	// wrapped in a runtime.Unknown message.
+	protobufPrefix = "k8s\x00"
+	// encryptedPrefix starts the objects encrypted at rest, followed by
+	// the provider, its version and the name of the key.
+	encryptedPrefix = "k8s:enc:"
+)
+
+// Value decodes a value stored by the API server into a document, which
+// marshals to JSON or YAML:
+//   - The objects stored as JSON are decoded as is.
+//   - The objects stored as protobuf are decoded without their schema, as
+//     the types of the API server are not known. Their metadata is decoded
+//     with the names of its fields, and the other fields are named by their
+//     number.
+//   - The objects encrypted at rest are reported with their provider and
+//     key, as they can't be decrypted.
+//
+// The other values are returned as strings if printable, or else as bytes.
+func Value(value []byte) any {
+	switch {
+	case bytes.HasPrefix(value, []byte(protobufPrefix)):
+		if doc, err := decodeUnknown(value[len(protobufPrefix):]); err == nil {
+			return doc
+		}
+	case bytes.HasPrefix(value, []byte(encryptedPrefix)):
+		parts := strings.SplitN(string(value[len(encryptedPrefix):]), ":", 4)
+		encrypted := map[string]any{"size": len(value)}
+		if len(parts) == 4 {
+			encrypted["provider"] = parts[0] + "/" + parts[1]
+			encrypted["key"] = parts[2]
+		}
+		return map[string]any{"encrypted": encrypted}
+	case json.Valid(value):
+		decoder := json.NewDecoder(bytes.NewReader(value))
+		decoder.UseNumber()
+		var doc any
+		if err := decoder.Decode(&doc); err == nil {
+			return doc
+		}
+	}
+	if isPrintable(value) {
+		return string(value)
+	}
+	return value
+}
+
+// decodeUnknown decodes a runtime.Unknown message, holding the type and raw
+// protobuf of an object.
+func decodeUnknown(b []byte) (map[string]any, error) {
+	fields, err := parseFields(b)
+	if err != nil {
+		return nil, err
+	}
+	doc := map[string]any{}
+	for _, f := range fields {
+		if f.typ != protowire.BytesType {
+			continue
+		}
+		switch f.number {
+		case 1:
+			typeMeta, err := parseFields(f.bytes)
+			if err != nil {
+				return nil, err
+			}
+			for _, tf := range typeMeta {
+				switch tf.number {
+				case 1:
+					doc["apiVersion"] = string(tf.bytes)
+				case 2:
+					doc["kind"] = string(tf.bytes)
+				}
+			}
+		case 2:
+			object, err := parseFields(f.bytes)
+			if err != nil {
+				return nil, fmt.Errorf("invalid object: %w", err)
+			}
+			for name, value := range decodeMessage(object, objectSchema) {
+				doc[name] = value
+			}
+		}
+	}
+	return doc, nil
+}
+
+// field is a field of a protobuf message. The numeric values are held in
+// value, and the length-delimited ones in bytes.
+type field struct {
+	number protowire.Number
+	typ    protowire.Type
+	value  uint64
+	bytes  []byte
+}
+
+// parseFields parses the fields of a protobuf message, in order.
+func parseFields(b []byte) ([]field, error) {
+	var fields []field
+	for len(b) > 0 {
+		number, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		f := field{number: number, typ: typ}
+		switch typ {
+		case protowire.VarintType:
+			f.value, n = protowire.ConsumeVarint(b)
+		case protowire.Fixed32Type:
+			var v uint32
+			v, n = protowire.ConsumeFixed32(b)
+			f.value = uint64(v)
+		case protowire.Fixed64Type:
+			f.value, n = protowire.ConsumeFixed64(b)
+		case protowire.BytesType:
+			f.bytes, n = protowire.ConsumeBytes(b)
+		default:
+			return nil, fmt.Errorf("unsupported wire type %d", typ)
+		}
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		b = b[n:]
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
+
+// fieldKind tells how a known field is decoded.
+type fieldKind int
+
+const (
+	// scalarField is decoded as the unknown fields, or with the schema of
+	// the field if set.
+	scalarField fieldKind = iota
+	boolField
+	// timeField is a metav1.Time, decoded as an RFC 3339 timestamp.
+	timeField
+	// mapField is a map of strings, stored as repeated entries.
+	mapField
+	// listField is a repeated field, decoded as a list even if it holds a
+	// single value.
+	listField
+)
+
+type schemaField struct {
+	name   string
+	kind   fieldKind
+	schema schema
+}
+
+// schema names the known fields of a message.
+type schema map[protowire.Number]schemaField
+
+var (
+	ownerReferenceSchema = schema{
+		1: {name: "kind"},
+		3: {name: "name"},
+		4: {name: "uid"},
+		5: {name: "apiVersion"},
+		6: {name: "controller", kind: boolField},
+		7: {name: "blockOwnerDeletion", kind: boolField},
+	}
+
+	objectMetaSchema = schema{
+		1:  {name: "name"},
+		2:  {name: "generateName"},
+		3:  {name: "namespace"},
+		4:  {name: "selfLink"},
+		5:  {name: "uid"},
+		6:  {name: "resourceVersion"},
+		7:  {name: "generation"},
+		8:  {name: "creationTimestamp", kind: timeField},
+		9:  {name: "deletionTimestamp", kind: timeField},
+		10: {name: "deletionGracePeriodSeconds"},
+		11: {name: "labels", kind: mapField},
+		12: {name: "annotations", kind: mapField},
+		13: {name: "ownerReferences", kind: listField, schema: ownerReferenceSchema},
+		14: {name: "finalizers", kind: listField},
+		17: {name: "managedFields", kind: listField},
+	}
+
+	// objectSchema is the schema of the objects of the API server, whose
+	// first field is their metadata.
+	objectSchema = schema{
+		1: {name: "metadata", schema: objectMetaSchema},
+	}
+)
+
+// decodeMessage decodes the fields of a message, named by s or else by
+// their number. The repeated fields are decoded as lists.
+func decodeMessage(fields []field, s schema) map[string]any {
+	doc := map[string]any{}
+	for _, f := range fields {
+		sf, known := s[f.number]
+		name := sf.name
+		if !known {
+			name = strconv.Itoa(int(f.number))
+		}
+
+		var value any
+		switch sf.kind {
+		case boolField:
+			value = f.value != 0
+		case timeField:
+			value = decodeTime(f)
+		case mapField:
+			m, _ := doc[name].(map[string]any)
+			if m == nil {
+				m = map[string]any{}
+				doc[name] = m
+			}
+			if entry, err := parseFields(f.bytes); err == nil {
+				var key, value string
+				for _, ef := range entry {
+					switch ef.number {
+					case 1:
+						key = string(ef.bytes)
+					case 2:
+						value = string(ef.bytes)
+					}
+				}
+				m[key] = value
+			}
+			continue
+		default:
+			value = decodeField(f, sf.schema)
+		}
+
+		switch previous, ok := doc[name]; {
+		case sf.kind == listField:
+			list, _ := previous.([]any)
+			doc[name] = append(list, value)
+		case !ok:
+			doc[name] = value
+		default:
+			// The fields found more than once are repeated.
+			list, isList := previous.([]any)
+			if !isList {
+				list = []any{previous}
+			}
+			doc[name] = append(list, value)
+		}
+	}
+	return doc
+}
+
+// decodeField decodes the value of a field. The length-delimited fields
+// are decoded as strings if printable, or else as messages if they parse
+// as such, or else as bytes.
+func decodeField(f field, s schema) any {
+	switch f.typ {
+	case protowire.VarintType:
+		return int64(f.value)
+	case protowire.BytesType:
+	default:
+		return f.value
+	}
+
+	if s == nil && isPrintable(f.bytes) {
+		return string(f.bytes)
+	}
+	if fields, err := parseFields(f.bytes); err == nil && (len(fields) > 0 || s != nil) {
+		return decodeMessage(fields, s)
+	}
+	return f.bytes
+}
+
+// decodeTime decodes a metav1.Time, holding its seconds and nanoseconds
+// since the epoch.
+func decodeTime(f field) any {
+	fields, err := parseFields(f.bytes)
+	if err != nil {
+		return f.bytes
+	}
+	var seconds, nanos int64
+	for _, tf := range fields {
+		switch tf.number {
+		case 1:
+			seconds = int64(tf.value)
+		case 2:
+			nanos = int64(tf.value)
+		}
+	}
+	return time.Unix(seconds, nanos).UTC().Format(time.RFC3339)
+}
+
+// isPrintable reports whether b is a UTF-8 string of printable characters
+// and whitespace.
+func isPrintable(b []byte) bool {
+	if !utf8.Valid(b) {
+		return false
+	}
+	for _, r := range string(b) {
+		if !unicode.IsPrint(r) && r != '\n' && r != '\r' && r != '\t' {
+			return false
+		}
+	}
+	return true
+}
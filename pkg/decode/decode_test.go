@@ -0,0 +1,93 @@
+package decode
+
+import (
+	"encoding/json"
+	"testing"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func appendMessage(b []byte, number protowire.Number, message []byte) []byte {
+	b = protowire.AppendTag(b, number, protowire.BytesType)
+	return protowire.AppendBytes(b, message)
+}
+
+func appendString(b []byte, number protowire.Number, s string) []byte {
+	return appendMessage(b, number, []byte(s))
+}
+
+func appendVarint(b []byte, number protowire.Number, v uint64) []byte {
+	b = protowire.AppendTag(b, number, protowire.VarintType)
+	return protowire.AppendVarint(b, v)
+}
+
+// configMap returns a ConfigMap stored as protobuf.
+func configMap() []byte {
+	var metadata []byte
+	metadata = appendString(metadata, 1, "foo")
+	metadata = appendString(metadata, 3, "default")
+	metadata = appendString(metadata, 5, "6f1b1a2e-8a5d-4a4c-9a0e-5a3e7c1d2b4f")
+	metadata = appendString(metadata, 6, "42")
+	metadata = appendMessage(metadata, 8, appendVarint(nil, 1, 1700000000))
+	metadata = appendMessage(metadata, 11, appendString(appendString(nil, 1, "app"), 2, "web"))
+	metadata = appendMessage(metadata, 11, appendString(appendString(nil, 1, "tier"), 2, "front"))
+	metadata = appendString(metadata, 14, "example.com/finalizer")
+	metadata = appendMessage(metadata, 13, appendVarint(appendString(appendString(nil, 1, "Deployment"), 3, "web"), 6, 1))
+
+	var object []byte
+	object = appendMessage(object, 1, metadata)
+	object = appendMessage(object, 2, appendString(appendString(nil, 1, "key"), 2, "value"))
+	object = appendMessage(object, 2, appendString(appendString(nil, 1, "other"), 2, "value"))
+	object = appendVarint(object, 4, 1)
+
+	var typeMeta []byte
+	typeMeta = appendString(typeMeta, 1, "v1")
+	typeMeta = appendString(typeMeta, 2, "ConfigMap")
+
+	var unknown []byte
+	unknown = appendMessage(unknown, 1, typeMeta)
+	unknown = appendMessage(unknown, 2, object)
+	return append([]byte(protobufPrefix), unknown...)
+}
+
+func TestValue(t *testing.T) {
+	for name, tc := range map[string]struct {
+		value    []byte
+		expected string
+	}{
+		"protobuf": {
+			value: configMap(),
+			expected: `{"2":[{"1":"key","2":"value"},{"1":"other","2":"value"}],"4":1,"apiVersion":"v1","kind":"ConfigMap",` +
+				`"metadata":{"creationTimestamp":"2023-11-14T22:13:20Z","finalizers":["example.com/finalizer"],` +
+				`"labels":{"app":"web","tier":"front"},"name":"foo","namespace":"default",` +
+				`"ownerReferences":[{"controller":true,"kind":"Deployment","name":"web"}],` +
+				`"resourceVersion":"42","uid":"6f1b1a2e-8a5d-4a4c-9a0e-5a3e7c1d2b4f"}}`,
+		},
+		"json": {
+			value:    []byte(`{"apiVersion":"example.com/v1","kind":"Widget","spec":{"size":12345678901234567890}}`),
+			expected: `{"apiVersion":"example.com/v1","kind":"Widget","spec":{"size":12345678901234567890}}`,
+		},
+		"encrypted": {
+			value:    []byte("k8s:enc:aescbc:v1:key1:\x8a\x01\xff"),
+			expected: `{"encrypted":{"key":"key1","provider":"aescbc/v1","size":26}}`,
+		},
+		"string": {
+			value:    []byte(`{"health":`),
+			expected: `"{\"health\":"`,
+		},
+		"bytes": {
+			value:    []byte{0xff, 0x00},
+			expected: `"/wA="`,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			b, err := json.Marshal(Value(tc.value))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != tc.expected {
+				t.Fatalf("expected %s, got %s", tc.expected, b)
+			}
+		})
+	}
+}
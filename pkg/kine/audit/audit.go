@@ -0,0 +1,96 @@
+// Package audit records the writes to the datastore in an append-only log.
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/syslog"
+	"sync"
+
+	"github.com/canonical/k8s-dqlite/pkg/kine/server"
+	"github.com/canonical/k8s-dqlite/pkg/logging"
+	"golang.org/x/time/rate"
+)
+
+var logger = logging.Component(logging.Server)
+
+// Syslog is the path sending the audit log to syslog instead of a file.
+const Syslog = "syslog"
+
+type Config struct {
+	// Path is the file of the audit log, or Syslog.
+	Path string
+	// MaxSize is the size in bytes after which the file is rotated. If
+	// zero, the file is never rotated.
+	MaxSize int64
+	// MaxBackups is the number of rotated files kept.
+	MaxBackups int
+	// RateLimit is the maximum number of entries recorded per second.
+	// The entries over the limit are dropped, and their number is
+	// recorded with the next entry. If zero, there is no limit.
+	RateLimit float64
+}
+
+// Log writes the audit entries as JSON lines.
+type Log struct {
+	mu      sync.Mutex
+	w       io.WriteCloser
+	limiter *rate.Limiter
+	dropped int64
+}
+
+var _ server.AuditLog = (*Log)(nil)
+
+func New(config Config) (*Log, error) {
+	var (
+		w   io.WriteCloser
+		err error
+	)
+	if config.Path == Syslog {
+		w, err = syslog.New(syslog.LOG_INFO|syslog.LOG_AUTH, "k8s-dqlite")
+	} else {
+		w, err = openRotatingFile(config.Path, config.MaxSize, config.MaxBackups)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+
+	l := &Log{w: w}
+	if config.RateLimit > 0 {
+		l.limiter = rate.NewLimiter(rate.Limit(config.RateLimit), max(1, int(config.RateLimit)))
+	}
+	return l, nil
+}
+
+type record struct {
+	server.AuditEntry
+	// Dropped is the number of entries dropped since the previous record.
+	Dropped int64 `json:"dropped,omitempty"`
+}
+
+func (l *Log) Record(entry server.AuditEntry) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.limiter != nil && !l.limiter.Allow() {
+		l.dropped++
+		return
+	}
+	b, err := json.Marshal(record{AuditEntry: entry, Dropped: l.dropped})
+	if err != nil {
+		logger.WithError(err).Warning("Failed to encode audit entry")
+		return
+	}
+	if _, err := l.w.Write(append(b, '\n')); err != nil {
+		logger.WithError(err).Warning("Failed to write audit entry")
+		return
+	}
+	l.dropped = 0
+}
+
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.w.Close()
+}
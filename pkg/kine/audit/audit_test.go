@@ -0,0 +1,91 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/canonical/k8s-dqlite/pkg/kine/server"
+)
+
+func readRecords(t *testing.T, path string) []record {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var records []record
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var r record
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, r)
+	}
+	return records
+}
+
+func TestRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	l, err := New(Config{Path: path, MaxSize: 200, MaxBackups: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := int64(1); i <= 10; i++ {
+		l.Record(server.AuditEntry{Op: "create", Key: "/registry/pods/default/pod", Revision: i})
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("expected at most 2 backups, got %v", err)
+	}
+	// The entries are split across the files, the most recent ones in
+	// the current file.
+	var revisions []int64
+	for _, p := range []string{path + ".2", path + ".1", path} {
+		for _, r := range readRecords(t, p) {
+			revisions = append(revisions, r.Revision)
+		}
+	}
+	if len(revisions) == 0 || revisions[len(revisions)-1] != 10 {
+		t.Fatalf("expected the last entry to be revision 10, got %v", revisions)
+	}
+	for i := 1; i < len(revisions); i++ {
+		if revisions[i] != revisions[i-1]+1 {
+			t.Errorf("expected consecutive revisions, got %v", revisions)
+			break
+		}
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	l, err := New(Config{Path: path, RateLimit: 0.001})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := int64(1); i <= 5; i++ {
+		l.Record(server.AuditEntry{Op: "update", Key: "/key", Revision: i})
+	}
+	// Let the next entry through, as if the limiter was refilled.
+	l.limiter = nil
+	l.Record(server.AuditEntry{Op: "update", Key: "/key", Revision: 6})
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	records := readRecords(t, path)
+	if len(records) != 2 {
+		t.Fatalf("expected 2 records, got %d", len(records))
+	}
+	if records[0].Revision != 1 || records[1].Revision != 6 || records[1].Dropped != 4 {
+		t.Errorf("expected revisions 1 and 6 with 4 dropped entries, got %+v", records)
+	}
+}
@@ -2,22 +2,62 @@ package broadcaster
 
 import (
 	"context"
+	"fmt"
 	"sync"
+
+	"github.com/canonical/k8s-dqlite/pkg/logging"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/metric"
+)
+
+var logger = logging.Component(logging.Watch)
+
+const (
+	otelName = "broadcaster"
+
+	// DefaultQueueSize is the number of items queued for each subscriber
+	// if no size is configured.
+	DefaultQueueSize = 100
+)
+
+var (
+	otelMeter  metric.Meter
+	evictedCnt metric.Int64Counter
 )
 
+func init() {
+	var err error
+	otelMeter = otel.Meter(otelName)
+
+	evictedCnt, err = otelMeter.Int64Counter(fmt.Sprintf("%s.evicted", otelName), metric.WithDescription("Number of subscribers evicted for not keeping up"))
+	if err != nil {
+		logger.WithError(err).Warning("Otel failed to create evicted counter")
+	}
+}
+
 type ConnectFunc func() (chan interface{}, error)
 
 type Broadcaster struct {
 	sync.Mutex
 	running bool
 	subs    map[chan interface{}]struct{}
+
+	// QueueSize is the number of items queued for each subscriber. The
+	// subscribers falling further behind are evicted, and their channel
+	// closed, so that they don't stall the others. If zero,
+	// DefaultQueueSize is used.
+	QueueSize int
 }
 
 func (b *Broadcaster) Subscribe(ctx context.Context) (<-chan interface{}, error) {
 	b.Lock()
 	defer b.Unlock()
 
-	sub := make(chan interface{}, 100)
+	size := b.QueueSize
+	if size <= 0 {
+		size = DefaultQueueSize
+	}
+	sub := make(chan interface{}, size)
 	if b.subs == nil {
 		b.subs = map[chan interface{}]struct{}{}
 	}
@@ -73,7 +113,10 @@ func (b *Broadcaster) publish(item interface{}) {
 		select {
 		case sub <- item:
 		default:
-			// Slow consumer, drop
+			// Slow consumer, evict it. Closing its channel makes the
+			// watch be canceled, so that the client re-establishes it.
+			logger.Warnf("Evicting a watch subscriber after %d queued items", cap(sub))
+			evictedCnt.Add(context.Background(), 1)
 			b.unsub(sub)
 		}
 	}
@@ -0,0 +1,46 @@
+package broadcaster
+
+import (
+	"context"
+	"testing"
+)
+
+func TestSlowSubscriberEviction(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	items := make(chan interface{})
+	b := &Broadcaster{QueueSize: 2}
+	if err := b.Start(func() (chan interface{}, error) { return items, nil }); err != nil {
+		t.Fatal(err)
+	}
+	fast, err := b.Subscribe(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slow, err := b.Subscribe(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		items <- i
+		if item := <-fast; item != i {
+			t.Fatalf("expected item %d, got %v", i, item)
+		}
+	}
+	close(items)
+
+	// The slow subscriber gets the queued items before its channel is
+	// closed.
+	var received []interface{}
+	for item := range slow {
+		received = append(received, item)
+	}
+	if len(received) != 2 {
+		t.Errorf("expected 2 items for the slow subscriber, got %v", received)
+	}
+	if _, ok := <-fast; ok {
+		t.Error("expected the channel to be closed once the stream ends")
+	}
+}
@@ -0,0 +1,252 @@
+// Package changefeed exports the changes of the datastore, tailing the kine
+// log, as JSON lines to a sink.
+package changefeed
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/canonical/k8s-dqlite/pkg/kine/server"
+	"github.com/canonical/k8s-dqlite/pkg/logging"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+)
+
+var logger = logging.Component(logging.Server)
+
+const (
+	// watchPrefix is the prefix of the exported keys, which leaves out the
+	// internal keys of kine.
+	watchPrefix = "/"
+
+	minRetryInterval = time.Second
+	maxRetryInterval = 30 * time.Second
+)
+
+var (
+	metricsEvents = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "k8s_dqlite_changefeed_events",
+		Help: "Total number of events exported by the changefeed",
+	})
+	metricsErrors = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "k8s_dqlite_changefeed_errors",
+		Help: "Total number of failures to export events, which are retried",
+	})
+	metricsSkipped = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "k8s_dqlite_changefeed_skipped_revisions",
+		Help: "Total number of revisions skipped by the changefeed, as they were compacted before being exported",
+	})
+	metricsRevision = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "k8s_dqlite_changefeed_revision",
+		Help: "Last revision exported by the changefeed",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(metricsEvents, metricsErrors, metricsSkipped, metricsRevision)
+}
+
+// Config configures the changefeed, which is disabled if it has no sink.
+type Config struct {
+	// Sink is where the events are exported, as accepted by NewSink.
+	Sink string
+	// CursorPath is the file holding the last revision exported, from
+	// which the changefeed resumes.
+	CursorPath string
+}
+
+// Event is a change of the datastore, as exported.
+type Event struct {
+	Revision int64 `json:"revision"`
+	// Type is one of create, update and delete.
+	Type           string `json:"type"`
+	Key            string `json:"key"`
+	CreateRevision int64  `json:"create_revision"`
+	Lease          int64  `json:"lease,omitempty"`
+	// Value is the value of the key, unless it was deleted.
+	Value []byte `json:"value,omitempty"`
+	// PrevRevision and PrevValue are the revision and value of the key
+	// before the change, unless it was created.
+	PrevRevision int64  `json:"prev_revision,omitempty"`
+	PrevValue    []byte `json:"prev_value,omitempty"`
+}
+
+func newEvent(event *server.Event) Event {
+	e := Event{
+		Revision:       event.KV.ModRevision,
+		Type:           "update",
+		Key:            event.KV.Key,
+		CreateRevision: event.KV.CreateRevision,
+		Lease:          event.KV.Lease,
+		Value:          event.KV.Value,
+	}
+	switch {
+	case event.Create:
+		e.Type = "create"
+	case event.Delete:
+		e.Type = "delete"
+		e.Value = nil
+	}
+	if event.PrevKV != nil && !event.Create {
+		e.PrevRevision = event.PrevKV.ModRevision
+		e.PrevValue = event.PrevKV.Value
+	}
+	return e
+}
+
+// Source is the part of the kine backend tailed by the changefeed.
+type Source interface {
+	Watch(ctx context.Context, key string, revision int64) <-chan []*server.Event
+	List(ctx context.Context, prefix, startKey string, limit, revision int64) (int64, []*server.KeyValue, error)
+	CurrentRevision(ctx context.Context) (int64, error)
+}
+
+// Feed exports the changes of a source to a sink, at least once: the
+// revision of the last exported change is saved once the sink accepted it,
+// and the feed resumes after it.
+type Feed struct {
+	source     Source
+	sink       Sink
+	cursorPath string
+	// retryInterval is the first interval between the attempts to export
+	// events or to watch the source, which doubles up to maxRetryInterval.
+	retryInterval time.Duration
+}
+
+func New(config Config, source Source) (*Feed, error) {
+	sink, err := NewSink(config.Sink)
+	if err != nil {
+		return nil, err
+	}
+	return &Feed{
+		source:        source,
+		sink:          sink,
+		cursorPath:    config.CursorPath,
+		retryInterval: minRetryInterval,
+	}, nil
+}
+
+// Run exports the changes until ctx is done, and closes the sink. Without a
+// saved cursor, the changes following the current revision are exported.
+func (f *Feed) Run(ctx context.Context) {
+	defer f.sink.Close()
+
+	cursor, err := f.startRevision(ctx)
+	if err != nil {
+		return
+	}
+	logger.WithField("revision", cursor).Print("Start changefeed")
+
+	for {
+		// The watch is drained until it ends, even once ctx is done.
+		for events := range f.source.Watch(ctx, watchPrefix, cursor+1) {
+			if ctx.Err() == nil {
+				cursor = f.export(ctx, cursor, events)
+			}
+		}
+		if ctx.Err() != nil {
+			return
+		}
+
+		// The watch ends on errors, or if the revisions to catch up
+		// with were compacted, in which case they are skipped.
+		if _, _, err := f.source.List(ctx, watchPrefix, "", 1, cursor); errors.Is(err, server.ErrCompacted) {
+			current, err := f.source.CurrentRevision(ctx)
+			if err == nil && current > cursor {
+				logger.WithFields(logrus.Fields{"from": cursor + 1, "to": current}).Error("Changefeed revisions were compacted before being exported, skipping them")
+				metricsSkipped.Add(float64(current - cursor))
+				cursor = current
+				f.saveCursor(cursor)
+			}
+		}
+		if !sleep(ctx, f.retryInterval) {
+			return
+		}
+	}
+}
+
+// startRevision returns the revision of the saved cursor, or else the
+// current revision.
+func (f *Feed) startRevision(ctx context.Context) (int64, error) {
+	b, err := os.ReadFile(f.cursorPath)
+	if err == nil {
+		cursor, err := strconv.ParseInt(strings.TrimSpace(string(b)), 10, 64)
+		if err == nil {
+			return cursor, nil
+		}
+		logger.WithError(err).WithField("path", f.cursorPath).Warning("Invalid changefeed cursor, starting from the current revision")
+	} else if !os.IsNotExist(err) {
+		logger.WithError(err).WithField("path", f.cursorPath).Warning("Failed to read changefeed cursor, starting from the current revision")
+	}
+
+	for interval := f.retryInterval; ; interval = min(2*interval, maxRetryInterval) {
+		current, err := f.source.CurrentRevision(ctx)
+		if err == nil {
+			return current, nil
+		}
+		logger.WithError(err).Warning("Failed to get current revision for changefeed")
+		if !sleep(ctx, interval) {
+			return 0, ctx.Err()
+		}
+	}
+}
+
+// export sends the events after cursor to the sink, retrying until it
+// accepts them or ctx is done, and returns the new cursor.
+func (f *Feed) export(ctx context.Context, cursor int64, events []*server.Event) int64 {
+	var batch []Event
+	for _, event := range events {
+		if event.KV.ModRevision > cursor {
+			batch = append(batch, newEvent(event))
+		}
+	}
+	if len(batch) == 0 {
+		return cursor
+	}
+
+	for interval := f.retryInterval; ; interval = min(2*interval, maxRetryInterval) {
+		err := f.sink.Send(ctx, batch)
+		if err == nil {
+			break
+		}
+		metricsErrors.Inc()
+		logger.WithError(err).WithField("revision", batch[0].Revision).Warning("Failed to export changefeed events, retrying")
+		if !sleep(ctx, interval) {
+			return cursor
+		}
+	}
+
+	cursor = batch[len(batch)-1].Revision
+	metricsEvents.Add(float64(len(batch)))
+	metricsRevision.Set(float64(cursor))
+	f.saveCursor(cursor)
+	return cursor
+}
+
+// saveCursor saves the revision of the last exported change. On failure,
+// the changes since the last saved cursor are exported again on restart.
+func (f *Feed) saveCursor(cursor int64) {
+	tmp := f.cursorPath + ".tmp"
+	if err := os.WriteFile(tmp, []byte(strconv.FormatInt(cursor, 10)), 0600); err != nil {
+		logger.WithError(err).Warning("Failed to save changefeed cursor")
+		return
+	}
+	if err := os.Rename(tmp, f.cursorPath); err != nil {
+		logger.WithError(err).Warning("Failed to save changefeed cursor")
+	}
+}
+
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
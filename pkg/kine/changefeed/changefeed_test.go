@@ -0,0 +1,196 @@
+package changefeed
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/canonical/k8s-dqlite/pkg/kine/server"
+)
+
+// fakeSource serves its events to the watches from the revisions that
+// were not compacted, then blocks until the watch is done.
+type fakeSource struct {
+	events  []*server.Event
+	compact int64
+	current int64
+}
+
+func (s *fakeSource) Watch(ctx context.Context, key string, revision int64) <-chan []*server.Event {
+	result := make(chan []*server.Event, 1)
+	if revision <= s.compact {
+		close(result)
+		return result
+	}
+	var events []*server.Event
+	for _, event := range s.events {
+		if event.KV.ModRevision >= revision {
+			events = append(events, event)
+		}
+	}
+	go func() {
+		defer close(result)
+		if len(events) > 0 {
+			result <- events
+		}
+		<-ctx.Done()
+	}()
+	return result
+}
+
+func (s *fakeSource) List(ctx context.Context, prefix, startKey string, limit, revision int64) (int64, []*server.KeyValue, error) {
+	if revision > 0 && revision < s.compact {
+		return s.current, nil, server.ErrCompacted
+	}
+	return s.current, nil, nil
+}
+
+func (s *fakeSource) CurrentRevision(ctx context.Context) (int64, error) {
+	return s.current, nil
+}
+
+var fixtureEvents = []*server.Event{
+	{Create: true, KV: &server.KeyValue{Key: "/a", CreateRevision: 1, ModRevision: 1, Value: []byte("a1")}},
+	{KV: &server.KeyValue{Key: "/a", CreateRevision: 1, ModRevision: 2, Value: []byte("a2")}, PrevKV: &server.KeyValue{Key: "/a", ModRevision: 1, Value: []byte("a1")}},
+	{Delete: true, KV: &server.KeyValue{Key: "/a", CreateRevision: 1, ModRevision: 3, Value: []byte("a2")}, PrevKV: &server.KeyValue{Key: "/a", ModRevision: 2, Value: []byte("a2")}},
+}
+
+var fixtureExported = []Event{
+	{Revision: 1, Type: "create", Key: "/a", CreateRevision: 1, Value: []byte("a1")},
+	{Revision: 2, Type: "update", Key: "/a", CreateRevision: 1, Value: []byte("a2"), PrevRevision: 1, PrevValue: []byte("a1")},
+	{Revision: 3, Type: "delete", Key: "/a", CreateRevision: 1, PrevRevision: 2, PrevValue: []byte("a2")},
+}
+
+// runFeed runs a feed until its cursor reaches revision.
+func runFeed(t *testing.T, config Config, source Source, revision int64) {
+	t.Helper()
+	feed, err := New(config, source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	feed.retryInterval = 10 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		feed.Run(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if b, err := os.ReadFile(config.CursorPath); err == nil && string(b) == strconv.FormatInt(revision, 10) {
+			return
+		}
+	}
+	t.Fatalf("changefeed did not reach revision %d", revision)
+}
+
+func readEvents(t *testing.T, path string) []Event {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var events []Event
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var event Event
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+func TestFileSink(t *testing.T) {
+	dir := t.TempDir()
+	config := Config{Sink: filepath.Join(dir, "changes.jsonl"), CursorPath: filepath.Join(dir, "cursor")}
+
+	// Without cursor, the feed starts from the current revision.
+	runFeed(t, config, &fakeSource{events: fixtureEvents, current: 1}, 3)
+	if events := readEvents(t, config.Sink); !reflect.DeepEqual(events, fixtureExported[1:]) {
+		t.Errorf("expected events\n%+v\ngot\n%+v", fixtureExported[1:], events)
+	}
+
+	// The feed resumes from its cursor.
+	source := &fakeSource{events: append(fixtureEvents, &server.Event{Create: true, KV: &server.KeyValue{Key: "/b", CreateRevision: 4, ModRevision: 4}}), current: 4}
+	runFeed(t, config, source, 4)
+	if events := readEvents(t, config.Sink); len(events) != 3 || events[2].Key != "/b" {
+		t.Errorf("expected the /b creation to be appended, got %+v", events)
+	}
+}
+
+func TestCompactedRevisions(t *testing.T) {
+	dir := t.TempDir()
+	config := Config{Sink: filepath.Join(dir, "changes.jsonl"), CursorPath: filepath.Join(dir, "cursor")}
+	if err := os.WriteFile(config.CursorPath, []byte("1"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// The revisions 2 and 3 were compacted, so they are skipped.
+	source := &fakeSource{events: append(fixtureEvents, &server.Event{Create: true, KV: &server.KeyValue{Key: "/b", CreateRevision: 4, ModRevision: 4}}), compact: 3, current: 3}
+	runFeed(t, config, source, 4)
+	if events := readEvents(t, config.Sink); len(events) != 1 || events[0].Revision != 4 {
+		t.Errorf("expected only revision 4 to be exported, got %+v", events)
+	}
+}
+
+func TestWebhookSink(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		requests int
+		events   []Event
+	)
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		// The first request fails, and is retried.
+		if requests++; requests == 1 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		scanner := bufio.NewScanner(r.Body)
+		for scanner.Scan() {
+			var event Event
+			if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			events = append(events, event)
+		}
+	}))
+	defer webhook.Close()
+
+	config := Config{Sink: webhook.URL, CursorPath: filepath.Join(t.TempDir(), "cursor")}
+	if err := os.WriteFile(config.CursorPath, []byte("0"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	runFeed(t, config, &fakeSource{events: fixtureEvents, current: 3}, 3)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if !reflect.DeepEqual(events, fixtureExported) {
+		t.Errorf("expected events\n%+v\ngot\n%+v", fixtureExported, events)
+	}
+}
+
+func TestUnsupportedSink(t *testing.T) {
+	if _, err := NewSink("kafka://broker:9092/topic"); err == nil {
+		t.Fatal("expected error")
+	}
+}
@@ -0,0 +1,118 @@
+package changefeed
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// webhookTimeout bounds the requests to the webhooks.
+const webhookTimeout = 30 * time.Second
+
+// Sink receives the exported events.
+type Sink interface {
+	// Send exports a batch of events, in revision order. It is retried
+	// with the same events on failure.
+	Send(ctx context.Context, events []Event) error
+	Close() error
+}
+
+// NewSink returns the sink exporting the events to target: a file path,
+// optionally as a file:// URL, where the events are appended as JSON lines,
+// or an http:// or https:// URL, where they are posted as JSON lines.
+func NewSink(target string) (Sink, error) {
+	switch {
+	case strings.HasPrefix(target, "http://") || strings.HasPrefix(target, "https://"):
+		if _, err := url.Parse(target); err != nil {
+			return nil, fmt.Errorf("invalid changefeed webhook %q: %w", target, err)
+		}
+		return &webhookSink{url: target, client: &http.Client{Timeout: webhookTimeout}}, nil
+	case strings.HasPrefix(target, "file://"):
+		return newFileSink(strings.TrimPrefix(target, "file://"))
+	case strings.Contains(target, "://"):
+		return nil, fmt.Errorf("unsupported changefeed sink %q, expected a file or an http(s) webhook", target)
+	case target == "":
+		return nil, fmt.Errorf("no changefeed sink")
+	}
+	return newFileSink(target)
+}
+
+func encodeEvents(events []Event) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	for _, event := range events {
+		if err := encoder.Encode(event); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// fileSink appends the events to a file, synced after each batch.
+type fileSink struct {
+	f *os.File
+}
+
+func newFileSink(path string) (*fileSink, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open changefeed file: %w", err)
+	}
+	return &fileSink{f: f}, nil
+}
+
+func (s *fileSink) Send(ctx context.Context, events []Event) error {
+	b, err := encodeEvents(events)
+	if err != nil {
+		return err
+	}
+	if _, err := s.f.Write(b); err != nil {
+		return err
+	}
+	return s.f.Sync()
+}
+
+func (s *fileSink) Close() error {
+	return s.f.Close()
+}
+
+// webhookSink posts the events as a JSON lines body, accepted with any 2xx
+// status.
+type webhookSink struct {
+	url    string
+	client *http.Client
+}
+
+func (s *webhookSink) Send(ctx context.Context, events []Event) error {
+	b, err := encodeEvents(events)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("changefeed webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+func (s *webhookSink) Close() error {
+	s.client.CloseIdleConnections()
+	return nil
+}
@@ -0,0 +1,135 @@
+// Package compression compresses the values stored in the kine table.
+//
+// A compressed value starts with a marker byte telling its format, followed
+// by the compressed value. The marker bytes are never the first byte of a
+// UTF-8 text, nor of the protobuf encoding of Kubernetes, which starts with
+// "k8s", so the raw values are told apart from the compressed ones.
+package compression
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// The compression algorithms.
+const (
+	None   = "none"
+	Zstd   = "zstd"
+	Snappy = "snappy"
+)
+
+// The marker bytes of the formats of the values.
+const (
+	markerZstd byte = 0xf5 + iota
+	markerSnappy
+	// markerRaw escapes the raw values starting with a marker byte.
+	markerRaw
+	// markerChunks starts the references to the values stored in chunks,
+	// followed by the decimal id of their first chunk.
+	markerChunks
+)
+
+var (
+	zstdEncoder, _ = zstd.NewWriter(nil)
+	zstdDecoder, _ = zstd.NewReader(nil)
+)
+
+// Compressor compresses the values of at least a threshold size.
+type Compressor struct {
+	algorithm string
+	threshold int
+}
+
+// New returns a compressor of the values of at least threshold bytes with
+// algorithm, which is one of None, Zstd or Snappy. A nil compressor leaves
+// the values raw.
+func New(algorithm string, threshold int) (*Compressor, error) {
+	switch algorithm {
+	case "", None:
+		return nil, nil
+	case Zstd, Snappy:
+		return &Compressor{algorithm: algorithm, threshold: threshold}, nil
+	default:
+		return nil, fmt.Errorf("unsupported compression %q (supported values are %s, %s, %s)", algorithm, None, Zstd, Snappy)
+	}
+}
+
+// Encode returns value as it is stored: compressed if it is large enough
+// and compressing it saves space, and raw otherwise. The raw values are
+// escaped even without compression, as the stored values are always
+// decoded.
+func (c *Compressor) Encode(value []byte) []byte {
+	if c != nil && len(value) > 0 && len(value) >= c.threshold {
+		var compressed []byte
+		switch c.algorithm {
+		case Zstd:
+			compressed = zstdEncoder.EncodeAll(value, []byte{markerZstd})
+		case Snappy:
+			compressed = append([]byte{markerSnappy}, snappy.Encode(nil, value)...)
+		}
+		if len(compressed) < len(value) {
+			return compressed
+		}
+	}
+	return Escape(value)
+}
+
+// Escape returns value, escaped if it starts with a marker byte, so that it
+// is read back raw.
+func Escape(value []byte) []byte {
+	if len(value) > 0 && isMarker(value[0]) {
+		return append([]byte{markerRaw}, value...)
+	}
+	return value
+}
+
+// ChunkReference returns the reference stored in place of a value stored in
+// chunks, given the id of its first chunk.
+func ChunkReference(id int64) []byte {
+	return strconv.AppendInt([]byte{markerChunks}, id, 10)
+}
+
+// ParseChunkReference returns the id of the first chunk of value, if value
+// is a reference to a value stored in chunks.
+func ParseChunkReference(value []byte) (int64, bool) {
+	if len(value) < 2 || value[0] != markerChunks {
+		return 0, false
+	}
+	id, err := strconv.ParseInt(string(value[1:]), 10, 64)
+	return id, err == nil && id > 0
+}
+
+// Decode returns the raw value of a stored value, compressed or not. The
+// values starting with a marker byte that fail to decompress are returned
+// as they are, as they can only be raw values stored without escaping.
+func Decode(value []byte) []byte {
+	if len(value) == 0 || !isMarker(value[0]) {
+		return value
+	}
+	var (
+		raw []byte
+		err error
+	)
+	switch value[0] {
+	case markerZstd:
+		raw, err = zstdDecoder.DecodeAll(value[1:], nil)
+	case markerSnappy:
+		raw, err = snappy.Decode(nil, value[1:])
+	case markerRaw:
+		return value[1:]
+	case markerChunks:
+		// The chunks are read back by the datastore.
+		return value
+	}
+	if err != nil {
+		return value
+	}
+	return raw
+}
+
+func isMarker(b byte) bool {
+	return b >= markerZstd && b <= markerChunks
+}
@@ -0,0 +1,74 @@
+package compression
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestEncodeDecode(t *testing.T) {
+	large := bytes.Repeat([]byte(`{"kind":"CustomResourceDefinition"}`), 100)
+	for _, algorithm := range []string{None, Zstd, Snappy} {
+		c, err := New(algorithm, 64)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, tc := range []struct {
+			name       string
+			value      []byte
+			compressed bool
+		}{
+			{name: "empty", value: []byte{}},
+			{name: "small", value: []byte("k8s\x00small")},
+			{name: "large", value: large, compressed: algorithm != None},
+			{name: "incompressible", value: []byte("0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ+/")},
+			{name: "marker", value: []byte{markerZstd, 1, 2, 3}},
+			{name: "escaped", value: []byte{markerRaw, 'k', '8', 's'}},
+			{name: "chunks", value: []byte{markerChunks, '4', '2'}},
+		} {
+			t.Run(algorithm+"/"+tc.name, func(t *testing.T) {
+				stored := c.Encode(tc.value)
+				if compressed := len(stored) < len(tc.value); compressed != tc.compressed {
+					t.Errorf("expected compressed %v, got %d bytes stored for %d", tc.compressed, len(stored), len(tc.value))
+				}
+				if raw := Decode(stored); !bytes.Equal(raw, tc.value) {
+					t.Errorf("expected %q, got %q", tc.value, raw)
+				}
+			})
+		}
+	}
+}
+
+func TestDecodeRaw(t *testing.T) {
+	// The raw values written without compression are read back as they
+	// are, even if they start with a marker byte.
+	for _, value := range [][]byte{nil, []byte("k8s\x00value"), {markerSnappy, 0xff, 0xff}} {
+		if raw := Decode(value); !bytes.Equal(raw, value) {
+			t.Errorf("expected %q, got %q", value, raw)
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	if c, err := New(None, 0); err != nil || c != nil {
+		t.Errorf("expected no compressor, got %v (%v)", c, err)
+	}
+	if _, err := New("lz4", 0); err == nil {
+		t.Error("expected an unsupported compression to be refused")
+	}
+}
+
+func TestChunkReference(t *testing.T) {
+	if id, ok := ParseChunkReference(ChunkReference(42)); !ok || id != 42 {
+		t.Errorf("expected chunk reference 42, got %d (%v)", id, ok)
+	}
+	for _, value := range [][]byte{nil, []byte("42"), {markerChunks}, append([]byte{markerChunks}, "4x"...), {markerRaw, markerChunks, '4'}} {
+		if _, ok := ParseChunkReference(value); ok {
+			t.Errorf("expected %q not to be a chunk reference", value)
+		}
+	}
+	// The raw values starting with the marker are escaped.
+	value := append([]byte{markerChunks}, "42"...)
+	if encoded := Escape(value); !bytes.Equal(Decode(encoded), value) {
+		t.Errorf("expected %q, got %q", value, Decode(encoded))
+	}
+}
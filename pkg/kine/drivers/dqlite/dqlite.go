@@ -3,25 +3,34 @@ package dqlite
 import (
 	"context"
 	"database/sql"
+	sqldriver "database/sql/driver"
 	"os"
 	"strings"
 
 	"github.com/canonical/go-dqlite"
 	"github.com/canonical/go-dqlite/driver"
+	"github.com/canonical/k8s-dqlite/pkg/kine/drivers"
 	"github.com/canonical/k8s-dqlite/pkg/kine/drivers/generic"
 	"github.com/canonical/k8s-dqlite/pkg/kine/drivers/sqlite"
 	"github.com/canonical/k8s-dqlite/pkg/kine/server"
 	"github.com/canonical/k8s-dqlite/pkg/kine/tls"
+	"github.com/canonical/k8s-dqlite/pkg/logging"
 	"github.com/mattn/go-sqlite3"
 	"github.com/pkg/errors"
-	"github.com/sirupsen/logrus"
 )
 
+var logger = logging.Component(logging.Driver)
+
 func init() {
 	// We assume SQLite will be used multi-threaded
 	if err := dqlite.ConfigMultiThread(); err != nil {
 		panic(errors.Wrap(err, "failed to set dqlite multithreaded mode"))
 	}
+
+	drivers.Register("dqlite", func(ctx context.Context, cfg *drivers.Config) (bool, server.Backend, error) {
+		backend, err := New(ctx, cfg.DataSourceName, cfg.Config, cfg.ConnectionPoolConfig)
+		return true, backend, err
+	})
 }
 
 func New(ctx context.Context, datasourceName string, tlsInfo tls.Config, connectionPoolConfig *generic.ConnectionPoolConfig) (server.Backend, error) {
@@ -30,7 +39,7 @@ func New(ctx context.Context, datasourceName string, tlsInfo tls.Config, connect
 }
 
 func NewVariant(ctx context.Context, datasourceName string, connectionPoolConfig *generic.ConnectionPoolConfig) (server.Backend, *generic.Generic, error) {
-	logrus.Printf("New kine for dqlite")
+	logger.Printf("New kine for dqlite")
 
 	// Driver name will be extracted from query parameters
 	backend, generic, err := sqlite.NewVariant(ctx, "", datasourceName, connectionPoolConfig)
@@ -61,16 +70,13 @@ func NewVariant(ctx context.Context, datasourceName string, connectionPoolConfig
 			return true
 		}
 
-		if strings.Contains(err.Error(), "bad connection") {
-			return true
-		}
-
 		if strings.Contains(err.Error(), "checkpoint in progress") {
 			return true
 		}
 
 		return false
 	}
+	generic.LeaderChange = isLeaderChange
 	generic.TranslateErr = func(err error) error {
 		if strings.Contains(err.Error(), "UNIQUE constraint") {
 			return server.ErrKeyExists
@@ -81,6 +87,22 @@ func NewVariant(ctx context.Context, datasourceName string, connectionPoolConfig
 	return backend, generic, nil
 }
 
+// isLeaderChange reports whether err is caused by a change of the dqlite
+// leader: the node is no longer the leader, lost its leadership while
+// committing, or no leader is elected yet. The driver reports the loss of
+// the leadership on a connection as a bad connection.
+func isLeaderChange(err error) bool {
+	cause := errors.Cause(err)
+	if err, ok := cause.(driver.Error); ok {
+		return err.Code == driver.ErrIoErrNotLeader || err.Code == driver.ErrIoErrLeadershipLost
+	}
+	if cause == driver.ErrNoAvailableLeader || cause == sqldriver.ErrBadConn {
+		return true
+	}
+	message := cause.Error()
+	return strings.Contains(message, driver.ErrNoAvailableLeader.Error()) || strings.Contains(message, "bad connection")
+}
+
 func migrate(ctx context.Context, newDB *sql.DB) (exitErr error) {
 	row := newDB.QueryRowContext(ctx, "SELECT COUNT(*) FROM kine")
 	var count int64
@@ -103,7 +125,7 @@ func migrate(ctx context.Context, newDB *sql.DB) (exitErr error) {
 
 	oldData, err := oldDB.QueryContext(ctx, "SELECT id, name, created, deleted, create_revision, prev_revision, lease, value, old_value FROM kine")
 	if err != nil {
-		logrus.Errorf("failed to find old data to migrate: %v", err)
+		logger.Errorf("failed to find old data to migrate: %v", err)
 		return nil
 	}
 	defer oldData.Close()
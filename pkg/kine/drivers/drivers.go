@@ -0,0 +1,74 @@
+package drivers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/canonical/k8s-dqlite/pkg/kine/drivers/generic"
+	"github.com/canonical/k8s-dqlite/pkg/kine/server"
+	"github.com/canonical/k8s-dqlite/pkg/kine/tls"
+)
+
+// Config is the configuration handed over to a DriverFactory.
+type Config struct {
+	// DataSourceName is the storage endpoint, stripped of its scheme.
+	DataSourceName string
+	// ConnectionPoolConfig configures the database connection pool.
+	ConnectionPoolConfig *generic.ConnectionPoolConfig
+
+	tls.Config
+}
+
+// DriverFactory builds a kine backend from the given configuration. It
+// returns whether leader election should be enabled for the backend, i.e.
+// whether the same datastore can be shared by more than one process.
+type DriverFactory func(ctx context.Context, config *Config) (leaderElect bool, backend server.Backend, err error)
+
+var (
+	factoriesMu sync.RWMutex
+	factories   = make(map[string]DriverFactory)
+)
+
+// Register makes a driver available for the storage endpoints using the
+// given scheme. Drivers call it from the init function of their package,
+// once for each scheme they serve. It panics if the scheme is registered
+// twice or if the factory is nil.
+func Register(scheme string, factory DriverFactory) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+
+	if factory == nil {
+		panic("drivers: Register factory is nil")
+	}
+	if _, dup := factories[scheme]; dup {
+		panic("drivers: Register called twice for scheme " + scheme)
+	}
+	factories[scheme] = factory
+}
+
+// Get returns the driver factory registered for scheme.
+func Get(scheme string) (DriverFactory, error) {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+
+	factory, ok := factories[scheme]
+	if !ok {
+		return nil, fmt.Errorf("storage backend %q is not defined", scheme)
+	}
+	return factory, nil
+}
+
+// Schemes returns the sorted list of registered schemes.
+func Schemes() []string {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+
+	schemes := make([]string, 0, len(factories))
+	for scheme := range factories {
+		schemes = append(schemes, scheme)
+	}
+	sort.Strings(schemes)
+	return schemes
+}
@@ -0,0 +1,71 @@
+package drivers
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/canonical/k8s-dqlite/pkg/kine/server"
+)
+
+func TestRegister(t *testing.T) {
+	factory := func(ctx context.Context, config *Config) (bool, server.Backend, error) {
+		return config.DataSourceName == "leader", nil, nil
+	}
+	Register("test-b", factory)
+	Register("test-a", factory)
+
+	if schemes := Schemes(); !reflect.DeepEqual(schemes, []string{"test-a", "test-b"}) {
+		t.Errorf("expected sorted schemes, got %v", schemes)
+	}
+
+	got, err := Get("test-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if leaderElect, _, _ := got(context.Background(), &Config{DataSourceName: "leader"}); !leaderElect {
+		t.Error("expected the registered factory to be returned")
+	}
+
+	if _, err := Get("test-c"); err == nil {
+		t.Error("expected error for unregistered scheme")
+	}
+}
+
+func TestRegisterPanics(t *testing.T) {
+	factory := func(ctx context.Context, config *Config) (bool, server.Backend, error) {
+		return false, nil, nil
+	}
+	Register("test-dup", factory)
+
+	tests := []struct {
+		name    string
+		scheme  string
+		factory DriverFactory
+	}{
+		{
+			name:    "duplicate scheme",
+			scheme:  "test-dup",
+			factory: factory,
+		},
+		{
+			name:   "nil factory",
+			scheme: "test-nil",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Error("expected Register to panic")
+				}
+			}()
+			Register(tt.scheme, tt.factory)
+		})
+	}
+
+	if _, err := Get("test-nil"); err == nil {
+		t.Error("expected nil factory not to be registered")
+	}
+}
@@ -0,0 +1,59 @@
+package generic
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/canonical/k8s-dqlite/pkg/kine/server"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+var (
+	activateAlarmSQL = `
+		INSERT INTO kine_alarms(alarm)
+		VALUES(?)`
+
+	deactivateAlarmSQL = `
+		DELETE FROM kine_alarms
+		WHERE alarm = ?`
+
+	listAlarmsSQL = `
+		SELECT alarm
+		FROM kine_alarms`
+)
+
+// ActivateAlarm persists an alarm, so that it is raised on all the members.
+// Activating an alarm that is already active is not an error.
+func (d *Generic) ActivateAlarm(ctx context.Context, alarm int32) (err error) {
+	ctx, span := otelTracer.Start(ctx, fmt.Sprintf("%s.ActivateAlarm", otelName))
+	defer func() {
+		span.RecordError(err)
+		span.End()
+	}()
+	span.SetAttributes(attribute.Int("alarm", int(alarm)))
+
+	_, err = d.execute(ctx, "activate_alarm_sql", d.ActivateAlarmSQL, alarm)
+	if err != nil && d.TranslateErr != nil && d.TranslateErr(err) == server.ErrKeyExists {
+		return nil
+	}
+	return err
+}
+
+// DeactivateAlarm removes a persisted alarm.
+func (d *Generic) DeactivateAlarm(ctx context.Context, alarm int32) (err error) {
+	ctx, span := otelTracer.Start(ctx, fmt.Sprintf("%s.DeactivateAlarm", otelName))
+	defer func() {
+		span.RecordError(err)
+		span.End()
+	}()
+	span.SetAttributes(attribute.Int("alarm", int(alarm)))
+
+	_, err = d.execute(ctx, "deactivate_alarm_sql", d.DeactivateAlarmSQL, alarm)
+	return err
+}
+
+// ListAlarms returns the active alarms.
+func (d *Generic) ListAlarms(ctx context.Context) (*sql.Rows, error) {
+	return d.query(ctx, "list_alarms_sql", d.ListAlarmsSQL)
+}
@@ -0,0 +1,264 @@
+package generic
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/canonical/k8s-dqlite/pkg/kine/prepared"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// backupRevisionSQL is the revision of a database, zero if it is empty.
+var backupRevisionSQL = `SELECT COALESCE((` + revSQL + `), 0)`
+
+// backupSchemaVersion is the user_version of the SQLite databases written by
+// Backup, so that they can be opened by the sqlite driver as they are.
+const backupSchemaVersion = 3
+
+var backupSchema = []string{
+	`CREATE TABLE kine
+	(
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL,
+		created INTEGER,
+		deleted INTEGER,
+		create_revision INTEGER NOT NULL,
+		prev_revision INTEGER,
+		lease INTEGER,
+		value BLOB,
+		old_value BLOB
+	)`,
+	`CREATE INDEX kine_name_index ON kine (name, id)`,
+	`CREATE UNIQUE INDEX kine_name_prev_revision_uindex ON kine (prev_revision, name)`,
+	`CREATE TABLE kine_leases
+	(
+		id INTEGER PRIMARY KEY,
+		ttl INTEGER NOT NULL,
+		expiry INTEGER
+	)`,
+	`CREATE TABLE kine_metadata
+	(
+		name TEXT PRIMARY KEY,
+		value INTEGER NOT NULL
+	)`,
+	`CREATE TABLE kine_chunks
+	(
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		blob INTEGER NOT NULL,
+		name TEXT NOT NULL,
+		data BLOB NOT NULL
+	)`,
+	fmt.Sprintf(`PRAGMA user_version = %d`, backupSchemaVersion),
+}
+
+// Backup writes a consistent copy of the database to a new SQLite database
+// at path, which must not exist or be empty, and returns the revision of the
+// copy. Drivers that can't produce the copy themselves through BackupSQL get
+// the rows copied in a single read-only transaction, with the BackupIsolation
+// level. This requires the sqlite3 driver to be registered. The events stored
+// apart are copied along with the other keys, which the sqlite driver stores
+// apart again when the copy is opened with the events prefix set.
+func (d *Generic) Backup(ctx context.Context, path string) (rev int64, err error) {
+	ctx, span := otelTracer.Start(ctx, fmt.Sprintf("%s.Backup", otelName))
+	defer func() {
+		span.RecordError(err)
+		span.End()
+	}()
+	span.SetAttributes(attribute.String("path", path))
+
+	if d.BackupSQL != "" {
+		if _, err := d.execute(ctx, "backup_sql", d.BackupSQL, path); err != nil {
+			return 0, err
+		}
+		return backupRevision(ctx, path, d.tableSQL(backupRevisionSQL, mainTable))
+	}
+
+	start := time.Now()
+	defer func() {
+		recordOpResult("backup", err, start)
+		recordTxResult("backup", err)
+	}()
+
+	dst, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return 0, err
+	}
+	defer dst.Close()
+
+	for _, stmt := range backupSchema {
+		if _, err := dst.ExecContext(ctx, stmt); err != nil {
+			return 0, fmt.Errorf("failed to create backup schema: %w", err)
+		}
+	}
+
+	src, err := d.DB.BeginTx(ctx, &sql.TxOptions{Isolation: d.BackupIsolation, ReadOnly: true})
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err := src.Rollback(); err != nil {
+			logger.WithError(err).Trace("can't rollback backup")
+		}
+	}()
+
+	// The revision is read in the same transaction as the rows, so
+	// that it matches the copy.
+	if rev, err = queryRevision(ctx, src, d.tableSQL(backupRevisionSQL, mainTable)); err != nil {
+		return 0, err
+	}
+	span.SetAttributes(attribute.Int64("revision", rev))
+
+	rows, err := src.QueryContext(ctx, d.allTableSQL(`SELECT id, name, created, deleted, create_revision, prev_revision, lease, value, old_value FROM kine ORDER BY id`))
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	tx, err := dst.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	insert, err := tx.PrepareContext(ctx, `INSERT INTO kine(id, name, created, deleted, create_revision, prev_revision, lease, value, old_value) VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return 0, err
+	}
+	defer insert.Close()
+
+	var count int64
+	for rows.Next() {
+		var (
+			id, createRevision                    int64
+			name                                  string
+			created, deleted, prevRevision, lease sql.NullInt64
+			value, oldValue                       []byte
+		)
+		if err := rows.Scan(&id, &name, &created, &deleted, &createRevision, &prevRevision, &lease, &value, &oldValue); err != nil {
+			return 0, err
+		}
+		if _, err := insert.ExecContext(ctx, id, name, created, deleted, createRevision, prevRevision, lease, value, oldValue); err != nil {
+			return 0, err
+		}
+		count++
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	span.SetAttributes(attribute.Int64("rows", count))
+
+	leases, err := src.QueryContext(ctx, d.ListLeasesSQL)
+	if err != nil {
+		return 0, err
+	}
+	defer leases.Close()
+
+	for leases.Next() {
+		var (
+			id, ttl int64
+			expiry  sql.NullInt64
+		)
+		if err := leases.Scan(&id, &ttl, &expiry); err != nil {
+			return 0, err
+		}
+		if _, err := tx.ExecContext(ctx, `INSERT INTO kine_leases(id, ttl, expiry) VALUES(?, ?, ?)`, id, ttl, expiry); err != nil {
+			return 0, err
+		}
+	}
+	if err := leases.Err(); err != nil {
+		return 0, err
+	}
+
+	metadata, err := src.QueryContext(ctx, `SELECT name, value FROM kine_metadata`)
+	if err != nil {
+		return 0, err
+	}
+	defer metadata.Close()
+
+	for metadata.Next() {
+		var (
+			name  string
+			value int64
+		)
+		if err := metadata.Scan(&name, &value); err != nil {
+			return 0, err
+		}
+		if _, err := tx.ExecContext(ctx, `INSERT INTO kine_metadata(name, value) VALUES(?, ?)`, name, value); err != nil {
+			return 0, err
+		}
+	}
+	if err := metadata.Err(); err != nil {
+		return 0, err
+	}
+
+	if d.ReadChunksSQL != "" {
+		if err := copyChunks(ctx, src, tx); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return rev, nil
+}
+
+// copyChunks copies the chunks of the values stored in chunks from src to
+// dst.
+func copyChunks(ctx context.Context, src *prepared.Tx, dst *sql.Tx) error {
+	rows, err := src.QueryContext(ctx, `SELECT id, blob, name, data FROM kine_chunks ORDER BY id`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			id, blob int64
+			name     string
+			data     []byte
+		)
+		if err := rows.Scan(&id, &blob, &name, &data); err != nil {
+			return err
+		}
+		if _, err := dst.ExecContext(ctx, `INSERT INTO kine_chunks(id, blob, name, data) VALUES(?, ?, ?, ?)`, id, blob, name, data); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// queryRevision returns the revision read by query in tx.
+func queryRevision(ctx context.Context, tx *prepared.Tx, query string) (rev int64, err error) {
+	rows, err := tx.QueryContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return 0, err
+		}
+		return 0, sql.ErrNoRows
+	}
+	if err := rows.Scan(&rev); err != nil {
+		return 0, err
+	}
+	return rev, nil
+}
+
+// backupRevision returns the revision of the backup at path, as read by
+// query.
+func backupRevision(ctx context.Context, path, query string) (rev int64, err error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+
+	err = db.QueryRowContext(ctx, query).Scan(&rev)
+	return rev, err
+}
@@ -0,0 +1,80 @@
+package generic
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// barrierRound is a barrier committed for the reads waiting on it.
+type barrierRound struct {
+	err  error
+	done chan struct{}
+}
+
+// readBarrier shares the barriers between the concurrent reads. A read
+// waits for the next barrier to start, which is committed after the read
+// arrived, as the barrier being committed might not be.
+type readBarrier struct {
+	mu      sync.Mutex
+	next    *barrierRound
+	running bool
+}
+
+// wait waits for a barrier committed by commit after it is called.
+func (b *readBarrier) wait(ctx context.Context, commit func(ctx context.Context) error) error {
+	b.mu.Lock()
+	if b.next == nil {
+		b.next = &barrierRound{done: make(chan struct{})}
+	}
+	round := b.next
+	if !b.running {
+		b.running = true
+		go b.run(commit)
+	}
+	b.mu.Unlock()
+
+	select {
+	case <-round.done:
+		return round.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (b *readBarrier) run(commit func(ctx context.Context) error) {
+	for {
+		b.mu.Lock()
+		round := b.next
+		b.next = nil
+		if round == nil {
+			b.running = false
+			b.mu.Unlock()
+			return
+		}
+		b.mu.Unlock()
+
+		// The barrier is not bound to any of the reads waiting on it.
+		round.err = commit(context.Background())
+		close(round.done)
+	}
+}
+
+// ReadBarrier waits for a barrier to be committed to the database after it
+// is called, if ReadBarrier is enabled. The reads following it see all the
+// writes acknowledged before it was called, even across changes of the
+// leader of the database cluster: a former leader that did not find out yet
+// that it was deposed fails to commit the barrier, rather than serving
+// stale reads. The concurrent reads share the barriers.
+func (d *Generic) ReadBarrier(ctx context.Context) error {
+	if !d.ReadBarrierEnabled {
+		return nil
+	}
+	start := time.Now()
+	err := d.readBarrier.wait(ctx, func(ctx context.Context) error {
+		_, err := d.execute(ctx, "read_barrier", d.ReadBarrierSQL)
+		return err
+	})
+	metricsReadBarrierLatency.Observe(time.Since(start).Seconds())
+	return err
+}
@@ -0,0 +1,230 @@
+package generic
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/canonical/k8s-dqlite/pkg/kine/prepared"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// batchOp is a single write waiting to be committed as part of a batch.
+type batchOp struct {
+	txName string
+	query  string
+	args   []interface{}
+	// span is the span of the caller, which the statements of the
+	// operation continue, or link to if batched with others.
+	span trace.SpanContext
+
+	rev      int64
+	inserted bool
+	err      error
+	done     chan struct{}
+}
+
+// writeBatcher coalesces concurrent writes into a single transaction. Writes
+// are queued while a batch is being committed, and the queue becomes the next
+// batch, so no latency is added when there is no contention.
+type writeBatcher struct {
+	mu      sync.Mutex
+	queue   []*batchOp
+	running bool
+
+	// ctx is used to commit the batches, so that they are not bound
+	// to any of the callers. It is cancelled when the dialect is closed.
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// start marks the batcher as running and returns the context to commit
+// the batches with. It must be called with the lock held.
+func (b *writeBatcher) start() context.Context {
+	if b.ctx == nil {
+		b.ctx, b.cancel = context.WithCancel(context.Background())
+	}
+	b.running = true
+	return b.ctx
+}
+
+// remove drops op from the queue, returning false if op was already
+// picked up by a batch. It must be called with the lock held.
+func (b *writeBatcher) remove(op *batchOp) bool {
+	for i, queued := range b.queue {
+		if queued == op {
+			b.queue = append(b.queue[:i:i], b.queue[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// close cancels the batches being committed.
+func (b *writeBatcher) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.cancel != nil {
+		b.cancel()
+	}
+}
+
+// batchInsert queues an insert statement and waits for the batch containing
+// it to be committed.
+func (d *Generic) batchInsert(ctx context.Context, txName, query string, args ...interface{}) (int64, bool, error) {
+	op := &batchOp{
+		txName: txName,
+		query:  query,
+		args:   args,
+		span:   trace.SpanContextFromContext(ctx),
+		done:   make(chan struct{}),
+	}
+
+	b := &d.batcher
+	b.mu.Lock()
+	b.queue = append(b.queue, op)
+	if !b.running {
+		go d.runBatches(b.start())
+	}
+	b.mu.Unlock()
+
+	select {
+	case <-op.done:
+		return op.rev, op.inserted, op.err
+	case <-ctx.Done():
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		// If the operation is already part of a batch, it might still be
+		// committed, just like an interrupted single write; it's up to the
+		// caller to find out.
+		b.remove(op)
+		return 0, false, ctx.Err()
+	}
+}
+
+func (d *Generic) runBatches(ctx context.Context) {
+	b := &d.batcher
+	for {
+		b.mu.Lock()
+		if len(b.queue) == 0 {
+			b.running = false
+			b.mu.Unlock()
+			return
+		}
+		n := len(b.queue)
+		if n > d.WriteBatchSize {
+			n = d.WriteBatchSize
+		}
+		batch := b.queue[:n:n]
+		b.queue = b.queue[n:]
+		b.mu.Unlock()
+
+		d.commitBatch(ctx, batch)
+	}
+}
+
+// commitBatch runs all the operations of the batch in a single transaction.
+// Should any of them fail, the transaction is rolled back and each operation
+// is retried on its own, so that errors are reported to the right caller.
+func (d *Generic) commitBatch(ctx context.Context, batch []*batchOp) {
+	defer func() {
+		for _, op := range batch {
+			close(op.done)
+		}
+	}()
+
+	if len(batch) == 1 {
+		op := batch[0]
+		op.rev, op.inserted, op.err = d.insertOne(trace.ContextWithSpanContext(ctx, op.span), op.txName, op.query, op.args...)
+		return
+	}
+
+	if err := d.tryCommitBatch(ctx, batch); err != nil {
+		logger.WithError(err).Debugf("write batch of %d operations failed, falling back to single writes", len(batch))
+		for _, op := range batch {
+			op.rev, op.inserted, op.err = d.insertOne(trace.ContextWithSpanContext(ctx, op.span), op.txName, op.query, op.args...)
+		}
+	}
+}
+
+func (d *Generic) tryCommitBatch(ctx context.Context, batch []*batchOp) (err error) {
+	links := make([]trace.Link, 0, len(batch))
+	for _, op := range batch {
+		if op.span.IsValid() {
+			links = append(links, trace.Link{SpanContext: op.span})
+		}
+	}
+	ctx, span := otelTracer.Start(ctx, fmt.Sprintf("%s.tryCommitBatch", otelName), trace.WithLinks(links...))
+	defer func() {
+		span.RecordError(err)
+		span.End()
+	}()
+	span.SetAttributes(attribute.Int("batch_size", len(batch)))
+	metricsWriteBatchSize.Observe(float64(len(batch)))
+
+	ctx, cancel := withTimeout(ctx, d.WriteTimeout)
+	defer cancel()
+
+	unlock, err := d.lockWrites(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	span.AddEvent("acquired write lock")
+
+	start := time.Now()
+	defer func() {
+		recordOpResult("write_batch", err, start)
+		recordTxResult("write_batch", err)
+	}()
+
+	tx, err := d.writeDB().BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil {
+			logger.WithError(err).Trace("can't rollback write batch")
+		}
+	}()
+
+	for _, op := range batch {
+		if op.rev, op.inserted, err = d.insertTx(ctx, tx, op.query, op.args...); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// insertTx is the transactional counterpart of insertOne.
+func (d *Generic) insertTx(ctx context.Context, tx *prepared.Tx, query string, args ...interface{}) (rev int64, inserted bool, err error) {
+	if !d.LastInsertID {
+		rows, err := tx.QueryContext(ctx, query+" RETURNING id", args...)
+		if err != nil {
+			return 0, false, err
+		}
+		defer rows.Close()
+
+		if !rows.Next() {
+			return 0, false, rows.Err()
+		}
+		if err := rows.Scan(&rev); err != nil {
+			return 0, false, err
+		}
+		return rev, true, nil
+	}
+
+	result, err := tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, false, err
+	}
+	if insertCount, err := result.RowsAffected(); err != nil {
+		return 0, false, err
+	} else if insertCount == 0 {
+		return 0, false, nil
+	}
+	rev, err = result.LastInsertId()
+	return rev, true, err
+}
@@ -0,0 +1,203 @@
+package generic
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const insertTestSQL = "INSERT INTO test(name) VALUES(?)"
+
+func TestWriteBatch(t *testing.T) {
+	tests := []struct {
+		name      string
+		batchSize int
+		names     []string
+		// failed is the index of the operations expected to fail.
+		failed []int
+	}{
+		{
+			name:      "single write",
+			batchSize: 4,
+			names:     []string{"a"},
+		},
+		{
+			name:      "single batch",
+			batchSize: 4,
+			names:     []string{"a", "b", "c", "d"},
+		},
+		{
+			name:      "multiple batches",
+			batchSize: 2,
+			names:     []string{"a", "b", "c", "d", "e"},
+		},
+		{
+			name:      "failing write in batch",
+			batchSize: 4,
+			names:     []string{"a", "b", "a", "c"},
+			failed:    []int{2},
+		},
+		{
+			name:      "failing write in later batch",
+			batchSize: 2,
+			names:     []string{"a", "b", "c", "b", "d"},
+			failed:    []int{3},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			d := newTestDialect(ctx, t, tt.batchSize)
+
+			results := queueInserts(ctx, t, d, tt.names)
+			runQueuedBatches(d)
+
+			failed := map[int]bool{}
+			for _, i := range tt.failed {
+				failed[i] = true
+			}
+			var lastRev int64
+			for i, result := range results {
+				r := <-result
+				if failed[i] {
+					if r.err == nil {
+						t.Errorf("insert %d (%s): expected error", i, tt.names[i])
+					}
+					continue
+				}
+				if r.err != nil {
+					t.Errorf("insert %d (%s): unexpected error: %v", i, tt.names[i], r.err)
+					continue
+				}
+				if !r.inserted {
+					t.Errorf("insert %d (%s): expected row to be inserted", i, tt.names[i])
+				}
+				if r.rev <= lastRev {
+					t.Errorf("insert %d (%s): expected revision greater than %d, got %d", i, tt.names[i], lastRev, r.rev)
+				}
+				lastRev = r.rev
+			}
+
+			var count int
+			if err := d.DB.Underlying().QueryRowContext(ctx, "SELECT COUNT(*) FROM test").Scan(&count); err != nil {
+				t.Fatal(err)
+			}
+			if expected := len(tt.names) - len(tt.failed); count != expected {
+				t.Errorf("expected %d rows, got %d", expected, count)
+			}
+		})
+	}
+}
+
+func TestWriteBatchCancel(t *testing.T) {
+	ctx := context.Background()
+	d := newTestDialect(ctx, t, 4)
+
+	// Hold the batcher, so that the write stays in the queue.
+	d.batcher.mu.Lock()
+	d.batcher.start()
+	d.batcher.mu.Unlock()
+
+	cancelCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if _, _, err := d.batchInsert(cancelCtx, "test", insertTestSQL, "a"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected %v, got %v", context.DeadlineExceeded, err)
+	}
+
+	d.batcher.mu.Lock()
+	queued := len(d.batcher.queue)
+	d.batcher.mu.Unlock()
+	if queued != 0 {
+		t.Fatalf("expected cancelled write to leave the queue, %d writes queued", queued)
+	}
+
+	runQueuedBatches(d)
+	var count int
+	if err := d.DB.Underlying().QueryRowContext(ctx, "SELECT COUNT(*) FROM test").Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Fatalf("expected cancelled write not to be committed, got %d rows", count)
+	}
+}
+
+func TestWriteBatchClose(t *testing.T) {
+	ctx := context.Background()
+	d := newTestDialect(ctx, t, 4)
+
+	if _, _, err := d.insert(ctx, "test", insertTestSQL, "a"); err != nil {
+		t.Fatal(err)
+	}
+	d.batcher.close()
+	if _, _, err := d.insert(ctx, "test", insertTestSQL, "b"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected %v after close, got %v", context.Canceled, err)
+	}
+}
+
+type insertResult struct {
+	rev      int64
+	inserted bool
+	err      error
+}
+
+func newTestDialect(ctx context.Context, t *testing.T, batchSize int) *Generic {
+	dbPath := path.Join(t.TempDir(), "db.sqlite") + "?_journal=WAL"
+	d, err := Open(ctx, "sqlite3", dbPath, &ConnectionPoolConfig{}, "?", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close() })
+
+	if _, err := d.DB.Underlying().ExecContext(ctx, "CREATE TABLE test (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT UNIQUE)"); err != nil {
+		t.Fatal(err)
+	}
+	d.WriteBatchSize = batchSize
+	return d
+}
+
+// queueInserts queues an insert for each name, in order, while the batcher
+// is held, so that they can be committed together by runQueuedBatches.
+func queueInserts(ctx context.Context, t *testing.T, d *Generic, names []string) []chan insertResult {
+	d.batcher.mu.Lock()
+	d.batcher.start()
+	d.batcher.mu.Unlock()
+
+	results := make([]chan insertResult, len(names))
+	for i, name := range names {
+		results[i] = make(chan insertResult, 1)
+		go func(result chan<- insertResult, name string) {
+			var r insertResult
+			r.rev, r.inserted, r.err = d.batchInsert(ctx, "test", insertTestSQL, name)
+			result <- r
+		}(results[i], name)
+
+		if err := waitQueued(d, i+1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return results
+}
+
+func waitQueued(d *Generic, n int) error {
+	for i := 0; i < 1000; i++ {
+		d.batcher.mu.Lock()
+		queued := len(d.batcher.queue)
+		d.batcher.mu.Unlock()
+		if queued == n {
+			return nil
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return fmt.Errorf("timed out waiting for %d queued writes", n)
+}
+
+// runQueuedBatches commits the queued writes and releases the batcher.
+func runQueuedBatches(d *Generic) {
+	d.runBatches(d.batcher.ctx)
+}
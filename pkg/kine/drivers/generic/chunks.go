@@ -0,0 +1,139 @@
+package generic
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+
+	"github.com/canonical/k8s-dqlite/pkg/kine/compression"
+)
+
+// chunkSweepDelay is how long the chunks are left alone after they are
+// written, so that the values being written are not taken for orphans.
+const chunkSweepDelay = 5 * time.Minute
+
+// chunkSweep tracks the chunks checked for orphans.
+type chunkSweep struct {
+	mu sync.Mutex
+	// swept is the id of the last chunk checked.
+	swept int64
+	// marks are the last chunk ids seen by the earlier sweeps, oldest
+	// first.
+	marks []chunkMark
+}
+
+// chunkMark is the last chunk id at a point in time.
+type chunkMark struct {
+	id int64
+	at time.Time
+}
+
+// chunkValue writes value in chunks if it is larger than ChunkSize, each in
+// a transaction of its own so that no replicated write is larger than a
+// chunk, and returns the reference stored in its place along with the id of
+// its first chunk. Smaller values are returned as they are, with an id of 0.
+func (d *Generic) chunkValue(ctx context.Context, key string, value []byte) ([]byte, int64, error) {
+	if d.ChunkSize <= 0 || len(value) <= d.ChunkSize {
+		return value, 0, nil
+	}
+	var id int64
+	for start := 0; start < len(value); start += d.ChunkSize {
+		end := min(start+d.ChunkSize, len(value))
+		result, err := d.execute(ctx, "insert_chunk_sql", d.InsertChunkSQL, id, key, value[start:end])
+		if err == nil && id == 0 {
+			id, err = result.LastInsertId()
+		}
+		if err != nil {
+			d.deleteChunks(ctx, key, id)
+			return nil, 0, err
+		}
+	}
+	return compression.ChunkReference(id), id, nil
+}
+
+// deleteChunks deletes the chunks of the value of key whose first chunk is
+// id, if any, after it failed to be written. The chunks left behind are
+// deleted by the sweeps.
+func (d *Generic) deleteChunks(ctx context.Context, key string, id int64) {
+	if id == 0 {
+		return
+	}
+	if _, err := d.execute(ctx, "delete_chunks_sql", d.DeleteChunksSQL, id, id, key); err != nil {
+		logger.WithError(err).Warning("failed to delete value chunks")
+	}
+}
+
+// ReadChunks returns the value of key stored in the chunks following the
+// chunk id, or nil if there are none.
+func (d *Generic) ReadChunks(ctx context.Context, key string, id int64) ([]byte, error) {
+	if d.ReadChunksSQL == "" {
+		return nil, nil
+	}
+	rows, err := d.query(ctx, "read_chunks_sql", d.ReadChunksSQL, id, id, key)
+	if err != nil {
+		return nil, err
+	}
+	return scanChunks(rows)
+}
+
+// scanChunks concatenates the chunks of rows, and closes them.
+func scanChunks(rows *sql.Rows) ([]byte, error) {
+	defer rows.Close()
+	var value []byte
+	for rows.Next() {
+		var chunk []byte
+		if err := rows.Scan(&chunk); err != nil {
+			return nil, err
+		}
+		value = append(value, chunk...)
+	}
+	return value, rows.Err()
+}
+
+// sweepChunks deletes the chunks no longer referenced by the rows of the keys,
+// which were left behind by the writes that failed. Only the chunks written
+// at least chunkSweepDelay ago are checked, each of them once.
+func (d *Generic) sweepChunks(ctx context.Context) error {
+	if d.SweepChunksSQL == "" {
+		return nil
+	}
+	d.chunkSweep.mu.Lock()
+	defer d.chunkSweep.mu.Unlock()
+
+	rows, err := d.query(ctx, "max_chunk_id_sql", d.MaxChunkIDSQL)
+	if err != nil {
+		return err
+	}
+	var last int64
+	if rows.Next() {
+		err = rows.Scan(&last)
+	}
+	rows.Close()
+	if err != nil {
+		return err
+	} else if err := rows.Err(); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	sweep := &d.chunkSweep
+	sweep.marks = append(sweep.marks, chunkMark{id: last, at: now})
+	watermark := sweep.swept
+	for len(sweep.marks) > 0 && now.Sub(sweep.marks[0].at) >= chunkSweepDelay {
+		watermark = sweep.marks[0].id
+		sweep.marks = sweep.marks[1:]
+	}
+	if watermark <= sweep.swept {
+		return nil
+	}
+	result, err := d.execute(ctx, "sweep_chunks_sql", d.allTableSQL(d.SweepChunksSQL), sweep.swept, watermark)
+	if err != nil {
+		return err
+	}
+	sweep.swept = watermark
+	if n, err := result.RowsAffected(); err == nil && n > 0 {
+		logger.Infof("Deleted %d orphaned value chunks", n)
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package generic
+
+import (
+	"context"
+	"sync"
+)
+
+const (
+	// storedOldValueSQL and dedupOldValueSQL are the old_value of the
+	// rows inserted by the updates and deletions, with and without the
+	// previous value stored.
+	storedOldValueSQL = "value AS old_value"
+	dedupOldValueSQL  = "NULL AS old_value"
+)
+
+// dedupOldValuesSQL clears the old_value of the rows between two revisions
+// that duplicates the value of their previous revision.
+var dedupOldValuesSQL = `
+	UPDATE kine
+	SET old_value = NULL
+	WHERE id > ? AND id <= ?
+		AND created = 0
+		AND old_value IS NOT NULL
+		AND old_value = (
+			SELECT pkv.value
+			FROM kine AS pkv
+			WHERE pkv.id = kine.prev_revision
+		)`
+
+// dedupProgress tracks the rows whose duplicated old values were cleared.
+type dedupProgress struct {
+	mu sync.Mutex
+	// revision is the revision up to which the rows were deduplicated.
+	revision int64
+}
+
+// dedupOldValues clears the duplicated old values of the next batch of rows
+// up to currentRevision, if DedupOldValues is set, so that the rows written
+// before are deduplicated over the compactions. The events stored apart are
+// deduplicated along with the other keys. The progress is only kept in
+// memory, so the rows are checked again after a restart.
+func (d *Generic) dedupOldValues(ctx context.Context, currentRevision int64) error {
+	if !d.DedupOldValues || d.DedupOldValuesSQL == "" {
+		return nil
+	}
+	d.dedup.mu.Lock()
+	defer d.dedup.mu.Unlock()
+
+	start := d.dedup.revision
+	if start >= currentRevision {
+		return nil
+	}
+	end := min(start+d.GetCompactBatchSize(), currentRevision)
+	tables := []string{mainTable}
+	if d.events.prefix != "" {
+		tables = append(tables, eventsTable)
+	}
+	var cleared int64
+	for _, table := range tables {
+		result, err := d.execute(ctx, "dedup_old_values_sql", d.tableSQL(d.DedupOldValuesSQL, table), start, end)
+		if err != nil {
+			return err
+		}
+		if n, err := result.RowsAffected(); err == nil {
+			cleared += n
+		}
+	}
+	d.dedup.revision = end
+	if cleared > 0 {
+		logger.Debugf("Cleared %d duplicated old values up to revision %d", cleared, end)
+	}
+	return nil
+}
@@ -0,0 +1,196 @@
+package generic
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/canonical/k8s-dqlite/pkg/kine/logstructured/sqllog"
+)
+
+const (
+	// mainTable stores the keys, and eventsTable the keys under the events
+	// prefix if they are stored apart. allTable is the view of the rows of
+	// both tables.
+	mainTable   = "kine"
+	eventsTable = "kine_events"
+	allTable    = "kine_all"
+)
+
+var (
+	// tableRegexp matches the references to the kine table in the queries,
+	// which are rewritten to read the other tables.
+	tableRegexp = regexp.MustCompile(`\bkine\b`)
+
+	// eventsRevSQL is the current revision when the events are stored
+	// apart: the revisions are shared by both tables, so it is the latest
+	// revision of either of them.
+	eventsRevSQL = `
+		SELECT MAX(rkv.id) AS id
+		FROM (
+			SELECT MAX(id) AS id FROM kine
+			UNION ALL
+			SELECT MAX(id) AS id FROM kine_events
+		) AS rkv`
+
+	// compactRevisionNames are the names in kine_metadata of the compact
+	// revisions of the tables.
+	compactRevisionNames = map[string]string{
+		mainTable:   `'compact_revision'`,
+		eventsTable: `'events_compact_revision'`,
+		allTable:    `'compact_revision', 'events_compact_revision'`,
+	}
+)
+
+// eventsRouting stores the keys under a prefix in the events table, which
+// is compacted on a schedule of its own. Both tables share the revisions,
+// so the ranges spanning them read the rows of both from allTable.
+type eventsRouting struct {
+	// prefix is the prefix of the keys stored in the events table, if
+	// they are stored apart, and start and end bound them.
+	prefix     string
+	start, end string
+
+	// queries caches the queries rewritten for the tables.
+	queries sync.Map
+}
+
+// tableQuery is a query rewritten for a table.
+type tableQuery struct {
+	query, table string
+}
+
+// table returns the table storing the keys between start, inclusive, and
+// end, exclusive.
+func (e *eventsRouting) table(start, end string) string {
+	switch {
+	case e.prefix == "" || end <= e.start || start >= e.end:
+		return mainTable
+	case start >= e.start && end <= e.end:
+		return eventsTable
+	}
+	return allTable
+}
+
+// keyTable returns the table storing key.
+func (e *eventsRouting) keyTable(key string) string {
+	if e.prefix != "" && strings.HasPrefix(key, e.prefix) {
+		return eventsTable
+	}
+	return mainTable
+}
+
+// tableSQL rewrites query, written for the kine table, to read and write
+// table instead, with the current revision of both tables. The queries are
+// left as they are unless the events are stored apart.
+func (d *Generic) tableSQL(query, table string) string {
+	if d.events.prefix == "" {
+		return query
+	}
+	key := tableQuery{query: query, table: table}
+	if cached, ok := d.events.queries.Load(key); ok {
+		return cached.(string)
+	}
+	rewritten := query
+	if table != mainTable {
+		rewritten = tableRegexp.ReplaceAllString(query, table)
+	}
+	rewritten = strings.ReplaceAll(rewritten, tableRegexp.ReplaceAllString(revSQL, table), eventsRevSQL)
+	d.events.queries.Store(key, rewritten)
+	return rewritten
+}
+
+// rangeTableSQL rewrites query for the table of the keys between start and
+// end.
+func (d *Generic) rangeTableSQL(query, start, end string) string {
+	return d.tableSQL(query, d.events.table(start, end))
+}
+
+// keyTableSQL rewrites query for the table of key.
+func (d *Generic) keyTableSQL(query, key string) string {
+	return d.tableSQL(query, d.events.keyTable(key))
+}
+
+// allTableSQL rewrites query to read the rows of both tables.
+func (d *Generic) allTableSQL(query string) string {
+	return d.tableSQL(query, allTable)
+}
+
+// setEventsPrefix stores the keys under prefix in the events table, or all
+// the keys in the kine table if empty.
+func (d *Generic) setEventsPrefix(prefix string) {
+	d.events.prefix = prefix
+	d.events.start, d.events.end = "", ""
+	if prefix != "" {
+		d.events.start, d.events.end = getPrefixRange(prefix)
+	}
+}
+
+// EventsPrefix returns the prefix of the keys stored in the events table,
+// or an empty prefix if they are stored with the other keys.
+func (d *Generic) EventsPrefix() string {
+	return d.events.prefix
+}
+
+// GetRangeCompactRevision returns the compact and current revisions of the
+// keys between start and end. Unless the keys are stored in the kine table
+// alone, it is the latest compact revision of both tables: the events table
+// is compacted on its own schedule, and the keys move between the tables as
+// the events are stored apart or not.
+func (d *Generic) GetRangeCompactRevision(ctx context.Context, start, end string) (int64, int64, error) {
+	table := allTable
+	if d.events.prefix != "" && d.events.table(start, end) == mainTable {
+		table = mainTable
+	}
+	return d.getCompactRevision(ctx, table)
+}
+
+// EventsCompactor returns the compaction of the events table, or nil if the
+// events are stored with the other keys.
+func (d *Generic) EventsCompactor() sqllog.Compactor {
+	if d.events.prefix == "" {
+		return nil
+	}
+	return eventsCompactor{d: d}
+}
+
+// eventsCompactor compacts the events table, on the schedule of the events,
+// in batches of the size set for the datastore.
+type eventsCompactor struct {
+	d *Generic
+}
+
+func (c eventsCompactor) GetCompactRevision(ctx context.Context) (int64, int64, error) {
+	return c.d.getCompactRevision(ctx, eventsTable)
+}
+
+func (c eventsCompactor) Compact(ctx context.Context, revision int64) error {
+	return c.d.compact(ctx, eventsTable, revision)
+}
+
+func (c eventsCompactor) GetCompactInterval() time.Duration {
+	if v := c.d.EventsCompactInterval; v > 0 {
+		return v
+	}
+	return c.d.GetCompactInterval()
+}
+
+func (c eventsCompactor) GetCompactBatchSize() int64 {
+	return c.d.GetCompactBatchSize()
+}
+
+func (c eventsCompactor) GetCompactBatchInterval() time.Duration {
+	return c.d.GetCompactBatchInterval()
+}
+
+func (c eventsCompactor) GetCompactRetentionDuration() time.Duration {
+	return c.d.EventsCompactRetentionDuration
+}
+
+// GetCompactRetentionRevisions returns zero, as the retention in revisions
+// of the datastore counts the revisions of all the keys.
+func (c eventsCompactor) GetCompactRetentionRevisions() int64 {
+	return 0
+}
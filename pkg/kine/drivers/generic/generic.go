@@ -7,18 +7,22 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
 
+	"github.com/canonical/k8s-dqlite/pkg/kine/broadcaster"
+	"github.com/canonical/k8s-dqlite/pkg/kine/compression"
 	"github.com/canonical/k8s-dqlite/pkg/kine/prepared"
+	"github.com/canonical/k8s-dqlite/pkg/kine/server"
+	"github.com/canonical/k8s-dqlite/pkg/logging"
 	"github.com/pkg/errors"
-	"github.com/sirupsen/logrus"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/metric"
 	"go.opentelemetry.io/otel/trace"
 )
 
+var logger = logging.Component(logging.Driver)
+
 const (
 	otelName            = "generic"
 	defaultMaxIdleConns = 2 // default from database/sql
@@ -29,13 +33,18 @@ var (
 	otelMeter        metric.Meter
 	compactCnt       metric.Int64Counter
 	compactBatchCnt  metric.Int64Counter
-	deleteRevCnt     metric.Int64Counter
 	deleteCnt        metric.Int64Counter
 	createCnt        metric.Int64Counter
 	updateCnt        metric.Int64Counter
 	fillCnt          metric.Int64Counter
 	currentRevCnt    metric.Int64Counter
 	getCompactRevCnt metric.Int64Counter
+	vacuumCnt        metric.Int64Counter
+
+	queryDuration      metric.Float64Histogram
+	execDuration       metric.Float64Histogram
+	compactDuration    metric.Float64Histogram
+	compactRowsDeleted metric.Int64Histogram
 )
 
 func init() {
@@ -44,51 +53,81 @@ func init() {
 	otelMeter = otel.Meter(otelName)
 	compactCnt, err = otelMeter.Int64Counter(fmt.Sprintf("%s.compact", otelName), metric.WithDescription("Number of compact requests"))
 	if err != nil {
-		logrus.WithError(err).Warning("Otel failed to create create counter")
+		logger.WithError(err).Warning("Otel failed to create create counter")
 	}
 	compactBatchCnt, err = otelMeter.Int64Counter(fmt.Sprintf("%s.compact_batch", otelName), metric.WithDescription("Number of compact batch requests"))
 	if err != nil {
-		logrus.WithError(err).Warning("Otel failed to create create counter")
-	}
-	deleteRevCnt, err = otelMeter.Int64Counter(fmt.Sprintf("%s.delete_rev", otelName), metric.WithDescription("Number of delete revision requests"))
-	if err != nil {
-		logrus.WithError(err).Warning("Otel failed to create create counter")
+		logger.WithError(err).Warning("Otel failed to create create counter")
 	}
 	createCnt, err = otelMeter.Int64Counter(fmt.Sprintf("%s.create", otelName), metric.WithDescription("Number of create requests"))
 	if err != nil {
-		logrus.WithError(err).Warning("Otel failed to create create counter")
+		logger.WithError(err).Warning("Otel failed to create create counter")
 	}
 	updateCnt, err = otelMeter.Int64Counter(fmt.Sprintf("%s.update", otelName), metric.WithDescription("Number of update requests"))
 	if err != nil {
-		logrus.WithError(err).Warning("Otel failed to create create counter")
+		logger.WithError(err).Warning("Otel failed to create create counter")
 	}
 	deleteCnt, err = otelMeter.Int64Counter(fmt.Sprintf("%s.delete", otelName), metric.WithDescription("Number of delete requests"))
 	if err != nil {
-		logrus.WithError(err).Warning("Otel failed to create create counter")
+		logger.WithError(err).Warning("Otel failed to create create counter")
 	}
 	fillCnt, err = otelMeter.Int64Counter(fmt.Sprintf("%s.fill", otelName), metric.WithDescription("Number of fill requests"))
 	if err != nil {
-		logrus.WithError(err).Warning("Otel failed to create create counter")
+		logger.WithError(err).Warning("Otel failed to create create counter")
 	}
 	currentRevCnt, err = otelMeter.Int64Counter(fmt.Sprintf("%s.current_revision", otelName), metric.WithDescription("Current revision"))
 	if err != nil {
-		logrus.WithError(err).Warning("Otel failed to create create counter")
+		logger.WithError(err).Warning("Otel failed to create create counter")
 	}
 	getCompactRevCnt, err = otelMeter.Int64Counter(fmt.Sprintf("%s.get_compact_revision", otelName), metric.WithDescription("Get compact revision"))
 	if err != nil {
-		logrus.WithError(err).Warning("Otel failed to create create counter")
+		logger.WithError(err).Warning("Otel failed to create create counter")
+	}
+	vacuumCnt, err = otelMeter.Int64Counter(fmt.Sprintf("%s.vacuum", otelName), metric.WithDescription("Number of vacuum requests"))
+	if err != nil {
+		logger.WithError(err).Warning("Otel failed to create create counter")
+	}
+	queryDuration, err = otelMeter.Float64Histogram(fmt.Sprintf("%s.query.duration", otelName), metric.WithDescription("Duration of the queries by tx name"), metric.WithUnit("s"))
+	if err != nil {
+		logger.WithError(err).Warning("Otel failed to create query duration histogram")
+	}
+	execDuration, err = otelMeter.Float64Histogram(fmt.Sprintf("%s.exec.duration", otelName), metric.WithDescription("Duration of the statements by tx name"), metric.WithUnit("s"))
+	if err != nil {
+		logger.WithError(err).Warning("Otel failed to create exec duration histogram")
+	}
+	compactDuration, err = otelMeter.Float64Histogram(fmt.Sprintf("%s.compact.duration", otelName), metric.WithDescription("Duration of the compactions"), metric.WithUnit("s"))
+	if err != nil {
+		logger.WithError(err).Warning("Otel failed to create compact duration histogram")
+	}
+	compactRowsDeleted, err = otelMeter.Int64Histogram(fmt.Sprintf("%s.compact.rows_deleted", otelName), metric.WithDescription("Number of rows deleted by the compactions"))
+	if err != nil {
+		logger.WithError(err).Warning("Otel failed to create compact rows deleted histogram")
 	}
-
 }
 
 var (
-	columns = "kv.id as theid, kv.name, kv.created, kv.deleted, kv.create_revision, kv.prev_revision, kv.lease, kv.value, kv.old_value"
+	columns = "kv.id as theid, kv.name, kv.created, kv.deleted, kv.create_revision, kv.prev_revision, kv.lease, kv.value, " + oldValueSQL
+
+	// oldValueSQL is the previous value of a row: its old_value or, if it
+	// was not stored, the value of its previous revision, which the
+	// compactions only delete along with the revisions following it.
+	oldValueSQL = `COALESCE(kv.old_value, (
+			SELECT pkv.value
+			FROM kine AS pkv
+			WHERE pkv.id = kv.prev_revision AND kv.created = 0
+		)) AS old_value`
 
 	revSQL = `
 		SELECT MAX(rkv.id) AS id
 		FROM kine AS rkv`
 
-	listSQL = fmt.Sprintf(`
+	// keyColumns are the columns of a keys only list, which don't read
+	// the values from disk.
+	keyColumns = "kv.id as theid, kv.name, kv.created, kv.deleted, kv.create_revision, kv.prev_revision, kv.lease, NULL AS value, NULL AS old_value"
+
+	// listSQL lists the latest revision of the keys in a range. It is
+	// formatted with the selected columns and a condition on the revisions.
+	listSQL = `
 		SELECT %s
 		FROM kine kv
 		JOIN (
@@ -96,13 +135,12 @@ var (
 			FROM kine mkv
 			WHERE
 				mkv.name >= ? AND mkv.name < ?
-				%%s
+				%s
 			GROUP BY mkv.name) maxkv
 	    	ON maxkv.id = kv.id
 		WHERE
 			  (kv.deleted = 0 OR ?)
-		ORDER BY kv.name ASC, kv.id ASC
-	`, columns)
+		` + listOrderBy
 
 	revisionAfterSQL = fmt.Sprintf(`
 		SELECT *
@@ -123,15 +161,39 @@ var (
 		ORDER BY lkv.name ASC, lkv.theid ASC
 	`, columns)
 
+	// revisionIntervalSQL is the compact and current revisions, formatted
+	// with the names of the compact revisions in kine_metadata, the latest
+	// of which is reported.
 	revisionIntervalSQL = `
 		SELECT (
-			SELECT MAX(prev_revision)
-			FROM kine
-			WHERE name = 'compact_rev_key'
-		) AS low, (
-			SELECT MAX(id)
-			FROM kine
+			SELECT MAX(value)
+			FROM kine_metadata
+			WHERE name IN (%s)
+		) AS low, (` + revSQL + `
 		) AS high`
+
+	// fillRowSQL matches the rows inserted by Fill, formatted with the
+	// condition on the name Fill gives them in the dialect. Only their
+	// exact shape is matched, so that the keys which happen to start with
+	// "gap-" are left alone.
+	fillRowSQL = `
+		deleted = 1
+		AND create_revision = 0
+		AND prev_revision = 0
+		AND value IS NULL
+		AND %s`
+
+	// compactFillSQL deletes the rows inserted by Fill up to a revision.
+	compactFillSQL = `
+		DELETE FROM kine
+		WHERE id <= ? AND ` + fillRowSQL
+
+	// countFillSQL counts the rows inserted by Fill after a revision, as
+	// the ones up to it are deleted by the compactions.
+	countFillSQL = `
+		SELECT COUNT(*)
+		FROM kine
+		WHERE id > ? AND ` + fillRowSQL
 )
 
 const maxRetries = 500
@@ -148,36 +210,180 @@ type TranslateErr func(error) error
 type ErrCode func(error) string
 
 type Generic struct {
-	sync.Mutex
-
-	LockWrites           bool
-	DB                   *prepared.DB
+	// LockWrites serializes the writes through a queue.
+	LockWrites   bool
+	LastInsertID bool
+	DB           *prepared.DB
+	// WriteDB is a separate connection pool for the writes, if the
+	// driver opened one with OpenWriteDB. Otherwise, DB is used.
+	WriteDB *prepared.DB
+	// PollDB is the connection pool of the watch poll loop, so that
+	// polling is never starved by the other queries.
+	PollDB *prepared.DB
+	// BackupIsolation is the isolation level of the transaction copying
+	// the rows in Backup, which must read a consistent snapshot.
+	BackupIsolation      sql.IsolationLevel
 	GetCurrentSQL        string
 	RevisionSQL          string
 	ListRevisionStartSQL string
 	GetRevisionAfterSQL  string
 	CountCurrentSQL      string
 	CountRevisionSQL     string
-	AfterSQLPrefix       string
-	AfterSQL             string
-	DeleteRevSQL         string
-	CompactSQL           string
-	UpdateCompactSQL     string
+	// CountPrefixSQL counts the current keys under a prefix ending with a
+	// slash from the numbers of keys maintained by the database, if any,
+	// rather than scanning them.
+	CountPrefixSQL    string
+	AfterSQLPrefix    string
+	AfterSQL          string
+	CompactSQL        string
+	CompactDeletedSQL string
+	// DedupOldValuesSQL clears the old_value of the rows between two
+	// revisions that duplicates the value of their previous revision.
+	DedupOldValuesSQL string
+	// CompactFillSQL deletes the rows inserted by Fill up to a revision,
+	// including the ones left behind by the earlier compactions, and
+	// CountFillSQL counts the ones after a revision. They are set by
+	// SetFillNameSQL.
+	CompactFillSQL   string
+	CountFillSQL     string
+	UpdateCompactSQL string
+	// UpdateEventsCompactSQL records the compact revision of the events
+	// table, if the driver can store the events apart from the other
+	// keys.
+	UpdateEventsCompactSQL string
+	// ReadBarrierSQL is the write committed as a read barrier. It leaves
+	// the database unchanged.
+	ReadBarrierSQL       string
 	DeleteSQL            string
 	FillSQL              string
 	CreateSQL            string
 	UpdateSQL            string
 	GetSizeSQL           string
-	Retry                ErrRetry
-	TranslateErr         TranslateErr
-	ErrCode              ErrCode
+	PageStatsSQL         string
+	VacuumSQL            string
+	IncrementalVacuumSQL string
+	CheckpointSQL        string
+	BackupSQL            string
+	HashSQL              string
+	HistorySQL           string
+	KeyStatsSQL          string
+	IntegrityCheckSQL    string
+	InvariantsSQL        string
+	RevisionGapsSQL      string
+	GrantLeaseSQL        string
+	CheckpointLeaseSQL   string
+	RevokeLeaseSQL       string
+	ListLeasesSQL        string
+	GetLeaseSQL          string
+	ActivateAlarmSQL     string
+	DeactivateAlarmSQL   string
+	ListAlarmsSQL        string
+	// InsertChunkSQL, ReadChunksSQL, DeleteChunksSQL, MaxChunkIDSQL and
+	// SweepChunksSQL write, read and delete the chunks of the values
+	// stored in chunks, if the driver supports them.
+	InsertChunkSQL  string
+	ReadChunksSQL   string
+	DeleteChunksSQL string
+	MaxChunkIDSQL   string
+	SweepChunksSQL  string
+	Retry           ErrRetry
+	// LeaderChange reports whether an error is caused by a change of the
+	// leader of the database cluster. The operations failing with such an
+	// error are retried after a backoff, while the new leader is elected.
+	LeaderChange ErrRetry
+	TranslateErr TranslateErr
+	ErrCode      ErrCode
 
 	// CompactInterval is interval between database compactions performed by kine.
 	CompactInterval time.Duration
+	// CompactBatchSize is the maximum number of revisions removed in a single
+	// compaction transaction. If zero, batches of 1000 revisions are used.
+	CompactBatchSize int64
+	// CompactBatchInterval is the pause between two consecutive compaction batches.
+	CompactBatchInterval time.Duration
+	// CompactRetentionDuration enables periodic compaction, retaining the
+	// revisions created in the given time window.
+	CompactRetentionDuration time.Duration
+	// CompactRetentionRevisions enables revision compaction, retaining the
+	// given number of most recent revisions.
+	CompactRetentionRevisions int64
 	// PollInterval is the event poll interval used by kine.
 	PollInterval time.Duration
+	// MaxPollInterval is the interval up to which polling backs off while
+	// no events are found. Polling doesn't back off if it is not greater
+	// than PollInterval.
+	MaxPollInterval time.Duration
 	// WatchQueryTimeout is the timeout on the after query in the poll loop.
 	WatchQueryTimeout time.Duration
+	// VacuumInterval is the interval between scheduled database vacuums.
+	// If zero, the database is never vacuumed in the background.
+	VacuumInterval time.Duration
+	// VacuumMode is either VacuumFull or VacuumIncremental, and applies to
+	// both the scheduled vacuums and the ones requested through Defragment.
+	VacuumMode string
+	// VacuumFreePages is the minimum number of free pages in the
+	// database for a scheduled vacuum to run.
+	VacuumFreePages int64
+	// WriteBatchSize is the maximum number of concurrent writes committed
+	// in a single transaction. Values lower than 2 disable write batching.
+	WriteBatchSize int
+	// WatchCacheSize is the number of recent events kept in memory, so
+	// that watches catching up don't query the database. If zero, the
+	// default size is used, and if negative, no events are kept.
+	WatchCacheSize int
+	// WatchQueueSize is the number of events queued for each watch. The
+	// watches falling further behind are canceled, so that clients
+	// re-establish them. If zero, the default size is used.
+	WatchQueueSize int
+	// ReadTimeout, WriteTimeout and CompactTimeout bound the duration of
+	// the read queries, of the writes and of the compaction batches. If
+	// zero, queries run as long as their context allows.
+	ReadTimeout    time.Duration
+	WriteTimeout   time.Duration
+	CompactTimeout time.Duration
+	// SlowQueryThreshold is the duration after which queries are logged
+	// as slow. If zero, slow queries are not logged.
+	SlowQueryThreshold time.Duration
+	// SlowQueryRedactArgs hides the arguments of the slow queries.
+	SlowQueryRedactArgs bool
+	// WriteQueueTimeout is how long the writes wait for their turn when
+	// writes are locked. If zero, they wait as long as their context
+	// allows.
+	WriteQueueTimeout time.Duration
+	// ReadBarrierEnabled makes ReadBarrier commit a barrier to the
+	// database, for the linearizable reads.
+	ReadBarrierEnabled bool
+	// Compressor compresses the values written. If nil, they are written
+	// raw.
+	Compressor *compression.Compressor
+	// ChunkSize is the maximum size of the values stored in the kine
+	// table. The larger values are stored in chunks of this size, each
+	// written in a transaction of its own. If not positive, the values
+	// are stored whole.
+	ChunkSize int
+	// DedupOldValues stops storing the previous values of the updates and
+	// deletions, which are read back from the previous revisions instead,
+	// and clears the ones stored before over the compactions.
+	DedupOldValues bool
+	// EventsCompactInterval is the interval between the compactions of
+	// the events table. If not positive, CompactInterval is used.
+	EventsCompactInterval time.Duration
+	// EventsCompactRetentionDuration retains the revisions of the events
+	// created in the given time window. If not positive, only the most
+	// recent revisions are retained.
+	EventsCompactRetentionDuration time.Duration
+	// CommitNotify receives a value when a transaction is committed to the
+	// database, if the driver supports it.
+	CommitNotify <-chan struct{}
+
+	paramCharacter string
+	numbered       bool
+	batcher        writeBatcher
+	writes         writeQueue
+	readBarrier    readBarrier
+	chunkSweep     chunkSweep
+	dedup          dedupProgress
+	events         eventsRouting
 }
 
 type ConnectionPoolConfig struct {
@@ -195,7 +401,7 @@ func configureConnectionPooling(connPoolConfig *ConnectionPoolConfig, db *sql.DB
 		connPoolConfig.MaxIdle = defaultMaxIdleConns
 	}
 
-	logrus.Infof(
+	logger.Infof(
 		"Configuring database connection pooling: maxIdleConns=%d, maxOpenConns=%d, connMaxLifetime=%v, connMaxIdleTime=%v ",
 		connPoolConfig.MaxIdle,
 		connPoolConfig.MaxOpen,
@@ -251,7 +457,7 @@ func Open(ctx context.Context, driverName, dataSourceName string, connPoolConfig
 			break
 		}
 
-		logrus.Errorf("failed to ping connection: %v", err)
+		logger.Errorf("failed to ping connection: %v", err)
 		select {
 		case <-ctx.Done():
 			return nil, ctx.Err()
@@ -261,24 +467,37 @@ func Open(ctx context.Context, driverName, dataSourceName string, connPoolConfig
 
 	configureConnectionPooling(connPoolConfig, db)
 
-	return &Generic{
-		DB: prepared.New(db),
+	pollDB, err := openReserved(driverName, dataSourceName, connPoolConfig.MaxLifetime)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	d := &Generic{
+		DB:              prepared.New(db),
+		PollDB:          pollDB,
+		LastInsertID:    true,
+		BackupIsolation: sql.LevelSerializable,
 
-		GetCurrentSQL:        q(fmt.Sprintf(listSQL, ""), paramCharacter, numbered),
-		ListRevisionStartSQL: q(fmt.Sprintf(listSQL, "AND mkv.id <= ?"), paramCharacter, numbered),
+		paramCharacter: paramCharacter,
+		numbered:       numbered,
+
+		GetCurrentSQL:        q(fmt.Sprintf(listSQL, columns, ""), paramCharacter, numbered),
+		RevisionSQL:          revSQL,
+		ListRevisionStartSQL: q(fmt.Sprintf(listSQL, columns, "AND mkv.id <= ?"), paramCharacter, numbered),
 		GetRevisionAfterSQL:  q(revisionAfterSQL, paramCharacter, numbered),
 
 		CountCurrentSQL: q(fmt.Sprintf(`
 			SELECT (%s), COUNT(*)
 			FROM (
 				%s
-			) c`, revSQL, fmt.Sprintf(listSQL, "")), paramCharacter, numbered),
+			) c`, revSQL, fmt.Sprintf(listSQL, "kv.id AS theid", "")), paramCharacter, numbered),
 
 		CountRevisionSQL: q(fmt.Sprintf(`
 			SELECT (%s), COUNT(c.theid)
 			FROM (
 				%s
-			) c`, revSQL, fmt.Sprintf(listSQL, "AND mkv.id <= ?")), paramCharacter, numbered),
+			) c`, revSQL, fmt.Sprintf(listSQL, "kv.id AS theid", "AND mkv.id <= ?")), paramCharacter, numbered),
 
 		AfterSQLPrefix: q(fmt.Sprintf(`
 			SELECT %s
@@ -295,14 +514,57 @@ func Open(ctx context.Context, driverName, dataSourceName string, connPoolConfig
 				ORDER BY kv.id ASC
 		`, columns), paramCharacter, numbered),
 
-		DeleteRevSQL: q(`
+		DedupOldValuesSQL: q(dedupOldValuesSQL, paramCharacter, numbered),
+
+		HashSQL:         q(hashSQL, paramCharacter, numbered),
+		HistorySQL:      q(historySQL, paramCharacter, numbered),
+		KeyStatsSQL:     keyStatsSQL,
+		InvariantsSQL:   invariantsSQL,
+		RevisionGapsSQL: q(revisionGapsSQL, paramCharacter, numbered),
+
+		GrantLeaseSQL:      q(grantLeaseSQL, paramCharacter, numbered),
+		CheckpointLeaseSQL: q(checkpointLeaseSQL, paramCharacter, numbered),
+		RevokeLeaseSQL:     q(revokeLeaseSQL, paramCharacter, numbered),
+		ListLeasesSQL:      listLeasesSQL,
+		GetLeaseSQL:        q(getLeaseSQL, paramCharacter, numbered),
+
+		ActivateAlarmSQL:   q(activateAlarmSQL, paramCharacter, numbered),
+		DeactivateAlarmSQL: q(deactivateAlarmSQL, paramCharacter, numbered),
+		ListAlarmsSQL:      listAlarmsSQL,
+
+		// This query adds `created = 0` as a condition
+		// to mitigate a bug in vXXX where newly created
+		// keys had `prev_revision = max(id)` instead of 0.
+		// Even with the bug fixed, we still need to check
+		// for that in older rows and if older peers are
+		// still running. Given that we are not yet using
+		// an index, it won't change much in performance
+		// however, it should be included in a covering
+		// index if ever necessary.
+		CompactSQL: q(`
+			DELETE FROM kine
+			WHERE id IN (
+				SELECT prev_revision
+				FROM kine
+				WHERE created = 0
+					AND prev_revision != 0
+					AND ? < id AND id <= ?
+			)`, paramCharacter, numbered),
+
+		CompactDeletedSQL: q(`
 			DELETE FROM kine
-			WHERE id = ?`, paramCharacter, numbered),
+			WHERE deleted = 1
+				AND ? < id AND id <= ?`, paramCharacter, numbered),
 
 		UpdateCompactSQL: q(`
-			UPDATE kine
-			SET prev_revision = max(prev_revision, ?)
-			WHERE name = 'compact_rev_key'`, paramCharacter, numbered),
+			UPDATE kine_metadata
+			SET value = ?
+			WHERE name = 'compact_revision' AND value < ?`, paramCharacter, numbered),
+
+		ReadBarrierSQL: `
+			UPDATE kine_metadata
+			SET value = value
+			WHERE name = 'compact_revision'`,
 
 		DeleteSQL: q(`
 			INSERT INTO kine(name, created, deleted, create_revision, prev_revision, lease, value, old_value)
@@ -360,11 +622,72 @@ func Open(ctx context.Context, driverName, dataSourceName string, connPoolConfig
 
 		FillSQL: q(`INSERT INTO kine(id, name, created, deleted, create_revision, prev_revision, lease, value, old_value)
 			VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?)`, paramCharacter, numbered),
-	}, err
+	}
+	d.SetFillNameSQL(`name = 'gap-' || id`)
+	poolStats.add(d)
+	return d, err
+}
+
+// OpenWriteDB opens a pool of a single connection for the writes, which are
+// then serialized rather than contending for the database lock, and never
+// wait for the connections busy with long reads.
+func (d *Generic) OpenWriteDB(driverName, dataSourceName string) error {
+	db, err := openReserved(driverName, dataSourceName, 0)
+	if err != nil {
+		return err
+	}
+	d.WriteDB = db
+	return nil
+}
+
+// openReserved opens a pool of a single connection, which is reserved to
+// some of the queries.
+func openReserved(driverName, dataSourceName string, maxLifetime time.Duration) (*prepared.DB, error) {
+	db, err := openAndTest(driverName, dataSourceName)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+	db.SetMaxIdleConns(1)
+	db.SetConnMaxLifetime(maxLifetime)
+	db.SetConnMaxIdleTime(0)
+	return prepared.New(db), nil
+}
+
+// writeDB returns the connection pool of the writes.
+func (d *Generic) writeDB() *prepared.DB {
+	if d.WriteDB != nil {
+		return d.WriteDB
+	}
+	return d.DB
+}
+
+// param returns the placeholder for the n-th (1-based) argument of a query.
+func (d *Generic) param(n int) string {
+	if d.numbered {
+		return d.paramCharacter + strconv.Itoa(n)
+	}
+	return d.paramCharacter
+}
+
+// limitSQL appends a LIMIT clause to query, bound to its n-th argument.
+func (d *Generic) limitSQL(query string, n int) string {
+	return fmt.Sprintf("%s LIMIT %s", query, d.param(n))
 }
 
 func (d *Generic) Close() error {
-	return d.DB.Close()
+	d.batcher.close()
+	poolStats.remove(d)
+	var firstErr error
+	for _, db := range []*prepared.DB{d.WriteDB, d.PollDB, d.DB} {
+		if db == nil {
+			continue
+		}
+		if err := db.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
 }
 
 func getPrefixRange(prefix string) (start, end string) {
@@ -380,6 +703,30 @@ func getPrefixRange(prefix string) (start, end string) {
 }
 
 func (d *Generic) query(ctx context.Context, txName, query string, args ...interface{}) (rows *sql.Rows, err error) {
+	ctx = d.readContext(ctx)
+	return d.queryDB(ctx, d.DB, txName, query, args...)
+}
+
+// readContext bounds ctx by the read timeout. As the rows are read after
+// the query returns, the context is only released once it is done.
+func (d *Generic) readContext(ctx context.Context) context.Context {
+	if timeout := d.ReadTimeout; timeout > 0 {
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		context.AfterFunc(ctx, cancel)
+		return ctx
+	}
+	return ctx
+}
+
+// withTimeout bounds ctx by timeout, if any.
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout > 0 {
+		return context.WithTimeout(ctx, timeout)
+	}
+	return ctx, func() {}
+}
+
+func (d *Generic) queryDB(ctx context.Context, db *prepared.DB, txName, query string, args ...interface{}) (rows *sql.Rows, err error) {
 	ctx, span := otelTracer.Start(ctx, fmt.Sprintf("%s.query", otelName))
 	defer func() {
 		span.RecordError(err)
@@ -396,18 +743,20 @@ func (d *Generic) query(ctx context.Context, txName, query string, args ...inter
 			err = fmt.Errorf("query (try: %d): %w", retryCount, err)
 		}
 		recordOpResult(txName, err, start)
+		queryDuration.Record(ctx, time.Since(start).Seconds(), metric.WithAttributes(attribute.String("tx_name", txName)))
+		d.logSlowQuery(txName, query, args, start, -1)
 	}()
 	for ; retryCount < maxRetries; retryCount++ {
 		if retryCount == 0 {
-			logrus.Tracef("QUERY (try: %d) %v : %s", retryCount, args, Stripped(query))
+			logger.Tracef("QUERY (try: %d) %v : %s", retryCount, args, Stripped(query))
 		} else {
-			logrus.Debugf("QUERY (try: %d) %v : %s", retryCount, args, Stripped(query))
+			logger.Debugf("QUERY (try: %d) %v : %s", retryCount, args, Stripped(query))
 		}
-		rows, err = d.DB.QueryContext(ctx, query, args...)
+		rows, err = db.QueryContext(ctx, query, args...)
 		if err == nil {
 			break
 		}
-		if d.Retry == nil || !d.Retry(err) {
+		if !d.shouldRetry(ctx, txName, err, retryCount, start) {
 			break
 		}
 	}
@@ -427,11 +776,14 @@ func (d *Generic) execute(ctx context.Context, txName, query string, args ...int
 		attribute.String("tx_name", txName),
 	)
 
-	if d.LockWrites {
-		d.Lock()
-		defer d.Unlock()
-		span.AddEvent("acquired write lock")
+	ctx, cancel := withTimeout(ctx, d.WriteTimeout)
+	defer cancel()
+	unlock, err := d.lockWrites(ctx)
+	if err != nil {
+		return nil, err
 	}
+	defer unlock()
+	span.AddEvent("acquired write lock")
 
 	start := time.Now()
 	retryCount := 0
@@ -440,18 +792,26 @@ func (d *Generic) execute(ctx context.Context, txName, query string, args ...int
 			err = fmt.Errorf("exec (try: %d): %w", retryCount, err)
 		}
 		recordOpResult(txName, err, start)
+		execDuration.Record(ctx, time.Since(start).Seconds(), metric.WithAttributes(attribute.String("tx_name", txName)))
+		rows := int64(-1)
+		if err == nil {
+			if n, err := result.RowsAffected(); err == nil {
+				rows = n
+			}
+		}
+		d.logSlowQuery(txName, query, args, start, rows)
 	}()
 	for ; retryCount < maxRetries; retryCount++ {
 		if retryCount > 2 {
-			logrus.Debugf("EXEC (try: %d) %v : %s", retryCount, args, Stripped(query))
+			logger.Debugf("EXEC (try: %d) %v : %s", retryCount, args, Stripped(query))
 		} else {
-			logrus.Tracef("EXEC (try: %d) %v : %s", retryCount, args, Stripped(query))
+			logger.Tracef("EXEC (try: %d) %v : %s", retryCount, args, Stripped(query))
 		}
-		result, err = d.DB.ExecContext(ctx, query, args...)
+		result, err = d.writeDB().ExecContext(ctx, query, args...)
 		if err == nil {
 			break
 		}
-		if d.Retry == nil || !d.Retry(err) {
+		if !d.shouldRetry(ctx, txName, err, retryCount, start) {
 			break
 		}
 	}
@@ -460,46 +820,90 @@ func (d *Generic) execute(ctx context.Context, txName, query string, args ...int
 	return result, err
 }
 
-func (d *Generic) CountCurrent(ctx context.Context, prefix string, startKey string) (int64, int64, error) {
-	var (
-		rev sql.NullInt64
-		id  int64
-	)
+// insert runs an INSERT ... SELECT statement and returns the revision of the
+// inserted row. If the statement inserted nothing, inserted is false. When
+// write batching is enabled, the statement is committed together with other
+// concurrent writes.
+func (d *Generic) insert(ctx context.Context, txName, query string, args ...interface{}) (rev int64, inserted bool, err error) {
+	if d.WriteBatchSize > 1 {
+		return d.batchInsert(ctx, txName, query, args...)
+	}
+	return d.insertOne(ctx, txName, query, args...)
+}
+
+// insertOne runs an insert statement in its own transaction. Drivers that
+// can't report the last insert id get the revision via a RETURNING clause.
+func (d *Generic) insertOne(ctx context.Context, txName, query string, args ...interface{}) (rev int64, inserted bool, err error) {
+	if !d.LastInsertID {
+		ctx, cancel := withTimeout(ctx, d.WriteTimeout)
+		defer cancel()
+		rows, err := d.queryDB(ctx, d.writeDB(), txName, query+" RETURNING id", args...)
+		if err != nil {
+			return 0, false, err
+		}
+		defer rows.Close()
 
+		if !rows.Next() {
+			return 0, false, rows.Err()
+		}
+		if err := rows.Scan(&rev); err != nil {
+			return 0, false, err
+		}
+		return rev, true, nil
+	}
+
+	result, err := d.execute(ctx, txName, query, args...)
+	if err != nil {
+		return 0, false, err
+	}
+	if insertCount, err := result.RowsAffected(); err != nil {
+		return 0, false, err
+	} else if insertCount == 0 {
+		return 0, false, nil
+	}
+	rev, err = result.LastInsertId()
+	return rev, true, err
+}
+
+func (d *Generic) CountCurrent(ctx context.Context, prefix string, startKey string) (int64, int64, error) {
 	start, end := getPrefixRange(prefix)
+	if d.CountPrefixSQL != "" && startKey == prefix && strings.HasSuffix(prefix, "/") {
+		// The key named after the prefix is not counted either way.
+		return d.count(ctx, "count_prefix", d.rangeTableSQL(d.CountPrefixSQL, start, end), start, end)
+	}
 	if startKey != "" {
 		start = startKey + "\x01"
 	}
-	rows, err := d.query(ctx, "count_current", d.CountCurrentSQL, start, end, false)
-	if err != nil {
-		return 0, 0, err
-	}
-	defer rows.Close()
+	return d.count(ctx, "count_current", d.rangeTableSQL(d.CountCurrentSQL, start, end), start, end, false)
+}
 
-	if !rows.Next() {
-		if err := rows.Err(); err != nil {
-			return 0, 0, err
-		}
-		return 0, 0, sql.ErrNoRows
+func (d *Generic) Count(ctx context.Context, prefix, startKey string, revision int64) (int64, int64, error) {
+	start, end := getPrefixRange(prefix)
+	if startKey != "" {
+		start = startKey + "\x01"
 	}
+	return d.count(ctx, "count_revision", d.rangeTableSQL(d.CountRevisionSQL, start, end), start, end, revision, false)
+}
 
-	if err := rows.Scan(&rev, &id); err != nil {
-		return 0, 0, err
+// CountRange counts the keys between start, inclusive, and end, exclusive,
+// at revision, or at the current revision if zero.
+func (d *Generic) CountRange(ctx context.Context, start, end string, revision int64, opts server.RangeOptions) (int64, int64, error) {
+	if revision == 0 {
+		query, args := d.filterSQL(d.CountCurrentSQL, []interface{}{start, end, false}, opts)
+		return d.count(ctx, "count_range_current", d.rangeTableSQL(query, start, end), args...)
 	}
-	return rev.Int64, id, nil
+	query, args := d.filterSQL(d.CountRevisionSQL, []interface{}{start, end, revision, false}, opts)
+	return d.count(ctx, "count_range_revision", d.rangeTableSQL(query, start, end), args...)
 }
 
-func (d *Generic) Count(ctx context.Context, prefix, startKey string, revision int64) (int64, int64, error) {
+// count runs a count query, returning the current revision and the count.
+func (d *Generic) count(ctx context.Context, txName, query string, args ...interface{}) (int64, int64, error) {
 	var (
 		rev sql.NullInt64
 		id  int64
 	)
 
-	start, end := getPrefixRange(prefix)
-	if startKey != "" {
-		start = startKey + "\x01"
-	}
-	rows, err := d.query(ctx, "count_revision", d.CountRevisionSQL, start, end, revision, false)
+	rows, err := d.query(ctx, txName, query, args...)
 	if err != nil {
 		return 0, 0, err
 	}
@@ -515,7 +919,7 @@ func (d *Generic) Count(ctx context.Context, prefix, startKey string, revision i
 	if err := rows.Scan(&rev, &id); err != nil {
 		return 0, 0, err
 	}
-	return rev.Int64, id, err
+	return rev.Int64, id, nil
 }
 
 func (d *Generic) Create(ctx context.Context, key string, value []byte, ttl int64) (rev int64, succeeded bool, err error) {
@@ -537,18 +941,20 @@ func (d *Generic) Create(ctx context.Context, key string, value []byte, ttl int6
 	)
 	createCnt.Add(ctx, 1)
 
-	result, err := d.execute(ctx, "create_sql", d.CreateSQL, key, ttl, value, key)
+	value, chunks, err := d.chunkValue(ctx, key, d.Compressor.Encode(value))
 	if err != nil {
-		logrus.WithError(err).Error("failed to create key")
+		logger.WithError(err).Error("failed to write value chunks")
 		return 0, false, err
 	}
-	if insertCount, err := result.RowsAffected(); err != nil {
+	rev, succeeded, err = d.insert(ctx, "create_sql", d.keyTableSQL(d.CreateSQL, key), key, ttl, value, key)
+	if err == nil && !succeeded {
+		d.deleteChunks(ctx, key, chunks)
+	}
+	if err != nil {
+		logger.WithError(err).Error("failed to create key")
 		return 0, false, err
-	} else if insertCount == 0 {
-		return 0, false, nil
 	}
-	rev, err = result.LastInsertId()
-	return rev, true, err
+	return rev, succeeded, nil
 }
 
 func (d *Generic) Update(ctx context.Context, key string, value []byte, preRev, ttl int64) (rev int64, updated bool, err error) {
@@ -564,18 +970,20 @@ func (d *Generic) Update(ctx context.Context, key string, value []byte, preRev,
 	}()
 
 	updateCnt.Add(ctx, 1)
-	result, err := d.execute(ctx, "update_sql", d.UpdateSQL, key, ttl, value, key, preRev)
+	value, chunks, err := d.chunkValue(ctx, key, d.Compressor.Encode(value))
 	if err != nil {
-		logrus.WithError(err).Error("failed to update key")
+		logger.WithError(err).Error("failed to write value chunks")
 		return 0, false, err
 	}
-	if insertCount, err := result.RowsAffected(); err != nil {
+	rev, updated, err = d.insert(ctx, "update_sql", d.keyTableSQL(d.UpdateSQL, key), key, ttl, value, key, preRev)
+	if err == nil && !updated {
+		d.deleteChunks(ctx, key, chunks)
+	}
+	if err != nil {
+		logger.WithError(err).Error("failed to update key")
 		return 0, false, err
-	} else if insertCount == 0 {
-		return 0, false, nil
 	}
-	rev, err = result.LastInsertId()
-	return rev, true, err
+	return rev, updated, nil
 }
 
 func (d *Generic) Delete(ctx context.Context, key string, revision int64) (rev int64, deleted bool, err error) {
@@ -589,35 +997,44 @@ func (d *Generic) Delete(ctx context.Context, key string, revision int64) (rev i
 	}()
 	span.SetAttributes(attribute.String("key", key))
 
-	result, err := d.execute(ctx, "delete_sql", d.DeleteSQL, key, revision)
+	rev, deleted, err = d.insert(ctx, "delete_sql", d.keyTableSQL(d.DeleteSQL, key), key, revision)
 	if err != nil {
-		logrus.WithError(err).Error("failed to delete key")
-		return 0, false, err
-	}
-	if insertCount, err := result.RowsAffected(); err != nil {
+		logger.WithError(err).Error("failed to delete key")
 		return 0, false, err
-	} else if insertCount == 0 {
-		return 0, false, nil
 	}
-
-	rev, err = result.LastInsertId()
-	return rev, true, err
+	return rev, deleted, nil
 }
 
 // Compact compacts the database up to the revision provided in the method's call.
 // After the call, any request for a version older than the given revision will return
-// a compacted error.
-func (d *Generic) Compact(ctx context.Context, revision int64) (err error) {
+// a compacted error. The events stored apart are compacted on their own schedule.
+func (d *Generic) Compact(ctx context.Context, revision int64) error {
+	return d.compact(ctx, mainTable, revision)
+}
+
+// compact compacts table up to revision.
+func (d *Generic) compact(ctx context.Context, table string, revision int64) (err error) {
 	compactCnt.Add(ctx, 1)
 	ctx, span := otelTracer.Start(ctx, fmt.Sprintf("%s.Compact", otelName))
 	defer func() {
 		span.RecordError(err)
 		span.End()
 	}()
-	compactStart, currentRevision, err := d.GetCompactRevision(ctx)
+	span.SetAttributes(attribute.String("table", table))
+	if table == mainTable {
+		if err := d.sweepChunks(ctx); err != nil {
+			logger.WithError(err).Warning("failed to delete orphaned value chunks")
+		}
+	}
+	compactStart, currentRevision, err := d.getCompactRevision(ctx, table)
 	if err != nil {
 		return err
 	}
+	if table == mainTable {
+		if err := d.dedupOldValues(ctx, currentRevision); err != nil {
+			logger.WithError(err).Warning("failed to clear duplicated old values")
+		}
+	}
 	span.SetAttributes(
 		attribute.Int64("compact_start", compactStart),
 		attribute.Int64("current_revision", currentRevision), attribute.Int64("revision", revision),
@@ -629,16 +1046,24 @@ func (d *Generic) Compact(ctx context.Context, revision int64) (err error) {
 		revision = currentRevision
 	}
 
+	start := time.Now()
+	var deleted int64
 	for retryCount := 0; retryCount < maxRetries; retryCount++ {
-		err = d.tryCompact(ctx, compactStart, revision)
-		if err == nil || d.Retry == nil || !d.Retry(err) {
+		deleted, err = d.tryCompact(ctx, table, compactStart, revision)
+		if err == nil || !d.shouldRetry(ctx, "compact", err, retryCount, start) {
 			break
 		}
 	}
+	if err == nil {
+		recordCompact(ctx, time.Since(start), deleted)
+		span.SetAttributes(attribute.Int64("rows_deleted", deleted))
+	}
 	return err
 }
 
-func (d *Generic) tryCompact(ctx context.Context, start, end int64) (err error) {
+// tryCompact removes the revisions of table between start and end, returning
+// the number of deleted rows.
+func (d *Generic) tryCompact(ctx context.Context, table string, start, end int64) (deleted int64, err error) {
 	ctx, span := otelTracer.Start(ctx, fmt.Sprintf("%s.tryCompact", otelName))
 	defer func() {
 		span.RecordError(err)
@@ -647,54 +1072,82 @@ func (d *Generic) tryCompact(ctx context.Context, start, end int64) (err error)
 	span.SetAttributes(attribute.Int64("start", start), attribute.Int64("end", end))
 	compactBatchCnt.Add(ctx, 1)
 
-	tx, err := d.DB.BeginTx(ctx, nil)
+	ctx, cancel := withTimeout(ctx, d.CompactTimeout)
+	defer cancel()
+	tx, err := d.writeDB().BeginTx(ctx, nil)
 	if err != nil {
-		return err
+		return 0, err
 	}
 	defer func() {
 		if err := tx.Rollback(); err != nil {
-			logrus.WithError(err).Trace("can't rollback compaction")
+			logger.WithError(err).Trace("can't rollback compaction")
 		}
 	}()
 
-	// This query adds `created = 0` as a condition
-	// to mitigate a bug in vXXX where newly created
-	// keys had `prev_revision = max(id)` instead of 0.
-	// Even with the bug fixed, we still need to check
-	// for that in older rows and if older peers are
-	// still running. Given that we are not yet using
-	// an index, it won't change much in performance
-	// however, it should be included in a covering
-	// index if ever necessary.
-	if _, err = tx.ExecContext(ctx, `
-		DELETE FROM kine
-		WHERE id IN (
-			SELECT prev_revision
-			FROM kine
-			WHERE name != 'compact_rev_key'
-				AND created = 0
-				AND prev_revision != 0
-				AND ? < id AND id <= ?
-		)
-	`, start, end); err != nil {
-		return err
+	for _, query := range []string{d.CompactSQL, d.CompactDeletedSQL} {
+		result, err := tx.ExecContext(ctx, d.tableSQL(query, table), start, end)
+		if err != nil {
+			return 0, err
+		}
+		if n, err := result.RowsAffected(); err == nil {
+			deleted += n
+		}
+	}
+	if table == eventsTable {
+		if _, err = tx.ExecContext(ctx, d.UpdateEventsCompactSQL, end, end); err != nil {
+			return 0, err
+		}
+		return deleted, tx.Commit()
+	}
+	// The fill rows are tombstones, so the ones in the range are already
+	// deleted, but the ones below it are not.
+	result, err := tx.ExecContext(ctx, d.CompactFillSQL, end)
+	if err != nil {
+		return 0, err
+	}
+	if n, err := result.RowsAffected(); err == nil {
+		deleted += n
+	}
+	fillRows, err := d.countFillRows(ctx, tx, end)
+	if err != nil {
+		return 0, err
 	}
 
-	if _, err = tx.ExecContext(ctx, `
-		DELETE FROM kine
-		WHERE deleted = 1
-			AND ? < id AND id <= ?
-	`, start, end); err != nil {
-		return err
+	if _, err = tx.ExecContext(ctx, d.UpdateCompactSQL, end, end); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
 	}
+	metricsFillRows.Set(float64(fillRows))
+	return deleted, nil
+}
 
-	if _, err = tx.ExecContext(ctx, d.UpdateCompactSQL, end); err != nil {
-		return err
+// countFillRows counts the rows inserted by Fill after revision.
+func (d *Generic) countFillRows(ctx context.Context, tx *prepared.Tx, revision int64) (int64, error) {
+	rows, err := tx.QueryContext(ctx, d.CountFillSQL, revision)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	var count int64
+	if rows.Next() {
+		if err := rows.Scan(&count); err != nil {
+			return 0, err
+		}
 	}
-	return tx.Commit()
+	return count, rows.Err()
 }
 
+// GetCompactRevision returns the compact and current revisions of the kine
+// table.
 func (d *Generic) GetCompactRevision(ctx context.Context) (int64, int64, error) {
+	return d.getCompactRevision(ctx, mainTable)
+}
+
+// getCompactRevision returns the compact revision of table and the current
+// revision.
+func (d *Generic) getCompactRevision(ctx context.Context, table string) (int64, int64, error) {
 	getCompactRevCnt.Add(ctx, 1)
 	ctx, span := otelTracer.Start(ctx, fmt.Sprintf("%s.get_compact_revision", otelName))
 	var compact, target sql.NullInt64
@@ -707,7 +1160,7 @@ func (d *Generic) GetCompactRevision(ctx context.Context) (int64, int64, error)
 		span.End()
 	}()
 
-	rows, err := d.query(ctx, "revision_interval_sql", revisionIntervalSQL)
+	rows, err := d.query(ctx, "revision_interval_sql", d.compactRevisionSQL(table))
 	if err != nil {
 		return 0, 0, err
 	}
@@ -727,49 +1180,127 @@ func (d *Generic) GetCompactRevision(ctx context.Context) (int64, int64, error)
 	return compact.Int64, target.Int64, err
 }
 
-func (d *Generic) DeleteRevision(ctx context.Context, revision int64) error {
-	var err error
-	deleteRevCnt.Add(ctx, 1)
-	ctx, span := otelTracer.Start(ctx, fmt.Sprintf("%s.delete_revision", otelName))
-	defer func() {
-		span.RecordError(err)
-		span.End()
-	}()
-	span.SetAttributes(attribute.Int64("revision", revision))
-
-	_, err = d.execute(ctx, "delete_rev_sql", d.DeleteRevSQL, revision)
-	return err
+// compactRevisionSQL returns the query of the compact revision of table, the
+// latest compact revision of both tables for allTable, and of the current
+// revision.
+func (d *Generic) compactRevisionSQL(table string) string {
+	return d.tableSQL(fmt.Sprintf(revisionIntervalSQL, compactRevisionNames[table]), table)
 }
 
 func (d *Generic) ListCurrent(ctx context.Context, prefix, startKey string, limit int64, includeDeleted bool) (*sql.Rows, error) {
-	sql := d.GetCurrentSQL
 	start, end := getPrefixRange(prefix)
 	// NOTE(neoaggelos): don't ignore startKey if set
 	if startKey != "" {
 		start = startKey + "\x01"
 	}
+	txName, query, args := d.listRangeQuery(start, end, limit, includeDeleted, server.RangeOptions{})
+	return d.query(ctx, txName, query, args...)
+}
+
+// ListRange returns the keys between start, inclusive, and end, exclusive,
+// at revision, or at the current revision if zero.
+func (d *Generic) ListRange(ctx context.Context, start, end string, limit, revision int64, includeDeleted bool, opts server.RangeOptions) (*sql.Rows, error) {
+	if revision == 0 {
+		txName, query, args := d.listRangeQuery(start, end, limit, includeDeleted, opts)
+		return d.query(ctx, txName, query, args...)
+	}
+
+	sql, args := d.filterSQL(d.ListRevisionStartSQL, []interface{}{start, end, revision, includeDeleted}, opts)
+	sql = d.rangeTableSQL(rangeSQL(sql, opts), start, end)
+	if limit > 0 {
+		sql = d.limitSQL(sql, len(args)+1)
+		return d.query(ctx, "list_range_revision_sql_limit", sql, append(args, limit)...)
+	}
+	return d.query(ctx, "list_range_revision_sql", sql, args...)
+}
 
+func (d *Generic) listRangeQuery(start, end string, limit int64, includeDeleted bool, opts server.RangeOptions) (string, string, []interface{}) {
+	sql, args := d.filterSQL(d.GetCurrentSQL, []interface{}{start, end, includeDeleted}, opts)
+	sql = d.rangeTableSQL(rangeSQL(sql, opts), start, end)
 	if limit > 0 {
-		sql = fmt.Sprintf("%s LIMIT ?", sql)
-		return d.query(ctx, "get_current_sql_limit", sql, start, end, includeDeleted, limit)
+		sql = d.limitSQL(sql, len(args)+1)
+		return "get_current_sql_limit", sql, append(args, limit)
 	}
-	return d.query(ctx, "get_current_sql", sql, start, end, includeDeleted)
+	return "get_current_sql", sql, args
+}
+
+// listOrderBy is the ORDER BY clause of listSQL, which sorts the keys by
+// name in ascending order.
+const listOrderBy = "ORDER BY kv.name ASC, kv.id ASC"
+
+// createRevisionSQL is the create revision of a row of the kine table, as
+// created rows leave their create_revision unset.
+const createRevisionSQL = "CASE WHEN kv.created <> 0 THEN kv.id ELSE kv.create_revision END"
+
+// filterSQL adds the revision filters of a range to a query derived from
+// listSQL, which takes args, and returns the arguments of the new query.
+func (d *Generic) filterSQL(query string, args []interface{}, opts server.RangeOptions) (string, []interface{}) {
+	var filters strings.Builder
+	filter := func(expr, op string, value int64) {
+		if value > 0 {
+			args = append(args, value)
+			fmt.Fprintf(&filters, "AND %s %s %s\n\t\t", expr, op, d.param(len(args)))
+		}
+	}
+	filter("kv.id", ">=", opts.MinModRevision)
+	filter("kv.id", "<=", opts.MaxModRevision)
+	filter(createRevisionSQL, ">=", opts.MinCreateRevision)
+	filter(createRevisionSQL, "<=", opts.MaxCreateRevision)
+	if filters.Len() == 0 {
+		return query, args
+	}
+	return strings.Replace(query, listOrderBy, filters.String()+listOrderBy, 1), args
+}
+
+// rangeSQL adapts a query derived from listSQL to the options of a range:
+// keys only queries don't select the values, and the keys are sorted as
+// set by the options, with ties broken by name.
+func rangeSQL(query string, opts server.RangeOptions) string {
+	if opts.KeysOnly {
+		query = strings.Replace(query, columns, keyColumns, 1)
+	}
+	if opts.SortTarget == server.SortByKey && !opts.SortDescending {
+		return query
+	}
+
+	var column string
+	switch opts.SortTarget {
+	case server.SortByCreateRevision:
+		column = createRevisionSQL
+	case server.SortByModRevision:
+		column = "kv.id"
+	case server.SortByValue:
+		column = "kv.value"
+	default:
+		// Versions are not tracked and always reported as zero, so
+		// sorting by version leaves the keys sorted by name.
+		column = "kv.name"
+	}
+	direction := "ASC"
+	if opts.SortDescending {
+		direction = "DESC"
+	}
+	orderBy := fmt.Sprintf("ORDER BY %s %s", column, direction)
+	if column != "kv.name" {
+		orderBy += fmt.Sprintf(", kv.name %s", direction)
+	}
+	return strings.Replace(query, listOrderBy, orderBy, 1)
 }
 
 func (d *Generic) List(ctx context.Context, prefix, startKey string, limit, revision int64, includeDeleted bool) (*sql.Rows, error) {
 	start, end := getPrefixRange(prefix)
 	if startKey == "" {
-		sql := d.ListRevisionStartSQL
+		sql := d.rangeTableSQL(d.ListRevisionStartSQL, start, end)
 		if limit > 0 {
-			sql = fmt.Sprintf("%s LIMIT ?", sql)
+			sql = d.limitSQL(sql, 5)
 			return d.query(ctx, "list_revision_start_sql_limit", sql, start, end, revision, includeDeleted, limit)
 		}
 		return d.query(ctx, "list_revision_start_sql", sql, start, end, revision, includeDeleted)
 	}
 
-	sql := d.GetRevisionAfterSQL
+	sql := d.rangeTableSQL(d.GetRevisionAfterSQL, startKey+"\x01", end)
 	if limit > 0 {
-		sql = fmt.Sprintf("%s LIMIT ?", sql)
+		sql = d.limitSQL(sql, 5)
 		return d.query(ctx, "get_revision_after_sql_limit", sql, startKey+"\x01", end, revision, includeDeleted, limit)
 	}
 	return d.query(ctx, "get_revision_after_sql", sql, startKey+"\x01", end, revision, includeDeleted)
@@ -786,7 +1317,7 @@ func (d *Generic) CurrentRevision(ctx context.Context) (int64, error) {
 		span.End()
 	}()
 
-	rows, err := d.query(ctx, "rev_sql", revSQL)
+	rows, err := d.query(ctx, "rev_sql", d.tableSQL(revSQL, mainTable))
 	if err != nil {
 		return 0, err
 	}
@@ -808,29 +1339,55 @@ func (d *Generic) CurrentRevision(ctx context.Context) (int64, error) {
 
 func (d *Generic) AfterPrefix(ctx context.Context, prefix string, rev, limit int64) (*sql.Rows, error) {
 	start, end := getPrefixRange(prefix)
-	sql := d.AfterSQLPrefix
+	sql := d.rangeTableSQL(d.AfterSQLPrefix, start, end)
 	if limit > 0 {
-		sql = fmt.Sprintf("%s LIMIT ?", sql)
+		sql = d.limitSQL(sql, 4)
 		return d.query(ctx, "after_sql_prefix_limit", sql, start, end, rev, limit)
 	}
 	return d.query(ctx, "after_sql_prefix", sql, start, end, rev)
 }
 
+// After is the query of the watch poll loop, which runs on its own
+// connection if there is one.
 func (d *Generic) After(ctx context.Context, rev, limit int64) (*sql.Rows, error) {
-	sql := d.AfterSQL
+	db := d.PollDB
+	if db == nil {
+		db = d.DB
+	}
+	// The pool stats tell how long the query waited for a connection,
+	// which is only meaningful for a reserved connection.
+	waited := db.Stats().WaitDuration
+	defer func() {
+		if waited := db.Stats().WaitDuration - waited; waited >= 0 {
+			metricsPollQueueTime.Observe(waited.Seconds())
+		}
+	}()
+
+	sql := d.allTableSQL(d.AfterSQL)
 	if limit > 0 {
-		sql = fmt.Sprintf("%s LIMIT ?", sql)
-		return d.query(ctx, "after_sql_limit", sql, rev, limit)
+		sql = d.limitSQL(sql, 2)
+		return d.queryDB(d.readContext(ctx), db, "after_sql_limit", sql, rev, limit)
 	}
-	return d.query(ctx, "after_sql", sql, rev)
+	return d.queryDB(d.readContext(ctx), db, "after_sql", sql, rev)
 }
 
 func (d *Generic) Fill(ctx context.Context, revision int64) error {
 	fillCnt.Add(ctx, 1)
 	_, err := d.execute(ctx, "fill_sql", d.FillSQL, revision, fmt.Sprintf("gap-%d", revision), 0, 1, 0, 0, 0, nil, nil)
+	if err == nil {
+		metricsFillRows.Inc()
+	}
 	return err
 }
 
+// SetFillNameSQL sets the queries of the rows inserted by Fill, given the
+// condition on the name Fill gives to the row of the revision id, "gap-"
+// followed by the revision, in the dialect.
+func (d *Generic) SetFillNameSQL(nameSQL string) {
+	d.CompactFillSQL = q(fmt.Sprintf(compactFillSQL, nameSQL), d.paramCharacter, d.numbered)
+	d.CountFillSQL = q(fmt.Sprintf(countFillSQL, nameSQL), d.paramCharacter, d.numbered)
+}
+
 func (d *Generic) IsFill(key string) bool {
 	return strings.HasPrefix(key, "gap-")
 }
@@ -859,6 +1416,19 @@ func (d *Generic) GetSize(ctx context.Context) (int64, error) {
 	return size, nil
 }
 
+// GetFileSize returns the size of the database, including unused space.
+// Drivers that can't report page statistics fall back to GetSize.
+func (d *Generic) GetFileSize(ctx context.Context) (int64, error) {
+	if d.PageStatsSQL == "" {
+		return d.GetSize(ctx)
+	}
+	pageCount, _, pageSize, err := d.PageStats(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return pageCount * pageSize, nil
+}
+
 func (d *Generic) GetCompactInterval() time.Duration {
 	if v := d.CompactInterval; v > 0 {
 		return v
@@ -866,6 +1436,33 @@ func (d *Generic) GetCompactInterval() time.Duration {
 	return 5 * time.Minute
 }
 
+func (d *Generic) GetCompactBatchSize() int64 {
+	if v := d.CompactBatchSize; v > 0 {
+		return v
+	}
+	return 1000
+}
+
+func (d *Generic) GetCompactBatchInterval() time.Duration {
+	return d.CompactBatchInterval
+}
+
+func (d *Generic) GetCompactRetentionDuration() time.Duration {
+	return d.CompactRetentionDuration
+}
+
+func (d *Generic) GetCompactRetentionRevisions() int64 {
+	return d.CompactRetentionRevisions
+}
+
+func (d *Generic) GetVacuumInterval() time.Duration {
+	return d.VacuumInterval
+}
+
+func (d *Generic) GetVacuumFreePages() int64 {
+	return d.VacuumFreePages
+}
+
 func (d *Generic) GetWatchQueryTimeout() time.Duration {
 	if v := d.WatchQueryTimeout; v >= 5*time.Second {
 		return v
@@ -873,9 +1470,37 @@ func (d *Generic) GetWatchQueryTimeout() time.Duration {
 	return 20 * time.Second
 }
 
+func (d *Generic) GetWatchCacheSize() int {
+	switch {
+	case d.WatchCacheSize > 0:
+		return d.WatchCacheSize
+	case d.WatchCacheSize < 0:
+		return 0
+	}
+	return 1000
+}
+
+func (d *Generic) GetWatchQueueSize() int {
+	if v := d.WatchQueueSize; v > 0 {
+		return v
+	}
+	return broadcaster.DefaultQueueSize
+}
+
+func (d *Generic) GetCommitNotify() <-chan struct{} {
+	return d.CommitNotify
+}
+
 func (d *Generic) GetPollInterval() time.Duration {
 	if v := d.PollInterval; v > 0 {
 		return v
 	}
 	return time.Second
 }
+
+func (d *Generic) GetMaxPollInterval() time.Duration {
+	if v := d.MaxPollInterval; v > 0 {
+		return v
+	}
+	return 5 * time.Second
+}
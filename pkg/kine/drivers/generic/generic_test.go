@@ -0,0 +1,109 @@
+package generic
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestInsert(t *testing.T) {
+	const insertMissingSQL = "INSERT INTO test(name) SELECT ? WHERE NOT EXISTS (SELECT 1 FROM test WHERE name = ?)"
+
+	tests := []struct {
+		name         string
+		lastInsertID bool
+		batchSize    int
+	}{
+		{
+			name:         "last insert id",
+			lastInsertID: true,
+		},
+		{
+			name: "returning",
+		},
+		{
+			name:         "batched last insert id",
+			lastInsertID: true,
+			batchSize:    4,
+		},
+		{
+			name:      "batched returning",
+			batchSize: 4,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			d := newTestDialect(ctx, t, tt.batchSize)
+			d.LastInsertID = tt.lastInsertID
+
+			for i, name := range []string{"a", "b"} {
+				rev, inserted, err := d.insert(ctx, "test", insertMissingSQL, name, name)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !inserted {
+					t.Errorf("expected %s to be inserted", name)
+				}
+				if rev != int64(i+1) {
+					t.Errorf("expected %s to be inserted at revision %d, got %d", name, i+1, rev)
+				}
+			}
+
+			rev, inserted, err := d.insert(ctx, "test", insertMissingSQL, "a", "a")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if inserted || rev != 0 {
+				t.Errorf("expected nothing to be inserted, got revision %d", rev)
+			}
+		})
+	}
+}
+
+func TestQueryTimeouts(t *testing.T) {
+	ctx := context.Background()
+	d := newTestDialect(ctx, t, 0)
+	d.ReadTimeout = 10 * time.Millisecond
+	d.WriteTimeout = 10 * time.Millisecond
+
+	// A query counting forever.
+	const slowSQL = "WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n) SELECT COUNT(*) FROM n"
+	rows, err := d.query(ctx, "slow", slowSQL)
+	if err == nil {
+		rows.Next()
+		err = rows.Err()
+		rows.Close()
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the read to time out, got %v", err)
+	}
+
+	// Writes time out waiting for their turn as well.
+	d.LockWrites = true
+	if err := d.writes.acquire(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer d.writes.release()
+	if _, err := d.execute(ctx, "test", insertTestSQL, "a"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the write to time out, got %v", err)
+	}
+}
+
+func TestSlowQueryArgs(t *testing.T) {
+	args := []interface{}{"/registry/pods", int64(42), []byte("value")}
+	for _, tc := range []struct {
+		redact bool
+		want   []string
+	}{
+		{false, []string{"/registry/pods", "42", "<5 bytes>"}},
+		{true, []string{"<redacted>", "<redacted>", "<5 bytes>"}},
+	} {
+		if got := slowQueryArgs(args, tc.redact); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("slowQueryArgs(redact=%v) = %v, want %v", tc.redact, got, tc.want)
+		}
+	}
+}
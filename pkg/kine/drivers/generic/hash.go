@@ -0,0 +1,132 @@
+package generic
+
+import (
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"time"
+
+	"github.com/canonical/k8s-dqlite/pkg/kine/prepared"
+	"github.com/canonical/k8s-dqlite/pkg/kine/server"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+var hashSQL = `
+	SELECT id, name, created, deleted, create_revision, prev_revision, lease, value
+	FROM kine
+	WHERE ? < id AND id <= ?
+	ORDER BY id ASC`
+
+// Hash returns the CRC-32 (Castagnoli) of the rows retained up to revision,
+// or the current revision if zero, along with the compact revision and the
+// revision hashed. The revisions are read in the same read-only transaction
+// as the rows, with the BackupIsolation level, so that the hash matches
+// them. The rows up to the compact revision are left out, so the hashes of
+// members that compacted to different revisions only match once they have
+// compacted to the same one. When the events are stored apart, the rows of
+// both tables are hashed, after the latest compact revision of the two.
+func (d *Generic) Hash(ctx context.Context, revision int64) (hash uint32, compactRevision, hashRevision int64, err error) {
+	ctx, span := otelTracer.Start(ctx, fmt.Sprintf("%s.Hash", otelName))
+	start := time.Now()
+	defer func() {
+		recordOpResult("hash_sql", err, start)
+		recordTxResult("hash_sql", err)
+		span.RecordError(err)
+		span.End()
+	}()
+	span.SetAttributes(attribute.Int64("revision", revision))
+
+	tx, err := d.DB.BeginTx(ctx, &sql.TxOptions{Isolation: d.BackupIsolation, ReadOnly: true})
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil {
+			logger.WithError(err).Trace("can't rollback hash")
+		}
+	}()
+
+	compact, current, err := queryRevisionInterval(ctx, tx, d.compactRevisionSQL(allTable))
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	compactRevision = compact
+	hashRevision = revision
+	if hashRevision == 0 {
+		hashRevision = current
+	} else if hashRevision > current {
+		return 0, 0, 0, server.ErrFutureRev
+	} else if hashRevision < compactRevision {
+		return 0, 0, 0, server.ErrCompacted
+	}
+	span.SetAttributes(attribute.Int64("compact", compactRevision), attribute.Int64("hashRevision", hashRevision))
+
+	rows, err := tx.QueryContext(ctx, d.allTableSQL(d.HashSQL), compactRevision, hashRevision)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	defer rows.Close()
+
+	var (
+		h     = crc32.New(crc32.MakeTable(crc32.Castagnoli))
+		buf   = make([]byte, 8)
+		count int64
+	)
+	writeInt := func(v int64) {
+		binary.BigEndian.PutUint64(buf, uint64(v))
+		h.Write(buf)
+	}
+	for rows.Next() {
+		var (
+			id, createRevision                    int64
+			name                                  string
+			created, deleted, prevRevision, lease sql.NullInt64
+			value                                 []byte
+		)
+		if err := rows.Scan(&id, &name, &created, &deleted, &createRevision, &prevRevision, &lease, &value); err != nil {
+			return 0, 0, 0, err
+		}
+		writeInt(id)
+		writeInt(int64(len(name)))
+		h.Write([]byte(name))
+		writeInt(created.Int64)
+		writeInt(deleted.Int64)
+		writeInt(createRevision)
+		writeInt(prevRevision.Int64)
+		writeInt(lease.Int64)
+		writeInt(int64(len(value)))
+		h.Write(value)
+		count++
+	}
+	if err := rows.Err(); err != nil {
+		return 0, 0, 0, err
+	}
+
+	hash = h.Sum32()
+	span.SetAttributes(attribute.Int64("rows", count), attribute.Int64("hash", int64(hash)))
+	return hash, compactRevision, hashRevision, nil
+}
+
+// queryRevisionInterval returns the compact and current revisions in tx, as
+// read by query.
+func queryRevisionInterval(ctx context.Context, tx *prepared.Tx, query string) (compact, current int64, err error) {
+	rows, err := tx.QueryContext(ctx, query)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return 0, 0, err
+		}
+		return 0, 0, sql.ErrNoRows
+	}
+	var low, high sql.NullInt64
+	if err := rows.Scan(&low, &high); err != nil {
+		return 0, 0, err
+	}
+	return low.Int64, high.Int64, nil
+}
@@ -0,0 +1,19 @@
+package generic
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+var historySQL = fmt.Sprintf(`
+	SELECT %s
+	FROM kine AS kv
+	WHERE kv.name = ?
+	ORDER BY kv.id ASC`, columns)
+
+// History lists the rows of key retained in the database, tombstones
+// included, in revision order.
+func (d *Generic) History(ctx context.Context, key string) (*sql.Rows, error) {
+	return d.query(ctx, "history_sql", d.keyTableSQL(d.HistorySQL, key), key)
+}
@@ -0,0 +1,47 @@
+package generic
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	// leaderRetryMinBackoff and leaderRetryMaxBackoff bound the wait before
+	// retrying an operation which failed as the leader changed.
+	leaderRetryMinBackoff = 10 * time.Millisecond
+	leaderRetryMaxBackoff = 500 * time.Millisecond
+	// leaderRetryTimeout is the time after which the operations failing as
+	// the leader changed are no longer retried, which leaves time for a few
+	// elections.
+	leaderRetryTimeout = 15 * time.Second
+)
+
+// shouldRetry reports whether the operation txName, started at start and
+// failing with err on its try-th attempt, must be retried. The operations
+// failing as the leader changed are retried after a bounded backoff, while
+// the new leader is elected, and the ones failing with an error the driver
+// can retry are retried straight away.
+func (d *Generic) shouldRetry(ctx context.Context, txName string, err error, try int, start time.Time) bool {
+	if d.LeaderChange == nil || !d.LeaderChange(err) {
+		return d.Retry != nil && d.Retry(err)
+	}
+	if time.Since(start) >= leaderRetryTimeout {
+		return false
+	}
+
+	backoff := leaderRetryMaxBackoff
+	if try < 6 {
+		backoff = min(leaderRetryMinBackoff<<try, leaderRetryMaxBackoff)
+	}
+	logger.WithError(err).WithField("tx_name", txName).Debugf("Leader changed, retry in %v", backoff)
+	metricsLeaderRetries.WithLabelValues(txName).Inc()
+
+	timer := time.NewTimer(backoff)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
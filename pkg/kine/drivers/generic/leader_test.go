@@ -0,0 +1,42 @@
+package generic
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestShouldRetry(t *testing.T) {
+	errLeader := errors.New("not leader")
+	errBusy := errors.New("busy")
+	d := &Generic{
+		Retry:        func(err error) bool { return err == errBusy },
+		LeaderChange: func(err error) bool { return err == errLeader },
+	}
+	ctx := context.Background()
+
+	if !d.shouldRetry(ctx, "test", errBusy, 0, time.Now()) {
+		t.Error("expected the driver errors to be retried")
+	}
+	if d.shouldRetry(ctx, "test", errors.New("other"), 0, time.Now()) {
+		t.Error("expected the other errors not to be retried")
+	}
+
+	start := time.Now()
+	if !d.shouldRetry(ctx, "test", errLeader, 2, start) {
+		t.Error("expected the leader changes to be retried")
+	}
+	if waited := time.Since(start); waited < leaderRetryMinBackoff<<2 {
+		t.Errorf("expected to back off for %v, waited %v", leaderRetryMinBackoff<<2, waited)
+	}
+	if d.shouldRetry(ctx, "test", errLeader, 0, time.Now().Add(-leaderRetryTimeout)) {
+		t.Error("expected the leader changes not to be retried after the timeout")
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+	if d.shouldRetry(ctx, "test", errLeader, 10, time.Now()) {
+		t.Error("expected the leader changes not to be retried once the context is done")
+	}
+}
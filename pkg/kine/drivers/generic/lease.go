@@ -0,0 +1,87 @@
+package generic
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+var (
+	grantLeaseSQL = `
+		INSERT INTO kine_leases(id, ttl, expiry)
+		VALUES(?, ?, ?)`
+
+	checkpointLeaseSQL = `
+		UPDATE kine_leases
+		SET expiry = ?
+		WHERE id = ?`
+
+	revokeLeaseSQL = `
+		DELETE FROM kine_leases
+		WHERE id = ?`
+
+	listLeasesSQL = `
+		SELECT id, ttl, expiry
+		FROM kine_leases`
+
+	getLeaseSQL = `
+		SELECT id, ttl, expiry
+		FROM kine_leases
+		WHERE id = ?`
+)
+
+// GrantLease persists a lease with the given ID and TTL in seconds, expiring
+// at the given unix time in milliseconds. If a lease with the same ID exists,
+// the error is translated to server.ErrKeyExists.
+func (d *Generic) GrantLease(ctx context.Context, id, ttl, expiry int64) (err error) {
+	ctx, span := otelTracer.Start(ctx, fmt.Sprintf("%s.GrantLease", otelName))
+	defer func() {
+		if err != nil && d.TranslateErr != nil {
+			err = d.TranslateErr(err)
+		}
+		span.RecordError(err)
+		span.End()
+	}()
+	span.SetAttributes(attribute.Int64("id", id), attribute.Int64("ttl", ttl))
+
+	_, err = d.execute(ctx, "grant_lease_sql", d.GrantLeaseSQL, id, ttl, expiry)
+	return err
+}
+
+// CheckpointLease persists the expiry of a lease, as a unix time in milliseconds.
+func (d *Generic) CheckpointLease(ctx context.Context, id, expiry int64) (err error) {
+	ctx, span := otelTracer.Start(ctx, fmt.Sprintf("%s.CheckpointLease", otelName))
+	defer func() {
+		span.RecordError(err)
+		span.End()
+	}()
+	span.SetAttributes(attribute.Int64("id", id), attribute.Int64("expiry", expiry))
+
+	_, err = d.execute(ctx, "checkpoint_lease_sql", d.CheckpointLeaseSQL, expiry, id)
+	return err
+}
+
+// RevokeLease removes a lease. Keys attached to it are not deleted.
+func (d *Generic) RevokeLease(ctx context.Context, id int64) (err error) {
+	ctx, span := otelTracer.Start(ctx, fmt.Sprintf("%s.RevokeLease", otelName))
+	defer func() {
+		span.RecordError(err)
+		span.End()
+	}()
+	span.SetAttributes(attribute.Int64("id", id))
+
+	_, err = d.execute(ctx, "revoke_lease_sql", d.RevokeLeaseSQL, id)
+	return err
+}
+
+// ListLeases returns the ID, TTL and expiry of all the persisted leases.
+func (d *Generic) ListLeases(ctx context.Context) (*sql.Rows, error) {
+	return d.query(ctx, "list_leases_sql", d.ListLeasesSQL)
+}
+
+// GetLease returns the ID, TTL and expiry of a persisted lease.
+func (d *Generic) GetLease(ctx context.Context, id int64) (*sql.Rows, error) {
+	return d.query(ctx, "get_lease_sql", d.GetLeaseSQL, id)
+}
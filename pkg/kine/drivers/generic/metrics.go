@@ -1,8 +1,12 @@
 package generic
 
 import (
+	"context"
+	"database/sql"
+	"sync"
 	"time"
 
+	"github.com/canonical/k8s-dqlite/pkg/kine/prepared"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
@@ -17,15 +21,150 @@ var (
 	}, []string{"tx_name", "result"})
 	metricsOpLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
 		Name:    "k8s_dqlite_generic_op_latency",
-		Help:    "Transaction latency of database operations by tx_name and result",
+		Help:    "Latency (in seconds) of database operations by tx_name and result",
 		Buckets: []float64{0, 0.05, 0.1, 0.3, 0.5, 1, 3, 5, 10},
 	}, []string{"tx_name", "result"})
 	metricsCurrentOps = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Name: "k8s_dqlite_generic_current_ops",
 		Help: "Total number of database operations that are currently running by tx_name",
 	}, []string{"tx_name"})
+	metricsCompactLatency = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "k8s_dqlite_generic_compact_latency",
+		Help:    "Latency (in seconds) of the compactions",
+		Buckets: []float64{0.01, 0.05, 0.1, 0.5, 1, 5, 10, 30, 60},
+	})
+	metricsCompactRowsDeleted = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "k8s_dqlite_generic_compact_rows_deleted",
+		Help:    "Number of rows deleted by the compactions",
+		Buckets: prometheus.ExponentialBuckets(1, 4, 10),
+	})
+	metricsFillRows = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "k8s_dqlite_generic_fill_rows",
+		Help: "Number of rows filling the gaps of the revisions in the database, counted by the last compaction plus the fills since",
+	})
+	metricsWriteBatchSize = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "k8s_dqlite_generic_write_batch_size",
+		Help:    "Number of write operations committed in a single transaction",
+		Buckets: prometheus.ExponentialBuckets(2, 2, 8),
+	})
+	metricsSlowQueryLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "k8s_dqlite_generic_slow_query_latency",
+		Help:    "Latency (in seconds) of the queries exceeding the slow query threshold by tx_name",
+		Buckets: []float64{0.1, 0.5, 1, 2, 5, 10, 30, 60},
+	}, []string{"tx_name"})
+	metricsWriteQueueDepth = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "k8s_dqlite_generic_write_queue_depth",
+		Help: "Number of writes waiting for their turn in the write queue",
+	})
+	metricsWriteQueueTime = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "k8s_dqlite_generic_write_queue_time",
+		Help:    "Time (in seconds) spent by the writes waiting for their turn in the write queue",
+		Buckets: []float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5},
+	})
+	metricsPollQueueTime = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "k8s_dqlite_generic_poll_queue_time",
+		Help:    "Time (in seconds) spent by the watch poll queries waiting for a connection",
+		Buckets: []float64{0, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1},
+	})
+	metricsReadBarrierLatency = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "k8s_dqlite_generic_read_barrier_latency",
+		Help:    "Latency (in seconds) of the read barriers of the linearizable reads, waiting for the barrier included",
+		Buckets: []float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5},
+	})
+	metricsLeaderRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "k8s_dqlite_generic_leader_retries",
+		Help: "Total number of database operations retried as the leader changed by tx_name",
+	}, []string{"tx_name"})
+	metricsVacuumReclaimedBytes = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "k8s_dqlite_generic_vacuum_reclaimed_bytes",
+		Help: "Total number of bytes returned to the file system by database vacuums",
+	})
 )
 
+// poolStats reports the statistics of the connection pools of the open
+// dialects, labelled by pool. The main pools of the dialects that don't
+// have a separate pool for the writes are labelled read_write.
+var poolStats = newPoolStatsCollector()
+
+type poolStatsCollector struct {
+	mu       sync.Mutex
+	dialects map[*Generic]struct{}
+
+	open         *prometheus.Desc
+	inUse        *prometheus.Desc
+	idle         *prometheus.Desc
+	waitCount    *prometheus.Desc
+	waitDuration *prometheus.Desc
+}
+
+func newPoolStatsCollector() *poolStatsCollector {
+	labels := []string{"pool"}
+	return &poolStatsCollector{
+		dialects:     map[*Generic]struct{}{},
+		open:         prometheus.NewDesc("k8s_dqlite_generic_pool_open_connections", "Number of open connections by pool", labels, nil),
+		inUse:        prometheus.NewDesc("k8s_dqlite_generic_pool_in_use_connections", "Number of connections in use by pool", labels, nil),
+		idle:         prometheus.NewDesc("k8s_dqlite_generic_pool_idle_connections", "Number of idle connections by pool", labels, nil),
+		waitCount:    prometheus.NewDesc("k8s_dqlite_generic_pool_wait_count", "Total number of connections waited for by pool", labels, nil),
+		waitDuration: prometheus.NewDesc("k8s_dqlite_generic_pool_wait_seconds", "Total time spent waiting for connections by pool", labels, nil),
+	}
+}
+
+func (c *poolStatsCollector) add(d *Generic) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.dialects[d] = struct{}{}
+}
+
+func (c *poolStatsCollector) remove(d *Generic) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.dialects, d)
+}
+
+func (c *poolStatsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.open
+	ch <- c.inUse
+	ch <- c.idle
+	ch <- c.waitCount
+	ch <- c.waitDuration
+}
+
+func (c *poolStatsCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := map[string]sql.DBStats{}
+	addStats := func(pool string, db *prepared.DB) {
+		s := db.Stats()
+		total := stats[pool]
+		total.OpenConnections += s.OpenConnections
+		total.InUse += s.InUse
+		total.Idle += s.Idle
+		total.WaitCount += s.WaitCount
+		total.WaitDuration += s.WaitDuration
+		stats[pool] = total
+	}
+	for d := range c.dialects {
+		if d.PollDB != nil {
+			addStats("poll", d.PollDB)
+		}
+		if d.WriteDB == nil {
+			addStats("read_write", d.DB)
+			continue
+		}
+		addStats("read", d.DB)
+		addStats("write", d.WriteDB)
+	}
+
+	for pool, s := range stats {
+		ch <- prometheus.MustNewConstMetric(c.open, prometheus.GaugeValue, float64(s.OpenConnections), pool)
+		ch <- prometheus.MustNewConstMetric(c.inUse, prometheus.GaugeValue, float64(s.InUse), pool)
+		ch <- prometheus.MustNewConstMetric(c.idle, prometheus.GaugeValue, float64(s.Idle), pool)
+		ch <- prometheus.MustNewConstMetric(c.waitCount, prometheus.CounterValue, float64(s.WaitCount), pool)
+		ch <- prometheus.MustNewConstMetric(c.waitDuration, prometheus.CounterValue, s.WaitDuration.Seconds(), pool)
+	}
+}
+
 func errorToResultLabel(err error) string {
 	if err != nil {
 		return "fail"
@@ -39,10 +178,17 @@ func recordTxResult(txName string, err error) {
 
 func recordOpResult(txName string, err error, startTime time.Time) {
 	resultLabel := errorToResultLabel(err)
-	metricsOpLatency.WithLabelValues(txName, resultLabel).Observe(float64(time.Since(startTime) / time.Second))
+	metricsOpLatency.WithLabelValues(txName, resultLabel).Observe(time.Since(startTime).Seconds())
 	metricsOpResult.WithLabelValues(txName, resultLabel).Inc()
 }
 
+func recordCompact(ctx context.Context, duration time.Duration, deleted int64) {
+	metricsCompactLatency.Observe(duration.Seconds())
+	metricsCompactRowsDeleted.Observe(float64(deleted))
+	compactDuration.Record(ctx, duration.Seconds())
+	compactRowsDeleted.Record(ctx, deleted)
+}
+
 func incCurrentOps(txName string) {
 	metricsCurrentOps.WithLabelValues(txName).Inc()
 }
@@ -57,5 +203,17 @@ func init() {
 		metricsOpResult,
 		metricsOpLatency,
 		metricsCurrentOps,
+		metricsCompactLatency,
+		metricsCompactRowsDeleted,
+		metricsFillRows,
+		metricsWriteBatchSize,
+		metricsSlowQueryLatency,
+		metricsWriteQueueDepth,
+		metricsWriteQueueTime,
+		metricsPollQueueTime,
+		metricsVacuumReclaimedBytes,
+		metricsReadBarrierLatency,
+		metricsLeaderRetries,
+		poolStats,
 	)
 }
@@ -0,0 +1,304 @@
+package generic
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/canonical/k8s-dqlite/pkg/kine/compression"
+)
+
+// Options are the kine tuning parameters that can be set through the
+// query string of the datastore endpoint, regardless of the driver.
+type Options struct {
+	// CompactInterval is interval between database compactions performed by kine.
+	CompactInterval time.Duration
+	// CompactBatchSize is the maximum number of revisions removed in a single compaction transaction.
+	CompactBatchSize int64
+	// CompactBatchInterval is the pause between two consecutive compaction batches.
+	CompactBatchInterval time.Duration
+	// CompactRetentionDuration is how long revisions are retained before being compacted.
+	CompactRetentionDuration time.Duration
+	// CompactRetentionRevisions is the number of most recent revisions retained by compaction.
+	CompactRetentionRevisions int64
+	// PollInterval is the event poll interval used by kine.
+	PollInterval time.Duration
+	// MaxPollInterval is the interval up to which polling backs off when idle.
+	MaxPollInterval time.Duration
+	// WatchQueryTimeout is the timeout on the after query in the poll loop.
+	WatchQueryTimeout time.Duration
+	// VacuumInterval is the interval between scheduled database vacuums.
+	VacuumInterval time.Duration
+	// VacuumMode is either VacuumFull or VacuumIncremental.
+	VacuumMode string
+	// VacuumFreePages is the minimum number of free pages for a scheduled vacuum to run.
+	VacuumFreePages int64
+	// WriteBatchSize is the maximum number of writes committed in a single transaction.
+	WriteBatchSize int
+	// WatchCacheSize is the number of recent events kept in memory for watches.
+	WatchCacheSize int
+	// WatchQueueSize is the number of events queued for each watch.
+	WatchQueueSize int
+	// ReadTimeout is the timeout of the read queries.
+	ReadTimeout time.Duration
+	// WriteTimeout is the timeout of the writes.
+	WriteTimeout time.Duration
+	// CompactTimeout is the timeout of the compaction batches.
+	CompactTimeout time.Duration
+	// SlowQueryThreshold is the duration after which queries are logged as slow.
+	SlowQueryThreshold time.Duration
+	// SlowQueryRedactArgs hides the arguments of the slow queries.
+	SlowQueryRedactArgs bool
+	// WriteQueueTimeout is how long the writes wait for their turn.
+	WriteQueueTimeout time.Duration
+	// ReadBarrier commits a barrier before the linearizable reads.
+	ReadBarrier bool
+	// Compression is the algorithm compressing the values written.
+	Compression string
+	// CompressionThreshold is the minimum size of the values compressed.
+	CompressionThreshold int
+	// ChunkSize is the maximum size of the values stored whole.
+	ChunkSize int
+	// DedupOldValues reads the previous values back from the previous revisions.
+	DedupOldValues bool
+	// EventsPrefix is the prefix of the keys stored in the events table.
+	EventsPrefix string
+	// EventsCompactInterval is the interval between compactions of the events table.
+	EventsCompactInterval time.Duration
+	// EventsCompactRetentionDuration is how long the revisions of the events are retained.
+	EventsCompactRetentionDuration time.Duration
+}
+
+// ParseOptions extracts the kine tuning parameters from values. Recognised
+// keys are removed from values, so that the remaining ones can be handed
+// over to the database driver.
+func ParseOptions(values url.Values) (Options, error) {
+	var result Options
+
+	for k, vs := range values {
+		if len(vs) == 0 {
+			continue
+		}
+
+		switch k {
+		case "compact-interval":
+			d, err := time.ParseDuration(vs[0])
+			if err != nil {
+				return Options{}, fmt.Errorf("failed to parse compact-interval duration value %q: %w", vs[0], err)
+			}
+			result.CompactInterval = d
+		case "compact-batch-size":
+			n, err := strconv.ParseInt(vs[0], 10, 64)
+			if err != nil {
+				return Options{}, fmt.Errorf("failed to parse compact-batch-size value %q: %w", vs[0], err)
+			}
+			result.CompactBatchSize = n
+		case "compact-batch-interval":
+			d, err := time.ParseDuration(vs[0])
+			if err != nil {
+				return Options{}, fmt.Errorf("failed to parse compact-batch-interval duration value %q: %w", vs[0], err)
+			}
+			result.CompactBatchInterval = d
+		case "compact-retention-duration":
+			d, err := time.ParseDuration(vs[0])
+			if err != nil {
+				return Options{}, fmt.Errorf("failed to parse compact-retention-duration duration value %q: %w", vs[0], err)
+			}
+			result.CompactRetentionDuration = d
+		case "compact-retention-revisions":
+			n, err := strconv.ParseInt(vs[0], 10, 64)
+			if err != nil {
+				return Options{}, fmt.Errorf("failed to parse compact-retention-revisions value %q: %w", vs[0], err)
+			}
+			result.CompactRetentionRevisions = n
+		case "poll-interval":
+			d, err := time.ParseDuration(vs[0])
+			if err != nil {
+				return Options{}, fmt.Errorf("failed to parse poll-interval duration value %q: %w", vs[0], err)
+			}
+			result.PollInterval = d
+		case "max-poll-interval":
+			d, err := time.ParseDuration(vs[0])
+			if err != nil {
+				return Options{}, fmt.Errorf("failed to parse max-poll-interval duration value %q: %w", vs[0], err)
+			}
+			result.MaxPollInterval = d
+		case "watch-query-timeout":
+			d, err := time.ParseDuration(vs[0])
+			if err != nil {
+				return Options{}, fmt.Errorf("failed to parse watch-query-timeout duration value %q: %w", vs[0], err)
+			}
+			result.WatchQueryTimeout = d
+		case "vacuum-interval":
+			d, err := time.ParseDuration(vs[0])
+			if err != nil {
+				return Options{}, fmt.Errorf("failed to parse vacuum-interval duration value %q: %w", vs[0], err)
+			}
+			result.VacuumInterval = d
+		case "vacuum-mode":
+			switch vs[0] {
+			case VacuumFull, VacuumIncremental:
+				result.VacuumMode = vs[0]
+			default:
+				return Options{}, fmt.Errorf("unsupported vacuum-mode value %q (supported values are %s, %s)", vs[0], VacuumFull, VacuumIncremental)
+			}
+		case "vacuum-free-pages":
+			n, err := strconv.ParseInt(vs[0], 10, 64)
+			if err != nil {
+				return Options{}, fmt.Errorf("failed to parse vacuum-free-pages value %q: %w", vs[0], err)
+			}
+			result.VacuumFreePages = n
+		case "write-batch-size":
+			n, err := strconv.Atoi(vs[0])
+			if err != nil {
+				return Options{}, fmt.Errorf("failed to parse write-batch-size value %q: %w", vs[0], err)
+			}
+			result.WriteBatchSize = n
+		case "watch-cache-size":
+			n, err := strconv.Atoi(vs[0])
+			if err != nil {
+				return Options{}, fmt.Errorf("failed to parse watch-cache-size value %q: %w", vs[0], err)
+			}
+			result.WatchCacheSize = n
+		case "watch-queue-size":
+			n, err := strconv.Atoi(vs[0])
+			if err != nil {
+				return Options{}, fmt.Errorf("failed to parse watch-queue-size value %q: %w", vs[0], err)
+			}
+			result.WatchQueueSize = n
+		case "query-timeout-read":
+			d, err := time.ParseDuration(vs[0])
+			if err != nil {
+				return Options{}, fmt.Errorf("failed to parse query-timeout-read duration value %q: %w", vs[0], err)
+			}
+			result.ReadTimeout = d
+		case "query-timeout-write":
+			d, err := time.ParseDuration(vs[0])
+			if err != nil {
+				return Options{}, fmt.Errorf("failed to parse query-timeout-write duration value %q: %w", vs[0], err)
+			}
+			result.WriteTimeout = d
+		case "query-timeout-compact":
+			d, err := time.ParseDuration(vs[0])
+			if err != nil {
+				return Options{}, fmt.Errorf("failed to parse query-timeout-compact duration value %q: %w", vs[0], err)
+			}
+			result.CompactTimeout = d
+		case "slow-query-threshold":
+			d, err := time.ParseDuration(vs[0])
+			if err != nil {
+				return Options{}, fmt.Errorf("failed to parse slow-query-threshold duration value %q: %w", vs[0], err)
+			}
+			result.SlowQueryThreshold = d
+		case "slow-query-redact-args":
+			b, err := strconv.ParseBool(vs[0])
+			if err != nil {
+				return Options{}, fmt.Errorf("failed to parse slow-query-redact-args value %q: %w", vs[0], err)
+			}
+			result.SlowQueryRedactArgs = b
+		case "write-queue-timeout":
+			d, err := time.ParseDuration(vs[0])
+			if err != nil {
+				return Options{}, fmt.Errorf("failed to parse write-queue-timeout duration value %q: %w", vs[0], err)
+			}
+			result.WriteQueueTimeout = d
+		case "read-barrier":
+			b, err := strconv.ParseBool(vs[0])
+			if err != nil {
+				return Options{}, fmt.Errorf("failed to parse read-barrier value %q: %w", vs[0], err)
+			}
+			result.ReadBarrier = b
+		case "compression":
+			if _, err := compression.New(vs[0], 0); err != nil {
+				return Options{}, err
+			}
+			result.Compression = vs[0]
+		case "compression-threshold":
+			n, err := strconv.Atoi(vs[0])
+			if err != nil {
+				return Options{}, fmt.Errorf("failed to parse compression-threshold value %q: %w", vs[0], err)
+			}
+			result.CompressionThreshold = n
+		case "chunk-size":
+			n, err := strconv.Atoi(vs[0])
+			if err != nil {
+				return Options{}, fmt.Errorf("failed to parse chunk-size value %q: %w", vs[0], err)
+			}
+			result.ChunkSize = n
+		case "dedup-old-values":
+			b, err := strconv.ParseBool(vs[0])
+			if err != nil {
+				return Options{}, fmt.Errorf("failed to parse dedup-old-values value %q: %w", vs[0], err)
+			}
+			result.DedupOldValues = b
+		case "events-prefix":
+			if vs[0] != "" && !strings.HasSuffix(vs[0], "/") {
+				return Options{}, fmt.Errorf("events-prefix value %q must end with a slash", vs[0])
+			}
+			result.EventsPrefix = vs[0]
+		case "events-compact-interval":
+			d, err := time.ParseDuration(vs[0])
+			if err != nil {
+				return Options{}, fmt.Errorf("failed to parse events-compact-interval duration value %q: %w", vs[0], err)
+			}
+			result.EventsCompactInterval = d
+		case "events-compact-retention-duration":
+			d, err := time.ParseDuration(vs[0])
+			if err != nil {
+				return Options{}, fmt.Errorf("failed to parse events-compact-retention-duration duration value %q: %w", vs[0], err)
+			}
+			result.EventsCompactRetentionDuration = d
+		default:
+			continue
+		}
+		delete(values, k)
+	}
+
+	return result, nil
+}
+
+// ApplyOptions configures the dialect with the given tuning parameters.
+func (d *Generic) ApplyOptions(opts Options) {
+	d.CompactInterval = opts.CompactInterval
+	d.CompactBatchSize = opts.CompactBatchSize
+	d.CompactBatchInterval = opts.CompactBatchInterval
+	d.CompactRetentionDuration = opts.CompactRetentionDuration
+	d.CompactRetentionRevisions = opts.CompactRetentionRevisions
+	d.PollInterval = opts.PollInterval
+	d.MaxPollInterval = opts.MaxPollInterval
+	d.WatchQueryTimeout = opts.WatchQueryTimeout
+	d.VacuumInterval = opts.VacuumInterval
+	d.VacuumMode = opts.VacuumMode
+	d.VacuumFreePages = opts.VacuumFreePages
+	d.WriteBatchSize = opts.WriteBatchSize
+	d.WatchCacheSize = opts.WatchCacheSize
+	d.WatchQueueSize = opts.WatchQueueSize
+	d.ReadTimeout = opts.ReadTimeout
+	d.WriteTimeout = opts.WriteTimeout
+	d.CompactTimeout = opts.CompactTimeout
+	d.SlowQueryThreshold = opts.SlowQueryThreshold
+	d.SlowQueryRedactArgs = opts.SlowQueryRedactArgs
+	d.WriteQueueTimeout = opts.WriteQueueTimeout
+	d.ReadBarrierEnabled = opts.ReadBarrier
+	// The algorithm was checked when the options were parsed.
+	d.Compressor, _ = compression.New(opts.Compression, opts.CompressionThreshold)
+	d.ChunkSize = opts.ChunkSize
+	if d.ChunkSize > 0 && d.InsertChunkSQL == "" {
+		logger.Warning("Chunked values are only supported by sqlite and dqlite, values are stored whole")
+		d.ChunkSize = 0
+	}
+	d.DedupOldValues = opts.DedupOldValues
+	if d.DedupOldValues {
+		d.UpdateSQL = strings.Replace(d.UpdateSQL, storedOldValueSQL, dedupOldValueSQL, 1)
+		d.DeleteSQL = strings.Replace(d.DeleteSQL, storedOldValueSQL, dedupOldValueSQL, 1)
+	}
+	d.setEventsPrefix(opts.EventsPrefix)
+	if opts.EventsPrefix != "" && d.UpdateEventsCompactSQL == "" {
+		logger.Warning("Storing the events apart is only supported by sqlite and dqlite, events are stored with the other keys")
+		d.setEventsPrefix("")
+	}
+	d.EventsCompactInterval = opts.EventsCompactInterval
+	d.EventsCompactRetentionDuration = opts.EventsCompactRetentionDuration
+}
@@ -0,0 +1,282 @@
+package generic
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseOptions(t *testing.T) {
+	tests := []struct {
+		name     string
+		query    string
+		expected Options
+		// remaining are the query parameters left for the driver.
+		remaining url.Values
+		wantErr   bool
+	}{
+		{
+			name:      "empty",
+			remaining: url.Values{},
+		},
+		{
+			name: "all options",
+			query: "compact-interval=5m&compact-batch-size=500&compact-batch-interval=10ms" +
+				"&compact-retention-duration=1h&compact-retention-revisions=1000" +
+				"&poll-interval=2s&max-poll-interval=10s&watch-query-timeout=30s" +
+				"&vacuum-interval=1h&vacuum-mode=incremental&vacuum-free-pages=64" +
+				"&write-batch-size=8&watch-cache-size=500&watch-queue-size=1000" +
+				"&query-timeout-read=10s&query-timeout-write=15s&query-timeout-compact=1m" +
+				"&slow-query-threshold=1s&slow-query-redact-args=true" +
+				"&write-queue-timeout=5s",
+			expected: Options{
+				CompactInterval:           5 * time.Minute,
+				CompactBatchSize:          500,
+				CompactBatchInterval:      10 * time.Millisecond,
+				CompactRetentionDuration:  time.Hour,
+				CompactRetentionRevisions: 1000,
+				PollInterval:              2 * time.Second,
+				MaxPollInterval:           10 * time.Second,
+				WatchQueryTimeout:         30 * time.Second,
+				VacuumInterval:            time.Hour,
+				VacuumMode:                VacuumIncremental,
+				VacuumFreePages:           64,
+				WriteBatchSize:            8,
+				WatchCacheSize:            500,
+				WatchQueueSize:            1000,
+				ReadTimeout:               10 * time.Second,
+				WriteTimeout:              15 * time.Second,
+				CompactTimeout:            time.Minute,
+				SlowQueryThreshold:        time.Second,
+				SlowQueryRedactArgs:       true,
+				WriteQueueTimeout:         5 * time.Second,
+			},
+			remaining: url.Values{},
+		},
+		{
+			name:      "driver parameters",
+			query:     "_journal=WAL&poll-interval=2s&sslmode=disable",
+			expected:  Options{PollInterval: 2 * time.Second},
+			remaining: url.Values{"_journal": {"WAL"}, "sslmode": {"disable"}},
+		},
+		{
+			name:      "full vacuum mode",
+			query:     "vacuum-mode=full",
+			expected:  Options{VacuumMode: VacuumFull},
+			remaining: url.Values{},
+		},
+		{
+			name:    "invalid duration",
+			query:   "compact-interval=5",
+			wantErr: true,
+		},
+		{
+			name:    "invalid number",
+			query:   "compact-retention-revisions=many",
+			wantErr: true,
+		},
+		{
+			name:    "invalid vacuum mode",
+			query:   "vacuum-mode=sometimes",
+			wantErr: true,
+		},
+		{
+			name:      "compression",
+			query:     "compression=zstd&compression-threshold=512",
+			expected:  Options{Compression: "zstd", CompressionThreshold: 512},
+			remaining: url.Values{},
+		},
+		{
+			name:    "invalid compression",
+			query:   "compression=lz4",
+			wantErr: true,
+		},
+		{
+			name:      "dedup old values",
+			query:     "dedup-old-values=true",
+			expected:  Options{DedupOldValues: true},
+			remaining: url.Values{},
+		},
+		{
+			name:      "chunk size",
+			query:     "chunk-size=65536",
+			expected:  Options{ChunkSize: 65536},
+			remaining: url.Values{},
+		},
+		{
+			name:    "invalid chunk size",
+			query:   "chunk-size=64k",
+			wantErr: true,
+		},
+		{
+			name:  "events",
+			query: "events-prefix=/registry/events/&events-compact-interval=1m&events-compact-retention-duration=1h",
+			expected: Options{
+				EventsPrefix:                   "/registry/events/",
+				EventsCompactInterval:          time.Minute,
+				EventsCompactRetentionDuration: time.Hour,
+			},
+			remaining: url.Values{},
+		},
+		{
+			name:    "events prefix without slash",
+			query:   "events-prefix=/registry/events",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			values, err := url.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			opts, err := ParseOptions(values)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if opts != tt.expected {
+				t.Errorf("expected options %+v, got %+v", tt.expected, opts)
+			}
+			if !reflect.DeepEqual(values, tt.remaining) {
+				t.Errorf("expected remaining parameters %v, got %v", tt.remaining, values)
+			}
+		})
+	}
+}
+
+func TestApplyOptions(t *testing.T) {
+	opts := Options{
+		CompactInterval:           5 * time.Minute,
+		CompactBatchSize:          500,
+		CompactBatchInterval:      10 * time.Millisecond,
+		CompactRetentionDuration:  time.Hour,
+		CompactRetentionRevisions: 1000,
+		PollInterval:              2 * time.Second,
+		MaxPollInterval:           10 * time.Second,
+		WatchQueryTimeout:         30 * time.Second,
+		VacuumInterval:            time.Hour,
+		VacuumMode:                VacuumIncremental,
+		VacuumFreePages:           64,
+		WriteBatchSize:            8,
+	}
+
+	var d Generic
+	d.ApplyOptions(opts)
+
+	applied := Options{
+		CompactInterval:           d.CompactInterval,
+		CompactBatchSize:          d.CompactBatchSize,
+		CompactBatchInterval:      d.CompactBatchInterval,
+		CompactRetentionDuration:  d.CompactRetentionDuration,
+		CompactRetentionRevisions: d.CompactRetentionRevisions,
+		PollInterval:              d.PollInterval,
+		MaxPollInterval:           d.MaxPollInterval,
+		WatchQueryTimeout:         d.WatchQueryTimeout,
+		VacuumInterval:            d.VacuumInterval,
+		VacuumMode:                d.VacuumMode,
+		VacuumFreePages:           d.VacuumFreePages,
+		WriteBatchSize:            d.WriteBatchSize,
+	}
+	if applied != opts {
+		t.Errorf("expected dialect to be configured with %+v, got %+v", opts, applied)
+	}
+}
+
+func TestApplyEventsPrefix(t *testing.T) {
+	opts := Options{EventsPrefix: "/registry/events/"}
+
+	// The events are stored with the other keys by the drivers without an
+	// events table.
+	var d Generic
+	d.ApplyOptions(opts)
+	if prefix := d.EventsPrefix(); prefix != "" {
+		t.Errorf("expected the events stored with the other keys, got prefix %q", prefix)
+	}
+
+	d = Generic{UpdateEventsCompactSQL: "UPDATE"}
+	d.ApplyOptions(opts)
+	if prefix := d.EventsPrefix(); prefix != opts.EventsPrefix {
+		t.Errorf("expected the events prefix %q, got %q", opts.EventsPrefix, prefix)
+	}
+	for _, tc := range []struct {
+		start, end, table string
+	}{
+		{"/registry/events/", "/registry/events0", eventsTable},
+		{"/registry/events/default/", "/registry/events/default0", eventsTable},
+		{"/registry/pods/", "/registry/pods0", mainTable},
+		{"/registry/", "/registry0", allTable},
+		{"/registry/a", "/registry/events/a", allTable},
+	} {
+		if table := d.events.table(tc.start, tc.end); table != tc.table {
+			t.Errorf("expected the keys between %s and %s in %s, got %s", tc.start, tc.end, tc.table, table)
+		}
+	}
+}
+
+func TestParseWriteBatchSize(t *testing.T) {
+	tests := []struct {
+		query     string
+		batchSize int
+		wantErr   bool
+	}{
+		{
+			query: "",
+		},
+		{
+			query:     "write-batch-size=16",
+			batchSize: 16,
+		},
+		{
+			query:     "write-batch-size=0",
+			batchSize: 0,
+		},
+		{
+			query:   "write-batch-size=many",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			values, err := url.ParseQuery(tt.query + "&_journal=WAL")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			opts, err := ParseOptions(values)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if opts.WriteBatchSize != tt.batchSize {
+				t.Errorf("expected write batch size %d, got %d", tt.batchSize, opts.WriteBatchSize)
+			}
+			if values.Has("write-batch-size") {
+				t.Error("expected write-batch-size to be removed from the query")
+			}
+			if values.Get("_journal") != "WAL" {
+				t.Error("expected driver parameters to be kept in the query")
+			}
+
+			var d Generic
+			d.ApplyOptions(opts)
+			if d.WriteBatchSize != tt.batchSize {
+				t.Errorf("expected dialect write batch size %d, got %d", tt.batchSize, d.WriteBatchSize)
+			}
+		})
+	}
+}
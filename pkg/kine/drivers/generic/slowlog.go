@@ -0,0 +1,54 @@
+package generic
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// logSlowQuery logs the queries taking longer than the slow query threshold,
+// with the number of rows they affected, or -1 if unknown. For the queries
+// returning rows, the duration is the time until the first rows are ready.
+func (d *Generic) logSlowQuery(txName, query string, args []interface{}, start time.Time, rows int64) {
+	threshold := d.SlowQueryThreshold
+	if threshold <= 0 {
+		return
+	}
+	duration := time.Since(start)
+	if duration < threshold {
+		return
+	}
+
+	metricsSlowQueryLatency.WithLabelValues(txName).Observe(duration.Seconds())
+	fields := logrus.Fields{
+		"tx_name":  txName,
+		"duration": duration,
+		"args":     slowQueryArgs(args, d.SlowQueryRedactArgs),
+		"query":    Stripped(query).String(),
+	}
+	if rows >= 0 {
+		fields["rows"] = rows
+	}
+	logger.WithFields(fields).Warn("Slow query")
+}
+
+// slowQueryArgs formats the arguments of a slow query. Values are only
+// logged by size, as they might be large or binary, and all the arguments
+// are hidden if redact is set.
+func slowQueryArgs(args []interface{}, redact bool) []string {
+	result := make([]string, 0, len(args))
+	for _, arg := range args {
+		switch arg := arg.(type) {
+		case []byte:
+			result = append(result, fmt.Sprintf("<%d bytes>", len(arg)))
+		default:
+			if redact {
+				result = append(result, "<redacted>")
+			} else {
+				result = append(result, fmt.Sprintf("%v", arg))
+			}
+		}
+	}
+	return result
+}
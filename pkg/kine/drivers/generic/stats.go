@@ -0,0 +1,24 @@
+package generic
+
+import (
+	"context"
+	"database/sql"
+)
+
+// keyStatsSQL aggregates the rows of each key, along with its latest row.
+var keyStatsSQL = `
+	SELECT kv.name, kv.deleted, COALESCE(LENGTH(kv.value), 0), s.revisions, s.bytes
+	FROM kine AS kv
+	JOIN (
+		SELECT MAX(id) AS id, COUNT(*) AS revisions,
+			SUM(COALESCE(LENGTH(value), 0) + COALESCE(LENGTH(old_value), 0)) AS bytes
+		FROM kine
+		GROUP BY name
+	) AS s
+		ON s.id = kv.id`
+
+// KeyStats lists, for each key, whether it is deleted, the size of its
+// value, its number of rows and the bytes used by their values.
+func (d *Generic) KeyStats(ctx context.Context) (*sql.Rows, error) {
+	return d.query(ctx, "key_stats_sql", d.allTableSQL(d.KeyStatsSQL))
+}
@@ -0,0 +1,130 @@
+package generic
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/canonical/k8s-dqlite/pkg/kine/logstructured/sqllog"
+	"github.com/canonical/k8s-dqlite/pkg/kine/prepared"
+	"github.com/canonical/k8s-dqlite/pkg/kine/server"
+)
+
+// Txn runs f in a single database transaction, which is committed if f
+// succeeds. The transaction is retried as a whole, running f again, if
+// it fails with an error the driver can retry.
+func (d *Generic) Txn(ctx context.Context, f func(tx sqllog.Tx) error) (err error) {
+	ctx, span := otelTracer.Start(ctx, fmt.Sprintf("%s.Txn", otelName))
+	defer func() {
+		if err != nil && d.TranslateErr != nil {
+			err = d.TranslateErr(err)
+		}
+		span.RecordError(err)
+		span.End()
+	}()
+
+	ctx, cancel := withTimeout(ctx, d.WriteTimeout)
+	defer cancel()
+	unlock, err := d.lockWrites(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	span.AddEvent("acquired write lock")
+
+	start := time.Now()
+	defer func() {
+		recordOpResult("txn", err, start)
+		recordTxResult("txn", err)
+	}()
+
+	for retryCount := 0; retryCount < maxRetries; retryCount++ {
+		err = d.tryTxn(ctx, f)
+		if err == nil || !d.shouldRetry(ctx, "txn", err, retryCount, start) {
+			break
+		}
+	}
+	return err
+}
+
+func (d *Generic) tryTxn(ctx context.Context, f func(tx sqllog.Tx) error) error {
+	tx, err := d.writeDB().BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			logger.WithError(err).Trace("can't rollback transaction")
+		}
+	}()
+
+	if err := f(&genericTx{d: d, tx: tx}); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// genericTx runs the queries of the dialect in a database transaction.
+type genericTx struct {
+	d  *Generic
+	tx *prepared.Tx
+}
+
+func (t *genericTx) CurrentRevision(ctx context.Context) (int64, error) {
+	rows, err := t.tx.QueryContext(ctx, t.d.tableSQL(revSQL, mainTable))
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return 0, err
+		}
+		return 0, fmt.Errorf("can't get current revision: aggregate query returned empty set")
+	}
+
+	var id sql.NullInt64
+	if err := rows.Scan(&id); err != nil {
+		return 0, err
+	}
+	return id.Int64, nil
+}
+
+func (t *genericTx) ListRange(ctx context.Context, start, end string, limit int64, includeDeleted bool, opts server.RangeOptions) (*sql.Rows, error) {
+	_, query, args := t.d.listRangeQuery(start, end, limit, includeDeleted, opts)
+	return t.tx.QueryContext(ctx, query, args...)
+}
+
+func (t *genericTx) Create(ctx context.Context, key string, value []byte, lease int64) (int64, bool, error) {
+	createCnt.Add(ctx, 1)
+	return t.d.insertTx(ctx, t.tx, t.d.keyTableSQL(t.d.CreateSQL, key), key, lease, t.d.Compressor.Encode(value), key)
+}
+
+func (t *genericTx) Update(ctx context.Context, key string, value []byte, prevRev, lease int64) (int64, bool, error) {
+	updateCnt.Add(ctx, 1)
+	return t.d.insertTx(ctx, t.tx, t.d.keyTableSQL(t.d.UpdateSQL, key), key, lease, t.d.Compressor.Encode(value), key, prevRev)
+}
+
+func (t *genericTx) Delete(ctx context.Context, key string, revision int64) (int64, bool, error) {
+	deleteCnt.Add(ctx, 1)
+	return t.d.insertTx(ctx, t.tx, t.d.keyTableSQL(t.d.DeleteSQL, key), key, revision)
+}
+
+// ReadChunks returns the value of key stored in the chunks following the
+// chunk id, or nil if there are none.
+func (t *genericTx) ReadChunks(ctx context.Context, key string, id int64) ([]byte, error) {
+	if t.d.ReadChunksSQL == "" {
+		return nil, nil
+	}
+	rows, err := t.tx.QueryContext(ctx, t.d.ReadChunksSQL, id, id, key)
+	if err != nil {
+		return nil, err
+	}
+	return scanChunks(rows)
+}
+
+func (t *genericTx) GetLease(ctx context.Context, id int64) (*sql.Rows, error) {
+	return t.tx.QueryContext(ctx, t.d.GetLeaseSQL, id)
+}
@@ -0,0 +1,140 @@
+package generic
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const (
+	// VacuumFull rebuilds the whole database file.
+	VacuumFull = "full"
+	// VacuumIncremental only returns the free pages to the file
+	// system, which requires incremental auto_vacuum to be enabled.
+	VacuumIncremental = "incremental"
+)
+
+// PageStats returns the number of pages of the database, how many of them
+// are unused and the size of a page in bytes.
+func (d *Generic) PageStats(ctx context.Context) (pageCount, freePages, pageSize int64, err error) {
+	if d.PageStatsSQL == "" {
+		return 0, 0, 0, errors.New("driver does not support page statistics")
+	}
+	rows, err := d.query(ctx, "page_stats_sql", d.PageStatsSQL)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return 0, 0, 0, err
+		}
+		return 0, 0, 0, fmt.Errorf("cannot get page statistics: query returned no rows")
+	}
+
+	if err := rows.Scan(&pageCount, &freePages, &pageSize); err != nil {
+		return 0, 0, 0, err
+	}
+	return pageCount, freePages, pageSize, nil
+}
+
+// Vacuum returns the unused pages of the database to the file system and,
+// if supported, checkpoints the write-ahead log. It reports the size of the
+// database file before and after the vacuum.
+func (d *Generic) Vacuum(ctx context.Context) (before, after int64, err error) {
+	ctx, span := otelTracer.Start(ctx, fmt.Sprintf("%s.Vacuum", otelName))
+	defer func() {
+		span.RecordError(err)
+		span.SetAttributes(attribute.Int64("before", before), attribute.Int64("after", after))
+		span.End()
+	}()
+	span.SetAttributes(attribute.String("mode", d.VacuumMode))
+
+	if d.VacuumSQL == "" {
+		return 0, 0, errors.New("driver does not support vacuum")
+	}
+	return d.vacuumWith(ctx, "vacuum_sql", d.VacuumSQL)
+}
+
+// IncrementalVacuum only returns the free pages of the database to the file
+// system, without rebuilding it. It reports the size of the database file
+// before and after the vacuum.
+func (d *Generic) IncrementalVacuum(ctx context.Context) (before, after int64, err error) {
+	ctx, span := otelTracer.Start(ctx, fmt.Sprintf("%s.IncrementalVacuum", otelName))
+	defer func() {
+		span.RecordError(err)
+		span.SetAttributes(attribute.Int64("before", before), attribute.Int64("after", after))
+		span.End()
+	}()
+
+	if d.IncrementalVacuumSQL == "" {
+		return 0, 0, errors.New("driver does not support incremental vacuum")
+	}
+	return d.vacuumWith(ctx, "incremental_vacuum_sql", d.IncrementalVacuumSQL)
+}
+
+func (d *Generic) vacuumWith(ctx context.Context, txName, query string) (before, after int64, err error) {
+	vacuumCnt.Add(ctx, 1)
+
+	pageCount, _, pageSize, err := d.PageStats(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+	before = pageCount * pageSize
+
+	if err := d.vacuum(ctx, txName, query); err != nil {
+		return 0, 0, err
+	}
+
+	pageCount, _, pageSize, err = d.PageStats(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+	after = pageCount * pageSize
+
+	if after < before {
+		metricsVacuumReclaimedBytes.Add(float64(before - after))
+	}
+	return before, after, nil
+}
+
+// vacuum runs the vacuum and the checkpoint on a dedicated connection. SQLite
+// refuses to vacuum while other statements of the same connection are still
+// in progress, which can't be ruled out for the pooled connections holding
+// the cached prepared statements.
+func (d *Generic) vacuum(ctx context.Context, txName, query string) (err error) {
+	unlock, err := d.lockWrites(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	start := time.Now()
+	defer func() {
+		recordOpResult(txName, err, start)
+		recordTxResult(txName, err)
+	}()
+
+	conn, err := d.writeDB().Underlying().Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, query); err != nil {
+		return err
+	}
+	if d.CheckpointSQL == "" {
+		return nil
+	}
+	// The checkpoint returns a row, which is only completed by closing rows.
+	rows, err := conn.QueryContext(ctx, d.CheckpointSQL)
+	if err != nil {
+		return err
+	}
+	return rows.Close()
+}
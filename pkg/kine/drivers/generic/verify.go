@@ -0,0 +1,110 @@
+package generic
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// invariantsSQL lists the rows breaking the invariants of the kine table,
+// with the problem found. The created rows are not checked against their
+// previous revision, which older versions set to the current revision. The
+// rows filling the gaps of revisions are left out. The compact revision is
+// reported on a row of its own.
+var invariantsSQL = `
+	SELECT kv.id, kv.name, 'references a later revision' AS problem
+	FROM kine AS kv
+	WHERE kv.name NOT LIKE 'gap-%'
+		AND (kv.create_revision >= kv.id OR (kv.created = 0 AND kv.prev_revision >= kv.id))
+	UNION ALL
+	SELECT kv.id, kv.name, 'is a tombstone marked as created'
+	FROM kine AS kv
+	WHERE kv.deleted = 1 AND kv.created = 1
+	UNION ALL
+	SELECT kv.id, kv.name, 'follows a tombstone without being created'
+	FROM kine AS kv
+	JOIN kine AS prev
+		ON prev.id = kv.prev_revision
+	WHERE kv.created = 0
+		AND prev.deleted = 1
+	UNION ALL
+	SELECT kv.id, kv.name, 'follows a revision of another key'
+	FROM kine AS kv
+	JOIN kine AS prev
+		ON prev.id = kv.prev_revision
+	WHERE kv.created = 0
+		AND prev.name != kv.name
+	UNION ALL
+	SELECT 0, meta.name, 'records a compact revision after the current revision'
+	FROM kine_metadata AS meta
+	WHERE meta.name IN ('compact_revision', 'events_compact_revision')
+		AND meta.value > COALESCE((` + revSQL + `), 0)`
+
+// revisionGapsSQL lists the revisions in (start, end] whose previous
+// revision is missing, with the revision before the gap. Only the revisions
+// after the compact revision are checked, as the compactions delete the rows
+// up to it, while the gaps after it are filled by rows of their own. When the
+// events are stored apart, the latest compact revision of both tables is
+// used.
+var revisionGapsSQL = `
+	SELECT COALESCE((
+			SELECT MAX(prev.id)
+			FROM kine AS prev
+			WHERE meta.value < prev.id AND prev.id < kv.id
+		), meta.value), kv.id
+	FROM kine AS kv, (
+		SELECT MAX(value) AS value
+		FROM kine_metadata
+		WHERE name IN ('compact_revision', 'events_compact_revision')
+	) AS meta
+	WHERE ? < kv.id AND kv.id <= ?
+		AND kv.id > meta.value + 1
+		AND NOT EXISTS (SELECT 1 FROM kine AS prev WHERE prev.id = kv.id - 1)`
+
+// IntegrityCheck runs the integrity check of the database, and returns the
+// problems found. Drivers without integrity check report none.
+func (d *Generic) IntegrityCheck(ctx context.Context) (problems []string, err error) {
+	ctx, span := otelTracer.Start(ctx, fmt.Sprintf("%s.IntegrityCheck", otelName))
+	defer func() {
+		span.RecordError(err)
+		span.End()
+	}()
+
+	if d.IntegrityCheckSQL == "" {
+		return nil, nil
+	}
+	rows, err := d.query(ctx, "integrity_check_sql", d.IntegrityCheckSQL)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var problem string
+		if err := rows.Scan(&problem); err != nil {
+			return nil, err
+		}
+		// A database without problems reports a single ok row.
+		if problem != "ok" {
+			problems = append(problems, problem)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	span.SetAttributes(attribute.Int("problems", len(problems)))
+	return problems, nil
+}
+
+// Invariants lists the id, name and problem of the rows breaking the
+// invariants of the kine table.
+func (d *Generic) Invariants(ctx context.Context) (*sql.Rows, error) {
+	return d.query(ctx, "invariants_sql", d.allTableSQL(d.InvariantsSQL))
+}
+
+// RevisionGaps lists the revisions in (start, end], after the compact
+// revision, that follow missing revisions, with the revision before them.
+func (d *Generic) RevisionGaps(ctx context.Context, start, end int64) (*sql.Rows, error) {
+	return d.query(ctx, "revision_gaps_sql", d.allTableSQL(d.RevisionGapsSQL), start, end)
+}
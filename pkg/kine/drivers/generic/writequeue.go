@@ -0,0 +1,92 @@
+package generic
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// writeQueue serializes the writes of the dialects that lock them. Unlike
+// a mutex, writers are served in the order they arrive, and the ones whose
+// context is done leave the queue instead of waiting for their turn.
+type writeQueue struct {
+	mu   sync.Mutex
+	busy bool
+	// waiters are the channels of the queued writers, which are closed
+	// when the writers are handed the queue over.
+	waiters list.List
+}
+
+// acquire waits for the turn of the writer, until ctx is done.
+func (q *writeQueue) acquire(ctx context.Context) error {
+	q.mu.Lock()
+	if !q.busy {
+		q.busy = true
+		q.mu.Unlock()
+		return nil
+	}
+	ready := make(chan struct{})
+	elem := q.waiters.PushBack(ready)
+	metricsWriteQueueDepth.Inc()
+	q.mu.Unlock()
+
+	start := time.Now()
+	defer func() {
+		metricsWriteQueueTime.Observe(time.Since(start).Seconds())
+	}()
+
+	select {
+	case <-ready:
+		return nil
+	case <-ctx.Done():
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		select {
+		case <-ready:
+			// The queue was handed over in the meantime, pass it on.
+			q.handOver()
+		default:
+			q.waiters.Remove(elem)
+			metricsWriteQueueDepth.Dec()
+		}
+		return ctx.Err()
+	}
+}
+
+// release hands the queue over to the next writer.
+func (q *writeQueue) release() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.handOver()
+}
+
+// handOver must be called with the lock held.
+func (q *writeQueue) handOver() {
+	next := q.waiters.Front()
+	if next == nil {
+		q.busy = false
+		return
+	}
+	q.waiters.Remove(next)
+	metricsWriteQueueDepth.Dec()
+	close(next.Value.(chan struct{}))
+}
+
+// lockWrites waits for the turn of a write, if writes are locked, and
+// returns the function ending it.
+func (d *Generic) lockWrites(ctx context.Context) (func(), error) {
+	if !d.LockWrites {
+		return func() {}, nil
+	}
+	if timeout := d.WriteQueueTimeout; timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	if err := d.writes.acquire(ctx); err != nil {
+		return nil, fmt.Errorf("failed to wait for the write queue: %w", err)
+	}
+	return d.writes.release, nil
+}
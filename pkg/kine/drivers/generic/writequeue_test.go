@@ -0,0 +1,71 @@
+package generic
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestWriteQueueOrder(t *testing.T) {
+	ctx := context.Background()
+	var q writeQueue
+	if err := q.acquire(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	const writers = 5
+	served := make(chan int, writers)
+	for i := 0; i < writers; i++ {
+		go func(i int) {
+			if err := q.acquire(ctx); err != nil {
+				t.Error(err)
+				return
+			}
+			served <- i
+			q.release()
+		}(i)
+		// Wait for the writer to be queued before starting the next one.
+		waitForWaiters(t, &q, i+1)
+	}
+
+	q.release()
+	for i := 0; i < writers; i++ {
+		if got := <-served; got != i {
+			t.Errorf("expected writer %d to be served, got %d", i, got)
+		}
+	}
+}
+
+func TestWriteQueueCancel(t *testing.T) {
+	var q writeQueue
+	if err := q.acquire(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := q.acquire(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the deadline to be exceeded, got %v", err)
+	}
+	waitForWaiters(t, &q, 0)
+
+	// The cancelled writer doesn't hold a slot.
+	q.release()
+	if err := q.acquire(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func waitForWaiters(t *testing.T, q *writeQueue, n int) {
+	t.Helper()
+	for start := time.Now(); time.Since(start) < time.Second; time.Sleep(time.Millisecond) {
+		q.mu.Lock()
+		waiters := q.waiters.Len()
+		q.mu.Unlock()
+		if waiters == n {
+			return
+		}
+	}
+	t.Fatalf("expected %d queued writers", n)
+}
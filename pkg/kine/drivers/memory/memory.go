@@ -0,0 +1,68 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/canonical/k8s-dqlite/pkg/kine/drivers"
+	"github.com/canonical/k8s-dqlite/pkg/kine/drivers/generic"
+	"github.com/canonical/k8s-dqlite/pkg/kine/drivers/sqlite"
+	"github.com/canonical/k8s-dqlite/pkg/kine/server"
+	"github.com/canonical/k8s-dqlite/pkg/logging"
+)
+
+var logger = logging.Component(logging.Driver)
+
+const defaultName = "kine"
+
+func init() {
+	drivers.Register("memory", func(ctx context.Context, cfg *drivers.Config) (bool, server.Backend, error) {
+		backend, err := New(ctx, cfg.DataSourceName, cfg.ConnectionPoolConfig)
+		return false, backend, err
+	})
+}
+
+// New creates a kine backend on an in-memory SQLite database. The data
+// only lives as long as the process and is never written to disk, which
+// makes it suitable for tests and ephemeral clusters.
+//
+// The data source name is the name of the database, optionally followed by
+// the usual query parameters. Backends opened with the same name in the
+// same process share the database.
+func New(ctx context.Context, dataSourceName string, connectionPoolConfig *generic.ConnectionPoolConfig) (server.Backend, error) {
+	backend, _, err := NewVariant(ctx, dataSourceName, connectionPoolConfig)
+	return backend, err
+}
+
+func NewVariant(ctx context.Context, dataSourceName string, connectionPoolConfig *generic.ConnectionPoolConfig) (server.Backend, *generic.Generic, error) {
+	logger.Printf("New kine for memory")
+
+	name, query, _ := strings.Cut(dataSourceName, "?")
+	name = strings.Trim(name, "/")
+	if name == "" {
+		name = defaultName
+	}
+
+	// The memdb VFS (unlike a shared cache) supports concurrent connections
+	// with regular locking, but the database is dropped as soon as its last
+	// connection is closed, so at least one connection must be kept forever.
+	poolConfig := *connectionPoolConfig
+	if poolConfig.MaxIdle < 1 {
+		poolConfig.MaxIdle = 1
+	}
+	poolConfig.MaxLifetime = 0
+	poolConfig.MaxIdleTime = 0
+
+	dsn := fmt.Sprintf("file:/%s?vfs=memdb&_busy_timeout=5000", name)
+	if query != "" {
+		dsn = fmt.Sprintf("%s&%s", dsn, query)
+	}
+	backend, dialect, err := sqlite.NewVariant(ctx, "sqlite3", dsn, &poolConfig)
+	if err != nil {
+		return nil, nil, err
+	}
+	// VACUUM INTO would write backups through the memdb VFS as well.
+	dialect.BackupSQL = ""
+	return backend, dialect, nil
+}
@@ -0,0 +1,368 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/canonical/k8s-dqlite/pkg/kine/drivers"
+	"github.com/canonical/k8s-dqlite/pkg/kine/drivers/generic"
+	"github.com/canonical/k8s-dqlite/pkg/kine/logstructured"
+	"github.com/canonical/k8s-dqlite/pkg/kine/logstructured/sqllog"
+	"github.com/canonical/k8s-dqlite/pkg/kine/server"
+	"github.com/canonical/k8s-dqlite/pkg/kine/tls"
+	"github.com/canonical/k8s-dqlite/pkg/logging"
+	"github.com/go-sql-driver/mysql"
+)
+
+var logger = logging.Component(logging.Driver)
+
+const (
+	defaultDSN = "root@unix(/var/run/mysqld/mysqld.sock)/"
+	defaultDB  = "kubernetes"
+
+	// tlsConfigName is the name under which the TLS configuration
+	// of the datastore is registered in the mysql driver.
+	tlsConfigName = "kine"
+
+	// MySQL error numbers, see https://dev.mysql.com/doc/mysql-errors/8.0/en/server-error-reference.html
+	errDupFieldName    = 1060
+	errDupKeyName      = 1061
+	errCantDropKey     = 1091
+	errDupEntry        = 1062
+	errLockDeadlock    = 1213
+	errLockWaitTimeout = 1205
+)
+
+var (
+	schema = []string{
+		`CREATE TABLE IF NOT EXISTS kine
+		(
+			id BIGINT UNSIGNED AUTO_INCREMENT,
+			name VARCHAR(630) CHARACTER SET ascii COLLATE ascii_bin NOT NULL,
+			created INTEGER,
+			deleted INTEGER,
+			create_revision BIGINT UNSIGNED NOT NULL,
+			prev_revision BIGINT UNSIGNED,
+			lease INTEGER,
+			value MEDIUMBLOB,
+			old_value MEDIUMBLOB,
+			PRIMARY KEY (id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS kine_leases
+		(
+			id BIGINT NOT NULL,
+			ttl BIGINT NOT NULL,
+			PRIMARY KEY (id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS kine_alarms
+		(
+			alarm INTEGER NOT NULL,
+			PRIMARY KEY (alarm)
+		)`,
+		// The compact revision was kept in the prev_revision of the
+		// compact_rev_key rows of the kine table, which are moved to the
+		// metadata table.
+		`CREATE TABLE IF NOT EXISTS kine_metadata
+		(
+			name VARCHAR(64) CHARACTER SET ascii COLLATE ascii_bin NOT NULL,
+			value BIGINT NOT NULL,
+			PRIMARY KEY (name)
+		)`,
+		`INSERT IGNORE INTO kine_metadata(name, value)
+		SELECT 'compact_revision', COALESCE(MAX(prev_revision), 0)
+		FROM kine
+		WHERE name = 'compact_rev_key'`,
+		`DELETE FROM kine WHERE name = 'compact_rev_key'`,
+	}
+
+	// MySQL has no "CREATE INDEX IF NOT EXISTS", so duplicate
+	// index errors are ignored when creating these. The deleted flag is
+	// included in the index of the revisions by name, so that the latest
+	// revision of a key, and whether it is deleted, are looked up from the
+	// index alone.
+	indexes = []string{
+		`CREATE INDEX kine_name_id_deleted_index ON kine (name, id, deleted)`,
+		`CREATE UNIQUE INDEX kine_name_prev_revision_uindex ON kine (prev_revision, name)`,
+	}
+
+	// MySQL has no "DROP INDEX IF EXISTS" either, so missing index errors
+	// are ignored when dropping these, which the indexes above replace.
+	droppedIndexes = []string{
+		`DROP INDEX kine_name_index ON kine`,
+	}
+
+	// MySQL has no "ADD COLUMN IF NOT EXISTS" either, so duplicate
+	// column errors are ignored when adding these.
+	columns = []string{
+		`ALTER TABLE kine_leases ADD COLUMN expiry BIGINT`,
+	}
+
+	// escapeValues escapes once the raw values starting with a marker byte
+	// of the compression package, so that they are no longer taken for
+	// compressed values or chunk references. The statements are run in a
+	// transaction, so that the values are not escaped twice.
+	escapeValues = []string{
+		`UPDATE kine
+		SET value = CASE WHEN LEFT(value, 1) BETWEEN X'F5' AND X'F8' THEN CONCAT(X'F7', value) ELSE value END,
+			old_value = CASE WHEN LEFT(old_value, 1) BETWEEN X'F5' AND X'F8' THEN CONCAT(X'F7', old_value) ELSE old_value END
+		WHERE (LEFT(value, 1) BETWEEN X'F5' AND X'F8' OR LEFT(old_value, 1) BETWEEN X'F5' AND X'F8')
+			AND NOT EXISTS (SELECT 1 FROM kine_metadata WHERE name = 'escaped_values')`,
+		`INSERT IGNORE INTO kine_metadata(name, value) VALUES('escaped_values', 1)`,
+	}
+)
+
+type opts struct {
+	dsn string
+
+	generic.Options
+}
+
+func init() {
+	drivers.Register("mysql", func(ctx context.Context, cfg *drivers.Config) (bool, server.Backend, error) {
+		backend, err := New(ctx, cfg.DataSourceName, cfg.Config, cfg.ConnectionPoolConfig)
+		return true, backend, err
+	})
+}
+
+func New(ctx context.Context, dataSourceName string, tlsInfo tls.Config, connectionPoolConfig *generic.ConnectionPoolConfig) (server.Backend, error) {
+	backend, _, err := NewVariant(ctx, dataSourceName, tlsInfo, connectionPoolConfig)
+	return backend, err
+}
+
+func NewVariant(ctx context.Context, dataSourceName string, tlsInfo tls.Config, connectionPoolConfig *generic.ConnectionPoolConfig) (server.Backend, *generic.Generic, error) {
+	const retryAttempts = 300
+
+	logger.Printf("New kine for mysql")
+
+	opts, err := parseOpts(dataSourceName, tlsInfo)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := createDBIfNotExist(ctx, opts.dsn); err != nil {
+		return nil, nil, err
+	}
+
+	dialect, err := generic.Open(ctx, "mysql", opts.dsn, connectionPoolConfig, "?", false)
+	if err != nil {
+		return nil, nil, err
+	}
+	for i := 0; i < retryAttempts; i++ {
+		err = setup(ctx, dialect.DB.Underlying())
+		if err == nil {
+			break
+		}
+		logger.Errorf("failed to setup db: %v", err)
+		select {
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		case <-time.After(time.Second):
+		}
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	configureDialect(dialect)
+	dialect.ApplyOptions(opts.Options)
+
+	return logstructured.New(sqllog.New(dialect)), dialect, nil
+}
+
+// configureDialect adapts the generic dialect to MySQL.
+func configureDialect(dialect *generic.Generic) {
+	// MySQL rejects bare columns in aggregate queries and deleting from, or
+	// updating, a table that is also selected from, so these queries are
+	// replaced.
+	dialect.CreateSQL = `
+		INSERT INTO kine(name, created, deleted, create_revision, prev_revision, lease, value, old_value)
+		SELECT
+			? AS name,
+			1 AS created,
+			0 AS deleted,
+			0 AS create_revision,
+			COALESCE(maxkv.id, 0) AS prev_revision,
+			? AS lease,
+			? AS value,
+			NULL AS old_value
+		FROM (
+			SELECT MAX(id) AS id
+			FROM kine
+			WHERE name = ?
+		) maxkv
+		LEFT JOIN kine ON kine.id = maxkv.id
+		WHERE maxkv.id IS NULL OR kine.deleted = 1`
+	dialect.CompactSQL = `
+		DELETE kv FROM kine AS kv
+		INNER JOIN (
+			SELECT kp.prev_revision AS id
+			FROM kine AS kp
+			WHERE kp.created = 0
+				AND kp.prev_revision != 0
+				AND ? < kp.id AND kp.id <= ?
+		) AS ks
+			ON kv.id = ks.id`
+	dialect.DedupOldValuesSQL = `
+		UPDATE kine AS kv
+		INNER JOIN kine AS pkv
+			ON pkv.id = kv.prev_revision
+		SET kv.old_value = NULL
+		WHERE ? < kv.id AND kv.id <= ?
+			AND kv.created = 0
+			AND kv.old_value = pkv.value`
+	// MySQL concatenates with CONCAT, || being a logical or.
+	dialect.SetFillNameSQL(`name = CONCAT('gap-', id)`)
+	// Serializable transactions lock the rows they read in MySQL, while
+	// repeatable reads already see a consistent snapshot.
+	dialect.BackupIsolation = sql.LevelRepeatableRead
+	dialect.GetSizeSQL = `
+		SELECT CAST(SUM(data_length + index_length) AS SIGNED)
+		FROM information_schema.TABLES
+		WHERE table_schema = DATABASE() AND table_name = 'kine'`
+
+	dialect.Retry = func(err error) bool {
+		var mysqlErr *mysql.MySQLError
+		if errors.As(err, &mysqlErr) {
+			return mysqlErr.Number == errLockDeadlock || mysqlErr.Number == errLockWaitTimeout
+		}
+		return false
+	}
+	dialect.TranslateErr = func(err error) error {
+		var mysqlErr *mysql.MySQLError
+		if errors.As(err, &mysqlErr) && mysqlErr.Number == errDupEntry {
+			return server.ErrKeyExists
+		}
+		return err
+	}
+	dialect.ErrCode = func(err error) string {
+		if err == nil {
+			return ""
+		}
+		var mysqlErr *mysql.MySQLError
+		if errors.As(err, &mysqlErr) {
+			return strconv.Itoa(int(mysqlErr.Number))
+		}
+		return err.Error()
+	}
+}
+
+// setup creates the kine tables and indexes if they don't exist yet.
+func setup(ctx context.Context, db *sql.DB) error {
+	for _, stmt := range schema {
+		if _, err := db.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+
+	for _, stmt := range indexes {
+		if _, err := db.ExecContext(ctx, stmt); err != nil {
+			var mysqlErr *mysql.MySQLError
+			if !errors.As(err, &mysqlErr) || mysqlErr.Number != errDupKeyName {
+				return err
+			}
+		}
+	}
+
+	for _, stmt := range droppedIndexes {
+		if _, err := db.ExecContext(ctx, stmt); err != nil {
+			var mysqlErr *mysql.MySQLError
+			if !errors.As(err, &mysqlErr) || mysqlErr.Number != errCantDropKey {
+				return err
+			}
+		}
+	}
+
+	for _, stmt := range columns {
+		if _, err := db.ExecContext(ctx, stmt); err != nil {
+			var mysqlErr *mysql.MySQLError
+			if !errors.As(err, &mysqlErr) || mysqlErr.Number != errDupFieldName {
+				return err
+			}
+		}
+	}
+
+	txn, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer txn.Rollback()
+	for _, stmt := range escapeValues {
+		if _, err := txn.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	return txn.Commit()
+}
+
+// createDBIfNotExist connects to the server without selecting a database
+// and creates the database named in the connection string, if missing.
+func createDBIfNotExist(ctx context.Context, dataSourceName string) error {
+	config, err := mysql.ParseDSN(dataSourceName)
+	if err != nil {
+		return err
+	}
+	dbName := config.DBName
+
+	config.DBName = ""
+	db, err := sql.Open("mysql", config.FormatDSN())
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if _, err := db.ExecContext(ctx, fmt.Sprintf("CREATE DATABASE IF NOT EXISTS `%s`", strings.ReplaceAll(dbName, "`", "``"))); err != nil {
+		return fmt.Errorf("failed to create database %s: %w", dbName, err)
+	}
+	return nil
+}
+
+func parseOpts(dsn string, tlsInfo tls.Config) (opts, error) {
+	if dsn == "" {
+		dsn = defaultDSN
+	}
+
+	var options generic.Options
+	if parts := strings.SplitN(dsn, "?", 2); len(parts) == 2 {
+		values, err := url.ParseQuery(parts[1])
+		if err != nil {
+			return opts{}, err
+		}
+		if options, err = generic.ParseOptions(values); err != nil {
+			return opts{}, err
+		}
+		dsn = parts[0]
+		if len(values) > 0 {
+			dsn = fmt.Sprintf("%s?%s", parts[0], values.Encode())
+		}
+	}
+
+	config, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		return opts{}, err
+	}
+	if config.DBName == "" {
+		config.DBName = defaultDB
+	}
+
+	tlsConfig, err := tlsInfo.ClientConfig()
+	if err != nil {
+		return opts{}, err
+	}
+	if tlsConfig != nil {
+		if err := mysql.RegisterTLSConfig(tlsConfigName, tlsConfig); err != nil {
+			return opts{}, err
+		}
+		config.TLSConfig = tlsConfigName
+	}
+
+	return opts{
+		dsn:     config.FormatDSN(),
+		Options: options,
+	}, nil
+}
@@ -0,0 +1,308 @@
+package mysql
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/canonical/k8s-dqlite/pkg/kine/drivers/generic"
+	"github.com/canonical/k8s-dqlite/pkg/kine/server"
+	"github.com/canonical/k8s-dqlite/pkg/kine/tls"
+	"github.com/go-sql-driver/mysql"
+)
+
+// testDSNEnv names the environment variable holding the connection string
+// of the MySQL server used by the integration tests, which are skipped
+// if it is unset.
+const testDSNEnv = "KINE_TEST_MYSQL_DSN"
+
+func TestParseOpts(t *testing.T) {
+	tests := []struct {
+		name            string
+		dsn             string
+		expectedDSN     string
+		compactInterval time.Duration
+		wantErr         bool
+	}{
+		{
+			name:        "empty",
+			expectedDSN: "root@unix(/var/run/mysqld/mysqld.sock)/kubernetes",
+		},
+		{
+			name:        "default database",
+			dsn:         "user:pass@tcp(db:3306)/",
+			expectedDSN: "user:pass@tcp(db:3306)/kubernetes",
+		},
+		{
+			name:        "database",
+			dsn:         "user:pass@tcp(db:3306)/kine",
+			expectedDSN: "user:pass@tcp(db:3306)/kine",
+		},
+		{
+			name:            "kine options",
+			dsn:             "user:pass@tcp(db:3306)/kine?compact-interval=1m&timeout=5s",
+			expectedDSN:     "user:pass@tcp(db:3306)/kine?timeout=5s",
+			compactInterval: time.Minute,
+		},
+		{
+			name:            "only kine options",
+			dsn:             "user:pass@tcp(db:3306)/kine?compact-interval=1m",
+			expectedDSN:     "user:pass@tcp(db:3306)/kine",
+			compactInterval: time.Minute,
+		},
+		{
+			name:    "invalid option",
+			dsn:     "user:pass@tcp(db:3306)/kine?compact-interval=often",
+			wantErr: true,
+		},
+		{
+			name:    "invalid dsn",
+			dsn:     "user:pass@db:3306/kine",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts, err := parseOpts(tt.dsn, tls.Config{})
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if opts.dsn != tt.expectedDSN {
+				t.Errorf("expected dsn %q, got %q", tt.expectedDSN, opts.dsn)
+			}
+			if opts.CompactInterval != tt.compactInterval {
+				t.Errorf("expected compact interval %v, got %v", tt.compactInterval, opts.CompactInterval)
+			}
+		})
+	}
+}
+
+func TestParseOptsTLS(t *testing.T) {
+	caFile := writeTestCA(t)
+
+	opts, err := parseOpts("user:pass@tcp(db:3306)/kine", tls.Config{CAFile: caFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Parsing the DSN fails if the TLS configuration is not registered.
+	config, err := mysql.ParseDSN(opts.dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.TLSConfig != tlsConfigName {
+		t.Errorf("expected TLS configuration %q, got %q", tlsConfigName, config.TLSConfig)
+	}
+
+	if _, err := parseOpts("user:pass@tcp(db:3306)/kine", tls.Config{CAFile: filepath.Join(t.TempDir(), "missing.crt")}); err == nil {
+		t.Error("expected error for missing CA file")
+	}
+}
+
+func TestConfigureDialect(t *testing.T) {
+	dialect := &generic.Generic{}
+	configureDialect(dialect)
+
+	tests := []struct {
+		name     string
+		query    string
+		params   int
+		contains []string
+	}{
+		{
+			name:   "create",
+			query:  dialect.CreateSQL,
+			params: 4,
+		},
+		{
+			name:   "compact",
+			query:  dialect.CompactSQL,
+			params: 2,
+			// MySQL can't delete from a table it selects from, so the
+			// deleted rows are joined instead.
+			contains: []string{"DELETE kv FROM kine AS kv", "INNER JOIN", "ON kv.id = ks.id"},
+		},
+		{
+			name:   "dedup old values",
+			query:  dialect.DedupOldValuesSQL,
+			params: 2,
+			// Nor update a table it selects from.
+			contains: []string{"UPDATE kine AS kv", "INNER JOIN", "ON pkv.id = kv.prev_revision"},
+		},
+		{
+			name:  "get size",
+			query: dialect.GetSizeSQL,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if strings.Contains(tt.query, "$") {
+				t.Errorf("expected positional placeholders only, got %q", tt.query)
+			}
+			if params := strings.Count(tt.query, "?"); params != tt.params {
+				t.Errorf("expected %d parameters, got %d", tt.params, params)
+			}
+			for _, s := range tt.contains {
+				if !strings.Contains(tt.query, s) {
+					t.Errorf("expected %q in %q", s, tt.query)
+				}
+			}
+		})
+	}
+}
+
+func TestErrors(t *testing.T) {
+	dialect := &generic.Generic{}
+	configureDialect(dialect)
+
+	otherErr := errors.New("connection refused")
+	tests := []struct {
+		name       string
+		err        error
+		retry      bool
+		translated error
+		code       string
+	}{
+		{
+			name: "nil",
+		},
+		{
+			name:       "duplicate entry",
+			err:        &mysql.MySQLError{Number: errDupEntry},
+			translated: server.ErrKeyExists,
+			code:       "1062",
+		},
+		{
+			name:       "wrapped duplicate entry",
+			err:        fmt.Errorf("insert: %w", &mysql.MySQLError{Number: errDupEntry}),
+			translated: server.ErrKeyExists,
+			code:       "1062",
+		},
+		{
+			name:  "deadlock",
+			err:   &mysql.MySQLError{Number: errLockDeadlock},
+			retry: true,
+			code:  "1213",
+		},
+		{
+			name:  "lock wait timeout",
+			err:   &mysql.MySQLError{Number: errLockWaitTimeout},
+			retry: true,
+			code:  "1205",
+		},
+		{
+			name: "other mysql error",
+			err:  &mysql.MySQLError{Number: errDupKeyName},
+			code: "1061",
+		},
+		{
+			name: "other error",
+			err:  otherErr,
+			code: otherErr.Error(),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.err != nil {
+				if retry := dialect.Retry(tt.err); retry != tt.retry {
+					t.Errorf("expected retry %v, got %v", tt.retry, retry)
+				}
+			}
+
+			expected := tt.translated
+			if expected == nil {
+				expected = tt.err
+			}
+			if translated := dialect.TranslateErr(tt.err); translated != expected {
+				t.Errorf("expected translated error %v, got %v", expected, translated)
+			}
+
+			if code := dialect.ErrCode(tt.err); code != tt.code {
+				t.Errorf("expected code %q, got %q", tt.code, code)
+			}
+		})
+	}
+}
+
+func TestBackend(t *testing.T) {
+	dsn := os.Getenv(testDSNEnv)
+	if dsn == "" {
+		t.Skipf("%s is not set", testDSNEnv)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	backend, err := New(ctx, dsn, tls.Config{}, &generic.ConnectionPoolConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := backend.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	key := fmt.Sprintf("/mysql-test/%d", time.Now().UnixNano())
+	createRev, created, err := backend.Create(ctx, key, []byte("v1"), 0)
+	if err != nil || !created {
+		t.Fatalf("failed to create %s: %v", key, err)
+	}
+	updateRev, updated, err := backend.Update(ctx, key, []byte("v2"), createRev, 0)
+	if err != nil || !updated {
+		t.Fatalf("failed to update %s: %v", key, err)
+	}
+	if _, deleted, err := backend.Delete(ctx, key, updateRev); err != nil || !deleted {
+		t.Fatalf("failed to delete %s: %v", key, err)
+	}
+	// Compaction runs the DELETE ... JOIN statement.
+	if err := backend.DoCompact(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// writeTestCA writes a self-signed CA certificate and returns its path.
+func writeTestCA(t *testing.T) string {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "kine-test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "ca.crt")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
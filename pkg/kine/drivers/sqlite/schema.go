@@ -3,7 +3,9 @@ package sqlite
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"time"
 )
 
 // The database version that designates whether table migration
@@ -15,10 +17,54 @@ import (
 
 type SchemaVersion int32
 
+// migration moves the schema to version.
+type migration struct {
+	version SchemaVersion
+	// compatible is the oldest schema version supported by the binaries
+	// that can still use the database once it is migrated, so that the
+	// migrations can roll out across clusters running mixed versions. The
+	// older binaries refuse to start on the database.
+	compatible SchemaVersion
+	apply      func(ctx context.Context, txn *sql.Tx) error
+}
+
 var (
-	databaseSchemaVersion = NewSchemaVersion(0, 1)
+	// migrations are the migrations of the schema, in order.
+	migrations = []migration{
+		{version: NewSchemaVersion(0, 1), apply: applySchemaV0_1},
+		{version: NewSchemaVersion(0, 2), apply: applySchemaV0_2},
+		{version: NewSchemaVersion(0, 3), apply: applySchemaV0_3},
+		{version: NewSchemaVersion(0, 4), apply: applySchemaV0_4},
+		{version: NewSchemaVersion(0, 5), apply: applySchemaV0_5},
+		{version: NewSchemaVersion(0, 6), apply: applySchemaV0_6},
+		{version: NewSchemaVersion(0, 7), apply: applySchemaV0_7},
+		// The older versions would recreate the compaction marker in the
+		// kine table, and compact again from the first revision.
+		{version: NewSchemaVersion(0, 8), compatible: NewSchemaVersion(0, 8), apply: applySchemaV0_8},
+		// The older versions can use the database as long as no value is
+		// stored in chunks.
+		{version: NewSchemaVersion(0, 9), apply: applySchemaV0_9},
+		// The versions older than 0.9 would read the escaped values with
+		// their escape byte.
+		{version: EscapedSchemaVersion, compatible: NewSchemaVersion(0, 9), apply: applySchemaV0_10},
+		// The older versions can use the database as long as the events
+		// are not stored apart.
+		{version: NewSchemaVersion(0, 11), apply: applySchemaV0_11},
+	}
+
+	databaseSchemaVersion = migrations[len(migrations)-1].version
 )
 
+// EscapedSchemaVersion is the first schema version whose raw values starting
+// with a marker byte of the compression package are escaped, as written by
+// compression.Escape.
+var EscapedSchemaVersion = NewSchemaVersion(0, 10)
+
+// ErrIncompatibleSchema is returned when the schema of the database can't
+// be used by this version, such as after a downgrade past a migration that
+// older versions don't support.
+var ErrIncompatibleSchema = errors.New("incompatible database schema")
+
 func NewSchemaVersion(major int16, minor int16) SchemaVersion {
 	return SchemaVersion(int32(major)<<16 | int32(minor))
 }
@@ -33,6 +79,17 @@ func (sv SchemaVersion) Minor() int16 {
 	return int16(sv)
 }
 
+// String returns the version as major.minor.
+func (sv SchemaVersion) String() string {
+	return fmt.Sprintf("%d.%d", sv.Major(), sv.Minor())
+}
+
+// DatabaseSchemaVersion returns the schema version the databases are
+// migrated to on startup.
+func DatabaseSchemaVersion() SchemaVersion {
+	return databaseSchemaVersion
+}
+
 func (sv SchemaVersion) CompatibleWith(targetSV SchemaVersion) error {
 	// Major version must be the same
 	if sv.Major() != targetSV.Major() {
@@ -89,8 +146,369 @@ CREATE TABLE kine
 	return nil
 }
 
+// applySchemaV0_2 moves the schema from version 1 to version 2,
+// adding the table of the leases granted through the lease API.
+func applySchemaV0_2(ctx context.Context, txn *sql.Tx) error {
+	createTableSQL := `
+CREATE TABLE IF NOT EXISTS kine_leases
+(
+	id INTEGER PRIMARY KEY,
+	ttl INTEGER NOT NULL
+)`
+	_, err := txn.ExecContext(ctx, createTableSQL)
+	return err
+}
+
+// applySchemaV0_3 moves the schema from version 2 to version 3,
+// adding the checkpointed expiry of the leases, as a unix time in
+// milliseconds.
+func applySchemaV0_3(ctx context.Context, txn *sql.Tx) error {
+	_, err := txn.ExecContext(ctx, `ALTER TABLE kine_leases ADD COLUMN expiry INTEGER`)
+	return err
+}
+
+// applySchemaV0_4 moves the schema from version 3 to version 4,
+// adding the table of the alarms raised on the cluster.
+func applySchemaV0_4(ctx context.Context, txn *sql.Tx) error {
+	createTableSQL := `
+CREATE TABLE IF NOT EXISTS kine_alarms
+(
+	alarm INTEGER PRIMARY KEY
+)`
+	_, err := txn.ExecContext(ctx, createTableSQL)
+	return err
+}
+
+// keyPrefixSQL returns the expression of the prefix a key is counted under
+// in kine_key_counts, given the expression of its name: the name up to its
+// last slash, the last character aside. The keys counted under the prefixes
+// starting with a prefix ending with a slash are then the keys under it,
+// the key named after the prefix aside.
+func keyPrefixSQL(name string) string {
+	parent := fmt.Sprintf("substr(%s, 1, length(%s) - 1)", name, name)
+	return fmt.Sprintf("rtrim(%s, replace(%s, '/', ''))", parent, parent)
+}
+
+// applySchemaV0_5 moves the schema from version 4 to version 5, adding the
+// number of current keys by prefix, which a trigger maintains as the rows
+// are inserted, so that the keys under a prefix are counted without being
+// scanned.
+func applySchemaV0_5(ctx context.Context, txn *sql.Tx) error {
+	createTableSQL := `
+CREATE TABLE IF NOT EXISTS kine_key_counts
+(
+	prefix TEXT PRIMARY KEY,
+	count INTEGER NOT NULL
+)`
+	if _, err := txn.ExecContext(ctx, createTableSQL); err != nil {
+		return err
+	}
+
+	if _, err := txn.ExecContext(ctx, keyCountsTriggerSQL("kine_key_counts_insert", "kine")); err != nil {
+		return err
+	}
+
+	_, err := txn.ExecContext(ctx, countKeysSQL("kine"))
+	return err
+}
+
+// keyCountsTriggerSQL returns the trigger named trigger counting the keys
+// of the rows inserted into table.
+func keyCountsTriggerSQL(trigger, table string) string {
+	// The count of a prefix changes when a row makes a key current or
+	// deleted, which its previous row tells, rather than its created flag,
+	// so that rows can be inserted after their history is compacted, as
+	// snapshots are restored.
+	return fmt.Sprintf(`
+CREATE TRIGGER IF NOT EXISTS %[2]s AFTER INSERT ON %[3]s
+BEGIN
+	INSERT INTO kine_key_counts(prefix, count)
+	SELECT prefix, delta
+	FROM (
+		SELECT %[1]s AS prefix, (NEW.deleted = 0) - COALESCE((
+			SELECT deleted = 0
+			FROM %[3]s
+			WHERE name = NEW.name AND id < NEW.id
+			ORDER BY id DESC
+			LIMIT 1
+		), 0) AS delta
+	)
+	WHERE delta != 0
+	ON CONFLICT(prefix) DO UPDATE SET count = count + excluded.count;
+
+	DELETE FROM kine_key_counts
+	WHERE prefix = %[1]s AND count = 0;
+END`, keyPrefixSQL("NEW.name"), trigger, table)
+}
+
+// countKeysSQL returns the query counting the current keys of the rows of
+// table into kine_key_counts.
+func countKeysSQL(table string) string {
+	return fmt.Sprintf(`
+INSERT INTO kine_key_counts(prefix, count)
+SELECT %[1]s, COUNT(*)
+FROM %[2]s AS kv
+JOIN (
+	SELECT MAX(id) AS id
+	FROM %[2]s
+	GROUP BY name
+) AS maxkv
+	ON maxkv.id = kv.id
+WHERE kv.deleted = 0
+GROUP BY 1`, keyPrefixSQL("kv.name"), table)
+}
+
+// applySchemaV0_6 moves the schema from version 5 to version 6, replacing
+// the index of the revisions by name with one that includes the deleted
+// flag, so that the latest revision of a key, and whether it is deleted,
+// are looked up from the index alone. The queries by revision use the
+// primary key, and the lookups by previous revision the unique index.
+func applySchemaV0_6(ctx context.Context, txn *sql.Tx) error {
+	if _, err := txn.ExecContext(ctx, `CREATE INDEX IF NOT EXISTS kine_name_id_deleted_index ON kine (name, id, deleted)`); err != nil {
+		return err
+	}
+	_, err := txn.ExecContext(ctx, `DROP INDEX IF EXISTS kine_name_index`)
+	return err
+}
+
+// applySchemaV0_7 moves the schema from version 6 to version 7, adding the
+// table of the migrations applied to the database, along with the oldest
+// schema version supported by the binaries that can still use it.
+func applySchemaV0_7(ctx context.Context, txn *sql.Tx) error {
+	createTableSQL := `
+CREATE TABLE IF NOT EXISTS kine_schema_version
+(
+	version INTEGER PRIMARY KEY,
+	compatible INTEGER NOT NULL,
+	applied INTEGER NOT NULL
+)`
+	_, err := txn.ExecContext(ctx, createTableSQL)
+	return err
+}
+
+// applySchemaV0_8 moves the schema from version 7 to version 8, moving the
+// compact revision from the prev_revision of the compact_rev_key rows of the
+// kine table to the kine_metadata table, so that it is no longer listed
+// with the keys.
+func applySchemaV0_8(ctx context.Context, txn *sql.Tx) error {
+	for _, stmt := range []string{`
+CREATE TABLE IF NOT EXISTS kine_metadata
+(
+	name TEXT PRIMARY KEY,
+	value INTEGER NOT NULL
+)`, `
+INSERT OR IGNORE INTO kine_metadata(name, value)
+SELECT 'compact_revision', COALESCE(MAX(prev_revision), 0)
+FROM kine
+WHERE name = 'compact_rev_key'`, `
+DELETE FROM kine
+WHERE name = 'compact_rev_key'`,
+	} {
+		if _, err := txn.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applySchemaV0_9 moves the schema from version 8 to version 9, adding the
+// table of the chunks of the values stored in chunks. The first chunk of a
+// value has a blob of 0, and its id identifies the value, which the next
+// chunks have as their blob. The chunks are deleted along with the last row
+// of the kine table of their key referencing them.
+func applySchemaV0_9(ctx context.Context, txn *sql.Tx) error {
+	for _, stmt := range []string{`
+CREATE TABLE IF NOT EXISTS kine_chunks
+(
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	blob INTEGER NOT NULL,
+	name TEXT NOT NULL,
+	data BLOB NOT NULL
+)`,
+		`CREATE INDEX IF NOT EXISTS kine_chunks_blob_index ON kine_chunks (blob, id)`,
+		chunksDeleteTriggerSQL("kine_chunks_delete", "kine", "kine"),
+	} {
+		if _, err := txn.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applySchemaV0_10 moves the schema from version 9 to version 10, escaping
+// the raw values starting with a marker byte of the compression package, so
+// that they are no longer taken for compressed values or chunk references.
+// No release compressed the values, nor stored them in chunks, before this
+// version.
+func applySchemaV0_10(ctx context.Context, txn *sql.Tx) error {
+	for _, column := range []string{"value", "old_value"} {
+		if _, err := txn.ExecContext(ctx, fmt.Sprintf(`
+UPDATE kine
+SET %[1]s = CAST(X'F7' || %[1]s AS BLOB)
+WHERE substr(%[1]s, 1, 1) BETWEEN X'F5' AND X'F8'`, column)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applySchemaV0_11 moves the schema from version 10 to version 11, adding
+// the kine_events table, which stores the keys under the events prefix when
+// they are stored apart, and the kine_all view of the rows of both tables.
+// Both tables share the revisions: the triggers inserting the rows keep
+// their sequences in step, so that the ids are allocated past the rows of
+// either table. The events table has a compact revision of its own, as it is
+// compacted on a schedule of its own.
+func applySchemaV0_11(ctx context.Context, txn *sql.Tx) error {
+	for _, stmt := range []string{`
+CREATE TABLE IF NOT EXISTS kine_events
+(
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	name TEXT NOT NULL,
+	created INTEGER,
+	deleted INTEGER,
+	create_revision INTEGER NOT NULL,
+	prev_revision INTEGER,
+	lease INTEGER,
+	value BLOB,
+	old_value BLOB
+)`,
+		`CREATE INDEX IF NOT EXISTS kine_events_name_id_deleted_index ON kine_events (name, id, deleted)`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS kine_events_name_prev_revision_uindex ON kine_events (prev_revision, name)`,
+		fmt.Sprintf(`
+CREATE VIEW IF NOT EXISTS kine_all AS
+SELECT %[1]s FROM kine
+UNION ALL
+SELECT %[1]s FROM kine_events`, kineColumns),
+		sequenceTriggerSQL("kine_sequence_insert", "kine", "kine_events"),
+		sequenceTriggerSQL("kine_events_sequence_insert", "kine_events", "kine"),
+		`
+INSERT INTO sqlite_sequence(name, seq)
+SELECT 'kine_events', seq
+FROM sqlite_sequence
+WHERE name = 'kine' AND NOT EXISTS (
+	SELECT 1
+	FROM sqlite_sequence
+	WHERE name = 'kine_events'
+)`,
+		keyCountsTriggerSQL("kine_events_key_counts_insert", "kine_events"),
+		// A value stored in chunks is referenced by the rows of both
+		// tables while they are moved from one to the other.
+		`DROP TRIGGER IF EXISTS kine_chunks_delete`,
+		chunksDeleteTriggerSQL("kine_chunks_delete", "kine", "kine_all"),
+		chunksDeleteTriggerSQL("kine_events_chunks_delete", "kine_events", "kine_all"),
+		`
+INSERT OR IGNORE INTO kine_metadata(name, value)
+SELECT 'events_compact_revision', value
+FROM kine_metadata
+WHERE name = 'compact_revision'`,
+	} {
+		if _, err := txn.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// kineColumns are the columns of the kine and kine_events tables.
+const kineColumns = `id, name, created, deleted, create_revision, prev_revision, lease, value, old_value`
+
+// sequenceTriggerSQL returns the trigger named trigger moving the sequence
+// of other past the ids of the rows inserted into table.
+func sequenceTriggerSQL(trigger, table, other string) string {
+	return fmt.Sprintf(`
+CREATE TRIGGER IF NOT EXISTS %[1]s AFTER INSERT ON %[2]s
+BEGIN
+	UPDATE sqlite_sequence
+	SET seq = NEW.id
+	WHERE name = '%[3]s' AND seq < NEW.id;
+
+	INSERT INTO sqlite_sequence(name, seq)
+	SELECT '%[3]s', NEW.id
+	WHERE NOT EXISTS (
+		SELECT 1
+		FROM sqlite_sequence
+		WHERE name = '%[3]s'
+	);
+END`, trigger, table, other)
+}
+
+// chunksDeleteTriggerSQL returns the trigger named trigger deleting the
+// chunks of the values of the rows deleted from table, once no row of rows
+// references them.
+func chunksDeleteTriggerSQL(trigger, table, rows string) string {
+	return fmt.Sprintf(`
+CREATE TRIGGER IF NOT EXISTS %[4]s AFTER DELETE ON %[5]s
+WHEN substr(OLD.value, 1, 1) = X'F8' OR substr(OLD.old_value, 1, 1) = X'F8'
+BEGIN
+	DELETE FROM kine_chunks
+	WHERE (id IN (%[1]s, %[2]s) OR blob IN (%[1]s, %[2]s))
+		AND kine_chunks.name = OLD.name
+		AND NOT EXISTS (
+			SELECT 1
+			FROM %[6]s AS kv
+			WHERE kv.name = OLD.name AND (kv.value = %[3]s OR kv.old_value = %[3]s)
+		);
+END`, chunkIDSQL("OLD.value"), chunkIDSQL("OLD.old_value"), chunkReferenceSQL, trigger, table, rows)
+}
+
+// chunkReferenceSQL is the reference to its value stored in the kine table
+// in place of the value of a row of the kine_chunks table, as written by
+// compression.ChunkReference.
+const chunkReferenceSQL = `CAST(X'F8' || CASE kine_chunks.blob WHEN 0 THEN kine_chunks.id ELSE kine_chunks.blob END AS BLOB)`
+
+// chunkIDSQL returns the expression of the id of the first chunk of the value
+// of column, or NULL if it is not stored in chunks.
+func chunkIDSQL(column string) string {
+	return fmt.Sprintf(`CASE WHEN substr(%[1]s, 1, 1) = X'F8' THEN CAST(CAST(substr(%[1]s, 2) AS TEXT) AS INTEGER) END`, column)
+}
+
+// recordMigrations records the migrations applied to the database, once
+// the table of the migrations exists.
+func recordMigrations(ctx context.Context, txn *sql.Tx, applied []migration) error {
+	if exists, err := hasTable(ctx, txn, "kine_schema_version"); err != nil || !exists {
+		return err
+	}
+	now := time.Now().Unix()
+	for _, m := range applied {
+		if _, err := txn.ExecContext(ctx, `INSERT OR REPLACE INTO kine_schema_version(version, compatible, applied) VALUES(?, ?, ?)`, m.version, m.compatible, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkCompatible fails with ErrIncompatibleSchema if the database, at
+// schema version current, can't be used by this version: the migrations
+// applied to it must all be compatible with the schema of this version.
+func checkCompatible(ctx context.Context, q queryRower, current SchemaVersion) error {
+	if err := current.CompatibleWith(databaseSchemaVersion); err != nil {
+		return fmt.Errorf("%w: %v", ErrIncompatibleSchema, err)
+	}
+	if current <= databaseSchemaVersion {
+		return nil
+	}
+	if exists, err := hasTable(ctx, q, "kine_schema_version"); err != nil || !exists {
+		return err
+	}
+	var compatible SchemaVersion
+	if err := q.QueryRowContext(ctx, `SELECT COALESCE(MAX(compatible), 0) FROM kine_schema_version`).Scan(&compatible); err != nil {
+		return err
+	}
+	if compatible > databaseSchemaVersion {
+		return fmt.Errorf("%w: database schema %v requires a version supporting schema %v, this version supports schema %v", ErrIncompatibleSchema, current, compatible, databaseSchemaVersion)
+	}
+	return nil
+}
+
+// queryRower runs queries returning a single row, as databases and
+// transactions do.
+type queryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
 // hasTable checks if a table exists.
-func hasTable(ctx context.Context, txn *sql.Tx, tableName string) (bool, error) {
+func hasTable(ctx context.Context, txn queryRower, tableName string) (bool, error) {
 	// FIXME: why we can't use `pragma_table_list()`? Is dqlite/sqlite using
 	// a very old sqlite version? `pragma_free_list()` works though...
 	tableListSQL := `SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?`
@@ -7,24 +7,33 @@ import (
 	"net/url"
 	"os"
 	"strings"
+	"sync/atomic"
 	"time"
 
+	"github.com/canonical/k8s-dqlite/pkg/kine/drivers"
 	"github.com/canonical/k8s-dqlite/pkg/kine/drivers/generic"
 	"github.com/canonical/k8s-dqlite/pkg/kine/logstructured"
 	"github.com/canonical/k8s-dqlite/pkg/kine/logstructured/sqllog"
 	"github.com/canonical/k8s-dqlite/pkg/kine/server"
+	"github.com/canonical/k8s-dqlite/pkg/logging"
 	"github.com/mattn/go-sqlite3"
 	"github.com/pkg/errors"
-	"github.com/sirupsen/logrus"
 )
 
+var logger = logging.Component(logging.Driver)
+
 type opts struct {
 	dsn        string
 	driverName string // If not empty, use a pre-registered dqlite driver
 
-	compactInterval   time.Duration
-	pollInterval      time.Duration
-	watchQueryTimeout time.Duration
+	generic.Options
+}
+
+func init() {
+	drivers.Register("sqlite", func(ctx context.Context, cfg *drivers.Config) (bool, server.Backend, error) {
+		backend, err := New(ctx, cfg.DataSourceName, cfg.ConnectionPoolConfig)
+		return false, backend, err
+	})
 }
 
 func New(ctx context.Context, dataSourceName string, connectionPoolConfig *generic.ConnectionPoolConfig) (server.Backend, error) {
@@ -51,7 +60,7 @@ func NewVariant(ctx context.Context, driverName, dataSourceName string, connecti
 		}
 		driverName = opts.driverName
 	}
-	logrus.Printf("DriverName is %s.", driverName)
+	logger.Printf("DriverName is %s.", driverName)
 
 	if opts.dsn == "" {
 		if err := os.MkdirAll("./db", 0700); err != nil {
@@ -60,16 +69,49 @@ func NewVariant(ctx context.Context, driverName, dataSourceName string, connecti
 		opts.dsn = "./db/state.db?_journal=WAL&_synchronous=FULL&_foreign_keys=1"
 	}
 
-	dialect, err := generic.Open(ctx, driverName, opts.dsn, connectionPoolConfig, "?", false)
+	openDriverName := driverName
+	var commits chan struct{}
+	if driverName == "sqlite3" {
+		commits = make(chan struct{}, 1)
+		openDriverName = registerCommitNotifyDriver(commits)
+	}
+	dialect, err := generic.Open(ctx, openDriverName, opts.dsn, connectionPoolConfig, "?", false)
 	if err != nil {
 		return nil, nil, err
 	}
+	if commits != nil {
+		dialect.CommitNotify = commits
+	}
+
+	// Incremental vacuums, scheduled or requested through Defragment, only
+	// release the free pages, which requires the incremental auto_vacuum
+	// mode.
+	wantIncrementalVacuum := opts.VacuumMode == generic.VacuumIncremental
+	incrementalVacuum := false
+	setup := func() (err error) {
+		if wantIncrementalVacuum {
+			// Only local databases, or new ones, can be fully vacuumed for
+			// the mode to apply, as the whole database would be rewritten
+			// through raft otherwise.
+			incrementalVacuum, err = enableIncrementalVacuum(ctx, dialect.DB.Underlying(), driverName == "sqlite3")
+			if err != nil {
+				return err
+			}
+		}
+		if err := Setup(ctx, dialect.DB.Underlying()); err != nil {
+			return err
+		}
+		return routeEvents(ctx, dialect.DB.Underlying(), opts.EventsPrefix)
+	}
 	for i := 0; i < retryAttempts; i++ {
-		err = setup(ctx, dialect.DB.Underlying())
+		err = setup()
 		if err == nil {
 			break
+		} else if errors.Is(err, ErrIncompatibleSchema) {
+			dialect.Close()
+			return nil, nil, err
 		}
-		logrus.Errorf("failed to setup db: %v", err)
+		logger.Errorf("failed to setup db: %v", err)
 		select {
 		case <-ctx.Done():
 			return nil, nil, ctx.Err()
@@ -77,18 +119,71 @@ func NewVariant(ctx context.Context, driverName, dataSourceName string, connecti
 		}
 		time.Sleep(time.Second)
 	}
+	if err == nil && wantIncrementalVacuum && !incrementalVacuum {
+		dialect.Close()
+		return nil, nil, errors.New("incremental vacuums require incremental auto_vacuum, which can only be enabled on new dqlite databases: use the full vacuum mode instead")
+	}
+
+	if driverName == "sqlite3" {
+		// Writes go through a connection of their own, so that they
+		// are serialized rather than failing with busy errors, and
+		// take the database lock up front with BEGIN IMMEDIATE.
+		if err := dialect.OpenWriteDB(openDriverName, withQuery(opts.dsn, "_txlock=immediate")); err != nil {
+			dialect.Close()
+			return nil, nil, err
+		}
+	}
 
 	dialect.TranslateErr = func(err error) error {
-		if err, ok := err.(sqlite3.Error); ok && err.ExtendedCode == sqlite3.ErrConstraintUnique {
+		var sqliteErr sqlite3.Error
+		if errors.As(err, &sqliteErr) && (sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique || sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey) {
 			return server.ErrKeyExists
 		}
 		return err
 	}
 	dialect.GetSizeSQL = `SELECT (page_count - freelist_count) * page_size FROM pragma_page_count(), pragma_page_size(), pragma_freelist_count()`
 
-	dialect.CompactInterval = opts.compactInterval
-	dialect.PollInterval = opts.pollInterval
-	dialect.WatchQueryTimeout = opts.watchQueryTimeout
+	dialect.PageStatsSQL = `SELECT page_count, freelist_count, page_size FROM pragma_page_count(), pragma_freelist_count(), pragma_page_size()`
+
+	dialect.IntegrityCheckSQL = `PRAGMA integrity_check`
+
+	// SQLite compares the names as bytes, so the fill rows are also matched
+	// as a range of names, which uses their index.
+	dialect.SetFillNameSQL(`name >= 'gap-' AND name < 'gap.' AND name = 'gap-' || id`)
+
+	dialect.CountPrefixSQL = fmt.Sprintf(`
+		SELECT (%s), COALESCE(SUM(count), 0)
+		FROM kine_key_counts
+		WHERE prefix >= ? AND prefix < ?`, dialect.RevisionSQL)
+
+	dialect.UpdateEventsCompactSQL = `
+		UPDATE kine_metadata
+		SET value = ?
+		WHERE name = 'events_compact_revision' AND value < ?`
+
+	dialect.InsertChunkSQL = `INSERT INTO kine_chunks(blob, name, data) VALUES(?, ?, ?)`
+	dialect.ReadChunksSQL = `SELECT data FROM kine_chunks WHERE (id = ? OR blob = ?) AND name = ? ORDER BY id`
+	dialect.DeleteChunksSQL = `DELETE FROM kine_chunks WHERE (id = ? OR blob = ?) AND name = ?`
+	dialect.MaxChunkIDSQL = `SELECT COALESCE(MAX(id), 0) FROM kine_chunks`
+	dialect.SweepChunksSQL = fmt.Sprintf(`
+		DELETE FROM kine_chunks
+		WHERE id > ? AND id <= ? AND NOT EXISTS (
+			SELECT 1
+			FROM kine
+			WHERE kine.name = kine_chunks.name AND (kine.value = %[1]s OR kine.old_value = %[1]s)
+		)`, chunkReferenceSQL)
+
+	dialect.ApplyOptions(opts.Options)
+
+	dialect.VacuumSQL = `VACUUM`
+	if incrementalVacuum {
+		dialect.IncrementalVacuumSQL = `PRAGMA incremental_vacuum`
+		dialect.VacuumSQL = dialect.IncrementalVacuumSQL
+	}
+	if driverName == "sqlite3" {
+		dialect.CheckpointSQL = `PRAGMA wal_checkpoint(TRUNCATE)`
+		dialect.BackupSQL = `VACUUM INTO ?`
+	}
 
 	if driverName == "sqlite3" {
 		dialect.Retry = func(err error) bool {
@@ -102,11 +197,42 @@ func NewVariant(ctx context.Context, driverName, dataSourceName string, connecti
 	return logstructured.New(sqllog.New(dialect)), dialect, nil
 }
 
-// setup performs table setup, which may include creation of the Kine table if
+// withQuery appends a query parameter to a data source name.
+func withQuery(dsn, param string) string {
+	if strings.Contains(dsn, "?") {
+		return dsn + "&" + param
+	}
+	return dsn + "?" + param
+}
+
+var commitNotifyDrivers int64
+
+// registerCommitNotifyDriver registers a SQLite driver whose connections
+// notify commits on the channel, and returns its name. Each database needs
+// its own driver, as hooks are set when connections are opened.
+func registerCommitNotifyDriver(commits chan<- struct{}) string {
+	name := fmt.Sprintf("sqlite3-notify-%d", atomic.AddInt64(&commitNotifyDrivers, 1))
+	sql.Register(name, &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			conn.RegisterCommitHook(func() int {
+				select {
+				case commits <- struct{}{}:
+				default:
+				}
+				// Zero lets the commit proceed.
+				return 0
+			})
+			return nil
+		},
+	})
+	return name
+}
+
+// Setup performs table setup, which may include creation of the Kine table if
 // it doesn't already exist, migrating key_value table contents to the Kine
 // table if the key_value table exists, all in a single database transaction.
 // changes are rolled back if an error occurs.
-func setup(ctx context.Context, db *sql.DB) error {
+func Setup(ctx context.Context, db *sql.DB) error {
 	// Optimistically ask for the user_version without starting a transaction
 	var currentSchemaVersion SchemaVersion
 
@@ -115,10 +241,14 @@ func setup(ctx context.Context, db *sql.DB) error {
 		return err
 	}
 
-	if err := currentSchemaVersion.CompatibleWith(databaseSchemaVersion); err != nil {
+	if err := checkCompatible(ctx, db, currentSchemaVersion); err != nil {
 		return err
 	}
-	if currentSchemaVersion >= databaseSchemaVersion {
+	if currentSchemaVersion > databaseSchemaVersion {
+		logger.Warningf("Database schema %v is newer than schema %v of this version, it is used as compatible", currentSchemaVersion, databaseSchemaVersion)
+		return nil
+	}
+	if currentSchemaVersion == databaseSchemaVersion {
 		return nil
 	}
 
@@ -135,8 +265,104 @@ func setup(ctx context.Context, db *sql.DB) error {
 	return txn.Commit()
 }
 
-// migrate tries to migrate from a version of the database
-// to the target one.
+// routeEvents moves the rows of the keys under prefix to the kine_events
+// table, and the rows of the other keys back to the kine table, as the events
+// are stored apart or not. With an empty prefix, all the rows are moved back
+// to the kine table. The keys are counted again once rows are moved, as the
+// counts are kept as the rows are inserted, not deleted.
+func routeEvents(ctx context.Context, db *sql.DB, prefix string) error {
+	start, end := prefix, prefix
+	if prefix != "" {
+		end = strings.TrimSuffix(prefix, "/") + "0"
+	}
+
+	var misplaced bool
+	row := db.QueryRowContext(ctx, `
+		SELECT EXISTS (
+			SELECT 1
+			FROM kine
+			WHERE name >= ? AND name < ?
+		) OR EXISTS (
+			SELECT 1
+			FROM kine_events
+			WHERE name < ? OR name >= ?
+		)`, start, end, start, end)
+	if err := row.Scan(&misplaced); err != nil || !misplaced {
+		return err
+	}
+	logger.Infof("Moving the rows between the kine and kine_events tables for the events prefix %q", prefix)
+
+	txn, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer txn.Rollback()
+
+	// The rows are copied before they are deleted, so that the chunks of
+	// their values are kept.
+	for _, stmt := range []string{
+		fmt.Sprintf(`INSERT INTO kine_events(%[1]s) SELECT %[1]s FROM kine WHERE name >= ? AND name < ? ORDER BY id`, kineColumns),
+		`DELETE FROM kine WHERE name >= ? AND name < ?`,
+		fmt.Sprintf(`INSERT INTO kine(%[1]s) SELECT %[1]s FROM kine_events WHERE name < ? OR name >= ? ORDER BY id`, kineColumns),
+		`DELETE FROM kine_events WHERE name < ? OR name >= ?`,
+	} {
+		if _, err := txn.ExecContext(ctx, stmt, start, end); err != nil {
+			return err
+		}
+	}
+	if _, err := txn.ExecContext(ctx, `DELETE FROM kine_key_counts`); err != nil {
+		return err
+	}
+	if _, err := txn.ExecContext(ctx, countKeysSQL("kine_all")); err != nil {
+		return err
+	}
+	return txn.Commit()
+}
+
+// enableIncrementalVacuum switches the database to incremental auto_vacuum,
+// reporting whether it is enabled. The new mode is only applied by a full
+// vacuum, which must run on the same connection that set it. Unless fullVacuum
+// is set, the mode is only switched on new databases, as they are cheap to
+// vacuum.
+func enableIncrementalVacuum(ctx context.Context, db *sql.DB, fullVacuum bool) (bool, error) {
+	const autoVacuumIncremental = 2
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	var mode int
+	if err := conn.QueryRowContext(ctx, `PRAGMA auto_vacuum`).Scan(&mode); err != nil {
+		return false, err
+	}
+	if mode == autoVacuumIncremental {
+		return true, nil
+	}
+
+	var tables int
+	if err := conn.QueryRowContext(ctx, `SELECT COUNT(*) FROM sqlite_master`).Scan(&tables); err != nil {
+		return false, err
+	}
+	if tables > 0 {
+		if !fullVacuum {
+			return false, nil
+		}
+		logger.Info("Enabling incremental auto_vacuum, this requires a full vacuum of the database")
+	}
+
+	if _, err := conn.ExecContext(ctx, `PRAGMA auto_vacuum = INCREMENTAL`); err != nil {
+		return false, err
+	}
+	if _, err := conn.ExecContext(ctx, `VACUUM`); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// migrate applies the migrations of the schema that are missing from the
+// database, in order, and records them.
 func migrate(ctx context.Context, txn *sql.Tx) error {
 	var currentSchemaVersion SchemaVersion
 
@@ -145,20 +371,25 @@ func migrate(ctx context.Context, txn *sql.Tx) error {
 		return err
 	}
 
-	if err := currentSchemaVersion.CompatibleWith(databaseSchemaVersion); err != nil {
+	if err := checkCompatible(ctx, txn, currentSchemaVersion); err != nil {
 		return err
 	}
 	if currentSchemaVersion >= databaseSchemaVersion {
 		return nil
 	}
 
-	switch currentSchemaVersion {
-	case NewSchemaVersion(0, 0):
-		if err := applySchemaV0_1(ctx, txn); err != nil {
-			return err
+	var applied []migration
+	for _, m := range migrations {
+		if m.version <= currentSchemaVersion {
+			continue
 		}
-	default:
-		return nil
+		if err := m.apply(ctx, txn); err != nil {
+			return fmt.Errorf("failed to migrate to schema %v: %w", m.version, err)
+		}
+		applied = append(applied, m)
+	}
+	if err := recordMigrations(ctx, txn, applied); err != nil {
+		return err
 	}
 
 	setUserVersionSQL := fmt.Sprintf(`PRAGMA user_version = %d`, databaseSchemaVersion)
@@ -184,36 +415,13 @@ func parseOpts(dsn string) (opts, error) {
 		return result, err
 	}
 
-	for k, vs := range values {
-		if len(vs) == 0 {
-			continue
-		}
+	if v := values.Get("driver-name"); v != "" {
+		result.driverName = v
+	}
+	delete(values, "driver-name")
 
-		switch k {
-		case "driver-name":
-			result.driverName = vs[0]
-		case "compact-interval":
-			d, err := time.ParseDuration(vs[0])
-			if err != nil {
-				return opts{}, fmt.Errorf("failed to parse compact-interval duration value %q: %w", vs[0], err)
-			}
-			result.compactInterval = d
-		case "poll-interval":
-			d, err := time.ParseDuration(vs[0])
-			if err != nil {
-				return opts{}, fmt.Errorf("failed to parse poll-interval duration value %q: %w", vs[0], err)
-			}
-			result.pollInterval = d
-		case "watch-query-timeout":
-			d, err := time.ParseDuration(vs[0])
-			if err != nil {
-				return opts{}, fmt.Errorf("failed to parse watch-query-timeout duration value %q: %w", vs[0], err)
-			}
-			result.watchQueryTimeout = d
-		default:
-			continue
-		}
-		delete(values, k)
+	if result.Options, err = generic.ParseOptions(values); err != nil {
+		return opts{}, err
 	}
 
 	if len(values) == 0 {
@@ -3,12 +3,18 @@ package sqlite_test
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"fmt"
 	"path"
+	"reflect"
 	"testing"
 	"time"
 
+	"github.com/canonical/k8s-dqlite/pkg/kine/compression"
 	"github.com/canonical/k8s-dqlite/pkg/kine/drivers/generic"
 	"github.com/canonical/k8s-dqlite/pkg/kine/drivers/sqlite"
+	"github.com/canonical/k8s-dqlite/pkg/kine/logstructured/sqllog"
+	"github.com/canonical/k8s-dqlite/pkg/kine/server"
 	"github.com/sirupsen/logrus"
 )
 
@@ -37,6 +43,23 @@ CREATE TABLE IF NOT EXISTS kine
 	return nil
 }
 
+// newTestDialect returns the backend and the dialect of a new database,
+// closed at the end of the test, along with the context of the test.
+func newTestDialect(t *testing.T) (context.Context, server.Backend, *generic.Generic) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+	dbPath := path.Join(t.TempDir(), "db.sqlite")
+	connPoolConfig := generic.ConnectionPoolConfig{MaxIdle: 1, MaxOpen: 1}
+
+	backend, dialect, err := sqlite.NewVariant(ctx, "sqlite3", dbPath, &connPoolConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { dialect.Close() })
+	return ctx, backend, dialect
+}
+
 func TestMigration(t *testing.T) {
 	const driver = "sqlite3"
 
@@ -68,7 +91,7 @@ SELECT COUNT(*)
 FROM sqlite_master
 WHERE type = 'index'
 	AND tbl_name = 'kine'
-	AND name IN ('kine_name_index', 'kine_name_prev_revision_uindex')`)
+	AND name IN ('kine_name_id_deleted_index', 'kine_name_prev_revision_uindex')`)
 
 	var indexes int
 	if err := row.Scan(&indexes); err != nil {
@@ -78,4 +101,711 @@ WHERE type = 'index'
 	if indexes != 2 {
 		t.Errorf("Expected 2 indexes, got %d", indexes)
 	}
+
+	row = db.QueryRow(`
+SELECT COUNT(*)
+FROM sqlite_master
+WHERE type = 'table'
+	AND name = 'kine_leases'`)
+
+	var tables int
+	if err := row.Scan(&tables); err != nil {
+		t.Error(err)
+	}
+
+	if tables != 1 {
+		t.Errorf("Expected kine_leases table, got %d tables", tables)
+	}
+
+	row = db.QueryRow(`
+SELECT COUNT(*)
+FROM sqlite_master
+WHERE type = 'table'
+	AND name = 'kine_alarms'`)
+
+	if err := row.Scan(&tables); err != nil {
+		t.Error(err)
+	}
+
+	if tables != 1 {
+		t.Errorf("Expected kine_alarms table, got %d tables", tables)
+	}
+}
+
+func TestEscapeMigration(t *testing.T) {
+	ctx := context.Background()
+	dbPath := path.Join(t.TempDir(), "db.sqlite")
+	db, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := setupV0(db); err != nil {
+		t.Fatal(err)
+	}
+	// Raw values written before the escaping, which start with the marker
+	// bytes of compressed values and chunk references.
+	values := [][]byte{{0xf5, 1, 2}, {0xf7, 'a'}, append([]byte{0xf8}, "42"...), []byte("k8s")}
+	for i, value := range values {
+		if _, err := db.ExecContext(ctx, `INSERT INTO kine(name, created, deleted, create_revision, prev_revision, lease, value, old_value) VALUES(?, 1, 0, 0, 0, 0, ?, ?)`, fmt.Sprintf("/key/%d", i), value, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	connPoolConfig := generic.ConnectionPoolConfig{MaxIdle: 1, MaxOpen: 1}
+	backend, dialect, err := sqlite.NewVariant(ctx, "sqlite3", dbPath, &connPoolConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dialect.Close()
+
+	for i, value := range values {
+		_, kv, err := backend.Get(ctx, fmt.Sprintf("/key/%d", i), "", 1, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if kv == nil || string(kv.Value) != string(value) {
+			t.Errorf("expected value %q, got %+v", value, kv)
+		}
+	}
+	var escaped int
+	if err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM kine WHERE substr(old_value, 1, 1) = X'F7'`).Scan(&escaped); err != nil {
+		t.Fatal(err)
+	}
+	if escaped != 3 {
+		t.Errorf("expected 3 escaped previous values, got %d", escaped)
+	}
+}
+
+func TestCommitNotify(t *testing.T) {
+	ctx, _, dialect := newTestDialect(t)
+
+	commits := dialect.GetCommitNotify()
+	if commits == nil {
+		t.Fatal("Expected commits to be notified")
+	}
+	// Drain the commits of the setup.
+	select {
+	case <-commits:
+	default:
+	}
+
+	if _, err := dialect.DB.ExecContext(ctx, `INSERT INTO kine_alarms (alarm) VALUES (1)`); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-commits:
+	case <-time.After(time.Second):
+		t.Error("Expected the commit to be notified")
+	}
+}
+
+func TestWriteDB(t *testing.T) {
+	ctx, _, dialect := newTestDialect(t)
+
+	if dialect.WriteDB == nil {
+		t.Fatal("Expected a separate connection pool for the writes")
+	}
+
+	// Hold the only connection of the read pool, with an open read
+	// transaction, which must not block the writes.
+	conn, err := dialect.DB.Underlying().Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	rows, err := conn.QueryContext(ctx, `SELECT id FROM kine`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	if _, created, err := dialect.Create(ctx, "/key", []byte("value"), 0); err != nil {
+		t.Fatal(err)
+	} else if !created {
+		t.Error("Expected the key to be created")
+	}
+}
+
+func TestVerify(t *testing.T) {
+	ctx, backend, dialect := newTestDialect(t)
+
+	rev, _, err := dialect.Create(ctx, "/key", []byte("1"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rev, _, err = dialect.Update(ctx, "/key", []byte("2"), rev, 0); err != nil {
+		t.Fatal(err)
+	}
+	if rev, _, err = dialect.Delete(ctx, "/key", rev); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = dialect.Create(ctx, "/key", []byte("3"), 0); err != nil {
+		t.Fatal(err)
+	}
+	violations, err := backend.Verify(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(violations) != 0 {
+		t.Fatalf("unexpected violations %+v", violations)
+	}
+
+	// An update of the tombstone of another key.
+	if _, err := dialect.DB.Underlying().ExecContext(ctx, `
+		INSERT INTO kine(name, created, deleted, create_revision, prev_revision, lease, value, old_value)
+		VALUES('/other', 0, 0, 1, ?, 0, '4', '2')`, rev); err != nil {
+		t.Fatal(err)
+	}
+	violations, err = backend.Verify(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	problems := map[string]bool{}
+	for _, violation := range violations {
+		problems[violation.Problem] = true
+	}
+	for _, problem := range []string{"follows a tombstone without being created", "follows a revision of another key"} {
+		if !problems[problem] {
+			t.Errorf("expected violation %q, got %+v", problem, violations)
+		}
+	}
+}
+
+func TestCompactFill(t *testing.T) {
+	ctx, _, dialect := newTestDialect(t)
+
+	// The keys which happen to start with "gap-" are not fill rows.
+	if _, _, err := dialect.Create(ctx, "gap-user", []byte("1"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := dialect.Create(ctx, "/a", []byte("1"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := dialect.Fill(ctx, 3); err != nil {
+		t.Fatal(err)
+	}
+	// The fill row was left behind by an earlier compaction.
+	if _, err := dialect.DB.Underlying().ExecContext(ctx, `UPDATE kine_metadata SET value = 3 WHERE name = 'compact_revision'`); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := dialect.Create(ctx, "/b", []byte("1"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := dialect.Fill(ctx, 5); err != nil {
+		t.Fatal(err)
+	}
+	if err := dialect.Fill(ctx, 7); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := dialect.Compact(ctx, 6); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	rows, err := dialect.DB.Underlying().QueryContext(ctx, `SELECT name FROM kine WHERE name LIKE 'gap-%' ORDER BY id`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, name)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"gap-user", "gap-7"}; !reflect.DeepEqual(names, expected) {
+		t.Errorf("expected fill rows %v, got %v", expected, names)
+	}
+}
+
+func TestRevisionGaps(t *testing.T) {
+	ctx, _, dialect := newTestDialect(t)
+
+	for _, key := range []string{"/a", "/b", "/c"} {
+		if _, _, err := dialect.Create(ctx, key, []byte("1"), 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := dialect.Fill(ctx, 4); err != nil {
+		t.Fatal(err)
+	}
+	// Revisions 5 and 6 are missing.
+	if _, err := dialect.DB.Underlying().ExecContext(ctx, `
+		INSERT INTO kine(id, name, created, deleted, create_revision, prev_revision, lease, value, old_value)
+		VALUES(7, '/d', 1, 0, 0, 0, 0, '1', NULL)`); err != nil {
+		t.Fatal(err)
+	}
+
+	gaps := func(start, end int64) [][2]int64 {
+		rows, err := dialect.RevisionGaps(ctx, start, end)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rows.Close()
+		var gaps [][2]int64
+		for rows.Next() {
+			var gap [2]int64
+			if err := rows.Scan(&gap[0], &gap[1]); err != nil {
+				t.Fatal(err)
+			}
+			gaps = append(gaps, gap)
+		}
+		if err := rows.Err(); err != nil {
+			t.Fatal(err)
+		}
+		return gaps
+	}
+	if got, expected := gaps(0, 7), [][2]int64{{4, 7}}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected gaps %v, got %v", expected, got)
+	}
+	if got := gaps(0, 4); len(got) != 0 {
+		t.Errorf("expected no gaps up to revision 4, got %v", got)
+	}
+
+	// The compactions delete the rows up to the compact revision.
+	if _, err := dialect.DB.Underlying().ExecContext(ctx, `UPDATE kine_metadata SET value = 6 WHERE name = 'compact_revision'`); err != nil {
+		t.Fatal(err)
+	}
+	if got := gaps(0, 7); len(got) != 0 {
+		t.Errorf("expected no gaps after the compact revision, got %v", got)
+	}
+}
+
+func TestChunks(t *testing.T) {
+	ctx, _, dialect := newTestDialect(t)
+	dialect.ChunkSize = 4
+
+	countChunks := func() int {
+		var count int
+		if err := dialect.DB.Underlying().QueryRowContext(ctx, `SELECT COUNT(*) FROM kine_chunks`).Scan(&count); err != nil {
+			t.Fatal(err)
+		}
+		return count
+	}
+	readValue := func(rev int64) []byte {
+		var name string
+		var value []byte
+		if err := dialect.DB.Underlying().QueryRowContext(ctx, `SELECT name, value FROM kine WHERE id = ?`, rev).Scan(&name, &value); err != nil {
+			t.Fatal(err)
+		}
+		id, ok := compression.ParseChunkReference(value)
+		if !ok {
+			return value
+		}
+		value, err := dialect.ReadChunks(ctx, name, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return value
+	}
+
+	rev, _, err := dialect.Create(ctx, "/a", []byte("0123456789"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value := readValue(rev); string(value) != "0123456789" {
+		t.Errorf("expected value 0123456789, got %q", value)
+	}
+	if count := countChunks(); count != 3 {
+		t.Errorf("expected 3 chunks, got %d", count)
+	}
+	if _, _, err := dialect.Create(ctx, "/b", []byte("1"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if count := countChunks(); count != 3 {
+		t.Errorf("expected the small values to be stored whole, got %d chunks", count)
+	}
+
+	// The chunks of the failed writes are deleted.
+	if _, updated, err := dialect.Update(ctx, "/a", []byte("abcdefghij"), rev+10, 0); err != nil || updated {
+		t.Fatalf("expected the update of a stale revision to fail, got %v (%v)", updated, err)
+	}
+	if count := countChunks(); count != 3 {
+		t.Errorf("expected the chunks of the failed update to be deleted, got %d chunks", count)
+	}
+
+	updateRev, _, err := dialect.Update(ctx, "/a", []byte("abcdefghij"), rev, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value := readValue(updateRev); string(value) != "abcdefghij" {
+		t.Errorf("expected value abcdefghij, got %q", value)
+	}
+
+	// The first value is still referenced as the previous value of the
+	// update, so its chunks are only deleted along with the update.
+	if err := dialect.Compact(ctx, updateRev); err != nil {
+		t.Fatal(err)
+	}
+	if count := countChunks(); count != 6 {
+		t.Errorf("expected 6 chunks, got %d", count)
+	}
+	deleteRev, _, err := dialect.Delete(ctx, "/a", updateRev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := dialect.Compact(ctx, deleteRev); err != nil {
+		t.Fatal(err)
+	}
+	if count := countChunks(); count != 0 {
+		t.Errorf("expected the chunks to be deleted with the key, got %d chunks", count)
+	}
+
+	// The orphaned chunks are swept, and the referenced ones are kept.
+	rev, _, err = dialect.Create(ctx, "/c", []byte("0123456789"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The chunks of a key are neither read nor deleted through the rows of
+	// other keys.
+	if _, err := dialect.DB.Underlying().ExecContext(ctx, `INSERT INTO kine(name, created, deleted, create_revision, prev_revision, lease, value, old_value) SELECT '/e', 1, 0, 0, 0, 0, value, NULL FROM kine WHERE id = ?`, rev); err != nil {
+		t.Fatal(err)
+	}
+	var id int64
+	if err := dialect.DB.Underlying().QueryRowContext(ctx, `SELECT id FROM kine WHERE name = '/e'`).Scan(&id); err != nil {
+		t.Fatal(err)
+	}
+	if value := readValue(id); value != nil {
+		t.Errorf("expected the chunks of /c not to be read for /e, got %q", value)
+	}
+	if _, err := dialect.DB.Underlying().ExecContext(ctx, `DELETE FROM kine WHERE name = '/e'`); err != nil {
+		t.Fatal(err)
+	}
+	if count := countChunks(); count != 3 {
+		t.Errorf("expected the chunks of /c to be kept, got %d chunks", count)
+	}
+
+	if _, err := dialect.DB.Underlying().ExecContext(ctx, `INSERT INTO kine_chunks(blob, name, data) VALUES(0, '/d', 'orphan')`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dialect.DB.Underlying().ExecContext(ctx, dialect.SweepChunksSQL, 0, 100); err != nil {
+		t.Fatal(err)
+	}
+	if count := countChunks(); count != 3 {
+		t.Errorf("expected the orphaned chunk to be swept, got %d chunks", count)
+	}
+}
+
+func TestDedupOldValues(t *testing.T) {
+	ctx, _, dialect := newTestDialect(t)
+
+	rev, _, err := dialect.Create(ctx, "/a", []byte("1"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The old value of this update is stored, as it is written before the
+	// deduplication is enabled.
+	if rev, _, err = dialect.Update(ctx, "/a", []byte("2"), rev, 0); err != nil {
+		t.Fatal(err)
+	}
+	dialect.ApplyOptions(generic.Options{DedupOldValues: true})
+	if rev, _, err = dialect.Update(ctx, "/a", []byte("3"), rev, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = dialect.Delete(ctx, "/a", rev); err != nil {
+		t.Fatal(err)
+	}
+
+	countOldValues := func() int {
+		var count int
+		if err := dialect.DB.Underlying().QueryRowContext(ctx, `SELECT COUNT(old_value) FROM kine`).Scan(&count); err != nil {
+			t.Fatal(err)
+		}
+		return count
+	}
+	prevValues := func() []string {
+		rows, err := dialect.After(ctx, 0, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		events, err := sqllog.RowsToEvents(rows)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var values []string
+		for _, event := range events {
+			if event.PrevKV != nil {
+				values = append(values, string(event.PrevKV.Value))
+			}
+		}
+		return values
+	}
+
+	if count := countOldValues(); count != 1 {
+		t.Errorf("expected a single old value stored, got %d", count)
+	}
+	expected := []string{"1", "2", "3"}
+	if values := prevValues(); !reflect.DeepEqual(values, expected) {
+		t.Errorf("expected previous values %v, got %v", expected, values)
+	}
+
+	// The old values stored before are cleared by the compactions.
+	if err := dialect.Compact(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	if count := countOldValues(); count != 0 {
+		t.Errorf("expected the stored old values to be cleared, got %d", count)
+	}
+	if values := prevValues(); !reflect.DeepEqual(values, expected) {
+		t.Errorf("expected previous values %v, got %v", expected, values)
+	}
+}
+
+func TestKeyCounts(t *testing.T) {
+	ctx, _, dialect := newTestDialect(t)
+
+	var err error
+	revs := map[string]int64{}
+	for _, key := range []string{"/registry/pods/a/1", "/registry/pods/a/2", "/registry/pods/b/1", "/registry/pods/", "/registry/podtemplates/a/1", "/registry/pods//"} {
+		if revs[key], _, err = dialect.Create(ctx, key, []byte("1"), 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rev := revs["/registry/pods//"]
+	if rev, _, err = dialect.Update(ctx, "/registry/pods//", []byte("2"), rev, 0); err != nil {
+		t.Fatal(err)
+	}
+	if rev, _, err = dialect.Delete(ctx, "/registry/pods//", rev); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = dialect.Create(ctx, "/registry/pods//", []byte("3"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = dialect.Delete(ctx, "/registry/pods/a/2", revs["/registry/pods/a/2"]); err != nil {
+		t.Fatal(err)
+	}
+
+	// The counts must match the ones of the keys scanned at the current
+	// revision, before and after they are recounted by the migration.
+	check := func() {
+		t.Helper()
+		for _, prefix := range []string{"/", "/registry/", "/registry/pods/", "/registry/pods/a/", "/registry/podtemplates/", "/registry/pods/c/"} {
+			currentRev, count, err := dialect.CountCurrent(ctx, prefix, prefix)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, scanned, err := dialect.Count(ctx, prefix, prefix, currentRev)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if count != scanned {
+				t.Errorf("expected %d keys under %s, got %d", scanned, prefix, count)
+			}
+		}
+	}
+	check()
+
+	db := dialect.DB.Underlying()
+	for _, stmt := range []string{`DROP TRIGGER kine_key_counts_insert`, `DROP TABLE kine_key_counts`, `PRAGMA user_version = 4`} {
+		if _, err := db.ExecContext(ctx, stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sqlite.Setup(ctx, db); err != nil {
+		t.Fatal(err)
+	}
+	check()
+}
+
+func TestQueryPlans(t *testing.T) {
+	ctx, _, dialect := newTestDialect(t)
+
+	for _, tc := range []struct {
+		name  string
+		query string
+		args  []any
+		plan  string
+	}{
+		{name: "create", query: dialect.CreateSQL, args: []any{"/key", 0, "value", "/key"}, plan: "SEARCH kine USING COVERING INDEX kine_name_id_deleted_index (name=?)"},
+		{name: "update", query: dialect.UpdateSQL, args: []any{"/key", 0, "value", "/key", 1}, plan: "SEARCH kine USING COVERING INDEX kine_name_id_deleted_index (name=?)"},
+		{name: "list", query: dialect.GetCurrentSQL, args: []any{"/", "0", false}, plan: "SEARCH mkv USING COVERING INDEX kine_name_id_deleted_index (name>? AND name<?)"},
+		{name: "after", query: dialect.AfterSQL, args: []any{1}, plan: "SEARCH kv USING INTEGER PRIMARY KEY (rowid>?)"},
+		{name: "compact", query: dialect.CompactSQL, args: []any{1, 2}, plan: "SEARCH kine USING INTEGER PRIMARY KEY (rowid>? AND rowid<?)"},
+		{name: "compact fill", query: dialect.CompactFillSQL, args: []any{2}, plan: "SEARCH kine USING INDEX kine_name_prev_revision_uindex (prev_revision=? AND name>? AND name<?)"},
+		{name: "count fill", query: dialect.CountFillSQL, args: []any{2}, plan: "SEARCH kine USING INDEX kine_name_prev_revision_uindex (prev_revision=? AND name>? AND name<?)"},
+		{name: "count prefix", query: dialect.CountPrefixSQL, args: []any{"/", "0"}, plan: "SEARCH kine_key_counts USING INDEX sqlite_autoindex_kine_key_counts_1 (prefix>? AND prefix<?)"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rows, err := dialect.DB.Underlying().QueryContext(ctx, "EXPLAIN QUERY PLAN "+tc.query, tc.args...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rows.Close()
+
+			var plan []string
+			for rows.Next() {
+				var (
+					id, parent, unused int
+					detail             string
+				)
+				if err := rows.Scan(&id, &parent, &unused, &detail); err != nil {
+					t.Fatal(err)
+				}
+				if detail == tc.plan {
+					return
+				}
+				plan = append(plan, detail)
+			}
+			if err := rows.Err(); err != nil {
+				t.Fatal(err)
+			}
+			t.Errorf("expected the plan to include %q, got %q", tc.plan, plan)
+		})
+	}
+}
+
+func TestSchemaVersions(t *testing.T) {
+	ctx, _, dialect := newTestDialect(t)
+	db := dialect.DB.Underlying()
+
+	var (
+		migrations int
+		latest     sqlite.SchemaVersion
+	)
+	if err := db.QueryRowContext(ctx, `SELECT COUNT(*), MAX(version) FROM kine_schema_version`).Scan(&migrations, &latest); err != nil {
+		t.Fatal(err)
+	}
+	if latest != sqlite.DatabaseSchemaVersion() || migrations != int(latest.Minor()) {
+		t.Errorf("expected the migrations up to %v to be recorded, got %d up to %v", sqlite.DatabaseSchemaVersion(), migrations, latest)
+	}
+
+	// A newer schema can be used as long as its migrations are compatible
+	// with the schema of this version.
+	newer := sqlite.NewSchemaVersion(0, sqlite.DatabaseSchemaVersion().Minor()+1)
+	for _, stmt := range []string{
+		fmt.Sprintf(`INSERT INTO kine_schema_version(version, compatible, applied) VALUES(%d, 0, 0)`, newer),
+		fmt.Sprintf(`PRAGMA user_version = %d`, newer),
+	} {
+		if _, err := db.ExecContext(ctx, stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sqlite.Setup(ctx, db); err != nil {
+		t.Fatalf("expected compatible schema %v to be used, got %v", newer, err)
+	}
+
+	if _, err := db.ExecContext(ctx, `UPDATE kine_schema_version SET compatible = ? WHERE version = ?`, newer, newer); err != nil {
+		t.Fatal(err)
+	}
+	if err := sqlite.Setup(ctx, db); !errors.Is(err, sqlite.ErrIncompatibleSchema) {
+		t.Fatalf("expected the downgrade to be refused, got %v", err)
+	}
+}
+
+func TestEventsTable(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	dbPath := path.Join(t.TempDir(), "db.sqlite")
+	connPoolConfig := generic.ConnectionPoolConfig{MaxIdle: 1, MaxOpen: 1}
+
+	open := func(query string) *generic.Generic {
+		t.Helper()
+		_, dialect, err := sqlite.NewVariant(ctx, "sqlite3", dbPath+query, &connPoolConfig)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return dialect
+	}
+	tableRows := func(dialect *generic.Generic, table string) (rows int) {
+		t.Helper()
+		if err := dialect.DB.Underlying().QueryRowContext(ctx, "SELECT COUNT(*) FROM "+table).Scan(&rows); err != nil {
+			t.Fatal(err)
+		}
+		return rows
+	}
+	checkCounts := func(dialect *generic.Generic) {
+		t.Helper()
+		for _, prefix := range []string{"/registry/", "/registry/events/", "/registry/pods/"} {
+			currentRev, count, err := dialect.CountCurrent(ctx, prefix, prefix)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, scanned, err := dialect.Count(ctx, prefix, prefix, currentRev)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if count != scanned {
+				t.Errorf("expected %d keys under %s, got %d", scanned, prefix, count)
+			}
+		}
+	}
+
+	dialect := open("?events-prefix=/registry/events/")
+	var revs []int64
+	for _, key := range []string{"/registry/pods/a", "/registry/events/a", "/registry/pods/b", "/registry/events/b"} {
+		rev, _, err := dialect.Create(ctx, key, []byte("1"), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		revs = append(revs, rev)
+	}
+	// Both tables share the revisions.
+	if !reflect.DeepEqual(revs, []int64{1, 2, 3, 4}) {
+		t.Fatalf("expected the keys created at revisions 1 to 4, got %v", revs)
+	}
+	if kine, events := tableRows(dialect, "kine"), tableRows(dialect, "kine_events"); kine != 2 || events != 2 {
+		t.Fatalf("expected 2 rows in each table, got %d and %d", kine, events)
+	}
+	rev, _, err := dialect.Update(ctx, "/registry/events/a", []byte("2"), revs[1], 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rev != 5 {
+		t.Fatalf("expected the event updated at revision 5, got %d", rev)
+	}
+	checkCounts(dialect)
+
+	// The events are compacted apart from the other keys.
+	if err := dialect.EventsCompactor().Compact(ctx, rev); err != nil {
+		t.Fatal(err)
+	}
+	if compact, _, err := dialect.GetCompactRevision(ctx); err != nil || compact != 0 {
+		t.Fatalf("expected the kine table left uncompacted, got %d (%v)", compact, err)
+	}
+	for _, tc := range []struct {
+		start, end string
+		compact    int64
+	}{
+		{"/registry/events/", "/registry/events0", rev},
+		{"/registry/pods/", "/registry/pods0", 0},
+		{"/registry/", "/registry0", rev},
+	} {
+		if compact, current, err := dialect.GetRangeCompactRevision(ctx, tc.start, tc.end); err != nil || compact != tc.compact || current != rev {
+			t.Errorf("expected the keys between %s and %s compacted up to %d at %d, got %d at %d (%v)", tc.start, tc.end, tc.compact, rev, compact, current, err)
+		}
+	}
+	if events := tableRows(dialect, "kine_events"); events != 2 {
+		t.Fatalf("expected the superseded event compacted, got %d events", events)
+	}
+	dialect.Close()
+
+	// The rows are moved back as the events are no longer stored apart, and
+	// moved again once they are.
+	for _, tc := range []struct {
+		query        string
+		kine, events int
+	}{
+		{"", 4, 0},
+		{"?events-prefix=/registry/events/", 2, 3},
+	} {
+		dialect := open(tc.query)
+		if kine, events := tableRows(dialect, "kine"), tableRows(dialect, "kine_events"); kine != tc.kine || events != tc.events {
+			t.Errorf("%q: expected %d and %d rows, got %d and %d", tc.query, tc.kine, tc.events, kine, events)
+		}
+		checkCounts(dialect)
+		rev, _, err := dialect.Create(ctx, "/registry/events/"+tc.query, []byte("1"), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if current, err := dialect.CurrentRevision(ctx); err != nil || current != rev {
+			t.Errorf("%q: expected the current revision %d, got %d (%v)", tc.query, rev, current, err)
+		}
+		dialect.Close()
+	}
 }
@@ -0,0 +1,73 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"path"
+	"testing"
+)
+
+func TestEnableIncrementalVacuum(t *testing.T) {
+	tests := []struct {
+		name       string
+		existing   bool
+		fullVacuum bool
+		enabled    bool
+	}{
+		{
+			name:    "new database",
+			enabled: true,
+		},
+		{
+			name:     "existing database without full vacuum",
+			existing: true,
+		},
+		{
+			name:       "existing database with full vacuum",
+			existing:   true,
+			fullVacuum: true,
+			enabled:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			dbPath := path.Join(t.TempDir(), "db.sqlite")
+
+			db, err := sql.Open("sqlite3", dbPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() { db.Close() }()
+
+			if tt.existing {
+				if _, err := db.ExecContext(ctx, `CREATE TABLE t (x INTEGER)`); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			enabled, err := enableIncrementalVacuum(ctx, db, tt.fullVacuum)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if enabled != tt.enabled {
+				t.Fatalf("expected enabled: %v, got %v", tt.enabled, enabled)
+			}
+			db.Close()
+
+			// The mode must be persisted, not only set on a connection.
+			db, err = sql.Open("sqlite3", dbPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var mode int
+			if err := db.QueryRowContext(ctx, `PRAGMA auto_vacuum`).Scan(&mode); err != nil {
+				t.Fatal(err)
+			}
+			if persisted := mode == 2; persisted != tt.enabled {
+				t.Fatalf("expected incremental auto_vacuum: %v, got mode %d", tt.enabled, mode)
+			}
+		})
+	}
+}
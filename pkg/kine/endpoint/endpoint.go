@@ -2,28 +2,46 @@ package endpoint
 
 import (
 	"context"
-	"fmt"
+	cryptotls "crypto/tls"
 	"net"
 	"os"
 	"strings"
+	"time"
 
-	"github.com/canonical/k8s-dqlite/pkg/kine/drivers/dqlite"
+	"github.com/canonical/k8s-dqlite/pkg/kine/drivers"
+	_ "github.com/canonical/k8s-dqlite/pkg/kine/drivers/dqlite"
 	"github.com/canonical/k8s-dqlite/pkg/kine/drivers/generic"
-	"github.com/canonical/k8s-dqlite/pkg/kine/drivers/sqlite"
+	_ "github.com/canonical/k8s-dqlite/pkg/kine/drivers/memory"
+	_ "github.com/canonical/k8s-dqlite/pkg/kine/drivers/mysql"
+	_ "github.com/canonical/k8s-dqlite/pkg/kine/drivers/pgsql"
+	_ "github.com/canonical/k8s-dqlite/pkg/kine/drivers/sqlite"
 	"github.com/canonical/k8s-dqlite/pkg/kine/server"
 	"github.com/canonical/k8s-dqlite/pkg/kine/tls"
+	"github.com/canonical/k8s-dqlite/pkg/logging"
+	grpc_prometheus "github.com/grpc-ecosystem/go-grpc-prometheus"
 	"github.com/pkg/errors"
-	"github.com/sirupsen/logrus"
 	"go.etcd.io/etcd/server/v3/embed"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/keepalive"
 )
 
+var logger = logging.Component(logging.Server)
+
+func init() {
+	// As in etcd with --metrics=extensive, the latency of the requests is
+	// recorded by method.
+	grpc_prometheus.EnableHandlingTimeHistogram()
+}
+
 const (
-	KineSocket    = "unix://kine.sock"
-	SQLiteBackend = "sqlite"
-	DQLiteBackend = "dqlite"
-	ETCDBackend   = "etcd3"
+	KineSocket      = "unix://kine.sock"
+	SQLiteBackend   = "sqlite"
+	DQLiteBackend   = "dqlite"
+	PostgresBackend = "postgres"
+	MySQLBackend    = "mysql"
+	MemoryBackend   = "memory"
+	ETCDBackend     = "etcd3"
 )
 
 type Config struct {
@@ -31,14 +49,139 @@ type Config struct {
 	Listener             string
 	Endpoint             string
 	ConnectionPoolConfig generic.ConnectionPoolConfig
+	// Cluster optionally exposes the state of the cluster replicating the datastore.
+	Cluster server.Cluster
+	// QuotaBackendBytes is the size of the database after which a NOSPACE
+	// alarm is raised and writes are refused. If zero, there is no quota.
+	QuotaBackendBytes int64
+	// Quota optionally replaces QuotaBackendBytes, so that the quota can
+	// be changed while the server runs.
+	Quota *server.Quota
+	// WatchProgressNotifyInterval is the interval between the progress
+	// notifications of the watches that request them. If zero, none
+	// are sent.
+	WatchProgressNotifyInterval time.Duration
+	// AuditLog optionally records the writes to the datastore.
+	AuditLog server.AuditLog
+	// RequestLimits optionally bound the size of the write requests and
+	// the number of operations of the transactions.
+	RequestLimits server.RequestLimits
+	// ServerTLSConfig optionally enables TLS on the listener, such as to
+	// authenticate the clients by their certificates.
+	ServerTLSConfig *cryptotls.Config
+	// GRPCOptions optionally tune the connections of the gRPC server.
+	GRPCOptions GRPCOptions
 
 	tls.Config
 }
 
+// GRPCOptions tune the connections of the gRPC server of the kine endpoint.
+// The zero values keep the defaults of etcd.
+type GRPCOptions struct {
+	// KeepaliveMinTime is the minimum interval between the keepalive pings
+	// of the clients, which are disconnected if they ping more often.
+	KeepaliveMinTime time.Duration
+	// KeepaliveTime is the interval after which the server pings an idle
+	// connection, and KeepaliveTimeout the time it waits for the reply
+	// before closing the connection.
+	KeepaliveTime    time.Duration
+	KeepaliveTimeout time.Duration
+	// MaxConcurrentStreams is the maximum number of concurrent streams of
+	// each connection.
+	MaxConcurrentStreams uint32
+	// MaxRecvMsgSize and MaxSendMsgSize are the maximum sizes of the
+	// messages received and sent. The maximum size of the messages received
+	// defaults to the maximum size of the requests plus GRPCOverheadBytes.
+	MaxRecvMsgSize int
+	MaxSendMsgSize int
+}
+
+// serverOptions returns the options of the gRPC server.
+func (o GRPCOptions) serverOptions(limits server.RequestLimits) []grpc.ServerOption {
+	keepaliveMinTime := embed.DefaultGRPCKeepAliveMinTime
+	if o.KeepaliveMinTime > 0 {
+		keepaliveMinTime = o.KeepaliveMinTime
+	}
+	keepaliveTime := embed.DefaultGRPCKeepAliveInterval
+	if o.KeepaliveTime > 0 {
+		keepaliveTime = o.KeepaliveTime
+	}
+	keepaliveTimeout := embed.DefaultGRPCKeepAliveTimeout
+	if o.KeepaliveTimeout > 0 {
+		keepaliveTimeout = o.KeepaliveTimeout
+	}
+	gopts := []grpc.ServerOption{
+		grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{
+			MinTime:             keepaliveMinTime,
+			PermitWithoutStream: false,
+		}),
+		grpc.KeepaliveParams(keepalive.ServerParameters{
+			Time:    keepaliveTime,
+			Timeout: keepaliveTimeout,
+		}),
+	}
+	if o.MaxConcurrentStreams > 0 {
+		gopts = append(gopts, grpc.MaxConcurrentStreams(o.MaxConcurrentStreams))
+	}
+	if o.MaxRecvMsgSize > 0 {
+		gopts = append(gopts, grpc.MaxRecvMsgSize(o.MaxRecvMsgSize))
+	} else if limits.MaxRequestBytes > 0 {
+		// As in etcd, the messages larger than a request are refused
+		// by gRPC, leaving room for its overhead.
+		gopts = append(gopts, grpc.MaxRecvMsgSize(limits.MaxRequestBytes+server.GRPCOverheadBytes))
+	}
+	if o.MaxSendMsgSize > 0 {
+		gopts = append(gopts, grpc.MaxSendMsgSize(o.MaxSendMsgSize))
+	}
+	return gopts
+}
+
+func (c Config) quota() *server.Quota {
+	if c.Quota != nil {
+		return c.Quota
+	}
+	return server.NewQuota(c.QuotaBackendBytes)
+}
+
 type ETCDConfig struct {
 	Endpoints   []string
 	TLSConfig   tls.Config
 	LeaderElect bool
+	// Server is the server of the kine endpoint, unless the backend is
+	// etcd.
+	Server *Server
+}
+
+// Server is the gRPC server of the kine endpoint.
+type Server struct {
+	grpc   *grpc.Server
+	bridge *server.KVServerBridge
+}
+
+// Drain stops the server: it refuses new requests, waits for the requests
+// being served to complete, or for ctx to be done, and then closes the
+// remaining streams, such as the watches.
+func (s *Server) Drain(ctx context.Context) {
+	stopped := make(chan struct{})
+	go func() {
+		s.grpc.GracefulStop()
+		close(stopped)
+	}()
+	if err := s.bridge.WaitRequests(ctx); err != nil {
+		logger.WithError(err).Warning("Stopping kine before the requests being served complete")
+	}
+	s.grpc.Stop()
+	<-stopped
+}
+
+// Watches lists the watches being served.
+func (s *Server) Watches() []server.WatchInfo {
+	return s.bridge.Watches()
+}
+
+// Connections lists the client connections of the server.
+func (s *Server) Connections() []server.ConnectionInfo {
+	return s.bridge.Connections()
 }
 
 func Listen(ctx context.Context, config Config) (ETCDConfig, error) {
@@ -65,9 +208,11 @@ func Listen(ctx context.Context, config Config) (ETCDConfig, error) {
 		listen = KineSocket
 	}
 
-	b := server.New(backend)
-	grpcServer := grpcServer(config)
+	b := server.New(backend, config.Cluster, config.quota(), config.WatchProgressNotifyInterval, config.AuditLog, config.RequestLimits)
+	grpcServer := grpcServer(config, b)
 	b.Register(grpcServer)
+	grpc_prometheus.Register(grpcServer)
+	go b.CheckHealth(ctx)
 
 	listener, err := createListener(listen)
 	if err != nil {
@@ -76,7 +221,7 @@ func Listen(ctx context.Context, config Config) (ETCDConfig, error) {
 
 	go func() {
 		if err := grpcServer.Serve(listener); err != nil {
-			logrus.Errorf("Kine server shutdown: %v", err)
+			logger.Errorf("Kine server shutdown: %v", err)
 		}
 		listener.Close()
 		grpcServer.Stop()
@@ -86,6 +231,7 @@ func Listen(ctx context.Context, config Config) (ETCDConfig, error) {
 		LeaderElect: leaderelect,
 		Endpoints:   []string{listen},
 		TLSConfig:   tls.Config{},
+		Server:      &Server{grpc: grpcServer, bridge: b},
 	}, nil
 }
 
@@ -94,7 +240,7 @@ func createListener(listen string) (ret net.Listener, rerr error) {
 
 	if network == "unix" {
 		if err := os.Remove(address); err != nil && !os.IsNotExist(err) {
-			logrus.Warnf("failed to remove socket %s: %v", address, err)
+			logger.Warnf("failed to remove socket %s: %v", address, err)
 		}
 		defer func() {
 			if err := os.Chmod(address, 0600); err != nil {
@@ -103,7 +249,7 @@ func createListener(listen string) (ret net.Listener, rerr error) {
 		}()
 	}
 
-	logrus.Infof("Kine listening on %s://%s", network, address)
+	logger.Infof("Kine listening on %s://%s", network, address)
 	return net.Listen(network, address)
 }
 
@@ -131,9 +277,11 @@ func ListenAndReturnBackend(ctx context.Context, config Config) (ETCDConfig, ser
 		listen = KineSocket
 	}
 
-	b := server.New(backend)
-	grpcServer := grpcServer(config)
+	b := server.New(backend, config.Cluster, config.quota(), config.WatchProgressNotifyInterval, config.AuditLog, config.RequestLimits)
+	grpcServer := grpcServer(config, b)
 	b.Register(grpcServer)
+	grpc_prometheus.Register(grpcServer)
+	go b.CheckHealth(ctx)
 
 	listener, err := createListener(listen)
 	if err != nil {
@@ -142,7 +290,7 @@ func ListenAndReturnBackend(ctx context.Context, config Config) (ETCDConfig, ser
 
 	go func() {
 		if err := grpcServer.Serve(listener); err != nil {
-			logrus.Errorf("Kine server shutdown: %v", err)
+			logger.Errorf("Kine server shutdown: %v", err)
 		}
 		listener.Close()
 		grpcServer.Stop()
@@ -152,54 +300,54 @@ func ListenAndReturnBackend(ctx context.Context, config Config) (ETCDConfig, ser
 		LeaderElect: leaderelect,
 		Endpoints:   []string{listen},
 		TLSConfig:   tls.Config{},
+		Server:      &Server{grpc: grpcServer, bridge: b},
 	}, backend, nil
 }
 
-func grpcServer(config Config) *grpc.Server {
+// grpcServer returns the server of the kine endpoint. The server in the
+// configuration, if any, is used as is, without the interceptors and the stats handler of b.
+//
+// The requests are counted, timed and their codes recorded by method in the
+// grpc_server_* metrics of etcd, so that its dashboards work as is. The
+// requests refused by b are recorded as well.
+func grpcServer(config Config, b *server.KVServerBridge) *grpc.Server {
 	if config.GRPCServer != nil {
 		return config.GRPCServer
 	}
-	gopts := []grpc.ServerOption{
-		grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{
-			MinTime:             embed.DefaultGRPCKeepAliveMinTime,
-			PermitWithoutStream: false,
-		}),
-		grpc.KeepaliveParams(keepalive.ServerParameters{
-			Time:    embed.DefaultGRPCKeepAliveInterval,
-			Timeout: embed.DefaultGRPCKeepAliveTimeout,
-		}),
+	gopts := append(config.GRPCOptions.serverOptions(config.RequestLimits),
+		grpc.ChainUnaryInterceptor(grpc_prometheus.UnaryServerInterceptor, b.UnaryInterceptor()),
+		grpc.ChainStreamInterceptor(grpc_prometheus.StreamServerInterceptor, b.StreamInterceptor()),
+		grpc.StatsHandler(b.StatsHandler()),
+	)
+	if config.ServerTLSConfig != nil {
+		gopts = append(gopts, grpc.Creds(credentials.NewTLS(config.ServerTLSConfig)))
 	}
 
 	return grpc.NewServer(gopts...)
 }
 
 func getKineStorageBackend(ctx context.Context, driver, dsn string, cfg Config) (bool, server.Backend, error) {
-	var (
-		backend     server.Backend
-		leaderElect = true
-		err         error
-	)
-	switch driver {
-	case SQLiteBackend:
-		leaderElect = false
-		backend, err = sqlite.New(ctx, dsn, &cfg.ConnectionPoolConfig)
-	case DQLiteBackend:
-		backend, err = dqlite.New(ctx, dsn, cfg.Config, &cfg.ConnectionPoolConfig)
-	default:
-		return false, nil, fmt.Errorf("storage backend is not defined")
+	factory, err := drivers.Get(driver)
+	if err != nil {
+		return false, nil, err
 	}
-
-	return leaderElect, backend, err
+	return factory(ctx, &drivers.Config{
+		DataSourceName:       dsn,
+		ConnectionPoolConfig: &cfg.ConnectionPoolConfig,
+		Config:               cfg.Config,
+	})
 }
 
+// ParseStorageEndpoint splits the storage endpoint into the scheme of the
+// driver serving it and the data source name handed over to the driver.
+// Endpoints without a scheme use the default SQLite datastore, while
+// HTTP(S) endpoints are not served by kine but by an external etcd.
 func ParseStorageEndpoint(storageEndpoint string) (string, string) {
 	network, address := networkAndAddress(storageEndpoint)
 	switch network {
 	case "":
 		return SQLiteBackend, ""
-	case "http":
-		fallthrough
-	case "https":
+	case "http", "https":
 		return ETCDBackend, address
 	}
 	return network, address
@@ -0,0 +1,433 @@
+package logstructured
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/canonical/k8s-dqlite/pkg/kine/server"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const (
+	// minLeaseID and maxLeaseID bound the IDs of granted leases. They fit
+	// in the 32 bit lease column of all the drivers, and are larger than
+	// any TTL stored as lease by the previous versions, which granted
+	// leases using their TTL as ID.
+	minLeaseID = 1 << 30
+	maxLeaseID = 1<<31 - 1
+
+	// leaseCheckInterval is the interval between checks for expired leases.
+	leaseCheckInterval = 500 * time.Millisecond
+	// leaseCheckpointInterval is the interval between checkpoints of the
+	// expiry of the renewed leases, and between loads of the leases
+	// granted through other members.
+	leaseCheckpointInterval = 5 * time.Second
+	// maxLeaseGrantAttempts bounds the attempts at granting a lease with a
+	// random ID, which may have been granted through another member.
+	maxLeaseGrantAttempts = 5
+)
+
+type lease struct {
+	ttl    int64
+	expiry time.Time
+	// checkpoint is the last persisted expiry of the lease.
+	checkpoint time.Time
+}
+
+// lessor tracks the expiry of the leases and the keys attached to them.
+// Expiries are periodically checkpointed to the database, so that they are
+// shared with the other members and survive restarts. Leases with a TTL
+// shorter than the checkpoint interval may be revoked by other members
+// before their renewals are checkpointed.
+type lessor struct {
+	mu     sync.Mutex
+	leases map[int64]*lease
+	keys   map[int64]map[string]struct{}
+	owner  map[string]int64
+}
+
+func newLessor() *lessor {
+	return &lessor{
+		leases: make(map[int64]*lease),
+		keys:   make(map[int64]map[string]struct{}),
+		owner:  make(map[string]int64),
+	}
+}
+
+// add tracks a persisted lease. Leases that were never checkpointed
+// expire after their TTL.
+func (ls *lessor) add(checkpoint *server.LeaseCheckpoint, now time.Time) lease {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	if l, ok := ls.leases[checkpoint.ID]; ok {
+		return *l
+	}
+	l := &lease{
+		ttl:        checkpoint.TTL,
+		expiry:     checkpoint.Expiry,
+		checkpoint: checkpoint.Expiry,
+	}
+	if l.expiry.IsZero() {
+		l.expiry = now.Add(time.Duration(l.ttl) * time.Second)
+	}
+	ls.leases[checkpoint.ID] = l
+	return *l
+}
+
+// extend moves the expiry of a lease to the checkpoint, if it was
+// renewed through another member. It returns false if it was not.
+func (ls *lessor) extend(checkpoint *server.LeaseCheckpoint) bool {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	l, ok := ls.leases[checkpoint.ID]
+	if !ok || !checkpoint.Expiry.After(l.expiry) {
+		return false
+	}
+	l.expiry = checkpoint.Expiry
+	l.checkpoint = checkpoint.Expiry
+	return true
+}
+
+// dirty returns the expiry of the leases renewed since their last checkpoint.
+func (ls *lessor) dirty() map[int64]time.Time {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	expiries := make(map[int64]time.Time)
+	for id, l := range ls.leases {
+		if l.expiry.After(l.checkpoint) {
+			expiries[id] = l.expiry
+		}
+	}
+	return expiries
+}
+
+func (ls *lessor) checkpointed(id int64, expiry time.Time) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	if l, ok := ls.leases[id]; ok && expiry.After(l.checkpoint) {
+		l.checkpoint = expiry
+	}
+}
+
+func (ls *lessor) get(id int64) (lease, bool) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	l, ok := ls.leases[id]
+	if !ok {
+		return lease{}, false
+	}
+	return *l, true
+}
+
+func (ls *lessor) renew(id int64, now time.Time) (int64, bool) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	l, ok := ls.leases[id]
+	if !ok {
+		return 0, false
+	}
+	l.expiry = now.Add(time.Duration(l.ttl) * time.Second)
+	return l.ttl, true
+}
+
+func (ls *lessor) remove(id int64) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	delete(ls.leases, id)
+	for key := range ls.keys[id] {
+		delete(ls.owner, key)
+	}
+	delete(ls.keys, id)
+}
+
+// track updates the lease a key is attached to after an event.
+func (ls *lessor) track(event *server.Event) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	key := event.KV.Key
+	if id, ok := ls.owner[key]; ok {
+		delete(ls.keys[id], key)
+		if len(ls.keys[id]) == 0 {
+			delete(ls.keys, id)
+		}
+		delete(ls.owner, key)
+	}
+
+	id := event.KV.Lease
+	if event.Delete || id < minLeaseID {
+		return
+	}
+	if ls.keys[id] == nil {
+		ls.keys[id] = make(map[string]struct{})
+	}
+	ls.keys[id][key] = struct{}{}
+	ls.owner[key] = id
+}
+
+func (ls *lessor) attached(id int64) []string {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	keys := make([]string, 0, len(ls.keys[id]))
+	for key := range ls.keys[id] {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func (ls *lessor) expired(now time.Time) []int64 {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	var ids []int64
+	for id, l := range ls.leases {
+		if now.After(l.expiry) {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// loadLeases tracks the persisted leases, so that the ones granted through
+// other members expire here as well, even if their member is gone.
+func (l *LogStructured) loadLeases(ctx context.Context) error {
+	leases, err := l.log.Leases(ctx)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, lease := range leases {
+		l.leases.add(lease, now)
+		l.leases.extend(lease)
+	}
+	return nil
+}
+
+// lookupLease returns the lease with the given ID, loading it from the
+// database if it was granted through another member.
+func (l *LogStructured) lookupLease(ctx context.Context, id int64) (lease, error) {
+	if lease, ok := l.leases.get(id); ok {
+		return lease, nil
+	}
+	checkpoint, err := l.log.Lease(ctx, id)
+	if err != nil {
+		return lease{}, err
+	} else if checkpoint == nil {
+		return lease{}, server.ErrLeaseNotFound
+	}
+	return l.leases.add(checkpoint, time.Now()), nil
+}
+
+func (l *LogStructured) checkLease(ctx context.Context, id int64) error {
+	if id < minLeaseID {
+		// Either no lease, or a TTL set by clients of previous versions.
+		return nil
+	}
+	_, err := l.lookupLease(ctx, id)
+	return err
+}
+
+func (l *LogStructured) LeaseGrant(ctx context.Context, id, ttl int64) (leaseID int64, err error) {
+	ctx, span := otelTracer.Start(ctx, fmt.Sprintf("%s.LeaseGrant", otelName))
+	defer func() {
+		logger.Debugf("LEASE GRANT id=%d, ttl=%d => id=%d, err=%v", id, ttl, leaseID, err)
+		span.SetAttributes(attribute.Int64("id", leaseID), attribute.Int64("ttl", ttl))
+		span.RecordError(err)
+		span.End()
+	}()
+
+	if ttl <= 0 {
+		return 0, fmt.Errorf("lease TTL must be positive, got %d", ttl)
+	}
+
+	if id != 0 {
+		if id < minLeaseID || id > maxLeaseID {
+			return 0, fmt.Errorf("lease ID must be between %d and %d, got %d", minLeaseID, maxLeaseID, id)
+		}
+		if err := l.grantLease(ctx, id, ttl); err == server.ErrKeyExists {
+			return 0, server.ErrLeaseExist
+		} else if err != nil {
+			return 0, err
+		}
+		return id, nil
+	}
+
+	// Random IDs are only checked against the local leases, the database
+	// refuses the ones granted through other members.
+	for attempt := 0; attempt < maxLeaseGrantAttempts; attempt++ {
+		id = minLeaseID + rand.Int63n(maxLeaseID-minLeaseID+1)
+		if _, ok := l.leases.get(id); ok {
+			continue
+		}
+		if err := l.grantLease(ctx, id, ttl); err != server.ErrKeyExists {
+			return id, err
+		}
+	}
+	return 0, fmt.Errorf("failed to find a free lease ID after %d attempts", maxLeaseGrantAttempts)
+}
+
+func (l *LogStructured) grantLease(ctx context.Context, id, ttl int64) error {
+	checkpoint := &server.LeaseCheckpoint{
+		ID:     id,
+		TTL:    ttl,
+		Expiry: time.Now().Add(time.Duration(ttl) * time.Second),
+	}
+	if err := l.log.GrantLease(ctx, id, ttl, checkpoint.Expiry); err != nil {
+		return err
+	}
+	l.leases.add(checkpoint, time.Now())
+	return nil
+}
+
+// LeaseRevoke deletes all the keys attached to the lease, then the lease.
+func (l *LogStructured) LeaseRevoke(ctx context.Context, id int64) (err error) {
+	ctx, span := otelTracer.Start(ctx, fmt.Sprintf("%s.LeaseRevoke", otelName))
+	defer func() {
+		logger.Debugf("LEASE REVOKE id=%d => err=%v", id, err)
+		span.SetAttributes(attribute.Int64("id", id))
+		span.RecordError(err)
+		span.End()
+	}()
+
+	if _, err := l.lookupLease(ctx, id); err != nil {
+		return err
+	}
+
+	for _, key := range l.leases.attached(id) {
+		_, event, err := l.get(ctx, key, "", 1, 0, false)
+		if err != nil {
+			return err
+		}
+		if event == nil || event.KV.Lease != id {
+			continue
+		}
+		if _, _, err := l.Delete(ctx, key, event.KV.ModRevision); err != nil {
+			return err
+		}
+	}
+
+	if err := l.log.RevokeLease(ctx, id); err != nil {
+		return err
+	}
+	l.leases.remove(id)
+	return nil
+}
+
+// LeaseKeepAlive renews the lease, returning its TTL.
+func (l *LogStructured) LeaseKeepAlive(ctx context.Context, id int64) (int64, error) {
+	if _, err := l.lookupLease(ctx, id); err != nil {
+		return 0, err
+	}
+	ttl, ok := l.leases.renew(id, time.Now())
+	if !ok {
+		return 0, server.ErrLeaseNotFound
+	}
+	return ttl, nil
+}
+
+func (l *LogStructured) LeaseTimeToLive(ctx context.Context, id int64) (*server.Lease, error) {
+	lease, err := l.lookupLease(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	remaining := int64(time.Until(lease.expiry).Round(time.Second) / time.Second)
+	if remaining < 0 {
+		remaining = 0
+	}
+	return &server.Lease{
+		ID:         id,
+		TTL:        remaining,
+		GrantedTTL: lease.ttl,
+		Keys:       l.leases.attached(id),
+	}, nil
+}
+
+// LeaseLeases returns the IDs of all the leases, including the ones
+// granted through other members.
+func (l *LogStructured) LeaseLeases(ctx context.Context) ([]int64, error) {
+	leases, err := l.log.Leases(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]int64, 0, len(leases))
+	for _, lease := range leases {
+		ids = append(ids, lease.ID)
+	}
+	return ids, nil
+}
+
+// expireLeases revokes the leases that were not kept alive in time.
+func (l *LogStructured) expireLeases(ctx context.Context) {
+	ticker := time.NewTicker(leaseCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		for _, id := range l.leases.expired(time.Now()) {
+			if err := l.expireLease(ctx, id); err != nil {
+				logger.WithError(err).WithField("id", id).Warning("failed to revoke expired lease")
+			}
+		}
+	}
+}
+
+// expireLease revokes a lease that expired, unless it was renewed
+// through another member since its last checkpoint.
+func (l *LogStructured) expireLease(ctx context.Context, id int64) error {
+	checkpoint, err := l.log.Lease(ctx, id)
+	if err != nil {
+		return err
+	} else if checkpoint == nil {
+		// Already revoked through another member.
+		l.leases.remove(id)
+		return nil
+	}
+	if checkpoint.Expiry.After(time.Now()) && l.leases.extend(checkpoint) {
+		return nil
+	}
+	return l.LeaseRevoke(ctx, id)
+}
+
+// checkpointLeases periodically persists the expiry of the renewed leases,
+// and loads the ones granted or renewed through other members.
+func (l *LogStructured) checkpointLeases(ctx context.Context) {
+	ticker := time.NewTicker(leaseCheckpointInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		for id, expiry := range l.leases.dirty() {
+			if err := l.log.CheckpointLease(ctx, id, expiry); err != nil {
+				logger.WithError(err).WithField("id", id).Warning("failed to checkpoint lease")
+				continue
+			}
+			l.leases.checkpointed(id, expiry)
+		}
+
+		if err := l.loadLeases(ctx); err != nil {
+			logger.WithError(err).Warning("failed to load leases")
+		}
+	}
+}
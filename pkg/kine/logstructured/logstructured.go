@@ -8,13 +8,22 @@ import (
 	"time"
 
 	"github.com/canonical/k8s-dqlite/pkg/kine/server"
-	"github.com/sirupsen/logrus"
+	"github.com/canonical/k8s-dqlite/pkg/logging"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/trace"
 )
 
-const otelName = "logstructured"
+var logger = logging.Component(logging.Backend)
+
+const (
+	otelName = "logstructured"
+
+	// catchUpPageSize is the number of events read at once by the watches
+	// catching up, so that large backlogs are streamed rather than being
+	// loaded in memory by a single query.
+	catchUpPageSize = 500
+)
 
 var (
 	otelTracer trace.Tracer
@@ -28,6 +37,9 @@ type Log interface {
 	Start(ctx context.Context) error
 	Wait()
 	CurrentRevision(ctx context.Context) (int64, error)
+	CompactRevision(ctx context.Context, prefix string) (int64, error)
+	ReadBarrier(ctx context.Context) error
+	CheckPollLoop() error
 	List(ctx context.Context, prefix, startKey string, limit, revision int64, includeDeletes bool) (int64, []*server.Event, error)
 	Create(ctx context.Context, key string, value []byte, lease int64) (rev int64, created bool, err error)
 	Update(ctx context.Context, key string, value []byte, revision, lease int64) (rev int64, updated bool, err error)
@@ -35,18 +47,42 @@ type Log interface {
 	After(ctx context.Context, prefix string, revision, limit int64) (int64, []*server.Event, error)
 	Watch(ctx context.Context, prefix string) <-chan []*server.Event
 	Count(ctx context.Context, prefix, startKey string, revision int64) (int64, int64, error)
+	ListRange(ctx context.Context, start, end string, limit, revision int64, opts server.RangeOptions) (int64, []*server.Event, error)
+	CountRange(ctx context.Context, start, end string, revision int64, opts server.RangeOptions) (int64, int64, error)
 	DbSize(ctx context.Context) (int64, error)
+	DbFileSize(ctx context.Context) (int64, error)
 	DoCompact(ctx context.Context) error
+	CompactTo(ctx context.Context, revision int64) (compactRevision int64, err error)
+	SetCompaction(config server.CompactionConfig)
+	DoVacuum(ctx context.Context) (before, after int64, err error)
+	DoBackup(ctx context.Context, path string) (int64, error)
+	DoHash(ctx context.Context, revision int64) (hash uint32, compactRevision, hashRevision int64, err error)
+	History(ctx context.Context, key string) (compactRevision int64, events []*server.Event, err error)
+	KeyStats(ctx context.Context) ([]server.KeyStats, error)
+	Verify(ctx context.Context) ([]server.Violation, error)
+	GrantLease(ctx context.Context, id, ttl int64, expiry time.Time) error
+	CheckpointLease(ctx context.Context, id int64, expiry time.Time) error
+	RevokeLease(ctx context.Context, id int64) error
+	Leases(ctx context.Context) ([]*server.LeaseCheckpoint, error)
+	Lease(ctx context.Context, id int64) (*server.LeaseCheckpoint, error)
+	Alarms(ctx context.Context) ([]int32, error)
+	ActivateAlarm(ctx context.Context, alarm int32) error
+	DeactivateAlarm(ctx context.Context, alarm int32) error
+	Txn(ctx context.Context, f func(tx server.Transaction) error) error
 }
 
 type LogStructured struct {
-	log Log
-	wg  sync.WaitGroup
+	log    Log
+	wg     sync.WaitGroup
+	leases *lessor
+	ttls   *ttlSweeper
 }
 
 func New(log Log) *LogStructured {
 	return &LogStructured{
-		log: log,
+		log:    log,
+		leases: newLessor(),
+		ttls:   newTTLSweeper(),
 	}
 }
 
@@ -54,17 +90,77 @@ func (l *LogStructured) DoCompact(ctx context.Context) error {
 	return l.log.DoCompact(ctx)
 }
 
+func (l *LogStructured) CompactTo(ctx context.Context, revision int64) (compactRevision int64, err error) {
+	return l.log.CompactTo(ctx, revision)
+}
+
+func (l *LogStructured) SetCompaction(config server.CompactionConfig) {
+	l.log.SetCompaction(config)
+}
+
+func (l *LogStructured) DoVacuum(ctx context.Context) (before, after int64, err error) {
+	return l.log.DoVacuum(ctx)
+}
+
+func (l *LogStructured) DoBackup(ctx context.Context, path string) (int64, error) {
+	return l.log.DoBackup(ctx, path)
+}
+
+func (l *LogStructured) DoHash(ctx context.Context, revision int64) (hash uint32, compactRevision, hashRevision int64, err error) {
+	return l.log.DoHash(ctx, revision)
+}
+
+func (l *LogStructured) History(ctx context.Context, key string) (compactRevision int64, events []*server.Event, err error) {
+	return l.log.History(ctx, key)
+}
+
+func (l *LogStructured) KeyStats(ctx context.Context) ([]server.KeyStats, error) {
+	return l.log.KeyStats(ctx)
+}
+
+func (l *LogStructured) Verify(ctx context.Context) ([]server.Violation, error) {
+	return l.log.Verify(ctx)
+}
+
+func (l *LogStructured) Alarms(ctx context.Context) ([]int32, error) {
+	return l.log.Alarms(ctx)
+}
+
+func (l *LogStructured) ActivateAlarm(ctx context.Context, alarm int32) error {
+	return l.log.ActivateAlarm(ctx, alarm)
+}
+
+func (l *LogStructured) DeactivateAlarm(ctx context.Context, alarm int32) error {
+	return l.log.DeactivateAlarm(ctx, alarm)
+}
+
 func (l *LogStructured) Start(ctx context.Context) error {
 	if err := l.log.Start(ctx); err != nil {
 		return err
 	}
 	l.Create(ctx, "/registry/health", []byte(`{"health":"true"}`), 0)
 
-	l.wg.Add(1)
+	if err := l.loadLeases(ctx); err != nil {
+		return err
+	}
+
+	l.wg.Add(4)
 	go func() {
 		defer l.wg.Done()
 		l.ttl(ctx)
 	}()
+	go func() {
+		defer l.wg.Done()
+		l.sweepTTLs(ctx)
+	}()
+	go func() {
+		defer l.wg.Done()
+		l.expireLeases(ctx)
+	}()
+	go func() {
+		defer l.wg.Done()
+		l.checkpointLeases(ctx)
+	}()
 	return nil
 }
 
@@ -82,7 +178,7 @@ func (l *LogStructured) Get(ctx context.Context, key, rangeEnd string, limit, re
 	)
 	defer func() {
 		l.adjustRevision(ctx, &revRet)
-		logrus.Debugf("GET %s, rev=%d => rev=%d, kv=%v, err=%v", key, revision, revRet, kvRet != nil, errRet)
+		logger.Debugf("GET %s, rev=%d => rev=%d, kv=%v, err=%v", key, revision, revRet, kvRet != nil, errRet)
 		span.SetAttributes(attribute.Int64("adjusted-revision", revRet))
 		span.RecordError(errRet)
 		span.End()
@@ -138,14 +234,17 @@ func (l *LogStructured) adjustRevision(ctx context.Context, rev *int64) {
 }
 
 func (l *LogStructured) Create(ctx context.Context, key string, value []byte, lease int64) (rev int64, created bool, err error) {
+	if err := l.checkLease(ctx, lease); err != nil {
+		return 0, false, err
+	}
 	rev, created, err = l.log.Create(ctx, key, value, lease)
-	logrus.Debugf("CREATE %s, size=%d, lease=%d => rev=%d, err=%v", key, len(value), lease, rev, err)
+	logger.Debugf("CREATE %s, size=%d, lease=%d => rev=%d, err=%v", key, len(value), lease, rev, err)
 	return rev, created, err
 }
 
 func (l *LogStructured) Delete(ctx context.Context, key string, revision int64) (revRet int64, deleted bool, errRet error) {
 	rev, del, err := l.log.Delete(ctx, key, revision)
-	logrus.Debugf("DELETE %s, rev=%d => rev=%d, deleted=%v, err=%v", key, revision, rev, del, err)
+	logger.Debugf("DELETE %s, rev=%d => rev=%d, deleted=%v, err=%v", key, revision, rev, del, err)
 	return rev, del, err
 }
 
@@ -153,7 +252,7 @@ func (l *LogStructured) List(ctx context.Context, prefix, startKey string, limit
 	ctx, span := otelTracer.Start(ctx, fmt.Sprintf("%s.List", otelName))
 
 	defer func() {
-		logrus.Debugf("LIST %s, start=%s, limit=%d, rev=%d => rev=%d, kvs=%d, err=%v", prefix, startKey, limit, revision, revRet, len(kvRet), errRet)
+		logger.Debugf("LIST %s, start=%s, limit=%d, rev=%d => rev=%d, kvs=%d, err=%v", prefix, startKey, limit, revision, revRet, len(kvRet), errRet)
 		span.SetAttributes(
 			attribute.String("prefix", prefix),
 			attribute.String("startKey", startKey),
@@ -194,7 +293,7 @@ func (l *LogStructured) List(ctx context.Context, prefix, startKey string, limit
 func (l *LogStructured) Count(ctx context.Context, prefix, startKey string, revision int64) (revRet int64, count int64, err error) {
 	ctx, span := otelTracer.Start(ctx, fmt.Sprintf("%s.Count", otelName))
 	defer func() {
-		logrus.Debugf("COUNT prefix=%s startKey=%s => rev=%d, count=%d, err=%v", prefix, startKey, revRet, count, err)
+		logger.Debugf("COUNT prefix=%s startKey=%s => rev=%d, count=%d, err=%v", prefix, startKey, revRet, count, err)
 		span.SetAttributes(
 			attribute.String("prefix", prefix),
 			attribute.String("startKey", startKey),
@@ -222,10 +321,72 @@ func (l *LogStructured) Count(ctx context.Context, prefix, startKey string, revi
 	return rev, count, nil
 }
 
+func (l *LogStructured) ListRange(ctx context.Context, start, end string, limit, revision int64, opts server.RangeOptions) (revRet int64, kvRet []*server.KeyValue, errRet error) {
+	ctx, span := otelTracer.Start(ctx, fmt.Sprintf("%s.ListRange", otelName))
+	defer func() {
+		logger.Debugf("LIST RANGE start=%s, end=%s, limit=%d, rev=%d => rev=%d, kvs=%d, err=%v", start, end, limit, revision, revRet, len(kvRet), errRet)
+		span.SetAttributes(
+			attribute.String("start", start),
+			attribute.String("end", end),
+			attribute.Int64("limit", limit),
+			attribute.Int64("revision", revision),
+			attribute.Int64("adjusted-revision", revRet),
+			attribute.Int64("kv-count", int64(len(kvRet))),
+		)
+		span.RecordError(errRet)
+		span.End()
+	}()
+
+	if revision == 0 {
+		// List at the current revision, so that it is consistent
+		// with the revision reported in the response.
+		currentRev, err := l.log.CurrentRevision(ctx)
+		if err != nil {
+			return 0, nil, err
+		}
+		revision = currentRev
+	}
+	_, events, err := l.log.ListRange(ctx, start, end, limit, revision, opts)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	kvs := make([]*server.KeyValue, 0, len(events))
+	for _, event := range events {
+		kvs = append(kvs, event.KV)
+	}
+	return revision, kvs, nil
+}
+
+func (l *LogStructured) CountRange(ctx context.Context, start, end string, revision int64, opts server.RangeOptions) (revRet int64, count int64, err error) {
+	ctx, span := otelTracer.Start(ctx, fmt.Sprintf("%s.CountRange", otelName))
+	defer func() {
+		logger.Debugf("COUNT RANGE start=%s, end=%s, rev=%d => rev=%d, count=%d, err=%v", start, end, revision, revRet, count, err)
+		span.SetAttributes(
+			attribute.String("start", start),
+			attribute.String("end", end),
+			attribute.Int64("revision", revision),
+			attribute.Int64("adjusted-revision", revRet),
+			attribute.Int64("count", count),
+		)
+		span.RecordError(err)
+		span.End()
+	}()
+
+	rev, count, err := l.log.CountRange(ctx, start, end, revision, opts)
+	if err != nil {
+		return 0, 0, err
+	}
+	if revision != 0 {
+		rev = revision
+	}
+	return rev, count, nil
+}
+
 func (l *LogStructured) Update(ctx context.Context, key string, value []byte, revision, lease int64) (revRet int64, updateRet bool, errRet error) {
 	ctx, span := otelTracer.Start(ctx, fmt.Sprintf("%s.Update", otelName))
 	defer func() {
-		logrus.Debugf("UPDATE %s, value=%d, rev=%d, lease=%v => rev=%d, updated=%v, err=%v", key, len(value), revision, lease, revRet, updateRet, errRet)
+		logger.Debugf("UPDATE %s, value=%d, rev=%d, lease=%v => rev=%d, updated=%v, err=%v", key, len(value), revision, lease, revRet, updateRet, errRet)
 		span.SetAttributes(
 			attribute.String("key", key),
 			attribute.Int64("revision", revision),
@@ -236,6 +397,9 @@ func (l *LogStructured) Update(ctx context.Context, key string, value []byte, re
 		)
 		span.End()
 	}()
+	if err := l.checkLease(ctx, lease); err != nil {
+		return 0, false, err
+	}
 	return l.log.Update(ctx, key, value, revision, lease)
 }
 
@@ -250,7 +414,7 @@ func (l *LogStructured) ttlEvents(ctx context.Context) chan *server.Event {
 		rev, events, err := l.log.List(ctx, "/", "", 1000, 0, false)
 		for len(events) > 0 {
 			if err != nil {
-				logrus.Errorf("failed to read old events for ttl: %v", err)
+				logger.Errorf("failed to read old events for ttl: %v", err)
 				return
 			}
 
@@ -271,11 +435,11 @@ func (l *LogStructured) ttlEvents(ctx context.Context) chan *server.Event {
 	go func() {
 		defer l.wg.Done()
 
+		// All the events are forwarded, so that keys are
+		// detached from their leases when updated or deleted.
 		for events := range l.log.Watch(ctx, "/") {
 			for _, event := range events {
-				if event.KV.Lease > 0 {
-					result <- event
-				}
+				result <- event
 			}
 		}
 
@@ -287,25 +451,8 @@ func (l *LogStructured) ttlEvents(ctx context.Context) chan *server.Event {
 	return result
 }
 
-func (l *LogStructured) ttl(ctx context.Context) {
-	// very naive TTL support
-	mutex := &sync.Mutex{}
-	for event := range l.ttlEvents(ctx) {
-		go func(event *server.Event) {
-			select {
-			case <-ctx.Done():
-				return
-			case <-time.After(time.Duration(event.KV.Lease) * time.Second):
-			}
-			mutex.Lock()
-			l.Delete(ctx, event.KV.Key, event.KV.ModRevision)
-			mutex.Unlock()
-		}(event)
-	}
-}
-
 func (l *LogStructured) Watch(ctx context.Context, prefix string, revision int64) <-chan []*server.Event {
-	logrus.Debugf("WATCH %s, revision=%d", prefix, revision)
+	logger.Debugf("WATCH %s, revision=%d", prefix, revision)
 	ctx, span := otelTracer.Start(ctx, fmt.Sprintf("%s.Watch", otelName))
 	defer span.End()
 	span.SetAttributes(
@@ -324,16 +471,15 @@ func (l *LogStructured) Watch(ctx context.Context, prefix string, revision int64
 
 	result := make(chan []*server.Event, 100)
 
-	rev, kvs, err := l.log.After(ctx, prefix, revision, 0)
+	rev, kvs, err := l.log.After(ctx, prefix, revision, catchUpPageSize)
 	if err != nil {
-		logrus.Errorf("failed to list %s for revision %d", prefix, revision)
 		msg := fmt.Sprintf("failed to list %s for revision %d", prefix, revision)
 		span.AddEvent(msg)
-		logrus.Errorf(msg)
+		logger.WithError(err).WithField("revision", revision).Errorf("failed to list %s", prefix)
 		cancel()
 	}
 
-	logrus.Debugf("WATCH LIST key=%s rev=%d => rev=%d kvs=%d", prefix, revision, rev, len(kvs))
+	logger.Debugf("WATCH LIST key=%s rev=%d => rev=%d kvs=%d", prefix, revision, rev, len(kvs))
 	span.SetAttributes(attribute.Int64("current-revision", rev), attribute.Int64("kvs-count", int64(len(kvs))))
 
 	l.wg.Add(1)
@@ -341,11 +487,20 @@ func (l *LogStructured) Watch(ctx context.Context, prefix string, revision int64
 		defer l.wg.Done()
 
 		lastRevision := revision
-		if len(kvs) > 0 {
-			lastRevision = rev
+		// Stream the backlog page by page, until the last page, which
+		// is complete up to the current revision.
+		for err == nil && len(kvs) == catchUpPageSize {
+			result <- kvs
+			lastRevision = kvs[len(kvs)-1].KV.ModRevision
+			rev, kvs, err = l.log.After(ctx, prefix, lastRevision, catchUpPageSize)
+			if err != nil {
+				logger.WithError(err).WithField("revision", lastRevision).Errorf("failed to list %s", prefix)
+				cancel()
+			}
 		}
 
 		if len(kvs) > 0 {
+			lastRevision = rev
 			result <- kvs
 		}
 
@@ -371,3 +526,76 @@ func filter(events []*server.Event, rev int64) []*server.Event {
 func (l *LogStructured) DbSize(ctx context.Context) (int64, error) {
 	return l.log.DbSize(ctx)
 }
+
+func (l *LogStructured) DbFileSize(ctx context.Context) (int64, error) {
+	return l.log.DbFileSize(ctx)
+}
+
+func (l *LogStructured) CurrentRevision(ctx context.Context) (int64, error) {
+	return l.log.CurrentRevision(ctx)
+}
+
+func (l *LogStructured) CompactRevision(ctx context.Context, prefix string) (int64, error) {
+	return l.log.CompactRevision(ctx, prefix)
+}
+
+func (l *LogStructured) ReadBarrier(ctx context.Context) error {
+	return l.log.ReadBarrier(ctx)
+}
+
+func (l *LogStructured) CheckPollLoop() error {
+	return l.log.CheckPollLoop()
+}
+
+// Txn runs f in a single transaction of the log. Leases are checked before
+// they are attached to keys, as for single writes.
+func (l *LogStructured) Txn(ctx context.Context, f func(tx server.Transaction) error) (err error) {
+	ctx, span := otelTracer.Start(ctx, fmt.Sprintf("%s.Txn", otelName))
+	defer func() {
+		logger.Debugf("TXN => err=%v", err)
+		span.RecordError(err)
+		span.End()
+	}()
+	return l.log.Txn(ctx, func(tx server.Transaction) error {
+		return f(&leaseCheckedTx{Transaction: tx, l: l})
+	})
+}
+
+// leaseCheckedTx refuses the writes attaching keys to unknown leases.
+// Leases are loaded in the transaction, as the database may not serve
+// other connections until it ends.
+type leaseCheckedTx struct {
+	server.Transaction
+	l *LogStructured
+}
+
+func (t *leaseCheckedTx) checkLease(ctx context.Context, id int64) error {
+	if id < minLeaseID {
+		return nil
+	}
+	if _, ok := t.l.leases.get(id); ok {
+		return nil
+	}
+	checkpoint, err := t.Transaction.Lease(ctx, id)
+	if err != nil {
+		return err
+	} else if checkpoint == nil {
+		return server.ErrLeaseNotFound
+	}
+	t.l.leases.add(checkpoint, time.Now())
+	return nil
+}
+
+func (t *leaseCheckedTx) Create(ctx context.Context, key string, value []byte, lease int64) (int64, bool, error) {
+	if err := t.checkLease(ctx, lease); err != nil {
+		return 0, false, err
+	}
+	return t.Transaction.Create(ctx, key, value, lease)
+}
+
+func (t *leaseCheckedTx) Update(ctx context.Context, key string, value []byte, revision, lease int64) (int64, bool, error) {
+	if err := t.checkLease(ctx, lease); err != nil {
+		return 0, false, err
+	}
+	return t.Transaction.Update(ctx, key, value, revision, lease)
+}
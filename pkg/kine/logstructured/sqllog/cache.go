@@ -0,0 +1,89 @@
+package sqllog
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/canonical/k8s-dqlite/pkg/kine/server"
+)
+
+// eventCache is a ring buffer of the most recent events polled from the
+// log. As in the watchable store of etcd, watches catching up on recent
+// revisions are served from memory instead of querying the database.
+type eventCache struct {
+	mu     sync.RWMutex
+	events []*server.Event
+	// head is the index of the oldest event, and size the number of
+	// cached events.
+	head, size int
+	// All the events after from, up to to, are cached. The range is
+	// empty until polling starts.
+	from, to int64
+}
+
+func newEventCache(capacity int) *eventCache {
+	return &eventCache{
+		events: make([]*server.Event, capacity),
+		from:   1,
+	}
+}
+
+// reset empties the cache, which then holds the events polled after
+// revision.
+func (c *eventCache) reset(revision int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	clear(c.events)
+	c.head, c.size = 0, 0
+	c.from, c.to = revision, revision
+}
+
+// add caches the events polled up to revision, evicting the oldest ones
+// once the cache is full.
+func (c *eventCache) add(events []*server.Event, revision int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.events) == 0 {
+		return
+	}
+	for _, event := range events {
+		if c.size == len(c.events) {
+			c.from = c.events[c.head].KV.ModRevision
+			c.head = (c.head + 1) % len(c.events)
+			c.size--
+		}
+		c.events[(c.head+c.size)%len(c.events)] = event
+		c.size++
+	}
+	c.to = revision
+}
+
+// after returns up to limit cached events after revision, matching the
+// prefix as watches do, and the last polled revision. It returns false if
+// some of the events after revision are not cached.
+func (c *eventCache) after(prefix string, revision, limit int64) ([]*server.Event, int64, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if len(c.events) == 0 || revision < c.from || revision > c.to {
+		return nil, 0, false
+	}
+
+	checkPrefix := strings.HasSuffix(prefix, "/")
+	var result []*server.Event
+	for i := 0; i < c.size; i++ {
+		event := c.events[(c.head+i)%len(c.events)]
+		if event.KV.ModRevision <= revision {
+			continue
+		}
+		if (checkPrefix && strings.HasPrefix(event.KV.Key, prefix)) || event.KV.Key == prefix {
+			result = append(result, event)
+			if limit > 0 && int64(len(result)) == limit {
+				break
+			}
+		}
+	}
+	return result, c.to, true
+}
@@ -0,0 +1,62 @@
+package sqllog
+
+import (
+	"testing"
+
+	"github.com/canonical/k8s-dqlite/pkg/kine/server"
+)
+
+func cacheEvent(key string, revision int64) *server.Event {
+	return &server.Event{KV: &server.KeyValue{Key: key, ModRevision: revision}}
+}
+
+func TestEventCache(t *testing.T) {
+	cache := newEventCache(3)
+	if _, _, ok := cache.after("/a/", 0, 0); ok {
+		t.Fatal("expected a miss before polling starts")
+	}
+
+	cache.reset(10)
+	cache.add([]*server.Event{cacheEvent("/a/1", 11), cacheEvent("/b/1", 12)}, 12)
+	cache.add(nil, 13)
+	cache.add([]*server.Event{cacheEvent("/a/2", 14), cacheEvent("/a/3", 15)}, 15)
+
+	tests := []struct {
+		name     string
+		prefix   string
+		revision int64
+		limit    int64
+		ok       bool
+		expected []int64
+	}{
+		{name: "evicted", prefix: "/a/", revision: 10},
+		{name: "not polled", prefix: "/a/", revision: 16},
+		{name: "oldest", prefix: "/", revision: 11, ok: true, expected: []int64{12, 14, 15}},
+		{name: "prefix", prefix: "/a/", revision: 11, ok: true, expected: []int64{14, 15}},
+		{name: "key", prefix: "/a/2", revision: 11, ok: true, expected: []int64{14}},
+		{name: "limit", prefix: "/", revision: 12, limit: 1, ok: true, expected: []int64{14}},
+		{name: "up to date", prefix: "/", revision: 15, ok: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events, polled, ok := cache.after(tt.prefix, tt.revision, tt.limit)
+			if ok != tt.ok {
+				t.Fatalf("expected ok %v, got %v", tt.ok, ok)
+			}
+			if !ok {
+				return
+			}
+			if polled != 15 {
+				t.Errorf("expected polled revision 15, got %d", polled)
+			}
+			if len(events) != len(tt.expected) {
+				t.Fatalf("expected %d events, got %d", len(tt.expected), len(events))
+			}
+			for i, event := range events {
+				if event.KV.ModRevision != tt.expected[i] {
+					t.Errorf("expected revision %d, got %d", tt.expected[i], event.KV.ModRevision)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,52 @@
+package sqllog
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/canonical/k8s-dqlite/pkg/kine/compression"
+	"github.com/canonical/k8s-dqlite/pkg/kine/server"
+)
+
+// chunkReader reads back the values stored in chunks.
+type chunkReader interface {
+	ReadChunks(ctx context.Context, key string, id int64) ([]byte, error)
+}
+
+// rowsToEvents is RowsToEvents, with the values stored in chunks read back.
+func (s *SQLLog) rowsToEvents(ctx context.Context, rows *sql.Rows) ([]*server.Event, error) {
+	events, err := RowsToEvents(rows)
+	if err != nil {
+		return nil, err
+	}
+	if err := readChunks(ctx, s.d, events); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// readChunks replaces the references to the values stored in chunks of the
+// events with the values, read back from r.
+func readChunks(ctx context.Context, r chunkReader, events []*server.Event) error {
+	for _, event := range events {
+		for _, kv := range []*server.KeyValue{event.KV, event.PrevKV} {
+			if kv == nil {
+				continue
+			}
+			id, ok := compression.ParseChunkReference(kv.Value)
+			if !ok {
+				continue
+			}
+			value, err := r.ReadChunks(ctx, kv.Key, id)
+			if err != nil {
+				return err
+			}
+			// A reference without chunks can only be a raw value
+			// stored without escaping.
+			if value != nil {
+				kv.Value = compression.Decode(value)
+			}
+		}
+	}
+	return nil
+}
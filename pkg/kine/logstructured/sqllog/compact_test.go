@@ -0,0 +1,185 @@
+package sqllog
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/canonical/k8s-dqlite/pkg/kine/server"
+)
+
+// compactDialect records the revisions passed to Compact.
+type compactDialect struct {
+	Dialect
+
+	batchSize     int64
+	batchInterval time.Duration
+	revisions     []int64
+	times         []time.Time
+}
+
+func (d *compactDialect) GetCompactBatchSize() int64 { return d.batchSize }
+
+func (d *compactDialect) GetCompactBatchInterval() time.Duration { return d.batchInterval }
+
+func (d *compactDialect) Compact(_ context.Context, revision int64) error {
+	d.revisions = append(d.revisions, revision)
+	d.times = append(d.times, time.Now())
+	return nil
+}
+
+func TestCompactBatches(t *testing.T) {
+	tests := []struct {
+		name      string
+		start     int64
+		target    int64
+		batchSize int64
+		revisions []int64
+	}{
+		{
+			name:      "nothing to compact",
+			start:     100,
+			target:    100,
+			batchSize: 1000,
+		},
+		{
+			name:      "target behind start",
+			start:     100,
+			target:    50,
+			batchSize: 1000,
+		},
+		{
+			name:      "single batch",
+			start:     0,
+			target:    500,
+			batchSize: 1000,
+			revisions: []int64{500},
+		},
+		{
+			name:      "exact batches",
+			start:     0,
+			target:    3000,
+			batchSize: 1000,
+			revisions: []int64{1000, 2000, 3000},
+		},
+		{
+			name:      "last batch shorter",
+			start:     10,
+			target:    2500,
+			batchSize: 1000,
+			revisions: []int64{1010, 2010, 2500},
+		},
+		{
+			name:      "batches larger than the default",
+			start:     0,
+			target:    12000,
+			batchSize: 5000,
+			revisions: []int64{5000, 10000, 12000},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &compactDialect{batchSize: tt.batchSize}
+			s := &SQLLog{d: d}
+
+			if err := s.compactBatches(context.Background(), d, tt.start, tt.target); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(d.revisions, tt.revisions) {
+				t.Fatalf("expected batches up to %v, got %v", tt.revisions, d.revisions)
+			}
+		})
+	}
+}
+
+func TestCompactBatchInterval(t *testing.T) {
+	const interval = 50 * time.Millisecond
+
+	d := &compactDialect{batchSize: 10, batchInterval: interval}
+	s := &SQLLog{d: d}
+
+	start := time.Now()
+	if err := s.compactBatches(context.Background(), d, 0, 30); err != nil {
+		t.Fatal(err)
+	}
+	if len(d.times) != 3 {
+		t.Fatalf("expected 3 batches, got %d", len(d.times))
+	}
+	// No pause before the first batch, nor after the last one.
+	if elapsed := d.times[0].Sub(start); elapsed >= interval {
+		t.Fatalf("expected the first batch to run right away, ran after %v", elapsed)
+	}
+	for i := 1; i < len(d.times); i++ {
+		if pause := d.times[i].Sub(d.times[i-1]); pause < interval {
+			t.Fatalf("expected a pause of at least %v before batch %d, got %v", interval, i, pause)
+		}
+	}
+
+	t.Run("cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		d := &compactDialect{batchSize: 10, batchInterval: time.Hour}
+		s := &SQLLog{d: d}
+		if err := s.compactBatches(ctx, d, 0, 30); err != context.Canceled {
+			t.Fatalf("expected %v, got %v", context.Canceled, err)
+		}
+		if len(d.revisions) != 1 {
+			t.Fatalf("expected a single batch before cancellation, got %d", len(d.revisions))
+		}
+	})
+}
+
+// revisionCompactor compacts from its compact revision, as a table would.
+type revisionCompactor struct {
+	compactDialect
+
+	compact, current int64
+}
+
+func (c *revisionCompactor) GetCompactRevision(context.Context) (int64, int64, error) {
+	return c.compact, c.current, nil
+}
+
+func (c *revisionCompactor) Compact(ctx context.Context, revision int64) error {
+	c.compact = revision
+	return c.compactDialect.Compact(ctx, revision)
+}
+
+func TestCompactToEvents(t *testing.T) {
+	ctx := context.Background()
+	d := &revisionCompactor{compactDialect: compactDialect{batchSize: 1000}, compact: 10, current: 100}
+	events := &revisionCompactor{compactDialect: compactDialect{batchSize: 1000}, compact: 50, current: 100}
+	s := &SQLLog{d: d, events: events}
+
+	// The events share the revisions, so both are compacted up to the same
+	// revision.
+	compactRevision, err := s.CompactTo(ctx, 80)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if compactRevision != 80 {
+		t.Fatalf("expected compact revision 80, got %d", compactRevision)
+	}
+	if !reflect.DeepEqual(d.revisions, []int64{80}) || !reflect.DeepEqual(events.revisions, []int64{80}) {
+		t.Fatalf("expected both tables compacted up to 80, got %v and %v", d.revisions, events.revisions)
+	}
+
+	// The revisions already compacted are left as is.
+	events.compact = 90
+	if compactRevision, err = s.CompactTo(ctx, 85); err != nil {
+		t.Fatal(err)
+	}
+	if compactRevision != 85 {
+		t.Fatalf("expected compact revision 85, got %d", compactRevision)
+	}
+	if !reflect.DeepEqual(d.revisions, []int64{80, 85}) || !reflect.DeepEqual(events.revisions, []int64{80}) {
+		t.Fatalf("expected only the kine table compacted up to 85, got %v and %v", d.revisions, events.revisions)
+	}
+
+	if _, err := s.CompactTo(ctx, 120); err != server.ErrFutureRev {
+		t.Fatalf("expected %v, got %v", server.ErrFutureRev, err)
+	}
+}
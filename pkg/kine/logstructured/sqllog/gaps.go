@@ -0,0 +1,67 @@
+package sqllog
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+)
+
+// revisionCheckInterval is the time between two checks of the revisions
+// polled for gaps.
+const revisionCheckInterval = time.Minute
+
+var metricsRevisionGaps = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "k8s_dqlite_revision_gaps_total",
+	Help: "Total number of unexpected gaps (missing) and repeated revisions (duplicate) found in the revisions by kind",
+}, []string{"kind"})
+
+func init() {
+	prometheus.MustRegister(metricsRevisionGaps)
+}
+
+// checkRevisions periodically checks the revisions the poll loop went past
+// for gaps. The poll loop fills the gaps it waited for, so the gaps left
+// are revisions it gave up on, or rows lost since.
+func (s *SQLLog) checkRevisions(start int64) {
+	t := time.NewTicker(revisionCheckInterval)
+	defer t.Stop()
+
+	checked := start
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-t.C:
+		}
+
+		end := s.polled.Load()
+		if end <= checked {
+			continue
+		}
+		if err := s.checkRevisionGaps(s.ctx, checked, end); err != nil {
+			logger.WithError(err).Warning("revision gap check failed")
+			continue
+		}
+		checked = end
+	}
+}
+
+// checkRevisionGaps reports the gaps in the revisions in (start, end].
+func (s *SQLLog) checkRevisionGaps(ctx context.Context, start, end int64) error {
+	rows, err := s.d.RevisionGaps(ctx, start, end)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var before, after int64
+		if err := rows.Scan(&before, &after); err != nil {
+			return err
+		}
+		metricsRevisionGaps.WithLabelValues("missing").Inc()
+		logger.WithFields(logrus.Fields{"before": before, "after": after, "missing": after - before - 1}).Error("revisions missing")
+	}
+	return rows.Err()
+}
@@ -0,0 +1,156 @@
+package sqllog
+
+import (
+	"sync"
+	"time"
+
+	"github.com/canonical/k8s-dqlite/pkg/kine/server"
+)
+
+const (
+	// periodicSamples is the number of revision samples taken
+	// in each retention window by the periodic policy.
+	periodicSamples = 10
+	// maxPeriodicInterval is the maximum interval between two
+	// compactions of the periodic policy.
+	maxPeriodicInterval = time.Hour
+)
+
+// retentionPolicy decides which revisions can be removed by compaction.
+// Modelled after the etcd auto-compaction modes.
+type retentionPolicy interface {
+	// Interval is the time between two compaction runs.
+	Interval() time.Duration
+	// Target returns the revision up to which the database can be
+	// compacted, given the current revision of the database.
+	Target(now time.Time, current int64) int64
+}
+
+// retentionSettings are the compaction settings of the retention policies,
+// as configured in the dialect.
+type retentionSettings interface {
+	GetCompactInterval() time.Duration
+	GetCompactRetentionDuration() time.Duration
+	GetCompactRetentionRevisions() int64
+}
+
+// compactionSettings are the retention settings changed while the log runs.
+type compactionSettings server.CompactionConfig
+
+func (c compactionSettings) GetCompactInterval() time.Duration { return c.Interval }
+
+func (c compactionSettings) GetCompactRetentionDuration() time.Duration { return c.RetentionDuration }
+
+func (c compactionSettings) GetCompactRetentionRevisions() int64 { return c.RetentionRevisions }
+
+// newRetentionPolicy builds the configured retention policy. If both a
+// retention duration and a retention revision count are given, the
+// revisions retained by either policy are kept. With none of them, only the
+// last SupersededCount revisions are kept.
+func newRetentionPolicy(d retentionSettings) retentionPolicy {
+	var policies multiPolicy
+	if retention := d.GetCompactRetentionDuration(); retention > 0 {
+		policies = append(policies, newPeriodicPolicy(retention))
+	}
+	if retention := d.GetCompactRetentionRevisions(); retention > 0 {
+		policies = append(policies, &revisionPolicy{
+			interval:  d.GetCompactInterval(),
+			retention: retention,
+		})
+	}
+
+	switch len(policies) {
+	case 0:
+		return &revisionPolicy{
+			interval:  d.GetCompactInterval(),
+			retention: SupersededCount,
+		}
+	case 1:
+		return policies[0]
+	default:
+		return policies
+	}
+}
+
+// revisionPolicy retains a fixed number of revisions.
+type revisionPolicy struct {
+	interval  time.Duration
+	retention int64
+}
+
+func (p *revisionPolicy) Interval() time.Duration { return p.interval }
+
+func (p *revisionPolicy) Target(_ time.Time, current int64) int64 {
+	return current - p.retention
+}
+
+type revisionSample struct {
+	time     time.Time
+	revision int64
+}
+
+// periodicPolicy retains the revisions created in the last retention window.
+// The current revision is sampled at every run; the database is compacted up
+// to the newest sample older than the retention window.
+type periodicPolicy struct {
+	retention time.Duration
+
+	mu      sync.Mutex
+	samples []revisionSample
+}
+
+func newPeriodicPolicy(retention time.Duration) *periodicPolicy {
+	return &periodicPolicy{retention: retention}
+}
+
+func (p *periodicPolicy) Interval() time.Duration {
+	interval := p.retention / periodicSamples
+	if interval < time.Second {
+		return time.Second
+	}
+	if interval > maxPeriodicInterval {
+		return maxPeriodicInterval
+	}
+	return interval
+}
+
+func (p *periodicPolicy) Target(now time.Time, current int64) int64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.samples = append(p.samples, revisionSample{time: now, revision: current})
+
+	deadline := now.Add(-p.retention)
+	target, i := int64(0), 0
+	for ; i < len(p.samples) && !p.samples[i].time.After(deadline); i++ {
+		target = p.samples[i].revision
+	}
+	if i > 0 {
+		// Keep the last expired sample, as the target of the next runs.
+		p.samples = p.samples[i-1:]
+	}
+	return target
+}
+
+// multiPolicy retains what is retained by any of its policies.
+type multiPolicy []retentionPolicy
+
+func (p multiPolicy) Interval() time.Duration {
+	interval := p[0].Interval()
+	for _, policy := range p[1:] {
+		if v := policy.Interval(); v < interval {
+			interval = v
+		}
+	}
+	return interval
+}
+
+func (p multiPolicy) Target(now time.Time, current int64) int64 {
+	target := p[0].Target(now, current)
+	for _, policy := range p[1:] {
+		if v := policy.Target(now, current); v < target {
+			target = v
+		}
+	}
+	return target
+}
@@ -0,0 +1,296 @@
+package sqllog
+
+import (
+	"testing"
+	"time"
+
+	"github.com/canonical/k8s-dqlite/pkg/kine/server"
+)
+
+// retentionDialect fakes the compaction settings of a dialect.
+type retentionDialect struct {
+	Dialect
+
+	interval  time.Duration
+	duration  time.Duration
+	revisions int64
+}
+
+func (d *retentionDialect) GetCompactInterval() time.Duration { return d.interval }
+
+func (d *retentionDialect) GetCompactRetentionDuration() time.Duration { return d.duration }
+
+func (d *retentionDialect) GetCompactRetentionRevisions() int64 { return d.revisions }
+
+// fixedPolicy always returns the same interval and target.
+type fixedPolicy struct {
+	interval time.Duration
+	target   int64
+}
+
+func (p *fixedPolicy) Interval() time.Duration { return p.interval }
+
+func (p *fixedPolicy) Target(time.Time, int64) int64 { return p.target }
+
+func TestNewRetentionPolicy(t *testing.T) {
+	tests := []struct {
+		name     string
+		dialect  *retentionDialect
+		expected retentionPolicy
+	}{
+		{
+			name:     "default",
+			dialect:  &retentionDialect{interval: time.Minute},
+			expected: &revisionPolicy{interval: time.Minute, retention: SupersededCount},
+		},
+		{
+			name:     "revisions",
+			dialect:  &retentionDialect{interval: time.Minute, revisions: 1000},
+			expected: &revisionPolicy{interval: time.Minute, retention: 1000},
+		},
+		{
+			name:     "duration",
+			dialect:  &retentionDialect{interval: time.Minute, duration: time.Hour},
+			expected: newPeriodicPolicy(time.Hour),
+		},
+		{
+			name:    "duration and revisions",
+			dialect: &retentionDialect{interval: time.Minute, duration: time.Hour, revisions: 1000},
+			expected: multiPolicy{
+				newPeriodicPolicy(time.Hour),
+				&revisionPolicy{interval: time.Minute, retention: 1000},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := newRetentionPolicy(tt.dialect)
+			switch expected := tt.expected.(type) {
+			case *revisionPolicy:
+				if p, ok := policy.(*revisionPolicy); !ok || *p != *expected {
+					t.Errorf("expected %+v, got %+v", expected, policy)
+				}
+			case *periodicPolicy:
+				if p, ok := policy.(*periodicPolicy); !ok || p.retention != expected.retention {
+					t.Errorf("expected periodic policy retaining %v, got %+v", expected.retention, policy)
+				}
+			case multiPolicy:
+				if p, ok := policy.(multiPolicy); !ok || len(p) != len(expected) {
+					t.Errorf("expected %d policies, got %+v", len(expected), policy)
+				}
+			}
+		})
+	}
+}
+
+func TestRevisionPolicy(t *testing.T) {
+	tests := []struct {
+		name      string
+		retention int64
+		current   int64
+		target    int64
+	}{
+		{
+			name:      "more revisions than retained",
+			retention: 100,
+			current:   1000,
+			target:    900,
+		},
+		{
+			name:      "as many revisions as retained",
+			retention: 100,
+			current:   100,
+			target:    0,
+		},
+		{
+			name:      "fewer revisions than retained",
+			retention: 100,
+			current:   10,
+			target:    -90,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := &revisionPolicy{interval: time.Minute, retention: tt.retention}
+			if interval := policy.Interval(); interval != time.Minute {
+				t.Errorf("expected interval %v, got %v", time.Minute, interval)
+			}
+			if target := policy.Target(time.Now(), tt.current); target != tt.target {
+				t.Errorf("expected target %d, got %d", tt.target, target)
+			}
+		})
+	}
+}
+
+func TestPeriodicPolicyInterval(t *testing.T) {
+	tests := []struct {
+		retention time.Duration
+		interval  time.Duration
+	}{
+		{retention: time.Second, interval: time.Second},
+		{retention: 9 * time.Second, interval: time.Second},
+		{retention: 10 * time.Second, interval: time.Second},
+		{retention: 11 * time.Second, interval: 1100 * time.Millisecond},
+		{retention: 5 * time.Minute, interval: 30 * time.Second},
+		{retention: 10 * time.Hour, interval: time.Hour},
+		{retention: 11 * time.Hour, interval: time.Hour},
+		{retention: 24 * time.Hour, interval: time.Hour},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.retention.String(), func(t *testing.T) {
+			if interval := newPeriodicPolicy(tt.retention).Interval(); interval != tt.interval {
+				t.Errorf("expected interval %v, got %v", tt.interval, interval)
+			}
+		})
+	}
+}
+
+func TestPeriodicPolicyTarget(t *testing.T) {
+	type sample struct {
+		after   time.Duration
+		current int64
+		target  int64
+		// samples is the number of samples kept after the run.
+		samples int
+	}
+
+	tests := []struct {
+		name    string
+		samples []sample
+	}{
+		{
+			name: "within the first window",
+			samples: []sample{
+				{after: 0, current: 10, target: 0, samples: 1},
+				{after: 5 * time.Second, current: 20, target: 0, samples: 2},
+				{after: 9 * time.Second, current: 30, target: 0, samples: 3},
+			},
+		},
+		{
+			name: "sample exactly at the deadline",
+			samples: []sample{
+				{after: 0, current: 10, target: 0, samples: 1},
+				{after: 10 * time.Second, current: 20, target: 10, samples: 2},
+			},
+		},
+		{
+			name: "newest expired sample",
+			samples: []sample{
+				{after: 0, current: 10, target: 0, samples: 1},
+				{after: 5 * time.Second, current: 20, target: 0, samples: 2},
+				{after: 16 * time.Second, current: 30, target: 20, samples: 2},
+				// No new sample expired, the previous target is kept.
+				{after: 17 * time.Second, current: 40, target: 20, samples: 3},
+				{after: 40 * time.Second, current: 50, target: 40, samples: 2},
+			},
+		},
+		{
+			name: "idle database",
+			samples: []sample{
+				{after: 0, current: 10, target: 0, samples: 1},
+				{after: time.Minute, current: 10, target: 10, samples: 2},
+				{after: 2 * time.Minute, current: 10, target: 10, samples: 2},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start := time.Now()
+			policy := newPeriodicPolicy(10 * time.Second)
+			for i, s := range tt.samples {
+				if target := policy.Target(start.Add(s.after), s.current); target != s.target {
+					t.Errorf("run %d: expected target %d, got %d", i, s.target, target)
+				}
+				if samples := len(policy.samples); samples != s.samples {
+					t.Errorf("run %d: expected %d samples, got %d", i, s.samples, samples)
+				}
+			}
+		})
+	}
+}
+
+func TestMultiPolicy(t *testing.T) {
+	tests := []struct {
+		name     string
+		policies multiPolicy
+		interval time.Duration
+		target   int64
+	}{
+		{
+			name: "single policy",
+			policies: multiPolicy{
+				&fixedPolicy{interval: time.Minute, target: 100},
+			},
+			interval: time.Minute,
+			target:   100,
+		},
+		{
+			name: "minimum of the first policy",
+			policies: multiPolicy{
+				&fixedPolicy{interval: time.Second, target: 50},
+				&fixedPolicy{interval: time.Minute, target: 100},
+			},
+			interval: time.Second,
+			target:   50,
+		},
+		{
+			name: "minimum of the last policy",
+			policies: multiPolicy{
+				&fixedPolicy{interval: time.Minute, target: 100},
+				&fixedPolicy{interval: time.Hour, target: 200},
+				&fixedPolicy{interval: time.Second, target: 50},
+			},
+			interval: time.Second,
+			target:   50,
+		},
+		{
+			name: "nothing to compact",
+			policies: multiPolicy{
+				&fixedPolicy{interval: time.Minute, target: 100},
+				&fixedPolicy{interval: time.Minute, target: 0},
+			},
+			interval: time.Minute,
+			target:   0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if interval := tt.policies.Interval(); interval != tt.interval {
+				t.Errorf("expected interval %v, got %v", tt.interval, interval)
+			}
+			if target := tt.policies.Target(time.Now(), 1000); target != tt.target {
+				t.Errorf("expected target %d, got %d", tt.target, target)
+			}
+		})
+	}
+}
+
+func TestSetCompaction(t *testing.T) {
+	d := &retentionDialect{interval: time.Minute}
+	s := &SQLLog{
+		d:                d,
+		retention:        newRetentionPolicy(d),
+		retentionChanged: make(chan struct{}, 1),
+	}
+
+	s.SetCompaction(server.CompactionConfig{RetentionRevisions: 1000})
+	expected := revisionPolicy{interval: time.Minute, retention: 1000}
+	if p, ok := s.retentionPolicy().(*revisionPolicy); !ok || *p != expected {
+		t.Errorf("expected %+v, got %+v", expected, s.retentionPolicy())
+	}
+	select {
+	case <-s.retentionChanged:
+	default:
+		t.Error("expected the compaction loop to be woken up")
+	}
+
+	s.SetCompaction(server.CompactionConfig{Interval: time.Hour, RetentionDuration: time.Hour})
+	if p, ok := s.retentionPolicy().(*periodicPolicy); !ok || p.retention != time.Hour {
+		t.Errorf("expected periodic policy retaining 1h, got %+v", s.retentionPolicy())
+	}
+}
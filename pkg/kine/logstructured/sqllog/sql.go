@@ -7,10 +7,13 @@ import (
 	"fmt"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/canonical/k8s-dqlite/pkg/kine/broadcaster"
+	"github.com/canonical/k8s-dqlite/pkg/kine/compression"
 	"github.com/canonical/k8s-dqlite/pkg/kine/server"
+	"github.com/canonical/k8s-dqlite/pkg/logging"
 	"github.com/sirupsen/logrus"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
@@ -18,16 +21,26 @@ import (
 	"go.opentelemetry.io/otel/trace"
 )
 
+var logger = logging.Component(logging.Watch)
+
 const (
-	SupersededCount  = 100
-	compactBatchSize = 1000
-	otelName         = "sqllog"
+	SupersededCount = 100
+	otelName        = "sqllog"
+
+	// fallbackPollInterval is the minimum poll interval of the dialects
+	// that notify their commits.
+	fallbackPollInterval = 5 * time.Second
+	// commitSettleDelay is the delay before polling again when a commit
+	// was notified but its events were not visible yet.
+	commitSettleDelay = 10 * time.Millisecond
 )
 
 var (
-	otelTracer trace.Tracer
-	otelMeter  metric.Meter
-	compactCnt metric.Int64Counter
+	otelTracer        trace.Tracer
+	otelMeter         metric.Meter
+	compactCnt        metric.Int64Counter
+	watchCacheHitCnt  metric.Int64Counter
+	watchCacheMissCnt metric.Int64Counter
 )
 
 func init() {
@@ -37,7 +50,15 @@ func init() {
 
 	compactCnt, err = otelMeter.Int64Counter(fmt.Sprintf("%s.compact", otelName), metric.WithDescription("Number of compact requests"))
 	if err != nil {
-		logrus.WithError(err).Warning("Otel failed to create create counter")
+		logger.WithError(err).Warning("Otel failed to create create counter")
+	}
+	watchCacheHitCnt, err = otelMeter.Int64Counter(fmt.Sprintf("%s.watch_cache_hit", otelName), metric.WithDescription("Number of watch catch-ups served from the event cache"))
+	if err != nil {
+		logger.WithError(err).Warning("Otel failed to create watch cache hit counter")
+	}
+	watchCacheMissCnt, err = otelMeter.Int64Counter(fmt.Sprintf("%s.watch_cache_miss", otelName), metric.WithDescription("Number of watch catch-ups served from the database"))
+	if err != nil {
+		logger.WithError(err).Warning("Otel failed to create watch cache miss counter")
 	}
 }
 
@@ -47,36 +68,119 @@ type SQLLog struct {
 	ctx         context.Context
 	notify      chan int64
 	wg          sync.WaitGroup
+	cache       *eventCache
+
+	retentionMu sync.Mutex
+	retention   retentionPolicy
+	// retentionChanged wakes the compaction loop up when the retention
+	// policy is replaced.
+	retentionChanged chan struct{}
+	// events compacts the events stored apart, if they are, with the
+	// retention policy eventsRetention.
+	events          Compactor
+	eventsRetention retentionPolicy
+	// lastPoll is the time, in nanoseconds since the epoch, of the last
+	// successful query of the poll loop.
+	lastPoll atomic.Int64
+	// polled is the last revision the poll loop went past.
+	polled atomic.Int64
 }
 
 func New(d Dialect) *SQLLog {
 	l := &SQLLog{
-		d:      d,
-		notify: make(chan int64, 1024),
+		d:         d,
+		notify:    make(chan int64, 1024),
+		retention: newRetentionPolicy(d),
+		cache:     newEventCache(d.GetWatchCacheSize()),
+
+		retentionChanged: make(chan struct{}, 1),
+	}
+	l.broadcaster.QueueSize = d.GetWatchQueueSize()
+	if events := d.EventsCompactor(); events != nil {
+		l.events = events
+		l.eventsRetention = newRetentionPolicy(events)
 	}
 	return l
 }
 
+// Compactor compacts the revisions of a table, on a schedule of its own.
+type Compactor interface {
+	GetCompactRevision(ctx context.Context) (int64, int64, error)
+	Compact(ctx context.Context, revision int64) error
+	GetCompactInterval() time.Duration
+	GetCompactBatchSize() int64
+	GetCompactBatchInterval() time.Duration
+	GetCompactRetentionDuration() time.Duration
+	GetCompactRetentionRevisions() int64
+}
+
 type Dialect interface {
 	ListCurrent(ctx context.Context, prefix, startKey string, limit int64, includeDeleted bool) (*sql.Rows, error)
 	List(ctx context.Context, prefix, startKey string, limit, revision int64, includeDeleted bool) (*sql.Rows, error)
+	ListRange(ctx context.Context, start, end string, limit, revision int64, includeDeleted bool, opts server.RangeOptions) (*sql.Rows, error)
 	CountCurrent(ctx context.Context, prefix, startKey string) (int64, int64, error)
 	Count(ctx context.Context, prefix, startKey string, revision int64) (int64, int64, error)
+	CountRange(ctx context.Context, start, end string, revision int64, opts server.RangeOptions) (int64, int64, error)
 	CurrentRevision(ctx context.Context) (int64, error)
 	AfterPrefix(ctx context.Context, prefix string, rev, limit int64) (*sql.Rows, error)
 	After(ctx context.Context, rev, limit int64) (*sql.Rows, error)
 	Create(ctx context.Context, key string, value []byte, lease int64) (int64, bool, error)
 	Update(ctx context.Context, key string, value []byte, prevRev, lease int64) (int64, bool, error)
 	Delete(ctx context.Context, key string, revision int64) (int64, bool, error)
-	DeleteRevision(ctx context.Context, revision int64) error
 	GetCompactRevision(ctx context.Context) (int64, int64, error)
+	// GetRangeCompactRevision returns the compact and current revisions of
+	// the keys between start and end, which differ from those of the
+	// datastore if the events are compacted apart.
+	GetRangeCompactRevision(ctx context.Context, start, end string) (int64, int64, error)
+	// EventsCompactor returns the compaction of the events stored apart,
+	// or nil if they are stored with the other keys.
+	EventsCompactor() Compactor
+	ReadBarrier(ctx context.Context) error
 	Compact(ctx context.Context, revision int64) error
 	Fill(ctx context.Context, revision int64) error
 	IsFill(key string) bool
 	GetSize(ctx context.Context) (int64, error)
+	GetFileSize(ctx context.Context) (int64, error)
 	GetCompactInterval() time.Duration
+	GetCompactBatchSize() int64
+	GetCompactBatchInterval() time.Duration
+	GetCompactRetentionDuration() time.Duration
+	GetCompactRetentionRevisions() int64
+	GetVacuumInterval() time.Duration
+	GetVacuumFreePages() int64
+	PageStats(ctx context.Context) (pageCount, freePages, pageSize int64, err error)
+	Vacuum(ctx context.Context) (before, after int64, err error)
+	Backup(ctx context.Context, path string) (int64, error)
+	Hash(ctx context.Context, revision int64) (hash uint32, compactRevision, hashRevision int64, err error)
+	History(ctx context.Context, key string) (*sql.Rows, error)
+	KeyStats(ctx context.Context) (*sql.Rows, error)
+	IntegrityCheck(ctx context.Context) ([]string, error)
+	Invariants(ctx context.Context) (*sql.Rows, error)
+	RevisionGaps(ctx context.Context, start, end int64) (*sql.Rows, error)
+	ReadChunks(ctx context.Context, key string, id int64) ([]byte, error)
+	GrantLease(ctx context.Context, id, ttl, expiry int64) error
+	CheckpointLease(ctx context.Context, id, expiry int64) error
+	RevokeLease(ctx context.Context, id int64) error
+	ListLeases(ctx context.Context) (*sql.Rows, error)
+	GetLease(ctx context.Context, id int64) (*sql.Rows, error)
+	ActivateAlarm(ctx context.Context, alarm int32) error
+	DeactivateAlarm(ctx context.Context, alarm int32) error
+	ListAlarms(ctx context.Context) (*sql.Rows, error)
+	Txn(ctx context.Context, f func(tx Tx) error) error
 	GetWatchQueryTimeout() time.Duration
 	GetPollInterval() time.Duration
+	// GetMaxPollInterval returns the interval up to which polling backs
+	// off while no events are found.
+	GetMaxPollInterval() time.Duration
+	// GetWatchCacheSize returns the number of recent events kept in
+	// memory for the watches catching up.
+	GetWatchCacheSize() int
+	// GetWatchQueueSize returns the number of events queued for each
+	// watch before it is canceled for falling behind.
+	GetWatchQueueSize() int
+	// GetCommitNotify returns a channel receiving a value when a
+	// transaction is committed, or nil if commits are not notified.
+	GetCommitNotify() <-chan struct{}
 	Close() error
 }
 
@@ -84,7 +188,7 @@ func (s *SQLLog) Start(ctx context.Context) (err error) {
 	s.ctx = ctx
 	context.AfterFunc(ctx, func() {
 		if err := s.d.Close(); err != nil {
-			logrus.Errorf("cannot close database: %v", err)
+			logger.Errorf("cannot close database: %v", err)
 		}
 	})
 	return s.broadcaster.Start(s.startWatch)
@@ -94,43 +198,18 @@ func (s *SQLLog) Wait() {
 	s.wg.Wait()
 }
 
-func (s *SQLLog) compactStart(ctx context.Context) error {
-	rows, err := s.d.AfterPrefix(ctx, "compact_rev_key", 0, 0)
-	if err != nil {
-		return err
+// CheckPollLoop returns an error if the poll loop has not queried the
+// database for longer than it can wait between two polls, as it is either
+// stuck or failing.
+func (s *SQLLog) CheckPollLoop() error {
+	last := s.lastPoll.Load()
+	if last == 0 {
+		return errors.New("poll loop not started")
 	}
-
-	events, err := RowsToEvents(rows)
-	if err != nil {
-		return err
+	limit := max(s.d.GetPollInterval(), s.d.GetMaxPollInterval(), fallbackPollInterval) + s.d.GetWatchQueryTimeout()
+	if since := time.Since(time.Unix(0, last)); since > limit {
+		return fmt.Errorf("poll loop has not queried the database for %v", since.Round(time.Second))
 	}
-
-	if len(events) == 0 {
-		_, _, err := s.Create(ctx, "compact_rev_key", []byte(""), 0)
-		return err
-	} else if len(events) == 1 {
-		return nil
-	}
-
-	// this is to work around a bug in which we ended up with two compact_rev_key rows
-	maxRev := int64(0)
-	maxID := int64(0)
-	for _, event := range events {
-		if event.PrevKV != nil && event.PrevKV.ModRevision > maxRev {
-			maxRev = event.PrevKV.ModRevision
-			maxID = event.KV.ModRevision
-		}
-	}
-
-	for _, event := range events {
-		if event.KV.ModRevision == maxID {
-			continue
-		}
-		if err := s.d.DeleteRevision(ctx, event.KV.ModRevision); err != nil {
-			return err
-		}
-	}
-
 	return nil
 }
 
@@ -142,17 +221,51 @@ func (s *SQLLog) DoCompact(ctx context.Context) (err error) {
 		span.RecordError(err)
 		span.End()
 	}()
-	if err := s.compactStart(ctx); err != nil {
-		return fmt.Errorf("failed to initialise compaction: %v", err)
-	}
-
 	// When executing compaction as a background operation
 	// it's best not to take too much time away from query
 	// operation and similar. As such, we do compaction in
-	// small batches. Given that this logic runs every second,
-	// on regime it should take usually just a couple batches
-	// to keep the pace.
-	start, target, err := s.d.GetCompactRevision(ctx)
+	// small batches, optionally pausing between them so that
+	// writes are not held back by consecutive batches.
+	if err := s.compactMain(ctx); err != nil {
+		return err
+	}
+	if s.events != nil {
+		return s.compact(ctx, s.events, s.eventsRetention)
+	}
+	return nil
+}
+
+// compactMain compacts the revisions of the keys stored with the kine
+// table.
+func (s *SQLLog) compactMain(ctx context.Context) error {
+	return s.compact(ctx, s.d, s.retentionPolicy())
+}
+
+// compactEvents periodically compacts the events stored apart, on the
+// schedule of their retention policy.
+func (s *SQLLog) compactEvents() {
+	t := time.NewTicker(s.eventsRetention.Interval())
+	defer t.Stop()
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-t.C:
+			if err := s.compact(s.ctx, s.events, s.eventsRetention); err != nil {
+				logger.WithError(err).Trace("events compaction failed")
+			}
+		}
+	}
+}
+
+// compact compacts the revisions of c its retention policy lets go.
+func (s *SQLLog) compact(ctx context.Context, c Compactor, policy retentionPolicy) (err error) {
+	ctx, span := otelTracer.Start(ctx, fmt.Sprintf("%s.compact", otelName))
+	defer func() {
+		span.RecordError(err)
+		span.End()
+	}()
+	start, target, err := c.GetCompactRevision(ctx)
 	if err != nil {
 		return err
 	}
@@ -160,18 +273,100 @@ func (s *SQLLog) DoCompact(ctx context.Context) (err error) {
 	// NOTE: Upstream is ignoring the last 1000 revisions, however that causes the following CNCF conformance test to fail.
 	// This is because of low activity, where the created list is part of the last 1000 revisions and is not compacted.
 	// Link to failing test: https://github.com/kubernetes/kubernetes/blob/f2cfbf44b1fb482671aedbfff820ae2af256a389/test/e2e/apimachinery/chunking.go#L144
-	// To address this, we only ignore the last 100 revisions instead
-	target -= SupersededCount
+	// To address this, we only ignore the last 100 revisions instead, unless
+	// a different retention policy is configured.
+	target = policy.Target(time.Now(), target)
 	span.SetAttributes(attribute.Int64("target", target))
+	return s.compactBatches(ctx, c, start, target)
+}
+
+// CompactTo compacts the revisions up to revision on demand, in batches as
+// the periodic compactions, and returns the compact revision. The revisions
+// already compacted are left as is.
+func (s *SQLLog) CompactTo(ctx context.Context, revision int64) (compactRevision int64, err error) {
+	ctx, span := otelTracer.Start(ctx, fmt.Sprintf("%s.CompactTo", otelName))
+	defer func() {
+		span.RecordError(err)
+		span.End()
+	}()
+	span.SetAttributes(attribute.Int64("revision", revision))
+	start, current, err := s.d.GetCompactRevision(ctx)
+	if err != nil {
+		return 0, err
+	}
+	span.SetAttributes(attribute.Int64("start", start))
+	if revision > current {
+		return 0, server.ErrFutureRev
+	}
+	if s.events != nil {
+		// The events share the revisions, so they are compacted up to
+		// the same revision.
+		eventsStart, _, err := s.events.GetCompactRevision(ctx)
+		if err != nil {
+			return 0, err
+		}
+		if revision > eventsStart {
+			if err := s.compactBatches(ctx, s.events, eventsStart, revision); err != nil {
+				return 0, err
+			}
+		}
+	}
+	if revision <= start {
+		return start, nil
+	}
+	if err := s.compactBatches(ctx, s.d, start, revision); err != nil {
+		return 0, err
+	}
+	return revision, nil
+}
+
+func (s *SQLLog) retentionPolicy() retentionPolicy {
+	s.retentionMu.Lock()
+	defer s.retentionMu.Unlock()
+	return s.retention
+}
+
+// SetCompaction replaces the retention policy of the compaction. A new
+// periodic policy samples the revisions from scratch, so it only compacts
+// once its retention window has elapsed.
+func (s *SQLLog) SetCompaction(config server.CompactionConfig) {
+	if config.Interval <= 0 {
+		config.Interval = s.d.GetCompactInterval()
+	}
+	policy := newRetentionPolicy(compactionSettings(config))
+
+	s.retentionMu.Lock()
+	s.retention = policy
+	s.retentionMu.Unlock()
+
+	select {
+	case s.retentionChanged <- struct{}{}:
+	default:
+	}
+}
+
+// compactBatches compacts the revisions of c between start and target in
+// batches of the configured size, pausing for the configured interval
+// between them.
+func (s *SQLLog) compactBatches(ctx context.Context, c Compactor, start, target int64) error {
+	batchSize, batchInterval := c.GetCompactBatchSize(), c.GetCompactBatchInterval()
 	for start < target {
-		batchRevision := start + compactBatchSize
+		batchRevision := start + batchSize
 		if batchRevision > target {
 			batchRevision = target
 		}
-		if err := s.d.Compact(s.ctx, batchRevision); err != nil {
+		if err := c.Compact(ctx, batchRevision); err != nil {
 			return err
 		}
 		start = batchRevision
+
+		if start < target && batchInterval > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(batchInterval):
+			}
+		}
 	}
 	return nil
 }
@@ -180,6 +375,35 @@ func (s *SQLLog) CurrentRevision(ctx context.Context) (int64, error) {
 	return s.d.CurrentRevision(ctx)
 }
 
+func (s *SQLLog) ReadBarrier(ctx context.Context) error {
+	return s.d.ReadBarrier(ctx)
+}
+
+// CompactRevision returns the compact revision of the keys under prefix.
+func (s *SQLLog) CompactRevision(ctx context.Context, prefix string) (int64, error) {
+	compact, _, err := s.prefixCompactRevision(ctx, prefix)
+	return compact, err
+}
+
+// prefixCompactRevision returns the compact and current revisions of the
+// keys under prefix.
+func (s *SQLLog) prefixCompactRevision(ctx context.Context, prefix string) (int64, int64, error) {
+	start, end := prefixRange(prefix)
+	return s.d.GetRangeCompactRevision(ctx, start, end)
+}
+
+// prefixRange returns the range of the keys under prefix, as listed by the
+// dialect.
+func prefixRange(prefix string) (start, end string) {
+	switch {
+	case prefix == "":
+		return "", "\xff"
+	case strings.HasSuffix(prefix, "/"):
+		return prefix, prefix[:len(prefix)-1] + "0"
+	}
+	return prefix, prefix + "\x01"
+}
+
 func (s *SQLLog) After(ctx context.Context, prefix string, revision, limit int64) (int64, []*server.Event, error) {
 	var err error
 	ctx, span := otelTracer.Start(ctx, fmt.Sprintf("%s.After", otelName))
@@ -192,17 +416,26 @@ func (s *SQLLog) After(ctx context.Context, prefix string, revision, limit int64
 		attribute.Int64("revision", revision),
 		attribute.Int64("limit", limit),
 	)
-	rows, err := s.d.AfterPrefix(ctx, prefix, revision, limit)
-	if err != nil {
-		return 0, nil, err
-	}
 
-	result, err := RowsToEvents(rows)
-	if err != nil {
-		return 0, nil, err
+	result, polled, cached := s.cache.after(prefix, revision, limit)
+	span.SetAttributes(attribute.Bool("cached", cached))
+	if cached {
+		watchCacheHitCnt.Add(ctx, 1)
+	} else {
+		watchCacheMissCnt.Add(ctx, 1)
+		var rows *sql.Rows
+		rows, err = s.d.AfterPrefix(ctx, prefix, revision, limit)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		result, err = s.rowsToEvents(ctx, rows)
+		if err != nil {
+			return 0, nil, err
+		}
 	}
 
-	compact, rev, err := s.d.GetCompactRevision(ctx)
+	compact, rev, err := s.prefixCompactRevision(ctx, prefix)
 
 	if err != nil {
 		return 0, nil, err
@@ -211,6 +444,11 @@ func (s *SQLLog) After(ctx context.Context, prefix string, revision, limit int64
 	if revision > 0 && revision < compact {
 		return rev, result, server.ErrCompacted
 	}
+	if cached {
+		// The events after the last polled revision are left to the
+		// watches, which subscribe before catching up.
+		rev = polled
+	}
 
 	return rev, result, err
 }
@@ -233,17 +471,7 @@ func (s *SQLLog) List(ctx context.Context, prefix, startKey string, limit, revis
 		attribute.Bool("includeDeleted", includeDeleted),
 	)
 
-	// It's assumed that when there is a start key that that key exists.
-	if strings.HasSuffix(prefix, "/") {
-		// In the situation of a list start the startKey will not exist so set to ""
-		if prefix == startKey {
-			startKey = ""
-		}
-	} else {
-		// Also if this isn't a list there is no reason to pass startKey
-		startKey = ""
-	}
-
+	startKey = listStartKey(prefix, startKey)
 	if revision == 0 {
 		rows, err = s.d.ListCurrent(ctx, prefix, startKey, limit, includeDeleted)
 	} else {
@@ -253,12 +481,12 @@ func (s *SQLLog) List(ctx context.Context, prefix, startKey string, limit, revis
 		return 0, nil, err
 	}
 
-	result, err := RowsToEvents(rows)
+	result, err := s.rowsToEvents(ctx, rows)
 	if err != nil {
 		return 0, nil, err
 	}
 
-	compact, rev, err := s.d.GetCompactRevision(ctx)
+	compact, rev, err := s.prefixCompactRevision(ctx, prefix)
 	if err != nil {
 		return 0, nil, err
 	}
@@ -272,6 +500,57 @@ func (s *SQLLog) List(ctx context.Context, prefix, startKey string, limit, revis
 	return rev, result, err
 }
 
+// listStartKey returns the key a list of prefix starts after.
+func listStartKey(prefix, startKey string) string {
+	// It's assumed that when there is a start key that that key exists.
+	if strings.HasSuffix(prefix, "/") {
+		// In the situation of a list start the startKey will not exist so set to ""
+		if prefix == startKey {
+			return ""
+		}
+		return startKey
+	}
+	// Also if this isn't a list there is no reason to pass startKey
+	return ""
+}
+
+// ListRange lists the keys between start, inclusive, and end, exclusive.
+func (s *SQLLog) ListRange(ctx context.Context, start, end string, limit, revision int64, opts server.RangeOptions) (int64, []*server.Event, error) {
+	var err error
+	ctx, span := otelTracer.Start(ctx, fmt.Sprintf("%s.ListRange", otelName))
+	defer func() {
+		span.RecordError(err)
+		span.End()
+	}()
+	span.SetAttributes(
+		attribute.String("start", start),
+		attribute.String("end", end),
+		attribute.Int64("limit", limit),
+		attribute.Int64("revision", revision),
+	)
+
+	rows, err := s.d.ListRange(ctx, start, end, limit, revision, false, opts)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	result, err := s.rowsToEvents(ctx, rows)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	compact, rev, err := s.d.GetRangeCompactRevision(ctx, start, end)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if revision > 0 && revision < compact {
+		return rev, result, server.ErrCompacted
+	}
+
+	return rev, result, err
+}
+
 func RowsToEvents(rows *sql.Rows) ([]*server.Event, error) {
 	var result []*server.Event
 	defer rows.Close()
@@ -326,45 +605,239 @@ func filter(events interface{}, checkPrefix bool, prefix string) ([]*server.Even
 }
 
 func (s *SQLLog) startWatch() (chan interface{}, error) {
-	if err := s.compactStart(s.ctx); err != nil {
-		return nil, err
-	}
-
-	pollStart, _, err := s.d.GetCompactRevision(s.ctx)
+	pollStart, _, err := s.prefixCompactRevision(s.ctx, "")
 	if err != nil {
 		return nil, err
 	}
+	s.cache.reset(pollStart)
 
 	c := make(chan interface{})
 	// start compaction and polling at the same time to watch starts
 	// at the oldest revision, but compaction doesn't create gaps
-	s.wg.Add(2)
+	s.wg.Add(4)
 
 	go func() {
 		defer s.wg.Done()
 
-		t := time.NewTicker(s.d.GetCompactInterval())
+		t := time.NewTicker(s.retentionPolicy().Interval())
 
 		for {
 			select {
 			case <-s.ctx.Done():
 				return
+			case <-s.retentionChanged:
+				t.Reset(s.retentionPolicy().Interval())
 			case <-t.C:
-				if err := s.DoCompact(s.ctx); err != nil {
-					logrus.WithError(err).Trace("compaction failed")
+				if err := s.compactMain(s.ctx); err != nil {
+					logger.WithError(err).Trace("compaction failed")
 				}
 			}
 		}
 	}()
 
+	if s.events != nil {
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.compactEvents()
+		}()
+	}
+
+	go func() {
+		defer s.wg.Done()
+		s.vacuum()
+	}()
+
 	go func() {
 		defer s.wg.Done()
 		s.poll(c, pollStart)
 	}()
 
+	go func() {
+		defer s.wg.Done()
+		s.checkRevisions(pollStart)
+	}()
+
 	return c, nil
 }
 
+// vacuum periodically vacuums the database, as long as there are enough
+// free pages for it to be worth it.
+func (s *SQLLog) vacuum() {
+	interval := s.d.GetVacuumInterval()
+	if interval <= 0 {
+		return
+	}
+
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-t.C:
+		}
+
+		if err := s.tryVacuum(s.ctx); err != nil {
+			logger.WithError(err).Warning("vacuum failed")
+		}
+	}
+}
+
+// tryVacuum vacuums the database, fully or incrementally as configured, if
+// it has at least the configured number of free pages, so that the full
+// vacuums only rebuild the database once it is worth it.
+func (s *SQLLog) tryVacuum(ctx context.Context) error {
+	_, freePages, _, err := s.d.PageStats(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get database page statistics: %w", err)
+	}
+	if freePages == 0 || freePages < s.d.GetVacuumFreePages() {
+		return nil
+	}
+
+	before, after, err := s.d.Vacuum(ctx)
+	if err != nil {
+		return err
+	}
+	logger.WithFields(logrus.Fields{"before": before, "after": after}).Debug("scheduled vacuum completed")
+	return nil
+}
+
+// DoVacuum returns the unused pages of the database to the file system. It
+// reports the size of the database before and after the vacuum.
+func (s *SQLLog) DoVacuum(ctx context.Context) (before, after int64, err error) {
+	ctx, span := otelTracer.Start(ctx, fmt.Sprintf("%s.DoVacuum", otelName))
+	defer func() {
+		span.RecordError(err)
+		span.End()
+	}()
+
+	before, after, err = s.d.Vacuum(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+	logger.WithFields(logrus.Fields{"before": before, "after": after}).Debug("vacuum completed")
+	span.SetAttributes(attribute.Int64("before", before), attribute.Int64("after", after))
+	return before, after, nil
+}
+
+// DoBackup writes a consistent copy of the database to a new SQLite
+// database at path and returns the revision of the copy.
+func (s *SQLLog) DoBackup(ctx context.Context, path string) (_ int64, err error) {
+	ctx, span := otelTracer.Start(ctx, fmt.Sprintf("%s.DoBackup", otelName))
+	defer func() {
+		span.RecordError(err)
+		span.End()
+	}()
+	return s.d.Backup(ctx, path)
+}
+
+// DoHash hashes the revisions retained in the database up to revision, or
+// the current revision if zero. It also returns the compact revision, which
+// bounds the hashed range, and the revision the hash was computed at, both
+// read along with the hashed rows.
+func (s *SQLLog) DoHash(ctx context.Context, revision int64) (hash uint32, compactRevision, hashRevision int64, err error) {
+	ctx, span := otelTracer.Start(ctx, fmt.Sprintf("%s.DoHash", otelName))
+	defer func() {
+		span.RecordError(err)
+		span.End()
+	}()
+	span.SetAttributes(attribute.Int64("revision", revision))
+	return s.d.Hash(ctx, revision)
+}
+
+// History returns the revisions of key retained in the database, deletions
+// included, in revision order, and the compact revision, before which the
+// revisions are gone.
+func (s *SQLLog) History(ctx context.Context, key string) (compactRevision int64, events []*server.Event, err error) {
+	ctx, span := otelTracer.Start(ctx, fmt.Sprintf("%s.History", otelName))
+	defer func() {
+		span.RecordError(err)
+		span.End()
+	}()
+	span.SetAttributes(attribute.String("key", key))
+
+	rows, err := s.d.History(ctx, key)
+	if err != nil {
+		return 0, nil, err
+	}
+	events, err = s.rowsToEvents(ctx, rows)
+	if err != nil {
+		return 0, nil, err
+	}
+	compactRevision, _, err = s.prefixCompactRevision(ctx, key)
+	if err != nil {
+		return 0, nil, err
+	}
+	return compactRevision, events, nil
+}
+
+// KeyStats returns the storage statistics of the keys, tombstones and
+// internal keys included.
+func (s *SQLLog) KeyStats(ctx context.Context) (stats []server.KeyStats, err error) {
+	ctx, span := otelTracer.Start(ctx, fmt.Sprintf("%s.KeyStats", otelName))
+	defer func() {
+		span.RecordError(err)
+		span.End()
+	}()
+
+	rows, err := s.d.KeyStats(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var stat server.KeyStats
+		if err := rows.Scan(&stat.Key, &stat.Deleted, &stat.Size, &stat.Revisions, &stat.Bytes); err != nil {
+			return nil, err
+		}
+		stats = append(stats, stat)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	span.SetAttributes(attribute.Int("keys", len(stats)))
+	return stats, nil
+}
+
+// Verify runs the integrity check of the database and checks the
+// invariants of the revisions, and returns the violations found.
+func (s *SQLLog) Verify(ctx context.Context) (violations []server.Violation, err error) {
+	ctx, span := otelTracer.Start(ctx, fmt.Sprintf("%s.Verify", otelName))
+	defer func() {
+		span.RecordError(err)
+		span.End()
+	}()
+
+	problems, err := s.d.IntegrityCheck(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, problem := range problems {
+		violations = append(violations, server.Violation{Problem: problem})
+	}
+
+	rows, err := s.d.Invariants(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var violation server.Violation
+		if err := rows.Scan(&violation.Revision, &violation.Key, &violation.Problem); err != nil {
+			return nil, err
+		}
+		violations = append(violations, violation)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	span.SetAttributes(attribute.Int("violations", len(violations)))
+	return violations, nil
+}
+
 func (s *SQLLog) poll(result chan interface{}, pollStart int64) {
 	var (
 		last        = pollStart
@@ -373,11 +846,22 @@ func (s *SQLLog) poll(result chan interface{}, pollStart int64) {
 		waitForMore = true
 	)
 
-	wait := time.NewTicker(s.d.GetPollInterval())
+	// Commits wake the poll loop right away, in which case polling is
+	// only a fallback for the writes that are not notified.
+	commits := s.d.GetCommitNotify()
+	pollInterval := s.d.GetPollInterval()
+	if commits != nil && pollInterval < fallbackPollInterval {
+		pollInterval = fallbackPollInterval
+	}
+	backoff := newPollBackoff(pollInterval, s.d.GetMaxPollInterval())
+	wait := time.NewTimer(backoff.interval)
 	defer wait.Stop()
 	defer close(result)
+	s.lastPoll.Store(time.Now().UnixNano())
+	s.polled.Store(last)
 
 	for {
+		committed := false
 		if waitForMore {
 			select {
 			case <-s.ctx.Done():
@@ -386,7 +870,13 @@ func (s *SQLLog) poll(result chan interface{}, pollStart int64) {
 				if check <= last {
 					continue
 				}
+			case <-commits:
+				committed = true
 			case <-wait.C:
+				// The timer is armed again right away, assuming that
+				// the poll finds nothing, so that it keeps firing
+				// whatever the outcome of the poll.
+				wait.Reset(backoff.idle())
 			}
 		}
 		waitForMore = true
@@ -396,20 +886,36 @@ func (s *SQLLog) poll(result chan interface{}, pollStart int64) {
 		rows, err := s.d.After(watchCtx, last, 500)
 		if err != nil {
 			if !errors.Is(err, context.DeadlineExceeded) {
-				logrus.Errorf("fail to list latest changes: %v", err)
+				logger.Errorf("fail to list latest changes: %v", err)
 			}
 			continue
 		}
+		s.lastPoll.Store(time.Now().UnixNano())
 
-		events, err := RowsToEvents(rows)
+		events, err := s.rowsToEvents(watchCtx, rows)
 		if err != nil {
-			logrus.Errorf("fail to convert rows changes: %v", err)
+			logger.Errorf("fail to convert rows changes: %v", err)
 			continue
 		}
 
 		if len(events) == 0 {
+			if committed {
+				// Commits are notified before they are visible to
+				// the other connections, so poll again shortly.
+				next := last + 1
+				time.AfterFunc(commitSettleDelay, func() { s.notifyWatcherPoll(next) })
+			}
 			continue
 		}
+		if backoff.active() {
+			if !wait.Stop() {
+				select {
+				case <-wait.C:
+				default:
+				}
+			}
+			wait.Reset(backoff.interval)
+		}
 
 		waitForMore = len(events) < 100
 
@@ -420,6 +926,13 @@ func (s *SQLLog) poll(result chan interface{}, pollStart int64) {
 		)
 
 		for _, event := range events {
+			if event.KV.ModRevision <= rev {
+				// The revisions after the last one are listed in
+				// order, so a revision seen already is a duplicate.
+				metricsRevisionGaps.WithLabelValues("duplicate").Inc()
+				logger.WithFields(logrus.Fields{"key": event.KV.Key, "revision": event.KV.ModRevision, "last": rev}).Error("DUPLICATE")
+				continue
+			}
 			next := rev + 1
 			// Ensure that we are notifying events in a sequential fashion. For example if we find row 4 before 3
 			// we don't want to notify row 4 because 3 is essentially dropped forever.
@@ -427,7 +940,7 @@ func (s *SQLLog) poll(result chan interface{}, pollStart int64) {
 				if canSkipRevision(next, skip, skipTime) {
 					// This situation should never happen, but we have it here as a fallback just for unknown reasons
 					// we don't want to pause all watches forever
-					logrus.Errorf("GAP %s, revision=%d, delete=%v, next=%d", event.KV.Key, event.KV.ModRevision, event.Delete, next)
+					logger.WithFields(logrus.Fields{"key": event.KV.Key, "revision": event.KV.ModRevision, "delete": event.Delete, "next": next}).Error("GAP")
 				} else if skip != next {
 					// This is the first time we have encountered this missing revision, so record time start
 					// and trigger a quick retry for simple out of order events
@@ -437,10 +950,10 @@ func (s *SQLLog) poll(result chan interface{}, pollStart int64) {
 					break
 				} else {
 					if err := s.d.Fill(s.ctx, next); err == nil {
-						logrus.Debugf("FILL, revision=%d, err=%v", next, err)
+						logger.Debugf("FILL, revision=%d, err=%v", next, err)
 						s.notifyWatcherPoll(next)
 					} else {
-						logrus.Debugf("FILL FAILED, revision=%d, err=%v", next, err)
+						logger.Debugf("FILL FAILED, revision=%d, err=%v", next, err)
 					}
 					break
 				}
@@ -454,15 +967,19 @@ func (s *SQLLog) poll(result chan interface{}, pollStart int64) {
 			saveLast = true
 			rev = event.KV.ModRevision
 			if s.d.IsFill(event.KV.Key) {
-				logrus.Debugf("NOT TRIGGER FILL %s, revision=%d, delete=%v", event.KV.Key, event.KV.ModRevision, event.Delete)
+				logger.Debugf("NOT TRIGGER FILL %s, revision=%d, delete=%v", event.KV.Key, event.KV.ModRevision, event.Delete)
 			} else {
 				sequential = append(sequential, event)
-				logrus.Debugf("TRIGGERED %s, revision=%d, delete=%v", event.KV.Key, event.KV.ModRevision, event.Delete)
+				logger.Debugf("TRIGGERED %s, revision=%d, delete=%v", event.KV.Key, event.KV.ModRevision, event.Delete)
 			}
 		}
 
 		if saveLast {
 			last = rev
+			s.polled.Store(last)
+			// Events are cached before they are broadcast, so that
+			// watches subscribing in between find them in the cache.
+			s.cache.add(sequential, rev)
 			if len(sequential) > 0 {
 				result <- sequential
 			}
@@ -470,6 +987,36 @@ func (s *SQLLog) poll(result chan interface{}, pollStart int64) {
 	}
 }
 
+// pollBackoff doubles the poll interval while polls find no events, up to
+// a maximum, and goes back to the minimum as soon as events are found.
+type pollBackoff struct {
+	min, max, interval time.Duration
+}
+
+func newPollBackoff(min, max time.Duration) *pollBackoff {
+	if max < min {
+		max = min
+	}
+	return &pollBackoff{min: min, max: max, interval: min}
+}
+
+// idle backs off after a poll finding no events, and returns the next
+// interval.
+func (b *pollBackoff) idle() time.Duration {
+	b.interval = min(2*b.interval, b.max)
+	return b.interval
+}
+
+// active resets the interval after a poll finding events, and reports
+// whether it changed.
+func (b *pollBackoff) active() bool {
+	if b.interval == b.min {
+		return false
+	}
+	b.interval = b.min
+	return true
+}
+
 func canSkipRevision(rev, skip int64, skipTime time.Time) bool {
 	return rev == skip && time.Since(skipTime) > time.Second
 }
@@ -493,6 +1040,22 @@ func (s *SQLLog) Count(ctx context.Context, prefix, startKey string, revision in
 	return s.d.Count(ctx, prefix, startKey, revision)
 }
 
+// CountRange counts the keys between start, inclusive, and end, exclusive.
+func (s *SQLLog) CountRange(ctx context.Context, start, end string, revision int64, opts server.RangeOptions) (int64, int64, error) {
+	var err error
+	ctx, span := otelTracer.Start(ctx, fmt.Sprintf("%s.CountRange", otelName))
+	defer func() {
+		span.RecordError(err)
+		span.End()
+	}()
+	span.SetAttributes(
+		attribute.String("start", start),
+		attribute.String("end", end),
+		attribute.Int64("revision", revision),
+	)
+	return s.d.CountRange(ctx, start, end, revision, opts)
+}
+
 func (s *SQLLog) Create(ctx context.Context, key string, value []byte, lease int64) (int64, bool, error) {
 	rev, created, err := s.d.Create(ctx, key, value, lease)
 	if err != nil {
@@ -526,6 +1089,92 @@ func (s *SQLLog) Update(ctx context.Context, key string, value []byte, prevRev,
 	return rev, updated, nil
 }
 
+// GrantLease persists a lease with the given ID and TTL in seconds.
+func (s *SQLLog) GrantLease(ctx context.Context, id, ttl int64, expiry time.Time) error {
+	return s.d.GrantLease(ctx, id, ttl, expiry.UnixMilli())
+}
+
+// CheckpointLease persists the expiry of a lease.
+func (s *SQLLog) CheckpointLease(ctx context.Context, id int64, expiry time.Time) error {
+	return s.d.CheckpointLease(ctx, id, expiry.UnixMilli())
+}
+
+// RevokeLease removes a persisted lease.
+func (s *SQLLog) RevokeLease(ctx context.Context, id int64) error {
+	return s.d.RevokeLease(ctx, id)
+}
+
+// Leases returns all the persisted leases.
+func (s *SQLLog) Leases(ctx context.Context) ([]*server.LeaseCheckpoint, error) {
+	rows, err := s.d.ListLeases(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return rowsToLeases(rows)
+}
+
+// Lease returns a persisted lease, or nil if it doesn't exist.
+func (s *SQLLog) Lease(ctx context.Context, id int64) (*server.LeaseCheckpoint, error) {
+	rows, err := s.d.GetLease(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	leases, err := rowsToLeases(rows)
+	if err != nil || len(leases) == 0 {
+		return nil, err
+	}
+	return leases[0], nil
+}
+
+// ActivateAlarm persists an alarm.
+func (s *SQLLog) ActivateAlarm(ctx context.Context, alarm int32) error {
+	return s.d.ActivateAlarm(ctx, alarm)
+}
+
+// DeactivateAlarm removes a persisted alarm.
+func (s *SQLLog) DeactivateAlarm(ctx context.Context, alarm int32) error {
+	return s.d.DeactivateAlarm(ctx, alarm)
+}
+
+// Alarms returns the persisted alarms.
+func (s *SQLLog) Alarms(ctx context.Context) ([]int32, error) {
+	rows, err := s.d.ListAlarms(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var alarms []int32
+	for rows.Next() {
+		var alarm int32
+		if err := rows.Scan(&alarm); err != nil {
+			return nil, err
+		}
+		alarms = append(alarms, alarm)
+	}
+	return alarms, rows.Err()
+}
+
+func rowsToLeases(rows *sql.Rows) ([]*server.LeaseCheckpoint, error) {
+	defer rows.Close()
+
+	var leases []*server.LeaseCheckpoint
+	for rows.Next() {
+		var (
+			lease  server.LeaseCheckpoint
+			expiry sql.NullInt64
+		)
+		if err := rows.Scan(&lease.ID, &lease.TTL, &expiry); err != nil {
+			return nil, err
+		}
+		if expiry.Valid {
+			lease.Expiry = time.UnixMilli(expiry.Int64)
+		}
+		leases = append(leases, &lease)
+	}
+	return leases, rows.Err()
+}
+
 func (s *SQLLog) notifyWatcherPoll(revision int64) {
 	select {
 	case s.notify <- revision:
@@ -551,6 +1200,8 @@ func scan(rows *sql.Rows, event *server.Event) error {
 	if err != nil {
 		return err
 	}
+	event.KV.Value = compression.Decode(event.KV.Value)
+	event.PrevKV.Value = compression.Decode(event.PrevKV.Value)
 
 	if event.Create {
 		event.KV.CreateRevision = event.KV.ModRevision
@@ -575,3 +1226,15 @@ func (s *SQLLog) DbSize(ctx context.Context) (int64, error) {
 	span.SetAttributes(attribute.Int64("size", size))
 	return size, err
 }
+
+func (s *SQLLog) DbFileSize(ctx context.Context) (int64, error) {
+	var err error
+	ctx, span := otelTracer.Start(ctx, fmt.Sprintf("%s.DbFileSize", otelName))
+	defer func() {
+		span.RecordError(err)
+		span.End()
+	}()
+	size, err := s.d.GetFileSize(ctx)
+	span.SetAttributes(attribute.Int64("size", size))
+	return size, err
+}
@@ -0,0 +1,30 @@
+package sqllog
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPollBackoff(t *testing.T) {
+	backoff := newPollBackoff(time.Second, 5*time.Second)
+	for _, expected := range []time.Duration{2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second} {
+		if interval := backoff.idle(); interval != expected {
+			t.Errorf("expected interval %v, got %v", expected, interval)
+		}
+	}
+	if !backoff.active() {
+		t.Error("expected activity to reset the interval")
+	}
+	if backoff.interval != time.Second {
+		t.Errorf("expected interval %v, got %v", time.Second, backoff.interval)
+	}
+	if backoff.active() {
+		t.Error("expected the interval to be unchanged")
+	}
+
+	// A maximum lower than the minimum disables the backoff.
+	backoff = newPollBackoff(time.Second, time.Millisecond)
+	if interval := backoff.idle(); interval != time.Second {
+		t.Errorf("expected interval %v, got %v", time.Second, interval)
+	}
+}
@@ -0,0 +1,121 @@
+package sqllog
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/canonical/k8s-dqlite/pkg/kine/server"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Tx is a database transaction of a dialect.
+type Tx interface {
+	CurrentRevision(ctx context.Context) (int64, error)
+	ListRange(ctx context.Context, start, end string, limit int64, includeDeleted bool, opts server.RangeOptions) (*sql.Rows, error)
+	Create(ctx context.Context, key string, value []byte, lease int64) (int64, bool, error)
+	Update(ctx context.Context, key string, value []byte, prevRev, lease int64) (int64, bool, error)
+	Delete(ctx context.Context, key string, revision int64) (int64, bool, error)
+	GetLease(ctx context.Context, id int64) (*sql.Rows, error)
+	ReadChunks(ctx context.Context, key string, id int64) ([]byte, error)
+}
+
+// Txn runs f in a single database transaction. Watchers are notified of
+// the writes of f once the transaction is committed.
+func (s *SQLLog) Txn(ctx context.Context, f func(tx server.Transaction) error) (err error) {
+	ctx, span := otelTracer.Start(ctx, fmt.Sprintf("%s.Txn", otelName))
+	defer func() {
+		span.RecordError(err)
+		span.End()
+	}()
+
+	var lastRev int64
+	err = s.d.Txn(ctx, func(tx Tx) error {
+		t := &sqlTx{tx: tx}
+		if err := f(t); err != nil {
+			return err
+		}
+		lastRev = t.lastRev
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	span.SetAttributes(attribute.Int64("revision", lastRev))
+	if lastRev > 0 {
+		s.notifyWatcherPoll(lastRev)
+	}
+	return nil
+}
+
+// sqlTx reads and writes the log in a dialect transaction.
+type sqlTx struct {
+	tx Tx
+	// lastRev is the revision of the last write.
+	lastRev int64
+}
+
+func (t *sqlTx) CurrentRevision(ctx context.Context) (int64, error) {
+	return t.tx.CurrentRevision(ctx)
+}
+
+func (t *sqlTx) Get(ctx context.Context, key string) (*server.KeyValue, error) {
+	kvs, err := t.List(ctx, key, key+"\x00", 1, server.RangeOptions{})
+	if err != nil || len(kvs) == 0 {
+		return nil, err
+	}
+	return kvs[0], nil
+}
+
+func (t *sqlTx) List(ctx context.Context, start, end string, limit int64, opts server.RangeOptions) ([]*server.KeyValue, error) {
+	rows, err := t.tx.ListRange(ctx, start, end, limit, false, opts)
+	if err != nil {
+		return nil, err
+	}
+	events, err := RowsToEvents(rows)
+	if err != nil {
+		return nil, err
+	}
+	if err := readChunks(ctx, t.tx, events); err != nil {
+		return nil, err
+	}
+	kvs := make([]*server.KeyValue, 0, len(events))
+	for _, event := range events {
+		kvs = append(kvs, event.KV)
+	}
+	return kvs, nil
+}
+
+func (t *sqlTx) Lease(ctx context.Context, id int64) (*server.LeaseCheckpoint, error) {
+	rows, err := t.tx.GetLease(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	leases, err := rowsToLeases(rows)
+	if err != nil || len(leases) == 0 {
+		return nil, err
+	}
+	return leases[0], nil
+}
+
+func (t *sqlTx) Create(ctx context.Context, key string, value []byte, lease int64) (int64, bool, error) {
+	return t.written(t.tx.Create(ctx, key, value, lease))
+}
+
+func (t *sqlTx) Update(ctx context.Context, key string, value []byte, prevRev, lease int64) (int64, bool, error) {
+	return t.written(t.tx.Update(ctx, key, value, prevRev, lease))
+}
+
+func (t *sqlTx) Delete(ctx context.Context, key string, revision int64) (int64, bool, error) {
+	return t.written(t.tx.Delete(ctx, key, revision))
+}
+
+func (t *sqlTx) written(rev int64, ok bool, err error) (int64, bool, error) {
+	if err != nil {
+		return 0, false, err
+	}
+	if ok && rev > t.lastRev {
+		t.lastRev = rev
+	}
+	return rev, ok, nil
+}
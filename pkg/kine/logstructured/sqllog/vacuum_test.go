@@ -0,0 +1,134 @@
+package sqllog
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// vacuumDialect fakes the page statistics and counts the vacuums.
+type vacuumDialect struct {
+	Dialect
+
+	interval     time.Duration
+	freePages    int64
+	minFreePages int64
+	statsErr     error
+	vacuums      atomic.Int64
+}
+
+func (d *vacuumDialect) GetVacuumInterval() time.Duration { return d.interval }
+
+func (d *vacuumDialect) GetVacuumFreePages() int64 { return d.minFreePages }
+
+func (d *vacuumDialect) PageStats(context.Context) (int64, int64, int64, error) {
+	return 100, d.freePages, 4096, d.statsErr
+}
+
+func (d *vacuumDialect) Vacuum(context.Context) (int64, int64, error) {
+	d.vacuums.Add(1)
+	return 100 * 4096, (100 - d.freePages) * 4096, nil
+}
+
+func TestTryVacuum(t *testing.T) {
+	tests := []struct {
+		name         string
+		freePages    int64
+		minFreePages int64
+		statsErr     error
+		vacuum       bool
+		expectErr    bool
+	}{
+		{
+			name: "no free pages",
+		},
+		{
+			name:      "free pages without minimum",
+			freePages: 1,
+			vacuum:    true,
+		},
+		{
+			name:         "fewer free pages than the minimum",
+			freePages:    9,
+			minFreePages: 10,
+		},
+		{
+			name:         "as many free pages as the minimum",
+			freePages:    10,
+			minFreePages: 10,
+			vacuum:       true,
+		},
+		{
+			name:      "page statistics unavailable",
+			freePages: 10,
+			statsErr:  errors.New("no page statistics"),
+			expectErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &vacuumDialect{
+				freePages:    tt.freePages,
+				minFreePages: tt.minFreePages,
+				statsErr:     tt.statsErr,
+			}
+			s := &SQLLog{d: d}
+
+			err := s.tryVacuum(context.Background())
+			if tt.expectErr != (err != nil) {
+				t.Fatalf("expected error: %v, got %v", tt.expectErr, err)
+			}
+			if vacuumed := d.vacuums.Load() > 0; vacuumed != tt.vacuum {
+				t.Fatalf("expected vacuum: %v, got %v", tt.vacuum, vacuumed)
+			}
+		})
+	}
+}
+
+func TestVacuumSchedule(t *testing.T) {
+	t.Run("disabled", func(t *testing.T) {
+		d := &vacuumDialect{freePages: 10}
+		s := &SQLLog{d: d}
+		s.ctx = context.Background()
+
+		// With no interval, the loop returns right away.
+		s.vacuum()
+		if n := d.vacuums.Load(); n != 0 {
+			t.Fatalf("expected no vacuum, got %d", n)
+		}
+	})
+
+	t.Run("periodic", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		d := &vacuumDialect{interval: 10 * time.Millisecond, freePages: 10}
+		s := &SQLLog{d: d}
+		s.ctx = ctx
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			s.vacuum()
+		}()
+
+		deadline := time.After(5 * time.Second)
+		for d.vacuums.Load() < 3 {
+			select {
+			case <-deadline:
+				t.Fatalf("expected 3 scheduled vacuums, got %d", d.vacuums.Load())
+			case <-time.After(d.interval):
+			}
+		}
+
+		cancel()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("vacuum loop did not stop")
+		}
+	})
+}
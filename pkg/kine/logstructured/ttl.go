@@ -0,0 +1,116 @@
+package logstructured
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/canonical/k8s-dqlite/pkg/kine/server"
+)
+
+// ttlSweepInterval is the interval between sweeps of the expired TTL keys.
+const ttlSweepInterval = 500 * time.Millisecond
+
+type ttlKey struct {
+	revision int64
+	expiry   time.Time
+}
+
+// ttlSweeper tracks the expiry of the keys whose lease is a TTL, as set by
+// previous versions, instead of a granted lease. As the database does not
+// record when a revision was written, the TTL runs from when the revision
+// is first seen.
+type ttlSweeper struct {
+	mu   sync.Mutex
+	keys map[string]ttlKey
+}
+
+func newTTLSweeper() *ttlSweeper {
+	return &ttlSweeper{
+		keys: make(map[string]ttlKey),
+	}
+}
+
+// track updates the expiry of a key after an event. Keys that are deleted,
+// or written again without a TTL, no longer expire.
+func (s *ttlSweeper) track(event *server.Event, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := event.KV.Key
+	if current, ok := s.keys[key]; ok && current.revision >= event.KV.ModRevision {
+		return
+	}
+	ttl := event.KV.Lease
+	if event.Delete || ttl <= 0 || ttl >= minLeaseID {
+		delete(s.keys, key)
+		return
+	}
+	s.keys[key] = ttlKey{
+		revision: event.KV.ModRevision,
+		expiry:   now.Add(time.Duration(ttl) * time.Second),
+	}
+}
+
+// expired returns the revisions of the keys that expired, by key.
+func (s *ttlSweeper) expired(now time.Time) map[string]int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keys := make(map[string]int64)
+	for key, k := range s.keys {
+		if !now.Before(k.expiry) {
+			keys[key] = k.revision
+		}
+	}
+	return keys
+}
+
+// remove stops tracking a key, unless it was written again since revision.
+func (s *ttlSweeper) remove(key string, revision int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if k, ok := s.keys[key]; ok && k.revision == revision {
+		delete(s.keys, key)
+	}
+}
+
+// ttl tracks the keys attached to leases and the expiry of the TTL keys,
+// which are deleted by sweepTTLs.
+func (l *LogStructured) ttl(ctx context.Context) {
+	for event := range l.ttlEvents(ctx) {
+		l.leases.track(event)
+		l.ttls.track(event, time.Now())
+	}
+}
+
+// sweepTTLs periodically deletes the expired TTL keys. Keys are deleted
+// through the log, so that their tombstones are written and watchers
+// receive DELETE events. Sweeps don't hold back the tracking of events,
+// which includes the deletions of the sweeps.
+func (l *LogStructured) sweepTTLs(ctx context.Context) {
+	ticker := time.NewTicker(ttlSweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		l.sweepTTL(ctx, time.Now())
+	}
+}
+
+// sweepTTL deletes the TTL keys that expired by now. Keys that changed
+// since they were tracked are left to the event of their change.
+func (l *LogStructured) sweepTTL(ctx context.Context, now time.Time) {
+	for key, revision := range l.ttls.expired(now) {
+		if _, _, err := l.Delete(ctx, key, revision); err != nil {
+			logger.WithError(err).WithField("key", key).Warning("failed to delete expired key")
+			continue
+		}
+		l.ttls.remove(key, revision)
+	}
+}
@@ -35,12 +35,25 @@ func New(db *sql.DB) *DB {
 
 func (db *DB) Underlying() *sql.DB { return db.underlying }
 
+// Stats returns the statistics of the connection pool, which are zero once
+// the database is closed.
+func (db *DB) Stats() sql.DBStats {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if db.underlying == nil {
+		return sql.DBStats{}
+	}
+	return db.underlying.Stats()
+}
+
 func (db *DB) ExecContext(ctx context.Context, query string, args ...any) (result sql.Result, err error) {
 	ctx, span := otelTracer.Start(ctx, fmt.Sprintf("%s.ExecContext", otelName))
 	defer func() {
 		span.RecordError(err)
 		span.End()
 	}()
+	span.SetAttributes(attribute.String("query", query))
 
 	stmt, err := db.prepare(ctx, query)
 	if err != nil {
@@ -55,6 +68,7 @@ func (db *DB) QueryContext(ctx context.Context, query string, args ...any) (rows
 		span.RecordError(err)
 		span.End()
 	}()
+	span.SetAttributes(attribute.String("query", query))
 
 	stmt, err := db.prepare(ctx, query)
 	if err != nil {
@@ -122,6 +136,14 @@ func (db *DB) prepare(ctx context.Context, query string) (stmt *sql.Stmt, err er
 	return prepared, nil
 }
 
+// lookup returns the statement prepared for query, or nil if there is none.
+func (db *DB) lookup(query string) *sql.Stmt {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	return db.store[query]
+}
+
 func (db *DB) BeginTx(ctx context.Context, opts *sql.TxOptions) (*Tx, error) {
 	tx, err := db.underlying.BeginTx(ctx, opts)
 	if err != nil {
@@ -11,21 +11,32 @@ type Tx struct {
 }
 
 func (tx *Tx) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
-	stmt, err := tx.db.prepare(ctx, query)
+	stmt, err := tx.prepare(ctx, query)
 	if err != nil {
 		return nil, err
 	}
 
-	return tx.tx.StmtContext(ctx, stmt).ExecContext(ctx, args...)
+	return stmt.ExecContext(ctx, args...)
 }
 
 func (tx *Tx) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
-	stmt, err := tx.db.prepare(ctx, query)
+	stmt, err := tx.prepare(ctx, query)
 	if err != nil {
 		return nil, err
 	}
 
-	return tx.tx.StmtContext(ctx, stmt).QueryContext(ctx, args...)
+	return stmt.QueryContext(ctx, args...)
+}
+
+// prepare returns a statement bound to the transaction. Statements that were
+// not prepared yet are prepared on the connection of the transaction, as
+// preparing them on the pool could require opening a new connection, which
+// blocks on the locks held by the transaction itself.
+func (tx *Tx) prepare(ctx context.Context, query string) (*sql.Stmt, error) {
+	if stmt := tx.db.lookup(query); stmt != nil {
+		return tx.tx.StmtContext(ctx, stmt), nil
+	}
+	return tx.tx.PrepareContext(ctx, query)
 }
 
 func (tx *Tx) Commit() error   { return tx.tx.Commit() }
@@ -0,0 +1,210 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	"go.etcd.io/etcd/api/v3/etcdserverpb"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// quotaCheckInterval is how long the database size and the active alarms
+// are cached between quota checks, so that writes don't query them each time.
+const quotaCheckInterval = time.Second
+
+var (
+	metricsQuotaBytes = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "k8s_dqlite_quota_backend_bytes",
+		Help: "Size of the database (in bytes) after which writes are refused, or 0 if no quota is enforced",
+	})
+	metricsQuotaUsedBytes = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "k8s_dqlite_quota_used_bytes",
+		Help: "Size of the database (in bytes) counted against the quota, as of the last quota check",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(metricsQuotaBytes, metricsQuotaUsedBytes)
+}
+
+// Quota is the size of the database in bytes after which writes are
+// refused, which can be changed while the server runs. If not positive,
+// no quota is enforced.
+type Quota struct {
+	bytes atomic.Int64
+}
+
+func NewQuota(bytes int64) *Quota {
+	q := &Quota{}
+	q.Set(bytes)
+	return q
+}
+
+func (q *Quota) Bytes() int64 {
+	return q.bytes.Load()
+}
+
+func (q *Quota) Set(bytes int64) {
+	q.bytes.Store(bytes)
+	metricsQuotaBytes.Set(float64(max(bytes, 0)))
+}
+
+// alarms caches the alarms raised on the cluster, which are persisted in the
+// datastore so that all the members share them. As with etcd, a NOSPACE alarm
+// is raised when the database grows past the backend quota and stays raised,
+// refusing writes, until it is disarmed.
+type alarms struct {
+	mu     sync.Mutex
+	active map[etcdserverpb.AlarmType]bool
+
+	quota     *Quota
+	size      int64
+	checkedAt time.Time
+}
+
+func newAlarms(quota *Quota) *alarms {
+	return &alarms{
+		active: make(map[etcdserverpb.AlarmType]bool),
+		quota:  quota,
+	}
+}
+
+// expire reports whether the cache must be refreshed, in which case the
+// caller is expected to do so: concurrent callers are told it is fresh.
+func (a *alarms) expire(now time.Time) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if now.Sub(a.checkedAt) <= quotaCheckInterval {
+		return false
+	}
+	a.checkedAt = now
+	return true
+}
+
+func (a *alarms) update(size int64, active []int32) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.size = size
+	metricsQuotaUsedBytes.Set(float64(size))
+	a.active = make(map[etcdserverpb.AlarmType]bool, len(active))
+	for _, alarm := range active {
+		a.active[etcdserverpb.AlarmType(alarm)] = true
+	}
+}
+
+func (a *alarms) set(alarm etcdserverpb.AlarmType, active bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.active[alarm] = active
+	if !active {
+		// Check the size again on the next write, so that the alarm
+		// is raised again if no space was reclaimed.
+		a.checkedAt = time.Time{}
+	}
+}
+
+// noSpace reports whether the NOSPACE alarm is raised, and the last known
+// size of the database.
+func (a *alarms) noSpace() (bool, int64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.active[etcdserverpb.AlarmType_NOSPACE], a.size
+}
+
+// refreshAlarms reloads the database size and the persisted alarms, unless
+// they were loaded recently. The size excludes the free pages, so that the
+// space reclaimed by the compactions counts before the database is
+// defragmented.
+func (l *LimitedServer) refreshAlarms(ctx context.Context) error {
+	if !l.alarms.expire(time.Now()) {
+		return nil
+	}
+	size, err := l.backend.DbSize(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get database size: %w", err)
+	}
+	active, err := l.backend.Alarms(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get alarms: %w", err)
+	}
+	l.alarms.update(size, active)
+	return nil
+}
+
+// checkQuota fails with ErrNoSpace if the NOSPACE alarm is raised, raising it
+// first if the database is larger than the quota.
+func (l *LimitedServer) checkQuota(ctx context.Context) error {
+	quota := l.alarms.quota.Bytes()
+	if quota <= 0 {
+		return nil
+	}
+
+	if err := l.refreshAlarms(ctx); err != nil {
+		logger.WithError(err).Warning("failed to check the backend quota")
+	}
+
+	active, size := l.alarms.noSpace()
+	if active {
+		return ErrNoSpace
+	}
+	if size > quota {
+		logger.WithFields(logrus.Fields{"size": size, "quota": quota}).Warning("database exceeds the backend quota, raising NOSPACE alarm")
+		if err := l.backend.ActivateAlarm(ctx, int32(etcdserverpb.AlarmType_NOSPACE)); err != nil {
+			logger.WithError(err).Warning("failed to persist NOSPACE alarm")
+		}
+		l.alarms.set(etcdserverpb.AlarmType_NOSPACE, true)
+		return ErrNoSpace
+	}
+	return nil
+}
+
+func (l *LimitedServer) alarm(ctx context.Context, r *etcdserverpb.AlarmRequest) (active []etcdserverpb.AlarmType, err error) {
+	ctx, span := otelTracer.Start(ctx, fmt.Sprintf("%s.alarm", otelName))
+	defer func() {
+		span.RecordError(err)
+		span.End()
+	}()
+	span.SetAttributes(
+		attribute.String("action", r.Action.String()),
+		attribute.String("alarm", r.Alarm.String()),
+	)
+
+	switch r.Action {
+	case etcdserverpb.AlarmRequest_GET:
+	case etcdserverpb.AlarmRequest_ACTIVATE:
+		if r.Alarm == etcdserverpb.AlarmType_NONE {
+			return nil, fmt.Errorf("no alarm to activate")
+		}
+		if err := l.backend.ActivateAlarm(ctx, int32(r.Alarm)); err != nil {
+			return nil, err
+		}
+		l.alarms.set(r.Alarm, true)
+		logger.WithField("alarm", r.Alarm).Warning("alarm activated")
+	case etcdserverpb.AlarmRequest_DEACTIVATE:
+		if err := l.backend.DeactivateAlarm(ctx, int32(r.Alarm)); err != nil {
+			return nil, err
+		}
+		l.alarms.set(r.Alarm, false)
+		logger.WithField("alarm", r.Alarm).Info("alarm deactivated")
+	default:
+		return nil, fmt.Errorf("unknown alarm action %v", r.Action)
+	}
+
+	persisted, err := l.backend.Alarms(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, alarm := range persisted {
+		active = append(active, etcdserverpb.AlarmType(alarm))
+	}
+	return active, nil
+}
@@ -0,0 +1,69 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+// AuditEntry records a write to the datastore.
+type AuditEntry struct {
+	Time     time.Time `json:"time"`
+	Op       string    `json:"op"`
+	Key      string    `json:"key"`
+	Revision int64     `json:"revision"`
+	// Identity is the common name of the client certificate of the
+	// requester, or its address if it has none.
+	Identity string `json:"identity,omitempty"`
+}
+
+// AuditLog records the writes to the datastore.
+type AuditLog interface {
+	Record(entry AuditEntry)
+}
+
+// The operations of the audit entries.
+const (
+	auditCreate = "create"
+	auditUpdate = "update"
+	auditDelete = "delete"
+)
+
+// auditWrite is a write waiting to be audited once committed.
+type auditWrite struct {
+	op       string
+	key      string
+	revision int64
+}
+
+func (l *LimitedServer) audit(ctx context.Context, writes ...auditWrite) {
+	if l.auditLog == nil || len(writes) == 0 {
+		return
+	}
+	now, identity := time.Now(), requesterIdentity(ctx)
+	for _, w := range writes {
+		l.auditLog.Record(AuditEntry{
+			Time:     now,
+			Op:       w.op,
+			Key:      w.key,
+			Revision: w.revision,
+			Identity: identity,
+		})
+	}
+}
+
+func requesterIdentity(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return ""
+	}
+	if info, ok := p.AuthInfo.(credentials.TLSInfo); ok && len(info.State.PeerCertificates) > 0 {
+		return info.State.PeerCertificates[0].Subject.CommonName
+	}
+	if p.Addr != nil {
+		return p.Addr.String()
+	}
+	return ""
+}
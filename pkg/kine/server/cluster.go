@@ -0,0 +1,129 @@
+package server
+
+import (
+	"context"
+	"fmt"
+
+	"go.etcd.io/etcd/api/v3/etcdserverpb"
+)
+
+var _ etcdserverpb.ClusterServer = (*KVServerBridge)(nil)
+
+// MemberList lists the members of the cluster replicating the datastore.
+func (s *KVServerBridge) MemberList(ctx context.Context, r *etcdserverpb.MemberListRequest) (*etcdserverpb.MemberListResponse, error) {
+	if s.cluster == nil {
+		return nil, unsupported("cluster")
+	}
+	members, err := s.members(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &etcdserverpb.MemberListResponse{
+		Header:  s.clusterHeader(),
+		Members: members,
+	}, nil
+}
+
+// MemberAdd adds a member to the cluster, with the single peer URL of the
+// request.
+func (s *KVServerBridge) MemberAdd(ctx context.Context, r *etcdserverpb.MemberAddRequest) (*etcdserverpb.MemberAddResponse, error) {
+	if s.cluster == nil {
+		return nil, unsupported("cluster")
+	}
+	if len(r.PeerURLs) != 1 {
+		return nil, fmt.Errorf("exactly one peer URL is required, got %d", len(r.PeerURLs))
+	}
+	member, err := s.cluster.AddMember(ctx, r.PeerURLs[0], r.IsLearner)
+	if err != nil {
+		return nil, err
+	}
+	members, err := s.members(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &etcdserverpb.MemberAddResponse{
+		Header:  s.clusterHeader(),
+		Member:  toMember(member),
+		Members: members,
+	}, nil
+}
+
+// MemberRemove removes a member from the cluster.
+func (s *KVServerBridge) MemberRemove(ctx context.Context, r *etcdserverpb.MemberRemoveRequest) (*etcdserverpb.MemberRemoveResponse, error) {
+	if s.cluster == nil {
+		return nil, unsupported("cluster")
+	}
+	if err := s.cluster.RemoveMember(ctx, r.ID); err != nil {
+		return nil, err
+	}
+	members, err := s.members(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &etcdserverpb.MemberRemoveResponse{
+		Header:  s.clusterHeader(),
+		Members: members,
+	}, nil
+}
+
+// MemberUpdate changes the peer URLs of a member.
+func (s *KVServerBridge) MemberUpdate(ctx context.Context, r *etcdserverpb.MemberUpdateRequest) (*etcdserverpb.MemberUpdateResponse, error) {
+	if s.cluster == nil {
+		return nil, unsupported("cluster")
+	}
+	if err := s.cluster.UpdateMember(ctx, r.ID, r.PeerURLs); err != nil {
+		return nil, err
+	}
+	members, err := s.members(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &etcdserverpb.MemberUpdateResponse{
+		Header:  s.clusterHeader(),
+		Members: members,
+	}, nil
+}
+
+// MemberPromote promotes a learner to a voting member.
+func (s *KVServerBridge) MemberPromote(ctx context.Context, r *etcdserverpb.MemberPromoteRequest) (*etcdserverpb.MemberPromoteResponse, error) {
+	if s.cluster == nil {
+		return nil, unsupported("cluster")
+	}
+	if err := s.cluster.PromoteMember(ctx, r.ID); err != nil {
+		return nil, err
+	}
+	members, err := s.members(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &etcdserverpb.MemberPromoteResponse{
+		Header:  s.clusterHeader(),
+		Members: members,
+	}, nil
+}
+
+func (s *KVServerBridge) members(ctx context.Context) ([]*etcdserverpb.Member, error) {
+	members, err := s.cluster.Members(ctx)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*etcdserverpb.Member, 0, len(members))
+	for _, member := range members {
+		result = append(result, toMember(member))
+	}
+	return result, nil
+}
+
+func (s *KVServerBridge) clusterHeader() *etcdserverpb.ResponseHeader {
+	return &etcdserverpb.ResponseHeader{MemberId: s.cluster.MemberID()}
+}
+
+func toMember(member Member) *etcdserverpb.Member {
+	return &etcdserverpb.Member{
+		ID:         member.ID,
+		Name:       member.Name,
+		PeerURLs:   member.PeerURLs,
+		ClientURLs: member.ClientURLs,
+		IsLearner:  member.Learner,
+	}
+}
@@ -42,6 +42,10 @@ func (l *LimitedServer) create(ctx context.Context, put *etcdserverpb.PutRequest
 		return nil, unsupported("prevKv")
 	}
 
+	if err = l.checkQuota(ctx); err != nil {
+		return nil, err
+	}
+
 	rev, created, err := l.backend.Create(ctx, string(put.Key), put.Value, put.Lease)
 	if err != nil {
 		return nil, err
@@ -55,6 +59,7 @@ func (l *LimitedServer) create(ctx context.Context, put *etcdserverpb.PutRequest
 			Succeeded: false,
 		}, nil
 	}
+	l.audit(ctx, auditWrite{op: auditCreate, key: string(put.Key), revision: rev})
 
 	return &etcdserverpb.TxnResponse{
 		Header: txnHeader(rev),
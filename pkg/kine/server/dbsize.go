@@ -5,3 +5,7 @@ import "context"
 func (l *LimitedServer) dbSize(ctx context.Context) (int64, error) {
 	return l.backend.DbSize(ctx)
 }
+
+func (l *LimitedServer) dbFileSize(ctx context.Context) (int64, error) {
+	return l.backend.DbFileSize(ctx)
+}
@@ -0,0 +1,20 @@
+package server
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+func (l *LimitedServer) defragment(ctx context.Context) (before, after int64, err error) {
+	ctx, span := otelTracer.Start(ctx, fmt.Sprintf("%s.defragment", otelName))
+	defer func() {
+		span.RecordError(err)
+		span.End()
+	}()
+
+	before, after, err = l.backend.DoVacuum(ctx)
+	span.SetAttributes(attribute.Int64("before", before), attribute.Int64("after", after))
+	return before, after, err
+}
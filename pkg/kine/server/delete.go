@@ -40,6 +40,9 @@ func (l *LimitedServer) delete(ctx context.Context, key string, revision int64)
 	}
 
 	span.SetAttributes(attribute.Bool("deleted", deleted))
+	if deleted {
+		l.audit(ctx, auditWrite{op: auditDelete, key: key, revision: rev})
+	}
 
 	resp := &etcdserverpb.TxnResponse{
 		Header:    txnHeader(rev),
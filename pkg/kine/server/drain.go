@@ -0,0 +1,65 @@
+package server
+
+import (
+	"context"
+	"sync"
+)
+
+// inflight counts the unary requests being served.
+type inflight struct {
+	mu sync.Mutex
+	n  int
+	// idle is closed once no request is served, if waited for.
+	idle chan struct{}
+}
+
+// start counts a request, unless limit requests are already served. If
+// limit is not positive, the requests are not limited.
+func (i *inflight) start(limit int) bool {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if limit > 0 && i.n >= limit {
+		return false
+	}
+	i.n++
+	metricsInflightRequests.Set(float64(i.n))
+	return true
+}
+
+func (i *inflight) done() {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.n--
+	metricsInflightRequests.Set(float64(i.n))
+	if i.n == 0 && i.idle != nil {
+		close(i.idle)
+		i.idle = nil
+	}
+}
+
+func (i *inflight) wait(ctx context.Context) error {
+	i.mu.Lock()
+	if i.n == 0 {
+		i.mu.Unlock()
+		return nil
+	}
+	if i.idle == nil {
+		i.idle = make(chan struct{})
+	}
+	idle := i.idle
+	i.mu.Unlock()
+
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// WaitRequests waits until the unary requests being served complete, or
+// until ctx is done. The streams, such as the watches, are not waited for.
+// Only the requests going through UnaryInterceptor are counted.
+func (k *KVServerBridge) WaitRequests(ctx context.Context) error {
+	return k.inflight.wait(ctx)
+}
@@ -0,0 +1,44 @@
+package server
+
+import (
+	"context"
+	"errors"
+
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+)
+
+// etcdErrors are the errors of etcd returned by the server, which clients
+// match by their exact code and message.
+var etcdErrors = []error{
+	rpctypes.ErrGRPCCompacted,
+	rpctypes.ErrGRPCFutureRev,
+	rpctypes.ErrGRPCNoSpace,
+	rpctypes.ErrGRPCKeyNotFound,
+	rpctypes.ErrGRPCDuplicateKey,
+	rpctypes.ErrGRPCLeaseNotFound,
+	rpctypes.ErrGRPCLeaseExist,
+	rpctypes.ErrGRPCRequestTooLarge,
+	rpctypes.ErrGRPCTooManyOps,
+	rpctypes.ErrGRPCRequestTooManyRequests,
+}
+
+// toGRPCError returns the error of etcd err wraps, if any, so that the
+// clients recognize it, as they would not once wrapped with the context of
+// the failure. As in etcd, the errors of the context of the request are
+// returned as the errors of etcd for canceled and timed out requests.
+func toGRPCError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, context.Canceled):
+		return rpctypes.ErrGRPCCanceled
+	case errors.Is(err, context.DeadlineExceeded):
+		return rpctypes.ErrGRPCDeadlineExceeded
+	}
+	for _, etcdErr := range etcdErrors {
+		if errors.Is(err, etcdErr) {
+			return etcdErr
+		}
+	}
+	return err
+}
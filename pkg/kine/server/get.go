@@ -36,8 +36,23 @@ func (l *LimitedServer) get(ctx context.Context, r *etcdserverpb.RangeRequest) (
 	resp := &RangeResponse{
 		Header: txnHeader(rev),
 	}
-	if kv != nil {
-		resp.Kvs = []*KeyValue{kv}
+	if kv == nil || !rangeOptions(r).matches(kv) {
+		return resp, nil
 	}
+	if r.KeysOnly {
+		keyOnly := *kv
+		keyOnly.Value = nil
+		kv = &keyOnly
+	}
+	resp.Kvs = []*KeyValue{kv}
 	return resp, nil
 }
+
+// matches reports whether kv passes the revision filters of the options.
+func (o RangeOptions) matches(kv *KeyValue) bool {
+	inBounds := func(value, min, max int64) bool {
+		return (min == 0 || value >= min) && (max == 0 || value <= max)
+	}
+	return inBounds(kv.ModRevision, o.MinModRevision, o.MaxModRevision) &&
+		inBounds(kv.CreateRevision, o.MinCreateRevision, o.MaxCreateRevision)
+}
@@ -0,0 +1,24 @@
+package server
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+func (l *LimitedServer) hash(ctx context.Context, revision int64) (hash uint32, compactRevision, hashRevision int64, err error) {
+	ctx, span := otelTracer.Start(ctx, fmt.Sprintf("%s.hash", otelName))
+	defer func() {
+		span.RecordError(err)
+		span.End()
+	}()
+	span.SetAttributes(attribute.Int64("revision", revision))
+
+	hash, compactRevision, hashRevision, err = l.backend.DoHash(ctx, revision)
+	span.SetAttributes(
+		attribute.Int64("compactRevision", compactRevision),
+		attribute.Int64("hashRevision", hashRevision),
+	)
+	return hash, compactRevision, hashRevision, err
+}
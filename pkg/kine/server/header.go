@@ -0,0 +1,92 @@
+package server
+
+import (
+	"context"
+
+	"go.etcd.io/etcd/api/v3/etcdserverpb"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	"google.golang.org/grpc"
+)
+
+// headerResponse is a response with a header, as all the etcd responses.
+type headerResponse interface {
+	GetHeader() *etcdserverpb.ResponseHeader
+}
+
+// UnaryInterceptor sets the cluster and member IDs in the headers of the
+// responses, which some clients key their caches on. It also counts the
+// requests being served, for WaitRequests, and refuses the writes exceeding
+// the request limits and the requests over the limit of inflight requests.
+// The requests continue the traces of the clients, and fail with the errors
+// of etcd.
+func (k *KVServerBridge) UnaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		ctx = traceContext(ctx)
+		if err := k.limits.check(req); err != nil {
+			return nil, err
+		}
+		if !k.inflight.start(k.limits.MaxInflightRequests) {
+			metricsShedRequests.WithLabelValues("inflight").Inc()
+			return nil, rpctypes.ErrGRPCRequestTooManyRequests
+		}
+		defer k.inflight.done()
+
+		resp, err := handler(ctx, req)
+		if err != nil {
+			return nil, toGRPCError(err)
+		}
+		k.fillHeader(resp)
+		return resp, nil
+	}
+}
+
+// StreamInterceptor sets the cluster and member IDs in the headers of the
+// streamed responses, and translates the errors, as UnaryInterceptor.
+func (k *KVServerBridge) StreamInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return toGRPCError(handler(srv, &headerStream{ServerStream: ss, bridge: k, ctx: traceContext(ss.Context())}))
+	}
+}
+
+type headerStream struct {
+	grpc.ServerStream
+	bridge *KVServerBridge
+	ctx    context.Context
+}
+
+func (s *headerStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *headerStream) SendMsg(m any) error {
+	s.bridge.fillHeader(m)
+	return s.ServerStream.SendMsg(m)
+}
+
+// fillHeader sets the cluster and member IDs in the header of resp, and in
+// the ones of the responses of the operations of transactions.
+func (k *KVServerBridge) fillHeader(resp any) {
+	if k.cluster == nil {
+		return
+	}
+	if r, ok := resp.(headerResponse); ok {
+		if header := r.GetHeader(); header != nil {
+			header.ClusterId = k.cluster.ClusterID()
+			header.MemberId = k.cluster.MemberID()
+		}
+	}
+	if txn, ok := resp.(*etcdserverpb.TxnResponse); ok {
+		for _, op := range txn.Responses {
+			switch {
+			case op.GetResponseRange() != nil:
+				k.fillHeader(op.GetResponseRange())
+			case op.GetResponsePut() != nil:
+				k.fillHeader(op.GetResponsePut())
+			case op.GetResponseDeleteRange() != nil:
+				k.fillHeader(op.GetResponseDeleteRange())
+			case op.GetResponseTxn() != nil:
+				k.fillHeader(op.GetResponseTxn())
+			}
+		}
+	}
+}
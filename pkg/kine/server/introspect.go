@@ -0,0 +1,158 @@
+package server
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/stats"
+)
+
+// WatchInfo describes a watch being served.
+type WatchInfo struct {
+	ID            int64
+	Key           string
+	StartRevision int64
+	// Peer is the address of the client of the watch.
+	Peer    string
+	Started time.Time
+}
+
+// ConnectionInfo describes a client connection of the endpoint.
+type ConnectionInfo struct {
+	Remote    string
+	Connected time.Time
+	// Requests is the number of requests and streams started on the
+	// connection.
+	Requests int64
+}
+
+// watchRegistry tracks the watches being served, for introspection.
+type watchRegistry struct {
+	mu      sync.Mutex
+	watches map[int64]WatchInfo
+}
+
+func (r *watchRegistry) add(info WatchInfo) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.watches == nil {
+		r.watches = map[int64]WatchInfo{}
+	}
+	r.watches[info.ID] = info
+}
+
+func (r *watchRegistry) remove(id int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.watches, id)
+}
+
+func (r *watchRegistry) list() []WatchInfo {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	watches := make([]WatchInfo, 0, len(r.watches))
+	for _, info := range r.watches {
+		watches = append(watches, info)
+	}
+	sort.Slice(watches, func(i, j int) bool { return watches[i].ID < watches[j].ID })
+	return watches
+}
+
+// Watches lists the watches being served, by ID.
+func (k *KVServerBridge) Watches() []WatchInfo {
+	return k.watches.list()
+}
+
+type connectionKey struct{}
+
+type connection struct {
+	remote    string
+	connected time.Time
+	requests  atomic.Int64
+}
+
+// connectionTracker is a gRPC stats handler tracking the client connections.
+type connectionTracker struct {
+	mu          sync.Mutex
+	connections map[*connection]struct{}
+}
+
+func (t *connectionTracker) TagConn(ctx context.Context, info *stats.ConnTagInfo) context.Context {
+	var remote string
+	if info.RemoteAddr != nil {
+		remote = info.RemoteAddr.String()
+	}
+	return context.WithValue(ctx, connectionKey{}, &connection{remote: remote, connected: time.Now()})
+}
+
+func (t *connectionTracker) HandleConn(ctx context.Context, s stats.ConnStats) {
+	conn, ok := ctx.Value(connectionKey{}).(*connection)
+	if !ok {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	switch s.(type) {
+	case *stats.ConnBegin:
+		if t.connections == nil {
+			t.connections = map[*connection]struct{}{}
+		}
+		t.connections[conn] = struct{}{}
+	case *stats.ConnEnd:
+		delete(t.connections, conn)
+	}
+}
+
+func (t *connectionTracker) TagRPC(ctx context.Context, _ *stats.RPCTagInfo) context.Context {
+	return ctx
+}
+
+func (t *connectionTracker) HandleRPC(ctx context.Context, s stats.RPCStats) {
+	if _, ok := s.(*stats.Begin); !ok {
+		return
+	}
+	// The contexts of the requests derive from the one of their
+	// connection.
+	if conn, ok := ctx.Value(connectionKey{}).(*connection); ok {
+		conn.requests.Add(1)
+	}
+}
+
+func (t *connectionTracker) list() []ConnectionInfo {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	connections := make([]ConnectionInfo, 0, len(t.connections))
+	for conn := range t.connections {
+		connections = append(connections, ConnectionInfo{
+			Remote:    conn.remote,
+			Connected: conn.connected,
+			Requests:  conn.requests.Load(),
+		})
+	}
+	sort.Slice(connections, func(i, j int) bool { return connections[i].Connected.Before(connections[j].Connected) })
+	return connections
+}
+
+// StatsHandler returns the gRPC stats handler tracking the connections
+// listed by Connections.
+func (k *KVServerBridge) StatsHandler() stats.Handler {
+	return &k.connections
+}
+
+// Connections lists the client connections, oldest first. Only the
+// connections of the servers using StatsHandler are listed.
+func (k *KVServerBridge) Connections() []ConnectionInfo {
+	return k.connections.list()
+}
+
+// peerAddress returns the address of the client of ctx, if known.
+func peerAddress(ctx context.Context) string {
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		return p.Addr.String()
+	}
+	return ""
+}
@@ -2,31 +2,102 @@ package server
 
 import (
 	"context"
-	"fmt"
+	"io"
 
 	"go.etcd.io/etcd/api/v3/etcdserverpb"
 )
 
 func (s *KVServerBridge) LeaseGrant(ctx context.Context, req *etcdserverpb.LeaseGrantRequest) (*etcdserverpb.LeaseGrantResponse, error) {
+	if err := s.limited.checkQuota(ctx); err != nil {
+		return nil, err
+	}
+	id, err := s.limited.backend.LeaseGrant(ctx, req.ID, req.TTL)
+	if err != nil {
+		return nil, err
+	}
 	return &etcdserverpb.LeaseGrantResponse{
 		Header: &etcdserverpb.ResponseHeader{},
-		ID:     req.TTL,
+		ID:     id,
 		TTL:    req.TTL,
 	}, nil
 }
 
-func (s *KVServerBridge) LeaseRevoke(context.Context, *etcdserverpb.LeaseRevokeRequest) (*etcdserverpb.LeaseRevokeResponse, error) {
-	return nil, fmt.Errorf("lease revoke is not supported")
+// LeaseRevoke deletes the keys attached to the lease, then the lease itself.
+func (s *KVServerBridge) LeaseRevoke(ctx context.Context, req *etcdserverpb.LeaseRevokeRequest) (*etcdserverpb.LeaseRevokeResponse, error) {
+	if err := s.limited.backend.LeaseRevoke(ctx, req.ID); err != nil {
+		return nil, err
+	}
+	return &etcdserverpb.LeaseRevokeResponse{
+		Header: &etcdserverpb.ResponseHeader{},
+	}, nil
 }
 
-func (s *KVServerBridge) LeaseKeepAlive(etcdserverpb.Lease_LeaseKeepAliveServer) error {
-	return fmt.Errorf("lease keep alive is not supported")
+// LeaseKeepAlive renews the leases requested on the stream. As with etcd,
+// a TTL of zero is returned for leases that don't exist.
+func (s *KVServerBridge) LeaseKeepAlive(srv etcdserverpb.Lease_LeaseKeepAliveServer) error {
+	for {
+		req, err := srv.Recv()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		ttl, err := s.limited.backend.LeaseKeepAlive(srv.Context(), req.ID)
+		if err == ErrLeaseNotFound {
+			ttl = 0
+		} else if err != nil {
+			return err
+		}
+
+		if err := srv.Send(&etcdserverpb.LeaseKeepAliveResponse{
+			Header: &etcdserverpb.ResponseHeader{},
+			ID:     req.ID,
+			TTL:    ttl,
+		}); err != nil {
+			return err
+		}
+	}
 }
 
-func (s *KVServerBridge) LeaseTimeToLive(context.Context, *etcdserverpb.LeaseTimeToLiveRequest) (*etcdserverpb.LeaseTimeToLiveResponse, error) {
-	return nil, fmt.Errorf("lease time to live is not supported")
+func (s *KVServerBridge) LeaseTimeToLive(ctx context.Context, req *etcdserverpb.LeaseTimeToLiveRequest) (*etcdserverpb.LeaseTimeToLiveResponse, error) {
+	lease, err := s.limited.backend.LeaseTimeToLive(ctx, req.ID)
+	if err == ErrLeaseNotFound {
+		// etcd reports missing leases with a TTL of -1.
+		return &etcdserverpb.LeaseTimeToLiveResponse{
+			Header: &etcdserverpb.ResponseHeader{},
+			ID:     req.ID,
+			TTL:    -1,
+		}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	resp := &etcdserverpb.LeaseTimeToLiveResponse{
+		Header:     &etcdserverpb.ResponseHeader{},
+		ID:         lease.ID,
+		TTL:        lease.TTL,
+		GrantedTTL: lease.GrantedTTL,
+	}
+	if req.Keys {
+		for _, key := range lease.Keys {
+			resp.Keys = append(resp.Keys, []byte(key))
+		}
+	}
+	return resp, nil
 }
 
-func (s *KVServerBridge) LeaseLeases(context.Context, *etcdserverpb.LeaseLeasesRequest) (*etcdserverpb.LeaseLeasesResponse, error) {
-	return nil, fmt.Errorf("lease leases is not supported")
+// LeaseLeases lists the leases granted through all the members.
+func (s *KVServerBridge) LeaseLeases(ctx context.Context, req *etcdserverpb.LeaseLeasesRequest) (*etcdserverpb.LeaseLeasesResponse, error) {
+	ids, err := s.limited.backend.LeaseLeases(ctx)
+	if err != nil {
+		return nil, err
+	}
+	resp := &etcdserverpb.LeaseLeasesResponse{
+		Header: &etcdserverpb.ResponseHeader{},
+	}
+	for _, id := range ids {
+		resp.Leases = append(resp.Leases, &etcdserverpb.LeaseStatus{ID: id})
+	}
+	return resp, nil
 }
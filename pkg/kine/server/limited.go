@@ -8,7 +8,9 @@ import (
 )
 
 type LimitedServer struct {
-	backend Backend
+	backend  Backend
+	alarms   *alarms
+	auditLog AuditLog
 }
 
 func (l *LimitedServer) Range(ctx context.Context, r *etcdserverpb.RangeRequest) (*RangeResponse, error) {
@@ -39,7 +41,7 @@ func (l *LimitedServer) Txn(ctx context.Context, txn *etcdserverpb.TxnRequest) (
 	if isCompact(txn) {
 		return l.compact(ctx)
 	}
-	return nil, fmt.Errorf("unsupported transaction: %v", txn)
+	return l.txn(ctx, txn)
 }
 
 type ResponseHeader struct {
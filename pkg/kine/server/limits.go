@@ -0,0 +1,128 @@
+package server
+
+import (
+	"math"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.etcd.io/etcd/api/v3/etcdserverpb"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"golang.org/x/time/rate"
+)
+
+const (
+	// DefaultMaxRequestBytes and DefaultMaxTxnOps are the limits of the
+	// requests of etcd.
+	DefaultMaxRequestBytes = 1.5 * 1024 * 1024
+	DefaultMaxTxnOps       = 128
+
+	// GRPCOverheadBytes is the headroom for the overhead of gRPC added to
+	// the maximum size of a request to get the maximum size of the messages
+	// received, as in etcd.
+	GRPCOverheadBytes = 512 * 1024
+)
+
+var (
+	metricsShedRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "k8s_dqlite_shed_requests",
+		Help: "Total number of requests refused by the concurrency limits by reason (inflight, watch_rate or watch_streams)",
+	}, []string{"reason"})
+	metricsInflightRequests = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "k8s_dqlite_inflight_requests",
+		Help: "Number of unary requests being served",
+	})
+	metricsWatchStreams = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "k8s_dqlite_watch_streams",
+		Help: "Number of watch streams being served",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(metricsShedRequests, metricsInflightRequests, metricsWatchStreams)
+}
+
+// RequestLimits bound the write requests, so that oversized objects fail
+// fast instead of bloating the database and its replication log, and the
+// concurrency of the requests, so that retry storms of the clients are shed
+// instead of queuing up on the single writer of the database.
+type RequestLimits struct {
+	// MaxRequestBytes is the maximum size of a write request. If not
+	// positive, the size is not limited.
+	MaxRequestBytes int
+	// MaxTxnOps is the maximum number of compares, and of operations of
+	// each branch, of a transaction. If not positive, the number is not
+	// limited.
+	MaxTxnOps int
+	// MaxInflightRequests is the maximum number of unary requests served
+	// at once. If not positive, the number is not limited.
+	MaxInflightRequests int
+	// WatchCreateRate is the maximum number of watches created per
+	// second, with bursts of as many. If not positive, the rate is not
+	// limited.
+	WatchCreateRate float64
+	// MaxWatchStreams is the maximum number of watch streams served at
+	// once. If not positive, the number is not limited.
+	MaxWatchStreams int
+}
+
+// watchCreateLimiter returns the limiter of the watch creations, which
+// allows them all if their rate is not limited.
+func (l RequestLimits) watchCreateLimiter() *rate.Limiter {
+	if l.WatchCreateRate <= 0 {
+		return rate.NewLimiter(rate.Inf, 0)
+	}
+	return rate.NewLimiter(rate.Limit(l.WatchCreateRate), int(math.Max(1, math.Ceil(l.WatchCreateRate))))
+}
+
+// shedWatchCreate returns the response refusing a watch creation over the
+// rate limit, which etcd clients report as the cancellation of the watch.
+func shedWatchCreate() *etcdserverpb.WatchResponse {
+	metricsShedRequests.WithLabelValues("watch_rate").Inc()
+	return &etcdserverpb.WatchResponse{
+		Header:       &etcdserverpb.ResponseHeader{},
+		WatchId:      clientv3.InvalidWatchID,
+		Created:      true,
+		Canceled:     true,
+		CancelReason: rpctypes.ErrTooManyRequests.Error(),
+	}
+}
+
+// sizedRequest is a request whose encoded size is known.
+type sizedRequest interface {
+	Size() int
+}
+
+// check fails with the errors of etcd if the write request req exceeds the
+// limits. The other requests are not checked.
+func (l RequestLimits) check(req any) error {
+	switch req.(type) {
+	case *etcdserverpb.PutRequest, *etcdserverpb.DeleteRangeRequest, *etcdserverpb.TxnRequest:
+	default:
+		return nil
+	}
+	if l.MaxRequestBytes > 0 && req.(sizedRequest).Size() > l.MaxRequestBytes {
+		return rpctypes.ErrGRPCRequestTooLarge
+	}
+	if txn, ok := req.(*etcdserverpb.TxnRequest); ok && l.MaxTxnOps > 0 {
+		return l.checkTxnOps(txn)
+	}
+	return nil
+}
+
+// checkTxnOps fails with ErrGRPCTooManyOps if the transaction or one of
+// its nested transactions has too many compares or operations.
+func (l RequestLimits) checkTxnOps(r *etcdserverpb.TxnRequest) error {
+	if len(r.Compare) > l.MaxTxnOps || len(r.Success) > l.MaxTxnOps || len(r.Failure) > l.MaxTxnOps {
+		return rpctypes.ErrGRPCTooManyOps
+	}
+	for _, ops := range [][]*etcdserverpb.RequestOp{r.Success, r.Failure} {
+		for _, op := range ops {
+			if nested := op.GetRequestTxn(); nested != nil {
+				if err := l.checkTxnOps(nested); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
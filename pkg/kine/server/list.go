@@ -6,7 +6,6 @@ import (
 	"fmt"
 	"strings"
 
-	"github.com/sirupsen/logrus"
 	"go.etcd.io/etcd/api/v3/etcdserverpb"
 	"go.opentelemetry.io/otel/attribute"
 )
@@ -28,26 +27,47 @@ func (l *LimitedServer) list(ctx context.Context, r *etcdserverpb.RangeRequest)
 		return nil, fmt.Errorf("invalid range end length of 0")
 	}
 
-	prefix := string(append(r.RangeEnd[:len(r.RangeEnd)-1], r.RangeEnd[len(r.RangeEnd)-1]-1))
-	if !strings.HasSuffix(prefix, "/") {
-		prefix = prefix + "/"
+	var (
+		listFn  func(ctx context.Context, limit, revision int64) (int64, []*KeyValue, error)
+		countFn func(ctx context.Context, revision int64) (int64, int64, error)
+	)
+	opts := rangeOptions(r)
+	if isPrefixRange(r.Key, r.RangeEnd) && opts == (RangeOptions{}) {
+		prefix, start := rangePrefix(r.Key, r.RangeEnd)
+		span.SetAttributes(
+			attribute.String("prefix", prefix),
+			attribute.String("start", start),
+		)
+		listFn = func(ctx context.Context, limit, revision int64) (int64, []*KeyValue, error) {
+			return l.backend.List(ctx, prefix, start, limit, revision)
+		}
+		countFn = func(ctx context.Context, revision int64) (int64, int64, error) {
+			return l.backend.Count(ctx, prefix, start, revision)
+		}
+	} else {
+		start, end := keyRange(r.Key, r.RangeEnd)
+		span.SetAttributes(
+			attribute.String("start", start),
+			attribute.String("end", end),
+		)
+		listFn = func(ctx context.Context, limit, revision int64) (int64, []*KeyValue, error) {
+			return l.backend.ListRange(ctx, start, end, limit, revision, opts)
+		}
+		countFn = func(ctx context.Context, revision int64) (int64, int64, error) {
+			return l.backend.CountRange(ctx, start, end, revision, opts)
+		}
 	}
-	start := string(bytes.TrimRight(r.Key, "\x00"))
 	revision := r.Revision
-	span.SetAttributes(
-		attribute.String("prefix", prefix),
-		attribute.String("start", start),
-		attribute.Int64("revision", revision),
-	)
+	span.SetAttributes(attribute.Int64("revision", revision))
 
 	if r.CountOnly {
-		rev, count, err := l.backend.Count(ctx, prefix, start, revision)
+		rev, count, err := countFn(ctx, revision)
 		if err != nil {
 			return nil, err
 		}
 		span.SetAttributes(attribute.Int64("count", count))
 
-		logrus.Tracef("LIST COUNT key=%s, end=%s, revision=%d, currentRev=%d count=%d", r.Key, r.RangeEnd, revision, rev, count)
+		logger.Tracef("LIST COUNT key=%s, end=%s, revision=%d, currentRev=%d count=%d", r.Key, r.RangeEnd, revision, rev, count)
 		return &RangeResponse{
 			Header: txnHeader(rev),
 			Count:  count,
@@ -60,7 +80,7 @@ func (l *LimitedServer) list(ctx context.Context, r *etcdserverpb.RangeRequest)
 	}
 	span.SetAttributes(attribute.Int64("limit", limit))
 
-	rev, kvs, err := l.backend.List(ctx, prefix, start, limit, revision)
+	rev, kvs, err := listFn(ctx, limit, revision)
 	if err != nil {
 		return nil, err
 	}
@@ -82,15 +102,73 @@ func (l *LimitedServer) list(ctx context.Context, r *etcdserverpb.RangeRequest)
 		}
 
 		// count the actual number of results if there are more items in the db.
-		rev, resp.Count, err = l.backend.Count(ctx, prefix, start, revision)
+		rev, resp.Count, err = countFn(ctx, revision)
 		if err != nil {
 			return nil, err
 		}
 
 		span.SetAttributes(attribute.Int64("count", resp.Count))
-		logrus.Tracef("LIST COUNT key=%s, end=%s, revision=%d, currentRev=%d count=%d", r.Key, r.RangeEnd, revision, rev, resp.Count)
+		logger.Tracef("LIST COUNT key=%s, end=%s, revision=%d, currentRev=%d count=%d", r.Key, r.RangeEnd, revision, rev, resp.Count)
 		resp.Header = txnHeader(rev)
 	}
 
 	return resp, nil
 }
+
+// rangeOptions returns the options of a range request. As in etcd, keys
+// are sorted in ascending order when a sort target other than the key is
+// set without an order.
+func rangeOptions(r *etcdserverpb.RangeRequest) RangeOptions {
+	opts := RangeOptions{
+		KeysOnly:          r.KeysOnly,
+		MinModRevision:    r.MinModRevision,
+		MaxModRevision:    r.MaxModRevision,
+		MinCreateRevision: r.MinCreateRevision,
+		MaxCreateRevision: r.MaxCreateRevision,
+	}
+	if r.SortOrder != etcdserverpb.RangeRequest_NONE || r.SortTarget != etcdserverpb.RangeRequest_KEY {
+		opts.SortTarget = SortTarget(r.SortTarget)
+		opts.SortDescending = r.SortOrder == etcdserverpb.RangeRequest_DESCEND
+	}
+	return opts
+}
+
+// rangePrefix returns the prefix of the keys between key and rangeEnd, and
+// the key the range starts from.
+func rangePrefix(key, rangeEnd []byte) (prefix, start string) {
+	prefix = string(append(rangeEnd[:len(rangeEnd)-1:len(rangeEnd)-1], rangeEnd[len(rangeEnd)-1]-1))
+	if !strings.HasSuffix(prefix, "/") {
+		prefix = prefix + "/"
+	}
+	return prefix, string(bytes.TrimRight(key, "\x00"))
+}
+
+// isPrefixRange reports whether the range between key and rangeEnd holds
+// the keys under a prefix ending with a slash, which is how the apiserver
+// lists, possibly continuing from key.
+func isPrefixRange(key, rangeEnd []byte) bool {
+	n := len(rangeEnd)
+	if n == 0 || rangeEnd[n-1] != '/'+1 {
+		return false
+	}
+	prefix := append(rangeEnd[:n-1:n-1], '/')
+	return bytes.HasPrefix(key, prefix)
+}
+
+// maxKey is greater than any valid UTF-8 key, and bounds the ranges
+// that have no end.
+const maxKey = "\U0010FFFF"
+
+// keyRange returns the bounds of the keys between key, inclusive, and
+// rangeEnd, exclusive. As in etcd, a key or range end of "\x00" leaves
+// the range unbounded.
+func keyRange(key, rangeEnd []byte) (start, end string) {
+	start, end = string(key), string(rangeEnd)
+	if start == "\x00" {
+		start = ""
+	}
+	if end == "\x00" {
+		end = maxKey
+	}
+	return start, end
+}
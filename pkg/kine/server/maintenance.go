@@ -4,40 +4,127 @@ import (
 	"context"
 	"fmt"
 
+	"github.com/sirupsen/logrus"
 	"go.etcd.io/etcd/api/v3/etcdserverpb"
 )
 
+// emulatedEtcdVersion is the etcd version reported to clients.
+const emulatedEtcdVersion = "3.5.12"
+
 var _ etcdserverpb.MaintenanceServer = (*KVServerBridge)(nil)
 
-func (s *KVServerBridge) Alarm(context.Context, *etcdserverpb.AlarmRequest) (*etcdserverpb.AlarmResponse, error) {
-	return nil, fmt.Errorf("alarm is not supported")
+// Alarm lists, raises or disarms the alarms of the cluster.
+func (s *KVServerBridge) Alarm(ctx context.Context, r *etcdserverpb.AlarmRequest) (*etcdserverpb.AlarmResponse, error) {
+	active, err := s.limited.alarm(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+
+	var memberID uint64
+	if s.cluster != nil {
+		memberID = s.cluster.MemberID()
+	}
+	resp := &etcdserverpb.AlarmResponse{
+		Header: &etcdserverpb.ResponseHeader{},
+	}
+	for _, alarm := range active {
+		resp.Alarms = append(resp.Alarms, &etcdserverpb.AlarmMember{
+			MemberID: memberID,
+			Alarm:    alarm,
+		})
+	}
+	return resp, nil
 }
 
 func (s *KVServerBridge) Status(ctx context.Context, r *etcdserverpb.StatusRequest) (*etcdserverpb.StatusResponse, error) {
-	size, err := s.limited.dbSize(ctx)
+	size, err := s.limited.dbFileSize(ctx)
 	if err != nil {
 		return nil, err
 	}
-	return &etcdserverpb.StatusResponse{
-		Header: &etcdserverpb.ResponseHeader{},
-		DbSize: size,
-	}, nil
+	sizeInUse, err := s.limited.dbSize(ctx)
+	if err != nil {
+		return nil, err
+	}
+	rev, err := s.limited.backend.CurrentRevision(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &etcdserverpb.StatusResponse{
+		Header: &etcdserverpb.ResponseHeader{
+			Revision: rev,
+		},
+		Version:     emulatedEtcdVersion,
+		DbSize:      size,
+		DbSizeInUse: sizeInUse,
+	}
+
+	if s.cluster != nil {
+		resp.Header.MemberId = s.cluster.MemberID()
+
+		if resp.Leader, err = s.cluster.Leader(ctx); err != nil {
+			resp.Errors = append(resp.Errors, fmt.Sprintf("failed to get leader: %v", err))
+		}
+		// The entries persisted by the member are the ones it applied, as
+		// far as the datastore can tell.
+		if term, index, err := s.cluster.RaftStatus(); err != nil {
+			resp.Errors = append(resp.Errors, fmt.Sprintf("failed to get raft status: %v", err))
+		} else {
+			resp.Header.RaftTerm = term
+			resp.RaftTerm = term
+			resp.RaftIndex = index
+			resp.RaftAppliedIndex = index
+		}
+	}
+	return resp, nil
 }
 
-func (s *KVServerBridge) Defragment(context.Context, *etcdserverpb.DefragmentRequest) (*etcdserverpb.DefragmentResponse, error) {
-	return nil, fmt.Errorf("defragment is not supported")
+// Defragment vacuums the database, returning its unused pages to the file system.
+func (s *KVServerBridge) Defragment(ctx context.Context, r *etcdserverpb.DefragmentRequest) (*etcdserverpb.DefragmentResponse, error) {
+	before, after, err := s.limited.defragment(ctx)
+	if err != nil {
+		logger.WithError(err).Error("failed to defragment the datastore")
+		return nil, err
+	}
+	logger.WithFields(logrus.Fields{"before": before, "after": after}).Info("Defragmented the datastore")
+	return &etcdserverpb.DefragmentResponse{
+		Header: &etcdserverpb.ResponseHeader{},
+	}, nil
 }
 
-func (s *KVServerBridge) Hash(context.Context, *etcdserverpb.HashRequest) (*etcdserverpb.HashResponse, error) {
-	return nil, fmt.Errorf("hash is not supported")
+// Hash returns the hash of all the revisions retained in the datastore.
+func (s *KVServerBridge) Hash(ctx context.Context, r *etcdserverpb.HashRequest) (*etcdserverpb.HashResponse, error) {
+	hash, _, rev, err := s.limited.hash(ctx, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &etcdserverpb.HashResponse{
+		Header: s.hashHeader(rev),
+		Hash:   hash,
+	}, nil
 }
 
-func (s *KVServerBridge) HashKV(context.Context, *etcdserverpb.HashKVRequest) (*etcdserverpb.HashKVResponse, error) {
-	return nil, fmt.Errorf("hash kv is not supported")
+// HashKV returns the hash of the revisions between the compact revision and
+// the requested one. Comparing it across members at the same revision detects
+// diverging replicas.
+func (s *KVServerBridge) HashKV(ctx context.Context, r *etcdserverpb.HashKVRequest) (*etcdserverpb.HashKVResponse, error) {
+	hash, compactRev, rev, err := s.limited.hash(ctx, r.Revision)
+	if err != nil {
+		return nil, err
+	}
+	return &etcdserverpb.HashKVResponse{
+		Header:          s.hashHeader(rev),
+		Hash:            hash,
+		CompactRevision: compactRev,
+	}, nil
 }
 
-func (s *KVServerBridge) Snapshot(*etcdserverpb.SnapshotRequest, etcdserverpb.Maintenance_SnapshotServer) error {
-	return fmt.Errorf("snapshot is not supported")
+func (s *KVServerBridge) hashHeader(rev int64) *etcdserverpb.ResponseHeader {
+	header := &etcdserverpb.ResponseHeader{Revision: rev}
+	if s.cluster != nil {
+		header.MemberId = s.cluster.MemberID()
+	}
+	return header
 }
 
 func (s *KVServerBridge) MoveLeader(context.Context, *etcdserverpb.MoveLeaderRequest) (*etcdserverpb.MoveLeaderResponse, error) {
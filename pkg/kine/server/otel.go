@@ -1,12 +1,14 @@
 package server
 
 import (
+	"context"
 	"fmt"
 
-	"github.com/sirupsen/logrus"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/propagation"
 	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/metadata"
 )
 
 const otelName = "limited-server"
@@ -19,6 +21,10 @@ var (
 	getCnt     metric.Int64Counter
 	listCnt    metric.Int64Counter
 	updateCnt  metric.Int64Counter
+	txnCnt     metric.Int64Counter
+	// watchCanceledCnt counts the watches canceled by the server, as
+	// opposed to the ones canceled by the clients.
+	watchCanceledCnt metric.Int64Counter
 )
 
 func init() {
@@ -28,22 +34,67 @@ func init() {
 
 	createCnt, err = otelMeter.Int64Counter(fmt.Sprintf("%s.create", otelName), metric.WithDescription("Number of create requests"))
 	if err != nil {
-		logrus.WithError(err).Warning("Otel failed to create create counter")
+		logger.WithError(err).Warning("Otel failed to create create counter")
 	}
 	deleteCnt, err = otelMeter.Int64Counter(fmt.Sprintf("%s.delete", otelName), metric.WithDescription("Number of delete requests"))
 	if err != nil {
-		logrus.WithError(err).Warning("Otel failed to create delete counter")
+		logger.WithError(err).Warning("Otel failed to create delete counter")
 	}
 	getCnt, err = otelMeter.Int64Counter(fmt.Sprintf("%s.get", otelName), metric.WithDescription("Number of get requests"))
 	if err != nil {
-		logrus.WithError(err).Warning("Otel failed to create get counter")
+		logger.WithError(err).Warning("Otel failed to create get counter")
 	}
 	listCnt, err = otelMeter.Int64Counter(fmt.Sprintf("%s.list", otelName), metric.WithDescription("Number of list requests"))
 	if err != nil {
-		logrus.WithError(err).Warning("Otel failed to create list counter")
+		logger.WithError(err).Warning("Otel failed to create list counter")
 	}
 	updateCnt, err = otelMeter.Int64Counter(fmt.Sprintf("%s.update", otelName), metric.WithDescription("Number of update requests"))
 	if err != nil {
-		logrus.WithError(err).Warning("Otel failed to create update counter")
+		logger.WithError(err).Warning("Otel failed to create update counter")
 	}
+	txnCnt, err = otelMeter.Int64Counter(fmt.Sprintf("%s.txn", otelName), metric.WithDescription("Number of generic transaction requests"))
+	if err != nil {
+		logger.WithError(err).Warning("Otel failed to create txn counter")
+	}
+	watchCanceledCnt, err = otelMeter.Int64Counter(fmt.Sprintf("%s.watch_canceled", otelName), metric.WithDescription("Number of watches canceled by the server"))
+	if err != nil {
+		logger.WithError(err).Warning("Otel failed to create watch canceled counter")
+	}
+}
+
+// otelPropagator reads the W3C trace context sent by the clients, such as
+// the apiserver with tracing enabled, in the metadata of the requests.
+var otelPropagator = propagation.TraceContext{}
+
+// traceContext returns ctx with the trace context of the client, if any, so
+// that the spans of the request, down to the SQL statements it runs,
+// continue the trace of the client.
+func traceContext(ctx context.Context) context.Context {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ctx
+	}
+	return otelPropagator.Extract(ctx, metadataCarrier(md))
+}
+
+// metadataCarrier carries the trace context in the metadata of a request.
+type metadataCarrier metadata.MD
+
+func (c metadataCarrier) Get(key string) string {
+	if values := metadata.MD(c).Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+func (c metadataCarrier) Set(key, value string) {
+	metadata.MD(c).Set(key, value)
+}
+
+func (c metadataCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for key := range c {
+		keys = append(keys, key)
+	}
+	return keys
 }
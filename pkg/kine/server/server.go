@@ -3,29 +3,68 @@ package server
 import (
 	"context"
 	"fmt"
+	"sync/atomic"
+	"time"
 
-	"github.com/sirupsen/logrus"
+	"github.com/canonical/k8s-dqlite/pkg/logging"
 	"go.etcd.io/etcd/api/v3/etcdserverpb"
 	"go.etcd.io/etcd/api/v3/mvccpb"
+	"golang.org/x/time/rate"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/health"
 	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 )
 
+var logger = logging.Component(logging.Server)
+
 var (
 	_ etcdserverpb.KVServer    = (*KVServerBridge)(nil)
 	_ etcdserverpb.WatchServer = (*KVServerBridge)(nil)
 )
 
+// healthCheckInterval is the interval between the reads of the datastore
+// setting the status of the gRPC health service.
+const healthCheckInterval = 5 * time.Second
+
 type KVServerBridge struct {
-	limited *LimitedServer
+	limited  *LimitedServer
+	cluster  Cluster
+	health   *health.Server
+	inflight inflight
+	limits   RequestLimits
+	// watchCreates limits the rate of the watch creations, and
+	// watchStreams counts the watch streams being served.
+	watchCreates *rate.Limiter
+	watchStreams atomic.Int64
+	// watches and connections track the watches and the client
+	// connections being served, for introspection.
+	watches     watchRegistry
+	connections connectionTracker
+
+	watchProgressNotifyInterval time.Duration
 }
 
-func New(backend Backend) *KVServerBridge {
+// New creates a server for the backend. The cluster is optional and
+// is only used to report the cluster state in maintenance requests, and
+// to serve the cluster service.
+// Writes are refused once the database grows larger than the quota.
+// Watches that request progress notifications get one every
+// watchProgressNotifyInterval. The writes are recorded in the audit log,
+// if any, and refused if they exceed the limits.
+func New(backend Backend, cluster Cluster, quota *Quota, watchProgressNotifyInterval time.Duration, auditLog AuditLog, limits RequestLimits) *KVServerBridge {
 	return &KVServerBridge{
 		limited: &LimitedServer{
-			backend: backend,
+			backend:  backend,
+			alarms:   newAlarms(quota),
+			auditLog: auditLog,
 		},
+		cluster: cluster,
+		health:  health.NewServer(),
+		limits:  limits,
+
+		watchCreates: limits.watchCreateLimiter(),
+
+		watchProgressNotifyInterval: watchProgressNotifyInterval,
 	}
 }
 
@@ -34,56 +73,58 @@ func (k *KVServerBridge) Register(server *grpc.Server) {
 	etcdserverpb.RegisterWatchServer(server, k)
 	etcdserverpb.RegisterKVServer(server, k)
 	etcdserverpb.RegisterMaintenanceServer(server, k)
+	etcdserverpb.RegisterClusterServer(server, k)
 
-	hsrv := health.NewServer()
-	hsrv.SetServingStatus("", healthpb.HealthCheckResponse_SERVING)
-	healthpb.RegisterHealthServer(server, hsrv)
+	healthpb.RegisterHealthServer(server, k.health)
 }
 
-func (k *KVServerBridge) Range(ctx context.Context, r *etcdserverpb.RangeRequest) (*etcdserverpb.RangeResponse, error) {
-	if r.KeysOnly {
-		return nil, unsupported("keysOnly")
-	}
-
-	if r.MaxCreateRevision != 0 {
-		return nil, unsupported("maxCreateRevision")
-	}
-
-	if r.SortOrder != 0 {
-		return nil, unsupported("sortOrder")
-	}
-
-	if r.SortTarget != 0 {
-		return nil, unsupported("sortTarget")
-	}
-
-	if r.Serializable {
-		return nil, unsupported("serializable")
-	}
-
-	if r.KeysOnly {
-		return nil, unsupported("keysOnly")
-	}
-
-	if r.MinModRevision != 0 {
-		return nil, unsupported("minModRevision")
-	}
-
-	if r.MinCreateRevision != 0 {
-		return nil, unsupported("minCreateRevision")
-	}
+// CheckHealth sets the status of the gRPC health service, serving as long
+// as the datastore answers reads, until ctx is done.
+func (k *KVServerBridge) CheckHealth(ctx context.Context) {
+	ticker := time.NewTicker(healthCheckInterval)
+	defer ticker.Stop()
+	defer k.health.Shutdown()
+
+	status := healthpb.HealthCheckResponse_UNKNOWN
+	for {
+		next := healthpb.HealthCheckResponse_SERVING
+		checkCtx, cancel := context.WithTimeout(ctx, healthCheckInterval)
+		_, err := k.limited.backend.CurrentRevision(checkCtx)
+		cancel()
+		if err != nil {
+			next = healthpb.HealthCheckResponse_NOT_SERVING
+		}
+		if next != status {
+			if err != nil && ctx.Err() == nil {
+				logger.WithError(err).Warning("Datastore health check failed")
+			}
+			status = next
+			k.health.SetServingStatus("", status)
+		}
 
-	if r.MaxCreateRevision != 0 {
-		return nil, unsupported("maxCreateRevision")
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
 	}
+}
 
-	if r.MaxModRevision != 0 {
-		return nil, unsupported("maxModRevision")
+// Range serves the reads. The serializable reads, which etcd serves from
+// the local member, are served as the other reads: dqlite only serves the
+// queries on the leader, so that the followers have no local replica to
+// read from. The linearizable reads wait for a read barrier first.
+func (k *KVServerBridge) Range(ctx context.Context, r *etcdserverpb.RangeRequest) (*etcdserverpb.RangeResponse, error) {
+	if !r.Serializable {
+		if err := k.limited.backend.ReadBarrier(ctx); err != nil {
+			logger.Errorf("error while waiting for the read barrier of range on %s %s: %v", r.Key, r.RangeEnd, err)
+			return nil, err
+		}
 	}
 
 	resp, err := k.limited.Range(ctx, r)
 	if err != nil {
-		logrus.Errorf("error while range on %s %s: %v", r.Key, r.RangeEnd, err)
+		logger.Errorf("error while range on %s %s: %v", r.Key, r.RangeEnd, err)
 		return nil, err
 	}
 
@@ -129,14 +170,24 @@ func (k *KVServerBridge) Put(ctx context.Context, r *etcdserverpb.PutRequest) (*
 	return nil, fmt.Errorf("put is not supported")
 }
 
+// DeleteRange deletes the keys of the range in a single transaction.
 func (k *KVServerBridge) DeleteRange(ctx context.Context, r *etcdserverpb.DeleteRangeRequest) (*etcdserverpb.DeleteRangeResponse, error) {
-	return nil, fmt.Errorf("delete is not supported")
+	resp, err := k.limited.txn(ctx, &etcdserverpb.TxnRequest{
+		Success: []*etcdserverpb.RequestOp{
+			{Request: &etcdserverpb.RequestOp_RequestDeleteRange{RequestDeleteRange: r}},
+		},
+	})
+	if err != nil {
+		logger.Errorf("error while deleting range %s %s: %v", r.Key, r.RangeEnd, err)
+		return nil, err
+	}
+	return resp.Responses[0].GetResponseDeleteRange(), nil
 }
 
 func (k *KVServerBridge) Txn(ctx context.Context, r *etcdserverpb.TxnRequest) (*etcdserverpb.TxnResponse, error) {
 	res, err := k.limited.Txn(ctx, r)
 	if err != nil {
-		logrus.Errorf("error in txn: %v", err)
+		logger.Errorf("error in txn: %v", err)
 	}
 	return res, err
 }
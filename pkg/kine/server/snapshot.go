@@ -0,0 +1,84 @@
+package server
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"go.etcd.io/etcd/api/v3/etcdserverpb"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// snapshotChunkSize is the size of the blobs streamed to snapshot clients.
+const snapshotChunkSize = 32 * 1024
+
+// Snapshot streams a consistent copy of the datastore, as a SQLite database
+// file. As with etcd, the stream ends with the sha256 checksum of the file.
+func (s *KVServerBridge) Snapshot(r *etcdserverpb.SnapshotRequest, srv etcdserverpb.Maintenance_SnapshotServer) (err error) {
+	ctx, span := otelTracer.Start(srv.Context(), fmt.Sprintf("%s.snapshot", otelName))
+	defer func() {
+		span.RecordError(err)
+		span.End()
+	}()
+
+	dir, err := os.MkdirTemp("", "kine-snapshot-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "snapshot.db")
+
+	rev, err := s.limited.backend.DoBackup(ctx, path)
+	if err != nil {
+		logger.WithError(err).Error("failed to back up the datastore")
+		return err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	remaining := uint64(info.Size()) + sha256.Size
+	span.SetAttributes(attribute.Int64("revision", rev), attribute.Int64("size", info.Size()))
+
+	header := &etcdserverpb.ResponseHeader{Revision: rev}
+	if s.cluster != nil {
+		header.MemberId = s.cluster.MemberID()
+	}
+
+	hash := sha256.New()
+	buf := make([]byte, snapshotChunkSize)
+	for {
+		n, err := io.ReadFull(f, buf)
+		if n > 0 {
+			hash.Write(buf[:n])
+			remaining -= uint64(n)
+			if err := srv.Send(&etcdserverpb.SnapshotResponse{
+				Header:         header,
+				RemainingBytes: remaining,
+				Blob:           buf[:n],
+			}); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		} else if err != nil {
+			return err
+		}
+	}
+
+	return srv.Send(&etcdserverpb.SnapshotResponse{
+		Header:         header,
+		RemainingBytes: 0,
+		Blob:           hash.Sum(nil),
+	})
+}
@@ -0,0 +1,387 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"go.etcd.io/etcd/api/v3/etcdserverpb"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// txn runs a transaction that doesn't match any of the requests of the
+// apiserver. The compares are evaluated and the operations are applied in
+// a single database transaction.
+func (l *LimitedServer) txn(ctx context.Context, r *etcdserverpb.TxnRequest) (resp *etcdserverpb.TxnResponse, err error) {
+	txnCnt.Add(ctx, 1)
+	ctx, span := otelTracer.Start(ctx, fmt.Sprintf("%s.txn", otelName))
+	defer func() {
+		span.RecordError(err)
+		span.End()
+	}()
+	span.SetAttributes(
+		attribute.Int("compares", len(r.Compare)),
+		attribute.Int("success", len(r.Success)),
+		attribute.Int("failure", len(r.Failure)),
+	)
+
+	if err := checkTxn(r); err != nil {
+		return nil, err
+	}
+	if hasPuts(r) {
+		if err := l.checkQuota(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	var writes []auditWrite
+	err = l.backend.Txn(ctx, func(tx Transaction) error {
+		t := &txnEval{tx: tx}
+		resp, err = t.eval(ctx, r)
+		writes = t.writes
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	l.audit(ctx, writes...)
+	span.SetAttributes(attribute.Bool("succeeded", resp.Succeeded), attribute.Int64("revision", resp.Header.Revision))
+	return resp, nil
+}
+
+// checkTxn refuses the transactions writing the same key more than once
+// in the same list of operations, as etcd does.
+func checkTxn(r *etcdserverpb.TxnRequest) error {
+	for _, ops := range [][]*etcdserverpb.RequestOp{r.Success, r.Failure} {
+		puts := map[string]struct{}{}
+		for _, op := range ops {
+			switch op := op.Request.(type) {
+			case *etcdserverpb.RequestOp_RequestPut:
+				key := string(op.RequestPut.Key)
+				if _, ok := puts[key]; ok {
+					return rpctypes.ErrGRPCDuplicateKey
+				}
+				puts[key] = struct{}{}
+			case *etcdserverpb.RequestOp_RequestTxn:
+				if err := checkTxn(op.RequestTxn); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// hasPuts reports whether the transaction may grow the database, in which
+// case it is refused once the quota is exceeded. Deletes are allowed, as
+// they are needed to reclaim space.
+func hasPuts(r *etcdserverpb.TxnRequest) bool {
+	for _, ops := range [][]*etcdserverpb.RequestOp{r.Success, r.Failure} {
+		for _, op := range ops {
+			switch op := op.Request.(type) {
+			case *etcdserverpb.RequestOp_RequestPut:
+				return true
+			case *etcdserverpb.RequestOp_RequestTxn:
+				if hasPuts(op.RequestTxn) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// txnEval evaluates a transaction request against a backend transaction.
+type txnEval struct {
+	tx Transaction
+	// rev is the revision of the last write, if any.
+	rev int64
+	// writes are the writes to audit once committed.
+	writes []auditWrite
+}
+
+func (t *txnEval) eval(ctx context.Context, r *etcdserverpb.TxnRequest) (*etcdserverpb.TxnResponse, error) {
+	succeeded := true
+	for _, c := range r.Compare {
+		ok, err := t.compare(ctx, c)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			succeeded = false
+			break
+		}
+	}
+
+	ops := r.Success
+	if !succeeded {
+		ops = r.Failure
+	}
+	responses := make([]*etcdserverpb.ResponseOp, 0, len(ops))
+	for _, op := range ops {
+		resp, err := t.apply(ctx, op)
+		if err != nil {
+			return nil, err
+		}
+		responses = append(responses, resp)
+	}
+
+	header, err := t.header(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &etcdserverpb.TxnResponse{
+		Header:    header,
+		Succeeded: succeeded,
+		Responses: responses,
+	}, nil
+}
+
+// header returns the header of a response, with the revision of the last
+// write, or the current revision if nothing was written yet.
+func (t *txnEval) header(ctx context.Context) (*etcdserverpb.ResponseHeader, error) {
+	if t.rev > 0 {
+		return txnHeader(t.rev), nil
+	}
+	rev, err := t.tx.CurrentRevision(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return txnHeader(rev), nil
+}
+
+// rangeKVs returns the current values of the keys between key and rangeEnd,
+// or of key alone if rangeEnd is empty.
+func (t *txnEval) rangeKVs(ctx context.Context, key, rangeEnd []byte, limit int64, opts RangeOptions) ([]*KeyValue, error) {
+	if len(rangeEnd) == 0 {
+		kv, err := t.tx.Get(ctx, string(key))
+		if err != nil || kv == nil || !opts.matches(kv) {
+			return nil, err
+		}
+		return []*KeyValue{kv}, nil
+	}
+	start, end := keyRange(key, rangeEnd)
+	return t.tx.List(ctx, start, end, limit, opts)
+}
+
+func (t *txnEval) compare(ctx context.Context, c *etcdserverpb.Compare) (bool, error) {
+	kvs, err := t.rangeKVs(ctx, c.Key, c.RangeEnd, 0, RangeOptions{})
+	if err != nil {
+		return false, err
+	}
+	if len(kvs) == 0 {
+		if c.Target == etcdserverpb.Compare_VALUE {
+			// Missing keys have no value to compare with.
+			return false, nil
+		}
+		kvs = []*KeyValue{{}}
+	}
+	for _, kv := range kvs {
+		ok, err := compareKV(c, kv)
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func compareKV(c *etcdserverpb.Compare, kv *KeyValue) (bool, error) {
+	var result int
+	switch c.Target {
+	case etcdserverpb.Compare_VALUE:
+		result = bytes.Compare(kv.Value, c.GetValue())
+	case etcdserverpb.Compare_CREATE:
+		result = compareInt64(kv.CreateRevision, c.GetCreateRevision())
+	case etcdserverpb.Compare_MOD:
+		result = compareInt64(kv.ModRevision, c.GetModRevision())
+	case etcdserverpb.Compare_LEASE:
+		result = compareInt64(kv.Lease, c.GetLease())
+	case etcdserverpb.Compare_VERSION:
+		// Versions are not tracked, so only the existence of the
+		// key can be checked, as a comparison with version zero.
+		if c.GetVersion() != 0 {
+			return false, unsupported("version compare")
+		}
+		if kv.ModRevision != 0 {
+			result = 1
+		}
+	default:
+		return false, fmt.Errorf("unknown compare target %v", c.Target)
+	}
+
+	switch c.Result {
+	case etcdserverpb.Compare_EQUAL:
+		return result == 0, nil
+	case etcdserverpb.Compare_NOT_EQUAL:
+		return result != 0, nil
+	case etcdserverpb.Compare_GREATER:
+		return result > 0, nil
+	case etcdserverpb.Compare_LESS:
+		return result < 0, nil
+	}
+	return false, fmt.Errorf("unknown compare result %v", c.Result)
+}
+
+func compareInt64(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func (t *txnEval) apply(ctx context.Context, op *etcdserverpb.RequestOp) (*etcdserverpb.ResponseOp, error) {
+	switch op := op.Request.(type) {
+	case *etcdserverpb.RequestOp_RequestRange:
+		resp, err := t.rangeOp(ctx, op.RequestRange)
+		if err != nil {
+			return nil, err
+		}
+		return &etcdserverpb.ResponseOp{Response: &etcdserverpb.ResponseOp_ResponseRange{ResponseRange: resp}}, nil
+	case *etcdserverpb.RequestOp_RequestPut:
+		resp, err := t.put(ctx, op.RequestPut)
+		if err != nil {
+			return nil, err
+		}
+		return &etcdserverpb.ResponseOp{Response: &etcdserverpb.ResponseOp_ResponsePut{ResponsePut: resp}}, nil
+	case *etcdserverpb.RequestOp_RequestDeleteRange:
+		resp, err := t.deleteRange(ctx, op.RequestDeleteRange)
+		if err != nil {
+			return nil, err
+		}
+		return &etcdserverpb.ResponseOp{Response: &etcdserverpb.ResponseOp_ResponseDeleteRange{ResponseDeleteRange: resp}}, nil
+	case *etcdserverpb.RequestOp_RequestTxn:
+		resp, err := t.eval(ctx, op.RequestTxn)
+		if err != nil {
+			return nil, err
+		}
+		return &etcdserverpb.ResponseOp{Response: &etcdserverpb.ResponseOp_ResponseTxn{ResponseTxn: resp}}, nil
+	}
+	return nil, fmt.Errorf("unknown transaction operation %T", op.Request)
+}
+
+func (t *txnEval) rangeOp(ctx context.Context, r *etcdserverpb.RangeRequest) (*etcdserverpb.RangeResponse, error) {
+	if r.Revision != 0 {
+		return nil, unsupported("revision in transaction")
+	}
+
+	limit := r.Limit
+	if limit > 0 {
+		limit++
+	}
+	kvs, err := t.rangeKVs(ctx, r.Key, r.RangeEnd, limit, rangeOptions(r))
+	if err != nil {
+		return nil, err
+	}
+	header, err := t.header(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &etcdserverpb.RangeResponse{
+		Header: header,
+		Count:  int64(len(kvs)),
+	}
+	if r.Limit > 0 && int64(len(kvs)) > r.Limit {
+		resp.More = true
+		kvs = kvs[:r.Limit]
+		if len(r.RangeEnd) > 0 {
+			all, err := t.rangeKVs(ctx, r.Key, r.RangeEnd, 0, RangeOptions{})
+			if err != nil {
+				return nil, err
+			}
+			resp.Count = int64(len(all))
+		}
+	}
+	if !r.CountOnly {
+		resp.Kvs = toKVs(kvs...)
+		if r.KeysOnly {
+			for _, kv := range resp.Kvs {
+				kv.Value = nil
+			}
+		}
+	}
+	return resp, nil
+}
+
+func (t *txnEval) put(ctx context.Context, r *etcdserverpb.PutRequest) (*etcdserverpb.PutResponse, error) {
+	key := string(r.Key)
+	prev, err := t.tx.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	value, lease := r.Value, r.Lease
+	if r.IgnoreValue || r.IgnoreLease {
+		if prev == nil {
+			return nil, rpctypes.ErrGRPCKeyNotFound
+		}
+		if r.IgnoreValue {
+			value = prev.Value
+		}
+		if r.IgnoreLease {
+			lease = prev.Lease
+		}
+	}
+
+	var (
+		rev     int64
+		written bool
+		op      = auditUpdate
+	)
+	if prev == nil {
+		op = auditCreate
+		rev, written, err = t.tx.Create(ctx, key, value, lease)
+	} else {
+		rev, written, err = t.tx.Update(ctx, key, value, prev.ModRevision, lease)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !written {
+		return nil, fmt.Errorf("failed to put %s in transaction", key)
+	}
+	t.rev = rev
+	t.writes = append(t.writes, auditWrite{op: op, key: key, revision: rev})
+
+	resp := &etcdserverpb.PutResponse{
+		Header: txnHeader(rev),
+	}
+	if r.PrevKv {
+		resp.PrevKv = toKV(prev)
+	}
+	return resp, nil
+}
+
+func (t *txnEval) deleteRange(ctx context.Context, r *etcdserverpb.DeleteRangeRequest) (*etcdserverpb.DeleteRangeResponse, error) {
+	kvs, err := t.rangeKVs(ctx, r.Key, r.RangeEnd, 0, RangeOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &etcdserverpb.DeleteRangeResponse{}
+	for _, kv := range kvs {
+		rev, deleted, err := t.tx.Delete(ctx, kv.Key, kv.ModRevision)
+		if err != nil {
+			return nil, err
+		}
+		if !deleted {
+			return nil, fmt.Errorf("failed to delete %s in transaction", kv.Key)
+		}
+		t.rev = rev
+		t.writes = append(t.writes, auditWrite{op: auditDelete, key: kv.Key, revision: rev})
+		resp.Deleted++
+		if r.PrevKv {
+			resp.PrevKvs = append(resp.PrevKvs, toKV(kv))
+		}
+	}
+
+	resp.Header, err = t.header(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
@@ -2,6 +2,7 @@ package server
 
 import (
 	"context"
+	"time"
 
 	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
 )
@@ -9,8 +10,27 @@ import (
 var (
 	ErrKeyExists = rpctypes.ErrGRPCDuplicateKey
 	ErrCompacted = rpctypes.ErrGRPCCompacted
+	ErrFutureRev = rpctypes.ErrGRPCFutureRev
+	ErrNoSpace   = rpctypes.ErrGRPCNoSpace
+
+	ErrLeaseNotFound = rpctypes.ErrGRPCLeaseNotFound
+	ErrLeaseExist    = rpctypes.ErrGRPCLeaseExist
 )
 
+// CompactionConfig are the compaction settings that can be changed while
+// the backend runs.
+type CompactionConfig struct {
+	// Interval is the interval between compactions. If not positive, the
+	// interval the backend was started with is kept.
+	Interval time.Duration
+	// RetentionDuration retains the revisions created in the given time
+	// window. If not positive, it is disabled.
+	RetentionDuration time.Duration
+	// RetentionRevisions retains the given number of most recent
+	// revisions. If not positive, it is disabled.
+	RetentionRevisions int64
+}
+
 type Backend interface {
 	Start(ctx context.Context) error
 	Wait()
@@ -19,10 +39,160 @@ type Backend interface {
 	Delete(ctx context.Context, key string, revision int64) (int64, bool, error)
 	List(ctx context.Context, prefix, startKey string, limit, revision int64) (int64, []*KeyValue, error)
 	Count(ctx context.Context, prefix, startKey string, revision int64) (int64, int64, error)
+	// ListRange lists the keys between start, inclusive, and end, exclusive.
+	ListRange(ctx context.Context, start, end string, limit, revision int64, opts RangeOptions) (int64, []*KeyValue, error)
+	// CountRange counts the keys between start, inclusive, and end, exclusive.
+	CountRange(ctx context.Context, start, end string, revision int64, opts RangeOptions) (int64, int64, error)
 	Update(ctx context.Context, key string, value []byte, revision, lease int64) (int64, bool, error)
 	Watch(ctx context.Context, key string, revision int64) <-chan []*Event
 	DbSize(ctx context.Context) (int64, error)
+	DbFileSize(ctx context.Context) (int64, error)
+	CurrentRevision(ctx context.Context) (int64, error)
+	// CompactRevision returns the revision up to which the revisions of
+	// the keys under prefix are compacted.
+	CompactRevision(ctx context.Context, prefix string) (int64, error)
+	// ReadBarrier returns once the reads following it see all the writes
+	// acknowledged before it was called, by any member of the cluster.
+	ReadBarrier(ctx context.Context) error
+	// CheckPollLoop returns an error if the poll loop feeding the watches
+	// has not queried the database for longer than it should.
+	CheckPollLoop() error
 	DoCompact(ctx context.Context) error
+	// CompactTo compacts the revisions up to revision, and returns the
+	// compact revision. It fails with ErrFutureRev if revision is after
+	// the current revision.
+	CompactTo(ctx context.Context, revision int64) (compactRevision int64, err error)
+	// SetCompaction changes the compaction settings while the backend
+	// runs.
+	SetCompaction(config CompactionConfig)
+	DoVacuum(ctx context.Context) (before, after int64, err error)
+	DoBackup(ctx context.Context, path string) (int64, error)
+	DoHash(ctx context.Context, revision int64) (hash uint32, compactRevision, hashRevision int64, err error)
+	// History returns the revisions of key retained in the database,
+	// deletions included, in revision order, and the compact revision.
+	History(ctx context.Context, key string) (compactRevision int64, events []*Event, err error)
+	// KeyStats returns the storage statistics of the keys, tombstones and
+	// internal keys included.
+	KeyStats(ctx context.Context) ([]KeyStats, error)
+	// Verify checks the integrity of the database and the invariants of
+	// the revisions it holds, and returns the violations found.
+	Verify(ctx context.Context) ([]Violation, error)
+	LeaseGrant(ctx context.Context, id, ttl int64) (int64, error)
+	LeaseRevoke(ctx context.Context, id int64) error
+	LeaseKeepAlive(ctx context.Context, id int64) (int64, error)
+	LeaseTimeToLive(ctx context.Context, id int64) (*Lease, error)
+	LeaseLeases(ctx context.Context) ([]int64, error)
+	Alarms(ctx context.Context) ([]int32, error)
+	ActivateAlarm(ctx context.Context, alarm int32) error
+	DeactivateAlarm(ctx context.Context, alarm int32) error
+	// Txn runs f in a single database transaction, which is committed
+	// if f succeeds. f may be run again if the transaction is retried.
+	Txn(ctx context.Context, f func(tx Transaction) error) error
+}
+
+// KeyStats are the storage statistics of a key.
+type KeyStats struct {
+	Key string
+	// Deleted reports whether the latest revision of the key is a
+	// tombstone.
+	Deleted bool
+	// Size is the size of the value of the latest revision.
+	Size int64
+	// Revisions is the number of rows of the key, and Bytes the size of
+	// their values and previous values.
+	Revisions int64
+	Bytes     int64
+}
+
+// Violation is a corruption of the datastore, found by Verify.
+type Violation struct {
+	// Revision and Key locate the revision breaking an invariant, and are
+	// empty for the problems found by the integrity check of the database.
+	Revision int64
+	Key      string
+	Problem  string
+}
+
+// Transaction reads and writes the current state of the datastore in a
+// single database transaction. Unlike etcd, each write of the transaction
+// gets its own revision.
+type Transaction interface {
+	CurrentRevision(ctx context.Context) (int64, error)
+	// Get returns the current value of key, or nil if it doesn't exist.
+	Get(ctx context.Context, key string) (*KeyValue, error)
+	// List returns the current values of the keys between start, inclusive,
+	// and end, exclusive.
+	List(ctx context.Context, start, end string, limit int64, opts RangeOptions) ([]*KeyValue, error)
+	Create(ctx context.Context, key string, value []byte, lease int64) (int64, bool, error)
+	Update(ctx context.Context, key string, value []byte, revision, lease int64) (int64, bool, error)
+	Delete(ctx context.Context, key string, revision int64) (int64, bool, error)
+	// Lease returns a persisted lease, or nil if it doesn't exist.
+	Lease(ctx context.Context, id int64) (*LeaseCheckpoint, error)
+}
+
+// SortTarget is the field the keys of a range are sorted by. The values
+// match the sort targets of etcd.
+type SortTarget int
+
+const (
+	SortByKey SortTarget = iota
+	SortByVersion
+	SortByCreateRevision
+	SortByModRevision
+	SortByValue
+)
+
+// RangeOptions change how the keys of a range are returned. The zero value
+// returns the keys with their values, sorted by name in ascending order.
+type RangeOptions struct {
+	SortTarget     SortTarget
+	SortDescending bool
+	// KeysOnly leaves out the values of the keys.
+	KeysOnly bool
+	// The revision filters leave out the keys whose mod or create revision
+	// is out of bounds. Zero leaves the bound unset.
+	MinModRevision    int64
+	MaxModRevision    int64
+	MinCreateRevision int64
+	MaxCreateRevision int64
+}
+
+// Cluster exposes the state of the cluster replicating the datastore.
+type Cluster interface {
+	// MemberID returns the ID of the local member.
+	MemberID() uint64
+	// ClusterID returns the ID of the cluster, or zero if it is not known
+	// yet.
+	ClusterID() uint64
+	// Leader returns the ID of the current leader.
+	Leader(ctx context.Context) (uint64, error)
+	// RaftStatus returns the current raft term of the local member and
+	// the index of the last raft entry it persisted.
+	RaftStatus() (term, index uint64, err error)
+	// Members lists the members of the cluster.
+	Members(ctx context.Context) ([]Member, error)
+	// AddMember adds a member with the peer URL, as a learner which does
+	// not vote if learner is set.
+	AddMember(ctx context.Context, peerURL string, learner bool) (Member, error)
+	// RemoveMember removes a member.
+	RemoveMember(ctx context.Context, id uint64) error
+	// UpdateMember changes the peer URLs of a member.
+	UpdateMember(ctx context.Context, id uint64, peerURLs []string) error
+	// PromoteMember promotes a learner to a voting member.
+	PromoteMember(ctx context.Context, id uint64) error
+}
+
+// Member is a member of the cluster.
+type Member struct {
+	ID   uint64
+	Name string
+	// PeerURLs are the URLs the other members reach the member at, and
+	// ClientURLs the ones of the datastore endpoint of the member, if
+	// known.
+	PeerURLs   []string
+	ClientURLs []string
+	// Learner is set if the member does not vote.
+	Learner bool
 }
 
 type KeyValue struct {
@@ -33,6 +203,26 @@ type KeyValue struct {
 	Lease          int64
 }
 
+type Lease struct {
+	ID int64
+	// TTL is the remaining time to live of the lease, in seconds.
+	TTL int64
+	// GrantedTTL is the time to live the lease was granted with, in seconds.
+	GrantedTTL int64
+	// Keys are the keys attached to the lease.
+	Keys []string
+}
+
+// LeaseCheckpoint is the persisted state of a lease.
+type LeaseCheckpoint struct {
+	ID int64
+	// TTL is the time to live the lease was granted with, in seconds.
+	TTL int64
+	// Expiry is the last persisted expiry of the lease. It is zero
+	// if the lease was never checkpointed.
+	Expiry time.Time
+}
+
 type Event struct {
 	Delete bool
 	Create bool
@@ -44,7 +44,13 @@ func (l *LimitedServer) update(ctx context.Context, rev int64, key string, value
 		attribute.Int64("revision", rev),
 	)
 
+	if err = l.checkQuota(ctx); err != nil {
+		return nil, err
+	}
+
+	op := auditUpdate
 	if rev == 0 {
+		op = auditCreate
 		rev, succeeded, err = l.backend.Create(ctx, key, value, lease)
 	} else {
 		rev, succeeded, err = l.backend.Update(ctx, key, value, rev, lease)
@@ -52,6 +58,9 @@ func (l *LimitedServer) update(ctx context.Context, rev int64, key string, value
 	if err != nil {
 		return nil, err
 	}
+	if succeeded {
+		l.audit(ctx, auditWrite{op: op, key: key, revision: rev})
+	}
 	span.SetAttributes(attribute.Bool("updated", succeeded), attribute.Int64("revision", rev))
 
 	resp := &etcdserverpb.TxnResponse{
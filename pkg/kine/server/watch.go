@@ -4,21 +4,42 @@ import (
 	"context"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/sirupsen/logrus"
 	"go.etcd.io/etcd/api/v3/etcdserverpb"
 	"go.etcd.io/etcd/api/v3/mvccpb"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	clientv3 "go.etcd.io/etcd/client/v3"
 )
 
 var (
 	watchID int64
 )
 
+// maxFragmentBytes is the size after which the responses of the watches
+// that allow it are split in fragments. As in etcd, it is the maximum
+// size of a request, with some headroom for the overhead of gRPC.
+const maxFragmentBytes = 1.5*1024*1024 + 512*1024
+
 func (s *KVServerBridge) Watch(ws etcdserverpb.Watch_WatchServer) error {
+	streams := s.watchStreams.Add(1)
+	defer func() {
+		metricsWatchStreams.Set(float64(s.watchStreams.Add(-1)))
+	}()
+	if max := s.limits.MaxWatchStreams; max > 0 && streams > int64(max) {
+		metricsShedRequests.WithLabelValues("watch_streams").Inc()
+		return rpctypes.ErrGRPCRequestTooManyRequests
+	}
+	metricsWatchStreams.Set(float64(streams))
+
 	w := watcher{
-		server:  ws,
-		backend: s.limited.backend,
-		watches: map[int64]func(){},
+		server:                 ws,
+		backend:                s.limited.backend,
+		watches:                map[int64]func(){},
+		progress:               map[int64]chan chan bool{},
+		progressNotifyInterval: s.watchProgressNotifyInterval,
+		registry:               &s.watches,
 	}
 	defer w.Close()
 
@@ -29,10 +50,18 @@ func (s *KVServerBridge) Watch(ws etcdserverpb.Watch_WatchServer) error {
 		}
 
 		if msg.GetCreateRequest() != nil {
+			if !s.watchCreates.Allow() {
+				if err := w.send(shedWatchCreate()); err != nil {
+					return err
+				}
+				continue
+			}
 			w.Start(ws.Context(), msg.GetCreateRequest())
 		} else if msg.GetCancelRequest() != nil {
-			logrus.Debugf("WATCH CANCEL REQ id=%d", msg.GetCancelRequest().GetWatchId())
+			logger.Debugf("WATCH CANCEL REQ id=%d", msg.GetCancelRequest().GetWatchId())
 			w.Cancel(msg.GetCancelRequest().WatchId, nil)
+		} else if msg.GetProgressRequest() != nil {
+			w.Progress(ws.Context())
 		}
 	}
 }
@@ -44,6 +73,24 @@ type watcher struct {
 	backend Backend
 	server  etcdserverpb.Watch_WatchServer
 	watches map[int64]func()
+	// progress asks the watches whether they are synced, that is
+	// whether all the events they received were sent.
+	progress map[int64]chan chan bool
+	// progressNotifyInterval is the interval between the progress
+	// notifications of the watches that requested them.
+	progressNotifyInterval time.Duration
+	// registry tracks the watches of the stream.
+	registry *watchRegistry
+
+	// sendLock serializes the responses, as streams don't support
+	// concurrent sends.
+	sendLock sync.Mutex
+}
+
+func (w *watcher) send(resp *etcdserverpb.WatchResponse) error {
+	w.sendLock.Lock()
+	defer w.sendLock.Unlock()
+	return w.server.Send(resp)
 }
 
 func (w *watcher) Start(ctx context.Context, r *etcdserverpb.WatchCreateRequest) {
@@ -54,15 +101,24 @@ func (w *watcher) Start(ctx context.Context, r *etcdserverpb.WatchCreateRequest)
 
 	id := atomic.AddInt64(&watchID, 1)
 	w.watches[id] = cancel
+	progress := make(chan chan bool)
+	w.progress[id] = progress
 	w.wg.Add(1)
 
 	key := string(r.Key)
+	w.registry.add(WatchInfo{
+		ID:            id,
+		Key:           key,
+		StartRevision: r.StartRevision,
+		Peer:          peerAddress(ctx),
+		Started:       time.Now(),
+	})
 
-	logrus.Debugf("WATCH START id=%d, count=%d, key=%s, revision=%d", id, len(w.watches), key, r.StartRevision)
+	logger.Debugf("WATCH START id=%d, count=%d, key=%s, revision=%d", id, len(w.watches), key, r.StartRevision)
 
 	go func() {
 		defer w.wg.Done()
-		if err := w.server.Send(&etcdserverpb.WatchResponse{
+		if err := w.send(&etcdserverpb.WatchResponse{
 			Header:  &etcdserverpb.ResponseHeader{},
 			Created: true,
 			WatchId: id,
@@ -71,31 +127,157 @@ func (w *watcher) Start(ctx context.Context, r *etcdserverpb.WatchCreateRequest)
 			return
 		}
 
-		for events := range w.backend.Watch(ctx, key, r.StartRevision) {
+		// The events before the compact revision might be missing,
+		// as with the backend catching up.
+		if r.StartRevision > 0 {
+			compactRevision, err := w.backend.CompactRevision(ctx, key)
+			if err != nil {
+				w.Cancel(id, err)
+				return
+			}
+			if r.StartRevision <= compactRevision {
+				w.cancelCompacted(id, compactRevision)
+				return
+			}
+		}
+
+		var tick <-chan time.Time
+		if r.ProgressNotify && w.progressNotifyInterval > 0 {
+			ticker := time.NewTicker(w.progressNotifyInterval)
+			defer ticker.Stop()
+			tick = ticker.C
+		}
+
+		watchCh := w.backend.Watch(ctx, key, r.StartRevision)
+		for {
+			var events []*Event
+			select {
+			case e, ok := <-watchCh:
+				if !ok {
+					if ctx.Err() == nil {
+						// The backend closed the watch, as it fell
+						// behind the events or failed to catch up.
+						watchCanceledCnt.Add(ctx, 1)
+					}
+					w.Cancel(id, nil)
+					logger.Debugf("WATCH CLOSE id=%d, key=%s", id, key)
+					return
+				}
+				events = e
+			case <-tick:
+				if len(watchCh) > 0 {
+					// Not synced, events are pending.
+					continue
+				}
+				if err := w.sendProgress(ctx, id); err != nil {
+					w.Cancel(id, err)
+				}
+				continue
+			case synced := <-progress:
+				synced <- len(watchCh) == 0
+				continue
+			}
+
 			if len(events) == 0 {
 				continue
 			}
 
-			if logrus.IsLevelEnabled(logrus.DebugLevel) {
+			if logger.Logger.IsLevelEnabled(logrus.DebugLevel) {
 				for _, event := range events {
-					logrus.Debugf("WATCH READ id=%d, key=%s, revision=%d", id, event.KV.Key, event.KV.ModRevision)
+					logger.Debugf("WATCH READ id=%d, key=%s, revision=%d", id, event.KV.Key, event.KV.ModRevision)
 				}
 			}
 
-			if err := w.server.Send(&etcdserverpb.WatchResponse{
+			resp := &etcdserverpb.WatchResponse{
 				Header:  txnHeader(events[len(events)-1].KV.ModRevision),
 				WatchId: id,
 				Events:  toEvents(events...),
-			}); err != nil {
+			}
+			send := w.send
+			if r.Fragment {
+				send = w.sendFragments
+			}
+			if err := send(resp); err != nil {
 				w.Cancel(id, err)
 				continue
 			}
 		}
-		w.Cancel(id, nil)
-		logrus.Debugf("WATCH CLOSE id=%d, key=%s", id, key)
 	}()
 }
 
+// sendFragments sends a response in fragments of at most maxFragmentBytes,
+// unless a single event is larger. All fragments but the last are marked
+// as such, so that clients merge them back.
+func (w *watcher) sendFragments(resp *etcdserverpb.WatchResponse) error {
+	if resp.Size() < maxFragmentBytes || len(resp.Events) < 2 {
+		return w.send(resp)
+	}
+
+	events := resp.Events
+	for len(events) > 0 {
+		fragment := *resp
+		fragment.Events = nil
+		size := fragment.Size()
+		for len(events) > 0 {
+			// Account for the tag and length of the event field.
+			eventSize := events[0].Size() + 8
+			if len(fragment.Events) > 0 && size+eventSize >= maxFragmentBytes {
+				break
+			}
+			fragment.Events = append(fragment.Events, events[0])
+			size += eventSize
+			events = events[1:]
+		}
+		fragment.Fragment = len(events) > 0
+		if err := w.send(&fragment); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Progress sends a progress notification with the current revision for
+// all the watches of the stream, if they are all synced. As in etcd, no
+// notification is sent otherwise, as the events that are pending would
+// be older than the revision of the notification.
+func (w *watcher) Progress(ctx context.Context) {
+	w.Lock()
+	defer w.Unlock()
+
+	for id, progress := range w.progress {
+		synced := make(chan bool, 1)
+		select {
+		case progress <- synced:
+		default:
+			// The watch is busy sending events.
+			logger.Tracef("WATCH PROGRESS NOT SYNCED id=%d", id)
+			return
+		}
+		if !<-synced {
+			logger.Tracef("WATCH PROGRESS NOT SYNCED id=%d", id)
+			return
+		}
+	}
+
+	if err := w.sendProgress(ctx, clientv3.InvalidWatchID); err != nil {
+		logger.WithError(err).Error("WATCH Failed to send progress notification")
+	}
+}
+
+// sendProgress sends a progress notification for a watch, with no events
+// and the current revision.
+func (w *watcher) sendProgress(ctx context.Context, id int64) error {
+	rev, err := w.backend.CurrentRevision(ctx)
+	if err != nil {
+		return err
+	}
+	logger.Tracef("WATCH PROGRESS id=%d, revision=%d", id, rev)
+	return w.send(&etcdserverpb.WatchResponse{
+		Header:  txnHeader(rev),
+		WatchId: id,
+	})
+}
+
 func toEvents(events ...*Event) []*mvccpb.Event {
 	ret := make([]*mvccpb.Event, 0, len(events))
 	for _, e := range events {
@@ -120,33 +302,57 @@ func toEvent(event *Event) *mvccpb.Event {
 }
 
 func (w *watcher) Cancel(watchID int64, err error) {
-	w.Lock()
-	if cancel, ok := w.watches[watchID]; ok {
-		cancel()
-		delete(w.watches, watchID)
-	}
-	w.Unlock()
+	w.remove(watchID)
 
 	reason := ""
 	if err != nil {
 		reason = err.Error()
 	}
-	logrus.Debugf("WATCH CANCEL id=%d reason=%s", watchID, reason)
-	serr := w.server.Send(&etcdserverpb.WatchResponse{
+	logger.Debugf("WATCH CANCEL id=%d reason=%s", watchID, reason)
+	serr := w.send(&etcdserverpb.WatchResponse{
 		Header:       &etcdserverpb.ResponseHeader{},
 		Canceled:     true,
 		CancelReason: "watch closed",
 		WatchId:      watchID,
 	})
 	if serr != nil && err != nil {
-		logrus.Errorf("WATCH Failed to send cancel response for watchID %d: %v", watchID, serr)
+		logger.Errorf("WATCH Failed to send cancel response for watchID %d: %v", watchID, serr)
+	}
+}
+
+// cancelCompacted cancels a watch starting before the compact revision.
+// As in etcd, the response reports the compact revision, for the clients
+// to fail with ErrCompacted.
+func (w *watcher) cancelCompacted(watchID, compactRevision int64) {
+	w.remove(watchID)
+
+	logger.Debugf("WATCH CANCEL id=%d compactRevision=%d", watchID, compactRevision)
+	if err := w.send(&etcdserverpb.WatchResponse{
+		Header:          &etcdserverpb.ResponseHeader{},
+		Canceled:        true,
+		CompactRevision: compactRevision,
+		WatchId:         watchID,
+	}); err != nil {
+		logger.Errorf("WATCH Failed to send cancel response for watchID %d: %v", watchID, err)
+	}
+}
+
+func (w *watcher) remove(watchID int64) {
+	w.Lock()
+	defer w.Unlock()
+	if cancel, ok := w.watches[watchID]; ok {
+		cancel()
+		delete(w.watches, watchID)
+		delete(w.progress, watchID)
+		w.registry.remove(watchID)
 	}
 }
 
 func (w *watcher) Close() {
 	w.Lock()
-	for _, v := range w.watches {
+	for id, v := range w.watches {
 		v()
+		w.registry.remove(id)
 	}
 	w.Unlock()
 	w.wg.Wait()
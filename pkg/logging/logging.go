@@ -0,0 +1,158 @@
+package logging
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// The components tagging the log entries, in the component field.
+const (
+	Server   = "server"
+	Backend  = "backend"
+	Watch    = "watch"
+	Driver   = "driver"
+	Migrator = "migrator"
+	Dqlite   = "dqlite"
+)
+
+var components = []string{Server, Backend, Watch, Driver, Migrator, Dqlite}
+
+// defaultLevels are the levels of the components that don't follow the
+// global level unless configured, as they are too verbose.
+var defaultLevels = map[string]logrus.Level{
+	Dqlite: logrus.WarnLevel,
+}
+
+var (
+	mu      sync.Mutex
+	loggers = map[string]*logrus.Logger{}
+	// levels are the levels configured for each component.
+	levels = map[string]logrus.Level{}
+)
+
+// Component returns the logger of a component. The component loggers have
+// their own level, but share the output, format and hooks of the standard
+// logger.
+func Component(name string) *logrus.Entry {
+	mu.Lock()
+	defer mu.Unlock()
+
+	logger, ok := loggers[name]
+	if !ok {
+		logger = &logrus.Logger{
+			Out:       stdOut{},
+			Formatter: stdFormatter{},
+			Hooks:     logrus.StandardLogger().Hooks,
+			Level:     levelOf(name),
+			ExitFunc:  os.Exit,
+		}
+		loggers[name] = logger
+	}
+	return logger.WithField("component", name)
+}
+
+// Configure sets the format (text or json) and the levels of the logs.
+// The levels are a comma separated list of a global level and of levels
+// for components, such as "info,driver=trace".
+func Configure(format, level string) error {
+	switch format {
+	case "text":
+		logrus.SetFormatter(&logrus.TextFormatter{})
+	case "json":
+		logrus.SetFormatter(&logrus.JSONFormatter{})
+	default:
+		return fmt.Errorf("unsupported log format %q, must be text or json", format)
+	}
+
+	global, componentLevels, err := parseLevels(level)
+	if err != nil {
+		return err
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	logrus.SetLevel(global)
+	levels = componentLevels
+	updateLevels()
+	return nil
+}
+
+// SetLevel sets the global level of the logs. The levels configured for
+// components are kept.
+func SetLevel(level logrus.Level) {
+	mu.Lock()
+	defer mu.Unlock()
+	logrus.SetLevel(level)
+	updateLevels()
+}
+
+func parseLevels(spec string) (logrus.Level, map[string]logrus.Level, error) {
+	global := logrus.InfoLevel
+	componentLevels := map[string]logrus.Level{}
+	for _, part := range strings.Split(spec, ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			l, err := logrus.ParseLevel(name)
+			if err != nil {
+				return 0, nil, err
+			}
+			global = l
+			continue
+		}
+		if !isComponent(name) {
+			return 0, nil, fmt.Errorf("unknown log component %q, must be one of %s", name, strings.Join(components, ", "))
+		}
+		l, err := logrus.ParseLevel(value)
+		if err != nil {
+			return 0, nil, err
+		}
+		componentLevels[name] = l
+	}
+	return global, componentLevels, nil
+}
+
+func isComponent(name string) bool {
+	for _, component := range components {
+		if name == component {
+			return true
+		}
+	}
+	return false
+}
+
+// levelOf returns the level of a component, which must be called with mu
+// held.
+func levelOf(name string) logrus.Level {
+	if l, ok := levels[name]; ok {
+		return l
+	}
+	if l, ok := defaultLevels[name]; ok {
+		return l
+	}
+	return logrus.GetLevel()
+}
+
+func updateLevels() {
+	for name, logger := range loggers {
+		logger.SetLevel(levelOf(name))
+	}
+}
+
+// stdOut writes to the output of the standard logger.
+type stdOut struct{}
+
+func (stdOut) Write(p []byte) (int, error) {
+	return logrus.StandardLogger().Out.Write(p)
+}
+
+// stdFormatter formats the entries with the formatter of the standard
+// logger.
+type stdFormatter struct{}
+
+func (stdFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+	return logrus.StandardLogger().Formatter.Format(entry)
+}
@@ -0,0 +1,67 @@
+package logging
+
+import (
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestConfigure(t *testing.T) {
+	defer func() {
+		logrus.SetFormatter(&logrus.TextFormatter{})
+		logrus.SetLevel(logrus.InfoLevel)
+	}()
+
+	if err := Configure("json", "debug"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := logrus.StandardLogger().Formatter.(*logrus.JSONFormatter); !ok {
+		t.Errorf("expected a JSON formatter, got %T", logrus.StandardLogger().Formatter)
+	}
+	if level := logrus.GetLevel(); level != logrus.DebugLevel {
+		t.Errorf("expected level debug, got %v", level)
+	}
+
+	if err := Configure("xml", "info"); err == nil {
+		t.Error("expected an error for an unsupported format")
+	}
+	if err := Configure("text", "verbose"); err == nil {
+		t.Error("expected an error for an unsupported level")
+	}
+}
+
+func TestComponentLevels(t *testing.T) {
+	defer func() {
+		if err := Configure("text", "info"); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	driver, dqlite := Component(Driver), Component(Dqlite)
+	if err := Configure("text", "warn,driver=trace"); err != nil {
+		t.Fatal(err)
+	}
+	if level := driver.Logger.GetLevel(); level != logrus.TraceLevel {
+		t.Errorf("expected driver level trace, got %v", level)
+	}
+	if level := Component(Server).Logger.GetLevel(); level != logrus.WarnLevel {
+		t.Errorf("expected server level warn, got %v", level)
+	}
+
+	// The global level doesn't change the configured components, nor
+	// dqlite, which is too verbose.
+	SetLevel(logrus.DebugLevel)
+	if level := driver.Logger.GetLevel(); level != logrus.TraceLevel {
+		t.Errorf("expected driver level trace, got %v", level)
+	}
+	if level := Component(Watch).Logger.GetLevel(); level != logrus.DebugLevel {
+		t.Errorf("expected watch level debug, got %v", level)
+	}
+	if level := dqlite.Logger.GetLevel(); level != logrus.WarnLevel {
+		t.Errorf("expected dqlite level warn, got %v", level)
+	}
+
+	if err := Configure("text", "info,raft=debug"); err == nil {
+		t.Error("expected an error for an unknown component")
+	}
+}
@@ -8,10 +8,13 @@ import (
 
 	"github.com/canonical/k8s-dqlite/pkg/kine/client"
 	kine_endpoint "github.com/canonical/k8s-dqlite/pkg/kine/endpoint"
+	"github.com/canonical/k8s-dqlite/pkg/logging"
 	"github.com/sirupsen/logrus"
 	clientv3 "go.etcd.io/etcd/client/v3"
 )
 
+var logger = logging.Component(logging.Migrator)
+
 func keyFileName(dir string, index int) string {
 	return filepath.Join(dir, fmt.Sprintf("%d.key", index))
 }
@@ -36,7 +39,7 @@ func BackupEtcd(ctx context.Context, endpoint, dir string) error {
 		return fmt.Errorf("failed to backup directory: %w", err)
 	}
 	for idx, kv := range resp.Kvs {
-		logrus.WithFields(logrus.Fields{"index": idx, "key": string(kv.Key), "len": len(kv.Value)}).Print("Writing key")
+		logger.WithFields(logrus.Fields{"index": idx, "key": string(kv.Key), "len": len(kv.Value)}).Print("Writing key")
 		if err := os.WriteFile(keyFileName(dir, idx), kv.Key, 0640); err != nil {
 			return fmt.Errorf("failed to write key file %d: %w", idx, err)
 		}
@@ -65,7 +68,7 @@ func RestoreToDqlite(ctx context.Context, endpoint, dir string) error {
 			if !os.IsNotExist(err) {
 				return fmt.Errorf("failed to read key file: %w", err)
 			}
-			logrus.WithField("entries", idx).Print("Completed database restore")
+			logger.WithField("entries", idx).Print("Completed database restore")
 			return nil
 		}
 		key := string(b)
@@ -75,7 +78,7 @@ func RestoreToDqlite(ctx context.Context, endpoint, dir string) error {
 			return fmt.Errorf("failed to read value file: %w", err)
 		}
 
-		log := logrus.WithFields(logrus.Fields{"index": idx, "key": key})
+		log := logger.WithFields(logrus.Fields{"index": idx, "key": key})
 		log.Debug("Restore key")
 		if err := putKey(ctx, client, key, value); err != nil {
 			log.Error("Failed to restore key")
@@ -101,7 +104,7 @@ func BackupDqlite(ctx context.Context, endpoint, dir string) error {
 		return fmt.Errorf("failed to backup directory: %w", err)
 	}
 	for idx, kv := range resp {
-		logrus.WithFields(logrus.Fields{"index": idx, "key": string(kv.Key), "len": len(kv.Data)}).Print("Writing key")
+		logger.WithFields(logrus.Fields{"index": idx, "key": string(kv.Key), "len": len(kv.Data)}).Print("Writing key")
 		if err := os.WriteFile(keyFileName(dir, idx), kv.Key, 0640); err != nil {
 			return fmt.Errorf("failed to write key file %d: %w", idx, err)
 		}
@@ -127,7 +130,7 @@ func RestoreToEtcd(ctx context.Context, endpoint, dir string) error {
 			if !os.IsNotExist(err) {
 				return fmt.Errorf("failed to read key file: %w", err)
 			}
-			logrus.WithField("entries", idx).Print("Completed database restore")
+			logger.WithField("entries", idx).Print("Completed database restore")
 			return nil
 		}
 		key := string(b)
@@ -137,7 +140,7 @@ func RestoreToEtcd(ctx context.Context, endpoint, dir string) error {
 			return fmt.Errorf("failed to read value file: %w", err)
 		}
 
-		log := logrus.WithFields(logrus.Fields{"index": idx, "key": key})
+		log := logger.WithFields(logrus.Fields{"index": idx, "key": key})
 		log.Debug("Restore key")
 		if _, err := client.Put(ctx, key, string(value)); err != nil {
 			log.Error("Failed to restore key")
@@ -0,0 +1,84 @@
+package migrator
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.etcd.io/etcd/client/pkg/v3/transport"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// etcdDialTimeout bounds the connection to etcd.
+const etcdDialTimeout = 10 * time.Second
+
+// EtcdConfig is the configuration of the connection to an etcd cluster.
+type EtcdConfig struct {
+	Endpoint string
+	// CAFile, CertFile and KeyFile are the CA certificate checking the etcd
+	// server and the certificate and key of the client, if TLS is used.
+	CAFile   string
+	CertFile string
+	KeyFile  string
+}
+
+// Client returns a client of the etcd endpoint.
+func (c EtcdConfig) Client() (*clientv3.Client, error) {
+	config := clientv3.Config{
+		Endpoints:   []string{c.Endpoint},
+		DialTimeout: etcdDialTimeout,
+	}
+	if c.CAFile != "" || c.CertFile != "" || c.KeyFile != "" {
+		tlsInfo := transport.TLSInfo{
+			TrustedCAFile: c.CAFile,
+			CertFile:      c.CertFile,
+			KeyFile:       c.KeyFile,
+		}
+		tlsConfig, err := tlsInfo.ClientConfig()
+		if err != nil {
+			return nil, fmt.Errorf("failed to load etcd certificates: %w", err)
+		}
+		tlsConfig.MinVersion = tls.VersionTLS12
+		config.TLS = tlsConfig
+	}
+	return clientv3.New(config)
+}
+
+// SnapshotEtcd writes a snapshot of the etcd cluster to path, as taken by
+// the etcd Snapshot API.
+func SnapshotEtcd(ctx context.Context, config EtcdConfig, path string) error {
+	client, err := config.Client()
+	if err != nil {
+		return fmt.Errorf("failed to create etcd client: %w", err)
+	}
+	defer client.Close()
+
+	status, err := client.Status(ctx, config.Endpoint)
+	if err != nil {
+		return fmt.Errorf("failed to get etcd status: %w", err)
+	}
+	logger.WithFields(logrus.Fields{"endpoint": config.Endpoint, "revision": status.Header.Revision, "size": status.DbSize}).Print("Taking etcd snapshot")
+
+	r, err := client.Snapshot(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to take etcd snapshot: %w", err)
+	}
+	defer r.Close()
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := io.Copy(f, r); err != nil {
+		return fmt.Errorf("failed to receive etcd snapshot: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,109 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// DatabaseSize is the size of the database of the datastore.
+type DatabaseSize struct {
+	// Size is the size of the database, and FileSize the size of its file,
+	// unused pages included.
+	Size     int64 `json:"size"`
+	FileSize int64 `json:"file_size"`
+}
+
+// adminNode is the node the admin endpoints observe.
+type adminNode interface {
+	// Status reports the status of the node and its cluster.
+	Status(ctx context.Context) (Status, error)
+	// DatabaseSize reports the size of the database.
+	DatabaseSize(ctx context.Context) (DatabaseSize, error)
+	// Watches lists the watches served by the node.
+	Watches() []Watch
+}
+
+// ReadAdminToken reads the token of the admin endpoints from path, ignoring
+// the surrounding white space.
+func ReadAdminToken(path string) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read admin token: %w", err)
+	}
+	token := strings.TrimSpace(string(b))
+	if token == "" {
+		return "", fmt.Errorf("admin token file %s is empty", path)
+	}
+	return token, nil
+}
+
+// HandleAdmin registers the read-only admin endpoints on mux, such as the
+// one of the metrics endpoint, for the clients sending token as a bearer
+// token in their Authorization header:
+//   - GET /status reports the status of the node and its cluster.
+//   - GET /members lists the members of the cluster.
+//   - GET /db/size reports the size of the database.
+//   - GET /watchers lists the watches served by the node.
+func (s *Server) HandleAdmin(mux *http.ServeMux, token string) {
+	handleAdmin(mux, s, token)
+}
+
+// DatabaseSize reports the size of the database.
+func (s *Server) DatabaseSize(ctx context.Context) (DatabaseSize, error) {
+	size, err := s.backend.DbSize(ctx)
+	if err != nil {
+		return DatabaseSize{}, err
+	}
+	fileSize, err := s.backend.DbFileSize(ctx)
+	if err != nil {
+		return DatabaseSize{}, err
+	}
+	return DatabaseSize{Size: size, FileSize: fileSize}, nil
+}
+
+func handleAdmin(mux *http.ServeMux, node adminNode, token string) {
+	expected := []byte("Bearer " + token)
+	// handle registers handler for the requests with the token.
+	handle := func(pattern string, handler http.HandlerFunc) {
+		mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+			if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				writeControlError(w, http.StatusUnauthorized, fmt.Errorf("invalid or missing admin token"))
+				return
+			}
+			handler(w, r)
+		})
+	}
+
+	handle("GET /status", func(w http.ResponseWriter, r *http.Request) {
+		status, err := node.Status(r.Context())
+		if err != nil {
+			writeControlError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeControlResult(w, status)
+	})
+	handle("GET /members", func(w http.ResponseWriter, r *http.Request) {
+		status, err := node.Status(r.Context())
+		if err != nil {
+			writeControlError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeControlResult(w, status.Members)
+	})
+	handle("GET /db/size", func(w http.ResponseWriter, r *http.Request) {
+		size, err := node.DatabaseSize(r.Context())
+		if err != nil {
+			writeControlError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeControlResult(w, size)
+	})
+	handle("GET /watchers", func(w http.ResponseWriter, r *http.Request) {
+		writeControlResult(w, node.Watches())
+	})
+}
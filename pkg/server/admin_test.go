@@ -0,0 +1,84 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type fakeAdminNode struct{}
+
+func (fakeAdminNode) Status(context.Context) (Status, error) {
+	return Status{ID: 1, Members: []Member{{ID: 1, Address: "10.0.0.1:9000", Role: "voter"}}}, nil
+}
+
+func (fakeAdminNode) DatabaseSize(context.Context) (DatabaseSize, error) {
+	return DatabaseSize{Size: 1024, FileSize: 4096}, nil
+}
+
+func (fakeAdminNode) Watches() []Watch {
+	return []Watch{{ID: 7, Key: "/registry/pods/"}}
+}
+
+func TestAdminEndpoints(t *testing.T) {
+	mux := http.NewServeMux()
+	handleAdmin(mux, fakeAdminNode{}, "secret")
+
+	for _, tc := range []struct {
+		path          string
+		authorization string
+		status        int
+	}{
+		{path: "/status", authorization: "Bearer secret", status: http.StatusOK},
+		{path: "/members", authorization: "Bearer secret", status: http.StatusOK},
+		{path: "/db/size", authorization: "Bearer secret", status: http.StatusOK},
+		{path: "/watchers", authorization: "Bearer secret", status: http.StatusOK},
+		{path: "/status", status: http.StatusUnauthorized},
+		{path: "/status", authorization: "Bearer wrong", status: http.StatusUnauthorized},
+		{path: "/status", authorization: "secret", status: http.StatusUnauthorized},
+	} {
+		req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+		if tc.authorization != "" {
+			req.Header.Set("Authorization", tc.authorization)
+		}
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		if rec.Code != tc.status {
+			t.Fatalf("expected %s with %q to return %d, got %d", tc.path, tc.authorization, tc.status, rec.Code)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/db/size", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	var size DatabaseSize
+	if err := json.NewDecoder(rec.Body).Decode(&size); err != nil {
+		t.Fatal(err)
+	}
+	if size != (DatabaseSize{Size: 1024, FileSize: 4096}) {
+		t.Fatalf("unexpected database size %+v", size)
+	}
+}
+
+func TestReadAdminToken(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "admin-token")
+	if err := os.WriteFile(path, []byte("secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if token, err := ReadAdminToken(path); err != nil || token != "secret" {
+		t.Fatalf("expected token secret, got %q (%v)", token, err)
+	}
+
+	if err := os.WriteFile(path, []byte(" \n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadAdminToken(path); err == nil {
+		t.Fatal("expected an empty token to be refused")
+	}
+}
@@ -0,0 +1,219 @@
+package server
+
+import (
+	"container/heap"
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	kine_server "github.com/canonical/k8s-dqlite/pkg/kine/server"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// internalPrefix groups the internal keys of kine, which don't start
+	// with a slash.
+	internalPrefix = "internal"
+	// defaultLargestKeys is the number of largest keys reported if none
+	// is requested.
+	defaultLargestKeys = 10
+)
+
+var (
+	metricsPrefixKeys = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "k8s_dqlite_prefix_keys",
+		Help: "Number of keys of the top-level prefix, tombstones left out",
+	}, []string{"prefix"})
+	metricsPrefixRows = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "k8s_dqlite_prefix_rows",
+		Help: "Number of revisions of the keys of the top-level prefix retained in the datastore",
+	}, []string{"prefix"})
+	metricsPrefixBytes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "k8s_dqlite_prefix_bytes",
+		Help: "Size of the values of the revisions of the keys of the top-level prefix retained in the datastore",
+	}, []string{"prefix"})
+)
+
+func init() {
+	prometheus.MustRegister(metricsPrefixKeys, metricsPrefixRows, metricsPrefixBytes)
+}
+
+// PrefixStats are the storage statistics of the keys of a top-level prefix.
+type PrefixStats struct {
+	Prefix string `json:"prefix"`
+	// Keys is the number of keys, tombstones left out.
+	Keys int64 `json:"keys"`
+	// Rows is the number of revisions of the keys, and Bytes the size of
+	// their values and previous values.
+	Rows  int64 `json:"rows"`
+	Bytes int64 `json:"bytes"`
+}
+
+// KeySize is the size of the value of a key.
+type KeySize struct {
+	Key       string `json:"key"`
+	Size      int64  `json:"size"`
+	Revisions int64  `json:"revisions"`
+}
+
+// Analysis reports what uses the storage of the datastore.
+type Analysis struct {
+	// Prefixes are the statistics of the top-level prefixes, the largest
+	// first.
+	Prefixes []PrefixStats `json:"prefixes"`
+	// Largest are the keys with the largest values, the largest first.
+	Largest []KeySize `json:"largest"`
+}
+
+// Analyze reports the statistics of the top-level prefixes of the keys,
+// and the top keys with the largest values, or defaultLargestKeys if top is
+// not positive.
+func (s *Server) Analyze(ctx context.Context, top int) (Analysis, error) {
+	stats, err := s.backend.KeyStats(ctx)
+	if err != nil {
+		return Analysis{}, fmt.Errorf("failed to read key statistics: %w", err)
+	}
+	if top <= 0 {
+		top = defaultLargestKeys
+	}
+	return analyze(stats, top), nil
+}
+
+func analyze(stats []kine_server.KeyStats, top int) Analysis {
+	var (
+		prefixes = map[string]*PrefixStats{}
+		largest  = &keySizeHeap{}
+	)
+	for _, stat := range stats {
+		prefix := keyPrefix(stat.Key)
+		p := prefixes[prefix]
+		if p == nil {
+			p = &PrefixStats{Prefix: prefix}
+			prefixes[prefix] = p
+		}
+		p.Rows += stat.Revisions
+		p.Bytes += stat.Bytes
+		if stat.Deleted || prefix == internalPrefix {
+			continue
+		}
+		p.Keys++
+
+		heap.Push(largest, KeySize{Key: stat.Key, Size: stat.Size, Revisions: stat.Revisions})
+		if largest.Len() > top {
+			heap.Pop(largest)
+		}
+	}
+
+	analysis := Analysis{
+		Prefixes: make([]PrefixStats, 0, len(prefixes)),
+		Largest:  make([]KeySize, largest.Len()),
+	}
+	for _, p := range prefixes {
+		analysis.Prefixes = append(analysis.Prefixes, *p)
+	}
+	sort.Slice(analysis.Prefixes, func(i, j int) bool {
+		a, b := analysis.Prefixes[i], analysis.Prefixes[j]
+		if a.Bytes != b.Bytes {
+			return a.Bytes > b.Bytes
+		}
+		return a.Prefix < b.Prefix
+	})
+	for i := len(analysis.Largest) - 1; i >= 0; i-- {
+		analysis.Largest[i] = heap.Pop(largest).(KeySize)
+	}
+	return analysis
+}
+
+// keyPrefix returns the top-level prefix of key: the resource of the
+// Kubernetes keys, as /registry/pods/, or else the first segment of the
+// key, as /k8s-dqlite/.
+func keyPrefix(key string) string {
+	if !strings.HasPrefix(key, "/") {
+		return internalPrefix
+	}
+	segments := 1
+	if strings.HasPrefix(key, "/registry/") {
+		segments = 2
+	}
+	end := 0
+	for i := 0; i < segments; i++ {
+		next := strings.IndexByte(key[end+1:], '/')
+		if next < 0 {
+			// The key is not nested under a prefix of its own.
+			return key[:end+1]
+		}
+		end += next + 1
+	}
+	return key[:end+1]
+}
+
+// keySizeHeap is a min-heap of key sizes, keeping the largest keys.
+type keySizeHeap []KeySize
+
+func (h keySizeHeap) Len() int { return len(h) }
+func (h keySizeHeap) Less(i, j int) bool {
+	if h[i].Size != h[j].Size {
+		return h[i].Size < h[j].Size
+	}
+	return h[i].Key > h[j].Key
+}
+func (h keySizeHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *keySizeHeap) Push(x any)   { *h = append(*h, x.(KeySize)) }
+func (h *keySizeHeap) Pop() any {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// updatePrefixMetrics updates the metrics of the top-level prefixes every
+// interval, until ctx is done. They are reported by the dqlite leader only,
+// as the nodes share the same data.
+func (s *Server) updatePrefixMetrics(ctx context.Context) {
+	if s.analyzeInterval == 0 {
+		return
+	}
+	logger.WithField("interval", s.analyzeInterval).Print("Enable prefix metrics")
+	ticker := time.NewTicker(s.analyzeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		id, err := s.leaderID(ctx)
+		if err != nil {
+			logger.WithError(err).Warning("Failed to get dqlite leader, skipping prefix metrics")
+			continue
+		}
+		if id != s.app.ID() {
+			resetPrefixMetrics()
+			continue
+		}
+
+		start := time.Now()
+		analysis, err := s.Analyze(ctx, 0)
+		if err != nil {
+			logger.WithError(err).Warning("Failed to update prefix metrics")
+			continue
+		}
+		resetPrefixMetrics()
+		for _, p := range analysis.Prefixes {
+			metricsPrefixKeys.WithLabelValues(p.Prefix).Set(float64(p.Keys))
+			metricsPrefixRows.WithLabelValues(p.Prefix).Set(float64(p.Rows))
+			metricsPrefixBytes.WithLabelValues(p.Prefix).Set(float64(p.Bytes))
+		}
+		logger.WithFields(logrus.Fields{"prefixes": len(analysis.Prefixes), "duration": time.Since(start)}).Debug("Updated prefix metrics")
+	}
+}
+
+func resetPrefixMetrics() {
+	metricsPrefixKeys.Reset()
+	metricsPrefixRows.Reset()
+	metricsPrefixBytes.Reset()
+}
@@ -0,0 +1,51 @@
+package server
+
+import (
+	"reflect"
+	"testing"
+
+	kine_server "github.com/canonical/k8s-dqlite/pkg/kine/server"
+)
+
+func TestKeyPrefix(t *testing.T) {
+	for key, expected := range map[string]string{
+		"/registry/pods/default/foo":           "/registry/pods/",
+		"/registry/example.com/widgets/ns/foo": "/registry/example.com/",
+		"/registry/health":                     "/registry/",
+		"/k8s-dqlite/cluster-uuid":             "/k8s-dqlite/",
+		"/key":                                 "/",
+		"compact_rev_key":                      internalPrefix,
+		"gap-42":                               internalPrefix,
+	} {
+		if prefix := keyPrefix(key); prefix != expected {
+			t.Errorf("expected the prefix of %q to be %q, got %q", key, expected, prefix)
+		}
+	}
+}
+
+func TestAnalyze(t *testing.T) {
+	analysis := analyze([]kine_server.KeyStats{
+		{Key: "/registry/pods/default/a", Size: 10, Revisions: 3, Bytes: 50},
+		{Key: "/registry/pods/default/b", Size: 30, Revisions: 1, Bytes: 30},
+		{Key: "/registry/pods/default/c", Deleted: true, Size: 100, Revisions: 2, Bytes: 200},
+		{Key: "/registry/secrets/default/d", Size: 20, Revisions: 1, Bytes: 20},
+		{Key: "/registry/secrets/default/e", Size: 20, Revisions: 1, Bytes: 20},
+		{Key: "compact_rev_key", Revisions: 1},
+	}, 3)
+
+	expected := Analysis{
+		Prefixes: []PrefixStats{
+			{Prefix: "/registry/pods/", Keys: 2, Rows: 6, Bytes: 280},
+			{Prefix: "/registry/secrets/", Keys: 2, Rows: 2, Bytes: 40},
+			{Prefix: internalPrefix, Rows: 1},
+		},
+		Largest: []KeySize{
+			{Key: "/registry/pods/default/b", Size: 30, Revisions: 1},
+			{Key: "/registry/secrets/default/d", Size: 20, Revisions: 1},
+			{Key: "/registry/secrets/default/e", Size: 20, Revisions: 1},
+		},
+	}
+	if !reflect.DeepEqual(analysis, expected) {
+		t.Fatalf("expected %+v, got %+v", expected, analysis)
+	}
+}
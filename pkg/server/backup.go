@@ -0,0 +1,185 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/canonical/k8s-dqlite/pkg/snapshot"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// scheduledBackupPrefix and scheduledBackupSuffix frame the names of the
+	// scheduled backups, which sort by time.
+	scheduledBackupPrefix = "k8s-dqlite-"
+	scheduledBackupSuffix = ".tar.gz"
+	scheduledBackupTime   = "20060102T150405Z"
+)
+
+var (
+	metricsScheduledBackups = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "k8s_dqlite_scheduled_backups",
+		Help: "Total number of scheduled backups by result",
+	}, []string{"result"})
+	metricsScheduledBackupLastSuccess = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "k8s_dqlite_scheduled_backup_last_success_timestamp_seconds",
+		Help: "Time of the last successful scheduled backup, in seconds since the epoch",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(metricsScheduledBackups, metricsScheduledBackupLastSuccess)
+}
+
+// BackupConfig configures the scheduled backups, which are disabled if
+// the interval is zero.
+type BackupConfig struct {
+	// Interval is the interval between the backups.
+	Interval time.Duration
+	// Retention is the number of backups kept on the target, the oldest
+	// being deleted. Zero keeps all of them.
+	Retention int
+	// Target is the URL of the storage of the backups, as accepted by
+	// snapshot.NewTarget.
+	Target string
+}
+
+func (c BackupConfig) validate() error {
+	if c.Interval < 0 {
+		return fmt.Errorf("the backup interval must not be negative")
+	}
+	if c.Retention < 0 {
+		return fmt.Errorf("the backup retention must not be negative")
+	}
+	if c.Interval > 0 && c.Target == "" {
+		return fmt.Errorf("scheduled backups need a backup target")
+	}
+	return nil
+}
+
+// Backup writes a backup archive of the datastore to w. The rows are copied
+// in a single transaction on the dqlite leader, so that the backup is
+// consistent while the cluster keeps serving requests. The copy is staged
+// in the storage directory, which has room for the database.
+func (s *Server) Backup(ctx context.Context, w io.Writer) (snapshot.ArchiveMetadata, error) {
+	dir, err := os.MkdirTemp(s.storageDir, "backup-")
+	if err != nil {
+		return snapshot.ArchiveMetadata{}, fmt.Errorf("failed to create backup directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	start := time.Now()
+	path := filepath.Join(dir, "k8s-dqlite.db")
+	rev, err := s.backend.DoBackup(ctx, path)
+	if err != nil {
+		return snapshot.ArchiveMetadata{}, fmt.Errorf("failed to copy datastore: %w", err)
+	}
+
+	metadata, err := snapshot.WriteArchive(ctx, w, path, snapshot.ArchiveMetadata{
+		Created:   start.UTC(),
+		Revision:  rev,
+		ClusterID: s.cluster.ClusterID(),
+	})
+	if err != nil {
+		return snapshot.ArchiveMetadata{}, fmt.Errorf("failed to write backup archive: %w", err)
+	}
+	logger.WithFields(logrus.Fields{
+		"revision": metadata.Revision,
+		"size":     metadata.Size,
+		"duration": time.Since(start),
+	}).Print("Backed up datastore")
+	return metadata, nil
+}
+
+// scheduleBackups stores a backup on the backup target every backup
+// interval, until ctx is done. The backups are taken by the dqlite leader
+// only, as the nodes share the same data.
+func (s *Server) scheduleBackups(ctx context.Context) {
+	if s.backup.Interval == 0 {
+		return
+	}
+	logger.WithFields(logrus.Fields{"interval": s.backup.Interval, "retention": s.backup.Retention}).Print("Schedule backups")
+	ticker := time.NewTicker(s.backup.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		id, err := s.leaderID(ctx)
+		if err != nil {
+			logger.WithError(err).Warning("Failed to get dqlite leader, skipping scheduled backup")
+			continue
+		}
+		if id != s.app.ID() {
+			continue
+		}
+		if err := s.scheduledBackup(ctx); err != nil {
+			metricsScheduledBackups.WithLabelValues("failure").Inc()
+			logger.WithError(err).Error("Scheduled backup failed")
+			continue
+		}
+		metricsScheduledBackups.WithLabelValues("success").Inc()
+		metricsScheduledBackupLastSuccess.SetToCurrentTime()
+	}
+}
+
+// scheduledBackup stores a backup on the backup target, and deletes the
+// backups exceeding the retention.
+func (s *Server) scheduledBackup(ctx context.Context) error {
+	f, err := os.CreateTemp(s.storageDir, "backup-*"+scheduledBackupSuffix)
+	if err != nil {
+		return fmt.Errorf("failed to create backup file: %w", err)
+	}
+	defer os.Remove(f.Name())
+	metadata, err := s.Backup(ctx, f)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%s%s-%d%s", scheduledBackupPrefix, metadata.Created.Format(scheduledBackupTime), metadata.Revision, scheduledBackupSuffix)
+	if err := s.backupTarget.Put(ctx, name, f.Name()); err != nil {
+		return fmt.Errorf("failed to store backup %s: %w", name, err)
+	}
+	logger.WithField("name", name).Print("Stored scheduled backup")
+
+	if s.backup.Retention == 0 {
+		return nil
+	}
+	return pruneBackups(ctx, s.backupTarget, s.backup.Retention)
+}
+
+// pruneBackups deletes the oldest scheduled backups of target, keeping
+// retention of them. The other files of the target are left alone.
+func pruneBackups(ctx context.Context, target snapshot.Target, retention int) error {
+	names, err := target.List(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list backups: %w", err)
+	}
+	var backups []string
+	for _, name := range names {
+		if strings.HasPrefix(name, scheduledBackupPrefix) && strings.HasSuffix(name, scheduledBackupSuffix) {
+			backups = append(backups, name)
+		}
+	}
+	for len(backups) > retention {
+		if err := target.Delete(ctx, backups[0]); err != nil {
+			return fmt.Errorf("failed to delete backup %s: %w", backups[0], err)
+		}
+		logger.WithField("name", backups[0]).Print("Deleted expired backup")
+		backups = backups[1:]
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package server
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/canonical/k8s-dqlite/pkg/snapshot"
+)
+
+func TestBackupConfig(t *testing.T) {
+	for _, config := range []BackupConfig{
+		{},
+		{Interval: time.Hour, Target: "file:///backups"},
+		{Interval: time.Hour, Retention: 3, Target: "file:///backups"},
+	} {
+		if err := config.validate(); err != nil {
+			t.Errorf("expected %+v to be valid, got %v", config, err)
+		}
+	}
+	for _, config := range []BackupConfig{
+		{Interval: -time.Hour, Target: "file:///backups"},
+		{Interval: time.Hour, Retention: -1, Target: "file:///backups"},
+		{Interval: time.Hour},
+	} {
+		if err := config.validate(); err == nil {
+			t.Errorf("expected %+v to be invalid", config)
+		}
+	}
+}
+
+func TestPruneBackups(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	target, err := snapshot.NewTarget("file://" + dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{
+		"k8s-dqlite-20240101T000000Z-10.tar.gz",
+		"k8s-dqlite-20240102T000000Z-20.tar.gz",
+		"k8s-dqlite-20240103T000000Z-30.tar.gz",
+		"manual.tar.gz",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := pruneBackups(ctx, target, 2); err != nil {
+		t.Fatal(err)
+	}
+	names, err := target.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"k8s-dqlite-20240102T000000Z-20.tar.gz",
+		"k8s-dqlite-20240103T000000Z-30.tar.gz",
+		"manual.tar.gz",
+	}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("expected %v, got %v", expected, names)
+	}
+}
@@ -0,0 +1,269 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// certificateCheckInterval is the interval between the checks for changes
+// of the certificate files.
+const certificateCheckInterval = 30 * time.Second
+
+// tlsCertificate is a certificate loaded from files, along with the CA
+// verifying the certificates of the peers, if any. By default, the
+// certificate of the dqlite cluster is loaded from cluster.crt and
+// cluster.key in the storage directory, cluster.crt also being the CA of
+// the cluster. It can be reloaded while the node runs: the new connections
+// use the certificate loaded last.
+type tlsCertificate struct {
+	crtFile, keyFile, caFile string
+
+	mu      sync.RWMutex
+	keypair *tls.Certificate
+	pool    *x509.CertPool
+	// serverName is the name the certificates of the other nodes are
+	// verified against.
+	serverName string
+	// stamp identifies the version of the files loaded last.
+	stamp string
+}
+
+// newClusterCertificate loads the certificate of the dqlite cluster from
+// the storage directory.
+func newClusterCertificate(dir string) (*tlsCertificate, error) {
+	crtFile := filepath.Join(dir, "cluster.crt")
+	return newTLSCertificate(crtFile, filepath.Join(dir, "cluster.key"), crtFile)
+}
+
+// newTLSCertificate loads the certificate in crtFile and keyFile, and the
+// CA in caFile, if any.
+func newTLSCertificate(crtFile, keyFile, caFile string) (*tlsCertificate, error) {
+	c := &tlsCertificate{crtFile: crtFile, keyFile: keyFile, caFile: caFile}
+	if err := c.reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// loadClusterCertificate loads the cluster certificate and the pool of the
+// certificates it trusts.
+func loadClusterCertificate(dir string) (tls.Certificate, *x509.CertPool, error) {
+	crtFile := filepath.Join(dir, "cluster.crt")
+	keypair, err := tls.LoadX509KeyPair(crtFile, filepath.Join(dir, "cluster.key"))
+	if err != nil {
+		return tls.Certificate{}, nil, fmt.Errorf("failed to load keypair from cluster.crt and cluster.key: %w", err)
+	}
+	pool, err := loadCertPool(crtFile)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+	return keypair, pool, nil
+}
+
+// loadCertPool loads the PEM encoded certificates in path.
+func loadCertPool(path string) (*x509.CertPool, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(b) {
+		return nil, fmt.Errorf("no certificate found in %s", path)
+	}
+	return pool, nil
+}
+
+func (c *tlsCertificate) files() []string {
+	files := []string{c.crtFile, c.keyFile}
+	if c.caFile != "" {
+		files = append(files, c.caFile)
+	}
+	return files
+}
+
+// fileStamp identifies the version of the certificate files by their size
+// and modification time.
+func (c *tlsCertificate) fileStamp() (string, error) {
+	var stamp string
+	for _, file := range c.files() {
+		info, err := os.Stat(file)
+		if err != nil {
+			return "", err
+		}
+		stamp += fmt.Sprintf("%d:%d;", info.Size(), info.ModTime().UnixNano())
+	}
+	return stamp, nil
+}
+
+// watch reloads the certificate when its files change, until ctx is done,
+// so that rotated certificates are used without restarting the node.
+func (c *tlsCertificate) watch(ctx context.Context, interval time.Duration) {
+	logger := logger.WithField("cert_file", c.crtFile)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		stamp, err := c.fileStamp()
+		if err != nil {
+			logger.WithError(err).Warning("Failed to check certificate")
+			continue
+		}
+		c.mu.RLock()
+		changed := stamp != c.stamp
+		c.mu.RUnlock()
+		if !changed {
+			continue
+		}
+		// The files might be replaced one after the other, in which
+		// case they are loaded on the next check.
+		if err := c.reload(); err != nil {
+			logger.WithError(err).Warning("Failed to reload certificate")
+			continue
+		}
+		logger.Print("Reloaded certificate")
+	}
+}
+
+// reload loads the certificate again from its files. The current
+// certificate is kept if the new one is invalid.
+func (c *tlsCertificate) reload() error {
+	// The files are stamped before they are read, so that changes made
+	// while reading them are noticed.
+	stamp, err := c.fileStamp()
+	if err != nil {
+		return fmt.Errorf("failed to check certificate: %w", err)
+	}
+	keypair, err := tls.LoadX509KeyPair(c.crtFile, c.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load keypair from %s and %s: %w", c.crtFile, c.keyFile, err)
+	}
+	var pool *x509.CertPool
+	if c.caFile != "" {
+		if pool, err = loadCertPool(c.caFile); err != nil {
+			return err
+		}
+	}
+	cert, err := x509.ParseCertificate(keypair.Certificate[0])
+	if err != nil {
+		return fmt.Errorf("failed to parse %s: %w", c.crtFile, err)
+	}
+	if pool != nil && len(cert.DNSNames) == 0 {
+		return fmt.Errorf("%s has no DNS name", c.crtFile)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.keypair = &keypair
+	c.pool = pool
+	if len(cert.DNSNames) > 0 {
+		c.serverName = cert.DNSNames[0]
+	}
+	c.stamp = stamp
+	return nil
+}
+
+func (c *tlsCertificate) certificate() *tls.Certificate {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.keypair
+}
+
+// peerTLSConfig returns the TLS configurations of the connections accepted
+// from, and dialed to, the other nodes. As with app.SimpleTLSConfig, the
+// nodes authenticate each other with certificates signed by the CA, but the
+// certificates are verified against the CA loaded last instead of the one
+// loaded when the connection was configured.
+func (c *tlsCertificate) peerTLSConfig() (listen, dial *tls.Config) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	listen = &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		// The client certificates are verified by verifyClient. The
+		// dqlite proxy still needs ClientCAs to tell that the config is
+		// the one of the server side.
+		ClientCAs:  c.pool,
+		ClientAuth: tls.RequireAnyClientCert,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return c.certificate(), nil
+		},
+		VerifyConnection: c.verifyClient,
+	}
+	dial = &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		// The server certificates are verified by verifyServer.
+		InsecureSkipVerify: true,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return c.certificate(), nil
+		},
+		VerifyConnection: c.verifyServer,
+	}
+	return listen, dial
+}
+
+// endpointTLSConfig returns the TLS configuration of the kine endpoint,
+// which presents the certificate and verifies the certificates of the
+// clients against clientCAs, if any. The clients without a certificate are
+// refused if requireClientCert is set.
+func (c *tlsCertificate) endpointTLSConfig(clientCAs *x509.CertPool, requireClientCert bool) *tls.Config {
+	clientAuth := tls.NoClientCert
+	switch {
+	case clientCAs != nil && requireClientCert:
+		clientAuth = tls.RequireAndVerifyClientCert
+	case clientCAs != nil:
+		clientAuth = tls.VerifyClientCertIfGiven
+	}
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ClientCAs:  clientCAs,
+		ClientAuth: clientAuth,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return c.certificate(), nil
+		},
+	}
+}
+
+func (c *tlsCertificate) verifyClient(state tls.ConnectionState) error {
+	return c.verify(state, "", x509.ExtKeyUsageClientAuth)
+}
+
+func (c *tlsCertificate) verifyServer(state tls.ConnectionState) error {
+	c.mu.RLock()
+	serverName := c.serverName
+	c.mu.RUnlock()
+	return c.verify(state, serverName, x509.ExtKeyUsageServerAuth)
+}
+
+func (c *tlsCertificate) verify(state tls.ConnectionState, serverName string, usage x509.ExtKeyUsage) error {
+	if len(state.PeerCertificates) == 0 {
+		return errors.New("no peer certificate")
+	}
+	c.mu.RLock()
+	pool := c.pool
+	c.mu.RUnlock()
+
+	opts := x509.VerifyOptions{
+		Roots:         pool,
+		DNSName:       serverName,
+		Intermediates: x509.NewCertPool(),
+		KeyUsages:     []x509.ExtKeyUsage{usage},
+	}
+	for _, cert := range state.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	_, err := state.PeerCertificates[0].Verify(opts)
+	return err
+}
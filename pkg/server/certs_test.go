@@ -0,0 +1,176 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeClusterCertificate writes a self-signed cluster certificate with the
+// given serial number to dir.
+func writeClusterCertificate(t *testing.T, dir string, serial int64) {
+	writeCertificate(t, dir, "cluster", serial, nil, nil)
+}
+
+// writeCertificate writes the certificate name.crt and its key name.key to
+// dir, signed by parent, or self-signed if parent is nil.
+func writeCertificate(t *testing.T, dir, name string, serial int64, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(serial),
+		Subject:               pkix.Name{CommonName: "k8s-dqlite"},
+		DNSNames:              []string{"k8s-dqlite"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, name+".crt"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, name+".key"), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+// handshake connects the listen and dial configurations, and returns the
+// serial number of the certificate presented by the server.
+func handshake(listen, dial *tls.Config) (int64, error) {
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+	defer clientConn.Close()
+
+	server := tls.Server(serverConn, listen)
+	errCh := make(chan error, 1)
+	go func() { errCh <- server.Handshake() }()
+
+	client := tls.Client(clientConn, dial)
+	if err := client.Handshake(); err != nil {
+		return 0, err
+	}
+	if err := <-errCh; err != nil {
+		return 0, err
+	}
+	return client.ConnectionState().PeerCertificates[0].SerialNumber.Int64(), nil
+}
+
+func TestClusterCertificateReload(t *testing.T) {
+	dir := t.TempDir()
+	writeClusterCertificate(t, dir, 1)
+
+	certificate, err := newClusterCertificate(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listen, dial := certificate.peerTLSConfig()
+	if serial, err := handshake(listen, dial); err != nil || serial != 1 {
+		t.Fatalf("expected certificate 1, got %d (%v)", serial, err)
+	}
+
+	writeClusterCertificate(t, dir, 2)
+	if err := certificate.reload(); err != nil {
+		t.Fatal(err)
+	}
+	if serial, err := handshake(listen, dial); err != nil || serial != 2 {
+		t.Fatalf("expected certificate 2, got %d (%v)", serial, err)
+	}
+
+	// A node still using the previous certificate is refused.
+	oldDir := t.TempDir()
+	writeClusterCertificate(t, oldDir, 3)
+	old, err := newClusterCertificate(oldDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, oldDial := old.peerTLSConfig()
+	if _, err := handshake(listen, oldDial); err == nil {
+		t.Fatal("expected the handshake with an untrusted certificate to fail")
+	}
+}
+
+func TestClusterCertificateWatch(t *testing.T) {
+	dir := t.TempDir()
+	writeClusterCertificate(t, dir, 1)
+
+	certificate, err := newClusterCertificate(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listen, dial := certificate.peerTLSConfig()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go certificate.watch(ctx, 10*time.Millisecond)
+
+	writeClusterCertificate(t, dir, 2)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		serial, err := handshake(listen, dial)
+		if err == nil && serial == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected certificate 2 to be loaded, got %d (%v)", serial, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestPeerCertificateCA(t *testing.T) {
+	dir := t.TempDir()
+	ca, caKey := writeCertificate(t, dir, "ca", 1, nil, nil)
+	writeCertificate(t, dir, "node1", 2, ca, caKey)
+	writeCertificate(t, dir, "node2", 3, ca, caKey)
+	writeCertificate(t, dir, "intruder", 4, nil, nil)
+
+	load := func(name, caFile string) *tlsCertificate {
+		certificate, err := newTLSCertificate(filepath.Join(dir, name+".crt"), filepath.Join(dir, name+".key"), caFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return certificate
+	}
+	caFile := filepath.Join(dir, "ca.crt")
+	listen, _ := load("node1", caFile).peerTLSConfig()
+	_, dial := load("node2", caFile).peerTLSConfig()
+	if serial, err := handshake(listen, dial); err != nil || serial != 2 {
+		t.Fatalf("expected certificate 2, got %d (%v)", serial, err)
+	}
+
+	_, intruderDial := load("intruder", filepath.Join(dir, "intruder.crt")).peerTLSConfig()
+	if _, err := handshake(listen, intruderDial); err == nil {
+		t.Fatal("expected the handshake with a certificate not signed by the CA to fail")
+	}
+}
@@ -0,0 +1,217 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/url"
+	"sync/atomic"
+
+	"github.com/canonical/go-dqlite"
+	"github.com/canonical/go-dqlite/app"
+	"github.com/canonical/go-dqlite/client"
+	"github.com/canonical/k8s-dqlite/pkg/kine/server"
+)
+
+var _ server.Cluster = (*dqliteCluster)(nil)
+
+// clusterUUIDKey is the key storing the UUID of the cluster.
+const clusterUUIDKey = "/k8s-dqlite/cluster-uuid"
+
+// dqliteCluster exposes the state of the dqlite cluster to kine. The
+// members are the dqlite nodes, with their dqlite address as peer URL, and
+// the voters are the voting members.
+type dqliteCluster struct {
+	app *app.App
+	// peerScheme is the scheme of the peer URLs, https if the nodes use
+	// TLS.
+	peerScheme string
+	// clientURL is the URL of the kine endpoint of the local node.
+	clientURL string
+	// dir is the data directory of the local node.
+	dir string
+	// id is the ID of the cluster, once loaded.
+	id atomic.Uint64
+}
+
+func (c *dqliteCluster) MemberID() uint64 {
+	return c.app.ID()
+}
+
+func (c *dqliteCluster) ClusterID() uint64 {
+	return c.id.Load()
+}
+
+// loadID loads the ID of the cluster, derived from the UUID of the cluster
+// stored in the datastore. The first node to load it generates the UUID,
+// so that it is the same for all the nodes and for the lifetime of the
+// cluster.
+func (c *dqliteCluster) loadID(ctx context.Context, backend server.Backend) error {
+	uuid := make([]byte, 16)
+	if _, err := rand.Read(uuid); err != nil {
+		return fmt.Errorf("failed to generate cluster UUID: %w", err)
+	}
+	// Set the version and variant of a random UUID.
+	uuid[6] = uuid[6]&0x0f | 0x40
+	uuid[8] = uuid[8]&0x3f | 0x80
+	value := []byte(fmt.Sprintf("%x-%x-%x-%x-%x", uuid[0:4], uuid[4:6], uuid[6:8], uuid[8:10], uuid[10:]))
+
+	_, created, err := backend.Create(ctx, clusterUUIDKey, value, 0)
+	if err != nil {
+		return fmt.Errorf("failed to store cluster UUID: %w", err)
+	}
+	if !created {
+		_, kv, err := backend.Get(ctx, clusterUUIDKey, "", 1, 0)
+		if err != nil {
+			return fmt.Errorf("failed to load cluster UUID: %w", err)
+		}
+		if kv == nil {
+			return fmt.Errorf("cluster UUID not found")
+		}
+		value = kv.Value
+	}
+
+	hash := sha256.Sum256(value)
+	c.id.Store(binary.BigEndian.Uint64(hash[:8]))
+	return nil
+}
+
+func (c *dqliteCluster) Leader(ctx context.Context) (uint64, error) {
+	cli, err := c.app.Client(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to connect to the local node: %w", err)
+	}
+	defer cli.Close()
+
+	leader, err := cli.Leader(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if leader == nil {
+		return 0, fmt.Errorf("no leader elected")
+	}
+	return leader.ID, nil
+}
+
+// RaftStatus reads the raft term and the index of the last closed entry from
+// the data directory of the local node, as dqlite does not expose them.
+func (c *dqliteCluster) RaftStatus() (term, index uint64, err error) {
+	state, err := readRaftState(c.dir)
+	if err != nil {
+		return 0, 0, err
+	}
+	return state.Term, state.LastIndex, nil
+}
+
+func (c *dqliteCluster) Members(ctx context.Context) ([]server.Member, error) {
+	cli, err := c.app.Leader(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to the leader: %w", err)
+	}
+	defer cli.Close()
+
+	nodes, err := cli.Cluster(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the nodes: %w", err)
+	}
+	members := make([]server.Member, 0, len(nodes))
+	for _, node := range nodes {
+		members = append(members, c.member(node))
+	}
+	return members, nil
+}
+
+func (c *dqliteCluster) member(node client.NodeInfo) server.Member {
+	member := server.Member{
+		ID:       node.ID,
+		Name:     node.Address,
+		PeerURLs: []string{(&url.URL{Scheme: c.peerScheme, Host: node.Address}).String()},
+		Learner:  node.Role != client.Voter,
+	}
+	if node.ID == c.app.ID() && c.clientURL != "" {
+		member.ClientURLs = []string{c.clientURL}
+	}
+	return member
+}
+
+// AddMember adds a dqlite node, with the ID dqlite generates for its
+// address. Voters are added as spares first, then promoted, and learners
+// are standbys, which replicate the datastore without voting.
+func (c *dqliteCluster) AddMember(ctx context.Context, peerURL string, learner bool) (server.Member, error) {
+	address, err := peerAddress(peerURL)
+	if err != nil {
+		return server.Member{}, err
+	}
+	cli, err := c.app.Leader(ctx)
+	if err != nil {
+		return server.Member{}, fmt.Errorf("failed to connect to the leader: %w", err)
+	}
+	defer cli.Close()
+
+	node := client.NodeInfo{ID: dqlite.GenerateID(address), Address: address, Role: client.Spare}
+	if err := cli.Add(ctx, node); err != nil {
+		return server.Member{}, fmt.Errorf("failed to add node: %w", err)
+	}
+	node.Role = client.Voter
+	if learner {
+		node.Role = client.StandBy
+	}
+	if err := cli.Assign(ctx, node.ID, node.Role); err != nil {
+		return server.Member{}, fmt.Errorf("failed to assign role of node: %w", err)
+	}
+	return c.member(node), nil
+}
+
+func (c *dqliteCluster) RemoveMember(ctx context.Context, id uint64) error {
+	cli, err := c.app.Leader(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to connect to the leader: %w", err)
+	}
+	defer cli.Close()
+
+	return cli.Remove(ctx, id)
+}
+
+// UpdateMember only accepts the current address of the node, as the address
+// of a dqlite node changes through update.yaml while it is stopped.
+func (c *dqliteCluster) UpdateMember(ctx context.Context, id uint64, peerURLs []string) error {
+	members, err := c.Members(ctx)
+	if err != nil {
+		return err
+	}
+	for _, member := range members {
+		if member.ID != id {
+			continue
+		}
+		if len(peerURLs) == 1 && peerURLs[0] == member.PeerURLs[0] {
+			return nil
+		}
+		return fmt.Errorf("changing the address of a running dqlite node is unsupported, use update.yaml instead")
+	}
+	return fmt.Errorf("member %d not found", id)
+}
+
+func (c *dqliteCluster) PromoteMember(ctx context.Context, id uint64) error {
+	cli, err := c.app.Leader(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to connect to the leader: %w", err)
+	}
+	defer cli.Close()
+
+	return cli.Assign(ctx, id, client.Voter)
+}
+
+// peerAddress returns the dqlite address, as host:port, of a peer URL.
+func peerAddress(peerURL string) (string, error) {
+	u, err := url.Parse(peerURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid peer URL %q: %w", peerURL, err)
+	}
+	if _, _, err := net.SplitHostPort(u.Host); err != nil {
+		return "", fmt.Errorf("invalid peer URL %q, must have a host and port: %w", peerURL, err)
+	}
+	return u.Host, nil
+}
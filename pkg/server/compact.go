@@ -0,0 +1,162 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// revisionSampleInterval is the interval between the samples of the
+	// current revision, which map the times to the revisions compacted.
+	revisionSampleInterval = time.Minute
+	// revisionSampleRetention is how long the samples are kept.
+	revisionSampleRetention = 7 * 24 * time.Hour
+	// lowStorageReclaimInterval is the minimum interval between the
+	// reclaims of space when the available storage is low.
+	lowStorageReclaimInterval = 10 * time.Minute
+)
+
+// CompactOptions select the revisions compacted on demand: those up to
+// Revision, or else those older than Keep.
+type CompactOptions struct {
+	Revision int64         `json:"revision,omitempty"`
+	Keep     time.Duration `json:"keep,omitempty"`
+}
+
+// CompactResult is the result of an on-demand compaction.
+type CompactResult struct {
+	// Revision is the compact revision after the compaction.
+	Revision int64 `json:"revision"`
+}
+
+// DefragResult is the result of an on-demand defragmentation.
+type DefragResult struct {
+	// Before and After are the sizes of the database file before and after
+	// the defragmentation.
+	Before int64 `json:"before"`
+	After  int64 `json:"after"`
+}
+
+type revisionSample struct {
+	time     time.Time
+	revision int64
+}
+
+// revisionSampler samples the current revision, to find the revisions
+// older than a given time, as kine doesn't record the time of the
+// revisions. The samples only cover the time the node has been running.
+type revisionSampler struct {
+	mu      sync.Mutex
+	samples []revisionSample
+}
+
+// add records the current revision at now, and forgets the samples older
+// than the retention, except the newest of them.
+func (r *revisionSampler) add(now time.Time, revision int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.samples = append(r.samples, revisionSample{time: now, revision: revision})
+	deadline := now.Add(-revisionSampleRetention)
+	i := 0
+	for i+1 < len(r.samples) && r.samples[i+1].time.Before(deadline) {
+		i++
+	}
+	r.samples = r.samples[i:]
+}
+
+// before returns the newest revision sampled before deadline, which all
+// the older revisions precede, and whether any sample is that old.
+func (r *revisionSampler) before(deadline time.Time) (int64, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var (
+		revision int64
+		found    bool
+	)
+	for _, sample := range r.samples {
+		if !sample.time.Before(deadline) {
+			break
+		}
+		revision, found = sample.revision, true
+	}
+	return revision, found
+}
+
+// sampleRevisions samples the current revision every sample interval,
+// until ctx is done.
+func (s *Server) sampleRevisions(ctx context.Context) {
+	ticker := time.NewTicker(revisionSampleInterval)
+	defer ticker.Stop()
+	for {
+		if revision, err := s.backend.CurrentRevision(ctx); err == nil {
+			s.revisions.add(time.Now(), revision)
+		} else if ctx.Err() == nil {
+			logger.WithError(err).Debug("Failed to sample current revision")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Compact compacts the revisions selected by opts on demand, regardless of
+// the periodic compactions. The revisions older than opts.Keep are found
+// from the revisions sampled since the node started, so only those
+// sampled before are compacted.
+func (s *Server) Compact(ctx context.Context, opts CompactOptions) (CompactResult, error) {
+	revision := opts.Revision
+	if revision <= 0 {
+		if opts.Keep <= 0 {
+			return CompactResult{}, fmt.Errorf("either a revision or a retention must be given")
+		}
+		var ok bool
+		if revision, ok = s.revisions.before(time.Now().Add(-opts.Keep)); !ok {
+			return CompactResult{}, fmt.Errorf("no revision is known to be older than %v, as the revisions are only sampled while the node runs", opts.Keep)
+		}
+	}
+
+	start := time.Now()
+	compacted, err := s.backend.CompactTo(ctx, revision)
+	if err != nil {
+		return CompactResult{}, err
+	}
+	logger.WithFields(logrus.Fields{"revision": compacted, "duration": time.Since(start)}).Print("Compacted datastore")
+	return CompactResult{Revision: compacted}, nil
+}
+
+// Defrag vacuums the database on demand, as configured for the
+// defragmentations, returning its unused pages to the file system.
+func (s *Server) Defrag(ctx context.Context) (DefragResult, error) {
+	before, after, err := s.backend.DoVacuum(ctx)
+	if err != nil {
+		return DefragResult{}, err
+	}
+	logger.WithFields(logrus.Fields{"before": before, "after": after}).Print("Defragmented datastore")
+	return DefragResult{Before: before, After: after}, nil
+}
+
+// reclaimStorage compacts the datastore up to the current revision, which
+// keeps the latest revision of each key, and vacuums the database, to free
+// as much space as possible when the available storage is low. The watches
+// behind the current revision are canceled as compacted, and the clients
+// list the keys again.
+func (s *Server) reclaimStorage(ctx context.Context) {
+	revision, err := s.backend.CurrentRevision(ctx)
+	if err != nil {
+		logger.WithError(err).Warning("Failed to get current revision, skipping reclaim")
+		return
+	}
+	if _, err := s.Compact(ctx, CompactOptions{Revision: revision}); err != nil {
+		logger.WithError(err).Warning("Failed to compact datastore")
+	}
+	if _, err := s.Defrag(ctx); err != nil {
+		logger.WithError(err).Warning("Failed to defragment datastore")
+	}
+}
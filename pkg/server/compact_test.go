@@ -0,0 +1,40 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRevisionSampler(t *testing.T) {
+	var r revisionSampler
+	now := time.Now()
+	if _, ok := r.before(now); ok {
+		t.Fatal("expected no revision without samples")
+	}
+
+	for i := 0; i < 10; i++ {
+		r.add(now.Add(time.Duration(i)*time.Hour), int64(100*(i+1)))
+	}
+	for _, tc := range []struct {
+		deadline time.Time
+		revision int64
+		ok       bool
+	}{
+		{deadline: now, ok: false},
+		{deadline: now.Add(time.Minute), revision: 100, ok: true},
+		{deadline: now.Add(5*time.Hour + time.Minute), revision: 600, ok: true},
+		{deadline: now.Add(24 * time.Hour), revision: 1000, ok: true},
+	} {
+		revision, ok := r.before(tc.deadline)
+		if revision != tc.revision || ok != tc.ok {
+			t.Errorf("expected revision %d (%v) before %v, got %d (%v)", tc.revision, tc.ok, tc.deadline.Sub(now), revision, ok)
+		}
+	}
+
+	// The samples older than the retention are forgotten, except the
+	// newest of them.
+	r.add(now.Add(revisionSampleRetention+2*time.Hour+time.Minute), 2000)
+	if len(r.samples) != 9 || r.samples[0].revision != 300 {
+		t.Fatalf("unexpected samples %+v", r.samples)
+	}
+}
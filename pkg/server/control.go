@@ -0,0 +1,614 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/canonical/go-dqlite/client"
+	"github.com/canonical/k8s-dqlite/pkg/kine/drivers/sqlite"
+	"github.com/canonical/k8s-dqlite/pkg/snapshot"
+)
+
+// Member is a node of the dqlite cluster, as listed by the control API.
+type Member struct {
+	ID      uint64 `json:"id"`
+	Address string `json:"address"`
+	Role    string `json:"role"`
+}
+
+// membershipClient is the part of the dqlite client changing the membership
+// of the cluster.
+type membershipClient interface {
+	Cluster(ctx context.Context) ([]client.NodeInfo, error)
+	Add(ctx context.Context, node client.NodeInfo) error
+	Assign(ctx context.Context, id uint64, role client.NodeRole) error
+	Remove(ctx context.Context, id uint64) error
+	Close() error
+}
+
+// ParseRole parses the name of a dqlite role: voter, standby or spare.
+func ParseRole(role string) (client.NodeRole, error) {
+	switch role {
+	case "voter":
+		return client.Voter, nil
+	case "standby", "stand-by":
+		return client.StandBy, nil
+	case "spare":
+		return client.Spare, nil
+	}
+	return 0, fmt.Errorf("unsupported role %q (supported values are voter, standby, spare)", role)
+}
+
+// ListenControl listens on the control socket at path, which only the
+// owner of the process can connect to. A socket left by a previous run is
+// replaced.
+func ListenControl(path string) (net.Listener, error) {
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to remove stale control socket: %w", err)
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on control socket: %w", err)
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("failed to restrict access to control socket: %w", err)
+	}
+	return listener, nil
+}
+
+// controlNode is the node the control API operates on.
+type controlNode interface {
+	// membership returns a client connected to the leader, which changes
+	// the membership.
+	membership(ctx context.Context) (membershipClient, error)
+	// transferLeadership transfers the leadership of the node, if held,
+	// and returns the ID of the leader.
+	transferLeadership(ctx context.Context) (uint64, error)
+	// Backup writes a backup archive of the datastore to w.
+	Backup(ctx context.Context, w io.Writer) (snapshot.ArchiveMetadata, error)
+	// History returns the revisions of a key retained in the datastore.
+	History(ctx context.Context, key string) (KeyHistory, error)
+	// ListKeys lists the keys with a prefix.
+	ListKeys(ctx context.Context, opts ListKeysOptions) (KeyList, error)
+	// Analyze reports what uses the storage of the datastore.
+	Analyze(ctx context.Context, top int) (Analysis, error)
+	// SchemaVersion returns the schema version of the database.
+	SchemaVersion(ctx context.Context) (sqlite.SchemaVersion, error)
+	// Verify verifies the integrity of the datastore.
+	Verify(ctx context.Context) (Verification, error)
+	// Compact compacts the datastore on demand.
+	Compact(ctx context.Context, opts CompactOptions) (CompactResult, error)
+	// Defrag defragments the datastore on demand.
+	Defrag(ctx context.Context) (DefragResult, error)
+	// Status reports the status of the node and its cluster.
+	Status(ctx context.Context) (Status, error)
+	// Watches lists the watches served by the node.
+	Watches() []Watch
+	// Connections lists the client connections of the node.
+	Connections() []Connection
+}
+
+// ControlHandler serves the JSON API of the control socket:
+//   - GET /members lists the members of the cluster.
+//   - POST /members adds a member.
+//   - DELETE /members/{id} removes a member.
+//   - PUT /members/{id}/role assigns the role of a member.
+//   - POST /handover transfers the leadership of the node to another voter.
+//   - POST /backup streams a backup archive of the datastore.
+//   - GET /history?key={key} lists the revisions of a key.
+//   - GET /keys?prefix={prefix} lists the keys with a prefix, from the
+//     optional start key, up to limit keys at revision, with their values
+//     if values is true.
+//   - GET /analyze?top={top} reports the statistics of the top-level
+//     prefixes and the top largest keys.
+//   - GET /schema-version reports the schema version of the database.
+//   - GET /verify verifies the integrity of the datastore.
+//   - POST /compact compacts the revisions up to a revision, or older
+//     than a retention.
+//   - POST /defrag defragments the database.
+//   - GET /status reports the status of the node and its cluster.
+//   - GET /watches lists the watches served by the node.
+//   - GET /connections lists the client connections of the node.
+//
+// The socket only accepts local connections from the owner of the process,
+// so it exposes no remote attack surface.
+func (s *Server) ControlHandler() http.Handler {
+	return controlHandler(s)
+}
+
+func (s *Server) membership(ctx context.Context) (membershipClient, error) {
+	client, err := s.app.Leader(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+func controlHandler(node controlNode) http.Handler {
+	// withLeader runs f with a client connected to the leader, which
+	// changes the membership.
+	withLeader := func(w http.ResponseWriter, r *http.Request, f func(membershipClient) (any, error)) {
+		client, err := node.membership(r.Context())
+		if err != nil {
+			writeControlError(w, http.StatusServiceUnavailable, fmt.Errorf("failed to connect to the leader: %w", err))
+			return
+		}
+		defer client.Close()
+
+		result, err := f(client)
+		if err != nil {
+			writeControlError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeControlResult(w, result)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /members", func(w http.ResponseWriter, r *http.Request) {
+		withLeader(w, r, func(c membershipClient) (any, error) {
+			nodes, err := c.Cluster(r.Context())
+			if err != nil {
+				return nil, err
+			}
+			members := make([]Member, 0, len(nodes))
+			for _, node := range nodes {
+				members = append(members, Member{ID: node.ID, Address: node.Address, Role: node.Role.String()})
+			}
+			return members, nil
+		})
+	})
+	mux.HandleFunc("POST /members", func(w http.ResponseWriter, r *http.Request) {
+		var member Member
+		if err := json.NewDecoder(r.Body).Decode(&member); err != nil {
+			writeControlError(w, http.StatusBadRequest, fmt.Errorf("invalid member: %w", err))
+			return
+		}
+		role, err := ParseRole(member.Role)
+		if err != nil {
+			writeControlError(w, http.StatusBadRequest, err)
+			return
+		}
+		if member.ID == 0 || member.Address == "" {
+			writeControlError(w, http.StatusBadRequest, fmt.Errorf("the ID and address of the member are required"))
+			return
+		}
+		withLeader(w, r, func(c membershipClient) (any, error) {
+			logger.WithField("id", member.ID).WithField("address", member.Address).Print("Add member")
+			if err := c.Add(r.Context(), client.NodeInfo{ID: member.ID, Address: member.Address}); err != nil {
+				return nil, err
+			}
+			// The members are added as spares, and then assigned
+			// their role.
+			if role != client.Spare {
+				return nil, c.Assign(r.Context(), member.ID, role)
+			}
+			return nil, nil
+		})
+	})
+	mux.HandleFunc("DELETE /members/{id}", func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+		if err != nil {
+			writeControlError(w, http.StatusBadRequest, fmt.Errorf("invalid member ID: %w", err))
+			return
+		}
+		withLeader(w, r, func(c membershipClient) (any, error) {
+			logger.WithField("id", id).Print("Remove member")
+			return nil, c.Remove(r.Context(), id)
+		})
+	})
+	mux.HandleFunc("PUT /members/{id}/role", func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+		if err != nil {
+			writeControlError(w, http.StatusBadRequest, fmt.Errorf("invalid member ID: %w", err))
+			return
+		}
+		var body struct {
+			Role string `json:"role"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeControlError(w, http.StatusBadRequest, fmt.Errorf("invalid role: %w", err))
+			return
+		}
+		role, err := ParseRole(body.Role)
+		if err != nil {
+			writeControlError(w, http.StatusBadRequest, err)
+			return
+		}
+		withLeader(w, r, func(c membershipClient) (any, error) {
+			logger.WithField("id", id).WithField("role", role).Print("Assign member role")
+			return nil, c.Assign(r.Context(), id, role)
+		})
+	})
+	mux.HandleFunc("POST /handover", func(w http.ResponseWriter, r *http.Request) {
+		leader, err := node.transferLeadership(r.Context())
+		if err != nil {
+			writeControlError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeControlResult(w, HandoverResult{Leader: leader})
+	})
+	mux.HandleFunc("POST /backup", func(w http.ResponseWriter, r *http.Request) {
+		// Errors while streaming the archive truncate it, which the
+		// client notices when verifying it.
+		w.Header().Set("Content-Type", "application/gzip")
+		if _, err := node.Backup(r.Context(), w); err != nil {
+			logger.WithError(err).Error("Failed to back up datastore")
+			writeControlError(w, http.StatusInternalServerError, err)
+		}
+	})
+	mux.HandleFunc("GET /history", func(w http.ResponseWriter, r *http.Request) {
+		key := r.URL.Query().Get("key")
+		if key == "" {
+			writeControlError(w, http.StatusBadRequest, fmt.Errorf("the key is required"))
+			return
+		}
+		history, err := node.History(r.Context(), key)
+		if err != nil {
+			writeControlError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeControlResult(w, history)
+	})
+	mux.HandleFunc("GET /keys", func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		opts := ListKeysOptions{
+			Prefix: query.Get("prefix"),
+			Start:  query.Get("start"),
+			Values: query.Get("values") == "true",
+		}
+		for name, value := range map[string]*int64{"limit": &opts.Limit, "revision": &opts.Revision} {
+			if query.Has(name) {
+				v, err := strconv.ParseInt(query.Get(name), 10, 64)
+				if err != nil {
+					writeControlError(w, http.StatusBadRequest, fmt.Errorf("invalid %s: %w", name, err))
+					return
+				}
+				*value = v
+			}
+		}
+		if opts.Prefix == "" {
+			writeControlError(w, http.StatusBadRequest, fmt.Errorf("the prefix is required"))
+			return
+		}
+		list, err := node.ListKeys(r.Context(), opts)
+		if err != nil {
+			writeControlError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeControlResult(w, list)
+	})
+	mux.HandleFunc("GET /analyze", func(w http.ResponseWriter, r *http.Request) {
+		var top int
+		if value := r.URL.Query().Get("top"); value != "" {
+			var err error
+			if top, err = strconv.Atoi(value); err != nil {
+				writeControlError(w, http.StatusBadRequest, fmt.Errorf("invalid top: %w", err))
+				return
+			}
+		}
+		analysis, err := node.Analyze(r.Context(), top)
+		if err != nil {
+			writeControlError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeControlResult(w, analysis)
+	})
+	mux.HandleFunc("GET /schema-version", func(w http.ResponseWriter, r *http.Request) {
+		version, err := node.SchemaVersion(r.Context())
+		if err != nil {
+			writeControlError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeControlResult(w, SchemaVersionResult{Version: version})
+	})
+	mux.HandleFunc("GET /verify", func(w http.ResponseWriter, r *http.Request) {
+		verification, err := node.Verify(r.Context())
+		if err != nil {
+			writeControlError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeControlResult(w, verification)
+	})
+	mux.HandleFunc("POST /compact", func(w http.ResponseWriter, r *http.Request) {
+		var opts CompactOptions
+		if err := json.NewDecoder(r.Body).Decode(&opts); err != nil {
+			writeControlError(w, http.StatusBadRequest, fmt.Errorf("invalid compaction: %w", err))
+			return
+		}
+		if (opts.Revision > 0) == (opts.Keep > 0) {
+			writeControlError(w, http.StatusBadRequest, fmt.Errorf("either a revision or a retention is required"))
+			return
+		}
+		result, err := node.Compact(r.Context(), opts)
+		if err != nil {
+			writeControlError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeControlResult(w, result)
+	})
+	mux.HandleFunc("POST /defrag", func(w http.ResponseWriter, r *http.Request) {
+		result, err := node.Defrag(r.Context())
+		if err != nil {
+			writeControlError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeControlResult(w, result)
+	})
+	mux.HandleFunc("GET /status", func(w http.ResponseWriter, r *http.Request) {
+		status, err := node.Status(r.Context())
+		if err != nil {
+			writeControlError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeControlResult(w, status)
+	})
+	mux.HandleFunc("GET /watches", func(w http.ResponseWriter, r *http.Request) {
+		writeControlResult(w, node.Watches())
+	})
+	mux.HandleFunc("GET /connections", func(w http.ResponseWriter, r *http.Request) {
+		writeControlResult(w, node.Connections())
+	})
+	return mux
+}
+
+// HandoverResult is the result of a leadership handover.
+type HandoverResult struct {
+	// Leader is the ID of the leader after the handover.
+	Leader uint64 `json:"leader"`
+}
+
+// SchemaVersionResult is the schema version of the database.
+type SchemaVersionResult struct {
+	Version sqlite.SchemaVersion `json:"version"`
+}
+
+type controlError struct {
+	Error string `json:"error"`
+}
+
+func writeControlError(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(controlError{Error: err.Error()})
+}
+
+func writeControlResult(w http.ResponseWriter, result any) {
+	if result == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		logger.WithError(err).Warning("Failed to write control response")
+	}
+}
+
+// ControlClient calls the control API of a running node through its
+// control socket.
+type ControlClient struct {
+	client *http.Client
+}
+
+// NewControlClient returns a client of the control socket at path.
+func NewControlClient(path string) *ControlClient {
+	return &ControlClient{
+		client: &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var d net.Dialer
+					return d.DialContext(ctx, "unix", path)
+				},
+			},
+		},
+	}
+}
+
+// Members lists the members of the cluster.
+func (c *ControlClient) Members(ctx context.Context) ([]Member, error) {
+	var members []Member
+	if err := c.do(ctx, http.MethodGet, "/members", nil, &members); err != nil {
+		return nil, err
+	}
+	return members, nil
+}
+
+// AddMember adds a member to the cluster.
+func (c *ControlClient) AddMember(ctx context.Context, member Member) error {
+	return c.do(ctx, http.MethodPost, "/members", member, nil)
+}
+
+// RemoveMember removes a member from the cluster.
+func (c *ControlClient) RemoveMember(ctx context.Context, id uint64) error {
+	return c.do(ctx, http.MethodDelete, fmt.Sprintf("/members/%d", id), nil, nil)
+}
+
+// AssignRole assigns the role of a member: voter, standby or spare.
+func (c *ControlClient) AssignRole(ctx context.Context, id uint64, role string) error {
+	return c.do(ctx, http.MethodPut, fmt.Sprintf("/members/%d/role", id), map[string]string{"role": role}, nil)
+}
+
+// Handover transfers the leadership of the node to another voter, if the
+// node is the leader, and returns the ID of the leader.
+func (c *ControlClient) Handover(ctx context.Context) (uint64, error) {
+	var result HandoverResult
+	if err := c.do(ctx, http.MethodPost, "/handover", nil, &result); err != nil {
+		return 0, err
+	}
+	return result.Leader, nil
+}
+
+// Backup writes a backup archive of the datastore to w. The archive must be
+// verified, as it is truncated if the backup fails midway.
+func (c *ControlClient) Backup(ctx context.Context, w io.Writer) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "http://k8s-dqlite/backup", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to connect to the control socket: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return controlResponseError(resp)
+	}
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// History lists the revisions of key retained in the datastore.
+func (c *ControlClient) History(ctx context.Context, key string) (KeyHistory, error) {
+	var history KeyHistory
+	if err := c.do(ctx, http.MethodGet, "/history?"+url.Values{"key": {key}}.Encode(), nil, &history); err != nil {
+		return KeyHistory{}, err
+	}
+	return history, nil
+}
+
+// ListKeys lists a page of the keys with a prefix.
+func (c *ControlClient) ListKeys(ctx context.Context, opts ListKeysOptions) (KeyList, error) {
+	query := url.Values{"prefix": {opts.Prefix}}
+	if opts.Start != "" {
+		query.Set("start", opts.Start)
+	}
+	if opts.Limit > 0 {
+		query.Set("limit", strconv.FormatInt(opts.Limit, 10))
+	}
+	if opts.Revision > 0 {
+		query.Set("revision", strconv.FormatInt(opts.Revision, 10))
+	}
+	if opts.Values {
+		query.Set("values", "true")
+	}
+	var list KeyList
+	if err := c.do(ctx, http.MethodGet, "/keys?"+query.Encode(), nil, &list); err != nil {
+		return KeyList{}, err
+	}
+	return list, nil
+}
+
+// Analyze reports the statistics of the top-level prefixes and the top
+// largest keys.
+func (c *ControlClient) Analyze(ctx context.Context, top int) (Analysis, error) {
+	var analysis Analysis
+	if err := c.do(ctx, http.MethodGet, fmt.Sprintf("/analyze?top=%d", top), nil, &analysis); err != nil {
+		return Analysis{}, err
+	}
+	return analysis, nil
+}
+
+// SchemaVersion returns the schema version of the database.
+func (c *ControlClient) SchemaVersion(ctx context.Context) (sqlite.SchemaVersion, error) {
+	var result SchemaVersionResult
+	if err := c.do(ctx, http.MethodGet, "/schema-version", nil, &result); err != nil {
+		return 0, err
+	}
+	return result.Version, nil
+}
+
+// Verify verifies the integrity of the datastore, and returns the
+// corruptions found.
+func (c *ControlClient) Verify(ctx context.Context) (Verification, error) {
+	var verification Verification
+	if err := c.do(ctx, http.MethodGet, "/verify", nil, &verification); err != nil {
+		return Verification{}, err
+	}
+	return verification, nil
+}
+
+// Compact compacts the revisions up to opts.Revision, or older than
+// opts.Keep, and returns the compact revision.
+func (c *ControlClient) Compact(ctx context.Context, opts CompactOptions) (CompactResult, error) {
+	var result CompactResult
+	if err := c.do(ctx, http.MethodPost, "/compact", opts, &result); err != nil {
+		return CompactResult{}, err
+	}
+	return result, nil
+}
+
+// Defrag defragments the database, and returns its size before and after.
+func (c *ControlClient) Defrag(ctx context.Context) (DefragResult, error) {
+	var result DefragResult
+	if err := c.do(ctx, http.MethodPost, "/defrag", nil, &result); err != nil {
+		return DefragResult{}, err
+	}
+	return result, nil
+}
+
+// Status reports the status of the node and its cluster.
+func (c *ControlClient) Status(ctx context.Context) (Status, error) {
+	var status Status
+	if err := c.do(ctx, http.MethodGet, "/status", nil, &status); err != nil {
+		return Status{}, err
+	}
+	return status, nil
+}
+
+// Watches lists the watches served by the node.
+func (c *ControlClient) Watches(ctx context.Context) ([]Watch, error) {
+	var watches []Watch
+	if err := c.do(ctx, http.MethodGet, "/watches", nil, &watches); err != nil {
+		return nil, err
+	}
+	return watches, nil
+}
+
+// Connections lists the client connections of the node.
+func (c *ControlClient) Connections(ctx context.Context) ([]Connection, error) {
+	var connections []Connection
+	if err := c.do(ctx, http.MethodGet, "/connections", nil, &connections); err != nil {
+		return nil, err
+	}
+	return connections, nil
+}
+
+// do sends a request with the JSON encoding of body, if not nil, and
+// decodes the response into result, if not nil.
+func (c *ControlClient) do(ctx context.Context, method, path string, body, result any) error {
+	var reader io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(b)
+	}
+	// The host is ignored, as the requests are sent to the socket.
+	req, err := http.NewRequestWithContext(ctx, method, "http://k8s-dqlite"+path, reader)
+	if err != nil {
+		return err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to connect to the control socket: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return controlResponseError(resp)
+	}
+	if result != nil {
+		return json.NewDecoder(resp.Body).Decode(result)
+	}
+	return nil
+}
+
+// controlResponseError returns the error of a failed control request.
+func controlResponseError(resp *http.Response) error {
+	var e controlError
+	if err := json.NewDecoder(resp.Body).Decode(&e); err != nil || e.Error == "" {
+		return fmt.Errorf("control request failed with %s", resp.Status)
+	}
+	return errors.New(strings.TrimSpace(e.Error))
+}
@@ -0,0 +1,302 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/canonical/go-dqlite/client"
+	"github.com/canonical/k8s-dqlite/pkg/kine/drivers/sqlite"
+	"github.com/canonical/k8s-dqlite/pkg/snapshot"
+)
+
+// fakeMembership is a membership client changing an in-memory cluster.
+type fakeMembership struct {
+	nodes map[uint64]client.NodeInfo
+}
+
+func (f *fakeMembership) Cluster(context.Context) ([]client.NodeInfo, error) {
+	nodes := make([]client.NodeInfo, 0, len(f.nodes))
+	for _, node := range f.nodes {
+		nodes = append(nodes, node)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].ID < nodes[j].ID })
+	return nodes, nil
+}
+
+func (f *fakeMembership) Add(_ context.Context, node client.NodeInfo) error {
+	if _, ok := f.nodes[node.ID]; ok {
+		return fmt.Errorf("node %d already exists", node.ID)
+	}
+	node.Role = client.Spare
+	f.nodes[node.ID] = node
+	return nil
+}
+
+func (f *fakeMembership) Assign(_ context.Context, id uint64, role client.NodeRole) error {
+	node, ok := f.nodes[id]
+	if !ok {
+		return fmt.Errorf("node %d not found", id)
+	}
+	node.Role = role
+	f.nodes[id] = node
+	return nil
+}
+
+func (f *fakeMembership) Remove(_ context.Context, id uint64) error {
+	if _, ok := f.nodes[id]; !ok {
+		return fmt.Errorf("node %d not found", id)
+	}
+	delete(f.nodes, id)
+	return nil
+}
+
+func (f *fakeMembership) Close() error { return nil }
+
+func (f *fakeMembership) membership(context.Context) (membershipClient, error) { return f, nil }
+
+// transferLeadership transfers the leadership from node 1 to the next
+// voter.
+func (f *fakeMembership) transferLeadership(context.Context) (uint64, error) {
+	nodes, _ := f.Cluster(context.Background())
+	for _, node := range nodes {
+		if node.ID != 1 && node.Role == client.Voter {
+			return node.ID, nil
+		}
+	}
+	return 0, fmt.Errorf("no other voter to transfer the leadership to")
+}
+
+// Backup writes a fake archive.
+func (f *fakeMembership) Backup(_ context.Context, w io.Writer) (snapshot.ArchiveMetadata, error) {
+	_, err := io.WriteString(w, "archive")
+	return snapshot.ArchiveMetadata{}, err
+}
+
+// History returns a fake history of key.
+func (f *fakeMembership) History(_ context.Context, key string) (KeyHistory, error) {
+	return KeyHistory{
+		Key:             key,
+		CompactRevision: 1,
+		Revisions: []KeyRevision{
+			{Revision: 2, Type: "create", CreateRevision: 2, Value: []byte("a")},
+			{Revision: 3, Type: "delete", CreateRevision: 2, PrevRevision: 2},
+		},
+	}, nil
+}
+
+// ListKeys lists the keys a, b and c under the prefix, at revision 5.
+func (f *fakeMembership) ListKeys(_ context.Context, opts ListKeysOptions) (KeyList, error) {
+	list := KeyList{Revision: 5}
+	for _, name := range []string{"a", "b", "c"} {
+		key := opts.Prefix + name
+		if key < opts.Start {
+			continue
+		}
+		if int64(len(list.Keys)) == opts.Limit {
+			list.Next = key
+			break
+		}
+		kv := KeyValue{Key: key, ModRevision: 5}
+		if opts.Values {
+			kv.Value = []byte(name)
+		}
+		list.Keys = append(list.Keys, kv)
+	}
+	return list, nil
+}
+
+// Analyze reports a single prefix and the top largest keys.
+func (f *fakeMembership) Analyze(_ context.Context, top int) (Analysis, error) {
+	analysis := Analysis{Prefixes: []PrefixStats{{Prefix: "/registry/pods/", Keys: 3, Rows: 5, Bytes: 60}}}
+	for i := 0; i < top && i < 3; i++ {
+		analysis.Largest = append(analysis.Largest, KeySize{Key: fmt.Sprintf("/registry/pods/default/%d", i), Size: int64(30 - 10*i), Revisions: 1})
+	}
+	return analysis, nil
+}
+
+func (f *fakeMembership) SchemaVersion(context.Context) (sqlite.SchemaVersion, error) {
+	return sqlite.NewSchemaVersion(0, 4), nil
+}
+
+func (f *fakeMembership) Verify(context.Context) (Verification, error) {
+	return Verification{Violations: []Violation{{Revision: 3, Key: "/registry/a", Problem: "follows a revision of another key"}}}, nil
+}
+
+func (f *fakeMembership) Compact(_ context.Context, opts CompactOptions) (CompactResult, error) {
+	return CompactResult{Revision: opts.Revision}, nil
+}
+
+func (f *fakeMembership) Defrag(context.Context) (DefragResult, error) {
+	return DefragResult{Before: 2048, After: 1024}, nil
+}
+
+func (f *fakeMembership) Status(context.Context) (Status, error) {
+	nodes, _ := f.Cluster(context.Background())
+	status := Status{ID: 1, Address: f.nodes[1].Address, Role: f.nodes[1].Role.String(), Revision: 42, Watches: 1, Connections: 1}
+	for _, node := range nodes {
+		status.Members = append(status.Members, Member{ID: node.ID, Address: node.Address, Role: node.Role.String()})
+	}
+	return status, nil
+}
+
+func (f *fakeMembership) Watches() []Watch {
+	return []Watch{{ID: 1, Key: "/registry/pods/", StartRevision: 40, Peer: "10.0.0.5:40000"}}
+}
+
+func (f *fakeMembership) Connections() []Connection {
+	return []Connection{{Remote: "10.0.0.5:40000", Requests: 3}}
+}
+
+func TestControlMembers(t *testing.T) {
+	ctx := context.Background()
+	membership := &fakeMembership{nodes: map[uint64]client.NodeInfo{
+		1: {ID: 1, Address: "10.0.0.1:9000", Role: client.Voter},
+	}}
+
+	path := filepath.Join(t.TempDir(), "control.sock")
+	listener, err := ListenControl(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{Handler: controlHandler(membership)}
+	go server.Serve(listener)
+	defer server.Close()
+
+	c := NewControlClient(path)
+	if _, err := c.Handover(ctx); err == nil {
+		t.Fatal("expected the handover to fail without another voter")
+	}
+	if err := c.AddMember(ctx, Member{ID: 2, Address: "10.0.0.2:9000", Role: "standby"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.AddMember(ctx, Member{ID: 3, Address: "10.0.0.3:9000", Role: "spare"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.AddMember(ctx, Member{ID: 4, Address: "10.0.0.4:9000", Role: "witness"}); err == nil {
+		t.Fatal("expected an unknown role to be refused")
+	}
+	if err := c.AssignRole(ctx, 3, "voter"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.RemoveMember(ctx, 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.RemoveMember(ctx, 2); err == nil {
+		t.Fatal("expected removing a missing member to fail")
+	}
+
+	members, err := c.Members(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Member{
+		{ID: 1, Address: "10.0.0.1:9000", Role: "voter"},
+		{ID: 3, Address: "10.0.0.3:9000", Role: "voter"},
+	}
+	if !reflect.DeepEqual(members, expected) {
+		t.Fatalf("expected members %v, got %v", expected, members)
+	}
+
+	if leader, err := c.Handover(ctx); err != nil || leader != 3 {
+		t.Fatalf("expected the leadership to be transferred to 3, got %d (%v)", leader, err)
+	}
+
+	var archive bytes.Buffer
+	if err := c.Backup(ctx, &archive); err != nil {
+		t.Fatal(err)
+	}
+	if archive.String() != "archive" {
+		t.Fatalf("unexpected backup archive %q", archive.String())
+	}
+
+	history, err := c.History(ctx, "/registry/pods/default/a b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if history.Key != "/registry/pods/default/a b" || len(history.Revisions) != 2 || string(history.Revisions[0].Value) != "a" {
+		t.Fatalf("unexpected key history %+v", history)
+	}
+
+	list, err := c.ListKeys(ctx, ListKeysOptions{Prefix: "/registry/", Start: "/registry/b", Limit: 1, Values: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Keys) != 1 || list.Keys[0].Key != "/registry/b" || string(list.Keys[0].Value) != "b" || list.Next != "/registry/c" {
+		t.Fatalf("unexpected key list %+v", list)
+	}
+	if _, err := c.ListKeys(ctx, ListKeysOptions{}); err == nil {
+		t.Fatal("expected listing keys without a prefix to fail")
+	}
+
+	analysis, err := c.Analyze(ctx, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(analysis.Prefixes) != 1 || len(analysis.Largest) != 2 || analysis.Largest[0].Size != 30 {
+		t.Fatalf("unexpected analysis %+v", analysis)
+	}
+
+	version, err := c.SchemaVersion(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != sqlite.NewSchemaVersion(0, 4) {
+		t.Fatalf("unexpected schema version %v", version)
+	}
+
+	verification, err := c.Verify(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(verification.Violations) != 1 || verification.Violations[0].Revision != 3 {
+		t.Fatalf("unexpected verification %+v", verification)
+	}
+
+	compacted, err := c.Compact(ctx, CompactOptions{Revision: 42})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if compacted.Revision != 42 {
+		t.Fatalf("unexpected compaction %+v", compacted)
+	}
+	if _, err := c.Compact(ctx, CompactOptions{Revision: 42, Keep: time.Hour}); err == nil {
+		t.Fatal("expected a compaction with both a revision and a retention to fail")
+	}
+	defrag, err := c.Defrag(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if defrag.After != 1024 {
+		t.Fatalf("unexpected defragmentation %+v", defrag)
+	}
+
+	status, err := c.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.ID != 1 || status.Role != "voter" || len(status.Members) != 2 || status.Revision != 42 {
+		t.Fatalf("unexpected status %+v", status)
+	}
+	watches, err := c.Watches(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(watches) != 1 || watches[0].Key != "/registry/pods/" || watches[0].StartRevision != 40 {
+		t.Fatalf("unexpected watches %+v", watches)
+	}
+	connections, err := c.Connections(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(connections) != 1 || connections[0].Requests != 3 {
+		t.Fatalf("unexpected connections %+v", connections)
+	}
+}
@@ -0,0 +1,428 @@
+package server
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/canonical/go-dqlite/client"
+	"github.com/canonical/k8s-dqlite/pkg/kine/drivers/sqlite"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// doctorFsyncSamples is the number of synced writes measuring the
+	// fsync latency of the storage, and doctorFsyncWarning the p99 latency
+	// above which the storage is too slow for the raft log.
+	doctorFsyncSamples = 50
+	doctorFsyncWarning = 10 * time.Millisecond
+	// doctorMinOpenFiles is the open file limit below which the node may
+	// run out of file descriptors, with many clients and watches.
+	doctorMinOpenFiles = 65536
+	// doctorCertificateWarning is how long before their expiry the
+	// certificates are reported.
+	doctorCertificateWarning = 30 * 24 * time.Hour
+	// doctorDialTimeout bounds the connections to the peers.
+	doctorDialTimeout = 3 * time.Second
+	// doctorClockSkewWarning is the clock offset above which the clock is
+	// reported as skewed.
+	doctorClockSkewWarning = time.Second
+	// doctorNTPTimeout bounds the query of the NTP server.
+	doctorNTPTimeout = 5 * time.Second
+)
+
+// DoctorStatus is the outcome of a preflight check.
+type DoctorStatus string
+
+const (
+	DoctorOK      DoctorStatus = "ok"
+	DoctorWarning DoctorStatus = "warning"
+	DoctorError   DoctorStatus = "error"
+	// DoctorSkipped is the status of the checks which don't apply, or
+	// can't be run.
+	DoctorSkipped DoctorStatus = "skipped"
+)
+
+// DoctorCheck is the result of a preflight check, with the action to take
+// in its message, unless it passed.
+type DoctorCheck struct {
+	Name    string       `json:"name"`
+	Status  DoctorStatus `json:"status"`
+	Message string       `json:"message"`
+}
+
+// DoctorConfig configures the preflight checks of a node.
+type DoctorConfig struct {
+	// Dir is the storage directory of the node.
+	Dir string
+	// CertFiles are the certificates checked besides cluster.crt in Dir,
+	// such as the certificate of the kine endpoint.
+	CertFiles []string
+	// JoinToken is the token the node joins the cluster with, if any, whose
+	// join endpoint is checked with the peers.
+	JoinToken string
+	// Control is the client of the node running on this host, if any,
+	// which reports the schema version of the database.
+	Control *ControlClient
+	// NTPServer is the server the clock is compared with. If empty, the
+	// clock skew isn't checked.
+	NTPServer string
+}
+
+// Doctor runs the preflight checks of the node in config.Dir, to run before
+// starting the node or joining it to a cluster: the permissions of the
+// storage directory, the fsync latency of its storage, the open file limit,
+// the expiry of the certificates, the schema version of the database, the
+// reachability of the peers and the clock skew.
+func Doctor(ctx context.Context, config DoctorConfig) []DoctorCheck {
+	storage := checkStorageDir(config.Dir)
+	checks := []DoctorCheck{storage}
+	if storage.Status == DoctorError {
+		checks = append(checks, DoctorCheck{Name: "fsync", Status: DoctorSkipped, Message: "the storage directory is not usable"})
+	} else {
+		checks = append(checks, checkFsync(config.Dir, doctorFsyncSamples))
+	}
+	checks = append(checks, checkOpenFiles())
+
+	certFiles := config.CertFiles
+	if exists, _ := fileExists(config.Dir, "cluster.crt"); exists {
+		certFiles = append([]string{filepath.Join(config.Dir, "cluster.crt")}, certFiles...)
+	}
+	if len(certFiles) == 0 {
+		checks = append(checks, DoctorCheck{Name: "certificate", Status: DoctorSkipped, Message: "no certificate found"})
+	}
+	for _, path := range certFiles {
+		checks = append(checks, checkCertificate(path, time.Now()))
+	}
+
+	checks = append(checks, checkSchemaVersion(ctx, config.Control))
+	checks = append(checks, checkPeers(ctx, config.Dir, config.JoinToken)...)
+	checks = append(checks, checkClock(ctx, config.NTPServer))
+	return checks
+}
+
+// checkStorageDir checks that the storage directory is a directory
+// writable by the node, and not by the other users, as it holds the
+// private key of the cluster.
+func checkStorageDir(dir string) DoctorCheck {
+	check := DoctorCheck{Name: "storage-dir", Status: DoctorError}
+	info, err := os.Stat(dir)
+	if os.IsNotExist(err) {
+		check.Message = fmt.Sprintf("%s does not exist, create it or set --storage-dir", dir)
+		return check
+	} else if err != nil {
+		check.Message = fmt.Sprintf("failed to check %s: %v", dir, err)
+		return check
+	}
+	if !info.IsDir() {
+		check.Message = fmt.Sprintf("%s is not a directory", dir)
+		return check
+	}
+	f, err := os.CreateTemp(dir, ".doctor-")
+	if err != nil {
+		check.Message = fmt.Sprintf("%s is not writable by uid %d, fix its ownership with chown: %v", dir, os.Geteuid(), err)
+		return check
+	}
+	f.Close()
+	os.Remove(f.Name())
+
+	if mode := info.Mode().Perm(); mode&0022 != 0 {
+		check.Status = DoctorWarning
+		check.Message = fmt.Sprintf("%s is writable by other users (mode %04o), restrict it with chmod 700", dir, mode)
+		return check
+	}
+	check.Status = DoctorOK
+	check.Message = fmt.Sprintf("%s is writable", dir)
+	return check
+}
+
+// checkFsync measures the latency of synced writes of 4KiB in dir, as the
+// appends to the raft log.
+func checkFsync(dir string, samples int) DoctorCheck {
+	check := DoctorCheck{Name: "fsync", Status: DoctorError}
+	latencies, err := fsyncLatencies(dir, samples)
+	if err != nil {
+		check.Message = fmt.Sprintf("failed to measure the fsync latency: %v", err)
+		return check
+	}
+	slices.Sort(latencies)
+	// The nearest-rank percentile.
+	p99 := latencies[(len(latencies)*99+99)/100-1]
+	check.Message = fmt.Sprintf("p99 fsync latency is %v over %d writes", p99.Round(time.Microsecond), len(latencies))
+	if p99 > doctorFsyncWarning {
+		check.Status = DoctorWarning
+		check.Message += fmt.Sprintf(", above %v: the storage is too slow for the raft log, use a local SSD", doctorFsyncWarning)
+		return check
+	}
+	check.Status = DoctorOK
+	return check
+}
+
+func fsyncLatencies(dir string, samples int) ([]time.Duration, error) {
+	f, err := os.CreateTemp(dir, ".doctor-fsync-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	buf := make([]byte, 4096)
+	latencies := make([]time.Duration, samples)
+	for i := range latencies {
+		start := time.Now()
+		if _, err := f.Write(buf); err != nil {
+			return nil, err
+		}
+		if err := f.Sync(); err != nil {
+			return nil, err
+		}
+		latencies[i] = time.Since(start)
+	}
+	return latencies, nil
+}
+
+// checkOpenFiles checks the limit of open files of the process, which the
+// node inherits when started from the same environment.
+func checkOpenFiles() DoctorCheck {
+	check := DoctorCheck{Name: "open-files", Status: DoctorError}
+	var limit unix.Rlimit
+	if err := unix.Getrlimit(unix.RLIMIT_NOFILE, &limit); err != nil {
+		check.Message = fmt.Sprintf("failed to get the open file limit: %v", err)
+		return check
+	}
+	check.Message = fmt.Sprintf("open file limit is %d", limit.Cur)
+	if limit.Cur < doctorMinOpenFiles {
+		check.Status = DoctorWarning
+		check.Message += fmt.Sprintf(", raise it to at least %d, with LimitNOFILE in the systemd unit or ulimit -n", doctorMinOpenFiles)
+		return check
+	}
+	check.Status = DoctorOK
+	return check
+}
+
+// checkCertificate checks the validity period of the certificate at path
+// against now.
+func checkCertificate(path string, now time.Time) DoctorCheck {
+	check := DoctorCheck{Name: "certificate", Status: DoctorError}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		check.Message = fmt.Sprintf("failed to read %s: %v", path, err)
+		return check
+	}
+	block, _ := pem.Decode(b)
+	if block == nil || block.Type != "CERTIFICATE" {
+		check.Message = fmt.Sprintf("no certificate found in %s", path)
+		return check
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		check.Message = fmt.Sprintf("failed to parse %s: %v", path, err)
+		return check
+	}
+
+	expiry := cert.NotAfter.UTC().Format(time.DateOnly)
+	switch left := cert.NotAfter.Sub(now); {
+	case now.Before(cert.NotBefore):
+		check.Message = fmt.Sprintf("%s is not valid before %s, check the clock of this host", path, cert.NotBefore.UTC().Format(time.RFC3339))
+	case left <= 0:
+		check.Message = fmt.Sprintf("%s expired on %s, renew it", path, expiry)
+	case left < doctorCertificateWarning:
+		check.Status = DoctorWarning
+		check.Message = fmt.Sprintf("%s expires on %s, in %d days, renew it", path, expiry, int(left.Hours()/24))
+	default:
+		check.Status = DoctorOK
+		check.Message = fmt.Sprintf("%s expires on %s", path, expiry)
+	}
+	return check
+}
+
+// checkSchemaVersion checks that the schema version of the database of the
+// running node can be migrated to the one of this version.
+func checkSchemaVersion(ctx context.Context, control *ControlClient) DoctorCheck {
+	check := DoctorCheck{Name: "schema-version", Status: DoctorSkipped}
+	if control == nil {
+		check.Message = "the node is not running, the schema version is read from the running node"
+		return check
+	}
+	version, err := control.SchemaVersion(ctx)
+	if err != nil {
+		check.Status = DoctorWarning
+		check.Message = fmt.Sprintf("failed to get the schema version from the node: %v", err)
+		return check
+	}
+	supported := sqlite.DatabaseSchemaVersion()
+	switch {
+	case version.CompatibleWith(supported) != nil:
+		check.Status = DoctorError
+		check.Message = fmt.Sprintf("database schema %v can't be migrated to schema %v of this version", version, supported)
+	case version > supported:
+		check.Status = DoctorWarning
+		check.Message = fmt.Sprintf("database schema %v is newer than schema %v of this version, which is a downgrade", version, supported)
+	case version < supported:
+		check.Status = DoctorOK
+		check.Message = fmt.Sprintf("database schema %v will be migrated to schema %v on start", version, supported)
+	default:
+		check.Status = DoctorOK
+		check.Message = fmt.Sprintf("database schema is %v", version)
+	}
+	return check
+}
+
+// checkPeers checks that the other nodes of the cluster accept connections,
+// as listed in cluster.yaml, or in init.yaml for the nodes not started yet,
+// along with the join endpoint of the join token.
+func checkPeers(ctx context.Context, dir, token string) []DoctorCheck {
+	var (
+		info      client.NodeInfo
+		cluster   []client.NodeInfo
+		init      InitConfiguration
+		addresses []string
+	)
+	if err := fileUnmarshal(&cluster, dir, "cluster.yaml"); err == nil {
+		fileUnmarshal(&info, dir, "info.yaml")
+		for _, node := range cluster {
+			if node.ID != info.ID {
+				addresses = append(addresses, node.Address)
+			}
+		}
+	} else if err := fileUnmarshal(&init, dir, "init.yaml"); err == nil {
+		for _, address := range init.Cluster {
+			if address != init.Address {
+				addresses = append(addresses, address)
+			}
+		}
+	}
+	if token != "" {
+		t, err := parseJoinToken(token)
+		if err != nil {
+			return []DoctorCheck{{Name: "peer", Status: DoctorError, Message: err.Error()}}
+		}
+		addresses = append(addresses, t.Address)
+	}
+	if len(addresses) == 0 {
+		return []DoctorCheck{{Name: "peer", Status: DoctorSkipped, Message: "no peer, the node is not part of a cluster"}}
+	}
+
+	checks := make([]DoctorCheck, 0, len(addresses))
+	for _, address := range addresses {
+		checks = append(checks, checkPeer(ctx, address))
+	}
+	return checks
+}
+
+func checkPeer(ctx context.Context, address string) DoctorCheck {
+	dialer := net.Dialer{Timeout: doctorDialTimeout}
+	start := time.Now()
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return DoctorCheck{
+			Name:    "peer",
+			Status:  DoctorWarning,
+			Message: fmt.Sprintf("%s is unreachable, check that it runs and that the firewalls allow its port: %v", address, err),
+		}
+	}
+	conn.Close()
+	return DoctorCheck{
+		Name:    "peer",
+		Status:  DoctorOK,
+		Message: fmt.Sprintf("%s is reachable in %v", address, time.Since(start).Round(time.Microsecond)),
+	}
+}
+
+// checkClock checks the offset of the clock against an NTP server, as the
+// validity of the certificates and the timestamps of the logs and backups
+// depend on it.
+func checkClock(ctx context.Context, server string) DoctorCheck {
+	check := DoctorCheck{Name: "clock", Status: DoctorSkipped}
+	if server == "" {
+		check.Message = "no NTP server configured"
+		return check
+	}
+	offset, err := ntpOffset(ctx, server)
+	if err != nil {
+		check.Message = fmt.Sprintf("failed to query NTP server %s: %v", server, err)
+		return check
+	}
+	check.Message = fmt.Sprintf("clock offset from %s is %v", server, offset.Round(time.Millisecond))
+	if offset.Abs() > doctorClockSkewWarning {
+		check.Status = DoctorWarning
+		check.Message += ", synchronize the clock with NTP"
+		return check
+	}
+	check.Status = DoctorOK
+	return check
+}
+
+// ntpEpochOffset is the number of seconds from the NTP epoch, 1900, to the
+// Unix epoch.
+const ntpEpochOffset = 2208988800
+
+// ntpOffset returns the offset of the local clock from the clock of an NTP
+// server, as host or host:port, with a single SNTP query.
+func ntpOffset(ctx context.Context, server string) (time.Duration, error) {
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "123")
+	}
+	ctx, cancel := context.WithTimeout(ctx, doctorNTPTimeout)
+	defer cancel()
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "udp", server)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+
+	// A version 4 client request.
+	request := make([]byte, 48)
+	request[0] = 4<<3 | 3
+	sent := time.Now()
+	if _, err := conn.Write(request); err != nil {
+		return 0, err
+	}
+	response := make([]byte, 48)
+	n, err := conn.Read(response)
+	if err != nil {
+		return 0, err
+	}
+	received := time.Now()
+	if n < 48 || response[0]&7 != 4 {
+		return 0, fmt.Errorf("invalid NTP response")
+	}
+	if response[1] == 0 {
+		return 0, fmt.Errorf("NTP server refused the request")
+	}
+
+	serverReceived, serverSent := ntpTime(response[32:40]), ntpTime(response[40:48])
+	return (serverReceived.Sub(sent) + serverSent.Sub(received)) / 2, nil
+}
+
+// ntpTime decodes an NTP timestamp: the seconds since the NTP epoch and
+// their fraction, as 32-bit fixed point.
+func ntpTime(b []byte) time.Time {
+	seconds := int64(binary.BigEndian.Uint32(b[:4])) - ntpEpochOffset
+	fraction := int64(binary.BigEndian.Uint32(b[4:8]))
+	return time.Unix(seconds, fraction*int64(time.Second)>>32)
+}
+
+// SchemaVersion returns the schema version of the database.
+func (s *Server) SchemaVersion(ctx context.Context) (sqlite.SchemaVersion, error) {
+	db, err := s.app.Open(ctx, "k8s")
+	if err != nil {
+		return 0, fmt.Errorf("failed to open database: %w", err)
+	}
+	defer db.Close()
+	var version sqlite.SchemaVersion
+	if err := db.QueryRowContext(ctx, "PRAGMA user_version").Scan(&version); err != nil {
+		return 0, fmt.Errorf("failed to read database schema version: %w", err)
+	}
+	return version, nil
+}
@@ -0,0 +1,132 @@
+package server
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/canonical/go-dqlite/client"
+)
+
+func TestCheckStorageDir(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Chmod(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if check := checkStorageDir(dir); check.Status != DoctorOK {
+		t.Fatalf("unexpected check %+v", check)
+	}
+	if err := os.Chmod(dir, 0777); err != nil {
+		t.Fatal(err)
+	}
+	if check := checkStorageDir(dir); check.Status != DoctorWarning {
+		t.Fatalf("expected a world-writable storage directory to be reported, got %+v", check)
+	}
+	if check := checkStorageDir(filepath.Join(dir, "missing")); check.Status != DoctorError {
+		t.Fatalf("expected a missing storage directory to fail, got %+v", check)
+	}
+
+	if check := checkFsync(dir, 3); check.Status == DoctorError {
+		t.Fatalf("unexpected check %+v", check)
+	}
+	if entries, err := os.ReadDir(dir); err != nil || len(entries) != 0 {
+		t.Fatalf("expected the checks to clean up, found %v (%v)", entries, err)
+	}
+}
+
+func TestCheckCertificate(t *testing.T) {
+	dir := t.TempDir()
+	writeClusterCertificate(t, dir, 1)
+	path := filepath.Join(dir, "cluster.crt")
+
+	now := time.Now()
+	for _, tc := range []struct {
+		now    time.Time
+		status DoctorStatus
+	}{
+		// The certificate is valid for an hour around now.
+		{now: now, status: DoctorWarning},
+		{now: now.Add(2 * time.Hour), status: DoctorError},
+		{now: now.Add(-2 * time.Hour), status: DoctorError},
+	} {
+		if check := checkCertificate(path, tc.now); check.Status != tc.status {
+			t.Fatalf("expected %v at %v, got %+v", tc.status, tc.now, check)
+		}
+	}
+	if check := checkCertificate(filepath.Join(dir, "cluster.key"), now); check.Status != DoctorError {
+		t.Fatalf("expected a key not to be accepted as a certificate, got %+v", check)
+	}
+}
+
+func TestCheckPeers(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	if checks := checkPeers(ctx, dir, ""); len(checks) != 1 || checks[0].Status != DoctorSkipped {
+		t.Fatalf("unexpected checks %+v", checks)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed.Close()
+
+	info := client.NodeInfo{ID: 1, Address: "127.0.0.1:1"}
+	cluster := []client.NodeInfo{info, {ID: 2, Address: listener.Addr().String()}, {ID: 3, Address: closed.Addr().String()}}
+	if err := fileMarshal(info, dir, "info.yaml"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fileMarshal(cluster, dir, "cluster.yaml"); err != nil {
+		t.Fatal(err)
+	}
+	checks := checkPeers(ctx, dir, "")
+	if len(checks) != 2 || checks[0].Status != DoctorOK || checks[1].Status != DoctorWarning {
+		t.Fatalf("unexpected checks %+v", checks)
+	}
+}
+
+func TestNTPOffset(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// The server clock is ahead by a minute.
+	skew := time.Minute
+	go func() {
+		request := make([]byte, 48)
+		_, addr, err := conn.ReadFrom(request)
+		if err != nil {
+			return
+		}
+		response := make([]byte, 48)
+		response[0] = 4<<3 | 4
+		response[1] = 2
+		now := time.Now().Add(skew)
+		seconds := uint32(now.Unix() + ntpEpochOffset)
+		fraction := uint32((int64(now.Nanosecond()) << 32) / int64(time.Second))
+		for _, offset := range []int{32, 40} {
+			binary.BigEndian.PutUint32(response[offset:], seconds)
+			binary.BigEndian.PutUint32(response[offset+4:], fraction)
+		}
+		conn.WriteTo(response, addr)
+	}()
+
+	offset, err := ntpOffset(context.Background(), conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if (offset - skew).Abs() > 100*time.Millisecond {
+		t.Fatalf("expected an offset of %v, got %v", skew, offset)
+	}
+}
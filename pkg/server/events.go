@@ -0,0 +1,42 @@
+package server
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// eventsPrefix is the prefix of the keys of the events of Kubernetes.
+const eventsPrefix = "/registry/events/"
+
+// EventsConfig configures the storage of the events of Kubernetes in a
+// table of their own, compacted on a schedule of its own, so that their
+// churn does not weigh on the compaction and the watches of the other keys.
+// The table is in the database of the datastore and shares its revisions.
+type EventsConfig struct {
+	// Enabled stores the events in a table of their own.
+	Enabled bool
+	// CompactInterval is the interval between the compactions of the
+	// events. If not positive, the compaction interval of the datastore is
+	// used.
+	CompactInterval time.Duration
+	// CompactRetentionDuration retains the revisions of the events created
+	// in the given time window. If not positive, only the most recent
+	// revisions are retained, as without a retention for the datastore.
+	CompactRetentionDuration time.Duration
+}
+
+// addParams adds the parameters storing the events in a table of their own
+// to the parameters of the kine endpoint, if enabled.
+func (c EventsConfig) addParams(params url.Values) {
+	if !c.Enabled {
+		return
+	}
+	params["events-prefix"] = []string{eventsPrefix}
+	if c.CompactInterval > 0 {
+		params["events-compact-interval"] = []string{fmt.Sprintf("%v", c.CompactInterval)}
+	}
+	if c.CompactRetentionDuration > 0 {
+		params["events-compact-retention-duration"] = []string{fmt.Sprintf("%v", c.CompactRetentionDuration)}
+	}
+}
@@ -0,0 +1,33 @@
+package server
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestEventsParams(t *testing.T) {
+	for _, tc := range []struct {
+		config EventsConfig
+		query  string
+	}{
+		{
+			EventsConfig{},
+			"driver-name=dqlite-1",
+		},
+		{
+			EventsConfig{Enabled: true},
+			"driver-name=dqlite-1&events-prefix=%2Fregistry%2Fevents%2F",
+		},
+		{
+			EventsConfig{Enabled: true, CompactInterval: time.Minute, CompactRetentionDuration: time.Hour},
+			"driver-name=dqlite-1&events-compact-interval=1m0s&events-compact-retention-duration=1h0m0s&events-prefix=%2Fregistry%2Fevents%2F",
+		},
+	} {
+		params := url.Values{"driver-name": {"dqlite-1"}}
+		tc.config.addParams(params)
+		if query := params.Encode(); query != tc.query {
+			t.Fatalf("%+v: expected %q, got %q", tc.config, tc.query, query)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// healthKey is the key written by the write probe of the health checks.
+const healthKey = "/k8s-dqlite/health"
+
+// healthCheck is a named probe of the health endpoints.
+type healthCheck struct {
+	name  string
+	check func(ctx context.Context) error
+}
+
+// HealthHandler serves the health endpoints of the server, which must be
+// started:
+//   - /livez checks that the datastore answers a read.
+//   - /readyz checks that the datastore answers a read, and a write if
+//     writeProbe is set, and that the dqlite cluster has a leader.
+//   - /healthz is an alias of /readyz.
+//
+// Each probe fails after timeout. Adding ?verbose to the request lists the
+// result of each probe.
+func (s *Server) HealthHandler(writeProbe bool, timeout time.Duration) http.Handler {
+	live := []healthCheck{{"read", s.readProbe}}
+	ready := append([]healthCheck{}, live...)
+	if writeProbe {
+		ready = append(ready, healthCheck{"write", s.writeProbe})
+	}
+	ready = append(ready, healthCheck{"leader", s.leaderProbe})
+
+	mux := http.NewServeMux()
+	mux.Handle("/livez", healthHandler(live, timeout))
+	mux.Handle("/readyz", healthHandler(ready, timeout))
+	mux.Handle("/healthz", healthHandler(ready, timeout))
+	return mux
+}
+
+func healthHandler(checks []healthCheck, timeout time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		failed := false
+		var report string
+		for _, c := range checks {
+			ctx, cancel := context.WithTimeout(r.Context(), timeout)
+			err := c.check(ctx)
+			cancel()
+			if err != nil {
+				failed = true
+				logger.WithError(err).WithField("check", c.name).Warning("Health check failed")
+				report += fmt.Sprintf("[-]%s failed: %v\n", c.name, err)
+			} else {
+				report += fmt.Sprintf("[+]%s ok\n", c.name)
+			}
+		}
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		if failed {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprint(w, report)
+			return
+		}
+		if _, verbose := r.URL.Query()["verbose"]; verbose {
+			fmt.Fprint(w, report)
+		}
+		fmt.Fprint(w, "ok\n")
+	}
+}
+
+func (s *Server) readProbe(ctx context.Context) error {
+	_, err := s.backend.CurrentRevision(ctx)
+	return err
+}
+
+// writeProbe writes the health key. Concurrent probes might race to update
+// it, which still proves that the datastore accepts writes.
+func (s *Server) writeProbe(ctx context.Context) error {
+	_, kv, err := s.backend.Get(ctx, healthKey, "", 1, 0)
+	if err != nil {
+		return err
+	}
+	value := []byte(time.Now().UTC().Format(time.RFC3339Nano))
+	if kv == nil {
+		_, _, err = s.backend.Create(ctx, healthKey, value, 0)
+	} else {
+		_, _, err = s.backend.Update(ctx, healthKey, value, kv.ModRevision, 0)
+	}
+	return err
+}
+
+func (s *Server) leaderProbe(ctx context.Context) error {
+	_, err := s.kineConfig.Cluster.Leader(ctx)
+	return err
+}
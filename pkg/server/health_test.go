@@ -0,0 +1,44 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHealthHandler(t *testing.T) {
+	ok := healthCheck{"read", func(context.Context) error { return nil }}
+	stuck := healthCheck{"write", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}}
+	noLeader := healthCheck{"leader", func(context.Context) error { return errors.New("no leader elected") }}
+
+	for _, tc := range []struct {
+		name   string
+		checks []healthCheck
+		url    string
+		status int
+		body   string
+	}{
+		{"healthy", []healthCheck{ok}, "/readyz", http.StatusOK, "ok\n"},
+		{"verbose", []healthCheck{ok}, "/readyz?verbose", http.StatusOK, "[+]read ok\nok\n"},
+		{"timeout", []healthCheck{ok, stuck}, "/readyz", http.StatusServiceUnavailable, "[-]write failed: context deadline exceeded"},
+		{"failed", []healthCheck{ok, noLeader}, "/readyz", http.StatusServiceUnavailable, "[-]leader failed: no leader elected"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			healthHandler(tc.checks, 10*time.Millisecond)(rec, httptest.NewRequest(http.MethodGet, tc.url, nil))
+			if rec.Code != tc.status {
+				t.Errorf("expected status %d, got %d", tc.status, rec.Code)
+			}
+			if !strings.Contains(rec.Body.String(), tc.body) {
+				t.Errorf("expected body to contain %q, got %q", tc.body, rec.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package server
+
+import (
+	"context"
+	"fmt"
+)
+
+// KeyRevision is a revision of a key, as stored in the datastore.
+type KeyRevision struct {
+	Revision int64 `json:"revision"`
+	// Type is one of create, update and delete.
+	Type           string `json:"type"`
+	CreateRevision int64  `json:"create_revision"`
+	// PrevRevision is the revision replaced by this one, unless the key was
+	// created.
+	PrevRevision int64 `json:"prev_revision,omitempty"`
+	Lease        int64 `json:"lease,omitempty"`
+	// Value is the value of the key, unless it was deleted.
+	Value []byte `json:"value,omitempty"`
+}
+
+// KeyHistory lists the revisions of a key retained in the datastore.
+type KeyHistory struct {
+	Key string `json:"key"`
+	// CompactRevision is the compact revision of the datastore, before
+	// which the revisions of the key are gone, except for its latest one.
+	CompactRevision int64         `json:"compact_revision"`
+	Revisions       []KeyRevision `json:"revisions"`
+}
+
+// History returns the revisions of key retained in the datastore, read
+// directly from the database.
+func (s *Server) History(ctx context.Context, key string) (KeyHistory, error) {
+	compactRevision, events, err := s.backend.History(ctx, key)
+	if err != nil {
+		return KeyHistory{}, fmt.Errorf("failed to read key history: %w", err)
+	}
+	history := KeyHistory{
+		Key:             key,
+		CompactRevision: compactRevision,
+		Revisions:       make([]KeyRevision, 0, len(events)),
+	}
+	for _, event := range events {
+		revision := KeyRevision{
+			Revision:       event.KV.ModRevision,
+			Type:           "update",
+			CreateRevision: event.KV.CreateRevision,
+			Lease:          event.KV.Lease,
+			Value:          event.KV.Value,
+		}
+		switch {
+		case event.Create:
+			revision.Type = "create"
+		case event.Delete:
+			// The tombstones hold the deleted value.
+			revision.Type = "delete"
+			revision.Value = nil
+		}
+		if event.PrevKV != nil {
+			revision.PrevRevision = event.PrevKV.ModRevision
+		}
+		history.Revisions = append(history.Revisions, revision)
+	}
+	return history, nil
+}
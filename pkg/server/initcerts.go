@@ -0,0 +1,162 @@
+package server
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// peerServerName is the first DNS name of the generated peer certificates,
+// which the nodes verify the certificates of each other against.
+const peerServerName = "k8s-dqlite"
+
+// InitCertsConfig configures the certificates generated by InitCerts.
+type InitCertsConfig struct {
+	// SANs are the DNS names and IP addresses of the node, added to the
+	// server and peer certificates.
+	SANs []string
+	// Validity is the validity of the generated certificates.
+	Validity time.Duration
+	// Force overwrites the existing server and peer certificates.
+	Force bool
+}
+
+// InitCerts generates the certificates of a node in dir:
+//   - ca.crt and ca.key, the CA signing the other certificates. An existing
+//     CA is used instead, so that the certificates of the other nodes can
+//     be generated from a copy of it.
+//   - cluster.crt and cluster.key, the peer certificate of the dqlite
+//     traffic between the nodes.
+//   - server.crt and server.key, the certificate of the kine endpoint.
+func InitCerts(dir string, config InitCertsConfig) error {
+	var dnsNames []string
+	var ips []net.IP
+	for _, san := range config.SANs {
+		if ip := net.ParseIP(san); ip != nil {
+			ips = append(ips, ip)
+		} else {
+			dnsNames = append(dnsNames, san)
+		}
+	}
+
+	if !config.Force {
+		for _, name := range []string{"cluster.crt", "cluster.key", "server.crt", "server.key"} {
+			if exists, err := fileExists(dir, name); err != nil {
+				return fmt.Errorf("failed to check for %s: %w", name, err)
+			} else if exists {
+				return fmt.Errorf("%s already exists", name)
+			}
+		}
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("failed to create storage dir: %w", err)
+	}
+
+	ca, caKey, err := loadOrCreateCA(dir, config.Validity)
+	if err != nil {
+		return err
+	}
+
+	peer := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: peerServerName},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		DNSNames:    append([]string{peerServerName}, dnsNames...),
+		IPAddresses: ips,
+	}
+	if err := createCertificate(dir, "cluster", peer, ca, caKey, config.Validity); err != nil {
+		return err
+	}
+
+	server := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: peerServerName},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		DNSNames:    append([]string{"localhost"}, dnsNames...),
+		IPAddresses: append([]net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback}, ips...),
+	}
+	return createCertificate(dir, "server", server, ca, caKey, config.Validity)
+}
+
+// loadOrCreateCA loads the CA in ca.crt and ca.key, or creates it if
+// missing.
+func loadOrCreateCA(dir string, validity time.Duration) (*x509.Certificate, crypto.Signer, error) {
+	if exists, err := fileExists(dir, "ca.crt"); err != nil {
+		return nil, nil, fmt.Errorf("failed to check for ca.crt: %w", err)
+	} else if exists {
+		keypair, err := tls.LoadX509KeyPair(filepath.Join(dir, "ca.crt"), filepath.Join(dir, "ca.key"))
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to load CA from ca.crt and ca.key: %w", err)
+		}
+		ca, err := x509.ParseCertificate(keypair.Certificate[0])
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to parse ca.crt: %w", err)
+		}
+		signer, ok := keypair.PrivateKey.(crypto.Signer)
+		if !ok || !ca.IsCA {
+			return nil, nil, fmt.Errorf("ca.crt is not a CA certificate")
+		}
+		logger.Print("Use existing CA from ca.crt")
+		return ca, signer, nil
+	}
+
+	ca := &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "k8s-dqlite-ca"},
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	if err := createCertificate(dir, "ca", ca, nil, nil, validity); err != nil {
+		return nil, nil, err
+	}
+	return loadOrCreateCA(dir, validity)
+}
+
+// createCertificate writes name.crt and name.key in dir, with a new key and
+// the template signed by parent, or self-signed if parent is nil.
+func createCertificate(dir, name string, template, parent *x509.Certificate, parentKey crypto.Signer, validity time.Duration) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return fmt.Errorf("failed to generate key of %s: %w", name, err)
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return fmt.Errorf("failed to generate serial number of %s: %w", name, err)
+	}
+
+	template.SerialNumber = serial
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(validity)
+	if template.KeyUsage == 0 {
+		template.KeyUsage = x509.KeyUsageDigitalSignature
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, key.Public(), parentKey)
+	if err != nil {
+		return fmt.Errorf("failed to create %s certificate: %w", name, err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return fmt.Errorf("failed to marshal key of %s: %w", name, err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, name+".crt"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		return fmt.Errorf("failed to write %s.crt: %w", name, err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, name+".key"), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return fmt.Errorf("failed to write %s.key: %w", name, err)
+	}
+	logger.WithField("file", filepath.Join(dir, name+".crt")).Print("Created certificate")
+	return nil
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"crypto/x509"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestInitCerts(t *testing.T) {
+	dir1, dir2 := t.TempDir(), t.TempDir()
+	config := InitCertsConfig{SANs: []string{"node1.example", "10.0.0.1"}, Validity: time.Hour}
+	if err := InitCerts(dir1, config); err != nil {
+		t.Fatal(err)
+	}
+	if err := InitCerts(dir1, config); err == nil {
+		t.Fatal("expected the existing certificates not to be overwritten")
+	}
+
+	// The second node uses a copy of the CA of the first one.
+	for _, name := range []string{"ca.crt", "ca.key"} {
+		b, err := os.ReadFile(filepath.Join(dir1, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir2, name), b, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := InitCerts(dir2, InitCertsConfig{SANs: []string{"node2.example"}, Validity: time.Hour}); err != nil {
+		t.Fatal(err)
+	}
+
+	load := func(dir, name string) *tlsCertificate {
+		certificate, err := newTLSCertificate(filepath.Join(dir, name+".crt"), filepath.Join(dir, name+".key"), filepath.Join(dir1, "ca.crt"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return certificate
+	}
+	listen, _ := load(dir1, "cluster").peerTLSConfig()
+	_, dial := load(dir2, "cluster").peerTLSConfig()
+	if _, err := handshake(listen, dial); err != nil {
+		t.Fatalf("expected the peer certificates to be trusted: %v", err)
+	}
+
+	server := load(dir1, "server").certificate()
+	cert, err := x509.ParseCertificate(server.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cert.VerifyHostname("10.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cert.VerifyHostname("node1.example"); err != nil {
+		t.Fatal(err)
+	}
+}
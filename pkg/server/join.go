@@ -0,0 +1,353 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// joinTokensFile holds the hashes of the join tokens created on the node
+// which are not used yet.
+const joinTokensFile = "join-tokens.yaml"
+
+// joinTokensMu serializes the updates of the join tokens file within the
+// process.
+var joinTokensMu sync.Mutex
+
+// joinToken is the information a node needs to join the cluster, encoded
+// as base64 JSON.
+type joinToken struct {
+	// Address is the address of the join endpoint of the node which
+	// created the token.
+	Address string `json:"address"`
+	// Secret authenticates the joining node.
+	Secret string `json:"secret"`
+	// Fingerprint is the SHA-256 fingerprint of the certificate of the join
+	// endpoint, which the joining node verifies.
+	Fingerprint string `json:"fingerprint"`
+}
+
+// joinTokenRecord is a join token stored on the node which created it.
+type joinTokenRecord struct {
+	// Hash is the SHA-256 hash of the secret of the token.
+	Hash   string    `yaml:"Hash"`
+	Expiry time.Time `yaml:"Expiry"`
+}
+
+type joinRequest struct {
+	Secret string `json:"secret"`
+}
+
+type joinResponse struct {
+	// Certificate and Key are the cluster certificate.
+	Certificate []byte `json:"certificate"`
+	Key         []byte `json:"key"`
+	// Cluster are the addresses of the nodes of the cluster.
+	Cluster []string `json:"cluster"`
+}
+
+// CreateJoinToken creates a token valid for ttl, with which a node can join
+// the cluster through the join endpoint at address. The certificate of the
+// join endpoint is certFile, or cluster.crt in dir if empty. The token is
+// stored in dir, so it must be created on the node serving the endpoint.
+func CreateJoinToken(dir, certFile, address string, ttl time.Duration) (string, error) {
+	if certFile == "" {
+		certFile = filepath.Join(dir, "cluster.crt")
+	}
+	b, err := os.ReadFile(certFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to read certificate: %w", err)
+	}
+	block, _ := pem.Decode(b)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return "", fmt.Errorf("no certificate found in %s", certFile)
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", fmt.Errorf("failed to generate secret: %w", err)
+	}
+	token := joinToken{
+		Address:     address,
+		Secret:      hex.EncodeToString(secret),
+		Fingerprint: fingerprint(block.Bytes),
+	}
+
+	joinTokensMu.Lock()
+	defer joinTokensMu.Unlock()
+	records, err := loadJoinTokens(dir)
+	if err != nil {
+		return "", err
+	}
+	records = append(records, joinTokenRecord{Hash: hashSecret(token.Secret), Expiry: time.Now().Add(ttl)})
+	if err := saveJoinTokens(dir, records); err != nil {
+		return "", err
+	}
+
+	b, err = json.Marshal(token)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// consumeJoinToken checks that secret is the one of a join token which did
+// not expire, and removes the token so that it is only used once.
+func consumeJoinToken(dir, secret string) error {
+	joinTokensMu.Lock()
+	defer joinTokensMu.Unlock()
+
+	records, err := loadJoinTokens(dir)
+	if err != nil {
+		return err
+	}
+	hash := hashSecret(secret)
+	found := false
+	for i, record := range records {
+		if record.Hash == hash {
+			records = append(records[:i], records[i+1:]...)
+			found = true
+			break
+		}
+	}
+	if !found {
+		return fmt.Errorf("invalid or expired join token")
+	}
+	return saveJoinTokens(dir, records)
+}
+
+// loadJoinTokens loads the join tokens of the node, except the expired
+// ones.
+func loadJoinTokens(dir string) ([]joinTokenRecord, error) {
+	var records []joinTokenRecord
+	if exists, err := fileExists(dir, joinTokensFile); err != nil {
+		return nil, fmt.Errorf("failed to check for %s: %w", joinTokensFile, err)
+	} else if exists {
+		if err := fileUnmarshal(&records, dir, joinTokensFile); err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", joinTokensFile, err)
+		}
+	}
+
+	now := time.Now()
+	valid := records[:0]
+	for _, record := range records {
+		if record.Expiry.After(now) {
+			valid = append(valid, record)
+		}
+	}
+	return valid, nil
+}
+
+// saveJoinTokens replaces the join tokens file, atomically as the tokens
+// might be created and used concurrently by different processes.
+func saveJoinTokens(dir string, records []joinTokenRecord) error {
+	tmp := joinTokensFile + ".tmp"
+	if err := fileMarshal(records, dir, tmp); err != nil {
+		return fmt.Errorf("failed to write %s: %w", joinTokensFile, err)
+	}
+	if err := os.Rename(filepath.Join(dir, tmp), filepath.Join(dir, joinTokensFile)); err != nil {
+		return fmt.Errorf("failed to replace %s: %w", joinTokensFile, err)
+	}
+	return nil
+}
+
+func hashSecret(secret string) string {
+	hash := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(hash[:])
+}
+
+func fingerprint(der []byte) string {
+	hash := sha256.Sum256(der)
+	return hex.EncodeToString(hash[:])
+}
+
+// JoinHandler serves the requests of the nodes joining the cluster with a
+// join token created on this node. The nodes receive the cluster
+// certificate and the addresses of the nodes of the cluster.
+func (s *Server) JoinHandler() http.Handler {
+	return joinHandler(s.storageDir, s.peerCertificate, s.clusterAddresses)
+}
+
+// JoinTLSConfig returns the TLS configuration of the join endpoint, which
+// presents the peer certificate, as fingerprinted in the join tokens.
+func (s *Server) JoinTLSConfig() (*tls.Config, error) {
+	if s.peerCertificate == nil {
+		return nil, fmt.Errorf("the join endpoint requires TLS")
+	}
+	return s.peerCertificate.endpointTLSConfig(nil, false), nil
+}
+
+func (s *Server) clusterAddresses(ctx context.Context) ([]string, error) {
+	client, err := s.app.Leader(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to the leader: %w", err)
+	}
+	defer client.Close()
+
+	nodes, err := client.Cluster(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the nodes: %w", err)
+	}
+	addresses := make([]string, 0, len(nodes))
+	for _, node := range nodes {
+		addresses = append(addresses, node.Address)
+	}
+	return addresses, nil
+}
+
+func joinHandler(dir string, certificate *tlsCertificate, cluster func(context.Context) ([]string, error)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logger := logger.WithField("remote_address", r.RemoteAddr)
+		if r.Method != http.MethodPost || r.URL.Path != "/join" {
+			http.NotFound(w, r)
+			return
+		}
+
+		var request joinRequest
+		if err := json.NewDecoder(io.LimitReader(r.Body, 4096)).Decode(&request); err != nil {
+			http.Error(w, "invalid join request", http.StatusBadRequest)
+			return
+		}
+		if err := consumeJoinToken(dir, request.Secret); err != nil {
+			logger.WithError(err).Warning("Refused join request")
+			http.Error(w, "invalid or expired join token", http.StatusForbidden)
+			return
+		}
+
+		var (
+			response joinResponse
+			err      error
+		)
+		if response.Certificate, err = os.ReadFile(certificate.crtFile); err != nil {
+			logger.WithError(err).Error("Failed to read cluster certificate")
+			http.Error(w, "failed to read cluster certificate", http.StatusInternalServerError)
+			return
+		}
+		if response.Key, err = os.ReadFile(certificate.keyFile); err != nil {
+			logger.WithError(err).Error("Failed to read cluster key")
+			http.Error(w, "failed to read cluster certificate", http.StatusInternalServerError)
+			return
+		}
+		if response.Cluster, err = cluster(r.Context()); err != nil {
+			logger.WithError(err).Error("Failed to list cluster nodes")
+			http.Error(w, "failed to list cluster nodes", http.StatusServiceUnavailable)
+			return
+		}
+
+		logger.Print("Accepted join request")
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			logger.WithError(err).Warning("Failed to write join response")
+		}
+	})
+}
+
+// parseJoinToken decodes a join token.
+func parseJoinToken(token string) (joinToken, error) {
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return joinToken{}, fmt.Errorf("invalid join token: %w", err)
+	}
+	var t joinToken
+	if err := json.Unmarshal(b, &t); err != nil {
+		return joinToken{}, fmt.Errorf("invalid join token: %w", err)
+	}
+	return t, nil
+}
+
+// Join prepares the node in dir to join the cluster with a join token: it
+// fetches the cluster certificate and the addresses of the nodes from the
+// join endpoint in the token, verified by its fingerprint, and writes
+// cluster.crt, cluster.key and an init.yaml for the node to join the
+// cluster at address when it starts. Nodes which are already initialized
+// are left unchanged.
+func Join(ctx context.Context, dir, token, address string) error {
+	for _, name := range []string{"info.yaml", "init.yaml"} {
+		if exists, err := fileExists(dir, name); err != nil {
+			return fmt.Errorf("failed to check for %s: %w", name, err)
+		} else if exists {
+			logger.WithField("file", name).Print("Node is already initialized, ignore join token")
+			return nil
+		}
+	}
+	if address == "" {
+		return fmt.Errorf("joining with a token requires the address of the node")
+	}
+
+	t, err := parseJoinToken(token)
+	if err != nil {
+		return err
+	}
+
+	request, err := json.Marshal(joinRequest{Secret: t.Secret})
+	if err != nil {
+		return err
+	}
+	client := &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{
+				MinVersion: tls.VersionTLS12,
+				// The certificate is verified by its fingerprint instead.
+				InsecureSkipVerify: true,
+				VerifyConnection: func(state tls.ConnectionState) error {
+					if len(state.PeerCertificates) == 0 || fingerprint(state.PeerCertificates[0].Raw) != t.Fingerprint {
+						return fmt.Errorf("certificate of the join endpoint does not match the join token")
+					}
+					return nil
+				},
+			},
+		},
+	}
+	defer client.CloseIdleConnections()
+
+	logger.WithFields(logrus.Fields{"endpoint": t.Address, "address": address}).Print("Join cluster")
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "https://"+t.Address+"/join", bytes.NewReader(request))
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send join request: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("join request failed with %s: %s", resp.Status, bytes.TrimSpace(message))
+	}
+	var response joinResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return fmt.Errorf("invalid join response: %w", err)
+	}
+	if _, err := tls.X509KeyPair(response.Certificate, response.Key); err != nil {
+		return fmt.Errorf("invalid cluster certificate in join response: %w", err)
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("failed to create storage dir: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "cluster.crt"), response.Certificate, 0600); err != nil {
+		return fmt.Errorf("failed to write cluster.crt: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "cluster.key"), response.Key, 0600); err != nil {
+		return fmt.Errorf("failed to write cluster.key: %w", err)
+	}
+	if err := fileMarshal(InitConfiguration{Address: address, Cluster: response.Cluster}, dir, "init.yaml"); err != nil {
+		return fmt.Errorf("failed to write init.yaml: %w", err)
+	}
+	return nil
+}
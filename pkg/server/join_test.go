@@ -0,0 +1,92 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestJoin(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	writeClusterCertificate(t, dir, 1)
+	certificate, err := newClusterCertificate(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cluster := func(context.Context) ([]string, error) { return []string{"10.0.0.1:9000"}, nil }
+	endpoint := httptest.NewUnstartedServer(joinHandler(dir, certificate, cluster))
+	endpoint.TLS = certificate.endpointTLSConfig(nil, false)
+	// httptest would present its own certificate otherwise.
+	endpoint.TLS.Certificates = []tls.Certificate{*certificate.certificate()}
+	endpoint.StartTLS()
+	defer endpoint.Close()
+	address := endpoint.Listener.Addr().String()
+
+	token, err := CreateJoinToken(dir, "", address, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	joinDir := t.TempDir()
+	if err := Join(ctx, joinDir, token, "10.0.0.2:9000"); err != nil {
+		t.Fatal(err)
+	}
+
+	var init InitConfiguration
+	if err := fileUnmarshal(&init, joinDir, "init.yaml"); err != nil {
+		t.Fatal(err)
+	}
+	if init.Address != "10.0.0.2:9000" || len(init.Cluster) != 1 || init.Cluster[0] != "10.0.0.1:9000" {
+		t.Fatalf("unexpected init.yaml %+v", init)
+	}
+	for _, name := range []string{"cluster.crt", "cluster.key"} {
+		expected, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual, err := os.ReadFile(filepath.Join(joinDir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(actual) != string(expected) {
+			t.Fatalf("%s differs from the one of the cluster", name)
+		}
+	}
+
+	// The tokens are used only once.
+	if err := Join(ctx, t.TempDir(), token, "10.0.0.3:9000"); err == nil {
+		t.Fatal("expected a used token to be refused")
+	}
+
+	// The joining nodes only trust the certificate in the token.
+	otherDir := t.TempDir()
+	writeClusterCertificate(t, otherDir, 2)
+	token, err = CreateJoinToken(otherDir, "", address, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Join(ctx, t.TempDir(), token, "10.0.0.3:9000"); err == nil {
+		t.Fatal("expected the certificate of the join endpoint to be refused")
+	}
+}
+
+func TestJoinTokenExpiry(t *testing.T) {
+	dir := t.TempDir()
+	writeClusterCertificate(t, dir, 1)
+	if _, err := CreateJoinToken(dir, "", net.JoinHostPort("127.0.0.1", "9443"), -time.Second); err != nil {
+		t.Fatal(err)
+	}
+	records, err := loadJoinTokens(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 0 {
+		t.Fatalf("expected the expired token to be dropped, got %d tokens", len(records))
+	}
+}
@@ -0,0 +1,97 @@
+package server
+
+import (
+	"context"
+	"fmt"
+
+	kine_server "github.com/canonical/k8s-dqlite/pkg/kine/server"
+)
+
+// maxListKeys bounds the number of keys listed at once.
+const maxListKeys = 1000
+
+// ListKeysOptions selects the keys listed.
+type ListKeysOptions struct {
+	// Prefix is the prefix of the keys, which must not be empty.
+	Prefix string
+	// Start is the key the list starts from, inclusive, to continue a
+	// previous list.
+	Start string
+	// Limit is the number of keys listed, up to maxListKeys. If not
+	// positive, maxListKeys keys are listed.
+	Limit int64
+	// Revision is the revision the keys are listed at, or zero for the
+	// current revision.
+	Revision int64
+	// Values lists the values of the keys along with their metadata.
+	Values bool
+}
+
+// KeyValue is the latest revision of a key.
+type KeyValue struct {
+	Key            string `json:"key"`
+	CreateRevision int64  `json:"create_revision"`
+	ModRevision    int64  `json:"mod_revision"`
+	Lease          int64  `json:"lease,omitempty"`
+	Value          []byte `json:"value,omitempty"`
+}
+
+// KeyList is a page of keys.
+type KeyList struct {
+	// Revision is the revision the keys were listed at, to list the next
+	// pages at.
+	Revision int64      `json:"revision"`
+	Keys     []KeyValue `json:"keys"`
+	// Next is the key the next page starts from, or empty if the list is
+	// complete.
+	Next string `json:"next,omitempty"`
+}
+
+// ListKeys lists the keys with a prefix, read directly from the database.
+func (s *Server) ListKeys(ctx context.Context, opts ListKeysOptions) (KeyList, error) {
+	if opts.Prefix == "" {
+		return KeyList{}, fmt.Errorf("the prefix is required")
+	}
+	if opts.Limit <= 0 || opts.Limit > maxListKeys {
+		opts.Limit = maxListKeys
+	}
+	start := opts.Prefix
+	if opts.Start > start {
+		start = opts.Start
+	}
+	// One more key is listed to tell whether the list is complete.
+	rev, kvs, err := s.backend.ListRange(ctx, start, prefixEnd(opts.Prefix), opts.Limit+1, opts.Revision, kine_server.RangeOptions{KeysOnly: !opts.Values})
+	if err != nil {
+		return KeyList{}, fmt.Errorf("failed to list keys: %w", err)
+	}
+
+	list := KeyList{Revision: rev, Keys: make([]KeyValue, 0, len(kvs))}
+	if int64(len(kvs)) > opts.Limit {
+		list.Next = kvs[opts.Limit].Key
+		kvs = kvs[:opts.Limit]
+	}
+	for _, kv := range kvs {
+		list.Keys = append(list.Keys, KeyValue{
+			Key:            kv.Key,
+			CreateRevision: kv.CreateRevision,
+			ModRevision:    kv.ModRevision,
+			Lease:          kv.Lease,
+			Value:          kv.Value,
+		})
+	}
+	return list, nil
+}
+
+// prefixEnd returns the end of the range of the keys with prefix, which is
+// not empty.
+func prefixEnd(prefix string) string {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return string(end[:i+1])
+		}
+	}
+	// The keys are valid UTF-8, and never start with 0xff.
+	return "\xff"
+}
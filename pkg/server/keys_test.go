@@ -0,0 +1,16 @@
+package server
+
+import "testing"
+
+func TestPrefixEnd(t *testing.T) {
+	for prefix, expected := range map[string]string{
+		"/registry/": "/registry0",
+		"/registry":  "/registrz",
+		"a\xff":      "b",
+		"\xff\xff":   "\xff",
+	} {
+		if end := prefixEnd(prefix); end != expected {
+			t.Errorf("expected the end of %q to be %q, got %q", prefix, expected, end)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package server
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// raftMetricsInterval is the interval between the updates of the dqlite
+// metrics.
+const raftMetricsInterval = 5 * time.Second
+
+var (
+	metricsRaftTerm = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "k8s_dqlite_raft_term",
+		Help: "Current raft term of the node",
+	})
+	metricsRaftLastIndex = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "k8s_dqlite_raft_last_index",
+		Help: "Index of the last raft entry in the closed segments and snapshots of the node",
+	})
+	metricsRaftSnapshotIndex = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "k8s_dqlite_raft_snapshot_index",
+		Help: "Index of the last raft snapshot of the node",
+	})
+	metricsRaftSnapshots = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "k8s_dqlite_raft_snapshots",
+		Help: "Total number of raft snapshots taken by the node",
+	})
+	metricsLeader = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "k8s_dqlite_leader",
+		Help: "Whether the node is the dqlite leader (1) or not (0)",
+	})
+	metricsLeaderChanges = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "k8s_dqlite_leader_changes",
+		Help: "Total number of changes of the dqlite leader seen by the node",
+	})
+	metricsClientConnections = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "k8s_dqlite_client_connections",
+		Help: "Number of open client connections of the kine endpoint of the node",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(
+		metricsRaftTerm,
+		metricsRaftLastIndex,
+		metricsRaftSnapshotIndex,
+		metricsRaftSnapshots,
+		metricsLeader,
+		metricsLeaderChanges,
+		metricsClientConnections,
+	)
+}
+
+// raftState is the state of raft stored in the data directory of a node.
+type raftState struct {
+	// Term is the current term, from the metadata files.
+	Term uint64
+	// LastIndex is the index of the last entry of the closed segments and
+	// snapshots. The entries of the open segment are not counted.
+	LastIndex uint64
+	// SnapshotIndex is the index of the last snapshot.
+	SnapshotIndex uint64
+}
+
+// raftDiskFormat is the format of the raft metadata files written by dqlite.
+const raftDiskFormat = 1
+
+// readRaftState reads the state of raft from the files of dir: the
+// metadata1 and metadata2 files hold the format, version, term and vote of
+// the node, the closed segments are named after the indexes of their first
+// and last entries, and the snapshots after their term, index and time.
+// The same state backs the raft metrics and the raft status of the etcd
+// Status RPC.
+func readRaftState(dir string) (raftState, error) {
+	var state raftState
+	var version uint64
+	for _, name := range []string{"metadata1", "metadata2"} {
+		b, err := os.ReadFile(filepath.Join(dir, name))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return raftState{}, err
+		}
+		if len(b) < 32 {
+			return raftState{}, fmt.Errorf("short raft metadata file %s", name)
+		}
+		if format := binary.LittleEndian.Uint64(b[0:8]); format != raftDiskFormat {
+			return raftState{}, fmt.Errorf("unsupported raft metadata format %d in %s", format, name)
+		}
+		// The file with the highest version is the most recent.
+		if v := binary.LittleEndian.Uint64(b[8:16]); v > version {
+			version = v
+			state.Term = binary.LittleEndian.Uint64(b[16:24])
+		}
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return raftState{}, err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		var first, last, term, index, timestamp uint64
+		switch {
+		case strings.HasPrefix(name, "snapshot-") && strings.HasSuffix(name, ".meta"):
+			if _, err := fmt.Sscanf(name, "snapshot-%d-%d-%d.meta", &term, &index, &timestamp); err == nil {
+				state.SnapshotIndex = max(state.SnapshotIndex, index)
+				state.LastIndex = max(state.LastIndex, index)
+			}
+		case len(name) == 33 && name[16] == '-':
+			if _, err := fmt.Sscanf(name, "%016d-%016d", &first, &last); err == nil {
+				state.LastIndex = max(state.LastIndex, last)
+			}
+		}
+	}
+	return state, nil
+}
+
+// updateRaftMetrics updates the dqlite metrics, and the number of client
+// connections, until ctx is done.
+func (s *Server) updateRaftMetrics(ctx context.Context) {
+	var leader, snapshotIndex uint64
+	ticker := time.NewTicker(raftMetricsInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		metricsClientConnections.Set(float64(len(s.kineServer.Connections())))
+		if state, err := readRaftState(s.storageDir); err != nil {
+			logger.WithError(err).Debug("Failed to read raft state")
+		} else {
+			metricsRaftTerm.Set(float64(state.Term))
+			metricsRaftLastIndex.Set(float64(state.LastIndex))
+			metricsRaftSnapshotIndex.Set(float64(state.SnapshotIndex))
+			if snapshotIndex != 0 && state.SnapshotIndex > snapshotIndex {
+				metricsRaftSnapshots.Inc()
+			}
+			snapshotIndex = state.SnapshotIndex
+		}
+
+		id, err := s.leaderID(ctx)
+		if err != nil {
+			logger.WithError(err).Debug("Failed to get dqlite leader")
+			continue
+		}
+		if leader != 0 && id != leader {
+			metricsLeaderChanges.Inc()
+		}
+		leader = id
+		if id == s.app.ID() {
+			metricsLeader.Set(1)
+		} else {
+			metricsLeader.Set(0)
+		}
+	}
+}
+
+func (s *Server) leaderID(ctx context.Context) (uint64, error) {
+	cli, err := s.app.Leader(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer cli.Close()
+
+	leader, err := cli.Leader(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if leader == nil {
+		return 0, fmt.Errorf("no leader")
+	}
+	return leader.ID, nil
+}
@@ -0,0 +1,104 @@
+package server
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/canonical/go-dqlite/app"
+)
+
+func TestReadRaftState(t *testing.T) {
+	dir := t.TempDir()
+	writeMetadata := func(name string, version, term uint64) {
+		b := make([]byte, 32)
+		binary.LittleEndian.PutUint64(b[0:8], 1)
+		binary.LittleEndian.PutUint64(b[8:16], version)
+		binary.LittleEndian.PutUint64(b[16:24], term)
+		if err := os.WriteFile(filepath.Join(dir, name), b, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeMetadata("metadata1", 5, 3)
+	writeMetadata("metadata2", 6, 4)
+	for _, name := range []string{
+		"0000000000000001-0000000000001024",
+		"0000000000001025-0000000000002048",
+		"open-1",
+		"snapshot-3-1500-1700000000000",
+		"snapshot-3-1500-1700000000000.meta",
+		"snapshot-4-2100-1700000001000.meta",
+		"info.yaml",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	state, err := readRaftState(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := (raftState{Term: 4, LastIndex: 2100, SnapshotIndex: 2100}); state != expected {
+		t.Fatalf("expected %+v, got %+v", expected, state)
+	}
+}
+
+// TestReadRaftStateDqlite reads the raft state from the data directory of a
+// dqlite node, whose entries are all in closed segments once it is stopped.
+func TestReadRaftStateDqlite(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+
+	dir := t.TempDir()
+	node, err := app.New(dir, app.WithAddress(address))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := node.Ready(ctx); err != nil {
+		node.Close()
+		t.Fatal(err)
+	}
+	db, err := node.Open(ctx, "test")
+	if err != nil {
+		node.Close()
+		t.Fatal(err)
+	}
+	const writes = 10
+	if _, err := db.ExecContext(ctx, `CREATE TABLE t (x INTEGER)`); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < writes; i++ {
+		if _, err := db.ExecContext(ctx, fmt.Sprintf(`INSERT INTO t(x) VALUES(%d)`, i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.Close()
+	if err := node.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	state, err := readRaftState(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Term == 0 {
+		t.Errorf("expected a raft term, got %+v", state)
+	}
+	// The bootstrap configuration, the table and the writes.
+	if state.LastIndex < writes+2 {
+		t.Errorf("expected at least %d raft entries, got %+v", writes+2, state)
+	}
+}
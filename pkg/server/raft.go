@@ -0,0 +1,123 @@
+package server
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/canonical/go-dqlite"
+	"github.com/canonical/go-dqlite/app"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// electionTimeoutLatencies and heartbeatLatencyTenths are the election
+	// timeout and heartbeat interval that dqlite derives from the network
+	// latency, in network latencies and tenths of network latency.
+	electionTimeoutLatencies = 15
+	heartbeatLatencyTenths   = 15
+
+	// defaultSnapshotThreshold and defaultSnapshotTrailing are the dqlite
+	// defaults of the raft snapshot parameters.
+	defaultSnapshotThreshold = 1024
+	defaultSnapshotTrailing  = 8192
+)
+
+// RaftConfig tunes the raft protocol of dqlite. The zero values keep the
+// dqlite defaults. The settings of tuning.yaml take precedence.
+type RaftConfig struct {
+	// ElectionTimeout is the time after which the followers which did not
+	// hear from the leader start an election.
+	ElectionTimeout time.Duration
+	// HeartbeatInterval is the interval between the heartbeats of the
+	// leader. As dqlite derives both from the network latency, the
+	// heartbeat interval is a tenth of the election timeout, so only one
+	// of them needs to be set.
+	HeartbeatInterval time.Duration
+	// SnapshotThreshold is the number of log entries after which a raft
+	// snapshot is taken.
+	SnapshotThreshold uint64
+	// SnapshotTrailing is the number of log entries kept after a raft
+	// snapshot, so that the followers lagging behind catch up from the log
+	// instead of the whole snapshot.
+	SnapshotTrailing uint64
+}
+
+// networkLatency returns the network latency from which dqlite derives the
+// election timeout and heartbeat interval, or zero if neither is set.
+func (c RaftConfig) networkLatency() (time.Duration, error) {
+	if c.ElectionTimeout < 0 || c.HeartbeatInterval < 0 {
+		return 0, fmt.Errorf("the raft election timeout and heartbeat interval must not be negative")
+	}
+	var fromElection, fromHeartbeat time.Duration
+	if c.ElectionTimeout > 0 {
+		fromElection = c.ElectionTimeout / electionTimeoutLatencies
+	}
+	if c.HeartbeatInterval > 0 {
+		fromHeartbeat = c.HeartbeatInterval * 10 / heartbeatLatencyTenths
+	}
+	switch {
+	case fromElection > 0 && fromHeartbeat > 0 && fromElection.Truncate(time.Millisecond) != fromHeartbeat.Truncate(time.Millisecond):
+		return 0, fmt.Errorf("the raft heartbeat interval must be a tenth of the election timeout, got %v and %v", c.HeartbeatInterval, c.ElectionTimeout)
+	case fromElection > 0:
+		return checkNetworkLatency(fromElection)
+	case fromHeartbeat > 0:
+		return checkNetworkLatency(fromHeartbeat)
+	}
+	return 0, nil
+}
+
+// checkNetworkLatency checks that dqlite accepts the network latency, which
+// is counted in milliseconds.
+func checkNetworkLatency(latency time.Duration) (time.Duration, error) {
+	if latency < time.Millisecond || latency > time.Hour {
+		return 0, fmt.Errorf("the raft election timeout must be between %v and %v", electionTimeoutLatencies*time.Millisecond, electionTimeoutLatencies*time.Hour)
+	}
+	return latency, nil
+}
+
+// snapshotParams returns the raft snapshot parameters, or nil if neither is
+// set.
+func (c RaftConfig) snapshotParams() (*dqlite.SnapshotParams, error) {
+	if c.SnapshotThreshold == 0 && c.SnapshotTrailing == 0 {
+		return nil, nil
+	}
+	params := &dqlite.SnapshotParams{Threshold: c.SnapshotThreshold, Trailing: c.SnapshotTrailing}
+	if params.Threshold == 0 {
+		params.Threshold = defaultSnapshotThreshold
+	}
+	if params.Trailing == 0 {
+		params.Trailing = defaultSnapshotTrailing
+	}
+	if params.Trailing < params.Threshold {
+		return nil, fmt.Errorf("the raft snapshot trailing entries (%d) must not be less than the snapshot threshold (%d)", params.Trailing, params.Threshold)
+	}
+	return params, nil
+}
+
+// options returns the options of the dqlite app tuning raft.
+func (c RaftConfig) options() ([]app.Option, error) {
+	var options []app.Option
+	latency, err := c.networkLatency()
+	if err != nil {
+		return nil, err
+	}
+	if latency > 0 {
+		ms := latency.Milliseconds()
+		logger.WithFields(logrus.Fields{
+			"network_latency":    latency,
+			"election_timeout":   time.Duration(ms*electionTimeoutLatencies) * time.Millisecond,
+			"heartbeat_interval": time.Duration(ms*heartbeatLatencyTenths/10) * time.Millisecond,
+		}).Print("Configure dqlite raft timeouts")
+		options = append(options, app.WithNetworkLatency(latency))
+	}
+
+	params, err := c.snapshotParams()
+	if err != nil {
+		return nil, err
+	}
+	if params != nil {
+		logger.WithFields(logrus.Fields{"threshold": params.Threshold, "trailing": params.Trailing}).Print("Configure dqlite raft snapshot parameters")
+		options = append(options, app.WithSnapshotParams(*params))
+	}
+	return options, nil
+}
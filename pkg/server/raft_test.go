@@ -0,0 +1,52 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRaftNetworkLatency(t *testing.T) {
+	for _, tc := range []struct {
+		config  RaftConfig
+		latency time.Duration
+	}{
+		{RaftConfig{}, 0},
+		{RaftConfig{ElectionTimeout: 3 * time.Second}, 200 * time.Millisecond},
+		{RaftConfig{HeartbeatInterval: 300 * time.Millisecond}, 200 * time.Millisecond},
+		{RaftConfig{ElectionTimeout: 3 * time.Second, HeartbeatInterval: 300 * time.Millisecond}, 200 * time.Millisecond},
+	} {
+		latency, err := tc.config.networkLatency()
+		if err != nil {
+			t.Fatalf("%+v: %v", tc.config, err)
+		}
+		if latency != tc.latency {
+			t.Fatalf("%+v: expected %v, got %v", tc.config, tc.latency, latency)
+		}
+	}
+
+	for _, config := range []RaftConfig{
+		{ElectionTimeout: 3 * time.Second, HeartbeatInterval: time.Second},
+		{ElectionTimeout: 10 * time.Millisecond},
+		{HeartbeatInterval: -time.Second},
+	} {
+		if _, err := config.networkLatency(); err == nil {
+			t.Fatalf("expected %+v to be refused", config)
+		}
+	}
+}
+
+func TestRaftSnapshotParams(t *testing.T) {
+	if params, err := (RaftConfig{}).snapshotParams(); err != nil || params != nil {
+		t.Fatalf("expected the dqlite defaults, got %v (%v)", params, err)
+	}
+	params, err := RaftConfig{SnapshotThreshold: 4096}.snapshotParams()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if params.Threshold != 4096 || params.Trailing != defaultSnapshotTrailing {
+		t.Fatalf("unexpected snapshot parameters %+v", params)
+	}
+	if _, err := (RaftConfig{SnapshotThreshold: 16384}).snapshotParams(); err == nil {
+		t.Fatal("expected fewer trailing entries than the threshold to be refused")
+	}
+}
@@ -0,0 +1,222 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/canonical/go-dqlite/client"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+)
+
+// defaultRebalanceInterval is the interval of the rebalancing controller
+// when the roles adjustment frequency is not set, as dqlite's default.
+const defaultRebalanceInterval = 30 * time.Second
+
+// probeTimeout is the timeout of the probe of a node by the rebalancing
+// controller.
+const probeTimeout = 2 * time.Second
+
+var (
+	metricsNodes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "k8s_dqlite_cluster_nodes",
+		Help: "Number of nodes of the dqlite cluster by role and state, as seen by the leader",
+	}, []string{"role", "state"})
+	metricsRoleChanges = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "k8s_dqlite_cluster_role_changes",
+		Help: "Total number of changes of the roles of the nodes by new role, as seen by the leader",
+	}, []string{"role"})
+	metricsEvictions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "k8s_dqlite_cluster_evictions",
+		Help: "Total number of evictions of unreachable nodes by result",
+	}, []string{"result"})
+)
+
+func init() {
+	prometheus.MustRegister(
+		metricsNodes,
+		metricsRoleChanges,
+		metricsEvictions,
+	)
+}
+
+// rebalancer complements the roles adjustment of dqlite, which promotes the
+// online nodes to replace the offline voters and stand-bys, spreading them
+// across the failure domains. On the leader, it reports the changes of the
+// roles and the state of the nodes, and evicts the nodes unreachable for
+// longer than evictAfter, so that the cluster heals after losing nodes for
+// good.
+type rebalancer struct {
+	// id is the ID of the node running the controller.
+	id uint64
+	// evictAfter is the time after which the unreachable nodes are
+	// removed. If zero, the nodes are never removed.
+	evictAfter time.Duration
+	// probe checks whether the node is reachable.
+	probe func(ctx context.Context, node client.NodeInfo) bool
+
+	// roles are the roles of the nodes seen last.
+	roles map[uint64]client.NodeRole
+	// unreachable are the times the unreachable nodes were first seen
+	// unreachable.
+	unreachable map[uint64]time.Time
+}
+
+func newRebalancer(id uint64, evictAfter time.Duration, probe func(context.Context, client.NodeInfo) bool) *rebalancer {
+	return &rebalancer{
+		id:          id,
+		evictAfter:  evictAfter,
+		probe:       probe,
+		roles:       map[uint64]client.NodeRole{},
+		unreachable: map[uint64]time.Time{},
+	}
+}
+
+// reset forgets the state of the nodes, when the node is not the leader.
+func (r *rebalancer) reset() {
+	clear(r.roles)
+	clear(r.unreachable)
+	metricsNodes.Reset()
+}
+
+// step checks the nodes of the cluster through cli, connected to the
+// leader, at time now.
+func (r *rebalancer) step(ctx context.Context, cli membershipClient, now time.Time) error {
+	nodes, err := cli.Cluster(ctx)
+	if err != nil {
+		return err
+	}
+
+	members := make(map[uint64]struct{}, len(nodes))
+	online := make(map[uint64]bool, len(nodes))
+	for _, node := range nodes {
+		members[node.ID] = struct{}{}
+		if previous, ok := r.roles[node.ID]; ok && previous != node.Role {
+			logger.WithFields(logrus.Fields{"id": node.ID, "address": node.Address, "from": previous, "to": node.Role}).Print("Node role changed")
+			metricsRoleChanges.WithLabelValues(node.Role.String()).Inc()
+		}
+		r.roles[node.ID] = node.Role
+
+		online[node.ID] = node.ID == r.id || r.probe(ctx, node)
+		if online[node.ID] {
+			if since, ok := r.unreachable[node.ID]; ok {
+				logger.WithFields(logrus.Fields{"id": node.ID, "address": node.Address, "unreachable_for": now.Sub(since)}).Print("Node is reachable again")
+				delete(r.unreachable, node.ID)
+			}
+		} else if _, ok := r.unreachable[node.ID]; !ok {
+			logger.WithFields(logrus.Fields{"id": node.ID, "address": node.Address, "role": node.Role}).Warning("Node is unreachable")
+			r.unreachable[node.ID] = now
+		}
+	}
+	// The nodes removed by other means are forgotten.
+	for id := range r.roles {
+		if _, ok := members[id]; !ok {
+			delete(r.roles, id)
+			delete(r.unreachable, id)
+		}
+	}
+
+	metricsNodes.Reset()
+	offline := 0
+	for _, node := range nodes {
+		state := "online"
+		if !online[node.ID] {
+			state = "offline"
+			offline++
+		}
+		metricsNodes.WithLabelValues(node.Role.String(), state).Inc()
+	}
+
+	if r.evictAfter <= 0 {
+		return nil
+	}
+	// When half of the nodes are unreachable, the leader is likely cut
+	// off from them rather than the nodes gone.
+	if 2*offline >= len(nodes) {
+		if offline > 0 {
+			logger.WithField("unreachable", offline).Warning("Too many nodes unreachable, skip eviction")
+		}
+		return nil
+	}
+	for _, node := range nodes {
+		since, ok := r.unreachable[node.ID]
+		if !ok || now.Sub(since) < r.evictAfter {
+			continue
+		}
+		logger := logger.WithFields(logrus.Fields{"id": node.ID, "address": node.Address, "role": node.Role, "unreachable_for": now.Sub(since)})
+		logger.Warning("Evict unreachable node")
+		// The voters and stand-bys are demoted first, so that
+		// the removal does not change the quorum.
+		if node.Role != client.Spare {
+			if err := cli.Assign(ctx, node.ID, client.Spare); err != nil {
+				logger.WithError(err).Warning("Failed to demote unreachable node")
+				metricsEvictions.WithLabelValues("failure").Inc()
+				continue
+			}
+		}
+		if err := cli.Remove(ctx, node.ID); err != nil {
+			logger.WithError(err).Warning("Failed to remove unreachable node")
+			metricsEvictions.WithLabelValues("failure").Inc()
+			continue
+		}
+		metricsEvictions.WithLabelValues("success").Inc()
+		delete(r.roles, node.ID)
+		delete(r.unreachable, node.ID)
+	}
+	return nil
+}
+
+// rebalance runs the rebalancing controller until ctx is done.
+func (s *Server) rebalance(ctx context.Context) {
+	interval := s.roles.AdjustmentFrequency
+	if interval <= 0 {
+		interval = defaultRebalanceInterval
+	}
+	r := newRebalancer(s.app.ID(), s.roles.EvictUnreachableAfter, s.probeNode)
+	logger.WithFields(logrus.Fields{"interval": interval, "evict_unreachable_after": s.roles.EvictUnreachableAfter}).Print("Start rebalancing controller")
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := s.rebalanceStep(ctx, r); err != nil {
+			logger.WithError(err).Warning("Failed to check the cluster nodes")
+		}
+	}
+}
+
+func (s *Server) rebalanceStep(ctx context.Context, r *rebalancer) error {
+	cli, err := s.app.Leader(ctx)
+	if err != nil {
+		return err
+	}
+	defer cli.Close()
+
+	leader, err := cli.Leader(ctx)
+	if err != nil {
+		return err
+	}
+	if leader == nil || leader.ID != s.app.ID() {
+		r.reset()
+		return nil
+	}
+	return r.step(ctx, cli, time.Now())
+}
+
+// probeNode checks that the node answers a request.
+func (s *Server) probeNode(ctx context.Context, node client.NodeInfo) bool {
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+
+	cli, err := client.New(ctx, node.Address, client.WithDialFunc(s.dialFunc), client.WithLogFunc(dqliteLogFunc))
+	if err != nil {
+		return false
+	}
+	defer cli.Close()
+	_, err = cli.Describe(ctx)
+	return err == nil
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/canonical/go-dqlite/client"
+)
+
+func TestRebalancerEvictsUnreachableNodes(t *testing.T) {
+	ctx := context.Background()
+	membership := &fakeMembership{nodes: map[uint64]client.NodeInfo{
+		1: {ID: 1, Address: "10.0.0.1:9000", Role: client.Voter},
+		2: {ID: 2, Address: "10.0.0.2:9000", Role: client.Voter},
+		3: {ID: 3, Address: "10.0.0.3:9000", Role: client.Voter},
+		4: {ID: 4, Address: "10.0.0.4:9000", Role: client.StandBy},
+	}}
+	reachable := map[uint64]bool{2: true, 3: false, 4: true}
+	r := newRebalancer(1, time.Minute, func(_ context.Context, node client.NodeInfo) bool {
+		return reachable[node.ID]
+	})
+
+	start := time.Now()
+	if err := r.step(ctx, membership, start); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := membership.nodes[3]; !ok {
+		t.Fatal("expected the node to be kept until the eviction delay passes")
+	}
+
+	// The node is reachable again, so it is not evicted.
+	reachable[3] = true
+	if err := r.step(ctx, membership, start.Add(2*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	reachable[3] = false
+	if err := r.step(ctx, membership, start.Add(3*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := membership.nodes[3]; !ok {
+		t.Fatal("expected the node to be kept until the eviction delay passes again")
+	}
+
+	if err := r.step(ctx, membership, start.Add(5*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := membership.nodes[3]; ok {
+		t.Fatal("expected the unreachable node to be evicted")
+	}
+	if len(membership.nodes) != 3 {
+		t.Fatalf("expected 3 nodes, got %d", len(membership.nodes))
+	}
+}
+
+func TestRebalancerKeepsNodesWhenCutOff(t *testing.T) {
+	ctx := context.Background()
+	membership := &fakeMembership{nodes: map[uint64]client.NodeInfo{
+		1: {ID: 1, Address: "10.0.0.1:9000", Role: client.Voter},
+		2: {ID: 2, Address: "10.0.0.2:9000", Role: client.Voter},
+		3: {ID: 3, Address: "10.0.0.3:9000", Role: client.Voter},
+		4: {ID: 4, Address: "10.0.0.4:9000", Role: client.StandBy},
+	}}
+	r := newRebalancer(1, time.Minute, func(context.Context, client.NodeInfo) bool { return false })
+
+	start := time.Now()
+	for _, now := range []time.Time{start, start.Add(time.Hour)} {
+		if err := r.step(ctx, membership, now); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(membership.nodes) != 4 {
+		t.Fatalf("expected no node to be evicted, got %d nodes", len(membership.nodes))
+	}
+}
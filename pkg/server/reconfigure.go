@@ -0,0 +1,87 @@
+package server
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/canonical/go-dqlite"
+	"github.com/canonical/go-dqlite/client"
+	"github.com/sirupsen/logrus"
+)
+
+// Reconfigure recovers the dqlite cluster from the loss of its quorum by
+// rewriting the membership stored in dir to the surviving nodes, as voters.
+// The nodes are given by address or ID, as found in cluster.yaml, and must
+// include the node in dir, which must not be running. It returns the new
+// membership, which must be the same on all the surviving nodes.
+func Reconfigure(dir string, nodes []string) ([]client.NodeInfo, error) {
+	var (
+		info    client.NodeInfo
+		cluster []client.NodeInfo
+	)
+	if err := fileUnmarshal(&info, dir, "info.yaml"); err != nil {
+		return nil, fmt.Errorf("failed to read info.yaml: %w", err)
+	}
+	if err := fileUnmarshal(&cluster, dir, "cluster.yaml"); err != nil {
+		return nil, fmt.Errorf("failed to read cluster.yaml: %w", err)
+	}
+
+	membership, err := survivingNodes(info, cluster, nodes)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.WithFields(logrus.Fields{"id": info.ID, "cluster": membership}).Print("Reconfigure dqlite membership")
+	if err := dqlite.ReconfigureMembershipExt(dir, membership); err != nil {
+		return nil, fmt.Errorf("failed to reconfigure dqlite membership: %w", err)
+	}
+	if err := fileMarshal(membership, dir, "cluster.yaml"); err != nil {
+		return nil, fmt.Errorf("failed to write cluster.yaml: %w", err)
+	}
+	return membership, nil
+}
+
+// survivingNodes returns the nodes of cluster matching the addresses or IDs
+// in nodes, as voters. The node described by info must be one of them.
+func survivingNodes(info client.NodeInfo, cluster []client.NodeInfo, nodes []string) ([]client.NodeInfo, error) {
+	var (
+		membership []client.NodeInfo
+		seen       = map[uint64]struct{}{}
+		local      bool
+	)
+	for _, name := range nodes {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		var (
+			node  client.NodeInfo
+			found bool
+		)
+		for _, candidate := range cluster {
+			if candidate.Address == name || strconv.FormatUint(candidate.ID, 10) == name {
+				node, found = candidate, true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("node %q is not in cluster.yaml", name)
+		}
+		if _, ok := seen[node.ID]; ok {
+			return nil, fmt.Errorf("node %q is given more than once", name)
+		}
+		seen[node.ID] = struct{}{}
+		local = local || node.ID == info.ID
+
+		node.Role = client.Voter
+		membership = append(membership, node)
+	}
+	if len(membership) == 0 {
+		return nil, fmt.Errorf("no surviving node given")
+	}
+	if !local {
+		return nil, fmt.Errorf("the surviving nodes must include the local node %d (%s)", info.ID, info.Address)
+	}
+	return membership, nil
+}
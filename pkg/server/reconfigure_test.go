@@ -0,0 +1,40 @@
+package server
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/canonical/go-dqlite/client"
+)
+
+func TestSurvivingNodes(t *testing.T) {
+	cluster := []client.NodeInfo{
+		{ID: 1, Address: "10.0.0.1:9000", Role: client.Voter},
+		{ID: 2, Address: "10.0.0.2:9000", Role: client.StandBy},
+		{ID: 3, Address: "10.0.0.3:9000", Role: client.Voter},
+	}
+	info := cluster[0]
+
+	membership, err := survivingNodes(info, cluster, []string{"10.0.0.1:9000", " 2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []client.NodeInfo{
+		{ID: 1, Address: "10.0.0.1:9000", Role: client.Voter},
+		{ID: 2, Address: "10.0.0.2:9000", Role: client.Voter},
+	}
+	if !reflect.DeepEqual(membership, expected) {
+		t.Fatalf("expected %v, got %v", expected, membership)
+	}
+
+	for _, nodes := range [][]string{
+		{},
+		{"10.0.0.2:9000"},
+		{"10.0.0.1:9000", "10.0.0.4:9000"},
+		{"10.0.0.1:9000", "1"},
+	} {
+		if _, err := survivingNodes(info, cluster, nodes); err == nil {
+			t.Fatalf("expected %v to be refused", nodes)
+		}
+	}
+}
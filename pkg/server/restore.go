@@ -0,0 +1,48 @@
+package server
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/canonical/go-dqlite/app"
+	"github.com/canonical/k8s-dqlite/pkg/snapshot"
+	"github.com/sirupsen/logrus"
+)
+
+// Restore replaces the contents of the datastore with the snapshot at
+// snapshotPath, as tuned by opts. It starts the dqlite node in dir, which
+// must not be running already, and the restored data is replicated to the
+// rest of the cluster.
+func Restore(ctx context.Context, dir string, enableTLS bool, snapshotPath string, opts snapshot.RestoreOptions) error {
+	var options []app.Option
+	if enableTLS {
+		listen, dial, err := loadClusterTLS(dir)
+		if err != nil {
+			return err
+		}
+		options = append(options, app.WithTLS(listen, dial))
+	}
+
+	app, err := app.New(dir, options...)
+	if err != nil {
+		return fmt.Errorf("failed to create dqlite app: %w", err)
+	}
+	defer app.Close()
+
+	if err := app.Ready(ctx); err != nil {
+		return fmt.Errorf("failed to start dqlite app: %w", err)
+	}
+
+	db, err := app.Open(ctx, "k8s")
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer db.Close()
+
+	rows, format, err := snapshot.Restore(ctx, db, snapshotPath, opts)
+	if err != nil {
+		return fmt.Errorf("failed to restore snapshot: %w", err)
+	}
+	logger.WithFields(logrus.Fields{"format": format, "rows": rows, "revision": opts.Revision}).Print("Restored snapshot")
+	return nil
+}
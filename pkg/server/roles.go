@@ -0,0 +1,92 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/canonical/go-dqlite/app"
+	"github.com/sirupsen/logrus"
+)
+
+// RolesConfig configures how dqlite assigns the roles of the nodes. The
+// leader periodically promotes and demotes the online nodes so that the
+// cluster has the desired number of voters and stand-bys, preferring the
+// candidates in the failure domains not covered yet, then the ones with the
+// lowest weight.
+type RolesConfig struct {
+	// Voters is the number of voters of the cluster, which must be odd. If
+	// zero, the dqlite default of 3 is used. All the nodes must use the
+	// same value.
+	Voters int
+	// StandBys is the number of stand-bys of the cluster. If zero, the
+	// dqlite default of 3 is used. All the nodes must use the same value.
+	StandBys int
+	// AdjustmentFrequency is the interval between the adjustments of the
+	// roles by the leader. If zero, the dqlite default of 30 seconds is
+	// used.
+	AdjustmentFrequency time.Duration
+	// FailureDomain is the failure domain of the node, such as its
+	// availability zone. If nil, it is read from the failure-domain file
+	// in the storage directory.
+	FailureDomain *uint64
+	// Weight is the weight of the node. The nodes with a higher weight are
+	// promoted last and demoted first.
+	Weight uint64
+	// EvictUnreachableAfter is the time after which the leader removes the
+	// unreachable nodes from the cluster. If zero, the nodes are never
+	// removed.
+	EvictUnreachableAfter time.Duration
+}
+
+func (c RolesConfig) validate() error {
+	if c.Voters < 0 || (c.Voters > 0 && c.Voters%2 == 0) {
+		return fmt.Errorf("the number of voters must be odd, got %d", c.Voters)
+	}
+	if c.StandBys < 0 {
+		return fmt.Errorf("the number of stand-bys must not be negative, got %d", c.StandBys)
+	}
+	if c.AdjustmentFrequency < 0 {
+		return fmt.Errorf("the roles adjustment frequency must not be negative, got %v", c.AdjustmentFrequency)
+	}
+	if c.EvictUnreachableAfter < 0 {
+		return fmt.Errorf("the eviction delay of the unreachable nodes must not be negative, got %v", c.EvictUnreachableAfter)
+	}
+	return nil
+}
+
+// options returns the options of the dqlite app setting the number of
+// voters and stand-bys, and the roles adjustment frequency.
+func (c RolesConfig) options() []app.Option {
+	var options []app.Option
+	if c.Voters > 0 {
+		options = append(options, app.WithVoters(c.Voters))
+	}
+	if c.StandBys > 0 {
+		options = append(options, app.WithStandBys(c.StandBys))
+	}
+	if c.AdjustmentFrequency > 0 {
+		options = append(options, app.WithRolesAdjustmentFrequency(c.AdjustmentFrequency))
+	}
+	if len(options) > 0 {
+		logger.WithFields(logrus.Fields{
+			"voters":               c.Voters,
+			"standbys":             c.StandBys,
+			"adjustment_frequency": c.AdjustmentFrequency,
+		}).Print("Configure dqlite roles")
+	}
+	return options
+}
+
+// setWeight sets the weight of the node, on each start like the failure
+// domain.
+func (s *Server) setWeight(ctx context.Context) error {
+	cli, err := s.app.Client(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to connect to the node: %w", err)
+	}
+	defer cli.Close()
+
+	logger.WithField("weight", s.roles.Weight).Print("Configure dqlite node weight")
+	return cli.Weight(ctx, s.roles.Weight)
+}
@@ -0,0 +1,26 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRolesConfig(t *testing.T) {
+	for _, config := range []RolesConfig{{}, {Voters: 5, StandBys: 0}, {Voters: 1, StandBys: 2, AdjustmentFrequency: time.Second}} {
+		if err := config.validate(); err != nil {
+			t.Fatalf("expected %+v to be valid: %v", config, err)
+		}
+	}
+	for _, config := range []RolesConfig{{Voters: 4}, {Voters: -1}, {StandBys: -1}, {AdjustmentFrequency: -time.Second}} {
+		if err := config.validate(); err == nil {
+			t.Fatalf("expected %+v to be refused", config)
+		}
+	}
+
+	if options := (RolesConfig{}).options(); len(options) != 0 {
+		t.Fatalf("expected the dqlite defaults, got %d options", len(options))
+	}
+	if options := (RolesConfig{Voters: 5, StandBys: 1}).options(); len(options) != 2 {
+		t.Fatalf("expected 2 options, got %d", len(options))
+	}
+}
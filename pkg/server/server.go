@@ -4,6 +4,7 @@ import (
 	"context"
 	"crypto/tls"
 	"crypto/x509"
+	"errors"
 	"fmt"
 	"net/url"
 	"os"
@@ -13,23 +14,52 @@ import (
 	"github.com/canonical/go-dqlite"
 	"github.com/canonical/go-dqlite/app"
 	"github.com/canonical/go-dqlite/client"
+	"github.com/canonical/k8s-dqlite/pkg/kine/audit"
+	"github.com/canonical/k8s-dqlite/pkg/kine/changefeed"
 	"github.com/canonical/k8s-dqlite/pkg/kine/drivers/generic"
 	"github.com/canonical/k8s-dqlite/pkg/kine/endpoint"
-	"github.com/canonical/k8s-dqlite/pkg/kine/server"
+	kine_server "github.com/canonical/k8s-dqlite/pkg/kine/server"
 	kine_tls "github.com/canonical/k8s-dqlite/pkg/kine/tls"
+	"github.com/canonical/k8s-dqlite/pkg/logging"
+	"github.com/canonical/k8s-dqlite/pkg/snapshot"
 	"github.com/sirupsen/logrus"
 )
 
+var logger = logging.Component(logging.Server)
+
 // Server is the main k8s-dqlite server.
 type Server struct {
 	// app is the dqlite application driving the server.
 	app *app.App
 
-	backend server.Backend
+	backend kine_server.Backend
+	cluster *dqliteCluster
+
+	// kineServer is the server of the kine endpoint, which is drained for
+	// up to drainTimeout on shutdown.
+	kineServer   *endpoint.Server
+	drainTimeout time.Duration
+	// cancel stops the background tasks and kine.
+	cancel context.CancelFunc
 
 	// kineConfig is the configuration to use for starting kine against the dqlite application.
 	kineConfig endpoint.Config
 
+	// peerCertificate is the certificate of the dqlite traffic, and
+	// kineCertificate the one of the kine endpoint, if TLS is enabled.
+	// They are the same unless a distinct one is configured for kine.
+	peerCertificate *tlsCertificate
+	kineCertificate *tlsCertificate
+
+	// auditConfig is the configuration of the audit log, which is
+	// disabled if it has no path.
+	auditConfig audit.Config
+	auditLog    *audit.Log
+
+	// changefeedConfig is the configuration of the changefeed, which is
+	// disabled if it has no sink.
+	changefeedConfig changefeed.Config
+
 	// storageDir is the root directory used for dqlite storage.
 	storageDir string
 	// watchAvailableStorageMinBytes is the minimum required bytes that the server will expect to be
@@ -41,16 +71,44 @@ type Server struct {
 	// actionOnLowDisk is the action to perform in case the system is running low on disk.
 	// One of "terminate", "handover", "none"
 	actionOnLowDisk string
+	// reclaimOnLowDisk compacts and vacuums the datastore first when the
+	// system is running low on disk, before actionOnLowDisk.
+	reclaimOnLowDisk bool
+
+	// roles configures the assignment of the roles of the nodes.
+	roles RolesConfig
+	// backup configures the scheduled backups, stored on backupTarget.
+	backup       BackupConfig
+	backupTarget snapshot.Target
+	// analyzeInterval is the interval between the updates of the metrics
+	// of the top-level prefixes. If zero, they are disabled.
+	analyzeInterval time.Duration
+	// verifyInterval is the interval between the background verifications
+	// of the datastore. If zero, they are disabled.
+	verifyInterval time.Duration
+	// revisions samples the current revision, to compact the revisions
+	// older than a retention on demand.
+	revisions revisionSampler
+	// dialFunc dials the other nodes.
+	dialFunc client.DialFunc
 
 	// mustStopCh is used when the server must terminate.
 	mustStopCh chan struct{}
 }
 
+// clusterIDRetryInterval is the interval between the attempts to load the
+// ID of the cluster.
+const clusterIDRetryInterval = 5 * time.Second
+
 // expectedFilesDuringInitialization is a list of files that are allowed to exist when initializing the dqlite node.
 // This is to prevent corruption that could occur by starting a new dqlite node when data already exists in the directory.
 var expectedFilesDuringInitialization = map[string]struct{}{
 	"cluster.crt":    {},
 	"cluster.key":    {},
+	"ca.crt":         {},
+	"ca.key":         {},
+	"server.crt":     {},
+	"server.key":     {},
 	"init.yaml":      {},
 	"failure-domain": {},
 	"tuning.yaml":    {},
@@ -64,23 +122,102 @@ func New(
 	diskMode bool,
 	clientSessionCacheSize uint,
 	minTLSVersion string,
+	maxTLSVersion string,
+	tlsCipherSuites []string,
+	peerCertFile string,
+	peerKeyFile string,
+	peerCAFile string,
+	kineCertFile string,
+	kineKeyFile string,
+	kineMinTLSVersion string,
+	clientCAFile string,
+	requireClientCert bool,
 	watchAvailableStorageInterval time.Duration,
 	watchAvailableStorageMinBytes uint64,
 	lowAvailableStorageAction string,
+	roles RolesConfig,
+	raft RaftConfig,
+	backup BackupConfig,
 	connectionPoolConfig generic.ConnectionPoolConfig,
 	watchQueryTimeout time.Duration,
+	compactInterval time.Duration,
+	pollInterval time.Duration,
+	maxPollInterval time.Duration,
+	writeBatchSize int,
+	watchCacheSize int,
+	watchQueueSize int,
+	readTimeout time.Duration,
+	writeTimeout time.Duration,
+	compactTimeout time.Duration,
+	slowQueryThreshold time.Duration,
+	slowQueryRedactArgs bool,
+	compactBatchSize int64,
+	compactBatchInterval time.Duration,
+	compactRetentionDuration time.Duration,
+	compactRetentionRevisions int64,
+	vacuumInterval time.Duration,
+	vacuumMode string,
+	vacuumFreePages int64,
+	readBarrier bool,
+	compression string,
+	compressionThreshold int,
+	chunkSize int,
+	dedupOldValues bool,
+	events EventsConfig,
+	quotaBackendBytes int64,
+	maxRequestBytes int,
+	maxTxnOps int,
+	maxInflightRequests int,
+	watchCreateRate float64,
+	maxWatchStreams int,
+	grpcOptions endpoint.GRPCOptions,
+	watchProgressNotifyInterval time.Duration,
+	drainTimeout time.Duration,
+	auditConfig audit.Config,
+	changefeedSink string,
+	analyzeInterval time.Duration,
+	verifyInterval time.Duration,
 ) (*Server, error) {
 	var (
 		options         []app.Option
+		dialFunc        = client.DefaultDialFunc
 		kineConfig      endpoint.Config
-		compactInterval *time.Duration
-		pollInterval    *time.Duration
+		peerCertificate *tlsCertificate
+		kineCertificate *tlsCertificate
 	)
 
-	switch lowAvailableStorageAction {
-	case "none", "handover", "terminate":
-	default:
-		return nil, fmt.Errorf("unsupported low available storage action %v (supported values are none, handover, terminate)", lowAvailableStorageAction)
+	if clientCAFile != "" && !enableTLS {
+		return nil, fmt.Errorf("client certificate authentication requires TLS")
+	}
+	if (peerCertFile == "") != (peerKeyFile == "") {
+		return nil, fmt.Errorf("the peer certificate and key files must be set together")
+	}
+	if (kineCertFile == "") != (kineKeyFile == "") {
+		return nil, fmt.Errorf("the kine certificate and key files must be set together")
+	}
+	if requireClientCert && clientCAFile == "" {
+		return nil, fmt.Errorf("requiring client certificates requires a client CA file")
+	}
+
+	reclaimOnLowDisk, lowAvailableStorageAction, err := parseLowStorageAction(lowAvailableStorageAction)
+	if err != nil {
+		return nil, err
+	}
+	if err := roles.validate(); err != nil {
+		return nil, err
+	}
+	raftOptions, err := raft.options()
+	if err != nil {
+		return nil, err
+	}
+	if err := backup.validate(); err != nil {
+		return nil, err
+	}
+	var backupTarget snapshot.Target
+	if backup.Interval > 0 {
+		if backupTarget, err = snapshot.NewTarget(backup.Target); err != nil {
+			return nil, err
+		}
 	}
 
 	if mustInit, err := fileExists(dir, "init.yaml"); err != nil {
@@ -112,7 +249,7 @@ func New(
 			return nil, fmt.Errorf("failed to remove init.yaml after init: %w", err)
 		}
 
-		logrus.WithFields(logrus.Fields{"address": init.Address, "cluster": init.Cluster}).Print("Will initialize dqlite node")
+		logger.WithFields(logrus.Fields{"address": init.Address, "cluster": init.Cluster}).Print("Will initialize dqlite node")
 
 		options = append(options, app.WithAddress(init.Address), app.WithCluster(init.Cluster))
 	} else if mustUpdate, err := fileExists(dir, "update.yaml"); err != nil {
@@ -135,7 +272,7 @@ func New(
 			return nil, fmt.Errorf("failed to read info.yaml: %w", err)
 		}
 
-		logrus.WithFields(logrus.Fields{"old_address": info.Address, "new_address": update.Address}).Print("Will update address of dqlite node")
+		logger.WithFields(logrus.Fields{"old_address": info.Address, "new_address": update.Address}).Print("Will update address of dqlite node")
 
 		// update node address
 		info.Address = update.Address
@@ -161,67 +298,112 @@ func New(
 
 	// handle failure-domain
 	var failureDomain uint64
-	if exists, err := fileExists(dir, "failure-domain"); err != nil {
+	if roles.FailureDomain != nil {
+		failureDomain = *roles.FailureDomain
+	} else if exists, err := fileExists(dir, "failure-domain"); err != nil {
 		return nil, fmt.Errorf("failed to check failure-domain: %w", err)
 	} else if exists {
 		if err := fileUnmarshal(&failureDomain, dir, "failure-domain"); err != nil {
 			return nil, fmt.Errorf("failed to parse failure-domain from file: %w", err)
 		}
 	}
-	logrus.WithField("failure-domain", failureDomain).Print("Configure dqlite failure domain")
+	logger.WithField("failure-domain", failureDomain).Print("Configure dqlite failure domain")
 	options = append(options, app.WithFailureDomain(failureDomain))
+	options = append(options, roles.options()...)
+	options = append(options, app.WithLogFunc(dqliteLogFunc))
 
 	// handle TLS
 	if enableTLS {
 		crtFile := filepath.Join(dir, "cluster.crt")
 		keyFile := filepath.Join(dir, "cluster.key")
-
-		keypair, err := tls.LoadX509KeyPair(crtFile, keyFile)
-		if err != nil {
-			return nil, fmt.Errorf("failed to load keypair from cluster.crt and cluster.key: %w", err)
+		if peerCertFile != "" {
+			crtFile, keyFile = peerCertFile, peerKeyFile
 		}
-		crtPEM, err := os.ReadFile(crtFile)
-		if err != nil {
-			return nil, fmt.Errorf("failed to read cluster.crt: %w", err)
-		}
-		pool := x509.NewCertPool()
-		if !pool.AppendCertsFromPEM(crtPEM) {
-			return nil, fmt.Errorf("failed to add certificate to pool")
+		caFile := crtFile
+		if peerCAFile != "" {
+			caFile = peerCAFile
 		}
 
-		listen, dial := app.SimpleTLSConfig(keypair, pool)
+		var err error
+		peerCertificate, err = newTLSCertificate(crtFile, keyFile, caFile)
+		if err != nil {
+			return nil, err
+		}
+		listen, dial := peerCertificate.peerTLSConfig()
 
 		if clientSessionCacheSize > 0 {
-			logrus.WithField("cache_size", clientSessionCacheSize).Print("Use TLS ClientSessionCache")
+			logger.WithField("cache_size", clientSessionCacheSize).Print("Use TLS ClientSessionCache")
 			dial.ClientSessionCache = tls.NewLRUClientSessionCache(int(clientSessionCacheSize))
 		} else {
-			logrus.Print("Disable TLS ClientSessionCache")
+			logger.Print("Disable TLS ClientSessionCache")
 			dial.ClientSessionCache = nil
 		}
 
-		switch minTLSVersion {
-		case "tls10":
-			listen.MinVersion = tls.VersionTLS10
-		case "tls11":
-			listen.MinVersion = tls.VersionTLS11
-		case "", "tls12":
+		if minTLSVersion == "" {
 			minTLSVersion = "tls12"
-			listen.MinVersion = tls.VersionTLS12
-		case "tls13":
-			listen.MinVersion = tls.VersionTLS13
-		default:
-			return nil, fmt.Errorf("unsupported TLS version %v (supported values are tls10, tls11, tls12, tls13)", minTLSVersion)
 		}
-		logrus.WithField("min_tls_version", minTLSVersion).Print("Enable TLS")
+		if listen.MinVersion, listen.MaxVersion, err = tlsVersions(minTLSVersion, maxTLSVersion); err != nil {
+			return nil, err
+		}
+		cipherSuites, err := parseCipherSuites(tlsCipherSuites)
+		if err != nil {
+			return nil, err
+		}
+		listen.CipherSuites = cipherSuites
+		dial.MaxVersion, dial.CipherSuites = listen.MaxVersion, cipherSuites
+		logger.WithFields(logrus.Fields{
+			"cert_file":       crtFile,
+			"ca_file":         caFile,
+			"min_tls_version": minTLSVersion,
+			"max_tls_version": maxTLSVersion,
+		}).Print("Enable TLS")
+
+		kineCertificate = peerCertificate
+		if kineCertFile != "" {
+			if kineCertificate, err = newTLSCertificate(kineCertFile, kineKeyFile, ""); err != nil {
+				return nil, err
+			}
+		}
+		if kineMinTLSVersion == "" {
+			kineMinTLSVersion = minTLSVersion
+		}
+		kineMinVersion, kineMaxVersion, err := tlsVersions(kineMinTLSVersion, maxTLSVersion)
+		if err != nil {
+			return nil, err
+		}
+
+		// The kine endpoint serves TLS if it has its own certificate or
+		// authenticates its clients.
+		if kineCertFile != "" || clientCAFile != "" {
+			var clientCAs *x509.CertPool
+			if clientCAFile != "" {
+				if clientCAs, err = loadCertPool(clientCAFile); err != nil {
+					return nil, fmt.Errorf("failed to load client CA: %w", err)
+				}
+			}
+			kineConfig.ServerTLSConfig = kineCertificate.endpointTLSConfig(clientCAs, requireClientCert)
+			kineConfig.ServerTLSConfig.MinVersion = kineMinVersion
+			kineConfig.ServerTLSConfig.MaxVersion = kineMaxVersion
+			kineConfig.ServerTLSConfig.CipherSuites = cipherSuites
+			logger.WithFields(logrus.Fields{
+				"cert_file":           kineCertificate.crtFile,
+				"client_ca_file":      clientCAFile,
+				"require_client_cert": requireClientCert,
+				"min_tls_version":     kineMinTLSVersion,
+			}).Print("Enable TLS on the kine endpoint")
+		}
 
 		kineConfig.Config = kine_tls.Config{
 			CertFile: crtFile,
 			KeyFile:  keyFile,
 		}
+		dialFunc = client.DialFuncWithTLS(client.DefaultDialFunc, dial)
 		options = append(options, app.WithTLS(listen, dial))
 	}
 	// set datastore connection pool options
 	kineConfig.ConnectionPoolConfig = connectionPoolConfig
+	// handle raft tuning, which tuning.yaml overrides
+	options = append(options, raftOptions...)
 	// handle tuning parameters
 	if exists, err := fileExists(dir, "tuning.yaml"); err != nil {
 		return nil, fmt.Errorf("failed to check for tuning.yaml: %w", err)
@@ -232,7 +414,7 @@ func New(
 		}
 
 		if v := tuning.Snapshot; v != nil {
-			logrus.WithFields(logrus.Fields{"threshold": v.Threshold, "trailing": v.Trailing}).Print("Configure dqlite raft snapshot parameters")
+			logger.WithFields(logrus.Fields{"threshold": v.Threshold, "trailing": v.Trailing}).Print("Configure dqlite raft snapshot parameters")
 			options = append(options, app.WithSnapshotParams(dqlite.SnapshotParams{
 				Threshold: v.Threshold,
 				Trailing:  v.Trailing,
@@ -240,21 +422,29 @@ func New(
 		}
 
 		if v := tuning.NetworkLatency; v != nil {
-			logrus.WithField("latency", *v).Print("Configure dqlite average one-way network latency")
+			logger.WithField("latency", *v).Print("Configure dqlite average one-way network latency")
 			options = append(options, app.WithNetworkLatency(*v))
 		}
 
-		// these are set in the kine endpoint config below
-		compactInterval = tuning.KineCompactInterval
-		pollInterval = tuning.KinePollInterval
+		// these are set in the kine endpoint config below, and take
+		// precedence over the command line
+		if v := tuning.KineCompactInterval; v != nil {
+			compactInterval = *v
+		}
+		if v := tuning.KinePollInterval; v != nil {
+			pollInterval = *v
+		}
+		if v := tuning.KineMaxPollInterval; v != nil {
+			maxPollInterval = *v
+		}
 	}
 
 	if diskMode {
-		logrus.Print("Enable dqlite disk mode operation")
+		logger.Print("Enable dqlite disk mode operation")
 		options = append(options, app.WithDiskMode(true))
 
 		// TODO: remove after disk mode is stable
-		logrus.Warn("dqlite disk mode operation is current at an experimental state and MUST NOT be used in production. Expect data loss.")
+		logger.Warn("dqlite disk mode operation is current at an experimental state and MUST NOT be used in production. Expect data loss.")
 	}
 
 	app, err := app.New(dir, options...)
@@ -264,58 +454,227 @@ func New(
 
 	params := make(url.Values)
 	params["driver-name"] = []string{app.Driver()}
-	if v := compactInterval; v != nil {
-		params["compact-interval"] = []string{fmt.Sprintf("%v", *v)}
+	if compactInterval > 0 {
+		params["compact-interval"] = []string{fmt.Sprintf("%v", compactInterval)}
+	}
+	if pollInterval > 0 {
+		params["poll-interval"] = []string{fmt.Sprintf("%v", pollInterval)}
+	}
+	if maxPollInterval > 0 {
+		params["max-poll-interval"] = []string{fmt.Sprintf("%v", maxPollInterval)}
+	}
+	if writeBatchSize != 0 {
+		params["write-batch-size"] = []string{fmt.Sprintf("%v", writeBatchSize)}
+	}
+	if watchCacheSize != 0 {
+		params["watch-cache-size"] = []string{fmt.Sprintf("%v", watchCacheSize)}
+	}
+	if watchQueueSize > 0 {
+		params["watch-queue-size"] = []string{fmt.Sprintf("%v", watchQueueSize)}
+	}
+	if readTimeout > 0 {
+		params["query-timeout-read"] = []string{fmt.Sprintf("%v", readTimeout)}
+	}
+	if writeTimeout > 0 {
+		params["query-timeout-write"] = []string{fmt.Sprintf("%v", writeTimeout)}
+	}
+	if compactTimeout > 0 {
+		params["query-timeout-compact"] = []string{fmt.Sprintf("%v", compactTimeout)}
 	}
-	if v := pollInterval; v != nil {
-		params["poll-interval"] = []string{fmt.Sprintf("%v", *v)}
+	if slowQueryThreshold > 0 {
+		params["slow-query-threshold"] = []string{fmt.Sprintf("%v", slowQueryThreshold)}
+	}
+	if slowQueryRedactArgs {
+		params["slow-query-redact-args"] = []string{"true"}
 	}
 
 	params["watch-query-timeout"] = []string{fmt.Sprintf("%v", watchQueryTimeout)}
+	if compactBatchSize > 0 {
+		params["compact-batch-size"] = []string{fmt.Sprintf("%v", compactBatchSize)}
+	}
+	if compactBatchInterval > 0 {
+		params["compact-batch-interval"] = []string{fmt.Sprintf("%v", compactBatchInterval)}
+	}
+	if compactRetentionDuration > 0 {
+		params["compact-retention-duration"] = []string{fmt.Sprintf("%v", compactRetentionDuration)}
+	}
+	if compactRetentionRevisions > 0 {
+		params["compact-retention-revisions"] = []string{fmt.Sprintf("%v", compactRetentionRevisions)}
+	}
+	if vacuumInterval > 0 {
+		params["vacuum-interval"] = []string{fmt.Sprintf("%v", vacuumInterval)}
+	}
+	if vacuumMode != "" {
+		params["vacuum-mode"] = []string{vacuumMode}
+	}
+	if vacuumFreePages > 0 {
+		params["vacuum-free-pages"] = []string{fmt.Sprintf("%v", vacuumFreePages)}
+	}
+	if readBarrier {
+		params["read-barrier"] = []string{"true"}
+	}
+	if compression != "" {
+		params["compression"] = []string{compression}
+		params["compression-threshold"] = []string{fmt.Sprintf("%v", compressionThreshold)}
+	}
+	if chunkSize > 0 {
+		params["chunk-size"] = []string{fmt.Sprintf("%v", chunkSize)}
+	}
+	if dedupOldValues {
+		params["dedup-old-values"] = []string{"true"}
+	}
+	events.addParams(params)
 
 	kineConfig.Listener = listen
+	peerScheme := "http"
+	if enableTLS {
+		peerScheme = "https"
+	}
+	cluster := &dqliteCluster{app: app, peerScheme: peerScheme, clientURL: listen, dir: dir}
+	kineConfig.Cluster = cluster
+	kineConfig.Quota = kine_server.NewQuota(quotaBackendBytes)
+	kineConfig.RequestLimits = kine_server.RequestLimits{
+		MaxRequestBytes:     maxRequestBytes,
+		MaxTxnOps:           maxTxnOps,
+		MaxInflightRequests: maxInflightRequests,
+		WatchCreateRate:     watchCreateRate,
+		MaxWatchStreams:     maxWatchStreams,
+	}
+	kineConfig.GRPCOptions = grpcOptions
+	kineConfig.WatchProgressNotifyInterval = watchProgressNotifyInterval
 	kineConfig.Endpoint = fmt.Sprintf("dqlite://k8s?%s", params.Encode())
 
 	return &Server{
-		app:        app,
-		kineConfig: kineConfig,
+		app:             app,
+		cluster:         cluster,
+		kineConfig:      kineConfig,
+		peerCertificate: peerCertificate,
+		kineCertificate: kineCertificate,
+		auditConfig:     auditConfig,
+		changefeedConfig: changefeed.Config{
+			Sink:       changefeedSink,
+			CursorPath: filepath.Join(dir, "changefeed-cursor"),
+		},
+		drainTimeout:    drainTimeout,
+		roles:           roles,
+		backup:          backup,
+		backupTarget:    backupTarget,
+		analyzeInterval: analyzeInterval,
+		verifyInterval:  verifyInterval,
+		dialFunc:        dialFunc,
 
 		storageDir:                    dir,
 		watchAvailableStorageMinBytes: watchAvailableStorageMinBytes,
 		watchAvailableStorageInterval: watchAvailableStorageInterval,
 		actionOnLowDisk:               lowAvailableStorageAction,
+		reclaimOnLowDisk:              reclaimOnLowDisk,
 
 		mustStopCh: make(chan struct{}, 1),
 	}, nil
 }
 
+// transferLeadership transfers the leadership to an online voter, if the
+// node is the leader, without changing the roles of the nodes as
+// app.Handover does. It returns the ID of the leader.
+func (s *Server) transferLeadership(ctx context.Context) (uint64, error) {
+	cli, err := s.app.Leader(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to connect to the leader: %w", err)
+	}
+	defer cli.Close()
+
+	leader, err := cli.Leader(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get the leader: %w", err)
+	}
+	if leader.ID != s.app.ID() {
+		logger.WithField("leader", leader.ID).Print("Not the leader, skip handover")
+		return leader.ID, nil
+	}
+
+	nodes, err := cli.Cluster(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list the nodes: %w", err)
+	}
+	var errs []error
+	for _, node := range nodes {
+		if node.ID == leader.ID || node.Role != client.Voter {
+			continue
+		}
+		logger.WithField("leader", node.ID).Print("Transfer dqlite leadership")
+		if err := cli.Transfer(ctx, node.ID); err != nil {
+			// The voter might be offline, so the next one is tried.
+			errs = append(errs, fmt.Errorf("failed to transfer leadership to %d: %w", node.ID, err))
+			continue
+		}
+		return node.ID, nil
+	}
+	if len(errs) == 0 {
+		return 0, fmt.Errorf("no other voter to transfer the leadership to")
+	}
+	return 0, errors.Join(errs...)
+}
+
+// loadClusterID loads the ID of the cluster, retrying until the datastore
+// accepts writes. The responses have no cluster ID until then.
+func (s *Server) loadClusterID(ctx context.Context) {
+	for {
+		err := s.cluster.loadID(ctx, s.backend)
+		if err == nil {
+			logger.WithField("cluster_id", s.cluster.ClusterID()).Print("Loaded cluster ID")
+			return
+		}
+		logger.WithError(err).Warning("Failed to load cluster ID")
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(clusterIDRetryInterval):
+		}
+	}
+}
+
 func (s *Server) watchAvailableStorageSize(ctx context.Context) {
-	logrus := logrus.WithField("dir", s.storageDir)
+	logger := logger.WithField("dir", s.storageDir)
 
 	if s.watchAvailableStorageInterval <= 0 {
-		logrus.Info("Disable periodic check for available disk size")
+		logger.Info("Disable periodic check for available disk size")
 		return
 	}
 
-	logrus.WithField("interval", s.watchAvailableStorageInterval).Info("Enable periodic check for available disk size")
+	logger.WithField("interval", s.watchAvailableStorageInterval).Info("Enable periodic check for available disk size")
+	// reclaimedAt is the time space was last reclaimed, which is done at
+	// most every reclaim interval.
+	var reclaimedAt time.Time
 	for {
 		select {
 		case <-ctx.Done():
 			return
 		case <-time.After(s.watchAvailableStorageInterval):
+			s.updateStorageMetrics(ctx)
 			if err := checkAvailableStorageSize(s.storageDir, s.watchAvailableStorageMinBytes); err != nil {
 				err := fmt.Errorf("periodic check for available disk storage failed: %w", err)
 
+				if s.reclaimOnLowDisk && time.Since(reclaimedAt) >= lowStorageReclaimInterval {
+					logger.WithError(err).Warning("Reclaiming datastore space")
+					reclaimedAt = time.Now()
+					s.reclaimStorage(ctx)
+					if err := checkAvailableStorageSize(s.storageDir, s.watchAvailableStorageMinBytes); err == nil {
+						logger.Info("Reclaimed enough disk storage")
+						continue
+					}
+				}
+
 				switch s.actionOnLowDisk {
 				case "none":
-					logrus.WithError(err).Info("Ignoring failed available disk storage check")
+					logger.WithError(err).Info("Ignoring failed available disk storage check")
 				case "handover":
-					logrus.WithError(err).Info("Handover dqlite leadership role")
+					logger.WithError(err).Info("Handover dqlite leadership role")
 					if err := s.app.Handover(ctx); err != nil {
-						logrus.WithError(err).Warning("Failed to handover dqlite leadership")
+						logger.WithError(err).Warning("Failed to handover dqlite leadership")
 					}
 				case "terminate":
-					logrus.WithError(err).Error("Terminating due to failed available disk storage check")
+					logger.WithError(err).Error("Terminating due to failed available disk storage check")
 					s.mustStopCh <- struct{}{}
 				}
 			}
@@ -323,6 +682,22 @@ func (s *Server) watchAvailableStorageSize(ctx context.Context) {
 	}
 }
 
+// dqliteLogger logs the messages of dqlite.
+var dqliteLogger = logging.Component(logging.Dqlite)
+
+func dqliteLogFunc(l client.LogLevel, format string, a ...interface{}) {
+	switch l {
+	case client.LogDebug:
+		dqliteLogger.Debugf(format, a...)
+	case client.LogInfo:
+		dqliteLogger.Infof(format, a...)
+	case client.LogWarn:
+		dqliteLogger.Warnf(format, a...)
+	case client.LogError:
+		dqliteLogger.Errorf(format, a...)
+	}
+}
+
 // MustStop returns a channel that can be used to check whether the server must stop.
 func (s *Server) MustStop() <-chan struct{} {
 	return s.mustStopCh
@@ -330,37 +705,142 @@ func (s *Server) MustStop() <-chan struct{} {
 
 // Start the dqlite node and the kine machinery.
 func (s *Server) Start(ctx context.Context) error {
+	// The background tasks and kine run until Shutdown, once the requests
+	// being served drained.
+	ctx, s.cancel = context.WithCancel(ctx)
+
 	if err := s.app.Ready(ctx); err != nil {
 		return fmt.Errorf("failed to start dqlite app: %w", err)
 	}
-	logrus.WithFields(logrus.Fields{"id": s.app.ID(), "address": s.app.Address()}).Print("Started dqlite")
+	logger.WithFields(logrus.Fields{"id": s.app.ID(), "address": s.app.Address()}).Print("Started dqlite")
+	if err := s.setWeight(ctx); err != nil {
+		// The node still runs, only the candidates for its role are
+		// ordered differently.
+		logger.WithError(err).Warning("Failed to set dqlite node weight")
+	}
+
+	if s.auditConfig.Path != "" {
+		auditLog, err := audit.New(s.auditConfig)
+		if err != nil {
+			return err
+		}
+		logger.WithField("path", s.auditConfig.Path).Print("Enable audit log")
+		s.auditLog = auditLog
+		s.kineConfig.AuditLog = auditLog
+	}
 
-	logrus.WithField("config", s.kineConfig).Debug("Starting kine")
-	_, backend, err := endpoint.ListenAndReturnBackend(ctx, s.kineConfig)
+	logger.WithField("config", s.kineConfig).Debug("Starting kine")
+	etcdConfig, backend, err := endpoint.ListenAndReturnBackend(ctx, s.kineConfig)
 	if err != nil {
 		return fmt.Errorf("failed to start kine: %w", err)
 	}
-	logrus.WithFields(logrus.Fields{"address": s.kineConfig.Listener, "database": s.kineConfig.Endpoint}).Print("Started kine")
+	logger.WithFields(logrus.Fields{"address": s.kineConfig.Listener, "database": s.kineConfig.Endpoint}).Print("Started kine")
 
 	s.backend = backend
+	s.kineServer = etcdConfig.Server
 
+	go s.loadClusterID(ctx)
 	go s.watchAvailableStorageSize(ctx)
+	go s.rebalance(ctx)
+	go s.updateRaftMetrics(ctx)
+	go s.scheduleBackups(ctx)
+	go s.updatePrefixMetrics(ctx)
+	go s.verifyPeriodically(ctx)
+	go s.sampleRevisions(ctx)
+	if s.changefeedConfig.Sink != "" {
+		feed, err := changefeed.New(s.changefeedConfig, backend)
+		if err != nil {
+			return err
+		}
+		// The sink is not logged, as webhooks may hold credentials.
+		logger.Print("Enable changefeed")
+		go feed.Run(ctx)
+	}
+	for _, certificate := range s.certificates() {
+		go certificate.watch(ctx, certificateCheckInterval)
+	}
+
+	s.notifyReady(ctx)
 
 	return nil
 }
 
+// Reload changes the settings of the running server that can be changed at
+// runtime, and reloads the cluster certificate from the storage directory.
+// As on start, the compaction interval of tuning.yaml takes precedence.
+func (s *Server) Reload(compactInterval, compactRetentionDuration time.Duration, compactRetentionRevisions, quotaBackendBytes int64) error {
+	if exists, err := fileExists(s.storageDir, "tuning.yaml"); err != nil {
+		return fmt.Errorf("failed to check for tuning.yaml: %w", err)
+	} else if exists {
+		var tuning TuningConfiguration
+		if err := fileUnmarshal(&tuning, s.storageDir, "tuning.yaml"); err != nil {
+			return fmt.Errorf("failed to read tuning.yaml: %w", err)
+		}
+		if v := tuning.KineCompactInterval; v != nil {
+			compactInterval = *v
+		}
+	}
+
+	logger.WithFields(logrus.Fields{
+		"compact_interval":            compactInterval,
+		"compact_retention_duration":  compactRetentionDuration,
+		"compact_retention_revisions": compactRetentionRevisions,
+		"quota_backend_bytes":         quotaBackendBytes,
+	}).Print("Reload configuration")
+	s.backend.SetCompaction(kine_server.CompactionConfig{
+		Interval:           compactInterval,
+		RetentionDuration:  compactRetentionDuration,
+		RetentionRevisions: compactRetentionRevisions,
+	})
+	s.kineConfig.Quota.Set(quotaBackendBytes)
+
+	for _, certificate := range s.certificates() {
+		if err := certificate.reload(); err != nil {
+			return fmt.Errorf("failed to reload certificate: %w", err)
+		}
+		logger.WithField("cert_file", certificate.crtFile).Print("Reloaded certificate")
+	}
+	return nil
+}
+
+// certificates returns the distinct certificates of the server.
+func (s *Server) certificates() []*tlsCertificate {
+	if s.peerCertificate == nil {
+		return nil
+	}
+	if s.kineCertificate == s.peerCertificate {
+		return []*tlsCertificate{s.peerCertificate}
+	}
+	return []*tlsCertificate{s.peerCertificate, s.kineCertificate}
+}
+
 // Shutdown cleans up any resources and attempts to hand-over and shutdown the dqlite application.
 func (s *Server) Shutdown(ctx context.Context) error {
-	logrus.Debug("Handing over dqlite leadership")
+	notifyStopping()
+	logger.Debug("Handing over dqlite leadership")
 	if err := s.app.Handover(ctx); err != nil {
-		logrus.WithError(err).Errorf("Failed to handover dqlite")
+		logger.WithError(err).Errorf("Failed to handover dqlite")
+	}
+	if s.kineServer != nil {
+		logger.WithField("timeout", s.drainTimeout).Debug("Draining kine requests")
+		drainCtx, cancel := context.WithTimeout(ctx, s.drainTimeout)
+		s.kineServer.Drain(drainCtx)
+		cancel()
 	}
-	logrus.Debug("Closing dqlite application")
+	if s.cancel != nil {
+		s.cancel()
+	}
+	logger.Debug("Closing dqlite application")
 	if err := s.app.Close(); err != nil {
 		return fmt.Errorf("failed to close dqlite app: %w", err)
 	}
 	close(s.mustStopCh)
 	s.backend.Wait()
+	if s.auditLog != nil {
+		if err := s.auditLog.Close(); err != nil {
+			return fmt.Errorf("failed to close audit log: %w", err)
+		}
+	}
 	return nil
 }
 
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"context"
+	"time"
+)
+
+// Status is the status of the node and of its cluster.
+type Status struct {
+	// ID, Address and Role are the ones of the node.
+	ID      uint64 `json:"id"`
+	Address string `json:"address"`
+	Role    string `json:"role"`
+	// Leader is the dqlite leader, if any.
+	Leader  *Member  `json:"leader,omitempty"`
+	Members []Member `json:"members"`
+	// Revision is the current revision of the datastore, and DatabaseSize
+	// the size of its database.
+	Revision     int64 `json:"revision"`
+	DatabaseSize int64 `json:"database_size"`
+	// Watches and Connections are the numbers of watches and client
+	// connections served by the kine endpoint of the node.
+	Watches     int `json:"watches"`
+	Connections int `json:"connections"`
+}
+
+// Watch is a watch served by the kine endpoint of the node.
+type Watch struct {
+	ID            int64     `json:"id"`
+	Key           string    `json:"key"`
+	StartRevision int64     `json:"start_revision,omitempty"`
+	Peer          string    `json:"peer,omitempty"`
+	Started       time.Time `json:"started"`
+}
+
+// Connection is a client connection of the kine endpoint of the node.
+type Connection struct {
+	Remote    string    `json:"remote"`
+	Connected time.Time `json:"connected"`
+	Requests  int64     `json:"requests"`
+}
+
+// Status reports the status of the node, its cluster and its datastore.
+func (s *Server) Status(ctx context.Context) (Status, error) {
+	status := Status{
+		ID:          s.app.ID(),
+		Address:     s.app.Address(),
+		Watches:     len(s.kineServer.Watches()),
+		Connections: len(s.kineServer.Connections()),
+	}
+
+	cli, err := s.app.Leader(ctx)
+	if err != nil {
+		return Status{}, err
+	}
+	defer cli.Close()
+	nodes, err := cli.Cluster(ctx)
+	if err != nil {
+		return Status{}, err
+	}
+	leader, err := cli.Leader(ctx)
+	if err != nil {
+		return Status{}, err
+	}
+	for _, node := range nodes {
+		member := Member{ID: node.ID, Address: node.Address, Role: node.Role.String()}
+		status.Members = append(status.Members, member)
+		if node.ID == status.ID {
+			status.Role = member.Role
+		}
+		if leader != nil && node.ID == leader.ID {
+			status.Leader = &member
+		}
+	}
+
+	if status.Revision, err = s.backend.CurrentRevision(ctx); err != nil {
+		return Status{}, err
+	}
+	if status.DatabaseSize, err = s.backend.DbSize(ctx); err != nil {
+		return Status{}, err
+	}
+	return status, nil
+}
+
+// Watches lists the watches served by the kine endpoint of the node.
+func (s *Server) Watches() []Watch {
+	infos := s.kineServer.Watches()
+	watches := make([]Watch, 0, len(infos))
+	for _, info := range infos {
+		watches = append(watches, Watch{
+			ID:            info.ID,
+			Key:           info.Key,
+			StartRevision: info.StartRevision,
+			Peer:          info.Peer,
+			Started:       info.Started,
+		})
+	}
+	return watches
+}
+
+// Connections lists the client connections of the kine endpoint of the
+// node.
+func (s *Server) Connections() []Connection {
+	infos := s.kineServer.Connections()
+	connections := make([]Connection, 0, len(infos))
+	for _, info := range infos {
+		connections = append(connections, Connection{
+			Remote:    info.Remote,
+			Connected: info.Connected,
+			Requests:  info.Requests,
+		})
+	}
+	return connections
+}
@@ -0,0 +1,106 @@
+package server
+
+import (
+	"context"
+	"os"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	metricsStorageAvailableBytes = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "k8s_dqlite_storage_available_bytes",
+		Help: "Available space (in bytes) of the file system of the storage directory",
+	})
+	metricsStorageDatabaseFileBytes = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "k8s_dqlite_storage_database_file_bytes",
+		Help: "Size (in bytes) of the database file, free pages included",
+	})
+	metricsStorageWALBytes = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "k8s_dqlite_storage_wal_bytes",
+		Help: "Size (in bytes) of the write-ahead logs of the databases in the storage directory, which are only stored on disk in disk mode",
+	})
+	metricsStorageRaftBytes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "k8s_dqlite_storage_raft_bytes",
+		Help: "Size (in bytes) of the raft files in the storage directory by kind (closed_segments, open_segments or snapshots)",
+	}, []string{"kind"})
+)
+
+func init() {
+	prometheus.MustRegister(
+		metricsStorageAvailableBytes,
+		metricsStorageDatabaseFileBytes,
+		metricsStorageWALBytes,
+		metricsStorageRaftBytes,
+	)
+}
+
+// storageUsage is the space used by the files of the storage directory.
+type storageUsage struct {
+	// ClosedSegments and OpenSegments are the sizes of the raft segments,
+	// and Snapshots the size of the raft snapshots and their metadata.
+	ClosedSegments int64
+	OpenSegments   int64
+	Snapshots      int64
+	// WAL is the size of the write-ahead logs of the databases.
+	WAL int64
+}
+
+// readStorageUsage reads the space used by the files of dir: the closed
+// raft segments are named after the indexes of their first and last
+// entries, the open segments are named open-N, the snapshots snapshot-*
+// and the write-ahead logs end with -wal.
+func readStorageUsage(dir string) (storageUsage, error) {
+	var usage storageUsage
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return storageUsage{}, err
+	}
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		info, err := entry.Info()
+		if os.IsNotExist(err) {
+			// The file was removed since the directory was read.
+			continue
+		} else if err != nil {
+			return storageUsage{}, err
+		}
+		switch name := entry.Name(); {
+		case strings.HasPrefix(name, "snapshot-"):
+			usage.Snapshots += info.Size()
+		case strings.HasPrefix(name, "open-"):
+			usage.OpenSegments += info.Size()
+		case strings.HasSuffix(name, "-wal"):
+			usage.WAL += info.Size()
+		case len(name) == 33 && name[16] == '-':
+			usage.ClosedSegments += info.Size()
+		}
+	}
+	return usage, nil
+}
+
+// updateStorageMetrics updates the metrics of the space used by the
+// datastore, and of the available space of its storage directory.
+func (s *Server) updateStorageMetrics(ctx context.Context) {
+	if available, err := availableStorageSize(s.storageDir); err != nil {
+		logger.WithError(err).Debug("Failed to get available disk size")
+	} else {
+		metricsStorageAvailableBytes.Set(float64(available))
+	}
+	if size, err := s.backend.DbFileSize(ctx); err != nil {
+		logger.WithError(err).Debug("Failed to get database file size")
+	} else {
+		metricsStorageDatabaseFileBytes.Set(float64(size))
+	}
+	if usage, err := readStorageUsage(s.storageDir); err != nil {
+		logger.WithError(err).Debug("Failed to read storage usage")
+	} else {
+		metricsStorageWALBytes.Set(float64(usage.WAL))
+		metricsStorageRaftBytes.WithLabelValues("closed_segments").Set(float64(usage.ClosedSegments))
+		metricsStorageRaftBytes.WithLabelValues("open_segments").Set(float64(usage.OpenSegments))
+		metricsStorageRaftBytes.WithLabelValues("snapshots").Set(float64(usage.Snapshots))
+	}
+}
@@ -0,0 +1,37 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadStorageUsage(t *testing.T) {
+	dir := t.TempDir()
+	for name, size := range map[string]int{
+		"0000000000000001-0000000000001024":  100,
+		"0000000000001025-0000000000002048":  200,
+		"open-1":                             50,
+		"open-2":                             25,
+		"snapshot-3-1500-1700000000000":      400,
+		"snapshot-3-1500-1700000000000.meta": 10,
+		"k8s":                                1000,
+		"k8s-wal":                            300,
+		"info.yaml":                          5,
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), make([]byte, size), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, "open-3"), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	usage, err := readStorageUsage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := (storageUsage{ClosedSegments: 300, OpenSegments: 75, Snapshots: 410, WAL: 300}); usage != expected {
+		t.Fatalf("expected %+v, got %+v", expected, usage)
+	}
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/coreos/go-systemd/v22/daemon"
+)
+
+// notifyReady tells systemd that the server is ready, and starts pinging
+// the systemd watchdog if the unit enables it. Both are no-ops if the
+// server is not run by systemd.
+func (s *Server) notifyReady(ctx context.Context) {
+	if ok, err := daemon.SdNotify(false, daemon.SdNotifyReady); err != nil {
+		logger.WithError(err).Warning("Failed to notify systemd")
+	} else if ok {
+		logger.Debug("Notified systemd")
+	}
+
+	interval, err := daemon.SdWatchdogEnabled(false)
+	if err != nil {
+		logger.WithError(err).Warning("Failed to read systemd watchdog settings")
+		return
+	}
+	if interval <= 0 {
+		return
+	}
+	logger.WithField("interval", interval).Info("Enable systemd watchdog")
+	go s.pingWatchdog(ctx, interval/2)
+}
+
+// pingWatchdog pings the systemd watchdog as long as the poll loop is alive,
+// so that systemd restarts the server if it gets stuck.
+func (s *Server) pingWatchdog(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.backend.CheckPollLoop(); err != nil {
+				logger.WithError(err).Warning("Skip systemd watchdog ping")
+				continue
+			}
+			if _, err := daemon.SdNotify(false, daemon.SdNotifyWatchdog); err != nil {
+				logger.WithError(err).Warning("Failed to ping systemd watchdog")
+			}
+		}
+	}
+}
+
+// notifyStopping tells systemd that the server is shutting down.
+func notifyStopping() {
+	if _, err := daemon.SdNotify(false, daemon.SdNotifyStopping); err != nil {
+		logger.WithError(err).Warning("Failed to notify systemd")
+	}
+}
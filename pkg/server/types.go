@@ -37,4 +37,7 @@ type TuningConfiguration struct {
 
 	// KinePollInterval is the kine poll interval.
 	KinePollInterval *time.Duration `yaml:"kine-poll-interval"`
+
+	// KineMaxPollInterval is the interval up to which kine polling backs off when idle.
+	KineMaxPollInterval *time.Duration `yaml:"kine-max-poll-interval"`
 }
@@ -1,10 +1,14 @@
 package server
 
 import (
+	"crypto/tls"
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 
+	"github.com/canonical/go-dqlite/app"
 	"golang.org/x/sys/unix"
 	"gopkg.in/yaml.v2"
 )
@@ -42,12 +46,122 @@ func fileExists(path ...string) (bool, error) {
 }
 
 func checkAvailableStorageSize(storageDir string, minimumBytes uint64) error {
-	var stat unix.Statfs_t
-	if err := unix.Statfs(storageDir, &stat); err != nil {
-		return fmt.Errorf("failed to check available disk size: %w", err)
+	availableBytes, err := availableStorageSize(storageDir)
+	if err != nil {
+		return err
 	}
-	if availableBytes := stat.Bavail * uint64(stat.Bsize); availableBytes < minimumBytes {
+	if availableBytes < minimumBytes {
 		return fmt.Errorf("available disk size (%v bytes) is below minimum required (%v bytes)", availableBytes, minimumBytes)
 	}
 	return nil
 }
+
+// availableStorageSize returns the available space of the file system of
+// storageDir, in bytes.
+func availableStorageSize(storageDir string) (uint64, error) {
+	var stat unix.Statfs_t
+	if err := unix.Statfs(storageDir, &stat); err != nil {
+		return 0, fmt.Errorf("failed to check available disk size: %w", err)
+	}
+	return stat.Bavail * uint64(stat.Bsize), nil
+}
+
+// parseLowStorageAction parses the action taken when the available storage
+// is low: none, handover, terminate, or reclaim, optionally followed by the
+// action taken if reclaiming space is not enough, as in reclaim,terminate.
+func parseLowStorageAction(action string) (reclaim bool, fallback string, err error) {
+	fallback = action
+	if rest, ok := strings.CutPrefix(action, "reclaim"); ok {
+		reclaim, fallback = true, "none"
+		if rest != "" {
+			fallback, ok = strings.CutPrefix(rest, ",")
+			if !ok {
+				fallback = action
+			}
+		}
+	}
+	switch fallback {
+	case "none", "handover", "terminate":
+		return reclaim, fallback, nil
+	}
+	return false, "", fmt.Errorf("unsupported low available storage action %v (supported values are none, handover, terminate, reclaim, reclaim,handover, reclaim,terminate)", action)
+}
+
+// loadClusterTLS loads the TLS configuration of the dqlite cluster from
+// cluster.crt and cluster.key in dir.
+func loadClusterTLS(dir string) (listen, dial *tls.Config, err error) {
+	keypair, pool, err := loadClusterCertificate(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	listen, dial = app.SimpleTLSConfig(keypair, pool)
+	return listen, dial, nil
+}
+
+// parseTLSVersion parses the name of a TLS version, such as tls12.
+func parseTLSVersion(version string) (uint16, error) {
+	switch version {
+	case "tls10":
+		return tls.VersionTLS10, nil
+	case "tls11":
+		return tls.VersionTLS11, nil
+	case "tls12":
+		return tls.VersionTLS12, nil
+	case "tls13":
+		return tls.VersionTLS13, nil
+	}
+	return 0, fmt.Errorf("unsupported TLS version %v (supported values are tls10, tls11, tls12, tls13)", version)
+}
+
+// parseCipherSuites parses the IANA names of TLS cipher suites, such as
+// TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256. The suites of TLS 1.3 are not
+// configurable, hence the names are those of the suites of TLS 1.2 and
+// below. The suites known to be insecure are accepted, with a warning.
+func parseCipherSuites(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	suites := map[string]*tls.CipherSuite{}
+	for _, suite := range tls.CipherSuites() {
+		suites[suite.Name] = suite
+	}
+	for _, suite := range tls.InsecureCipherSuites() {
+		suites[suite.Name] = suite
+	}
+
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		suite, ok := suites[strings.TrimSpace(name)]
+		if !ok {
+			return nil, fmt.Errorf("unsupported TLS cipher suite %v", name)
+		}
+		if suite.Insecure {
+			logger.WithField("cipher_suite", suite.Name).Warning("Enable insecure TLS cipher suite")
+		}
+		if slices.Equal(suite.SupportedVersions, []uint16{tls.VersionTLS13}) {
+			return nil, fmt.Errorf("TLS cipher suite %v is one of TLS 1.3, which is not configurable", suite.Name)
+		}
+		ids = append(ids, suite.ID)
+	}
+	return ids, nil
+}
+
+// tlsVersions parses the minimum and maximum TLS versions. An empty maximum
+// version allows the latest version supported.
+func tlsVersions(minVersion, maxVersion string) (uint16, uint16, error) {
+	minID, err := parseTLSVersion(minVersion)
+	if err != nil {
+		return 0, 0, err
+	}
+	if maxVersion == "" {
+		return minID, 0, nil
+	}
+	maxID, err := parseTLSVersion(maxVersion)
+	if err != nil {
+		return 0, 0, err
+	}
+	if maxID < minID {
+		return 0, 0, fmt.Errorf("maximum TLS version %v is below the minimum TLS version %v", maxVersion, minVersion)
+	}
+	return minID, maxID, nil
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"crypto/tls"
+	"slices"
+	"testing"
+)
+
+func TestParseCipherSuites(t *testing.T) {
+	suites, err := parseCipherSuites([]string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256", " TLS_RSA_WITH_AES_128_GCM_SHA256"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, tls.TLS_RSA_WITH_AES_128_GCM_SHA256}; !slices.Equal(suites, expected) {
+		t.Fatalf("expected %v, got %v", expected, suites)
+	}
+
+	for _, name := range []string{"TLS_UNKNOWN", "TLS_AES_128_GCM_SHA256"} {
+		if _, err := parseCipherSuites([]string{name}); err == nil {
+			t.Fatalf("expected %s to be refused", name)
+		}
+	}
+}
+
+func TestTLSVersions(t *testing.T) {
+	minVersion, maxVersion, err := tlsVersions("tls12", "")
+	if err != nil || minVersion != tls.VersionTLS12 || maxVersion != 0 {
+		t.Fatalf("unexpected versions %x, %x (%v)", minVersion, maxVersion, err)
+	}
+	minVersion, maxVersion, err = tlsVersions("tls12", "tls13")
+	if err != nil || minVersion != tls.VersionTLS12 || maxVersion != tls.VersionTLS13 {
+		t.Fatalf("unexpected versions %x, %x (%v)", minVersion, maxVersion, err)
+	}
+	if _, _, err := tlsVersions("tls13", "tls12"); err == nil {
+		t.Fatal("expected a maximum version below the minimum version to be refused")
+	}
+}
+
+func TestParseLowStorageAction(t *testing.T) {
+	for _, tc := range []struct {
+		action   string
+		reclaim  bool
+		fallback string
+	}{
+		{action: "none", fallback: "none"},
+		{action: "handover", fallback: "handover"},
+		{action: "terminate", fallback: "terminate"},
+		{action: "reclaim", reclaim: true, fallback: "none"},
+		{action: "reclaim,handover", reclaim: true, fallback: "handover"},
+		{action: "reclaim,terminate", reclaim: true, fallback: "terminate"},
+	} {
+		reclaim, fallback, err := parseLowStorageAction(tc.action)
+		if err != nil || reclaim != tc.reclaim || fallback != tc.fallback {
+			t.Fatalf("expected %s to be parsed as %v, %s, got %v, %s (%v)", tc.action, tc.reclaim, tc.fallback, reclaim, fallback, err)
+		}
+	}
+
+	for _, action := range []string{"", "reclaimed", "reclaim,", "reclaim,reclaim", "terminate,reclaim"} {
+		if _, _, err := parseLowStorageAction(action); err == nil {
+			t.Fatalf("expected %q to be refused", action)
+		}
+	}
+}
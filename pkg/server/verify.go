@@ -0,0 +1,120 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+)
+
+// maxLoggedViolations is the number of violations logged by the background
+// verifications.
+const maxLoggedViolations = 10
+
+var (
+	metricsCorruptionAlarm = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "k8s_dqlite_corruption_alarm",
+		Help: "Whether the last background verification of the datastore found corruptions, reported by the dqlite leader",
+	})
+	metricsVerifyViolations = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "k8s_dqlite_verify_violations",
+		Help: "Number of corruptions found by the last background verification of the datastore",
+	})
+	metricsVerifyLastSuccess = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "k8s_dqlite_verify_last_success_timestamp_seconds",
+		Help: "Time of the last completed background verification of the datastore, in seconds since the epoch",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(metricsCorruptionAlarm, metricsVerifyViolations, metricsVerifyLastSuccess)
+}
+
+// Violation is a corruption of the datastore.
+type Violation struct {
+	// Revision and Key locate the revision breaking an invariant of kine,
+	// and are empty for the problems found by the integrity check of the
+	// database.
+	Revision int64  `json:"revision,omitempty"`
+	Key      string `json:"key,omitempty"`
+	Problem  string `json:"problem"`
+}
+
+// Verification is the result of the verification of the datastore.
+type Verification struct {
+	Violations []Violation `json:"violations"`
+	// Duration is the duration of the verification.
+	Duration time.Duration `json:"duration"`
+}
+
+// Verify runs the integrity check of the database and checks the
+// invariants of the revisions of kine: the revisions only reference older
+// revisions of the same key, and the keys are created again after their
+// deletion.
+func (s *Server) Verify(ctx context.Context) (Verification, error) {
+	start := time.Now()
+	violations, err := s.backend.Verify(ctx)
+	if err != nil {
+		return Verification{}, err
+	}
+	verification := Verification{Violations: make([]Violation, 0, len(violations))}
+	for _, violation := range violations {
+		verification.Violations = append(verification.Violations, Violation{
+			Revision: violation.Revision,
+			Key:      violation.Key,
+			Problem:  violation.Problem,
+		})
+	}
+	verification.Duration = time.Since(start)
+	return verification, nil
+}
+
+// verifyPeriodically verifies the datastore every verify interval, until ctx
+// is done, raising the corruption alarm metric if corruptions are found.
+// The verifications run on the dqlite leader only, as the nodes share the
+// same data.
+func (s *Server) verifyPeriodically(ctx context.Context) {
+	if s.verifyInterval == 0 {
+		return
+	}
+	logger.WithField("interval", s.verifyInterval).Print("Enable background verification")
+	ticker := time.NewTicker(s.verifyInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		id, err := s.leaderID(ctx)
+		if err != nil {
+			logger.WithError(err).Warning("Failed to get dqlite leader, skipping verification")
+			continue
+		}
+		if id != s.app.ID() {
+			metricsCorruptionAlarm.Set(0)
+			metricsVerifyViolations.Set(0)
+			continue
+		}
+
+		verification, err := s.Verify(ctx)
+		if err != nil {
+			logger.WithError(err).Warning("Failed to verify datastore")
+			continue
+		}
+		metricsVerifyViolations.Set(float64(len(verification.Violations)))
+		metricsVerifyLastSuccess.SetToCurrentTime()
+		if len(verification.Violations) == 0 {
+			metricsCorruptionAlarm.Set(0)
+			logger.WithField("duration", verification.Duration).Debug("Verified datastore")
+			continue
+		}
+		metricsCorruptionAlarm.Set(1)
+		logger.WithField("violations", len(verification.Violations)).Error("Datastore corruption found, run k8s-dqlite verify for details")
+		for _, violation := range verification.Violations[:min(len(verification.Violations), maxLoggedViolations)] {
+			logger.WithFields(logrus.Fields{"revision": violation.Revision, "key": violation.Key, "problem": violation.Problem}).Error("Datastore corruption")
+		}
+	}
+}
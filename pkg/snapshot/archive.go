@@ -0,0 +1,229 @@
+package snapshot
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+const (
+	// archiveMetadataName and archiveDatabaseName are the names of the
+	// files of the backup archives.
+	archiveMetadataName = "metadata.json"
+	archiveDatabaseName = "k8s-dqlite.db"
+
+	gzipMagic = "\x1f\x8b"
+)
+
+// ArchiveMetadata describes the database of a backup archive.
+type ArchiveMetadata struct {
+	// Created is the time the backup was taken.
+	Created time.Time `json:"created"`
+	// Revision is the revision of the datastore in the backup.
+	Revision int64 `json:"revision"`
+	// ClusterID is the ID of the cluster the backup was taken from, if
+	// known.
+	ClusterID uint64 `json:"cluster_id,omitempty"`
+	// SchemaVersion is the user_version of the SQLite database.
+	SchemaVersion int64 `json:"schema_version"`
+	// Size and SHA256 are the size and checksum of the database.
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// WriteArchive writes a gzipped tar archive to w, holding the SQLite database
+// at dbPath, as taken by the Backup of the generic driver, and its metadata.
+// The schema version, size and checksum of the metadata are filled from the
+// database, and the metadata is returned.
+func WriteArchive(ctx context.Context, w io.Writer, dbPath string, metadata ArchiveMetadata) (ArchiveMetadata, error) {
+	schemaVersion, err := readSchemaVersion(ctx, dbPath)
+	if err != nil {
+		return ArchiveMetadata{}, err
+	}
+	metadata.SchemaVersion = schemaVersion
+
+	f, err := os.Open(dbPath)
+	if err != nil {
+		return ArchiveMetadata{}, err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	if metadata.Size, err = io.Copy(hash, f); err != nil {
+		return ArchiveMetadata{}, fmt.Errorf("failed to checksum database: %w", err)
+	}
+	metadata.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return ArchiveMetadata{}, err
+	}
+
+	b, err := json.MarshalIndent(metadata, "", "  ")
+	if err != nil {
+		return ArchiveMetadata{}, err
+	}
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	// The metadata comes first, so that it is read before the database.
+	if err := tw.WriteHeader(&tar.Header{Name: archiveMetadataName, Mode: 0600, Size: int64(len(b)), ModTime: metadata.Created}); err != nil {
+		return ArchiveMetadata{}, err
+	}
+	if _, err := tw.Write(b); err != nil {
+		return ArchiveMetadata{}, err
+	}
+	if err := tw.WriteHeader(&tar.Header{Name: archiveDatabaseName, Mode: 0600, Size: metadata.Size, ModTime: metadata.Created}); err != nil {
+		return ArchiveMetadata{}, err
+	}
+	if _, err := io.Copy(tw, f); err != nil {
+		return ArchiveMetadata{}, fmt.Errorf("failed to archive database: %w", err)
+	}
+	if err := tw.Close(); err != nil {
+		return ArchiveMetadata{}, err
+	}
+	if err := gz.Close(); err != nil {
+		return ArchiveMetadata{}, err
+	}
+	return metadata, nil
+}
+
+// ReadArchiveMetadata reads the metadata of the backup archive at path.
+func ReadArchiveMetadata(path string) (ArchiveMetadata, error) {
+	var (
+		metadata ArchiveMetadata
+		found    bool
+	)
+	err := walkArchive(path, func(name string, r io.Reader) (bool, error) {
+		if name != archiveMetadataName {
+			return false, nil
+		}
+		found = true
+		return true, json.NewDecoder(r).Decode(&metadata)
+	})
+	if err != nil {
+		return ArchiveMetadata{}, err
+	}
+	if !found {
+		return ArchiveMetadata{}, fmt.Errorf("backup archive has no metadata")
+	}
+	return metadata, nil
+}
+
+// VerifyArchive verifies the checksum of the database of the backup archive
+// at path, and returns its metadata.
+func VerifyArchive(path string) (ArchiveMetadata, error) {
+	return readArchive(path, io.Discard)
+}
+
+// extractArchive extracts the database of the backup archive at src to dst,
+// verifying its checksum.
+func extractArchive(src, dst string) (ArchiveMetadata, error) {
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return ArchiveMetadata{}, err
+	}
+	defer out.Close()
+	metadata, err := readArchive(src, out)
+	if err != nil {
+		return ArchiveMetadata{}, err
+	}
+	return metadata, out.Close()
+}
+
+// readArchive copies the database of the backup archive at path to w,
+// verifying its checksum.
+func readArchive(path string, w io.Writer) (ArchiveMetadata, error) {
+	var (
+		metadata ArchiveMetadata
+		found    bool
+	)
+	err := walkArchive(path, func(name string, r io.Reader) (bool, error) {
+		switch name {
+		case archiveMetadataName:
+			return false, json.NewDecoder(r).Decode(&metadata)
+		case archiveDatabaseName:
+			if metadata.SHA256 == "" {
+				return true, fmt.Errorf("backup archive has no metadata before its database")
+			}
+			hash := sha256.New()
+			if _, err := io.Copy(io.MultiWriter(w, hash), r); err != nil {
+				return true, fmt.Errorf("failed to read database: %w", err)
+			}
+			if hex.EncodeToString(hash.Sum(nil)) != metadata.SHA256 {
+				return true, fmt.Errorf("backup checksum mismatch")
+			}
+			found = true
+			return true, nil
+		}
+		return false, nil
+	})
+	if err != nil {
+		return ArchiveMetadata{}, err
+	}
+	if !found {
+		return ArchiveMetadata{}, fmt.Errorf("backup archive has no database")
+	}
+	return metadata, nil
+}
+
+// walkArchive calls fn with the files of the archive at path, until fn
+// returns true or an error, or the end of the archive.
+func walkArchive(path string, fn func(name string, r io.Reader) (bool, error)) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open backup archive: %w", err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return fmt.Errorf("failed to read backup archive: %w", err)
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("failed to read backup archive: %w", err)
+		}
+		if done, err := fn(header.Name, tr); err != nil || done {
+			return err
+		}
+	}
+}
+
+// isArchive reports whether the file at path is gzipped, as the backup
+// archives.
+func isArchive(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, fmt.Errorf("failed to open snapshot: %w", err)
+	}
+	defer f.Close()
+	header := make([]byte, len(gzipMagic))
+	if _, err := io.ReadFull(f, header); err != nil {
+		return false, nil
+	}
+	return string(header) == gzipMagic, nil
+}
+
+func readSchemaVersion(ctx context.Context, path string) (int64, error) {
+	db, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+	var version int64
+	if err := db.QueryRowContext(ctx, "PRAGMA user_version").Scan(&version); err != nil {
+		return 0, fmt.Errorf("failed to read database schema version: %w", err)
+	}
+	return version, nil
+}
@@ -0,0 +1,78 @@
+package snapshot
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestArchive(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	dbPath := filepath.Join(dir, "k8s-dqlite.db")
+	db := openDB(t, dbPath)
+	if _, _, err := Restore(ctx, db, writeEtcdSnapshot(t, etcdFixture, etcdFixtureLeases), RestoreOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.ExecContext(ctx, "PRAGMA user_version = 3"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	archivePath := filepath.Join(dir, "backup.tar.gz")
+	f, err := os.Create(archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	created := time.Now().UTC().Truncate(time.Second)
+	written, err := WriteArchive(ctx, f, dbPath, ArchiveMetadata{Created: created, Revision: 6, ClusterID: 42})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if written.SchemaVersion != 3 || written.Size == 0 || written.SHA256 == "" {
+		t.Fatalf("expected the metadata to be filled from the database, got %+v", written)
+	}
+
+	metadata, err := ReadArchiveMetadata(archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(metadata, written) {
+		t.Fatalf("expected metadata %+v, got %+v", written, metadata)
+	}
+	if _, err := VerifyArchive(archivePath); err != nil {
+		t.Fatal(err)
+	}
+
+	rows, format, err := readRows(archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format != FormatSQLite {
+		t.Errorf("expected format %q, got %q", FormatSQLite, format)
+	}
+	if !reflect.DeepEqual(rows, etcdFixtureRows) {
+		t.Errorf("expected rows\n%+v\ngot\n%+v", etcdFixtureRows, rows)
+	}
+
+	// A truncated archive is refused.
+	data, err := os.ReadFile(archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(archivePath, data[:len(data)/2], 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := VerifyArchive(archivePath); err == nil {
+		t.Fatal("expected a truncated archive to be refused")
+	}
+}
@@ -0,0 +1,141 @@
+package snapshot
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/canonical/k8s-dqlite/pkg/kine/compression"
+	bolt "go.etcd.io/bbolt"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	"go.etcd.io/etcd/server/v3/lease/leasepb"
+)
+
+var (
+	etcdKeyBucket   = []byte("key")
+	etcdLeaseBucket = []byte("lease")
+)
+
+const (
+	// etcdRevisionSize is the size of the revision keys in the key bucket: 8
+	// bytes of main revision, a separator and 8 bytes of sub revision.
+	etcdRevisionSize = 17
+	// etcdTombstone marks the revision keys of deleted entries.
+	etcdTombstone = 't'
+)
+
+// etcdKey is the state of a key during the conversion to kine rows.
+type etcdKey struct {
+	id             int64
+	createRevision int64
+	deleted        bool
+	value          []byte
+}
+
+// readEtcd converts the history retained in an etcd snapshot into kine rows.
+// As kine uses a single revision per row, etcd revisions are renumbered,
+// keeping their order, from 1 or, if keepRevisions is set, from their main
+// revision when it is not taken yet. Leases are replaced with their TTL, as
+// kine does.
+func readEtcd(path string, keepRevisions bool, fn func(*Row) error) error {
+	db, err := bolt.Open(path, 0600, &bolt.Options{ReadOnly: true})
+	if err != nil {
+		return fmt.Errorf("failed to open etcd snapshot: %w", err)
+	}
+	defer db.Close()
+
+	return db.View(func(tx *bolt.Tx) error {
+		ttls, err := readEtcdLeases(tx)
+		if err != nil {
+			return err
+		}
+
+		bucket := tx.Bucket(etcdKeyBucket)
+		if bucket == nil {
+			return fmt.Errorf("etcd snapshot has no key bucket")
+		}
+
+		var id int64
+		keys := make(map[string]*etcdKey)
+		cursor := bucket.Cursor()
+		for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+			if len(k) < etcdRevisionSize {
+				return fmt.Errorf("invalid revision key %x in etcd snapshot", k)
+			}
+			tombstone := len(k) > etcdRevisionSize && k[etcdRevisionSize] == etcdTombstone
+
+			var kv mvccpb.KeyValue
+			if err := kv.Unmarshal(v); err != nil {
+				return fmt.Errorf("failed to decode etcd key value: %w", err)
+			}
+
+			next := id + 1
+			if keepRevisions {
+				next = max(next, int64(binary.BigEndian.Uint64(k[:8])))
+			}
+			name := string(kv.Key)
+			row := &Row{
+				ID:   next,
+				Name: name,
+			}
+
+			prev := keys[name]
+			if prev != nil {
+				row.PrevRevision = prev.id
+			}
+
+			switch {
+			case tombstone:
+				if prev == nil || prev.deleted {
+					// Nothing to delete, the history was compacted.
+					continue
+				}
+				row.Deleted = true
+				row.CreateRevision = prev.createRevision
+				row.OldValue = prev.value
+			case prev == nil || prev.deleted:
+				row.Created = true
+				row.Lease = ttls[kv.Lease]
+				row.Value = compression.Escape(kv.Value)
+			default:
+				row.CreateRevision = prev.createRevision
+				row.Lease = ttls[kv.Lease]
+				row.Value = compression.Escape(kv.Value)
+				row.OldValue = prev.value
+			}
+
+			if err := fn(row); err != nil {
+				return err
+			}
+			id = row.ID
+
+			createRevision := row.CreateRevision
+			if row.Created {
+				createRevision = row.ID
+			}
+			keys[name] = &etcdKey{
+				id:             row.ID,
+				createRevision: createRevision,
+				deleted:        row.Deleted,
+				value:          row.Value,
+			}
+		}
+		return nil
+	})
+}
+
+func readEtcdLeases(tx *bolt.Tx) (map[int64]int64, error) {
+	ttls := make(map[int64]int64)
+	bucket := tx.Bucket(etcdLeaseBucket)
+	if bucket == nil {
+		return ttls, nil
+	}
+	err := bucket.ForEach(func(_, v []byte) error {
+		var lease leasepb.Lease
+		if err := lease.Unmarshal(v); err != nil {
+			return fmt.Errorf("failed to decode etcd lease: %w", err)
+		}
+		ttls[lease.ID] = lease.TTL
+		return nil
+	})
+	return ttls, err
+}
@@ -0,0 +1,296 @@
+package snapshot
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/canonical/k8s-dqlite/pkg/kine/compression"
+	bolt "go.etcd.io/bbolt"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	"go.etcd.io/etcd/server/v3/lease/leasepb"
+)
+
+// ExportFormat is the format of an export of the datastore.
+type ExportFormat string
+
+const (
+	// ExportEtcd is an etcd snapshot, restorable with etcdctl snapshot
+	// restore.
+	ExportEtcd ExportFormat = "etcd-snapshot"
+	// ExportSQL is a dump of the kine table as SQL statements, for SQLite
+	// and MySQL.
+	ExportSQL ExportFormat = "sqldump"
+	// ExportJSON holds the rows of the kine table as JSON lines.
+	ExportJSON ExportFormat = "jsonl"
+)
+
+var etcdMetaBucket = []byte("meta")
+
+var (
+	// etcdScheduledCompactKey and etcdFinishedCompactKey hold the compact
+	// revision of etcd, in the meta bucket.
+	etcdScheduledCompactKey = []byte("scheduledCompactRev")
+	etcdFinishedCompactKey  = []byte("finishedCompactRev")
+)
+
+// Export writes the rows of the snapshot at src, as accepted by Read, to
+// dst in the given format. The internal rows of kine are left out.
+func Export(ctx context.Context, src, dst string, format ExportFormat) error {
+	var write func(ctx context.Context, src string, w io.Writer) error
+	switch format {
+	case ExportEtcd:
+		return exportEtcd(ctx, src, dst)
+	case ExportSQL:
+		write = exportSQL
+	case ExportJSON:
+		write = exportJSON
+	default:
+		return fmt.Errorf("unsupported export format %q", format)
+	}
+
+	f, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+
+	if err := write(ctx, src, w); err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// isInternalRow reports whether row is one of the internal rows of kine: the
+// compaction marker and the rows filling the gaps of revisions.
+func isInternalRow(row *Row) bool {
+	return row.Name == compactRevKey || strings.HasPrefix(row.Name, "gap-")
+}
+
+func exportJSON(ctx context.Context, src string, w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	_, err := Read(ctx, src, func(row *Row) error {
+		if isInternalRow(row) {
+			return nil
+		}
+		return encoder.Encode(row)
+	})
+	return err
+}
+
+const sqlDumpSchema = `CREATE TABLE IF NOT EXISTS kine
+(
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	name TEXT NOT NULL,
+	created INTEGER,
+	deleted INTEGER,
+	create_revision INTEGER NOT NULL,
+	prev_revision INTEGER,
+	lease INTEGER,
+	value BLOB,
+	old_value BLOB
+);
+`
+
+func exportSQL(ctx context.Context, src string, w io.Writer) error {
+	if _, err := io.WriteString(w, "BEGIN TRANSACTION;\n"+sqlDumpSchema); err != nil {
+		return err
+	}
+	_, err := Read(ctx, src, func(row *Row) error {
+		if isInternalRow(row) {
+			return nil
+		}
+		_, err := fmt.Fprintf(w, "INSERT INTO kine(id, name, created, deleted, create_revision, prev_revision, lease, value, old_value) VALUES(%d, %s, %d, %d, %d, %d, %d, %s, %s);\n",
+			row.ID, sqlString(row.Name), boolToInt(row.Created), boolToInt(row.Deleted), row.CreateRevision, row.PrevRevision, row.Lease, sqlBlob(row.Value), sqlBlob(row.OldValue))
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "COMMIT;\n")
+	return err
+}
+
+func sqlString(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+func sqlBlob(b []byte) string {
+	if b == nil {
+		return "NULL"
+	}
+	return "X'" + hex.EncodeToString(b) + "'"
+}
+
+// exportEtcd writes the rows as the history of an etcd snapshot, keeping
+// their revisions and the compact revision. The leases are replaced with
+// a lease for each of their TTL. The snapshot ends with its sha256
+// checksum, as the snapshots taken from etcd.
+func exportEtcd(ctx context.Context, src, dst string) error {
+	if _, err := os.Stat(dst); err == nil {
+		return fmt.Errorf("%s already exists", dst)
+	}
+	db, err := bolt.Open(dst, 0600, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create etcd snapshot: %w", err)
+	}
+	defer db.Close()
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		keys, err := tx.CreateBucket(etcdKeyBucket)
+		if err != nil {
+			return err
+		}
+		// The rows are appended in revision order.
+		keys.FillPercent = 1
+		meta, err := tx.CreateBucket(etcdMetaBucket)
+		if err != nil {
+			return err
+		}
+		leaseBucket, err := tx.CreateBucket(etcdLeaseBucket)
+		if err != nil {
+			return err
+		}
+
+		var (
+			compactRevision int64
+			versions        = map[string]int64{}
+			leases          = map[int64]int64{}
+		)
+		format, err := Read(ctx, src, func(row *Row) error {
+			if row.Name == compactRevKey {
+				compactRevision = row.PrevRevision
+			}
+			if isInternalRow(row) {
+				return nil
+			}
+
+			key := etcdRevisionKey(row.ID)
+			if row.Deleted {
+				delete(versions, row.Name)
+				b, err := (&mvccpb.KeyValue{Key: []byte(row.Name)}).Marshal()
+				if err != nil {
+					return err
+				}
+				return keys.Put(append(key, etcdTombstone), b)
+			}
+
+			createRevision := row.CreateRevision
+			if row.Created {
+				createRevision = row.ID
+			}
+			versions[row.Name]++
+			kv := &mvccpb.KeyValue{
+				Key:            []byte(row.Name),
+				CreateRevision: createRevision,
+				ModRevision:    row.ID,
+				Version:        versions[row.Name],
+				Value:          compression.Decode(row.Value),
+			}
+			if row.Lease > 0 {
+				if _, ok := leases[row.Lease]; !ok {
+					leases[row.Lease] = int64(len(leases) + 1)
+				}
+				kv.Lease = leases[row.Lease]
+			}
+			b, err := kv.Marshal()
+			if err != nil {
+				return err
+			}
+			return keys.Put(key, b)
+		})
+		if err != nil {
+			return err
+		}
+		if format == FormatEtcd {
+			return fmt.Errorf("the snapshot already is an etcd snapshot")
+		}
+
+		for ttl, id := range leases {
+			b, err := (&leasepb.Lease{ID: id, TTL: ttl}).Marshal()
+			if err != nil {
+				return err
+			}
+			if err := leaseBucket.Put(etcdLeaseKey(id), b); err != nil {
+				return err
+			}
+		}
+		if compactRevision > 0 {
+			// Once restored, etcd compacts the history at the compact
+			// revision, as the older revisions are gone.
+			for _, name := range [][]byte{etcdScheduledCompactKey, etcdFinishedCompactKey} {
+				if err := meta.Put(name, etcdRevisionKey(compactRevision)); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		os.Remove(dst)
+		return err
+	}
+	if err := db.Close(); err != nil {
+		return err
+	}
+	return appendChecksum(dst)
+}
+
+// etcdRevisionKey returns the key of the main revision rev in the key
+// bucket, with a zero sub revision.
+func etcdRevisionKey(rev int64) []byte {
+	key := make([]byte, etcdRevisionSize, etcdRevisionSize+1)
+	binary.BigEndian.PutUint64(key, uint64(rev))
+	key[8] = '_'
+	return key
+}
+
+func etcdLeaseKey(id int64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(id))
+	return key
+}
+
+// appendChecksum appends the sha256 checksum of the file at path to it.
+func appendChecksum(path string) error {
+	f, err := os.OpenFile(path, os.O_RDWR, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return err
+	}
+	if _, err := f.Write(hash.Sum(nil)); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// ParseExportFormat parses the name of an export format.
+func ParseExportFormat(name string) (ExportFormat, error) {
+	switch format := ExportFormat(name); format {
+	case ExportEtcd, ExportSQL, ExportJSON:
+		return format, nil
+	}
+	return "", fmt.Errorf("unsupported export format %q, expected %s, %s or %s", name, ExportEtcd, ExportSQL, ExportJSON)
+}
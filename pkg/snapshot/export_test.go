@@ -0,0 +1,135 @@
+package snapshot
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"go.etcd.io/etcd/server/v3/lease"
+	"go.etcd.io/etcd/server/v3/mvcc"
+	"go.etcd.io/etcd/server/v3/mvcc/backend"
+	"go.uber.org/zap"
+)
+
+// writeSQLiteSnapshot writes a k8s-dqlite snapshot holding the rows of the
+// etcd fixture.
+func writeSQLiteSnapshot(t *testing.T) string {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "snapshot.db")
+	db := openDB(t, path)
+	if _, _, err := Restore(ctx, db, writeEtcdSnapshot(t, etcdFixture, etcdFixtureLeases), RestoreOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestExportEtcd(t *testing.T) {
+	ctx := context.Background()
+	dst := filepath.Join(t.TempDir(), "etcd.db")
+	if err := Export(ctx, writeSQLiteSnapshot(t), dst, ExportEtcd); err != nil {
+		t.Fatal(err)
+	}
+
+	// The export is read back as the same rows, with its checksum.
+	rows, format, err := readRows(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format != FormatEtcd || !reflect.DeepEqual(rows, etcdFixtureRows) {
+		t.Errorf("expected rows\n%+v\ngot %q rows\n%+v", etcdFixtureRows, format, rows)
+	}
+
+	// etcd reads the export without its checksum, as etcdctl restores it.
+	b, err := os.ReadFile(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "db")
+	if err := os.WriteFile(path, b[:len(b)-32], 0600); err != nil {
+		t.Fatal(err)
+	}
+	lg := zap.NewNop()
+	be := backend.NewDefaultBackend(path)
+	defer be.Close()
+	lessor := lease.NewLessor(lg, be, nil, lease.LessorConfig{MinLeaseTTL: 1})
+	defer lessor.Stop()
+	store := mvcc.NewStore(lg, be, lessor, mvcc.StoreConfig{})
+	defer store.Close()
+
+	result, err := store.Range(ctx, []byte("/"), []byte("0"), mvcc.RangeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Rev != 6 || len(result.KVs) != 2 {
+		t.Fatalf("expected 2 keys at revision 6, got %d at revision %d", len(result.KVs), result.Rev)
+	}
+	a, b2 := result.KVs[0], result.KVs[1]
+	if string(a.Value) != "a3" || a.CreateRevision != 5 || a.Version != 1 {
+		t.Errorf("unexpected /a %+v", a)
+	}
+	if string(b2.Value) != "b2" || b2.CreateRevision != 2 || b2.Version != 2 || b2.Lease == 0 {
+		t.Errorf("unexpected /b %+v", b2)
+	}
+	if l := lessor.Lookup(lease.LeaseID(b2.Lease)); l == nil || l.TTL() != 60 {
+		t.Errorf("expected /b to be attached to a lease of 60s, got %+v", l)
+	}
+}
+
+func TestExportSQL(t *testing.T) {
+	ctx := context.Background()
+	dst := filepath.Join(t.TempDir(), "dump.sql")
+	if err := Export(ctx, writeSQLiteSnapshot(t), dst, ExportSQL); err != nil {
+		t.Fatal(err)
+	}
+	dump, err := os.ReadFile(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "imported.db")
+	db := openDB(t, path)
+	if _, err := db.ExecContext(ctx, string(dump)); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	rows, _, err := readRows(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(rows, etcdFixtureRows) {
+		t.Errorf("expected rows\n%+v\ngot\n%+v", etcdFixtureRows, rows)
+	}
+}
+
+func TestExportJSON(t *testing.T) {
+	dst := filepath.Join(t.TempDir(), "rows.jsonl")
+	if err := Export(context.Background(), writeSQLiteSnapshot(t), dst, ExportJSON); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var rows []Row
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var row Row
+		if err := json.Unmarshal(scanner.Bytes(), &row); err != nil {
+			t.Fatal(err)
+		}
+		rows = append(rows, row)
+	}
+	if !reflect.DeepEqual(rows, etcdFixtureRows) {
+		t.Errorf("expected rows\n%+v\ngot\n%+v", etcdFixtureRows, rows)
+	}
+}
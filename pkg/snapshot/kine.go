@@ -0,0 +1,89 @@
+package snapshot
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/canonical/k8s-dqlite/pkg/kine/drivers/sqlite"
+	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/jackc/pgx/v5/stdlib"
+)
+
+// kineDatabase returns the format, database/sql driver and data source name
+// of the kine database at source, given as a mysql:// or postgres:// URL as
+// in the datastore endpoints of k3s. It returns false if source is not such
+// a URL.
+func kineDatabase(source string) (Format, string, string, bool) {
+	switch {
+	case strings.HasPrefix(source, "mysql://"):
+		return FormatMySQL, "mysql", strings.TrimPrefix(source, "mysql://"), true
+	case strings.HasPrefix(source, "postgres://"), strings.HasPrefix(source, "postgresql://"):
+		return FormatPostgres, "pgx", source, true
+	}
+	return "", "", "", false
+}
+
+// readKineDatabase reads the kine table of a database, as written by the
+// kine of k3s. The revisions and the compact revision are kept, and the
+// leases are already stored as their TTL.
+func readKineDatabase(ctx context.Context, driver, dsn string, fn func(*Row) error) error {
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return fmt.Errorf("failed to open kine database: %w", err)
+	}
+	defer db.Close()
+
+	// The compact revision of k8s-dqlite is kept out of the kine table.
+	tablesSQL := `SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = current_schema() AND table_name = 'kine_metadata'`
+	switch driver {
+	case "mysql":
+		tablesSQL = `SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = DATABASE() AND table_name = 'kine_metadata'`
+	case "sqlite3":
+		tablesSQL = `SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'kine_metadata'`
+	}
+	if err := readCompactRevision(ctx, db, tablesSQL, fn); err != nil {
+		return err
+	}
+	escaped, err := kineValuesEscaped(ctx, db, driver, tablesSQL)
+	if err != nil {
+		return err
+	}
+	if !escaped {
+		fn = escapeValues(fn)
+	}
+
+	// The rows are read by a single statement, which is consistent even
+	// if the database is still written to.
+	rows, err := db.QueryContext(ctx, `SELECT id, name, created, deleted, create_revision, prev_revision, lease, value, old_value FROM kine ORDER BY id`)
+	if err != nil {
+		return fmt.Errorf("failed to read kine table: %w", err)
+	}
+	return scanRows(rows, fn)
+}
+
+// kineValuesEscaped reports whether the raw values of the kine database are
+// escaped, as in the databases of k8s-dqlite, given the statement counting
+// its metadata tables.
+func kineValuesEscaped(ctx context.Context, db *sql.DB, driver, tablesSQL string) (bool, error) {
+	if driver == "sqlite3" {
+		var schemaVersion sqlite.SchemaVersion
+		if err := db.QueryRowContext(ctx, `PRAGMA user_version`).Scan(&schemaVersion); err != nil {
+			return false, fmt.Errorf("failed to read the schema version: %w", err)
+		}
+		return schemaVersion >= sqlite.EscapedSchemaVersion, nil
+	}
+	var tables int
+	if err := db.QueryRowContext(ctx, tablesSQL).Scan(&tables); err != nil {
+		return false, fmt.Errorf("failed to read database schema: %w", err)
+	}
+	if tables == 0 {
+		return false, nil
+	}
+	var escaped int
+	if err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM kine_metadata WHERE name = 'escaped_values'`).Scan(&escaped); err != nil {
+		return false, fmt.Errorf("failed to read the metadata: %w", err)
+	}
+	return escaped > 0, nil
+}
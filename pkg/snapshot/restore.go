@@ -0,0 +1,140 @@
+package snapshot
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/canonical/k8s-dqlite/pkg/kine/drivers/sqlite"
+)
+
+// compactRevKey is the name of the row holding the compact revision, in
+// its prev_revision, in the snapshots. The datastore keeps the compact
+// revision in its metadata table instead.
+const compactRevKey = "compact_rev_key"
+
+// RestoreOptions tunes Restore.
+type RestoreOptions struct {
+	// Revision, if not zero, restores the datastore as it was at that
+	// revision, by dropping the rows of the later revisions. The revision
+	// must not be compacted in the snapshot, and must be one of the
+	// revisions of a k8s-dqlite snapshot, as the revisions of etcd
+	// snapshots are renumbered.
+	Revision int64
+	// EtcdRevisions keeps the revisions of etcd snapshots, rather than
+	// renumbering them from 1, as described by ReadOptions.
+	EtcdRevisions bool
+}
+
+// Restore replaces the contents of the kine table in db with the rows of the
+// snapshot at path, in a single transaction. Leases are not restored, keys
+// attached to them expire after the lease TTL instead. It returns the number of rows
+// restored and the format of the snapshot.
+func Restore(ctx context.Context, db *sql.DB, path string, opts RestoreOptions) (int64, Format, error) {
+	revision := opts.Revision
+	if revision < 0 {
+		return 0, "", fmt.Errorf("invalid revision %d", revision)
+	}
+
+	if err := sqlite.Setup(ctx, db); err != nil {
+		return 0, "", fmt.Errorf("failed to set up the database: %w", err)
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, "", err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM kine`); err != nil {
+		return 0, "", fmt.Errorf("failed to clear the kine table: %w", err)
+	}
+	// The events are restored in the kine table, and moved to the events
+	// table as the datastore starts, if they are stored apart.
+	if _, err := tx.ExecContext(ctx, `DELETE FROM kine_events`); err != nil {
+		return 0, "", fmt.Errorf("failed to clear the events table: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM kine_key_counts`); err != nil {
+		return 0, "", fmt.Errorf("failed to clear the key counts table: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM kine_leases`); err != nil {
+		return 0, "", fmt.Errorf("failed to clear the leases table: %w", err)
+	}
+
+	insert, err := tx.PrepareContext(ctx, `INSERT INTO kine(id, name, created, deleted, create_revision, prev_revision, lease, value, old_value) VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return 0, "", err
+	}
+	defer insert.Close()
+
+	var count, maxRevision, compactRevision int64
+	format, err := ReadWithOptions(ctx, path, ReadOptions{EtcdRevisions: opts.EtcdRevisions}, func(row *Row) error {
+		// The compaction marker is updated in place, so its revision
+		// does not tell when it was last written.
+		if row.Name == compactRevKey {
+			compactRevision = max(compactRevision, row.PrevRevision)
+			return nil
+		}
+		maxRevision = max(maxRevision, row.ID)
+		if revision != 0 && row.ID > revision {
+			return nil
+		}
+		if _, err := insert.ExecContext(ctx, row.ID, row.Name, boolToInt(row.Created), boolToInt(row.Deleted), row.CreateRevision, row.PrevRevision, row.Lease, row.Value, row.OldValue); err != nil {
+			return fmt.Errorf("failed to restore revision %d: %w", row.ID, err)
+		}
+		count++
+		return nil
+	})
+	if err != nil {
+		return 0, "", err
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE kine_metadata SET value = ? WHERE name IN ('compact_revision', 'events_compact_revision')`, compactRevision); err != nil {
+		return 0, "", fmt.Errorf("failed to restore the compact revision: %w", err)
+	}
+	if revision != 0 {
+		if err := checkRestoredRevision(ctx, tx, format, revision, maxRevision); err != nil {
+			return 0, "", err
+		}
+	}
+
+	// The rows were inserted with their own ids, so the AUTOINCREMENT
+	// sequences, shared by the kine and events tables, are reset for new
+	// revisions to follow the restored ones.
+	if _, err := tx.ExecContext(ctx, `DELETE FROM sqlite_sequence WHERE name IN ('kine', 'kine_events')`); err != nil {
+		return 0, "", fmt.Errorf("failed to reset the revision sequence: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `INSERT INTO sqlite_sequence(name, seq) SELECT table_name, COALESCE((SELECT MAX(id) FROM kine), 0) FROM (SELECT 'kine' AS table_name UNION ALL SELECT 'kine_events')`); err != nil {
+		return 0, "", fmt.Errorf("failed to reset the revision sequence: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, "", err
+	}
+	return count, format, nil
+}
+
+// checkRestoredRevision checks that the datastore can be restored at
+// revision from a snapshot of the given format, ending at maxRevision.
+func checkRestoredRevision(ctx context.Context, tx *sql.Tx, format Format, revision, maxRevision int64) error {
+	if format == FormatEtcd {
+		return fmt.Errorf("etcd snapshots cannot be restored at a revision, as their revisions are renumbered")
+	}
+	if revision > maxRevision {
+		return fmt.Errorf("revision %d is after the last revision %d of the snapshot", revision, maxRevision)
+	}
+	var compactRevision int64
+	if err := tx.QueryRowContext(ctx, `SELECT value FROM kine_metadata WHERE name = 'compact_revision'`).Scan(&compactRevision); err != nil {
+		return fmt.Errorf("failed to read the compact revision: %w", err)
+	}
+	if revision < compactRevision {
+		return fmt.Errorf("revision %d is compacted, the snapshot holds the revisions from %d", revision, compactRevision)
+	}
+	return nil
+}
+
+func boolToInt(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,294 @@
+package snapshot
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// s3DefaultRegion is the region of the buckets if none is configured.
+	s3DefaultRegion = "us-east-1"
+
+	s3Algorithm  = "AWS4-HMAC-SHA256"
+	s3TimeFormat = "20060102T150405Z"
+	s3DateFormat = "20060102"
+)
+
+// s3Target stores the archives in an S3 bucket, under a prefix. The
+// requests are signed with AWS signature version 4, and the buckets are
+// addressed by path, as supported by the S3 compatible stores.
+type s3Target struct {
+	endpoint *url.URL
+	bucket   string
+	prefix   string
+	region   string
+
+	accessKeyID     string
+	secretAccessKey string
+	sessionToken    string
+
+	client *http.Client
+	// now returns the time the requests are signed at.
+	now func() time.Time
+}
+
+// newS3Target returns the target of the s3://bucket/prefix URL u. The
+// credentials are read from the AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and
+// AWS_SESSION_TOKEN environment variables. The region and endpoint are read
+// from the region and endpoint query parameters of u, or else from the
+// AWS_REGION and AWS_ENDPOINT_URL environment variables, and default to the
+// us-east-1 AWS endpoint.
+func newS3Target(u *url.URL) (*s3Target, error) {
+	if u.Host == "" {
+		return nil, fmt.Errorf("backup target %q has no bucket", u.Redacted())
+	}
+	t := &s3Target{
+		bucket:          u.Host,
+		prefix:          strings.TrimPrefix(u.Path, "/"),
+		region:          u.Query().Get("region"),
+		accessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		sessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		client:          http.DefaultClient,
+		now:             time.Now,
+	}
+	if t.prefix != "" && !strings.HasSuffix(t.prefix, "/") {
+		t.prefix += "/"
+	}
+	if t.region == "" {
+		t.region = os.Getenv("AWS_REGION")
+	}
+	if t.region == "" {
+		t.region = s3DefaultRegion
+	}
+	if t.accessKeyID == "" || t.secretAccessKey == "" {
+		return nil, fmt.Errorf("backup target %q needs the AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY environment variables", u.Redacted())
+	}
+
+	endpoint := u.Query().Get("endpoint")
+	if endpoint == "" {
+		endpoint = os.Getenv("AWS_ENDPOINT_URL")
+	}
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", t.region)
+	}
+	var err error
+	if t.endpoint, err = url.Parse(endpoint); err != nil {
+		return nil, fmt.Errorf("invalid S3 endpoint %q: %w", endpoint, err)
+	}
+	if t.endpoint.Scheme != "http" && t.endpoint.Scheme != "https" {
+		return nil, fmt.Errorf("invalid S3 endpoint %q, expected an http or https URL", endpoint)
+	}
+	return t, nil
+}
+
+func (t *s3Target) Put(ctx context.Context, name, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, f)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	resp, err := t.do(ctx, http.MethodPut, t.prefix+name, nil, f, size, hex.EncodeToString(hash.Sum(nil)))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// s3ListResult is the response of ListObjectsV2.
+type s3ListResult struct {
+	Contents []struct {
+		Key string
+	}
+	IsTruncated           bool
+	NextContinuationToken string
+}
+
+func (t *s3Target) List(ctx context.Context) ([]string, error) {
+	var (
+		names []string
+		token string
+	)
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {t.prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		resp, err := t.do(ctx, http.MethodGet, "", query, nil, 0, emptySHA256)
+		if err != nil {
+			return nil, err
+		}
+		var result s3ListResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode S3 object list: %w", err)
+		}
+		for _, object := range result.Contents {
+			name := strings.TrimPrefix(object.Key, t.prefix)
+			// The objects of the sub-directories of the prefix are not
+			// archives of the target.
+			if name != "" && !strings.Contains(name, "/") {
+				names = append(names, name)
+			}
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			break
+		}
+		token = result.NextContinuationToken
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (t *s3Target) Delete(ctx context.Context, name string) error {
+	resp, err := t.do(ctx, http.MethodDelete, t.prefix+name, nil, nil, 0, emptySHA256)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// emptySHA256 is the checksum of the empty payloads.
+var emptySHA256 = hex.EncodeToString(sha256.New().Sum(nil))
+
+// do sends a signed request for the object key of the bucket, or for the
+// bucket if key is empty.
+func (t *s3Target) do(ctx context.Context, method, key string, query url.Values, body io.Reader, size int64, payloadHash string) (*http.Response, error) {
+	path := strings.TrimSuffix(t.endpoint.Path, "/") + "/" + t.bucket
+	if key != "" {
+		path += "/" + key
+	}
+	u := *t.endpoint
+	u.Path = path
+	u.RawPath = s3Escape(path, true)
+	u.RawQuery = s3CanonicalQuery(query)
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = size
+	t.sign(req, payloadHash)
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, fmt.Errorf("S3 %s %s failed: %s: %s", method, u.Path, resp.Status, strings.TrimSpace(string(b)))
+	}
+	return resp, nil
+}
+
+// sign signs req with AWS signature version 4, covering its host and
+// headers.
+func (t *s3Target) sign(req *http.Request, payloadHash string) {
+	now := t.now().UTC()
+	req.Header.Set("X-Amz-Date", now.Format(s3TimeFormat))
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if t.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", t.sessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&canonicalHeaders, "%s:%s\n", name, headers[name])
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := strings.Join([]string{now.Format(s3DateFormat), t.region, "s3", "aws4_request"}, "/")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{s3Algorithm, now.Format(s3TimeFormat), scope, hex.EncodeToString(requestHash[:])}, "\n")
+
+	key := []byte("AWS4" + t.secretAccessKey)
+	for _, part := range []string{now.Format(s3DateFormat), t.region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s3Algorithm, t.accessKeyID, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// s3Escape escapes s as required by the signatures, leaving only the
+// unreserved characters, and the slashes if path is set, unescaped.
+func s3Escape(s string, path bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' || (path && c == '/') {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// s3CanonicalQuery returns the query sorted by key and escaped, as
+// required by the signatures.
+func s3CanonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, key := range keys {
+		values := append([]string(nil), query[key]...)
+		sort.Strings(values)
+		for _, value := range values {
+			parts = append(parts, s3Escape(key, false)+"="+s3Escape(value, false))
+		}
+	}
+	return strings.Join(parts, "&")
+}
@@ -0,0 +1,332 @@
+// Package snapshot reads datastore snapshots, either taken from k8s-dqlite
+// (SQLite databases) or from etcd (bbolt databases), as kine rows.
+package snapshot
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/canonical/k8s-dqlite/pkg/kine/compression"
+	"github.com/canonical/k8s-dqlite/pkg/kine/drivers/sqlite"
+)
+
+const (
+	// checksumAlignment is the alignment of snapshot files, used to
+	// detect whether they are followed by a sha256 checksum.
+	checksumAlignment = 512
+
+	sqliteMagic = "SQLite format 3\x00"
+	// boltMagic is found in the meta page of bbolt databases, after
+	// the 16 bytes page header.
+	boltMagic       = 0xED0CDAED
+	boltMagicOffset = 16
+)
+
+// Format is the format of a snapshot file.
+type Format string
+
+const (
+	FormatSQLite   Format = "sqlite"
+	FormatEtcd     Format = "etcd"
+	FormatMySQL    Format = "mysql"
+	FormatPostgres Format = "postgres"
+)
+
+// Row is a row of the kine table.
+type Row struct {
+	ID             int64  `json:"id"`
+	Name           string `json:"name"`
+	Created        bool   `json:"created"`
+	Deleted        bool   `json:"deleted"`
+	CreateRevision int64  `json:"create_revision"`
+	PrevRevision   int64  `json:"prev_revision"`
+	Lease          int64  `json:"lease"`
+	Value          []byte `json:"value"`
+	OldValue       []byte `json:"old_value"`
+}
+
+// ReadOptions tunes the reading of snapshots.
+type ReadOptions struct {
+	// EtcdRevisions keeps the main revisions of etcd snapshots as the
+	// revisions of the rows where possible. As kine uses a single revision
+	// per row, the changes following the transactions changing several
+	// keys are shifted to later revisions. By default, the revisions are
+	// renumbered from 1.
+	EtcdRevisions bool
+}
+
+// Read calls fn with all the rows stored in the snapshot at path, in
+// revision order. The compact revision of the snapshots that hold it out of
+// the kine table comes first, as a compaction marker row of revision 0. If the snapshot ends with a sha256 checksum, it is
+// verified before reading any row, as is the checksum of the database of
+// backup archives. The path may also be the URL of a MySQL or PostgreSQL
+// kine database, as accepted by kineDatabase.
+func Read(ctx context.Context, path string, fn func(*Row) error) (Format, error) {
+	return ReadWithOptions(ctx, path, ReadOptions{}, fn)
+}
+
+// ReadWithOptions is Read, tuned by opts.
+func ReadWithOptions(ctx context.Context, path string, opts ReadOptions, fn func(*Row) error) (Format, error) {
+	if format, driver, dsn, ok := kineDatabase(path); ok {
+		return format, readKineDatabase(ctx, driver, dsn, fn)
+	}
+
+	dir, err := os.MkdirTemp("", "k8s-dqlite-snapshot-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(dir)
+
+	// Both SQLite and bbolt must open the snapshot file for writing, so
+	// the original file is never handed over to them.
+	copyPath := filepath.Join(dir, "snapshot.db")
+	if archive, err := isArchive(path); err != nil {
+		return "", err
+	} else if archive {
+		if _, err := extractArchive(path, copyPath); err != nil {
+			return "", err
+		}
+		return FormatSQLite, readSQLite(ctx, copyPath, fn)
+	}
+	if err := copyVerified(path, copyPath); err != nil {
+		return "", err
+	}
+
+	format, err := detectFormat(copyPath)
+	if err != nil {
+		return "", err
+	}
+
+	switch format {
+	case FormatSQLite:
+		return format, readSQLite(ctx, copyPath, fn)
+	case FormatEtcd:
+		return format, readEtcd(copyPath, opts.EtcdRevisions, fn)
+	}
+	return "", fmt.Errorf("unsupported snapshot format %q", format)
+}
+
+// copyVerified copies the snapshot at src to dst, verifying and stripping the
+// trailing sha256 checksum if present.
+func copyVerified(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("failed to open snapshot: %w", err)
+	}
+	defer in.Close()
+
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	size := info.Size()
+	hasChecksum := size%checksumAlignment == sha256.Size
+	if hasChecksum {
+		size -= sha256.Size
+	}
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(out, hash), io.LimitReader(in, size)); err != nil {
+		return fmt.Errorf("failed to copy snapshot: %w", err)
+	}
+
+	if hasChecksum {
+		expected := make([]byte, sha256.Size)
+		if _, err := io.ReadFull(in, expected); err != nil {
+			return fmt.Errorf("failed to read snapshot checksum: %w", err)
+		}
+		if !bytes.Equal(hash.Sum(nil), expected) {
+			return fmt.Errorf("snapshot checksum mismatch")
+		}
+	}
+	return out.Close()
+}
+
+func detectFormat(path string) (Format, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	header := make([]byte, len(sqliteMagic))
+	if _, err := io.ReadFull(f, header); err != nil {
+		return "", fmt.Errorf("failed to read snapshot header: %w", err)
+	}
+	if string(header) == sqliteMagic {
+		return FormatSQLite, nil
+	}
+
+	magic := make([]byte, 4)
+	if _, err := f.ReadAt(magic, boltMagicOffset); err != nil {
+		return "", fmt.Errorf("failed to read snapshot header: %w", err)
+	}
+	if binary.LittleEndian.Uint32(magic) == boltMagic {
+		return FormatEtcd, nil
+	}
+	return "", fmt.Errorf("unknown snapshot format")
+}
+
+func readSQLite(ctx context.Context, path string, fn func(*Row) error) error {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	// Leases are replaced with their TTL, as they are not restored.
+	lease := "lease"
+	var leaseTables int
+	if err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'kine_leases'`).Scan(&leaseTables); err != nil {
+		return fmt.Errorf("failed to read snapshot schema: %w", err)
+	}
+	if leaseTables > 0 {
+		lease = "COALESCE((SELECT ttl FROM kine_leases WHERE kine_leases.id = kine.lease), lease)"
+	}
+
+	if err := readCompactRevision(ctx, db, `SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'kine_metadata'`, fn); err != nil {
+		return err
+	}
+
+	var chunkTables int
+	if err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'kine_chunks'`).Scan(&chunkTables); err != nil {
+		return fmt.Errorf("failed to read snapshot schema: %w", err)
+	}
+	if chunkTables > 0 {
+		fn = readChunks(ctx, db, fn)
+	}
+
+	var schemaVersion sqlite.SchemaVersion
+	if err := db.QueryRowContext(ctx, `PRAGMA user_version`).Scan(&schemaVersion); err != nil {
+		return fmt.Errorf("failed to read snapshot schema: %w", err)
+	}
+	if schemaVersion < sqlite.EscapedSchemaVersion {
+		fn = escapeValues(fn)
+	}
+
+	// The events stored apart are read along with the other keys.
+	table := "kine"
+	var eventsViews int
+	if err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM sqlite_master WHERE type = 'view' AND name = 'kine_all'`).Scan(&eventsViews); err != nil {
+		return fmt.Errorf("failed to read snapshot schema: %w", err)
+	}
+	if eventsViews > 0 {
+		table = "kine_all"
+	}
+
+	rows, err := db.QueryContext(ctx, fmt.Sprintf(`SELECT id, name, created, deleted, create_revision, prev_revision, %s, value, old_value FROM %s AS kine ORDER BY id`, lease, table))
+	if err != nil {
+		return fmt.Errorf("failed to read kine table: %w", err)
+	}
+	return scanRows(rows, fn)
+}
+
+// readCompactRevision calls fn with a compaction marker row of revision 0
+// holding the compact revision of the metadata table, if tablesSQL counts
+// one and the database was compacted, as the compaction marker was a row
+// of the kine table before. The rows of the kine table follow it. If the
+// events were compacted apart, the latest compact revision is kept.
+func readCompactRevision(ctx context.Context, db *sql.DB, tablesSQL string, fn func(*Row) error) error {
+	var tables int
+	if err := db.QueryRowContext(ctx, tablesSQL).Scan(&tables); err != nil {
+		return fmt.Errorf("failed to read snapshot schema: %w", err)
+	}
+	if tables == 0 {
+		return nil
+	}
+	var compactRevision int64
+	if err := db.QueryRowContext(ctx, `SELECT COALESCE(MAX(value), 0) FROM kine_metadata WHERE name IN ('compact_revision', 'events_compact_revision')`).Scan(&compactRevision); err != nil {
+		return fmt.Errorf("failed to read the compact revision: %w", err)
+	}
+	if compactRevision == 0 {
+		return nil
+	}
+	return fn(&Row{Name: compactRevKey, PrevRevision: compactRevision, Value: []byte{}})
+}
+
+// readChunks returns fn, called with the values stored in the chunks of db
+// in place of their references, so that the rows hold whole values.
+func readChunks(ctx context.Context, db *sql.DB, fn func(*Row) error) func(*Row) error {
+	read := func(name string, value []byte) ([]byte, error) {
+		id, ok := compression.ParseChunkReference(value)
+		if !ok {
+			return value, nil
+		}
+		rows, err := db.QueryContext(ctx, `SELECT data FROM kine_chunks WHERE (id = ? OR blob = ?) AND name = ? ORDER BY id`, id, id, name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read value chunks: %w", err)
+		}
+		defer rows.Close()
+		var chunks []byte
+		for rows.Next() {
+			var chunk []byte
+			if err := rows.Scan(&chunk); err != nil {
+				return nil, err
+			}
+			chunks = append(chunks, chunk...)
+		}
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+		if chunks == nil {
+			// Without chunks, the value can only be a raw value.
+			return value, nil
+		}
+		return chunks, nil
+	}
+	return func(row *Row) (err error) {
+		if row.Value, err = read(row.Name, row.Value); err != nil {
+			return err
+		}
+		if row.OldValue, err = read(row.Name, row.OldValue); err != nil {
+			return err
+		}
+		return fn(row)
+	}
+}
+
+// escapeValues returns fn, called with the values of the rows escaped, for
+// the snapshots of the databases whose raw values were stored unescaped.
+func escapeValues(fn func(*Row) error) func(*Row) error {
+	return func(row *Row) error {
+		row.Value = compression.Escape(row.Value)
+		row.OldValue = compression.Escape(row.OldValue)
+		return fn(row)
+	}
+}
+
+// scanRows calls fn with the rows of the kine table selected by rows, and
+// closes them.
+func scanRows(rows *sql.Rows, fn func(*Row) error) error {
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			row                                   Row
+			created, deleted, prevRevision, lease sql.NullInt64
+		)
+		if err := rows.Scan(&row.ID, &row.Name, &created, &deleted, &row.CreateRevision, &prevRevision, &lease, &row.Value, &row.OldValue); err != nil {
+			return err
+		}
+		row.Created = created.Int64 != 0
+		row.Deleted = deleted.Int64 != 0
+		row.PrevRevision = prevRevision.Int64
+		row.Lease = lease.Int64
+		if err := fn(&row); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
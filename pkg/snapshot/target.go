@@ -0,0 +1,99 @@
+package snapshot
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Target stores backup archives.
+type Target interface {
+	// Put stores the file at path as the archive name.
+	Put(ctx context.Context, name, path string) error
+	// List returns the names of the archives stored, sorted.
+	List(ctx context.Context) ([]string, error)
+	// Delete removes the archive name.
+	Delete(ctx context.Context, name string) error
+}
+
+// NewTarget returns the target storing backup archives at the given URL:
+// file:///path for a local directory, or s3://bucket/prefix for an S3
+// bucket, configured as described by newS3Target.
+func NewTarget(rawURL string) (Target, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid backup target %q: %w", rawURL, err)
+	}
+	switch u.Scheme {
+	case "file":
+		if u.Path == "" {
+			return nil, fmt.Errorf("backup target %q has no path", rawURL)
+		}
+		return &fileTarget{dir: filepath.Clean(u.Path)}, nil
+	case "s3":
+		return newS3Target(u)
+	}
+	return nil, fmt.Errorf("unsupported backup target %q, expected file:// or s3://", rawURL)
+}
+
+// fileTarget stores the archives in a local directory, e.g. a mounted
+// network file system.
+type fileTarget struct {
+	dir string
+}
+
+func (t *fileTarget) Put(ctx context.Context, name, path string) error {
+	if err := os.MkdirAll(t.dir, 0700); err != nil {
+		return err
+	}
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	// The archive is copied to a temporary file first, so that the
+	// archives listed are always complete.
+	dst, err := os.CreateTemp(t.dir, ".backup-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(dst.Name())
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := dst.Sync(); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+	return os.Rename(dst.Name(), filepath.Join(t.dir, name))
+}
+
+func (t *fileTarget) List(ctx context.Context) ([]string, error) {
+	entries, err := os.ReadDir(t.dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, entry := range entries {
+		if entry.Type().IsRegular() && entry.Name()[0] != '.' {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (t *fileTarget) Delete(ctx context.Context, name string) error {
+	return os.Remove(filepath.Join(t.dir, name))
+}
@@ -0,0 +1,65 @@
+package test
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/canonical/k8s-dqlite/pkg/kine/endpoint"
+	"github.com/canonical/k8s-dqlite/pkg/kine/server"
+	. "github.com/onsi/gomega"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+type auditRecorder struct {
+	mu      sync.Mutex
+	entries []server.AuditEntry
+}
+
+func (r *auditRecorder) Record(entry server.AuditEntry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entries = append(r.entries, entry)
+}
+
+func (r *auditRecorder) ops() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var ops []string
+	for _, entry := range r.entries {
+		ops = append(ops, entry.Op+" "+entry.Key)
+	}
+	return ops
+}
+
+func TestAudit(t *testing.T) {
+	for _, backendType := range []string{endpoint.SQLiteBackend, endpoint.DQLiteBackend, endpoint.MemoryBackend} {
+		t.Run(backendType, func(t *testing.T) {
+			g := NewWithT(t)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			recorder := &auditRecorder{}
+			kine := newKineServer(ctx, t, &kineOptions{
+				backendType: backendType,
+				auditLog:    recorder,
+			})
+
+			rev := createKey(ctx, g, kine.client, "/audit/a", "value")
+			updateRev(ctx, g, kine.client, "/audit/a", rev, "updated")
+			_, err := kine.client.Txn(ctx).Then(
+				clientv3.OpPut("/audit/b", "value"),
+				clientv3.OpDelete("/audit/a"),
+			).Commit()
+			g.Expect(err).To(BeNil())
+
+			g.Expect(recorder.ops()).To(Equal([]string{
+				"create /audit/a",
+				"update /audit/a",
+				"create /audit/b",
+				"delete /audit/a",
+			}))
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/canonical/k8s-dqlite/pkg/bench"
+	"github.com/canonical/k8s-dqlite/pkg/kine/endpoint"
+	. "github.com/onsi/gomega"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// TestBench runs a short benchmark of each operation against kine.
+func TestBench(t *testing.T) {
+	for _, backendType := range []string{endpoint.SQLiteBackend, endpoint.DQLiteBackend, endpoint.MemoryBackend} {
+		t.Run(backendType, func(t *testing.T) {
+			g := NewWithT(t)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			kine := newKineServer(ctx, t, &kineOptions{backendType: backendType})
+
+			result, err := bench.Run(ctx, kine.client, bench.Config{
+				Workload:    bench.Workload{bench.OpPut: 1, bench.OpGet: 1, bench.OpList: 1, bench.OpWatch: 1},
+				Concurrency: 4,
+				Duration:    10 * time.Second,
+				Requests:    200,
+				Prefix:      "/bench/",
+				Keys:        50,
+				KeySize:     16,
+				ValueSize:   100,
+				ListLimit:   10,
+			})
+			g.Expect(err).To(BeNil())
+			g.Expect(result.Ops).To(HaveLen(4))
+
+			var count int64
+			for _, op := range result.Ops {
+				g.Expect(op.Errors).To(BeZero(), op.Op)
+				g.Expect(op.Count).To(BeNumerically(">", 0), op.Op)
+				g.Expect(op.P50).To(BeNumerically("<=", op.P99), op.Op)
+				g.Expect(op.P99).To(BeNumerically("<=", op.Max), op.Op)
+				count += op.Count
+			}
+			g.Expect(count).To(Equal(int64(200)))
+
+			// The keys of the benchmark are deleted.
+			resp, err := kine.client.Get(ctx, "/bench/", clientv3.WithPrefix(), clientv3.WithCountOnly())
+			g.Expect(err).To(BeNil())
+			g.Expect(resp.Count).To(BeZero())
+		})
+	}
+}
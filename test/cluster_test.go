@@ -0,0 +1,206 @@
+package test
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/canonical/k8s-dqlite/pkg/kine/endpoint"
+	"github.com/canonical/k8s-dqlite/pkg/kine/server"
+	. "github.com/onsi/gomega"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// fakeCluster is an in-memory cluster, whose local member is the first one.
+type fakeCluster struct {
+	mu      sync.Mutex
+	members map[uint64]server.Member
+	nextID  uint64
+}
+
+func newFakeCluster() *fakeCluster {
+	return &fakeCluster{
+		members: map[uint64]server.Member{
+			1: {ID: 1, Name: "node1", PeerURLs: []string{"https://10.0.0.1:9000"}},
+		},
+		nextID: 2,
+	}
+}
+
+func (c *fakeCluster) MemberID() uint64 { return 1 }
+
+func (c *fakeCluster) ClusterID() uint64 { return 42 }
+
+func (c *fakeCluster) Leader(context.Context) (uint64, error) { return 1, nil }
+
+func (c *fakeCluster) RaftStatus() (uint64, uint64, error) { return 3, 42, nil }
+
+func (c *fakeCluster) Members(context.Context) ([]server.Member, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	members := make([]server.Member, 0, len(c.members))
+	for _, member := range c.members {
+		members = append(members, member)
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].ID < members[j].ID })
+	return members, nil
+}
+
+func (c *fakeCluster) AddMember(_ context.Context, peerURL string, learner bool) (server.Member, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	member := server.Member{ID: c.nextID, PeerURLs: []string{peerURL}, Learner: learner}
+	c.members[member.ID] = member
+	c.nextID++
+	return member, nil
+}
+
+func (c *fakeCluster) RemoveMember(_ context.Context, id uint64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.members[id]; !ok {
+		return fmt.Errorf("member %d not found", id)
+	}
+	delete(c.members, id)
+	return nil
+}
+
+func (c *fakeCluster) UpdateMember(_ context.Context, id uint64, peerURLs []string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	member, ok := c.members[id]
+	if !ok {
+		return fmt.Errorf("member %d not found", id)
+	}
+	member.PeerURLs = peerURLs
+	c.members[id] = member
+	return nil
+}
+
+func (c *fakeCluster) PromoteMember(_ context.Context, id uint64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	member, ok := c.members[id]
+	if !ok {
+		return fmt.Errorf("member %d not found", id)
+	}
+	member.Learner = false
+	c.members[id] = member
+	return nil
+}
+
+func TestMembers(t *testing.T) {
+	for _, backendType := range []string{endpoint.SQLiteBackend, endpoint.DQLiteBackend, endpoint.MemoryBackend} {
+		t.Run(backendType, func(t *testing.T) {
+			g := NewWithT(t)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			kine := newKineServer(ctx, t, &kineOptions{backendType: backendType, cluster: newFakeCluster()})
+
+			list, err := kine.client.MemberList(ctx)
+			g.Expect(err).To(BeNil())
+			g.Expect(list.Header.MemberId).To(Equal(uint64(1)))
+			g.Expect(list.Members).To(HaveLen(1))
+			g.Expect(list.Members[0].Name).To(Equal("node1"))
+			g.Expect(list.Members[0].PeerURLs).To(Equal([]string{"https://10.0.0.1:9000"}))
+
+			added, err := kine.client.MemberAddAsLearner(ctx, []string{"https://10.0.0.2:9000"})
+			g.Expect(err).To(BeNil())
+			g.Expect(added.Member.IsLearner).To(BeTrue())
+			g.Expect(added.Members).To(HaveLen(2))
+
+			_, err = kine.client.MemberPromote(ctx, added.Member.ID)
+			g.Expect(err).To(BeNil())
+			_, err = kine.client.MemberUpdate(ctx, added.Member.ID, []string{"https://10.0.0.3:9000"})
+			g.Expect(err).To(BeNil())
+
+			list, err = kine.client.MemberList(ctx)
+			g.Expect(err).To(BeNil())
+			g.Expect(list.Members).To(HaveLen(2))
+			g.Expect(list.Members[1].IsLearner).To(BeFalse())
+			g.Expect(list.Members[1].PeerURLs).To(Equal([]string{"https://10.0.0.3:9000"}))
+
+			_, err = kine.client.MemberAdd(ctx, []string{"https://10.0.0.4:9000", "https://10.0.0.5:9000"})
+			g.Expect(err).NotTo(BeNil())
+
+			removed, err := kine.client.MemberRemove(ctx, added.Member.ID)
+			g.Expect(err).To(BeNil())
+			g.Expect(removed.Members).To(HaveLen(1))
+		})
+	}
+}
+
+func TestMembersWithoutCluster(t *testing.T) {
+	g := NewWithT(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	kine := newKineServer(ctx, t, &kineOptions{backendType: endpoint.SQLiteBackend})
+	_, err := kine.client.MemberList(ctx)
+	g.Expect(err).NotTo(BeNil())
+}
+
+func TestResponseHeaders(t *testing.T) {
+	for _, backendType := range []string{endpoint.SQLiteBackend, endpoint.DQLiteBackend, endpoint.MemoryBackend} {
+		t.Run(backendType, func(t *testing.T) {
+			g := NewWithT(t)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			kine := newKineServer(ctx, t, &kineOptions{backendType: backendType, cluster: newFakeCluster()})
+
+			watch := kine.client.Watch(ctx, "key", clientv3.WithRev(1))
+
+			txn, err := kine.client.Txn(ctx).
+				If(clientv3.Compare(clientv3.ModRevision("key"), "=", 0)).
+				Then(clientv3.OpPut("key", "value")).
+				Commit()
+			g.Expect(err).To(BeNil())
+			g.Expect(txn.Succeeded).To(BeTrue())
+			g.Expect(txn.Header.ClusterId).To(Equal(uint64(42)))
+			g.Expect(txn.Header.MemberId).To(Equal(uint64(1)))
+
+			get, err := kine.client.Get(ctx, "key")
+			g.Expect(err).To(BeNil())
+			g.Expect(get.Header.ClusterId).To(Equal(uint64(42)))
+			g.Expect(get.Header.MemberId).To(Equal(uint64(1)))
+
+			select {
+			case resp := <-watch:
+				g.Expect(resp.Header.ClusterId).To(Equal(uint64(42)))
+				g.Expect(resp.Header.MemberId).To(Equal(uint64(1)))
+			case <-time.After(5 * time.Second):
+				t.Fatal("no watch response")
+			}
+		})
+	}
+}
+
+func TestClusterStatus(t *testing.T) {
+	for _, backendType := range []string{endpoint.SQLiteBackend, endpoint.DQLiteBackend, endpoint.MemoryBackend} {
+		t.Run(backendType, func(t *testing.T) {
+			g := NewWithT(t)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			kine := newKineServer(ctx, t, &kineOptions{backendType: backendType, cluster: newFakeCluster()})
+
+			resp, err := kine.client.Status(ctx, kine.client.Endpoints()[0])
+			g.Expect(err).To(BeNil())
+			g.Expect(resp.Errors).To(BeEmpty())
+			g.Expect(resp.Leader).To(Equal(uint64(1)))
+			g.Expect(resp.Header.RaftTerm).To(Equal(uint64(3)))
+			g.Expect(resp.RaftTerm).To(Equal(uint64(3)))
+			g.Expect(resp.RaftIndex).To(Equal(uint64(42)))
+			g.Expect(resp.RaftAppliedIndex).To(Equal(uint64(42)))
+		})
+	}
+}
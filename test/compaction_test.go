@@ -7,11 +7,14 @@ import (
 
 	"github.com/canonical/k8s-dqlite/pkg/kine/endpoint"
 	"github.com/canonical/k8s-dqlite/pkg/kine/logstructured/sqllog"
+	"github.com/canonical/k8s-dqlite/pkg/kine/server"
 	. "github.com/onsi/gomega"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	clientv3 "go.etcd.io/etcd/client/v3"
 )
 
 func TestCompaction(t *testing.T) {
-	for _, backendType := range []string{endpoint.SQLiteBackend, endpoint.DQLiteBackend} {
+	for _, backendType := range []string{endpoint.SQLiteBackend, endpoint.DQLiteBackend, endpoint.MemoryBackend} {
 		t.Run(backendType, func(t *testing.T) {
 			t.Run("SmallDatabaseDeleteEntry", func(t *testing.T) {
 				g := NewWithT(t)
@@ -92,8 +95,54 @@ func TestCompaction(t *testing.T) {
 	}
 }
 
+func TestCompactTo(t *testing.T) {
+	for _, backendType := range []string{endpoint.SQLiteBackend, endpoint.DQLiteBackend, endpoint.MemoryBackend} {
+		t.Run(backendType, func(t *testing.T) {
+			g := NewWithT(t)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			kine := newKineServer(ctx, t, &kineOptions{backendType: backendType})
+
+			rev := createKey(ctx, g, kine.client, "/compact/a", "1")
+			rev = updateRev(ctx, g, kine.client, "/compact/a", rev, "2")
+			updateRev(ctx, g, kine.client, "/compact/a", rev, "3")
+
+			compactRevision, err := kine.backend.CompactTo(ctx, rev)
+			g.Expect(err).To(BeNil())
+			g.Expect(compactRevision).To(Equal(rev))
+
+			_, _, err = kine.backend.List(ctx, "/compact/", "", 0, rev-1)
+			g.Expect(err).To(Equal(server.ErrCompacted))
+
+			// The clients get the errors of etcd for the compacted revisions.
+			_, err = kine.client.Get(ctx, "/compact/", clientv3.WithPrefix(), clientv3.WithRev(rev-1))
+			g.Expect(err).To(MatchError(rpctypes.ErrCompacted))
+			var resp clientv3.WatchResponse
+			g.Eventually(kine.client.Watch(ctx, "/compact/", clientv3.WithPrefix(), clientv3.WithRev(rev-1))).Should(Receive(&resp))
+			g.Expect(resp.CompactRevision).To(Equal(rev))
+			g.Expect(resp.Err()).To(MatchError(rpctypes.ErrCompacted))
+			_, kvs, err := kine.backend.List(ctx, "/compact/", "", 0, 0)
+			g.Expect(err).To(BeNil())
+			g.Expect(kvs).To(HaveLen(1))
+			g.Expect(string(kvs[0].Value)).To(Equal("3"))
+
+			// Compacting again up to an older revision is a no-op.
+			compactRevision, err = kine.backend.CompactTo(ctx, rev-1)
+			g.Expect(err).To(BeNil())
+			g.Expect(compactRevision).To(Equal(rev))
+
+			current, err := kine.backend.CurrentRevision(ctx)
+			g.Expect(err).To(BeNil())
+			_, err = kine.backend.CompactTo(ctx, current+10)
+			g.Expect(err).To(Equal(server.ErrFutureRev))
+		})
+	}
+}
+
 func BenchmarkCompaction(b *testing.B) {
-	for _, backendType := range []string{endpoint.SQLiteBackend, endpoint.DQLiteBackend} {
+	for _, backendType := range []string{endpoint.SQLiteBackend, endpoint.DQLiteBackend, endpoint.MemoryBackend} {
 		b.Run(backendType, func(b *testing.B) {
 			b.StopTimer()
 			ctx, cancel := context.WithCancel(context.Background())
@@ -13,7 +13,7 @@ import (
 
 // TestCreate is unit testing for the create operation.
 func TestCreate(t *testing.T) {
-	for _, backendType := range []string{endpoint.SQLiteBackend, endpoint.DQLiteBackend} {
+	for _, backendType := range []string{endpoint.SQLiteBackend, endpoint.DQLiteBackend, endpoint.MemoryBackend} {
 		t.Run(backendType, func(t *testing.T) {
 			g := NewWithT(t)
 
@@ -35,9 +35,60 @@ func TestCreate(t *testing.T) {
 	}
 }
 
+// TestCreateBatched checks that concurrent creates are committed correctly
+// when write batching is enabled.
+func TestCreateBatched(t *testing.T) {
+	for _, backendType := range []string{endpoint.SQLiteBackend, endpoint.DQLiteBackend, endpoint.MemoryBackend} {
+		t.Run(backendType, func(t *testing.T) {
+			g := NewWithT(t)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			kine := newKineServer(ctx, t, &kineOptions{
+				backendType:        backendType,
+				endpointParameters: []string{"write-batch-size=16"},
+			})
+
+			const workers = 32
+			var succeeded sync.Map
+			wg := &sync.WaitGroup{}
+			wg.Add(workers)
+			for worker := 0; worker < workers; worker++ {
+				go func(worker int) {
+					defer wg.Done()
+					createKey(ctx, g, kine.client, fmt.Sprintf("/batch/key-%d", worker), "value")
+
+					// All the workers race to create the same key.
+					resp, err := kine.client.Txn(ctx).
+						If(clientv3.Compare(clientv3.ModRevision("shared"), "=", 0)).
+						Then(clientv3.OpPut("shared", fmt.Sprintf("value-%d", worker))).
+						Commit()
+					g.Expect(err).To(BeNil())
+					if resp.Succeeded {
+						succeeded.Store(worker, resp.Header.Revision)
+					}
+				}(worker)
+			}
+			wg.Wait()
+
+			var winners int
+			succeeded.Range(func(_, _ any) bool {
+				winners++
+				return true
+			})
+			g.Expect(winners).To(Equal(1))
+
+			resp, err := kine.client.Get(ctx, "/batch/", clientv3.WithPrefix())
+			g.Expect(err).To(BeNil())
+			g.Expect(resp.Kvs).To(HaveLen(workers))
+		})
+	}
+}
+
 // BenchmarkCreate is a benchmark for the Create operation.
 func BenchmarkCreate(b *testing.B) {
-	for _, backendType := range []string{endpoint.SQLiteBackend, endpoint.DQLiteBackend} {
+	for _, backendType := range []string{endpoint.SQLiteBackend, endpoint.DQLiteBackend, endpoint.MemoryBackend} {
 		for _, workers := range []int{1, 4, 16, 64, 128} {
 			b.Run(fmt.Sprintf("%s/%d-workers", backendType, workers), func(b *testing.B) {
 				b.StopTimer()
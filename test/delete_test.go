@@ -14,21 +14,31 @@ import (
 
 // TestDelete is unit testing for the delete operation.
 func TestDelete(t *testing.T) {
-	for _, backendType := range []string{endpoint.SQLiteBackend, endpoint.DQLiteBackend} {
+	for _, backendType := range []string{endpoint.SQLiteBackend, endpoint.DQLiteBackend, endpoint.MemoryBackend} {
 		t.Run(backendType, func(t *testing.T) {
 			ctx, cancel := context.WithCancel(context.Background())
 			defer cancel()
 
 			kine := newKineServer(ctx, t, &kineOptions{backendType: backendType})
 
-			// Calling the delete method outside a transaction should fail in kine
-			t.Run("NotSupportedFails", func(t *testing.T) {
+			t.Run("DeleteRange", func(t *testing.T) {
 				g := NewWithT(t)
-				resp, err := kine.client.Delete(ctx, "missingKey")
 
-				g.Expect(err).NotTo(BeNil())
-				g.Expect(err.Error()).To(ContainSubstring("delete is not supported"))
-				g.Expect(resp).To(BeNil())
+				for _, key := range []string{"/deleteRange/a", "/deleteRange/b", "/deleteRange/c"} {
+					createKey(ctx, g, kine.client, key, "value")
+				}
+
+				resp, err := kine.client.Delete(ctx, "/deleteRange/a", clientv3.WithRange("/deleteRange/c"), clientv3.WithPrevKV())
+				g.Expect(err).To(BeNil())
+				g.Expect(resp.Deleted).To(Equal(int64(2)))
+				g.Expect(resp.PrevKvs).To(HaveLen(2))
+				assertMissingKey(ctx, g, kine.client, "/deleteRange/a")
+				assertMissingKey(ctx, g, kine.client, "/deleteRange/b")
+				assertKey(ctx, g, kine.client, "/deleteRange/c", "value")
+
+				resp, err = kine.client.Delete(ctx, "/deleteRange/missing")
+				g.Expect(err).To(BeNil())
+				g.Expect(resp.Deleted).To(BeZero())
 			})
 
 			// Delete a key that does not exist
@@ -66,7 +76,7 @@ func TestDelete(t *testing.T) {
 
 // BenchmarkDelete is a benchmark for the delete operation.
 func BenchmarkDelete(b *testing.B) {
-	for _, backendType := range []string{endpoint.SQLiteBackend, endpoint.DQLiteBackend} {
+	for _, backendType := range []string{endpoint.SQLiteBackend, endpoint.DQLiteBackend, endpoint.MemoryBackend} {
 		for _, workers := range []int{1, 4, 16, 64, 128} {
 			b.Run(fmt.Sprintf("%s/%d-workers", backendType, workers), func(b *testing.B) {
 				b.StopTimer()
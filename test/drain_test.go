@@ -0,0 +1,41 @@
+package test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/canonical/k8s-dqlite/pkg/kine/endpoint"
+	. "github.com/onsi/gomega"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+func TestDrain(t *testing.T) {
+	for _, backendType := range []string{endpoint.SQLiteBackend, endpoint.DQLiteBackend, endpoint.MemoryBackend} {
+		t.Run(backendType, func(t *testing.T) {
+			g := NewWithT(t)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			kine := newKineServer(ctx, t, &kineOptions{backendType: backendType})
+
+			_, err := kine.client.Get(ctx, "key")
+			g.Expect(err).To(BeNil())
+			watch := kine.client.Watch(ctx, "key", clientv3.WithCreatedNotify())
+			g.Eventually(watch).Should(Receive())
+
+			// The open watches do not delay the drain.
+			drainCtx, drainCancel := context.WithTimeout(ctx, 10*time.Second)
+			defer drainCancel()
+			start := time.Now()
+			kine.server.Drain(drainCtx)
+			g.Expect(time.Since(start)).To(BeNumerically("<", 5*time.Second))
+
+			requestCtx, requestCancel := context.WithTimeout(ctx, time.Second)
+			defer requestCancel()
+			_, err = kine.client.Get(requestCtx, "key")
+			g.Expect(err).NotTo(BeNil())
+		})
+	}
+}
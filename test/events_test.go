@@ -0,0 +1,178 @@
+package test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/canonical/k8s-dqlite/pkg/kine/endpoint"
+	. "github.com/onsi/gomega"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// eventsPrefix is the prefix of the keys stored in the events table.
+const eventsPrefix = "/registry/events/"
+
+// TestEventsTable checks that the events are stored in a table of their
+// own, sharing the revisions of the other keys, when the events table is
+// enabled.
+func TestEventsTable(t *testing.T) {
+	for _, backendType := range []string{endpoint.SQLiteBackend, endpoint.DQLiteBackend, endpoint.MemoryBackend} {
+		t.Run(backendType, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			kine := newKineServer(ctx, t, &kineOptions{
+				backendType: backendType,
+				eventsTable: true,
+			})
+
+			const (
+				podKey   = "/registry/pods/default/pod"
+				eventKey = "/registry/events/default/event"
+			)
+
+			t.Run("Revisions", func(t *testing.T) {
+				g := NewWithT(t)
+
+				podRev := createKey(ctx, g, kine.client, podKey, "pod")
+				eventRev := createKey(ctx, g, kine.client, eventKey, "event")
+				otherPodRev := createKey(ctx, g, kine.client, podKey+"-other", "pod")
+				g.Expect(eventRev).To(Equal(podRev + 1))
+				g.Expect(otherPodRev).To(Equal(eventRev + 1))
+
+				resp, err := kine.client.Get(ctx, eventKey)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(resp.Kvs).To(HaveLen(1))
+				g.Expect(resp.Kvs[0].ModRevision).To(Equal(eventRev))
+				g.Expect(resp.Header.Revision).To(Equal(otherPodRev))
+
+				resp, err = kine.client.Get(ctx, eventsPrefix, clientv3.WithPrefix())
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(resp.Kvs).To(HaveLen(1))
+				g.Expect(resp.Kvs[0].Key).To(Equal([]byte(eventKey)))
+
+				// The ranges spanning both tables list the keys of both, in
+				// order.
+				resp, err = kine.client.Get(ctx, eventsPrefix, clientv3.WithRange("/registry/pods0"))
+				g.Expect(err).NotTo(HaveOccurred())
+				var keys []string
+				for _, kv := range resp.Kvs {
+					keys = append(keys, string(kv.Key))
+				}
+				g.Expect(keys).To(Equal([]string{eventKey, "/registry/health", podKey, podKey + "-other"}))
+				g.Expect(resp.Header.Revision).To(Equal(otherPodRev))
+
+				resp, err = kine.client.Get(ctx, "/registry/", clientv3.WithPrefix(), clientv3.WithCountOnly())
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(resp.Count).To(Equal(int64(4)))
+			})
+
+			t.Run("Watch", func(t *testing.T) {
+				ctx, cancel := context.WithCancel(ctx)
+				defer cancel()
+				g := NewWithT(t)
+
+				current, err := kine.backend.CurrentRevision(ctx)
+				g.Expect(err).NotTo(HaveOccurred())
+
+				// All the watches share the stream of the context.
+				allCh := kine.client.Watch(ctx, "/registry/", clientv3.WithPrefix(), clientv3.WithRev(current+1))
+				eventsCh := kine.client.Watch(ctx, "/registry/events/watch/", clientv3.WithPrefix())
+				podsCh := kine.client.Watch(ctx, "/registry/pods/watch/", clientv3.WithPrefix())
+
+				// The watches spanning both tables see the keys of both.
+				eventRev := createKey(ctx, g, kine.client, "/registry/events/watch/event", "event")
+				g.Eventually(eventsCh, 2*time.Second).Should(ReceiveEvents(g,
+					CreateEvent(g, "/registry/events/watch/event", "event", eventRev),
+				))
+				g.Eventually(allCh, 2*time.Second).Should(ReceiveEvents(g,
+					CreateEvent(g, "/registry/events/watch/event", "event", eventRev),
+				))
+				podRev := createKey(ctx, g, kine.client, "/registry/pods/watch/pod", "pod")
+				g.Eventually(podsCh, 2*time.Second).Should(ReceiveEvents(g,
+					CreateEvent(g, "/registry/pods/watch/pod", "pod", podRev),
+				))
+				g.Eventually(allCh, 2*time.Second).Should(ReceiveEvents(g,
+					CreateEvent(g, "/registry/pods/watch/pod", "pod", podRev),
+				))
+
+				// The watches are notified of the same revision.
+				isProgressNotify := func(resp clientv3.WatchResponse) bool {
+					return resp.IsProgressNotify() && resp.Header.Revision == podRev
+				}
+				g.Eventually(func(g Gomega) {
+					g.Expect(kine.client.RequestProgress(ctx)).To(Succeed())
+					g.Eventually(eventsCh, 100*time.Millisecond).Should(Receive(Satisfy(isProgressNotify)))
+					g.Eventually(podsCh, 100*time.Millisecond).Should(Receive(Satisfy(isProgressNotify)))
+				}, 2*time.Second).Should(Succeed())
+			})
+
+			t.Run("Txn", func(t *testing.T) {
+				g := NewWithT(t)
+
+				key := "/registry/events/txn/event"
+				createKey(ctx, g, kine.client, key, "event")
+
+				// The transactions span both tables.
+				resp, err := kine.client.Txn(ctx).
+					If(clientv3.Compare(clientv3.Value(podKey), "=", "pod")).
+					Then(clientv3.OpGet(key), clientv3.OpPut(key, "updated")).
+					Commit()
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(resp.Succeeded).To(BeTrue())
+				g.Expect(resp.Responses[0].GetResponseRange().Kvs).To(HaveLen(1))
+
+				getResp, err := kine.client.Get(ctx, key)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(getResp.Kvs).To(HaveLen(1))
+				g.Expect(string(getResp.Kvs[0].Value)).To(Equal("updated"))
+				g.Expect(getResp.Kvs[0].ModRevision).To(Equal(resp.Header.Revision))
+			})
+
+			t.Run("Lease", func(t *testing.T) {
+				g := NewWithT(t)
+
+				lease, err := kine.client.Lease.Grant(ctx, 1)
+				g.Expect(err).NotTo(HaveOccurred())
+
+				key := "/registry/events/lease/event"
+				resp, err := kine.client.Txn(ctx).
+					If(clientv3.Compare(clientv3.ModRevision(key), "=", 0)).
+					Then(clientv3.OpPut(key, "event", clientv3.WithLease(lease.ID))).
+					Commit()
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(resp.Succeeded).To(BeTrue())
+
+				g.Eventually(func(g Gomega) {
+					resp, err := kine.client.Get(ctx, key)
+					g.Expect(err).NotTo(HaveOccurred())
+					g.Expect(resp.Kvs).To(BeEmpty())
+				}, 10*time.Second, testExpirePollPeriod).Should(Succeed())
+			})
+
+			t.Run("Compact", func(t *testing.T) {
+				g := NewWithT(t)
+
+				key := "/registry/events/compact/event"
+				rev := createKey(ctx, g, kine.client, key, "1")
+				rev = updateRev(ctx, g, kine.client, key, rev, "2")
+
+				compactRevision, err := kine.backend.CompactTo(ctx, rev)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(compactRevision).To(Equal(rev))
+
+				// Both tables are compacted up to the revision.
+				for _, prefix := range []string{eventsPrefix, "/registry/pods/", "/registry/"} {
+					_, err = kine.client.Get(ctx, prefix, clientv3.WithPrefix(), clientv3.WithRev(rev-1))
+					g.Expect(err).To(MatchError(rpctypes.ErrCompacted))
+				}
+				resp, err := kine.client.Get(ctx, key)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(resp.Kvs).To(HaveLen(1))
+				g.Expect(string(resp.Kvs[0].Value)).To(Equal("2"))
+			})
+		})
+	}
+}
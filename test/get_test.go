@@ -4,6 +4,7 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"sync"
 	"testing"
 
 	"github.com/canonical/k8s-dqlite/pkg/kine/endpoint"
@@ -13,7 +14,7 @@ import (
 
 // TestGet is unit testing for the Get operation.
 func TestGet(t *testing.T) {
-	for _, backendType := range []string{endpoint.SQLiteBackend, endpoint.DQLiteBackend} {
+	for _, backendType := range []string{endpoint.SQLiteBackend, endpoint.DQLiteBackend, endpoint.MemoryBackend} {
 		t.Run(backendType, func(t *testing.T) {
 			ctx, cancel := context.WithCancel(context.Background())
 			defer cancel()
@@ -62,6 +63,18 @@ func TestGet(t *testing.T) {
 				g.Expect(resp.Kvs[0].Value).To(Equal([]byte("testValue")))
 			})
 
+			t.Run("Serializable", func(t *testing.T) {
+				g := NewWithT(t)
+				key := "testKeySerializable"
+
+				createKey(ctx, g, kine.client, key, "testValue")
+
+				resp, err := kine.client.Get(ctx, key, clientv3.WithSerializable())
+				g.Expect(err).To(BeNil())
+				g.Expect(resp.Kvs).To(HaveLen(1))
+				g.Expect(resp.Kvs[0].Value).To(Equal([]byte("testValue")))
+			})
+
 			t.Run("KeyRevision", func(t *testing.T) {
 				g := NewWithT(t)
 				key := "testKeyRevision"
@@ -116,7 +129,7 @@ func TestGet(t *testing.T) {
 
 // BenchmarkGet is a benchmark for the Get operation.
 func BenchmarkGet(b *testing.B) {
-	for _, backendType := range []string{endpoint.SQLiteBackend, endpoint.DQLiteBackend} {
+	for _, backendType := range []string{endpoint.SQLiteBackend, endpoint.DQLiteBackend, endpoint.MemoryBackend} {
 		b.Run(backendType, func(b *testing.B) {
 			b.StopTimer()
 			g := NewWithT(b)
@@ -148,3 +161,83 @@ func BenchmarkGet(b *testing.B) {
 		})
 	}
 }
+
+// TestHistory checks that the history of a key lists its retained
+// revisions, tombstones included.
+func TestHistory(t *testing.T) {
+	for _, backendType := range []string{endpoint.SQLiteBackend, endpoint.DQLiteBackend, endpoint.MemoryBackend} {
+		t.Run(backendType, func(t *testing.T) {
+			g := NewWithT(t)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			kine := newKineServer(ctx, t, &kineOptions{backendType: backendType})
+
+			createRevision := createKey(ctx, g, kine.client, "/history/key", "a")
+			createKey(ctx, g, kine.client, "/history/other", "b")
+			updateRevision := updateRev(ctx, g, kine.client, "/history/key", createRevision, "c")
+			deleteRevision := deleteKey(ctx, g, kine.client, "/history/key", updateRevision)
+
+			_, events, err := kine.backend.History(ctx, "/history/key")
+			g.Expect(err).To(BeNil())
+			g.Expect(events).To(HaveLen(3))
+
+			g.Expect(events[0].Create).To(BeTrue())
+			g.Expect(events[0].KV.ModRevision).To(Equal(createRevision))
+			g.Expect(string(events[0].KV.Value)).To(Equal("a"))
+
+			g.Expect(events[1].Create || events[1].Delete).To(BeFalse())
+			g.Expect(events[1].KV.ModRevision).To(Equal(updateRevision))
+			g.Expect(events[1].KV.CreateRevision).To(Equal(createRevision))
+			g.Expect(events[1].PrevKV.ModRevision).To(Equal(createRevision))
+			g.Expect(string(events[1].KV.Value)).To(Equal("c"))
+
+			g.Expect(events[2].Delete).To(BeTrue())
+			g.Expect(events[2].KV.ModRevision).To(Equal(deleteRevision))
+
+			_, events, err = kine.backend.History(ctx, "/history/missing")
+			g.Expect(err).To(BeNil())
+			g.Expect(events).To(BeEmpty())
+		})
+	}
+}
+
+// TestGetReadBarrier checks that the linearizable reads see the writes
+// acknowledged before them when they wait for a read barrier.
+func TestGetReadBarrier(t *testing.T) {
+	for _, backendType := range []string{endpoint.SQLiteBackend, endpoint.DQLiteBackend} {
+		t.Run(backendType, func(t *testing.T) {
+			g := NewWithT(t)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			kine := newKineServer(ctx, t, &kineOptions{
+				backendType:        backendType,
+				endpointParameters: []string{"read-barrier=true"},
+			})
+
+			const readers = 16
+			wg := &sync.WaitGroup{}
+			wg.Add(readers)
+			for reader := 0; reader < readers; reader++ {
+				go func(reader int) {
+					defer wg.Done()
+					key := fmt.Sprintf("/barrier/key-%d", reader)
+					revision := createKey(ctx, g, kine.client, key, "value")
+
+					resp, err := kine.client.Get(ctx, key)
+					g.Expect(err).To(BeNil())
+					g.Expect(resp.Header.Revision).To(BeNumerically(">=", revision))
+					g.Expect(resp.Kvs).To(HaveLen(1))
+				}(reader)
+			}
+			wg.Wait()
+
+			resp, err := kine.client.Get(ctx, "/barrier/", clientv3.WithPrefix(), clientv3.WithSerializable())
+			g.Expect(err).To(BeNil())
+			g.Expect(resp.Kvs).To(HaveLen(readers))
+		})
+	}
+}
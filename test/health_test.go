@@ -0,0 +1,48 @@
+package test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/canonical/k8s-dqlite/pkg/kine/endpoint"
+	. "github.com/onsi/gomega"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// TestHealth checks that the gRPC health service of the kine endpoint
+// reports the datastore as serving.
+func TestHealth(t *testing.T) {
+	for _, backendType := range []string{endpoint.SQLiteBackend, endpoint.DQLiteBackend, endpoint.MemoryBackend} {
+		t.Run(backendType, func(t *testing.T) {
+			g := NewWithT(t)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			kine := newKineServer(ctx, t, &kineOptions{backendType: backendType})
+			client := healthpb.NewHealthClient(kine.client.ActiveConnection())
+
+			g.Eventually(func(g Gomega) {
+				resp, err := client.Check(ctx, &healthpb.HealthCheckRequest{})
+				g.Expect(err).To(BeNil())
+				g.Expect(resp.Status).To(Equal(healthpb.HealthCheckResponse_SERVING))
+			}, 5*time.Second, 100*time.Millisecond).Should(Succeed())
+		})
+	}
+}
+
+// TestCheckPollLoop checks that the poll loop reports being alive.
+func TestCheckPollLoop(t *testing.T) {
+	for _, backendType := range []string{endpoint.SQLiteBackend, endpoint.DQLiteBackend, endpoint.MemoryBackend} {
+		t.Run(backendType, func(t *testing.T) {
+			g := NewWithT(t)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			kine := newKineServer(ctx, t, &kineOptions{backendType: backendType})
+			g.Eventually(kine.backend.CheckPollLoop, 5*time.Second, 100*time.Millisecond).Should(Succeed())
+		})
+	}
+}
@@ -2,12 +2,15 @@ package test
 
 import (
 	"context"
+	"database/sql"
 	"testing"
 	"time"
 
 	"github.com/canonical/k8s-dqlite/pkg/kine/endpoint"
 	. "github.com/onsi/gomega"
+	"go.etcd.io/etcd/api/v3/etcdserverpb"
 	mvccpb "go.etcd.io/etcd/api/v3/mvccpb"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
 	clientv3 "go.etcd.io/etcd/client/v3"
 )
 
@@ -18,7 +21,7 @@ const (
 
 // TestLease is unit testing for the lease operation.
 func TestLease(t *testing.T) {
-	for _, backendType := range []string{endpoint.SQLiteBackend, endpoint.DQLiteBackend} {
+	for _, backendType := range []string{endpoint.SQLiteBackend, endpoint.DQLiteBackend, endpoint.MemoryBackend} {
 		t.Run(backendType, func(t *testing.T) {
 			ctx, cancel := context.WithCancel(context.Background())
 			defer cancel()
@@ -31,26 +34,33 @@ func TestLease(t *testing.T) {
 				resp, err := kine.client.Lease.Grant(ctx, ttl)
 
 				g.Expect(err).To(BeNil())
-				g.Expect(resp.ID).To(Equal(clientv3.LeaseID(ttl)))
+				g.Expect(resp.ID).NotTo(BeZero())
 				g.Expect(resp.TTL).To(Equal(ttl))
+
+				ttlResp, err := kine.client.Lease.TimeToLive(ctx, resp.ID)
+				g.Expect(err).To(BeNil())
+				g.Expect(ttlResp.GrantedTTL).To(Equal(ttl))
+				g.Expect(ttlResp.TTL).To(BeNumerically("<=", ttl))
+				g.Expect(ttlResp.TTL).To(BeNumerically(">", ttl-10))
 			})
 
 			t.Run("UseLease", func(t *testing.T) {
 				ttl := int64(1)
+				var leaseID clientv3.LeaseID
 				t.Run("CreateWithLease", func(t *testing.T) {
 					g := NewWithT(t)
 
 					{
 						resp, err := kine.client.Lease.Grant(ctx, ttl)
 						g.Expect(err).To(BeNil())
-						g.Expect(resp.ID).To(Equal(clientv3.LeaseID(ttl)))
 						g.Expect(resp.TTL).To(Equal(ttl))
+						leaseID = resp.ID
 					}
 
 					{
 						resp, err := kine.client.Txn(ctx).
 							If(clientv3.Compare(clientv3.ModRevision("/leaseTestKey"), "=", 0)).
-							Then(clientv3.OpPut("/leaseTestKey", "testValue", clientv3.WithLease(clientv3.LeaseID(ttl)))).
+							Then(clientv3.OpPut("/leaseTestKey", "testValue", clientv3.WithLease(leaseID))).
 							Commit()
 						g.Expect(err).To(BeNil())
 						g.Expect(resp.Succeeded).To(BeTrue())
@@ -62,20 +72,172 @@ func TestLease(t *testing.T) {
 						g.Expect(resp.Kvs).To(HaveLen(1))
 						g.Expect(resp.Kvs[0].Key).To(Equal([]byte("/leaseTestKey")))
 						g.Expect(resp.Kvs[0].Value).To(Equal([]byte("testValue")))
-						g.Expect(resp.Kvs[0].Lease).To(Equal(ttl))
+						g.Expect(resp.Kvs[0].Lease).To(Equal(int64(leaseID)))
 					}
 				})
 
 				t.Run("KeyShouldExpire", func(t *testing.T) {
 					g := NewWithT(t)
-					// timeout ttl*2 seconds, poll 100ms
+					// timeout ttl*3 seconds, poll 100ms
 					g.Eventually(func() []*mvccpb.KeyValue {
 						resp, err := kine.client.Get(ctx, "/leaseTestKey", clientv3.WithRange(""))
 						g.Expect(err).To(BeNil())
 						return resp.Kvs
-					}, time.Duration(ttl*2)*time.Second, testExpirePollPeriod, ctx).Should(BeEmpty())
+					}, time.Duration(ttl*3)*time.Second, testExpirePollPeriod, ctx).Should(BeEmpty())
+				})
+
+				t.Run("LeaseShouldExpire", func(t *testing.T) {
+					g := NewWithT(t)
+					resp, err := kine.client.Lease.TimeToLive(ctx, leaseID)
+					g.Expect(err).To(BeNil())
+					g.Expect(resp.TTL).To(Equal(int64(-1)))
 				})
 			})
+
+			t.Run("LeaseRevoke", func(t *testing.T) {
+				g := NewWithT(t)
+
+				resp, err := kine.client.Lease.Grant(ctx, 300)
+				g.Expect(err).To(BeNil())
+
+				for _, key := range []string{"/revokeTestKey/a", "/revokeTestKey/b"} {
+					txn, err := kine.client.Txn(ctx).
+						If(clientv3.Compare(clientv3.ModRevision(key), "=", 0)).
+						Then(clientv3.OpPut(key, "testValue", clientv3.WithLease(resp.ID))).
+						Commit()
+					g.Expect(err).To(BeNil())
+					g.Expect(txn.Succeeded).To(BeTrue())
+				}
+
+				g.Eventually(func() int {
+					ttlResp, err := kine.client.Lease.TimeToLive(ctx, resp.ID, clientv3.WithAttachedKeys())
+					g.Expect(err).To(BeNil())
+					return len(ttlResp.Keys)
+				}, 5*time.Second, testExpirePollPeriod, ctx).Should(Equal(2))
+
+				_, err = kine.client.Lease.Revoke(ctx, resp.ID)
+				g.Expect(err).To(BeNil())
+
+				getResp, err := kine.client.Get(ctx, "/revokeTestKey/", clientv3.WithPrefix())
+				g.Expect(err).To(BeNil())
+				g.Expect(getResp.Kvs).To(BeEmpty())
+			})
+
+			t.Run("LeaseKeepAlive", func(t *testing.T) {
+				g := NewWithT(t)
+
+				resp, err := kine.client.Lease.Grant(ctx, 300)
+				g.Expect(err).To(BeNil())
+
+				keepAlive, err := kine.client.Lease.KeepAliveOnce(ctx, resp.ID)
+				g.Expect(err).To(BeNil())
+				g.Expect(keepAlive.TTL).To(Equal(int64(300)))
+			})
+
+			t.Run("LeaseLeases", func(t *testing.T) {
+				g := NewWithT(t)
+
+				resp, err := kine.client.Lease.Grant(ctx, 300)
+				g.Expect(err).To(BeNil())
+
+				leases, err := kine.client.Lease.Leases(ctx)
+				g.Expect(err).To(BeNil())
+				g.Expect(leases.Leases).To(ContainElement(clientv3.LeaseStatus{ID: resp.ID}))
+			})
+
+			t.Run("UnknownLease", func(t *testing.T) {
+				g := NewWithT(t)
+
+				_, err := kine.client.Txn(ctx).
+					If(clientv3.Compare(clientv3.ModRevision("/unknownLeaseKey"), "=", 0)).
+					Then(clientv3.OpPut("/unknownLeaseKey", "testValue", clientv3.WithLease(clientv3.LeaseID(1<<30+1)))).
+					Commit()
+				g.Expect(err).To(MatchError(rpctypes.ErrLeaseNotFound))
+			})
+		})
+	}
+}
+
+// TestLeaseGrantedElsewhere checks that leases granted through other members,
+// which share the database, are enforced as the ones granted locally.
+func TestLeaseGrantedElsewhere(t *testing.T) {
+	const (
+		expiredLease = 1<<30 + 2
+		activeLease  = 1<<30 + 3
+	)
+
+	for _, backendType := range []string{endpoint.SQLiteBackend, endpoint.DQLiteBackend, endpoint.MemoryBackend} {
+		t.Run(backendType, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			kine := newKineServer(ctx, t, &kineOptions{
+				backendType: backendType,
+				setup: func(ctx context.Context, tx *sql.Tx) error {
+					expired := time.Now().Add(-time.Second).UnixMilli()
+					active := time.Now().Add(time.Hour).UnixMilli()
+					if _, err := tx.ExecContext(ctx, `INSERT INTO kine_leases(id, ttl, expiry) VALUES(?, 1, ?), (?, 3600, ?)`, expiredLease, expired, activeLease, active); err != nil {
+						return err
+					}
+					_, err := tx.ExecContext(ctx, `
+INSERT INTO kine(name, created, deleted, create_revision, prev_revision, lease, value, old_value)
+VALUES('/elsewhereTestKey', 1, 0, 0, 0, ?, 'testValue', NULL)`, expiredLease)
+					return err
+				},
+			})
+
+			t.Run("ExpiredLeaseRevoked", func(t *testing.T) {
+				g := NewWithT(t)
+				g.Eventually(func() []*mvccpb.KeyValue {
+					resp, err := kine.client.Get(ctx, "/elsewhereTestKey")
+					g.Expect(err).To(BeNil())
+					return resp.Kvs
+				}, 15*time.Second, testExpirePollPeriod, ctx).Should(BeEmpty())
+			})
+
+			t.Run("ExistingLeaseID", func(t *testing.T) {
+				g := NewWithT(t)
+				lease := etcdserverpb.NewLeaseClient(kine.client.ActiveConnection())
+				_, err := lease.LeaseGrant(ctx, &etcdserverpb.LeaseGrantRequest{ID: activeLease, TTL: 300})
+				g.Expect(err).To(MatchError(rpctypes.ErrGRPCLeaseExist))
+			})
+		})
+	}
+}
+
+// TestTTLKey checks that keys whose lease is a TTL, as set by previous
+// versions, are deleted once expired and that watchers see the deletion.
+func TestTTLKey(t *testing.T) {
+	for _, backendType := range []string{endpoint.SQLiteBackend, endpoint.DQLiteBackend, endpoint.MemoryBackend} {
+		t.Run(backendType, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			g := NewWithT(t)
+
+			kine := newKineServer(ctx, t, &kineOptions{backendType: backendType})
+
+			const key = "/ttlTestKey"
+			watchCh := kine.client.Watch(ctx, key)
+
+			resp, err := kine.client.Txn(ctx).
+				If(clientv3.Compare(clientv3.ModRevision(key), "=", 0)).
+				Then(clientv3.OpPut(key, "testValue", clientv3.WithLease(1))).
+				Commit()
+			g.Expect(err).To(BeNil())
+			g.Expect(resp.Succeeded).To(BeTrue())
+			createRev := resp.Header.Revision
+
+			g.Eventually(watchCh, 5*time.Second).Should(ReceiveEvents(g,
+				CreateEvent(g, key, "testValue", createRev),
+			))
+			g.Eventually(watchCh, 5*time.Second).Should(Receive(Satisfy(func(watch clientv3.WatchResponse) bool {
+				return g.Expect(watch.Events).To(HaveLen(1)) &&
+					DeleteEvent(g, key, "testValue", createRev, watch.Events[0].Kv.ModRevision)(watch.Events[0])
+			})))
+
+			getResp, err := kine.client.Get(ctx, key)
+			g.Expect(err).To(BeNil())
+			g.Expect(getResp.Kvs).To(BeEmpty())
 		})
 	}
 }
@@ -13,7 +13,7 @@ import (
 
 // TestList is the unit test for List operation.
 func TestList(t *testing.T) {
-	for _, backendType := range []string{endpoint.SQLiteBackend, endpoint.DQLiteBackend} {
+	for _, backendType := range []string{endpoint.SQLiteBackend, endpoint.DQLiteBackend, endpoint.MemoryBackend} {
 		t.Run(backendType, func(t *testing.T) {
 			g := NewWithT(t)
 
@@ -87,6 +87,121 @@ func TestList(t *testing.T) {
 				g.Expect(resp.Kvs[0].Key).To(Equal([]byte("/key/5")))
 			})
 
+			t.Run("ListRange", func(t *testing.T) {
+				g := NewWithT(t)
+
+				resp, err := kine.client.Get(ctx, "/key/2", clientv3.WithRange("/key/4"))
+
+				g.Expect(err).To(BeNil())
+				g.Expect(resp.Kvs).To(HaveLen(2))
+				g.Expect(resp.Count).To(Equal(int64(2)))
+				g.Expect(resp.Kvs[0].Key).To(Equal([]byte("/key/2")))
+				g.Expect(resp.Kvs[1].Key).To(Equal([]byte("/key/3")))
+
+				resp, err = kine.client.Get(ctx, "/key/2", clientv3.WithRange("/key/5"), clientv3.WithLimit(1))
+
+				g.Expect(err).To(BeNil())
+				g.Expect(resp.Kvs).To(HaveLen(1))
+				g.Expect(resp.More).To(BeTrue())
+				g.Expect(resp.Count).To(Equal(int64(3)))
+				g.Expect(resp.Kvs[0].Key).To(Equal([]byte("/key/2")))
+
+				resp, err = kine.client.Get(ctx, "/key/2", clientv3.WithRange("/key/5"), clientv3.WithCountOnly())
+
+				g.Expect(err).To(BeNil())
+				g.Expect(resp.Kvs).To(BeEmpty())
+				g.Expect(resp.Count).To(Equal(int64(3)))
+
+				// Other keys, like the ones of kine, sort after these.
+				resp, err = kine.client.Get(ctx, "/key/4", clientv3.WithFromKey())
+
+				g.Expect(err).To(BeNil())
+				g.Expect(len(resp.Kvs)).To(BeNumerically(">=", 2))
+				g.Expect(resp.Kvs[0].Key).To(Equal([]byte("/key/4")))
+				g.Expect(resp.Kvs[1].Key).To(Equal([]byte("/key/5")))
+			})
+
+			t.Run("ListSorted", func(t *testing.T) {
+				g := NewWithT(t)
+
+				// Keys were created from the last to the first.
+				resp, err := kine.client.Get(ctx, "/key/", clientv3.WithPrefix(), clientv3.WithSort(clientv3.SortByModRevision, clientv3.SortNone))
+
+				g.Expect(err).To(BeNil())
+				g.Expect(resp.Kvs).To(HaveLen(5))
+				g.Expect(resp.Kvs[0].Key).To(Equal([]byte("/key/5")))
+				g.Expect(resp.Kvs[4].Key).To(Equal([]byte("/key/1")))
+
+				resp, err = kine.client.Get(ctx, "/key/", clientv3.WithPrefix(), clientv3.WithSort(clientv3.SortByKey, clientv3.SortDescend), clientv3.WithLimit(2))
+
+				g.Expect(err).To(BeNil())
+				g.Expect(resp.Kvs).To(HaveLen(2))
+				g.Expect(resp.More).To(BeTrue())
+				g.Expect(resp.Count).To(Equal(int64(5)))
+				g.Expect(resp.Kvs[0].Key).To(Equal([]byte("/key/5")))
+				g.Expect(resp.Kvs[1].Key).To(Equal([]byte("/key/4")))
+
+				resp, err = kine.client.Get(ctx, "/key/", clientv3.WithPrefix(), clientv3.WithSort(clientv3.SortByCreateRevision, clientv3.SortDescend))
+
+				g.Expect(err).To(BeNil())
+				g.Expect(resp.Kvs).To(HaveLen(5))
+				g.Expect(resp.Kvs[0].Key).To(Equal([]byte("/key/1")))
+				g.Expect(resp.Kvs[4].Key).To(Equal([]byte("/key/5")))
+			})
+
+			t.Run("ListKeysOnly", func(t *testing.T) {
+				g := NewWithT(t)
+
+				resp, err := kine.client.Get(ctx, "/key/", clientv3.WithPrefix(), clientv3.WithKeysOnly())
+
+				g.Expect(err).To(BeNil())
+				g.Expect(resp.Kvs).To(HaveLen(5))
+				for _, kv := range resp.Kvs {
+					g.Expect(kv.Value).To(BeEmpty())
+					g.Expect(kv.ModRevision).ToNot(BeZero())
+				}
+
+				resp, err = kine.client.Get(ctx, "/key/1", clientv3.WithKeysOnly())
+
+				g.Expect(err).To(BeNil())
+				g.Expect(resp.Kvs).To(HaveLen(1))
+				g.Expect(resp.Kvs[0].Value).To(BeEmpty())
+			})
+
+			t.Run("ListRevisionFilters", func(t *testing.T) {
+				g := NewWithT(t)
+
+				resp, err := kine.client.Get(ctx, "/key/", clientv3.WithPrefix())
+				g.Expect(err).To(BeNil())
+				g.Expect(resp.Kvs).To(HaveLen(5))
+				// Keys were created from the last to the first.
+				rev3 := resp.Kvs[2].ModRevision
+
+				resp, err = kine.client.Get(ctx, "/key/", clientv3.WithPrefix(), clientv3.WithMinModRev(rev3))
+
+				g.Expect(err).To(BeNil())
+				g.Expect(resp.Kvs).To(HaveLen(3))
+				g.Expect(resp.Kvs[0].Key).To(Equal([]byte("/key/1")))
+				g.Expect(resp.Kvs[2].Key).To(Equal([]byte("/key/3")))
+
+				resp, err = kine.client.Get(ctx, "/key/", clientv3.WithPrefix(), clientv3.WithMaxCreateRev(rev3), clientv3.WithCountOnly())
+
+				g.Expect(err).To(BeNil())
+				g.Expect(resp.Count).To(Equal(int64(3)))
+
+				resp, err = kine.client.Get(ctx, "/key/", clientv3.WithPrefix(), clientv3.WithMinCreateRev(rev3), clientv3.WithMaxModRev(rev3), clientv3.WithLimit(1))
+
+				g.Expect(err).To(BeNil())
+				g.Expect(resp.Kvs).To(HaveLen(1))
+				g.Expect(resp.More).To(BeFalse())
+				g.Expect(resp.Kvs[0].Key).To(Equal([]byte("/key/3")))
+
+				resp, err = kine.client.Get(ctx, "/key/1", clientv3.WithMaxModRev(rev3))
+
+				g.Expect(err).To(BeNil())
+				g.Expect(resp.Kvs).To(BeEmpty())
+			})
+
 			t.Run("ListPrefix", func(t *testing.T) {
 				g := NewWithT(t)
 
@@ -191,7 +306,7 @@ func BenchmarkList(b *testing.B) {
 		}
 		return nil
 	}
-	backends := []string{endpoint.SQLiteBackend, endpoint.DQLiteBackend}
+	backends := []string{endpoint.SQLiteBackend, endpoint.DQLiteBackend, endpoint.MemoryBackend}
 	for _, backendType := range backends {
 		payloads := []struct {
 			name string
@@ -0,0 +1,365 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/canonical/k8s-dqlite/pkg/kine/endpoint"
+	"github.com/canonical/k8s-dqlite/pkg/kine/server"
+	. "github.com/onsi/gomega"
+	"go.etcd.io/etcd/api/v3/etcdserverpb"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+func TestDefragment(t *testing.T) {
+	for _, backendType := range []string{endpoint.SQLiteBackend, endpoint.MemoryBackend} {
+		t.Run(backendType, func(t *testing.T) {
+			g := NewWithT(t)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			kine := newKineServer(ctx, t, &kineOptions{
+				backendType: backendType,
+				setup: func(ctx context.Context, tx *sql.Tx) error {
+					if _, err := insertMany(ctx, tx, "key", 1000, 1000); err != nil {
+						return err
+					}
+					if _, err := deleteMany(ctx, tx, "key", 1000); err != nil {
+						return err
+					}
+					return nil
+				},
+			})
+
+			err := kine.backend.DoCompact(ctx)
+			g.Expect(err).To(BeNil())
+
+			before, after, err := kine.backend.DoVacuum(ctx)
+			g.Expect(err).To(BeNil())
+			g.Expect(after).To(BeNumerically("<", before))
+
+			_, err = kine.client.Defragment(ctx, kine.client.Endpoints()[0])
+			g.Expect(err).To(BeNil())
+		})
+	}
+}
+
+func TestStatus(t *testing.T) {
+	for _, backendType := range []string{endpoint.SQLiteBackend, endpoint.DQLiteBackend, endpoint.MemoryBackend} {
+		t.Run(backendType, func(t *testing.T) {
+			g := NewWithT(t)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			kine := newKineServer(ctx, t, &kineOptions{backendType: backendType})
+
+			resp, err := kine.client.Status(ctx, kine.client.Endpoints()[0])
+			g.Expect(err).To(BeNil())
+			g.Expect(resp.Version).NotTo(BeEmpty())
+			g.Expect(resp.DbSizeInUse).To(BeNumerically(">", 0))
+			g.Expect(resp.DbSize).To(BeNumerically(">=", resp.DbSizeInUse))
+			g.Expect(resp.Header.Revision).To(BeNumerically(">", 0))
+		})
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	for _, backendType := range []string{endpoint.SQLiteBackend, endpoint.DQLiteBackend, endpoint.MemoryBackend} {
+		t.Run(backendType, func(t *testing.T) {
+			g := NewWithT(t)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			kine := newKineServer(ctx, t, &kineOptions{
+				backendType: backendType,
+				setup: func(ctx context.Context, tx *sql.Tx) error {
+					_, err := insertMany(ctx, tx, "key", 100, 1000)
+					return err
+				},
+			})
+
+			stream, err := etcdserverpb.NewMaintenanceClient(kine.client.ActiveConnection()).Snapshot(ctx, &etcdserverpb.SnapshotRequest{})
+			g.Expect(err).To(BeNil())
+
+			var (
+				data     []byte
+				revision int64
+			)
+			for {
+				resp, err := stream.Recv()
+				if err == io.EOF {
+					break
+				}
+				g.Expect(err).To(BeNil())
+				revision = resp.Header.Revision
+				data = append(data, resp.Blob...)
+			}
+			g.Expect(len(data)).To(BeNumerically(">", sha256.Size))
+
+			content, trailer := data[:len(data)-sha256.Size], data[len(data)-sha256.Size:]
+			sum := sha256.Sum256(content)
+			g.Expect(bytes.Equal(sum[:], trailer)).To(BeTrue())
+			g.Expect(bytes.HasPrefix(content, []byte("SQLite format 3\x00"))).To(BeTrue())
+
+			// The revision of the header is the one of the snapshot.
+			path := filepath.Join(t.TempDir(), "snapshot.db")
+			g.Expect(os.WriteFile(path, content, 0600)).To(Succeed())
+			db, err := sql.Open("sqlite3", path)
+			g.Expect(err).To(BeNil())
+			defer db.Close()
+			var maxID int64
+			g.Expect(db.QueryRowContext(ctx, "SELECT MAX(id) FROM kine").Scan(&maxID)).To(Succeed())
+			g.Expect(revision).To(Equal(maxID))
+		})
+	}
+}
+
+func TestHashKV(t *testing.T) {
+	for _, backendType := range []string{endpoint.SQLiteBackend, endpoint.DQLiteBackend, endpoint.MemoryBackend} {
+		t.Run(backendType, func(t *testing.T) {
+			g := NewWithT(t)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			kine := newKineServer(ctx, t, &kineOptions{
+				backendType: backendType,
+				setup: func(ctx context.Context, tx *sql.Tx) error {
+					_, err := insertMany(ctx, tx, "key", 100, 100)
+					return err
+				},
+			})
+			member := kine.client.Endpoints()[0]
+
+			first, err := kine.client.HashKV(ctx, member, 0)
+			g.Expect(err).To(BeNil())
+			g.Expect(first.Header.Revision).To(BeNumerically(">", 0))
+
+			again, err := kine.client.HashKV(ctx, member, first.Header.Revision)
+			g.Expect(err).To(BeNil())
+			g.Expect(again.Hash).To(Equal(first.Hash))
+
+			createKey(ctx, g, kine.client, "/hashkv/key", "value")
+
+			current, err := kine.client.HashKV(ctx, member, 0)
+			g.Expect(err).To(BeNil())
+			g.Expect(current.Header.Revision).To(BeNumerically(">", first.Header.Revision))
+			g.Expect(current.Hash).NotTo(Equal(first.Hash))
+
+			previous, err := kine.client.HashKV(ctx, member, first.Header.Revision)
+			g.Expect(err).To(BeNil())
+			g.Expect(previous.Hash).To(Equal(first.Hash))
+
+			_, err = kine.client.HashKV(ctx, member, current.Header.Revision+100)
+			g.Expect(err).NotTo(BeNil())
+		})
+	}
+}
+
+func TestAlarmNoSpace(t *testing.T) {
+	for _, backendType := range []string{endpoint.SQLiteBackend, endpoint.DQLiteBackend, endpoint.MemoryBackend} {
+		t.Run(backendType, func(t *testing.T) {
+			g := NewWithT(t)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			kine := newKineServer(ctx, t, &kineOptions{
+				backendType:       backendType,
+				quotaBackendBytes: 1,
+			})
+
+			_, err := kine.client.Txn(ctx).
+				If(clientv3.Compare(clientv3.ModRevision("/alarm/key"), "=", 0)).
+				Then(clientv3.OpPut("/alarm/key", "value")).
+				Commit()
+			g.Expect(err).To(MatchError(rpctypes.ErrNoSpace))
+
+			alarms, err := kine.client.AlarmList(ctx)
+			g.Expect(err).To(BeNil())
+			g.Expect(alarms.Alarms).To(HaveLen(1))
+			g.Expect(alarms.Alarms[0].Alarm).To(Equal(etcdserverpb.AlarmType_NOSPACE))
+
+			disarmed, err := kine.client.AlarmDisarm(ctx, &clientv3.AlarmMember{
+				MemberID: alarms.Alarms[0].MemberID,
+				Alarm:    etcdserverpb.AlarmType_NOSPACE,
+			})
+			g.Expect(err).To(BeNil())
+			g.Expect(disarmed.Alarms).To(BeEmpty())
+
+			// The datastore is still over quota, so the alarm is raised again.
+			_, err = kine.client.Txn(ctx).
+				If(clientv3.Compare(clientv3.ModRevision("/alarm/key"), "=", 0)).
+				Then(clientv3.OpPut("/alarm/key", "value")).
+				Commit()
+			g.Expect(err).To(MatchError(rpctypes.ErrNoSpace))
+		})
+	}
+}
+
+func TestAlarmNoSpaceDefragment(t *testing.T) {
+	for _, backendType := range []string{endpoint.SQLiteBackend, endpoint.MemoryBackend} {
+		t.Run(backendType, func(t *testing.T) {
+			g := NewWithT(t)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			kine := newKineServer(ctx, t, &kineOptions{
+				backendType:       backendType,
+				quotaBackendBytes: 512 * 1024,
+				setup: func(ctx context.Context, tx *sql.Tx) error {
+					if _, err := insertMany(ctx, tx, "key", 1000, 1000); err != nil {
+						return err
+					}
+					if _, err := deleteMany(ctx, tx, "key", 1000); err != nil {
+						return err
+					}
+					return nil
+				},
+			})
+
+			_, err := kine.client.Txn(ctx).
+				If(clientv3.Compare(clientv3.ModRevision("/alarm/key"), "=", 0)).
+				Then(clientv3.OpPut("/alarm/key", "value")).
+				Commit()
+			g.Expect(err).To(MatchError(rpctypes.ErrNoSpace))
+
+			alarms, err := kine.client.AlarmList(ctx)
+			g.Expect(err).To(BeNil())
+			g.Expect(alarms.Alarms).To(HaveLen(1))
+
+			// Reclaim the space of the deleted keys.
+			g.Expect(kine.backend.DoCompact(ctx)).To(Succeed())
+			_, err = kine.client.Defragment(ctx, kine.client.Endpoints()[0])
+			g.Expect(err).To(BeNil())
+
+			disarmed, err := kine.client.AlarmDisarm(ctx, &clientv3.AlarmMember{
+				MemberID: alarms.Alarms[0].MemberID,
+				Alarm:    etcdserverpb.AlarmType_NOSPACE,
+			})
+			g.Expect(err).To(BeNil())
+			g.Expect(disarmed.Alarms).To(BeEmpty())
+
+			txn, err := kine.client.Txn(ctx).
+				If(clientv3.Compare(clientv3.ModRevision("/alarm/key"), "=", 0)).
+				Then(clientv3.OpPut("/alarm/key", "value")).
+				Commit()
+			g.Expect(err).To(BeNil())
+			g.Expect(txn.Succeeded).To(BeTrue())
+
+			alarms, err = kine.client.AlarmList(ctx)
+			g.Expect(err).To(BeNil())
+			g.Expect(alarms.Alarms).To(BeEmpty())
+		})
+	}
+}
+
+// TestAlarmNoSpaceCompact checks that the space reclaimed by a compaction
+// counts against the quota before the database is defragmented.
+func TestAlarmNoSpaceCompact(t *testing.T) {
+	for _, backendType := range []string{endpoint.SQLiteBackend, endpoint.DQLiteBackend, endpoint.MemoryBackend} {
+		t.Run(backendType, func(t *testing.T) {
+			g := NewWithT(t)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			kine := newKineServer(ctx, t, &kineOptions{
+				backendType:       backendType,
+				quotaBackendBytes: 512 * 1024,
+				setup: func(ctx context.Context, tx *sql.Tx) error {
+					_, err := insertMany(ctx, tx, "key", 1000, 1000)
+					return err
+				},
+			})
+
+			put := func() error {
+				_, err := kine.client.Txn(ctx).
+					If(clientv3.Compare(clientv3.ModRevision("/alarm/key"), "=", 0)).
+					Then(clientv3.OpPut("/alarm/key", "value")).
+					Commit()
+				return err
+			}
+			g.Expect(put()).To(MatchError(rpctypes.ErrNoSpace))
+
+			// Deletes are still allowed.
+			deleted, err := kine.client.Delete(ctx, "key/", clientv3.WithPrefix())
+			g.Expect(err).To(BeNil())
+			g.Expect(deleted.Deleted).To(Equal(int64(1000)))
+
+			g.Expect(kine.backend.DoCompact(ctx)).To(Succeed())
+			alarms, err := kine.client.AlarmList(ctx)
+			g.Expect(err).To(BeNil())
+			g.Expect(alarms.Alarms).To(HaveLen(1))
+			_, err = kine.client.AlarmDisarm(ctx, &clientv3.AlarmMember{
+				MemberID: alarms.Alarms[0].MemberID,
+				Alarm:    etcdserverpb.AlarmType_NOSPACE,
+			})
+			g.Expect(err).To(BeNil())
+
+			g.Expect(put()).To(Succeed())
+		})
+	}
+}
+
+func TestKeyStats(t *testing.T) {
+	for _, backendType := range []string{endpoint.SQLiteBackend, endpoint.DQLiteBackend, endpoint.MemoryBackend} {
+		t.Run(backendType, func(t *testing.T) {
+			g := NewWithT(t)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			kine := newKineServer(ctx, t, &kineOptions{backendType: backendType})
+
+			rev := createKey(ctx, g, kine.client, "/stats/a", "1")
+			updateRev(ctx, g, kine.client, "/stats/a", rev, "12345")
+			rev = createKey(ctx, g, kine.client, "/stats/b", "123")
+			deleteKey(ctx, g, kine.client, "/stats/b", rev)
+
+			stats, err := kine.backend.KeyStats(ctx)
+			g.Expect(err).To(BeNil())
+			byKey := map[string]server.KeyStats{}
+			for _, stat := range stats {
+				byKey[stat.Key] = stat
+			}
+			// The update keeps the previous value, and the tombstone
+			// the deleted one as its previous value.
+			g.Expect(byKey["/stats/a"]).To(Equal(server.KeyStats{Key: "/stats/a", Size: 5, Revisions: 2, Bytes: 7}))
+			g.Expect(byKey["/stats/b"]).To(Equal(server.KeyStats{Key: "/stats/b", Deleted: true, Revisions: 2, Bytes: 6}))
+		})
+	}
+}
+
+func TestVerify(t *testing.T) {
+	for _, backendType := range []string{endpoint.SQLiteBackend, endpoint.DQLiteBackend, endpoint.MemoryBackend} {
+		t.Run(backendType, func(t *testing.T) {
+			g := NewWithT(t)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			kine := newKineServer(ctx, t, &kineOptions{backendType: backendType})
+
+			rev := createKey(ctx, g, kine.client, "/verify/a", "1")
+			rev = updateRev(ctx, g, kine.client, "/verify/a", rev, "2")
+			deleteKey(ctx, g, kine.client, "/verify/a", rev)
+			createKey(ctx, g, kine.client, "/verify/a", "3")
+
+			violations, err := kine.backend.Verify(ctx)
+			g.Expect(err).To(BeNil())
+			g.Expect(violations).To(BeEmpty())
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/canonical/k8s-dqlite/pkg/kine/endpoint"
+	. "github.com/onsi/gomega"
+)
+
+// testCA signs the certificates of the TLS tests.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pool *x509.CertPool
+}
+
+func newTestCA(tb testing.TB) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &testCA{cert: cert, key: key, pool: pool}
+}
+
+// issue returns a certificate for name signed by the CA.
+func (ca *testCA) issue(tb testing.TB, name string, usage x509.ExtKeyUsage) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestClientCertAuth(t *testing.T) {
+	ca := newTestCA(t)
+	serverCert := ca.issue(t, "kine", x509.ExtKeyUsageServerAuth)
+	clientCert := ca.issue(t, "kube-apiserver", x509.ExtKeyUsageClientAuth)
+	untrustedCert := newTestCA(t).issue(t, "intruder", x509.ExtKeyUsageClientAuth)
+
+	for _, tc := range []struct {
+		name    string
+		certs   []tls.Certificate
+		allowed bool
+	}{
+		{"trusted", []tls.Certificate{clientCert}, true},
+		{"untrusted", []tls.Certificate{untrustedCert}, false},
+		{"missing", nil, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			kine := newKineServer(ctx, t, &kineOptions{
+				backendType: endpoint.SQLiteBackend,
+				serverTLSConfig: &tls.Config{
+					Certificates: []tls.Certificate{serverCert},
+					ClientCAs:    ca.pool,
+					ClientAuth:   tls.RequireAndVerifyClientCert,
+				},
+				clientTLSConfig: &tls.Config{
+					ServerName:   "kine",
+					RootCAs:      ca.pool,
+					Certificates: tc.certs,
+				},
+			})
+
+			getCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
+			defer cancel()
+			_, err := kine.client.Get(getCtx, "/tls/key")
+			if tc.allowed {
+				g.Expect(err).To(BeNil())
+			} else {
+				g.Expect(err).NotTo(BeNil())
+			}
+		})
+	}
+}
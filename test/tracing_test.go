@@ -0,0 +1,47 @@
+package test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/canonical/k8s-dqlite/pkg/kine/endpoint"
+	. "github.com/onsi/gomega"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"google.golang.org/grpc/metadata"
+)
+
+// TestTraceContext checks that the requests continue the trace sent by the
+// client down to the SQL statements.
+func TestTraceContext(t *testing.T) {
+	const (
+		traceID     = "4bf92f3577b34da6a3ce929d0e0e4736"
+		traceparent = "00-" + traceID + "-00f067aa0ba902b7-01"
+	)
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+
+	for _, backendType := range []string{endpoint.SQLiteBackend, endpoint.DQLiteBackend} {
+		t.Run(backendType, func(t *testing.T) {
+			g := NewWithT(t)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			kine := newKineServer(ctx, t, &kineOptions{backendType: backendType})
+
+			tracedCtx := metadata.AppendToOutgoingContext(ctx, "traceparent", traceparent)
+			_, err := kine.client.Get(tracedCtx, "/traced")
+			g.Expect(err).To(BeNil())
+
+			var statements []string
+			for _, span := range recorder.Ended() {
+				if span.SpanContext().TraceID().String() == traceID && span.Name() == "prepared.QueryContext" {
+					statements = append(statements, span.Name())
+				}
+			}
+			g.Expect(statements).NotTo(BeEmpty())
+		})
+	}
+}
@@ -0,0 +1,233 @@
+package test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/canonical/k8s-dqlite/pkg/kine/endpoint"
+	"github.com/canonical/k8s-dqlite/pkg/kine/server"
+	. "github.com/onsi/gomega"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// TestTxn checks the transactions that don't match any of the requests
+// of the apiserver.
+func TestTxn(t *testing.T) {
+	for _, backendType := range []string{endpoint.SQLiteBackend, endpoint.DQLiteBackend, endpoint.MemoryBackend} {
+		t.Run(backendType, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			kine := newKineServer(ctx, t, &kineOptions{backendType: backendType})
+
+			t.Run("MultiplePuts", func(t *testing.T) {
+				g := NewWithT(t)
+
+				resp, err := kine.client.Txn(ctx).
+					If(
+						clientv3.Compare(clientv3.CreateRevision("/txn/multi/a"), "=", 0),
+						clientv3.Compare(clientv3.CreateRevision("/txn/multi/b"), "=", 0),
+					).
+					Then(
+						clientv3.OpPut("/txn/multi/a", "a"),
+						clientv3.OpPut("/txn/multi/b", "b"),
+						clientv3.OpGet("/txn/multi/", clientv3.WithPrefix()),
+					).
+					Commit()
+				g.Expect(err).To(BeNil())
+				g.Expect(resp.Succeeded).To(BeTrue())
+				g.Expect(resp.Responses).To(HaveLen(3))
+
+				putA := resp.Responses[0].GetResponsePut().Header.Revision
+				putB := resp.Responses[1].GetResponsePut().Header.Revision
+				g.Expect(putB).To(BeNumerically(">", putA))
+				g.Expect(resp.Header.Revision).To(Equal(putB))
+
+				list := resp.Responses[2].GetResponseRange()
+				g.Expect(list.Kvs).To(HaveLen(2))
+				g.Expect(list.Kvs[0].Value).To(Equal([]byte("a")))
+				g.Expect(list.Kvs[1].Value).To(Equal([]byte("b")))
+			})
+
+			t.Run("FailedCompare", func(t *testing.T) {
+				g := NewWithT(t)
+
+				key := "/txn/compare"
+				createKey(ctx, g, kine.client, key, "v1")
+
+				resp, err := kine.client.Txn(ctx).
+					If(
+						clientv3.Compare(clientv3.Value(key), "=", "v1"),
+						clientv3.Compare(clientv3.Value(key), ">", "v2"),
+					).
+					Then(clientv3.OpPut(key, "v2")).
+					Else(clientv3.OpPut(key, "v3", clientv3.WithPrevKV())).
+					Commit()
+				g.Expect(err).To(BeNil())
+				g.Expect(resp.Succeeded).To(BeFalse())
+				g.Expect(resp.Responses).To(HaveLen(1))
+				g.Expect(resp.Responses[0].GetResponsePut().PrevKv.Value).To(Equal([]byte("v1")))
+				assertKey(ctx, g, kine.client, key, "v3")
+			})
+
+			t.Run("MissingKeyValue", func(t *testing.T) {
+				g := NewWithT(t)
+
+				resp, err := kine.client.Txn(ctx).
+					If(clientv3.Compare(clientv3.Value("/txn/missing"), "!=", "v1")).
+					Commit()
+				g.Expect(err).To(BeNil())
+				g.Expect(resp.Succeeded).To(BeFalse())
+			})
+
+			t.Run("DeleteRange", func(t *testing.T) {
+				g := NewWithT(t)
+
+				for _, key := range []string{"/txn/delete/a", "/txn/delete/b", "/txn/delete/c"} {
+					createKey(ctx, g, kine.client, key, "value")
+				}
+
+				resp, err := kine.client.Txn(ctx).
+					If(clientv3.Compare(clientv3.ModRevision("/txn/delete/").WithPrefix(), ">", 0)).
+					Then(clientv3.OpDelete("/txn/delete/", clientv3.WithPrefix(), clientv3.WithPrevKV())).
+					Commit()
+				g.Expect(err).To(BeNil())
+				g.Expect(resp.Succeeded).To(BeTrue())
+				deleted := resp.Responses[0].GetResponseDeleteRange()
+				g.Expect(deleted.Deleted).To(Equal(int64(3)))
+				g.Expect(deleted.PrevKvs).To(HaveLen(3))
+
+				getResp, err := kine.client.Get(ctx, "/txn/delete/", clientv3.WithPrefix())
+				g.Expect(err).To(BeNil())
+				g.Expect(getResp.Kvs).To(BeEmpty())
+			})
+
+			t.Run("Nested", func(t *testing.T) {
+				g := NewWithT(t)
+
+				key := "/txn/nested"
+				resp, err := kine.client.Txn(ctx).
+					If(clientv3.Compare(clientv3.Version(key), "=", 0)).
+					Then(
+						clientv3.OpPut(key, "outer"),
+						clientv3.OpTxn(
+							[]clientv3.Cmp{clientv3.Compare(clientv3.Value(key), "=", "outer")},
+							[]clientv3.Op{clientv3.OpPut(key+"/inner", "inner")},
+							nil,
+						),
+					).
+					Commit()
+				g.Expect(err).To(BeNil())
+				g.Expect(resp.Succeeded).To(BeTrue())
+				g.Expect(resp.Responses[1].GetResponseTxn().Succeeded).To(BeTrue())
+				assertKey(ctx, g, kine.client, key+"/inner", "inner")
+			})
+
+			t.Run("DuplicateKey", func(t *testing.T) {
+				g := NewWithT(t)
+
+				_, err := kine.client.Txn(ctx).
+					Then(
+						clientv3.OpPut("/txn/duplicate", "a"),
+						clientv3.OpPut("/txn/duplicate", "b"),
+					).
+					Commit()
+				g.Expect(err).To(MatchError(rpctypes.ErrDuplicateKey))
+			})
+
+			t.Run("Atomic", func(t *testing.T) {
+				g := NewWithT(t)
+
+				// The second put refers to an unknown lease, so the
+				// first one must be rolled back.
+				_, err := kine.client.Txn(ctx).
+					Then(
+						clientv3.OpPut("/txn/atomic/a", "a"),
+						clientv3.OpPut("/txn/atomic/b", "b", clientv3.WithLease(clientv3.LeaseID(1<<30+1))),
+					).
+					Commit()
+				g.Expect(err).To(MatchError(rpctypes.ErrLeaseNotFound))
+				assertMissingKey(ctx, g, kine.client, "/txn/atomic/a")
+			})
+		})
+	}
+}
+
+// TestRequestLimits checks that the write requests exceeding the limits are
+// refused with the errors of etcd.
+func TestRequestLimits(t *testing.T) {
+	for _, backendType := range []string{endpoint.SQLiteBackend, endpoint.DQLiteBackend, endpoint.MemoryBackend} {
+		t.Run(backendType, func(t *testing.T) {
+			g := NewWithT(t)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			kine := newKineServer(ctx, t, &kineOptions{
+				backendType:   backendType,
+				requestLimits: server.RequestLimits{MaxRequestBytes: 1024, MaxTxnOps: 2},
+			})
+
+			create := func(key, value string) error {
+				_, err := kine.client.Txn(ctx).
+					If(clientv3.Compare(clientv3.ModRevision(key), "=", 0)).
+					Then(clientv3.OpPut(key, value)).
+					Commit()
+				return err
+			}
+			g.Expect(create("/limits/small", "value")).To(Succeed())
+			g.Expect(create("/limits/large", strings.Repeat("v", 2048))).To(MatchError(rpctypes.ErrRequestTooLarge))
+
+			ops := make([]clientv3.Op, 0, 3)
+			for i := range 3 {
+				ops = append(ops, clientv3.OpPut(fmt.Sprintf("/limits/ops/%d", i), "value"))
+			}
+			_, err := kine.client.Txn(ctx).Then(ops...).Commit()
+			g.Expect(err).To(MatchError(rpctypes.ErrTooManyOps))
+			_, err = kine.client.Txn(ctx).Then(ops[:2]...).Commit()
+			g.Expect(err).To(BeNil())
+		})
+	}
+}
+
+func TestGRPCMessageSizes(t *testing.T) {
+	for _, backendType := range []string{endpoint.SQLiteBackend, endpoint.DQLiteBackend, endpoint.MemoryBackend} {
+		t.Run(backendType, func(t *testing.T) {
+			g := NewWithT(t)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			kine := newKineServer(ctx, t, &kineOptions{
+				backendType:   backendType,
+				requestLimits: server.RequestLimits{MaxRequestBytes: 1024},
+				grpcOptions:   endpoint.GRPCOptions{MaxRecvMsgSize: 8192, MaxSendMsgSize: 4096},
+			})
+
+			create := func(key, value string) error {
+				_, err := kine.client.Txn(ctx).
+					If(clientv3.Compare(clientv3.ModRevision(key), "=", 0)).
+					Then(clientv3.OpPut(key, value)).
+					Commit()
+				return err
+			}
+			// The size of the messages received replaces the default
+			// derived from the size of the requests.
+			g.Expect(create("/sizes/large", strings.Repeat("v", 2048))).To(MatchError(rpctypes.ErrRequestTooLarge))
+			g.Expect(status.Code(create("/sizes/huge", strings.Repeat("v", 16384)))).To(Equal(codes.ResourceExhausted))
+
+			for i := range 5 {
+				g.Expect(create(fmt.Sprintf("/sizes/%d", i), strings.Repeat("v", 900))).To(Succeed())
+			}
+			_, err := kine.client.Get(ctx, "/sizes/0")
+			g.Expect(err).To(BeNil())
+			_, err = kine.client.Get(ctx, "/sizes/", clientv3.WithPrefix())
+			g.Expect(status.Code(err)).To(Equal(codes.ResourceExhausted))
+		})
+	}
+}
@@ -3,6 +3,7 @@ package test
 import (
 	"context"
 	"fmt"
+	"strings"
 	"sync"
 	"testing"
 
@@ -13,7 +14,7 @@ import (
 
 // TestUpdate is unit testing for the update operation.
 func TestUpdate(t *testing.T) {
-	for _, backendType := range []string{endpoint.SQLiteBackend, endpoint.DQLiteBackend} {
+	for _, backendType := range []string{endpoint.SQLiteBackend, endpoint.DQLiteBackend, endpoint.MemoryBackend} {
 		t.Run(backendType, func(t *testing.T) {
 			ctx, cancel := context.WithCancel(context.Background())
 			defer cancel()
@@ -106,7 +107,7 @@ func TestUpdate(t *testing.T) {
 
 // BenchmarkUpdate is a benchmark for the Update operation.
 func BenchmarkUpdate(b *testing.B) {
-	for _, backendType := range []string{endpoint.SQLiteBackend, endpoint.DQLiteBackend} {
+	for _, backendType := range []string{endpoint.SQLiteBackend, endpoint.DQLiteBackend, endpoint.MemoryBackend} {
 		for _, workers := range []int{1, 4, 16, 64, 128} {
 			b.Run(fmt.Sprintf("%s/%d-workers", backendType, workers), func(b *testing.B) {
 				b.StopTimer()
@@ -153,3 +154,107 @@ func updateRev(ctx context.Context, g Gomega, client *clientv3.Client, key strin
 
 	return resp.Responses[0].GetResponsePut().Header.Revision
 }
+
+// TestUpdateCompression checks that the compressed values are read back
+// as they were written, along with the values written raw.
+func TestUpdateCompression(t *testing.T) {
+	for _, backendType := range []string{endpoint.SQLiteBackend, endpoint.DQLiteBackend, endpoint.MemoryBackend} {
+		t.Run(backendType, func(t *testing.T) {
+			g := NewWithT(t)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			kine := newKineServer(ctx, t, &kineOptions{
+				backendType:        backendType,
+				endpointParameters: []string{"compression=zstd", "compression-threshold=64"},
+			})
+
+			small := "small"
+			large := strings.Repeat("large ", 100)
+			rev := createKey(ctx, g, kine.client, "/compression/a", small)
+
+			watch := kine.client.Watch(ctx, "/compression/a", clientv3.WithRev(rev+1), clientv3.WithPrevKV())
+			updateRev(ctx, g, kine.client, "/compression/a", rev, large)
+
+			resp, err := kine.client.Get(ctx, "/compression/a")
+			g.Expect(err).To(BeNil())
+			g.Expect(resp.Kvs).To(HaveLen(1))
+			g.Expect(string(resp.Kvs[0].Value)).To(Equal(large))
+
+			var event clientv3.WatchResponse
+			g.Eventually(watch).Should(Receive(&event))
+			g.Expect(event.Events).To(HaveLen(1))
+			g.Expect(string(event.Events[0].Kv.Value)).To(Equal(large))
+			g.Expect(string(event.Events[0].PrevKv.Value)).To(Equal(small))
+		})
+	}
+}
+
+func TestUpdateChunks(t *testing.T) {
+	for _, backendType := range []string{endpoint.SQLiteBackend, endpoint.DQLiteBackend} {
+		t.Run(backendType, func(t *testing.T) {
+			g := NewWithT(t)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			kine := newKineServer(ctx, t, &kineOptions{
+				backendType:        backendType,
+				endpointParameters: []string{"chunk-size=64"},
+			})
+
+			first := strings.Repeat("first ", 100)
+			second := strings.Repeat("second ", 100)
+			rev := createKey(ctx, g, kine.client, "/chunks/a", first)
+
+			watch := kine.client.Watch(ctx, "/chunks/a", clientv3.WithRev(rev+1), clientv3.WithPrevKV())
+			updateRev(ctx, g, kine.client, "/chunks/a", rev, second)
+
+			resp, err := kine.client.Get(ctx, "/chunks/a")
+			g.Expect(err).To(BeNil())
+			g.Expect(resp.Kvs).To(HaveLen(1))
+			g.Expect(string(resp.Kvs[0].Value)).To(Equal(second))
+
+			var event clientv3.WatchResponse
+			g.Eventually(watch).Should(Receive(&event))
+			g.Expect(event.Events).To(HaveLen(1))
+			g.Expect(string(event.Events[0].Kv.Value)).To(Equal(second))
+			g.Expect(string(event.Events[0].PrevKv.Value)).To(Equal(first))
+		})
+	}
+}
+
+func TestUpdateDedupOldValues(t *testing.T) {
+	for _, backendType := range []string{endpoint.SQLiteBackend, endpoint.DQLiteBackend, endpoint.MemoryBackend} {
+		t.Run(backendType, func(t *testing.T) {
+			g := NewWithT(t)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			kine := newKineServer(ctx, t, &kineOptions{
+				backendType:        backendType,
+				endpointParameters: []string{"dedup-old-values=true"},
+			})
+
+			rev := createKey(ctx, g, kine.client, "/dedup/a", "a1")
+
+			watch := kine.client.Watch(ctx, "/dedup/a", clientv3.WithRev(rev+1), clientv3.WithPrevKV())
+			updateRev(ctx, g, kine.client, "/dedup/a", rev, "a2")
+			_, err := kine.client.Delete(ctx, "/dedup/a")
+			g.Expect(err).To(BeNil())
+
+			var events []*clientv3.Event
+			g.Eventually(func() []*clientv3.Event {
+				select {
+				case resp := <-watch:
+					events = append(events, resp.Events...)
+				default:
+				}
+				return events
+			}).Should(HaveLen(2))
+			g.Expect(string(events[0].Kv.Value)).To(Equal("a2"))
+			g.Expect(string(events[0].PrevKv.Value)).To(Equal("a1"))
+			g.Expect(events[1].Type).To(Equal(clientv3.EventTypeDelete))
+			g.Expect(string(events[1].PrevKv.Value)).To(Equal("a2"))
+		})
+	}
+}
@@ -2,6 +2,7 @@ package test
 
 import (
 	"context"
+	"crypto/tls"
 	"database/sql"
 	"errors"
 	"fmt"
@@ -27,18 +28,52 @@ func init() {
 type kineServer struct {
 	client         *clientv3.Client
 	backend        server.Backend
+	server         *endpoint.Server
 	dqliteListener *instrument.Listener
 }
 
 type kineOptions struct {
-	// backendType is the type of the kine backend. It can be either
-	// endpoint.SQLiteBackend or endpoint.DQLiteBackend.
+	// backendType is the type of the kine backend. It can be one of
+	// endpoint.SQLiteBackend, endpoint.DQLiteBackend or endpoint.MemoryBackend.
 	backendType string
 
 	// endpointParameters can be used to configure kine parameters
 	// like watch-query-timeout.
 	endpointParameters []string
 
+	// eventsTable stores the keys under eventsPrefix in a table of their
+	// own.
+	eventsTable bool
+
+	// quotaBackendBytes is the size of the database after which
+	// writes are refused. If zero, no quota is enforced.
+	quotaBackendBytes int64
+
+	// requestLimits bound the write requests.
+	requestLimits server.RequestLimits
+
+	// grpcOptions tune the connections of the gRPC server.
+	grpcOptions endpoint.GRPCOptions
+
+	// watchProgressNotifyInterval is the interval between the progress
+	// notifications of the watches that request them.
+	watchProgressNotifyInterval time.Duration
+
+	// auditLog optionally records the writes.
+	auditLog server.AuditLog
+
+	// cluster optionally exposes the cluster replicating the datastore.
+	cluster server.Cluster
+
+	// serverTLSConfig optionally enables TLS on the kine endpoint, in
+	// which case the client uses clientTLSConfig.
+	serverTLSConfig *tls.Config
+	clientTLSConfig *tls.Config
+
+	// maxCallRecvMsgSize is the size of the largest response the client
+	// accepts. If zero, the default of the client is used.
+	maxCallRecvMsgSize int
+
 	// setup is a function to setup the database before a test or
 	// benchmark starts. It is called after the endpoint started,
 	// so that migration and database schema setup is already done.
@@ -72,6 +107,8 @@ func newKineServer(ctx context.Context, tb testing.TB, options *kineOptions) *ki
 			}
 		})
 		endpointConfig, db = startDqlite(ctx, tb, dir, dqliteListener)
+	case endpoint.MemoryBackend:
+		endpointConfig, db = startMemory(ctx, tb, dir)
 	default:
 		tb.Fatalf("Testing %s backend not supported", options.backendType)
 	}
@@ -83,6 +120,16 @@ func newKineServer(ctx context.Context, tb testing.TB, options *kineOptions) *ki
 	for _, param := range options.endpointParameters {
 		endpointConfig.Endpoint = fmt.Sprintf("%s&%s", endpointConfig.Endpoint, param)
 	}
+	if options.eventsTable {
+		endpointConfig.Endpoint = fmt.Sprintf("%s&events-prefix=%s", endpointConfig.Endpoint, eventsPrefix)
+	}
+	endpointConfig.QuotaBackendBytes = options.quotaBackendBytes
+	endpointConfig.RequestLimits = options.requestLimits
+	endpointConfig.GRPCOptions = options.grpcOptions
+	endpointConfig.WatchProgressNotifyInterval = options.watchProgressNotifyInterval
+	endpointConfig.AuditLog = options.auditLog
+	endpointConfig.Cluster = options.cluster
+	endpointConfig.ServerTLSConfig = options.serverTLSConfig
 	config, backend, err := endpoint.ListenAndReturnBackend(ctx, *endpointConfig)
 	if err != nil {
 		tb.Fatal(err)
@@ -109,10 +156,14 @@ func newKineServer(ctx context.Context, tb testing.TB, options *kineOptions) *ki
 	if err != nil {
 		tb.Fatal(err)
 	}
+	if options.clientTLSConfig != nil {
+		tlsConfig = options.clientTLSConfig
+	}
 	client, err := clientv3.New(clientv3.Config{
-		Endpoints:   []string{endpointConfig.Listener},
-		DialTimeout: 5 * time.Second,
-		TLS:         tlsConfig,
+		Endpoints:          []string{endpointConfig.Listener},
+		DialTimeout:        5 * time.Second,
+		TLS:                tlsConfig,
+		MaxCallRecvMsgSize: options.maxCallRecvMsgSize,
 	})
 	tb.Cleanup(func() {
 		client.Close()
@@ -123,6 +174,7 @@ func newKineServer(ctx context.Context, tb testing.TB, options *kineOptions) *ki
 	return &kineServer{
 		client:         client,
 		backend:        backend,
+		server:         config.Server,
 		dqliteListener: dqliteListener,
 	}
 }
@@ -141,6 +193,20 @@ func startSqlite(_ context.Context, tb testing.TB, dir string) (*endpoint.Config
 	}, db
 }
 
+func startMemory(_ context.Context, tb testing.TB, dir string) (*endpoint.Config, *sql.DB) {
+	// In-memory databases are shared by name across the whole process,
+	// so the (unique) temporary directory is used as the database name.
+	db, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?vfs=memdb", dir))
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	return &endpoint.Config{
+		Listener: fmt.Sprintf("unix://%s/kine.sock", dir),
+		Endpoint: fmt.Sprintf("memory://%s", dir),
+	}, db
+}
+
 func startDqlite(ctx context.Context, tb testing.TB, dir string, listener *instrument.Listener) (*endpoint.Config, *sql.DB) {
 	app, err := app.New(dir,
 		app.WithAddress(listener.Address),
@@ -2,12 +2,16 @@ package test
 
 import (
 	"context"
+	"fmt"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/canonical/k8s-dqlite/pkg/kine/endpoint"
+	"github.com/canonical/k8s-dqlite/pkg/kine/server"
 	. "github.com/onsi/gomega"
 	"github.com/onsi/gomega/types"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
 	clientv3 "go.etcd.io/etcd/client/v3"
 )
 
@@ -22,7 +26,7 @@ func TestWatch(t *testing.T) {
 		idleTimeout = 100 * time.Millisecond
 	)
 
-	for _, backendType := range []string{endpoint.SQLiteBackend, endpoint.DQLiteBackend} {
+	for _, backendType := range []string{endpoint.SQLiteBackend, endpoint.DQLiteBackend, endpoint.MemoryBackend} {
 		t.Run(backendType, func(t *testing.T) {
 			ctx, cancel := context.WithCancel(context.Background())
 			defer cancel()
@@ -115,6 +119,147 @@ func TestWatch(t *testing.T) {
 	}
 }
 
+// TestWatchProgress checks the progress notifications of watches.
+func TestWatchProgress(t *testing.T) {
+	const progressTimeout = 2 * time.Second
+
+	for _, backendType := range []string{endpoint.SQLiteBackend, endpoint.DQLiteBackend, endpoint.MemoryBackend} {
+		t.Run(backendType, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			kine := newKineServer(ctx, t, &kineOptions{
+				backendType:                 backendType,
+				watchProgressNotifyInterval: 100 * time.Millisecond,
+			})
+
+			isProgressNotify := func(minRevision int64) types.GomegaMatcher {
+				return Satisfy(func(resp clientv3.WatchResponse) bool {
+					return resp.IsProgressNotify() && resp.Header.Revision >= minRevision
+				})
+			}
+
+			t.Run("RequestProgress", func(t *testing.T) {
+				ctx, cancel := context.WithCancel(ctx)
+				defer cancel()
+				g := NewWithT(t)
+
+				const prefix = "progress/request/"
+				watchCh := kine.client.Watch(ctx, prefix)
+
+				key := prefix + "key"
+				createRev := createKey(ctx, g, kine.client, key, "value")
+				g.Eventually(watchCh, progressTimeout).Should(ReceiveEvents(g,
+					CreateEvent(g, key, "value", createRev),
+				))
+
+				// Writes outside of the watched prefix advance the
+				// revision reported by progress notifications.
+				rev := createKey(ctx, g, kine.client, "progress/other", "value")
+				g.Eventually(func(g Gomega) {
+					g.Expect(kine.client.RequestProgress(ctx)).To(Succeed())
+					g.Eventually(watchCh, 100*time.Millisecond).Should(Receive(isProgressNotify(rev)))
+				}, progressTimeout).Should(Succeed())
+			})
+
+			t.Run("ProgressNotify", func(t *testing.T) {
+				ctx, cancel := context.WithCancel(ctx)
+				defer cancel()
+				g := NewWithT(t)
+
+				rev := createKey(ctx, g, kine.client, "progress/notify", "value")
+				watchCh := kine.client.Watch(ctx, "progress/notify/", clientv3.WithProgressNotify())
+				g.Eventually(watchCh, progressTimeout).Should(Receive(isProgressNotify(rev)))
+			})
+
+			t.Run("NoProgressNotify", func(t *testing.T) {
+				ctx, cancel := context.WithCancel(ctx)
+				defer cancel()
+				g := NewWithT(t)
+
+				watchCh := kine.client.Watch(ctx, "progress/none/")
+				g.Consistently(watchCh, 500*time.Millisecond).ShouldNot(Receive())
+			})
+		})
+	}
+}
+
+// TestWatchFragment checks that large watch responses are split in
+// fragments, for the watches that allow it.
+func TestWatchFragment(t *testing.T) {
+	const (
+		valueSize = 1024 * 1024
+		keys      = 3
+	)
+
+	for _, backendType := range []string{endpoint.SQLiteBackend, endpoint.DQLiteBackend, endpoint.MemoryBackend} {
+		t.Run(backendType, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			g := NewWithT(t)
+
+			// The client refuses the responses holding all the events.
+			kine := newKineServer(ctx, t, &kineOptions{
+				backendType:        backendType,
+				maxCallRecvMsgSize: (keys - 1) * valueSize,
+			})
+
+			value := strings.Repeat("v", valueSize)
+			var startRev int64
+			for i := 0; i < keys; i++ {
+				rev := createKey(ctx, g, kine.client, fmt.Sprintf("fragment/%d", i), value)
+				if startRev == 0 {
+					startRev = rev
+				}
+			}
+
+			watchCh := kine.client.Watch(ctx, "fragment/", clientv3.WithRev(startRev), clientv3.WithFragment())
+			g.Eventually(watchCh, 5*time.Second).Should(Receive(Satisfy(func(resp clientv3.WatchResponse) bool {
+				return g.Expect(resp.Err()).To(BeNil()) && g.Expect(resp.Events).To(HaveLen(keys))
+			})))
+		})
+	}
+}
+
+// TestWatchCatchUp checks that watches catching up on a backlog larger than
+// a page receive all the events, in order.
+func TestWatchCatchUp(t *testing.T) {
+	const keys = 1200
+
+	for _, backendType := range []string{endpoint.SQLiteBackend, endpoint.DQLiteBackend, endpoint.MemoryBackend} {
+		t.Run(backendType, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			g := NewWithT(t)
+
+			kine := newKineServer(ctx, t, &kineOptions{backendType: backendType})
+
+			var startRev int64
+			for i := 0; i < keys; i++ {
+				rev := createKey(ctx, g, kine.client, fmt.Sprintf("catchup/%d", i), "value")
+				if startRev == 0 {
+					startRev = rev
+				}
+			}
+
+			watchCh := kine.client.Watch(ctx, "catchup/", clientv3.WithRev(startRev))
+			nextRev := startRev
+			g.Eventually(func(g Gomega) {
+				g.Expect(watchCh).To(Receive(Satisfy(func(resp clientv3.WatchResponse) bool {
+					for _, event := range resp.Events {
+						if !g.Expect(event.Kv.ModRevision).To(Equal(nextRev)) {
+							return false
+						}
+						nextRev++
+					}
+					return true
+				})))
+				g.Expect(nextRev).To(Equal(startRev + keys))
+			}, 10*time.Second).Should(Succeed())
+		})
+	}
+}
+
 type EventMatcher func(*clientv3.Event) bool
 
 func ReceiveEvents(g Gomega, checks ...EventMatcher) types.GomegaMatcher {
@@ -181,3 +326,65 @@ func DeleteEvent(g Gomega, key, prevValue string, prevRevision, deleteRevision i
 		return ok
 	}
 }
+
+// TestWatchIntrospection checks that the watches and the connections being
+// served are listed.
+func TestWatchIntrospection(t *testing.T) {
+	for _, backendType := range []string{endpoint.SQLiteBackend, endpoint.DQLiteBackend, endpoint.MemoryBackend} {
+		t.Run(backendType, func(t *testing.T) {
+			g := NewWithT(t)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			kine := newKineServer(ctx, t, &kineOptions{backendType: backendType})
+
+			watchCtx, watchCancel := context.WithCancel(ctx)
+			watch := kine.client.Watch(watchCtx, "introspection/", clientv3.WithPrefix(), clientv3.WithRev(1), clientv3.WithCreatedNotify())
+			g.Eventually(watch).Should(Receive())
+
+			watches := kine.server.Watches()
+			g.Expect(watches).To(HaveLen(1))
+			g.Expect(watches[0].Key).To(Equal("introspection/"))
+			g.Expect(watches[0].StartRevision).To(Equal(int64(1)))
+			connections := kine.server.Connections()
+			g.Expect(connections).To(HaveLen(1))
+			g.Expect(connections[0].Requests).To(BeNumerically(">=", 1))
+
+			watchCancel()
+			g.Eventually(kine.server.Watches).Should(BeEmpty())
+		})
+	}
+}
+
+func TestWatchLimits(t *testing.T) {
+	for _, backendType := range []string{endpoint.SQLiteBackend, endpoint.DQLiteBackend, endpoint.MemoryBackend} {
+		t.Run(backendType, func(t *testing.T) {
+			g := NewWithT(t)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			kine := newKineServer(ctx, t, &kineOptions{
+				backendType:   backendType,
+				requestLimits: server.RequestLimits{WatchCreateRate: 0.001, MaxWatchStreams: 1},
+			})
+
+			watch := kine.client.Watch(ctx, "limits/", clientv3.WithPrefix(), clientv3.WithCreatedNotify())
+			g.Eventually(watch).Should(Receive(HaveField("Created", true)))
+
+			// The second watch of the stream exceeds the rate of creations.
+			watch = kine.client.Watch(ctx, "limits/other/", clientv3.WithPrefix(), clientv3.WithCreatedNotify())
+			var resp clientv3.WatchResponse
+			g.Eventually(watch).Should(Receive(&resp))
+			g.Expect(resp.Canceled).To(BeTrue())
+			g.Expect(resp.Err()).To(MatchError(rpctypes.ErrTooManyRequests))
+
+			// A watch requiring the leader opens a second stream.
+			watch = kine.client.Watch(clientv3.WithRequireLeader(ctx), "limits/", clientv3.WithPrefix(), clientv3.WithCreatedNotify())
+			g.Eventually(watch).Should(Receive(&resp))
+			g.Expect(resp.Canceled).To(BeTrue())
+			g.Expect(resp.Err()).To(MatchError(rpctypes.ErrTooManyRequests))
+		})
+	}
+}